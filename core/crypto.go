@@ -0,0 +1,148 @@
+package core
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/nacl/box"
+)
+
+// EncryptedPrefix marks the values sealed with the operator public key
+const EncryptedPrefix = "enc:"
+
+var encryptedValue = regexp.MustCompile(regexp.QuoteMeta(EncryptedPrefix) + `[A-Za-z0-9+/]+={0,2}`)
+
+// GenerateKeyPair returns a new NaCl box key pair, base64 encoded
+func GenerateKeyPair() (publicKey, privateKey string, err error) {
+	public, private, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(public[:]), base64.StdEncoding.EncodeToString(private[:]), nil
+}
+
+// ParseKey decodes a base64 encoded NaCl box key
+func ParseKey(key string) (*[32]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key: %w", err)
+	}
+	if len(data) != 32 {
+		return nil, errors.New("invalid key: it must be 32 bytes long")
+	}
+
+	var k [32]byte
+	copy(k[:], data)
+	return &k, nil
+}
+
+// Seal encrypts the value with the public key, so that only the holder of the private key can read it.
+// Empty values are kept as they are.
+func Seal(value string, publicKey *[32]byte) (string, error) {
+	if value == "" {
+		return value, nil
+	}
+
+	sealed, err := SealData([]byte(value), publicKey)
+	if err != nil {
+		return "", err
+	}
+
+	return EncryptedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts a value sealed with the public key of the private key.
+// Values not sealed are returned as they are. The values sealed more than once, i.e. restored from a campaign
+// bundle, are opened until their content is not a sealed value anymore.
+func Open(value string, privateKey *[32]byte) (string, error) {
+	message, err := open(value, privateKey)
+	if err != nil {
+		return "", err
+	}
+
+	for message != value {
+		value = message
+		if message, err = open(value, privateKey); err != nil {
+			// The content only looks like a sealed value, i.e. a credential starting with the prefix
+			return value, nil
+		}
+	}
+
+	return message, nil
+}
+
+// open decrypts a value sealed once, values not sealed are returned as they are
+func open(value string, privateKey *[32]byte) (string, error) {
+	if len(value) < len(EncryptedPrefix) || value[:len(EncryptedPrefix)] != EncryptedPrefix {
+		return value, nil
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(value[len(EncryptedPrefix):])
+	if err != nil {
+		return "", err
+	}
+
+	message, err := OpenData(sealed, privateKey)
+	if err != nil {
+		return "", err
+	}
+
+	return string(message), nil
+}
+
+// SealData encrypts the data with the public key, so that only the holder of the private key can read it
+func SealData(data []byte, publicKey *[32]byte) ([]byte, error) {
+	return box.SealAnonymous(nil, data, publicKey, rand.Reader)
+}
+
+// OpenData decrypts the data sealed with the public key of the private key
+func OpenData(sealed []byte, privateKey *[32]byte) ([]byte, error) {
+	public, err := curve25519.X25519(privateKey[:], curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+
+	var publicKey [32]byte
+	copy(publicKey[:], public)
+
+	message, ok := box.OpenAnonymous(nil, sealed, &publicKey, privateKey)
+	if !ok {
+		return nil, errors.New("cannot decrypt value: wrong key or corrupted data")
+	}
+
+	return message, nil
+}
+
+// DecryptStream copies r to w, decrypting all the sealed values found
+func DecryptStream(r io.Reader, w io.Writer, privateKey *[32]byte) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), 16<<20)
+
+	for scanner.Scan() {
+		var failed error
+		line := encryptedValue.ReplaceAllStringFunc(scanner.Text(), func(value string) string {
+			plain, err := Open(value, privateKey)
+			if err != nil {
+				failed = err
+				return value
+			}
+			return plain
+		})
+		if failed != nil {
+			return failed
+		}
+
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
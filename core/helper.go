@@ -1,5 +1,17 @@
 package core
 
+import (
+	"crypto/rand"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
+)
+
 type iError interface {
 	IsError() bool
 }
@@ -28,3 +40,101 @@ func StringContains(v string, a []string) bool {
 	}
 	return false
 }
+
+// Shred overwrites the file with random data before removing it,
+// so that its content cannot be recovered from the disk.
+func Shred(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+
+	info, err := f.Stat()
+	if err == nil {
+		_, err = io.CopyN(f, rand.Reader, info.Size())
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Remove(path)
+}
+
+// WriteFileAtomic writes the data to a temporary file, synced to the disk, and renames it to path,
+// so that a crash never leaves a partially written file behind.
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	f, err := ioutil.TempFile(dir, "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp, perm)
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+
+	// Persist the rename, where the directories can be synced
+	if d, err := os.Open(dir); err == nil {
+		_ = d.Sync()
+		d.Close()
+	}
+
+	return nil
+}
+
+// DomainToASCII returns the punycode form of an internationalized domain, i.e. xn--bcher-kva.de for bücher.de.
+// ASCII domains, and the ones that cannot be converted, are returned lowercase as they are.
+func DomainToASCII(domain string) string {
+	domain = strings.ToLower(domain)
+	if isASCII(domain) {
+		return domain
+	}
+
+	ascii, err := idna.Punycode.ToASCII(domain)
+	if err != nil {
+		return domain
+	}
+	return ascii
+}
+
+// DomainToUnicode returns the Unicode form of a punycode domain, i.e. bücher.de for xn--bcher-kva.de.
+// Domains without punycode labels, and the ones that cannot be converted, are returned as they are.
+func DomainToUnicode(domain string) string {
+	if !strings.Contains(domain, "xn--") {
+		return domain
+	}
+
+	unicode, err := idna.Punycode.ToUnicode(domain)
+	if err != nil {
+		return domain
+	}
+	return unicode
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
@@ -11,6 +11,9 @@ type Options struct {
 	Version        *bool
 	NoColors       *bool
 	ConfigFilePath *string
+	Keygen         *bool
+	Decrypt        *string
+	PrintConfig    *bool
 }
 
 func ParseOptions() (Options, error) {
@@ -21,6 +24,9 @@ func ParseOptions() (Options, error) {
 		Proxy:          flag.Bool("proxy", false, "Enable internal proxy."),
 		Version:        flag.Bool("version", false, "Print current version."),
 		NoColors:       flag.Bool("no-colors", false, "Disable output color effects."),
+		Keygen:         flag.Bool("keygen", false, "Generate a key pair to encrypt the captured data and exit."),
+		Decrypt:        flag.String("decrypt", "", "Decrypt the captured data read from stdin with the private key file and exit."),
+		PrintConfig:    flag.Bool("print-config", false, "Print the effective configuration, with the defaults, and exit."),
 	}
 
 	flag.Parse()
@@ -36,5 +42,8 @@ func GetDefaultOptions() Options {
 		Version:        &[]bool{false}[0],
 		NoColors:       &[]bool{false}[0],
 		ConfigFilePath: &[]string{""}[0],
+		Keygen:         &[]bool{false}[0],
+		Decrypt:        &[]string{""}[0],
+		PrintConfig:    &[]bool{false}[0],
 	}
 }
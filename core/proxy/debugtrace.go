@@ -0,0 +1,145 @@
+package proxy
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/muraenateam/muraena/log"
+)
+
+// TraceHeader carries the trace secret, turning on the rewrite trace of a request from any address
+const TraceHeader = "X-Muraena-Trace"
+
+// traceValueSize is the size of the values logged by the rewrite trace, longer ones are truncated
+const traceValueSize = 512
+
+// rewriteTracer logs how a request and its response are rewritten, without raising the log level of the victims
+type rewriteTracer struct {
+	id uint64
+}
+
+// rewriteSnapshot is the URL and the headers of a request or a response before they are rewritten
+type rewriteSnapshot struct {
+	url    string
+	status int
+	header http.Header
+}
+
+type tracerKey struct{}
+
+// tracedRequests numbers the traced requests, to tell their lines apart in the log
+var tracedRequests uint64
+
+// Traced reports if the rewrite of the request is traced: the requests of the operator addresses, if enabled,
+// and the ones carrying the trace secret. The TraceHeader is removed from all the requests.
+func (o *operatorSession) Traced(r *http.Request) bool {
+	secret := r.Header.Get(TraceHeader)
+	r.Header.Del(TraceHeader)
+	if o == nil {
+		return false
+	}
+
+	if o.traceSecret != "" && subtle.ConstantTimeCompare([]byte(secret), []byte(o.traceSecret)) == 1 {
+		return true
+	}
+	return o.trace && o.allows(r)
+}
+
+// withRewriteTracer returns the context of a traced request
+func withRewriteTracer(ctx context.Context) context.Context {
+	return context.WithValue(ctx, tracerKey{}, &rewriteTracer{id: atomic.AddUint64(&tracedRequests, 1)})
+}
+
+// rewriteTracerOf returns the rewrite tracer of the request context, nil if not traced
+func rewriteTracerOf(ctx context.Context) *rewriteTracer {
+	t, _ := ctx.Value(tracerKey{}).(*rewriteTracer)
+	return t
+}
+
+// Snapshot returns the URL, status and headers before they are rewritten, nil if not traced
+func (t *rewriteTracer) Snapshot(url string, status int, header http.Header) *rewriteSnapshot {
+	if t == nil {
+		return nil
+	}
+	return &rewriteSnapshot{url: url, status: status, header: header.Clone()}
+}
+
+// Compare logs the changes of the URL, status and headers since the snapshot
+func (t *rewriteTracer) Compare(stage string, before *rewriteSnapshot, url string, status int, header http.Header) {
+	if t == nil || before == nil {
+		return
+	}
+
+	if before.url != url {
+		t.logf("%s URL: %s > %s", stage, truncateTrace(before.url), truncateTrace(url))
+	}
+	if before.status != status {
+		t.logf("%s status: %d > %d", stage, before.status, status)
+	}
+
+	names := map[string]bool{}
+	for name := range before.header {
+		names[name] = true
+	}
+	for name := range header {
+		names[name] = true
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	for _, name := range sorted {
+		old, new := before.header[name], header[name]
+		switch {
+		case len(old) == 0:
+			t.logf("%s header %s added: %s", stage, name, truncateTrace(strings.Join(new, ", ")))
+		case len(new) == 0:
+			t.logf("%s header %s removed: %s", stage, name, truncateTrace(strings.Join(old, ", ")))
+		case strings.Join(old, "\n") != strings.Join(new, "\n"):
+			t.logf("%s header %s: %s > %s", stage, name, truncateTrace(strings.Join(old, ", ")), truncateTrace(strings.Join(new, ", ")))
+		}
+	}
+}
+
+// Body logs the size of a body before and after its rewrite, and the replacements of the rules found in the
+// original one
+func (t *rewriteTracer) Body(stage string, r *Replacer, direction Direction, contentType string, original []byte, size int) {
+	if t == nil {
+		return
+	}
+
+	_, traces := traceReplacements(r, string(original), direction)
+	t.logf("%s body %s: %d > %d bytes, %d replacements", stage, contentType, len(original), size, len(traces))
+
+	counts := map[int]int{}
+	var rules []rewriteTrace
+	for _, tr := range traces {
+		if counts[tr.Rule] == 0 {
+			rules = append(rules, tr)
+		}
+		counts[tr.Rule]++
+	}
+	for _, tr := range rules {
+		t.logf("%s body   %s > %s (%d, first at %d)", stage, tr.Old, tr.New, counts[tr.Rule], tr.Offset)
+	}
+}
+
+// logf logs a line of the trace, at the info level to be shown without the debug logging
+func (t *rewriteTracer) logf(format string, args ...interface{}) {
+	args = append([]interface{}{t.id}, args...)
+	log.Info("[trace %d] "+format, args...)
+}
+
+// truncateTrace shortens the long values, i.e. the cookies, logged by the trace
+func truncateTrace(value string) string {
+	if len(value) <= traceValueSize {
+		return value
+	}
+	return value[:traceValueSize] + "..."
+}
@@ -0,0 +1,85 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/muraenateam/muraena/session"
+)
+
+func TestOperatorTraced(t *testing.T) {
+	var disabled *operatorSession
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(TraceHeader, "s3cr3t")
+	if disabled.Traced(r) || r.Header.Get(TraceHeader) != "" {
+		t.Errorf("expected the trace header to be removed without tracing the request")
+	}
+
+	sess := &session.Session{Config: &session.Configuration{}}
+	sess.Config.Operator.Enabled = true
+	sess.Config.Operator.Allow = []string{"203.0.113.10"}
+	sess.Config.Operator.Trace.Secret = "s3cr3t"
+	o, err := newOperatorSession(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		trace          bool
+		remote, secret string
+		traced         bool
+	}{
+		{false, "203.0.113.10:4242", "", false},
+		{true, "203.0.113.10:4242", "", true},
+		{false, "198.51.100.1:4242", "s3cr3t", true},
+		{true, "198.51.100.1:4242", "guess", false},
+	} {
+		o.trace = c.trace
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = c.remote
+		if c.secret != "" {
+			r.Header.Set(TraceHeader, c.secret)
+		}
+
+		if traced := o.Traced(r); traced != c.traced {
+			t.Errorf("%s with %q, trace %v: expected traced %v", c.remote, c.secret, c.trace, c.traced)
+		}
+		if r.Header.Get(TraceHeader) != "" {
+			t.Errorf("expected the trace header to be removed")
+		}
+	}
+}
+
+func TestRewriteTracer(t *testing.T) {
+	if tracer := rewriteTracerOf(context.Background()); tracer != nil {
+		t.Fatal("expected the requests not to be traced by default")
+	}
+
+	// The untraced requests are left alone
+	var untraced *rewriteTracer
+	if untraced.Snapshot("/", 0, http.Header{}) != nil {
+		t.Error("expected no snapshot of an untraced request")
+	}
+	untraced.Compare("request", nil, "/", 0, http.Header{})
+	untraced.Body("request", nil, Forward, "text/plain", nil, 0)
+
+	first := rewriteTracerOf(withRewriteTracer(context.Background()))
+	second := rewriteTracerOf(withRewriteTracer(context.Background()))
+	if first == nil || second == nil || first.id == second.id {
+		t.Fatalf("expected the traced requests to be numbered, got %v and %v", first, second)
+	}
+
+	header := http.Header{"Referer": []string{"https://phishing.click/"}}
+	before := first.Snapshot("phishing.click/", 0, header)
+	header.Set("Referer", "https://poor.victim/")
+	if before.header.Get("Referer") != "https://phishing.click/" {
+		t.Error("expected the snapshot to hold the headers before the rewrite")
+	}
+
+	r := &Replacer{Phishing: "phishing.click", Target: "poor.victim"}
+	r.MakeReplacements()
+	first.Compare("request", before, "poor.victim/", 0, header)
+	first.Body("request", r, Forward, "text/plain", []byte("user=victim@phishing.click"), 23)
+}
@@ -0,0 +1,180 @@
+package proxy
+
+import (
+	"expvar"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"path/filepath"
+	"runtime"
+	runtimepprof "runtime/pprof"
+	"sync"
+	"time"
+
+	"github.com/muraenateam/muraena/log"
+	"github.com/muraenateam/muraena/session"
+)
+
+var publishRuntime sync.Once
+
+// runtimeReport is a snapshot of the memory used by the instance
+type runtimeReport struct {
+	HeapAlloc  uint64 `json:"heapAlloc"`
+	HeapSys    uint64 `json:"heapSys"`
+	NumGC      uint32 `json:"numGC"`
+	Goroutines int    `json:"goroutines"`
+	// Replacer is the estimated size of the replacement tables and of the compiled matchers
+	Replacer int `json:"replacer"`
+	Origins  int `json:"origins"`
+	// Rewrites is the number of rewritten bodies held by the rewrite cache
+	Rewrites int `json:"rewrites"`
+}
+
+// newRuntimeReport returns the current memory usage
+func newRuntimeReport() runtimeReport {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+
+	report := runtimeReport{
+		HeapAlloc:  m.HeapAlloc,
+		HeapSys:    m.HeapSys,
+		NumGC:      m.NumGC,
+		Goroutines: runtime.NumGoroutine(),
+	}
+
+	if replacer != nil {
+		report.Replacer = replacer.Footprint()
+		report.Origins = len(replacer.GetOrigins())
+	}
+
+	if rewrites != nil {
+		report.Rewrites = rewrites.entries.Len()
+	}
+
+	return report
+}
+
+func (r runtimeReport) String() string {
+	return fmt.Sprintf("heap %s (%s reserved), %d goroutines, %d GCs, replacer %s for %d origins, %d cached rewrites",
+		formatBytes(r.HeapAlloc), formatBytes(r.HeapSys), r.Goroutines, r.NumGC,
+		formatBytes(uint64(r.Replacer)), r.Origins, r.Rewrites)
+}
+
+// formatBytes returns the size in a human readable unit
+func formatBytes(size uint64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+
+	div, exp := uint64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// reportRuntime periodically logs the memory used, to spot a growth before the host runs out of memory
+func reportRuntime(interval time.Duration) {
+	for range time.Tick(interval) {
+		log.Info("Runtime: %s", newRuntimeReport())
+	}
+}
+
+// dumpProfiles writes the goroutine and heap dumps to dir, returning the files written
+func dumpProfiles(dir string) ([]string, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	stamp := time.Now().UTC().Format("20060102T150405")
+
+	var files []string
+	for _, p := range []struct {
+		name  string
+		debug int
+	}{
+		// the goroutine stacks are dumped as text, the heap in the pprof format
+		{"goroutine", 2},
+		{"heap", 0},
+	} {
+		file := filepath.Join(dir, fmt.Sprintf("%s-%s.pprof", p.name, stamp))
+		if p.debug > 0 {
+			file = filepath.Join(dir, fmt.Sprintf("%s-%s.txt", p.name, stamp))
+		}
+
+		f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+		if err != nil {
+			return files, err
+		}
+
+		err = runtimepprof.Lookup(p.name).WriteTo(f, p.debug)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return files, err
+		}
+
+		files = append(files, file)
+	}
+
+	return files, nil
+}
+
+// diagnosticsHandler returns the handler of the diagnostics endpoint:
+// the pprof profiles under /debug/pprof/, the expvar variables at /debug/vars,
+// the memory report at /debug/runtime and POST /debug/dump writing the goroutine and heap dumps on disk.
+func diagnosticsHandler(dumpDir string) http.Handler {
+	publishRuntime.Do(func() {
+		expvar.Publish("runtime", expvar.Func(func() interface{} { return newRuntimeReport() }))
+	})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+
+	mux.HandleFunc("/debug/runtime", func(w http.ResponseWriter, r *http.Request) {
+		if !allowMethods(w, r, http.MethodGet, http.MethodHead) {
+			return
+		}
+
+		writeJSON(w, newRuntimeReport())
+	})
+
+	mux.HandleFunc("/debug/dump", func(w http.ResponseWriter, r *http.Request) {
+		if !allowMethods(w, r, http.MethodPost) {
+			return
+		}
+
+		files, err := dumpProfiles(dumpDir)
+		if err != nil {
+			log.Error("Diagnostics: error writing the dumps: %s", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		log.Info("Diagnostics: dumped %v", files)
+		writeJSON(w, map[string][]string{"files": files})
+	})
+
+	return mux
+}
+
+// serveDiagnostics starts the runtime diagnostics endpoint and the periodic memory reports
+func serveDiagnostics(sess *session.Session) {
+	config := sess.Config.Diagnostics
+	if config.Listen == "" {
+		return
+	}
+
+	go reportRuntime(time.Duration(config.Report) * time.Minute)
+
+	serveAdmin(sess, "Diagnostics", config.Listen, config.Token, diagnosticsHandler(config.DumpDir))
+}
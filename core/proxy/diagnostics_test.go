@@ -0,0 +1,68 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestReplacerFootprint(t *testing.T) {
+	r := &Replacer{Origins: map[string]string{"cdn.target.tld": "ext1"}}
+	r.ForwardReplacements = []string{"phishing.tld", "target.tld"}
+
+	size := r.Footprint()
+	if size != len("cdn.target.tld")+len("ext1")+len("phishing.tld")+len("target.tld") {
+		t.Errorf("unexpected footprint %d", size)
+	}
+
+	r.getMatcher(matcherKind{forward: true})
+	if r.Footprint() <= size {
+		t.Errorf("expected the compiled matcher to be accounted")
+	}
+}
+
+func TestDiagnosticsHandler(t *testing.T) {
+	dir := t.TempDir()
+	h := diagnosticsHandler(dir)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/vars", nil))
+	var vars map[string]json.RawMessage
+	if err := json.Unmarshal(rec.Body.Bytes(), &vars); err != nil || vars["runtime"] == nil || vars["memstats"] == nil {
+		t.Errorf("unexpected expvar variables %s", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/pprof/goroutine?debug=1", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("pprof: got status %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/dump", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("dump: got status %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/debug/dump", nil))
+	var dump struct{ Files []string }
+	if err := json.Unmarshal(rec.Body.Bytes(), &dump); err != nil || len(dump.Files) != 2 {
+		t.Fatalf("unexpected dump %s", rec.Body.String())
+	}
+	for _, f := range dump.Files {
+		if info, err := os.Stat(f); err != nil || info.Size() == 0 {
+			t.Errorf("expected the dump %s to be written: %v", f, err)
+		}
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	for size, want := range map[uint64]string{512: "512 B", 1536: "1.5 KiB", 3 << 30: "3.0 GiB"} {
+		if got := formatBytes(size); got != want {
+			t.Errorf("formatBytes(%d) = %s, want %s", size, got, want)
+		}
+	}
+}
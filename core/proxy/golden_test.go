@@ -0,0 +1,38 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/muraenateam/muraena/core"
+	"github.com/muraenateam/muraena/session"
+)
+
+// TestGoldenFiles validates the transformation of the saved messages of testdata/golden.
+// Run muraena test-transform -config testdata/golden/config.toml -update testdata/golden to regenerate them.
+func TestGoldenFiles(t *testing.T) {
+	sess := &session.Session{Options: core.GetDefaultOptions()}
+	*sess.Options.ConfigFilePath = "testdata/golden/config.toml"
+	if err := sess.GetConfiguration(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewStandaloneReplacer(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { protected, embedded = nil, nil }()
+
+	results, err := CheckGoldenFiles(sess, r, "testdata/golden", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) == 0 {
+		t.Fatal("no golden files")
+	}
+
+	for _, result := range results {
+		if result.Err != nil {
+			t.Errorf("%s: %s", result.Name, result.Err)
+		}
+	}
+}
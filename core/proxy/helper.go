@@ -15,7 +15,9 @@ import (
 	ll "github.com/evilsocket/islazy/log"
 	"github.com/evilsocket/islazy/tui"
 
+	"github.com/muraenateam/muraena/core"
 	"github.com/muraenateam/muraena/log"
+	"github.com/muraenateam/muraena/session"
 )
 
 type Response struct {
@@ -117,34 +119,60 @@ func packDeflate(i []byte) ([]byte, error) {
 	return b.Bytes(), nil
 }
 
-// ArmorDomain filters duplicate strings in place and returns a slice with
-// only unique strings.
+// ArmorDomain normalizes the domains and returns a slice with only unique domains.
+// Internationalized domains are converted to punycode, so that their Unicode and ASCII forms are the same origin.
 func ArmorDomain(slice []string) []string {
 	keys := make(map[string]bool)
 	var list []string
 	for _, entry := range slice {
-		if _, value := keys[entry]; !value {
-			keys[entry] = true
-
-			// make it lowercase
-			entry = strings.ToLower(entry)
+		// make it lowercase
+		entry = strings.ToLower(entry)
+
+		// if string begins with a protocol, remove it
+		if strings.HasPrefix(entry, "http://") {
+			entry = strings.TrimPrefix(entry, "http://")
+		} else if strings.HasPrefix(entry, "https://") {
+			entry = strings.TrimPrefix(entry, "https://")
+		}
 
-			// if string begins with a protocol, remove it
-			if strings.HasPrefix(entry, "http://") {
-				entry = strings.TrimPrefix(entry, "http://")
-			} else if strings.HasPrefix(entry, "https://") {
-				entry = strings.TrimPrefix(entry, "https://")
-			}
+		// remove everything after the / (if any)
+		entry = strings.Split(entry, "/")[0]
 
-			// remove everything after the / (if any)
-			entry = strings.Split(entry, "/")[0]
+		entry = core.DomainToASCII(entry)
 
+		if !keys[entry] {
+			keys[entry] = true
 			list = append(list, entry)
 		}
 	}
 	return list
 }
 
+// isRewritable checks if a response with the given Content-Type has to be transformed,
+// i.e. if it does not match any of the SkipContentType rules.
+func isRewritable(sess *session.Session, contentType string) bool {
+	return !matchContentType(contentType, sess.Config.Transform.Response.SkipContentType)
+}
+
+// matchContentType checks if the Content-Type matches any of the media types, i.e. text/html or image/*
+func matchContentType(contentType string, mediaTypes []string) bool {
+	mediaType := strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	for _, t := range mediaTypes {
+		t = strings.ToLower(t)
+
+		if mediaType == t {
+			return true
+		}
+
+		if strings.HasSuffix(t, "/*") &&
+			strings.Split(mediaType, "/")[0] == strings.Split(t, "/*")[0] {
+			return true
+		}
+	}
+
+	return false
+}
+
 func isWildcard(s string) bool {
 	return strings.HasPrefix(s, "*.")
 }
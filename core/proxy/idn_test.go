@@ -0,0 +1,42 @@
+package proxy
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestArmorDomainIDN(t *testing.T) {
+	got := ArmorDomain([]string{"https://Bücher.de/path", "xn--bcher-kva.de", "*.bücher.de", "cdn.example.com"})
+	want := []string{"xn--bcher-kva.de", "*.xn--bcher-kva.de", "cdn.example.com"}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestTransformIDN(t *testing.T) {
+	r := &Replacer{Phishing: "xn--phshing-pza.tld", Target: "xn--bcher-kva.de", ExternalOriginPrefix: "ext"}
+	r.ExternalOrigin = ArmorDomain([]string{"static.müller.de"})
+	if err := r.DomainMapping(); err != nil {
+		t.Fatal(err)
+	}
+	r.MakeReplacements()
+
+	for _, tc := range []struct {
+		upstream string
+		phishing string
+	}{
+		{"https://www.xn--bcher-kva.de/login", "https://www.xn--phshing-pza.tld/login"},
+		{"Welcome to www.bücher.de!", "Welcome to www.phïshing.tld!"},
+		{"https://static.müller.de/app.js", "https://ext1.xn--phshing-pza.tld/app.js"},
+		{"https://static.xn--mller-kva.de/app.js", "https://ext1.xn--phshing-pza.tld/app.js"},
+	} {
+		if got := r.Transform(tc.upstream, false, Base64{}); got != tc.phishing {
+			t.Errorf("backward %s: got %s, want %s", tc.upstream, got, tc.phishing)
+		}
+	}
+
+	if got := r.Transform("https://www.phïshing.tld/", true, Base64{}); got != "https://www.bücher.de/" {
+		t.Errorf("forward: got %s", got)
+	}
+}
@@ -0,0 +1,326 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/muraenateam/muraena/session"
+)
+
+// jsonStep is a single step of a JSON selector
+type jsonStep struct {
+	// key is the object member name, empty for array indexes and wildcards
+	key string
+	// index is the array index, -1 if unused
+	index int
+	// wildcard matches any member or array element
+	wildcard bool
+	// recursive matches the step at any depth (..)
+	recursive bool
+}
+
+// jsonSelector is a compiled JSONPath-like selector, supporting:
+// $ (root), .name, ['name'], [n], [*], .* and ..name (recursive descent)
+type jsonSelector []jsonStep
+
+// jsonRule is a compiled session.JSONRule
+type jsonRule struct {
+	path      *regexp.Regexp
+	exactPath string
+	request   bool
+	response  bool
+	selectors []jsonSelector
+}
+
+// jsonRules are the JSON transformation rules shared by all the proxies
+var jsonRules []*jsonRule
+
+// newJSONRules compiles the JSON transformation rules defined in the configuration
+func newJSONRules(sess *session.Session) (rules []*jsonRule, err error) {
+	for _, r := range sess.Config.Transform.JSON {
+		rule := &jsonRule{exactPath: r.Path}
+		if strings.HasPrefix(r.Path, "^") && strings.HasSuffix(r.Path, "$") {
+			if rule.path, err = regexp.Compile(r.Path); err != nil {
+				return nil, fmt.Errorf("invalid JSON rule path %s: %w", r.Path, err)
+			}
+		}
+
+		switch strings.ToLower(r.Direction) {
+		case "request":
+			rule.request = true
+		case "response":
+			rule.response = true
+		case "", "both":
+			rule.request, rule.response = true, true
+		default:
+			return nil, fmt.Errorf("invalid JSON rule direction %s", r.Direction)
+		}
+
+		for _, s := range r.Selectors {
+			selector, err := parseJSONSelector(s)
+			if err != nil {
+				return nil, err
+			}
+			rule.selectors = append(rule.selectors, selector)
+		}
+
+		rules = append(rules, rule)
+	}
+
+	return
+}
+
+// matchJSONRule returns the rule bound to the URL path and direction, nil if none
+func matchJSONRule(rules []*jsonRule, path string, forward bool) *jsonRule {
+	for _, rule := range rules {
+		if (forward && !rule.request) || (!forward && !rule.response) {
+			continue
+		}
+
+		if rule.path != nil {
+			if rule.path.MatchString(path) {
+				return rule
+			}
+		} else if rule.exactPath == path {
+			return rule
+		}
+	}
+
+	return nil
+}
+
+// isJSON checks if the Content-Type is application/json or any +json media type
+func isJSON(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// parseJSONSelector compiles a selector such as $.data.items[*].url
+func parseJSONSelector(s string) (selector jsonSelector, err error) {
+	invalid := func() (jsonSelector, error) {
+		return nil, fmt.Errorf("invalid JSON selector %s", s)
+	}
+
+	rest := strings.TrimSpace(s)
+	if !strings.HasPrefix(rest, "$") {
+		return invalid()
+	}
+	rest = rest[1:]
+
+	for len(rest) > 0 {
+		step := jsonStep{index: -1}
+
+		switch {
+		case strings.HasPrefix(rest, ".."):
+			step.recursive = true
+			rest = rest[2:]
+		case strings.HasPrefix(rest, "."):
+			rest = rest[1:]
+		case strings.HasPrefix(rest, "["):
+		default:
+			return invalid()
+		}
+
+		switch {
+		case strings.HasPrefix(rest, "["):
+			end := strings.Index(rest, "]")
+			if end == -1 {
+				return invalid()
+			}
+
+			inner := strings.TrimSpace(rest[1:end])
+			rest = rest[end+1:]
+
+			if inner == "*" {
+				step.wildcard = true
+			} else if len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0] {
+				step.key = inner[1 : len(inner)-1]
+			} else if step.index, err = strconv.Atoi(inner); err != nil || step.index < 0 {
+				return invalid()
+			}
+
+		case strings.HasPrefix(rest, "*"):
+			step.wildcard = true
+			rest = rest[1:]
+
+		default:
+			end := strings.IndexAny(rest, ".[")
+			if end == -1 {
+				end = len(rest)
+			}
+			if end == 0 {
+				return invalid()
+			}
+			step.key = rest[:end]
+			rest = rest[end:]
+		}
+
+		selector = append(selector, step)
+	}
+
+	return
+}
+
+// matches checks if the step matches a path segment, either a string key or an int index
+func (step jsonStep) matches(segment interface{}) bool {
+	if step.wildcard {
+		return true
+	}
+
+	switch v := segment.(type) {
+	case string:
+		return step.index == -1 && step.key == v
+	case int:
+		return step.index == v
+	}
+
+	return false
+}
+
+// Matches checks if the path, or any of its ancestors, is selected
+func (selector jsonSelector) Matches(path []interface{}) bool {
+	if len(selector) == 0 {
+		return true
+	}
+	if len(path) == 0 {
+		return false
+	}
+
+	step := selector[0]
+	if step.matches(path[0]) && selector[1:].Matches(path[1:]) {
+		return true
+	}
+
+	// Recursive descent: skip the current segment and look for the step deeper
+	return step.recursive && selector.Matches(path[1:])
+}
+
+// jsonFrame tracks the position within a JSON container while tokenizing
+type jsonFrame struct {
+	array     bool
+	index     int
+	key       string
+	expectKey bool
+}
+
+// transformJSON applies transform to the string values selected by the rule, leaving the rest of the document,
+// including formatting, member order and escaping, byte-for-byte untouched.
+func transformJSON(body []byte, rule *jsonRule, transform func(string) string) ([]byte, error) {
+	type patch struct {
+		start, end int
+		value      []byte
+	}
+
+	var patches []patch
+	var stack []*jsonFrame
+
+	path := func() []interface{} {
+		p := make([]interface{}, 0, len(stack))
+		for _, f := range stack {
+			if f.array {
+				p = append(p, f.index)
+			} else {
+				p = append(p, f.key)
+			}
+		}
+		return p
+	}
+
+	// advance moves the parent container to its next element once a value is complete
+	advance := func() {
+		if len(stack) == 0 {
+			return
+		}
+		top := stack[len(stack)-1]
+		if top.array {
+			top.index++
+		} else {
+			top.expectKey = true
+		}
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+
+	for {
+		offset := int(decoder.InputOffset())
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case json.Delim:
+			switch t {
+			case '{':
+				stack = append(stack, &jsonFrame{expectKey: true})
+			case '[':
+				stack = append(stack, &jsonFrame{array: true})
+			default:
+				stack = stack[:len(stack)-1]
+				advance()
+			}
+
+		case string:
+			if len(stack) > 0 && !stack[len(stack)-1].array && stack[len(stack)-1].expectKey {
+				stack[len(stack)-1].key = t
+				stack[len(stack)-1].expectKey = false
+				continue
+			}
+
+			current := path()
+			for _, selector := range rule.selectors {
+				if !selector.Matches(current) {
+					continue
+				}
+
+				if value := transform(t); value != t {
+					var buf bytes.Buffer
+					encoder := json.NewEncoder(&buf)
+					encoder.SetEscapeHTML(false)
+					if err := encoder.Encode(value); err != nil {
+						return nil, err
+					}
+
+					// The string token starts at the first quote after the previous token and its separators
+					end := int(decoder.InputOffset())
+					start := offset + bytes.IndexByte(body[offset:end], '"')
+					patches = append(patches, patch{start, end, bytes.TrimRight(buf.Bytes(), "\n")})
+				}
+				break
+			}
+			advance()
+
+		default:
+			advance()
+		}
+	}
+
+	if len(patches) == 0 {
+		return body, nil
+	}
+
+	var out bytes.Buffer
+	last := 0
+	for _, p := range patches {
+		out.Write(body[last:p.start])
+		out.Write(p.value)
+		last = p.end
+	}
+	out.Write(body[last:])
+
+	return out.Bytes(), nil
+}
@@ -0,0 +1,124 @@
+package proxy
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/muraenateam/muraena/session"
+)
+
+func TestParseJSONSelector(t *testing.T) {
+	valid := []string{"$", "$.a", "$.a.b", "$['a b'].c", "$.a[0]", "$.a[*].b", "$..url", "$.*", "$..*"}
+	for _, s := range valid {
+		if _, err := parseJSONSelector(s); err != nil {
+			t.Errorf("parseJSONSelector(%q): %s", s, err)
+		}
+	}
+
+	invalid := []string{"", "a.b", "$.", "$.a[", "$.a[-1]", "$.a[x]", "$a"}
+	for _, s := range invalid {
+		if _, err := parseJSONSelector(s); err == nil {
+			t.Errorf("parseJSONSelector(%q): expected an error", s)
+		}
+	}
+}
+
+func TestTransformJSON(t *testing.T) {
+	body := `{
+  "redirect": "https://poor.victim/home",
+  "signature": "c2lnbmVkIGJ5IHBvb3IudmljdGlt:poor.victim",
+  "links": [{"href": "https://poor.victim/a", "rel": "poor.victim"}, {"href": "https://cdn.poor.victim/b"}],
+  "nested": {"deep": {"url": "https://poor.victim/c"}},
+  "html": "<a href=\"https://poor.victim\">&</a>",
+  "n": 1.50
+}`
+
+	tests := []struct {
+		selectors []string
+		want      []string
+		untouched []string
+	}{
+		{
+			[]string{"$.redirect"},
+			[]string{`"redirect": "https://phishing.click/home"`},
+			[]string{`"signature": "c2lnbmVkIGJ5IHBvb3IudmljdGlt:poor.victim"`, `"n": 1.50`},
+		},
+		{
+			[]string{"$.links[*].href"},
+			[]string{`{"href": "https://phishing.click/a", "rel": "poor.victim"}`, `{"href": "https://cdn.phishing.click/b"}`},
+			[]string{`"redirect": "https://poor.victim/home"`},
+		},
+		{
+			[]string{"$..url", "$.links[1]"},
+			[]string{`{"url": "https://phishing.click/c"}`, `{"href": "https://cdn.phishing.click/b"}`},
+			[]string{`{"href": "https://poor.victim/a", "rel": "poor.victim"}`},
+		},
+		{
+			[]string{"$['html']"},
+			[]string{`"html": "<a href=\"https://phishing.click\">&</a>"`},
+			nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.selectors, ","), func(t *testing.T) {
+			sess := &session.Session{Config: &session.Configuration{}}
+			sess.Config.Transform.JSON = []session.JSONRule{{Path: "/api", Selectors: tt.selectors}}
+
+			rules, err := newJSONRules(sess)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rule := matchJSONRule(rules, "/api", false)
+			if rule == nil {
+				t.Fatal("expected the rule to match")
+			}
+
+			out, err := transformJSON([]byte(body), rule, func(s string) string {
+				return strings.ReplaceAll(s, "poor.victim", "phishing.click")
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, w := range tt.want {
+				if !strings.Contains(string(out), w) {
+					t.Errorf("expected %s in\n%s", w, out)
+				}
+			}
+			for _, u := range tt.untouched {
+				if !strings.Contains(string(out), u) {
+					t.Errorf("expected %s to be untouched in\n%s", u, out)
+				}
+			}
+		})
+	}
+}
+
+func TestMatchJSONRule(t *testing.T) {
+	sess := &session.Session{Config: &session.Configuration{}}
+	sess.Config.Transform.JSON = []session.JSONRule{
+		{Path: "^/api/v[0-9]+/config$", Direction: "response", Selectors: []string{"$"}},
+		{Path: "/login", Direction: "request", Selectors: []string{"$.redirect"}},
+	}
+
+	rules, err := newJSONRules(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if matchJSONRule(rules, "/api/v2/config", false) == nil {
+		t.Error("expected the regular expression path to match")
+	}
+	if matchJSONRule(rules, "/api/v2/config", true) != nil {
+		t.Error("expected the response rule not to match requests")
+	}
+	if matchJSONRule(rules, "/login", true) == nil || matchJSONRule(rules, "/login/", true) != nil {
+		t.Error("expected the exact path to match")
+	}
+
+	if !isJSON("application/json; charset=utf-8") || !isJSON("application/vnd.api+json") || isJSON("text/html") {
+		t.Error("unexpected isJSON result")
+	}
+}
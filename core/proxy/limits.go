@@ -0,0 +1,190 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/muraenateam/muraena/log"
+	"github.com/muraenateam/muraena/module/watchdog"
+	"github.com/muraenateam/muraena/session"
+)
+
+var errTooManyConnections = errors.New("too many connections")
+
+// offenders reports the clients exceeding the limits of the victim-facing listener,
+// blocking them through the watchdog if enabled
+type offenders struct {
+	sess *session.Session
+
+	mu      sync.Mutex
+	blocked map[string]bool
+}
+
+func newOffenders(sess *session.Session) *offenders {
+	return &offenders{sess: sess, blocked: make(map[string]bool)}
+}
+
+// report logs the offense and blocks the IP address, once
+func (o *offenders) report(ip, reason string) {
+	log.Warning("[%s] %s", anonymizer.Anonymize(ip), reason)
+
+	if !o.sess.Config.Proxy.Limits.BlockOffenders || !o.sess.Config.Watchdog.Enabled {
+		return
+	}
+
+	o.mu.Lock()
+	blocked := o.blocked[ip]
+	o.blocked[ip] = true
+	o.mu.Unlock()
+	if blocked {
+		return
+	}
+
+	m, err := o.sess.Module("watchdog")
+	if err != nil {
+		log.Error("%s", err)
+		return
+	}
+	if wd, ok := m.(*watchdog.Watchdog); ok {
+		wd.Block(net.ParseIP(ip), reason)
+	}
+}
+
+// connectionLimitListener limits the concurrent connections of each client IP address.
+// Connections over the limit are closed before reading the request.
+type connectionLimitListener struct {
+	net.Listener
+	max       int
+	offenders *offenders
+
+	mu    sync.Mutex
+	count map[string]int
+}
+
+func newConnectionLimitListener(ln net.Listener, max int, o *offenders) *connectionLimitListener {
+	return &connectionLimitListener{Listener: ln, max: max, offenders: o, count: make(map[string]int)}
+}
+
+// Accept implements the net.Listener interface.
+// The client is counted by the connection on first use, as with the PROXY protocol
+// its address is only known once the header has been read.
+func (l *connectionLimitListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	return &limitedConn{Conn: conn, listener: l}, nil
+}
+
+// acquire counts a connection of the IP address, returning false if over the limit
+func (l *connectionLimitListener) acquire(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.count[ip] >= l.max {
+		return false
+	}
+	l.count[ip]++
+	return true
+}
+
+func (l *connectionLimitListener) release(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.count[ip]--; l.count[ip] <= 0 {
+		delete(l.count, ip)
+	}
+}
+
+type limitedConn struct {
+	net.Conn
+	listener *connectionLimitListener
+
+	once     sync.Once
+	ip       string
+	acquired bool
+	closed   sync.Once
+}
+
+// init counts the connection of the client
+func (c *limitedConn) init() {
+	c.once.Do(func() {
+		c.ip = c.RemoteAddr().String()
+		if host, _, err := net.SplitHostPort(c.ip); err == nil {
+			c.ip = host
+		}
+
+		if c.acquired = c.listener.acquire(c.ip); !c.acquired {
+			c.listener.offenders.report(c.ip, fmt.Sprintf("exceeded %d concurrent connections", c.listener.max))
+		}
+	})
+}
+
+// Read implements the net.Conn interface
+func (c *limitedConn) Read(b []byte) (int, error) {
+	c.init()
+	if !c.acquired {
+		c.Close()
+		return 0, errTooManyConnections
+	}
+
+	return c.Conn.Read(b)
+}
+
+// Close implements the net.Conn interface.
+// A connection closed before its first read is never counted, one being counted is waited for.
+func (c *limitedConn) Close() error {
+	c.once.Do(func() {})
+	c.closed.Do(func() {
+		if c.acquired {
+			c.listener.release(c.ip)
+		}
+	})
+	return c.Conn.Close()
+}
+
+// limitBody rejects the requests whose body exceeds the limit, reporting the client.
+// Bodies of unknown length are cut at the limit, failing the upstream request.
+func limitBody(w http.ResponseWriter, r *http.Request, max int64, o *offenders) bool {
+	if max <= 0 || r.Body == nil || r.Body == http.NoBody {
+		return true
+	}
+
+	if r.ContentLength > max {
+		o.report(GetSenderIP(r), fmt.Sprintf("request body of %d bytes exceeds %d bytes", r.ContentLength, max))
+		http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+		return false
+	}
+
+	r.Body = &limitedBody{ReadCloser: http.MaxBytesReader(w, r.Body, max), ip: GetSenderIP(r), max: max, offenders: o}
+	return true
+}
+
+// limitedBody reports the client once its body exceeds the limit
+type limitedBody struct {
+	io.ReadCloser
+	ip        string
+	max       int64
+	offenders *offenders
+	once      sync.Once
+}
+
+// Read implements the io.Reader interface
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		b.once.Do(func() {
+			b.offenders.report(b.ip, fmt.Sprintf("request body exceeds %d bytes", b.max))
+		})
+	}
+
+	return n, err
+}
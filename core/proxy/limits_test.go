@@ -0,0 +1,138 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestConnectionLimitListener(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	limited := newConnectionLimitListener(ln, 1, newOffenders(newTransportSession()))
+	defer limited.Close()
+
+	accept := func() net.Conn {
+		client, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { client.Close() })
+
+		conn, err := limited.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := client.Write([]byte("x")); err != nil {
+			t.Fatal(err)
+		}
+		return conn
+	}
+
+	buf := make([]byte, 1)
+	first := accept()
+	if _, err := first.Read(buf); err != nil {
+		t.Fatalf("first connection: %v", err)
+	}
+
+	second := accept()
+	if _, err := second.Read(buf); err != errTooManyConnections {
+		t.Fatalf("second connection: got %v, want %v", err, errTooManyConnections)
+	}
+
+	// Closing the first connection frees the slot
+	first.Close()
+	third := accept()
+	if _, err := third.Read(buf); err != nil {
+		t.Fatalf("third connection: %v", err)
+	}
+	third.Close()
+
+	if len(limited.count) != 0 {
+		t.Errorf("connections still counted: %v", limited.count)
+	}
+}
+
+func TestConnectionLimitListener_Close(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	limited := newConnectionLimitListener(ln, 1, newOffenders(newTransportSession()))
+	defer limited.Close()
+
+	for i := 0; i < 50; i++ {
+		client, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn, err := limited.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The server closes the connection while reading it, or before the first read
+		done := make(chan struct{})
+		go func() {
+			_, _ = conn.Read(make([]byte, 1))
+			close(done)
+		}()
+		if i%2 == 0 {
+			_, _ = client.Write([]byte("x"))
+		}
+		conn.Close()
+		<-done
+		client.Close()
+	}
+
+	limited.mu.Lock()
+	defer limited.mu.Unlock()
+	if len(limited.count) != 0 {
+		t.Errorf("connections still counted: %v", limited.count)
+	}
+}
+
+func TestLimitBody(t *testing.T) {
+	o := newOffenders(newTransportSession())
+
+	for _, tc := range []struct {
+		name    string
+		body    string
+		chunked bool
+		allowed bool
+		read    bool
+	}{
+		{"under the limit", "short", false, true, true},
+		{"declared over the limit", "a long request body", false, false, false},
+		{"chunked over the limit", "a long request body", true, true, false},
+	} {
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tc.body))
+		if tc.chunked {
+			r.ContentLength = -1
+		}
+		w := httptest.NewRecorder()
+
+		if got := limitBody(w, r, 8, o); got != tc.allowed {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.allowed)
+			continue
+		}
+		if !tc.allowed {
+			if w.Code != http.StatusRequestEntityTooLarge {
+				t.Errorf("%s: got status %d", tc.name, w.Code)
+			}
+			continue
+		}
+
+		_, err := ioutil.ReadAll(r.Body)
+		if (err == nil) != tc.read {
+			t.Errorf("%s: unexpected read error %v", tc.name, err)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package proxy
+
+import (
+	"net/http"
+)
+
+// rewriteLinks rewrites the URLs of the Link headers, i.e. the preloads and preconnects, through the backward mapping,
+// so that the browser does not connect to the target origins before the page is even parsed.
+// Each header value is rewritten on its own, preserving the repeated headers.
+func rewriteLinks(replacer *Replacer, header http.Header, base64 Base64) {
+	for i, value := range header.Values("Link") {
+		header["Link"][i] = replacer.Transform(value, false, base64)
+	}
+}
+
+// EarlyHintsProcessor rewrites the Link headers of the 103 Early Hints of the target
+func (muraena *MuraenaProxy) EarlyHintsProcessor(header http.Header) {
+	sess := muraena.Session
+	rewriteLinks(muraena.Replacer, header, Base64{
+		sess.Config.Transform.Base64.Enabled,
+		sess.Config.Transform.Base64.Padding,
+	})
+}
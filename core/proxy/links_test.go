@@ -0,0 +1,73 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestRewriteLinks(t *testing.T) {
+	r := &Replacer{Phishing: "phishing.click", Target: "poor.victim"}
+	r.SetBackwardReplacements([]string{"poor.victim", "phishing.click"})
+
+	header := http.Header{}
+	header.Add("Link", "<https://static.poor.victim/app.css>; rel=preload; as=style")
+	header.Add("Link", "<https://api.poor.victim>; rel=preconnect")
+
+	rewriteLinks(r, header, Base64{})
+
+	want := []string{
+		"<https://static.phishing.click/app.css>; rel=preload; as=style",
+		"<https://api.phishing.click>; rel=preconnect",
+	}
+	if got := header.Values("Link"); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestReverseProxyEarlyHints(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", "<https://static.poor.victim/app.css>; rel=preload")
+		w.WriteHeader(http.StatusEarlyHints)
+		w.Header().Del("Link")
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+
+	target, _ := url.Parse(backend.URL)
+	proxy := NewSingleHostReverseProxy(target)
+	proxy.ModifyEarlyHints = func(h http.Header) {
+		h.Set("Link", strings.Replace(h.Get("Link"), "poor.victim", "phishing.click", 1))
+	}
+	front := httptest.NewServer(proxy)
+	defer front.Close()
+
+	var hints []string
+	trace := &httptrace.ClientTrace{
+		Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+			if code == http.StatusEarlyHints {
+				hints = append(hints, header.Get("Link"))
+			}
+			return nil
+		},
+	}
+	req, _ := http.NewRequest(http.MethodGet, front.URL, nil)
+	resp, err := http.DefaultClient.Do(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if len(hints) != 1 || hints[0] != "<https://static.phishing.click/app.css>; rel=preload" {
+		t.Errorf("early hints %q", hints)
+	}
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Link") != "" {
+		t.Errorf("final response %d with Link %q", resp.StatusCode, resp.Header.Get("Link"))
+	}
+}
@@ -0,0 +1,86 @@
+package proxy
+
+import (
+	"container/list"
+	"sync"
+)
+
+// lru is a size bounded, concurrency safe, least recently used cache
+type lru struct {
+	mu      sync.Mutex
+	size    int
+	entries map[string]*list.Element
+	list    *list.List
+}
+
+type lruEntry struct {
+	key   string
+	value interface{}
+}
+
+func newLRU(size int) *lru {
+	return &lru{
+		size:    size,
+		entries: make(map[string]*list.Element),
+		list:    list.New(),
+	}
+}
+
+// Get returns the value stored for key
+func (c *lru) Get(key string) (value interface{}, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return
+	}
+
+	c.list.MoveToFront(e)
+	return e.Value.(*lruEntry).value, true
+}
+
+// Add stores the value for key, evicting the least recently used entries if needed
+func (c *lru) Add(key string, value interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[key]; ok {
+		c.list.MoveToFront(e)
+		e.Value.(*lruEntry).value = value
+		return
+	}
+
+	c.entries[key] = c.list.PushFront(&lruEntry{key: key, value: value})
+	for c.list.Len() > c.size {
+		c.remove(c.list.Back())
+	}
+}
+
+// Remove deletes the entries whose key satisfies match and returns how many were removed
+func (c *lru) Remove(match func(key string) bool) (removed int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, e := range c.entries {
+		if match(key) {
+			c.remove(e)
+			removed++
+		}
+	}
+
+	return
+}
+
+// Len returns the number of entries in the cache
+func (c *lru) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.list.Len()
+}
+
+func (c *lru) remove(e *list.Element) {
+	c.list.Remove(e)
+	delete(c.entries, e.Value.(*lruEntry).key)
+}
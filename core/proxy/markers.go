@@ -0,0 +1,184 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// rewriteEdit is a replacement applied by a pass of the rules: the bytes in [in, inEnd) of its input are
+// replaced by the ones in [out, outEnd) of its output
+type rewriteEdit struct {
+	in, inEnd   int
+	out, outEnd int
+	old, new    string
+}
+
+// rewriteTrace is a replacement of the rules found in a body, as written to the offsets log
+type rewriteTrace struct {
+	// Rule is the number of the replacement in the legend of the markers
+	Rule int    `json:"rule"`
+	Old  string `json:"old"`
+	New  string `json:"new"`
+	// Offset and Length locate the replaced bytes in the original body
+	Offset int `json:"offset"`
+	Length int `json:"length"`
+
+	// start and end locate the replacement in the rewritten body
+	start, end int
+}
+
+// replaceEdits is Replace, also returning the edits applied
+func replaceEdits(m *matcher, s string) (string, []rewriteEdit) {
+	var b strings.Builder
+	var edits []rewriteEdit
+	last := 0
+	m.scan(s, func(start, end int, pattern int32) {
+		b.WriteString(s[last:start])
+		out := b.Len()
+		b.WriteString(m.news[pattern])
+		edits = append(edits, rewriteEdit{start, end, out, b.Len(), m.olds[pattern], m.news[pattern]})
+		last = end
+	})
+
+	if len(edits) == 0 {
+		return s, nil
+	}
+
+	b.WriteString(s[last:])
+	return b.String(), edits
+}
+
+// mapRange returns the range of the output of a pass matching [start, end) of its input, or the range of the
+// input matching the output if backward. A range overlapping an edit is widened to the whole edit.
+func mapRange(edits []rewriteEdit, start, end int, backward bool) (int, int) {
+	bounds := func(e rewriteEdit) (int, int, int, int) {
+		if backward {
+			return e.out, e.outEnd, e.in, e.inEnd
+		}
+		return e.in, e.inEnd, e.out, e.outEnd
+	}
+
+	mappedStart, shift := -1, 0
+	for _, e := range edits {
+		from, to, mappedFrom, mappedTo := bounds(e)
+		if mappedStart == -1 {
+			if start < from {
+				mappedStart = start + shift
+			} else if start < to {
+				mappedStart = mappedFrom
+			}
+		}
+		if end <= from {
+			break
+		}
+		if end <= to {
+			return mappedStart, mappedTo
+		}
+		shift = mappedTo - to
+	}
+
+	if mappedStart == -1 {
+		mappedStart = start + shift
+	}
+	return mappedStart, end + shift
+}
+
+// traceReplacements applies the replacements of the rules to the original body, as the two passes of Transform,
+// returning the rewritten body and the replacements applied, numbered by rule in order of appearance
+func traceReplacements(r *Replacer, original string, direction Direction) (string, []rewriteTrace) {
+	forward := direction == Forward
+	intermediate, first := replaceEdits(r.getMatcher(matcherKind{forward: forward}), original)
+	rewritten, last := replaceEdits(r.getMatcher(matcherKind{forward: forward, last: true}), intermediate)
+
+	var traces []rewriteTrace
+	for _, e := range first {
+		start, end := mapRange(last, e.out, e.outEnd, false)
+		traces = append(traces, rewriteTrace{Old: e.old, New: e.new, Offset: e.in, Length: e.inEnd - e.in, start: start, end: end})
+	}
+	for _, e := range last {
+		offset, end := mapRange(first, e.in, e.inEnd, true)
+		traces = append(traces, rewriteTrace{Old: e.old, New: e.new, Offset: offset, Length: end - offset, start: e.out, end: e.outEnd})
+	}
+
+	// Enclosing replacements first, so that the markers are nested
+	sort.SliceStable(traces, func(i, j int) bool {
+		if traces[i].start != traces[j].start {
+			return traces[i].start < traces[j].start
+		}
+		return traces[i].end > traces[j].end
+	})
+
+	rules := map[string]int{}
+	for i := range traces {
+		pair := traces[i].Old + "\x00" + traces[i].New
+		if rules[pair] == 0 {
+			rules[pair] = len(rules) + 1
+		}
+		traces[i].Rule = rules[pair]
+	}
+
+	return rewritten, traces
+}
+
+// markReplacements returns the HTML pages and scripts rewritten by the rules, wrapping each replaced substring
+// in marker comments numbered by rule, listed at the top of the body. The other bodies are left as transformed.
+// The number of replacements is set in the OperatorHeader of the response.
+func markReplacements(r *Replacer, original []byte, transformed string, header http.Header) (string, []rewriteTrace) {
+	rewritten, traces := traceReplacements(r, string(original), Backward)
+	header.Set(OperatorHeader, fmt.Sprintf("%s; %d replacements", operatorMarkers, len(traces)))
+
+	var open, close string
+	contentType := strings.ToLower(header.Get("Content-Type"))
+	switch {
+	case strings.Contains(contentType, "html"):
+		open, close = "<!--mrn:%d-->", "<!--/mrn:%d-->"
+	case strings.Contains(contentType, "javascript") || strings.Contains(contentType, "ecmascript"):
+		open, close = "/*mrn:%d*/", "/*/mrn:%d*/"
+	default:
+		return transformed, traces
+	}
+
+	var b strings.Builder
+	b.Grow(len(rewritten) + 24*len(traces))
+
+	// stack holds the replacements whose closing marker is pending
+	var stack []rewriteTrace
+	pos := 0
+	closeUntil := func(offset int) {
+		for len(stack) > 0 && stack[len(stack)-1].end <= offset {
+			t := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			b.WriteString(rewritten[pos:t.end])
+			pos = t.end
+			fmt.Fprintf(&b, close, t.Rule)
+		}
+	}
+
+	for _, t := range traces {
+		closeUntil(t.start)
+		// A replacement crossing an enclosing one cannot be nested, it is only listed
+		if len(stack) > 0 && t.end > stack[len(stack)-1].end {
+			continue
+		}
+		b.WriteString(rewritten[pos:t.start])
+		pos = t.start
+		fmt.Fprintf(&b, open, t.Rule)
+		stack = append(stack, t)
+	}
+	closeUntil(len(rewritten))
+	b.WriteString(rewritten[pos:])
+
+	var legend strings.Builder
+	fmt.Fprintf(&legend, "Muraena: %d replacements", len(traces))
+	listed := map[int]bool{}
+	for _, t := range traces {
+		if !listed[t.Rule] {
+			listed[t.Rule] = true
+			fmt.Fprintf(&legend, "\n  mrn:%d %s > %s", t.Rule, t.Old, t.New)
+		}
+	}
+
+	return commentBody(contentType, legend.String(), b.String()), traces
+}
@@ -0,0 +1,144 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/muraenateam/muraena/session"
+)
+
+func TestMapRange(t *testing.T) {
+	// "aXbYc" > "aXXXbc": X replaced by XXX at 1, Y removed at 3
+	edits := []rewriteEdit{{in: 1, inEnd: 2, out: 1, outEnd: 4}, {in: 3, inEnd: 4, out: 5, outEnd: 5}}
+
+	for _, c := range []struct {
+		start, end         int
+		backward           bool
+		wantStart, wantEnd int
+	}{
+		{0, 1, false, 0, 1},
+		{1, 2, false, 1, 4},
+		{2, 3, false, 4, 5},
+		{4, 5, false, 5, 6},
+		// overlapping an edit, the range is widened
+		{0, 2, false, 0, 4},
+		{2, 4, false, 4, 5},
+		{2, 3, true, 1, 2},
+		{4, 5, true, 2, 3},
+		{5, 6, true, 4, 5},
+	} {
+		start, end := mapRange(edits, c.start, c.end, c.backward)
+		if start != c.wantStart || end != c.wantEnd {
+			t.Errorf("[%d, %d) backward %v: expected [%d, %d), got [%d, %d)",
+				c.start, c.end, c.backward, c.wantStart, c.wantEnd, start, end)
+		}
+	}
+}
+
+func TestMarkReplacements(t *testing.T) {
+	r := &Replacer{Phishing: "phishing.click", Target: "poor.victim", ExternalOriginPrefix: "ext"}
+	r.SetExternalOrigins([]string{"cdn.provider.net"})
+	if err := r.DomainMapping(); err != nil {
+		t.Fatal(err)
+	}
+	r.MakeReplacements()
+
+	original := `<script src="https://cdn.provider.net/app.js"></script><a href="https://poor.victim/">`
+	rewritten, traces := traceReplacements(r, original, Backward)
+	if rewritten != r.Transform(original, false, r.Base64) {
+		t.Errorf("expected the rules to rewrite the body as Transform, got %s", rewritten)
+	}
+	if len(traces) != 2 {
+		t.Fatalf("expected 2 replacements, got %+v", traces)
+	}
+	for _, tr := range traces {
+		if original[tr.Offset:tr.Offset+tr.Length] != tr.Old || rewritten[tr.start:tr.end] != tr.New {
+			t.Errorf("unexpected ranges of %+v", tr)
+		}
+	}
+
+	header := http.Header{"Content-Type": []string{"text/html"}}
+	marked, _ := markReplacements(r, []byte(original), rewritten, header)
+	if !strings.Contains(marked, `src="https://<!--mrn:1-->ext1.phishing.click<!--/mrn:1-->/app.js"`) {
+		t.Errorf("expected the external origin to be marked, got %s", marked)
+	}
+	if !strings.HasPrefix(marked, "<!-- Muraena: 2 replacements\n  mrn:1 cdn.provider.net > ext1.phishing.click") {
+		t.Errorf("expected the rules to be listed, got %s", marked)
+	}
+	if header.Get(OperatorHeader) != "markers; 2 replacements" {
+		t.Errorf("unexpected header %q", header.Get(OperatorHeader))
+	}
+
+	header = http.Header{"Content-Type": []string{"application/javascript"}}
+	if marked, _ = markReplacements(r, []byte(original), rewritten, header); !strings.Contains(marked, "/*mrn:2*/") {
+		t.Errorf("expected the script to be marked, got %s", marked)
+	}
+
+	header = http.Header{"Content-Type": []string{"application/json"}}
+	if marked, _ = markReplacements(r, []byte(original), "transformed", header); marked != "transformed" {
+		t.Errorf("expected the JSON body to be left as transformed, got %s", marked)
+	}
+
+	// Nested replacements: the last replacements rewrite part of the first ones
+	r.CustomResponseTransformations = [][]string{{"ext1.phishing", "custom.phishing"}}
+	r.MakeReplacements()
+	rewritten, traces = traceReplacements(r, original, Backward)
+	if len(traces) != 3 {
+		t.Fatalf("expected 3 replacements, got %+v", traces)
+	}
+	header = http.Header{"Content-Type": []string{"text/html"}}
+	marked, _ = markReplacements(r, []byte(original), rewritten, header)
+	if !strings.Contains(marked, "<!--mrn:1--><!--mrn:2-->custom.phishing<!--/mrn:2-->.click<!--/mrn:1-->") {
+		t.Errorf("expected the markers to be nested, got %s", marked)
+	}
+}
+
+func TestOperatorOffsets(t *testing.T) {
+	sess := &session.Session{Config: &session.Configuration{}}
+	sess.Config.Operator.Enabled = true
+	sess.Config.Operator.Allow = []string{"203.0.113.10"}
+	sess.Config.Operator.Offsets = filepath.Join(t.TempDir(), "offsets.log")
+	o, err := newOperatorSession(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &Replacer{Phishing: "phishing.click", Target: "poor.victim"}
+	r.MakeReplacements()
+
+	original := []byte(`<a href="https://poor.victim/">poor.victim</a>`)
+	response := &http.Response{
+		Header:  http.Header{"Content-Type": []string{"text/html"}},
+		Request: httptest.NewRequest(http.MethodGet, "https://poor.victim/login", nil),
+	}
+	o.Rewrite(operatorAnnotate, r, response, original, r.Transform(string(original), false, r.Base64))
+
+	file, err := os.Open(sess.Config.Operator.Offsets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	if !scanner.Scan() {
+		t.Fatal("expected the offsets to be logged")
+	}
+	var entry offsetsEntry
+	if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry.URL != "https://poor.victim/login" || entry.Size != len(original) || len(entry.Replacements) != 2 {
+		t.Fatalf("unexpected entry %+v", entry)
+	}
+	for _, tr := range entry.Replacements {
+		if string(original[tr.Offset:tr.Offset+tr.Length]) != tr.Old {
+			t.Errorf("unexpected range of %+v", tr)
+		}
+	}
+}
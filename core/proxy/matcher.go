@@ -0,0 +1,208 @@
+package proxy
+
+import (
+	"strings"
+)
+
+// matcher is an Aho–Corasick automaton replacing many patterns in a single pass over the input.
+// It follows the strings.Replacer semantics: matches are replaced from left to right without overlapping,
+// and when several patterns match at the same position the one listed first wins.
+type matcher struct {
+	// classes maps each input byte to its column in delta, bytes not used by any pattern share column 0
+	classes [256]byte
+	width   int
+	// delta is the full transition table of the automaton, indexed by node*width + class
+	delta []int32
+	nodes []matcherNode
+	olds  []string
+	news  []string
+}
+
+type matcherNode struct {
+	// output is the index of the pattern ending in this node, -1 if none
+	output int32
+	// link is the nearest node along the failure chain with an output, -1 if none
+	link  int32
+	depth int32
+}
+
+// newMatcher builds a matcher from a slice of old, new string pairs.
+// Empty old values are ignored.
+func newMatcher(pairs []string, caseInsensitive bool) *matcher {
+	m := &matcher{}
+
+	fold := func(c byte) byte { return c }
+	if caseInsensitive {
+		fold = func(c byte) byte {
+			if 'A' <= c && c <= 'Z' {
+				return c + 'a' - 'A'
+			}
+			return c
+		}
+	}
+
+	// Assign a class to each byte used in the patterns
+	m.width = 1
+	for i := 0; i+1 < len(pairs); i += 2 {
+		for j := 0; j < len(pairs[i]); j++ {
+			c := fold(pairs[i][j])
+			if m.classes[c] == 0 {
+				m.classes[c] = byte(m.width)
+				m.width++
+			}
+		}
+	}
+	for c := 0; c < 256; c++ {
+		m.classes[c] = m.classes[fold(byte(c))]
+	}
+
+	// Build the trie
+	m.nodes = append(m.nodes, matcherNode{output: -1, link: -1})
+	m.delta = make([]int32, m.width)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		old := pairs[i]
+		if old == "" {
+			continue
+		}
+
+		n := int32(0)
+		for j := 0; j < len(old); j++ {
+			idx := int(n)*m.width + int(m.classes[old[j]])
+			if m.delta[idx] == 0 {
+				m.delta[idx] = int32(len(m.nodes))
+				m.nodes = append(m.nodes, matcherNode{output: -1, link: -1, depth: m.nodes[n].depth + 1})
+				m.delta = append(m.delta, make([]int32, m.width)...)
+			}
+			n = m.delta[idx]
+		}
+
+		// Duplicated patterns keep the first definition
+		if m.nodes[n].output == -1 {
+			m.nodes[n].output = int32(len(m.olds))
+			m.olds = append(m.olds, old)
+			m.news = append(m.news, pairs[i+1])
+		}
+	}
+
+	// Turn the trie into a complete automaton, breadth first, following the failure links
+	fail := make([]int32, len(m.nodes))
+	queue := make([]int32, 0, len(m.nodes))
+	for c := 1; c < m.width; c++ {
+		if child := m.delta[c]; child != 0 {
+			queue = append(queue, child)
+		}
+	}
+
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+
+		f := fail[n]
+		if m.nodes[f].output != -1 {
+			m.nodes[n].link = f
+		} else {
+			m.nodes[n].link = m.nodes[f].link
+		}
+
+		for c := 0; c < m.width; c++ {
+			idx := int(n)*m.width + c
+			if child := m.delta[idx]; child != 0 {
+				fail[child] = m.delta[int(f)*m.width+c]
+				queue = append(queue, child)
+			} else {
+				m.delta[idx] = m.delta[int(f)*m.width+c]
+			}
+		}
+	}
+
+	return m
+}
+
+// footprint estimates the memory used by the automaton, in bytes.
+// The patterns are shared with the replacements, so they are not counted.
+func (m *matcher) footprint() int {
+	return len(m.classes) + 4*len(m.delta) + 12*len(m.nodes)
+}
+
+// Replace returns a copy of s with all the patterns replaced
+func (m *matcher) Replace(s string) string {
+	return m.ReplaceObserved(s, nil)
+}
+
+// ReplaceObserved is Replace, calling observe, if not nil, with the old and new values of each replacement
+func (m *matcher) ReplaceObserved(s string, observe func(old, new string)) string {
+	if len(m.olds) == 0 {
+		return s
+	}
+
+	var b strings.Builder
+	last := 0
+	m.scan(s, func(start, end int, pattern int32) {
+		if b.Len() == 0 {
+			b.Grow(len(s))
+		}
+		b.WriteString(s[last:start])
+		b.WriteString(m.news[pattern])
+		if observe != nil {
+			observe(m.olds[pattern], m.news[pattern])
+		}
+		last = end
+	})
+
+	if last == 0 {
+		return s
+	}
+
+	b.WriteString(s[last:])
+	return b.String()
+}
+
+// scan calls found with the position and the pattern of each match replaced, from left to right
+func (m *matcher) scan(s string, found func(start, end int, pattern int32)) {
+	if len(m.olds) == 0 {
+		return
+	}
+
+	last := 0
+
+	// Best pending match: the leftmost one, with the highest priority among those starting at the same position
+	start, end, pattern := -1, -1, int32(-1)
+
+	n := int32(0)
+	for i := 0; i <= len(s); i++ {
+		if i < len(s) {
+			n = m.delta[int(n)*m.width+int(m.classes[s[i]])]
+		}
+
+		// A pending match is final once no pattern starting at or before it can still be matched
+		if start != -1 && (i == len(s) || i-int(m.nodes[n].depth) >= start) {
+			found(start, end, pattern)
+			last = end
+			start, end, pattern = -1, -1, -1
+
+			// Rescan from the end of the replaced match, as matches cannot overlap
+			n = 0
+			i = last - 1
+			continue
+		}
+
+		if i == len(s) {
+			break
+		}
+
+		o := n
+		if m.nodes[o].output == -1 {
+			o = m.nodes[o].link
+		}
+		for ; o != -1; o = m.nodes[o].link {
+			p := m.nodes[o].output
+			st := i + 1 - len(m.olds[p])
+			if st < last {
+				continue
+			}
+			if start == -1 || st < start || (st == start && p < pattern) {
+				start, end, pattern = st, i+1, p
+			}
+		}
+	}
+}
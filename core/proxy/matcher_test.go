@@ -0,0 +1,100 @@
+package proxy
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestMatcherReplace(t *testing.T) {
+	tests := []struct {
+		name            string
+		pairs           []string
+		input           string
+		caseInsensitive bool
+		want            string
+	}{
+		{"no patterns", nil, "poor.victim", false, "poor.victim"},
+		{"single", []string{"poor.victim", "phishing.click"}, "https://poor.victim/", false, "https://phishing.click/"},
+		{"priority", []string{"www.poor.victim", "www.phishing.click", "poor.victim", "phishing.click"},
+			"www.poor.victim poor.victim", false, "www.phishing.click phishing.click"},
+		{"leftmost", []string{"victim", "X", "poor.victim", "Y"}, "poor.victim", false, "Y"},
+		{"no overlap", []string{"aa", "b"}, "aaa", false, "ba"},
+		{"case insensitive", []string{"poor.victim", "phishing.click"}, "POOR.Victim", true, "phishing.click"},
+		{"case sensitive", []string{"poor.victim", "phishing.click"}, "POOR.Victim", false, "POOR.Victim"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := newMatcher(tt.pairs, tt.caseInsensitive).Replace(tt.input); got != tt.want {
+				t.Errorf("Replace(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestMatcherStringsReplacer checks the matcher against strings.Replacer, whose semantics it must follow
+func TestMatcherStringsReplacer(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	word := func(max int) string {
+		b := make([]byte, 1+rnd.Intn(max))
+		for i := range b {
+			b[i] = "abc."[rnd.Intn(4)]
+		}
+		return string(b)
+	}
+
+	for i := 0; i < 2000; i++ {
+		var pairs []string
+		for j := 0; j < 1+rnd.Intn(8); j++ {
+			pairs = append(pairs, word(4), word(3))
+		}
+		input := word(40)
+
+		want := strings.NewReplacer(pairs...).Replace(input)
+		if got := newMatcher(pairs, false).Replace(input); got != want {
+			t.Fatalf("Replace(%q) with %q = %q, want %q", input, pairs, got, want)
+		}
+	}
+}
+
+func benchmarkReplacements(n int) ([]string, string) {
+	var pairs []string
+	var body strings.Builder
+	for i := 0; i < n; i++ {
+		origin := strings.Repeat("x", i%7) + "origin" + string(rune('a'+i%26)) + strings.Repeat("y", i/26) + ".victim"
+		pairs = append(pairs, origin, "phishing.click")
+	}
+	for i := 0; i < 2000; i++ {
+		body.WriteString("var url = 'https://")
+		body.WriteString(pairs[2*(i%n)])
+		body.WriteString("/static/app.js'; function f(a, b) { return a + b; }\n")
+	}
+	return pairs, body.String()
+}
+
+func BenchmarkStringsReplacer(b *testing.B) {
+	pairs, body := benchmarkReplacements(300)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		strings.NewReplacer(pairs...).Replace(body)
+	}
+}
+
+func BenchmarkMatcher(b *testing.B) {
+	pairs, body := benchmarkReplacements(300)
+	m := newMatcher(pairs, false)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m.Replace(body)
+	}
+}
+
+func BenchmarkCaseInsensitiveMatcher(b *testing.B) {
+	pairs, body := benchmarkReplacements(300)
+	m := newMatcher(pairs, true)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m.Replace(body)
+	}
+}
@@ -0,0 +1,55 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// sessionMigration upgrades the fields of a session.json to the following version of the schema
+type sessionMigration func(fields map[string]json.RawMessage) error
+
+// sessionMigrations holds the migration of each version of the session.json schema, sessionMigrations[v]
+// upgrading a session of version v to v+1. When a persisted field of the Replacer is added, renamed or changes
+// meaning, append the migration converting the sessions saved by the previous releases.
+var sessionMigrations = []sessionMigration{
+	// 0 is the version of the sessions saved before the versioning, whose fields are the ones of version 1
+	func(fields map[string]json.RawMessage) error { return nil },
+}
+
+// sessionVersion is the version of the session.json schema saved by this release
+var sessionVersion = len(sessionMigrations)
+
+// migrateSession upgrades a session.json to the last version of the migrations, returning the upgraded content and
+// the version it was saved with. A session saved by a newer release is rejected rather than misinterpreted.
+func migrateSession(data []byte, migrations []sessionMigration) ([]byte, int, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, 0, err
+	}
+
+	version := 0
+	if raw, ok := fields["Version"]; ok {
+		if err := json.Unmarshal(raw, &version); err != nil {
+			return nil, 0, fmt.Errorf("invalid version %s: %s", raw, err)
+		}
+	}
+
+	switch {
+	case version < 0:
+		return nil, 0, fmt.Errorf("invalid version %d", version)
+	case version > len(migrations):
+		return nil, version, fmt.Errorf("saved by a newer release, version %d instead of %d", version, len(migrations))
+	case version == len(migrations):
+		return data, version, nil
+	}
+
+	for v := version; v < len(migrations); v++ {
+		if err := migrations[v](fields); err != nil {
+			return nil, version, fmt.Errorf("error migrating from version %d: %s", v, err)
+		}
+		fields["Version"] = json.RawMessage(fmt.Sprint(v + 1))
+	}
+
+	data, err := json.Marshal(fields)
+	return data, version, err
+}
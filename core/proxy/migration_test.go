@@ -0,0 +1,78 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestMigrateSession(t *testing.T) {
+	migrations := []sessionMigration{
+		func(fields map[string]json.RawMessage) error { return nil },
+		// version 2 renames Prefix as ExternalOriginPrefix
+		func(fields map[string]json.RawMessage) error {
+			if prefix, ok := fields["Prefix"]; ok {
+				fields["ExternalOriginPrefix"] = prefix
+				delete(fields, "Prefix")
+			}
+			return nil
+		},
+	}
+
+	data, version, err := migrateSession([]byte(`{"Target": "poor.victim", "Prefix": "ext"}`), migrations)
+	if err != nil || version != 0 {
+		t.Fatalf("expected an unversioned session to be migrated, got version %d: %v", version, err)
+	}
+	var r Replacer
+	if err := json.Unmarshal(data, &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Version != 2 || r.ExternalOriginPrefix != "ext" || r.Target != "poor.victim" {
+		t.Errorf("unexpected migrated session %s", data)
+	}
+
+	current := `{"Version": 2, "ExternalOriginPrefix": "ext"}`
+	if data, version, err = migrateSession([]byte(current), migrations); err != nil || version != 2 || string(data) != current {
+		t.Errorf("expected a current session to be kept as is, got %s: %v", data, err)
+	}
+
+	if _, _, err = migrateSession([]byte(`{"Version": 3}`), migrations); err == nil || !strings.Contains(err.Error(), "newer") {
+		t.Errorf("expected a session of a newer release to be rejected, got %v", err)
+	}
+
+	migrations = append(migrations, func(fields map[string]json.RawMessage) error { return errors.New("boom") })
+	if _, _, err = migrateSession([]byte(current), migrations); err == nil {
+		t.Error("expected the failed migration to be reported")
+	}
+}
+
+func TestLoadUnversionedSession(t *testing.T) {
+	inTempDir(t)
+
+	loaded := &Replacer{Phishing: "phishing.click", Target: "poor.victim"}
+	filename := loaded.GetSessionFileName()
+	legacy := `{"Phishing": "phishing.click", "Target": "poor.victim", "ExternalOriginPrefix": "ext", "Origins": {}}`
+	if err := ioutil.WriteFile(filename, []byte(legacy), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := loaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Version != sessionVersion || loaded.Phishing != "phishing.click" {
+		t.Errorf("unexpected loaded session %+v", loaded)
+	}
+
+	if err := loaded.Save(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"Version": 1`) {
+		t.Errorf("expected the version to be saved, got %s", data)
+	}
+}
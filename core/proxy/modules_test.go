@@ -0,0 +1,58 @@
+package proxy
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestModuleURLsRewrite(t *testing.T) {
+	m := &moduleURLs{}
+	domains := []string{"poor.victim", "cdn.net"}
+	transform := func(s string) string {
+		return strings.NewReplacer("static.poor.victim", "static.phishing.click", "cdn.net", "ext1.phishing.click").Replace(s)
+	}
+
+	for _, tc := range []struct {
+		name, in, want string
+	}{
+		{"dynamic import", `import("https:\/\/static.poor.victim\/chunk.js")`,
+			`import("https://static.phishing.click/chunk.js")`},
+		{"worker", `new Worker('https://static\u002epoor\u002evictim/worker.js', {type: 'module'})`,
+			`new Worker('https://static.phishing.click/worker.js', {type: 'module'})`},
+		{"module URL", "new URL(`\\u{68}ttps://cdn\\x2enet/app.wasm`, import.meta.url)",
+			"new URL(`https://ext1.phishing.click/app.wasm`, import.meta.url)"},
+		{"streaming fetch", `WebAssembly.instantiateStreaming(fetch("https:\/\/cdn.net\/app.wasm"), imports)`,
+			`WebAssembly.instantiateStreaming(fetch("https://ext1.phishing.click/app.wasm"), imports)`},
+		{"import scripts", `importScripts("https:\/\/cdn.net\/sw-lib.js")`,
+			`importScripts("https://ext1.phishing.click/sw-lib.js")`},
+		{"plain literal", `import("https://static.poor.victim/chunk.js")`,
+			`import("https://static.poor.victim/chunk.js")`},
+		{"foreign origin", `import("https:\/\/other.tld\/chunk.js")`,
+			`import("https:\/\/other.tld\/chunk.js")`},
+		{"unsupported escape", `new Worker("https:\/\/cdn.net\/w.js\n")`,
+			`new Worker("https:\/\/cdn.net\/w.js\n")`},
+		{"not a module", `fetch("https:\/\/cdn.net\/data.json")`,
+			`fetch("https:\/\/cdn.net\/data.json")`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := m.Rewrite(tc.in, domains, transform); got != tc.want {
+				t.Errorf("Rewrite(%s)\n got %s\nwant %s", tc.in, got, tc.want)
+			}
+		})
+	}
+
+	var disabled *moduleURLs
+	in := `import("https:\/\/static.poor.victim\/chunk.js")`
+	if got := disabled.Rewrite(in, domains, transform); got != in {
+		t.Errorf("disabled stage rewrote %s", got)
+	}
+}
+
+func TestEscapeScriptString(t *testing.T) {
+	if got := escapeScriptString("it's ${x} \\", '`'); got != "it's \\${x} \\\\" {
+		t.Errorf("template: got %s", got)
+	}
+	if got := escapeScriptString("it's", '\''); got != `it\'s` {
+		t.Errorf("single quote: got %s", got)
+	}
+}
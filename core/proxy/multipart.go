@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/muraenateam/muraena/log"
+)
+
+var unsafeFileNameChars = regexp.MustCompile(`[^a-zA-Z0-9._-]`)
+
+// multipartBoundary returns the boundary of a multipart/form-data Content-Type, empty if the content is not multipart
+func multipartBoundary(contentType string) string {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType != "multipart/form-data" {
+		return ""
+	}
+
+	return params["boundary"]
+}
+
+// transformMultipart rebuilds a multipart/form-data body, with the same boundary, applying transform
+// to the form field values only. File parts are copied untouched and, if uploads is not empty,
+// saved within the uploads directory.
+func transformMultipart(body []byte, boundary string, transform func(string) string, uploads string) ([]byte, error) {
+	reader := multipart.NewReader(bytes.NewReader(body), boundary)
+
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+	if err := writer.SetBoundary(boundary); err != nil {
+		return nil, err
+	}
+
+	for {
+		part, err := reader.NextRawPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		content, err := ioutil.ReadAll(part)
+		if err != nil {
+			return nil, err
+		}
+
+		if part.FileName() == "" {
+			content = []byte(transform(string(content)))
+		} else if uploads != "" {
+			if err := saveUpload(uploads, part.FileName(), content); err != nil {
+				log.Warning("Error saving uploaded file %s: %s", part.FileName(), err)
+			}
+		}
+
+		w, err := writer.CreatePart(part.Header)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = w.Write(content); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// saveUpload stores an uploaded file into the directory, prefixing its sanitized name with a timestamp
+func saveUpload(directory, fileName string, content []byte) error {
+	if err := os.MkdirAll(directory, 0700); err != nil {
+		return err
+	}
+
+	name := unsafeFileNameChars.ReplaceAllString(filepath.Base(strings.ReplaceAll(fileName, "\\", "/")), "_")
+	path := filepath.Join(directory, fmt.Sprintf("%d-%s", time.Now().UnixNano(), name))
+
+	log.Info("Saving uploaded file %s to %s", fileName, path)
+	return ioutil.WriteFile(path, content, 0600)
+}
@@ -0,0 +1,60 @@
+package proxy
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime/multipart"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTransformMultipart(t *testing.T) {
+	file := []byte("\x00\x01phishing.click\xff")
+
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	_ = w.WriteField("redirect", "https://phishing.click/home")
+	fw, _ := w.CreateFormFile("document", "../../report.pdf")
+	_, _ = fw.Write(file)
+	_ = w.Close()
+
+	if got := multipartBoundary(w.FormDataContentType()); got != w.Boundary() {
+		t.Fatalf("multipartBoundary() = %q, want %q", got, w.Boundary())
+	}
+	if got := multipartBoundary("application/x-www-form-urlencoded"); got != "" {
+		t.Errorf("multipartBoundary() = %q for a non multipart content", got)
+	}
+
+	uploads := t.TempDir()
+	out, err := transformMultipart(body.Bytes(), w.Boundary(), func(s string) string {
+		return strings.ReplaceAll(s, "phishing.click", "poor.victim")
+	}, uploads)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reader := multipart.NewReader(bytes.NewReader(out), w.Boundary())
+	form, err := reader.ReadForm(1 << 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := form.Value["redirect"][0]; got != "https://poor.victim/home" {
+		t.Errorf("field value = %q, want the transformed value", got)
+	}
+
+	f, _ := form.File["document"][0].Open()
+	content, _ := ioutil.ReadAll(f)
+	if !bytes.Equal(content, file) {
+		t.Errorf("file content = %q, want it untouched", content)
+	}
+
+	saved, _ := filepath.Glob(filepath.Join(uploads, "*-report.pdf"))
+	if len(saved) != 1 {
+		t.Fatalf("expected the upload to be saved within %s, found %v", uploads, saved)
+	}
+	if content, _ := ioutil.ReadFile(saved[0]); !bytes.Equal(content, file) {
+		t.Errorf("saved content = %q", content)
+	}
+}
@@ -0,0 +1,232 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/muraenateam/muraena/log"
+	"github.com/muraenateam/muraena/session"
+)
+
+// Modes of the operator session
+const (
+	// operatorOriginal passes the responses untouched, as sent by the target
+	operatorOriginal = "original"
+	// operatorAnnotate transforms the responses, listing the replacements applied
+	operatorAnnotate = "annotate"
+	// operatorMarkers transforms the responses, wrapping each replaced substring in marker comments
+	operatorMarkers = "markers"
+	// operatorOff handles the operator requests as the victim ones
+	operatorOff = "off"
+)
+
+// OperatorHeader selects the mode of an operator request, i.e. set by a match and replace rule of Burp or ZAP.
+// It is removed from all the requests, and only honored for the operator addresses.
+const OperatorHeader = "X-Muraena-Operator"
+
+// operatorSession recognizes the requests of the operator, browsing the target through the proxy during the
+// development of the rules: they are neither tracked nor relayed, and their responses are left untouched or
+// annotated, to compare the rewritten pages with the original ones side by side.
+type operatorSession struct {
+	allowed []*net.IPNet
+	mode    string
+
+	// trace turns on the rewrite trace of the operator requests, traceSecret the one of the requests carrying it
+	trace       bool
+	traceSecret string
+
+	// offsets is the log of the replacements found in the annotated responses, nil if disabled
+	mu      sync.Mutex
+	offsets *os.File
+}
+
+type operatorKey struct{}
+
+// operators is the operator session, nil if disabled
+var operators *operatorSession
+
+// newOperatorSession returns the operator session defined in the configuration, nil if disabled
+func newOperatorSession(sess *session.Session) (*operatorSession, error) {
+	config := sess.Config.Operator
+	if !config.Enabled {
+		return nil, nil
+	}
+
+	o := &operatorSession{
+		mode:        strings.ToLower(config.Mode),
+		trace:       config.Trace.Enabled,
+		traceSecret: config.Trace.Secret,
+	}
+	for _, a := range config.Allow {
+		if network := session.ParseNetwork(a); network != nil {
+			o.allowed = append(o.allowed, network)
+		}
+	}
+
+	if config.Offsets != "" {
+		file, err := os.OpenFile(config.Offsets, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return nil, fmt.Errorf("error opening the operator offsets log: %w", err)
+		}
+		o.offsets = file
+	}
+
+	return o, nil
+}
+
+// Mode returns the mode of the request, empty for the victims. The client address is the one of the connection.
+func (o *operatorSession) Mode(r *http.Request) string {
+	if o == nil {
+		return ""
+	}
+
+	mode := strings.ToLower(strings.TrimSpace(r.Header.Get(OperatorHeader)))
+	r.Header.Del(OperatorHeader)
+
+	if !o.allows(r) {
+		return ""
+	}
+
+	switch mode {
+	case operatorOff:
+		return ""
+	case operatorOriginal, operatorAnnotate, operatorMarkers:
+		return mode
+	}
+	return o.mode
+}
+
+// allows reports if the request comes from an operator address, the one of the connection
+func (o *operatorSession) allows(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return session.ContainsIP(o.allowed, host)
+}
+
+// withOperatorMode returns the context of an operator request
+func withOperatorMode(ctx context.Context, mode string) context.Context {
+	return context.WithValue(ctx, operatorKey{}, mode)
+}
+
+// operatorMode returns the operator mode of the request context, empty for the victims
+func operatorMode(ctx context.Context) string {
+	mode, _ := ctx.Value(operatorKey{}).(string)
+	return mode
+}
+
+// Rewrite returns the transformed body of an operator response in the annotate or markers mode,
+// logging the offsets of the replacements found in the original body, if enabled
+func (o *operatorSession) Rewrite(mode string, r *Replacer, response *http.Response, original []byte, transformed string) string {
+	var traces []rewriteTrace
+	switch mode {
+	case operatorAnnotate:
+		transformed = annotateReplacements(r, original, transformed, response.Header)
+		if o.offsets != nil {
+			_, traces = traceReplacements(r, string(original), Backward)
+		}
+	case operatorMarkers:
+		transformed, traces = markReplacements(r, original, transformed, response.Header)
+	default:
+		return transformed
+	}
+
+	if o.offsets != nil {
+		o.logOffsets(response, len(original), traces)
+	}
+	return transformed
+}
+
+// offsetsEntry is a line of the offsets log
+type offsetsEntry struct {
+	Time         time.Time      `json:"time"`
+	Method       string         `json:"method"`
+	URL          string         `json:"url"`
+	ContentType  string         `json:"contentType"`
+	Size         int            `json:"size"`
+	Replacements []rewriteTrace `json:"replacements"`
+}
+
+// logOffsets appends the replacements found in a response to the offsets log, one JSON object per line
+func (o *operatorSession) logOffsets(response *http.Response, size int, traces []rewriteTrace) {
+	// The log lists the replacements in the order of the original body
+	sort.SliceStable(traces, func(i, j int) bool { return traces[i].Offset < traces[j].Offset })
+
+	line, err := json.Marshal(offsetsEntry{
+		Time:         time.Now().UTC(),
+		Method:       response.Request.Method,
+		URL:          response.Request.URL.String(),
+		ContentType:  response.Header.Get("Content-Type"),
+		Size:         size,
+		Replacements: traces,
+	})
+	if err != nil {
+		log.Warning("Error encoding the offsets of %s: %s", response.Request.URL, err)
+		return
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if _, err = o.offsets.Write(append(line, '\n')); err != nil {
+		log.Warning("Error writing the operator offsets log: %s", err)
+	}
+}
+
+// annotateReplacements returns the transformed body listing the replacements found in the original one, as a
+// comment of the HTML pages, scripts and stylesheets. Their number is also set in the OperatorHeader of the response.
+func annotateReplacements(r *Replacer, original []byte, transformed string, header http.Header) string {
+	type found struct {
+		pair  string
+		count int
+	}
+
+	var replacements []found
+	total := 0
+	for _, pairs := range [][]string{r.GetBackwardReplacements(), r.GetLastBackwardReplacements()} {
+		for i := 0; i+1 < len(pairs); i += 2 {
+			if pairs[i] == "" {
+				continue
+			}
+			if n := bytes.Count(original, []byte(pairs[i])); n > 0 {
+				replacements = append(replacements, found{fmt.Sprintf("%s > %s", pairs[i], pairs[i+1]), n})
+				total += n
+			}
+		}
+	}
+	sort.SliceStable(replacements, func(i, j int) bool { return replacements[i].count > replacements[j].count })
+
+	header.Set(OperatorHeader, fmt.Sprintf("%s; %d replacements", operatorAnnotate, total))
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Muraena: %d replacements", total)
+	for _, f := range replacements {
+		fmt.Fprintf(&b, "\n  %s (%d)", f.pair, f.count)
+	}
+	return commentBody(strings.ToLower(header.Get("Content-Type")), b.String(), transformed)
+}
+
+// commentBody adds the comment at the top of the HTML pages and scripts, and at the bottom of the stylesheets.
+// The other bodies are returned as they are.
+func commentBody(contentType, comment, body string) string {
+	switch {
+	case strings.Contains(contentType, "html"):
+		return "<!-- " + strings.ReplaceAll(comment, "--", "- -") + "\n-->\n" + body
+	case strings.Contains(contentType, "javascript") || strings.Contains(contentType, "ecmascript"):
+		return "/* " + strings.ReplaceAll(comment, "*/", "* /") + "\n*/\n" + body
+	case strings.Contains(contentType, "css"):
+		// appended, as a @charset rule must be the first of the stylesheet
+		return body + "\n/* " + strings.ReplaceAll(comment, "*/", "* /") + "\n*/\n"
+	}
+
+	return body
+}
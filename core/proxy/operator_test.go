@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/muraenateam/muraena/session"
+)
+
+func TestOperatorMode(t *testing.T) {
+	var disabled *operatorSession
+	if mode := disabled.Mode(httptest.NewRequest(http.MethodGet, "/", nil)); mode != "" {
+		t.Errorf("expected no operator session, got %q", mode)
+	}
+
+	sess := &session.Session{Config: &session.Configuration{}}
+	sess.Config.Operator.Enabled = true
+	sess.Config.Operator.Allow = []string{"203.0.113.0/24"}
+	sess.Config.Operator.Mode = operatorOriginal
+	o, err := newOperatorSession(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		remote, header, mode string
+	}{
+		{"203.0.113.7:4242", "", operatorOriginal},
+		{"203.0.113.7:4242", "Annotate", operatorAnnotate},
+		{"203.0.113.7:4242", operatorMarkers, operatorMarkers},
+		{"203.0.113.7:4242", operatorOff, ""},
+		{"203.0.113.7:4242", "unknown", operatorOriginal},
+		// The victims cannot switch to the operator mode
+		{"198.51.100.1:4242", operatorOriginal, ""},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = c.remote
+		if c.header != "" {
+			r.Header.Set(OperatorHeader, c.header)
+		}
+
+		if mode := o.Mode(r); mode != c.mode {
+			t.Errorf("%s with %q: expected mode %q, got %q", c.remote, c.header, c.mode, mode)
+		}
+		if r.Header.Get(OperatorHeader) != "" {
+			t.Errorf("expected the operator header to be removed")
+		}
+	}
+
+	ctx := withOperatorMode(httptest.NewRequest(http.MethodGet, "/", nil).Context(), operatorAnnotate)
+	if operatorMode(ctx) != operatorAnnotate {
+		t.Errorf("expected the mode to be carried by the context")
+	}
+}
+
+func TestAnnotateReplacements(t *testing.T) {
+	r := &Replacer{Phishing: "phishing.click", Target: "poor.victim", ExternalOriginPrefix: "ext"}
+	r.SetExternalOrigins([]string{"cdn.provider.net"})
+	if err := r.DomainMapping(); err != nil {
+		t.Fatal(err)
+	}
+	r.MakeReplacements()
+
+	original := `<html><script src="https://cdn.provider.net/app.js"></script><a href="https://poor.victim/">`
+	transformed := string(r.TransformContent([]byte(original), Backward, "text/html"))
+
+	header := http.Header{"Content-Type": []string{"text/html; charset=utf-8"}}
+	annotated := annotateReplacements(r, []byte(original), transformed, header)
+	if !strings.HasPrefix(annotated, "<!-- Muraena: ") || !strings.HasSuffix(annotated, transformed) {
+		t.Fatalf("expected the page to start with the replacements, got %s", annotated)
+	}
+	if !strings.Contains(annotated, "cdn.provider.net > ") || !strings.Contains(header.Get(OperatorHeader), "replacements") {
+		t.Errorf("expected the replacement of the external origin to be listed, got %s", annotated)
+	}
+
+	header = http.Header{"Content-Type": []string{"text/css"}}
+	if annotated = annotateReplacements(r, []byte(original), transformed, header); !strings.HasPrefix(annotated, transformed) {
+		t.Errorf("expected the stylesheet annotation to be appended, got %s", annotated)
+	}
+
+	header = http.Header{"Content-Type": []string{"application/json"}}
+	if annotated = annotateReplacements(r, []byte(original), transformed, header); annotated != transformed {
+		t.Errorf("expected the JSON body to be left as transformed, got %s", annotated)
+	}
+}
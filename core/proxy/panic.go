@@ -0,0 +1,95 @@
+package proxy
+
+import (
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/muraenateam/muraena/core"
+	"github.com/muraenateam/muraena/core/db"
+	"github.com/muraenateam/muraena/log"
+	"github.com/muraenateam/muraena/session"
+)
+
+// Panic wipes all the captured data and the session state, then stops Muraena.
+// The servers are closed without draining and the replacer state is not saved.
+func Panic(sess *session.Session) {
+	panicOnce.Do(func() { wipe(sess) })
+}
+
+var panicOnce sync.Once
+
+func wipe(sess *session.Session) {
+	log.Important("Panic: wiping all the captured data")
+
+	serversMu.Lock()
+	for _, server := range servers {
+		server.Close()
+	}
+	serversMu.Unlock()
+
+	purge(sess)
+	if sess.Config.Tracking.Enabled {
+		db.Close()
+	}
+
+	log.Important("Panic: wipe completed")
+	close(shutdownComplete)
+}
+
+// purge deletes all the tracking data from the storage and shreds the files holding captured data or session state
+func purge(sess *session.Session) {
+	if sess.Config.Tracking.Enabled {
+		if err := db.Wipe(); err != nil {
+			log.Error("Error wiping the storage: %s", err)
+		}
+	}
+
+	for _, path := range panicFiles(sess) {
+		if err := core.Shred(path); err != nil && !os.IsNotExist(err) {
+			log.Error("Error wiping %s: %s", path, err)
+		}
+	}
+}
+
+// panicFiles returns the files holding captured data or session state
+func panicFiles(sess *session.Session) (files []string) {
+	if replacer != nil {
+		files = append(files, replacer.GetSessionFileName())
+	}
+
+	if sess.Config.Log.Enabled {
+		files = append(files, sess.Config.Log.FilePath)
+	}
+
+	if sess.Config.Events.Enabled {
+		for _, sink := range sess.Config.Events.Sinks {
+			if strings.ToLower(sink.Type) == "file" && sink.Path != "" {
+				files = append(files, sink.Path)
+			}
+		}
+	}
+
+	return
+}
+
+// servePanic exposes the panic endpoint: POST /panic wipes all the captured data
+func servePanic(sess *session.Session) {
+	config := sess.Config.Retention.Panic
+	if config.Listen == "" {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/panic", func(w http.ResponseWriter, r *http.Request) {
+		if !allowMethods(w, r, http.MethodPost) {
+			return
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+		go Panic(sess)
+	})
+
+	serveAdmin(sess, "Panic", config.Listen, config.Token, mux)
+}
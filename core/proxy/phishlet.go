@@ -0,0 +1,452 @@
+package proxy
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"regexp"
+	"regexp/syntax"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"golang.org/x/net/publicsuffix"
+	"gopkg.in/yaml.v3"
+
+	"github.com/muraenateam/muraena/core"
+)
+
+// phishlet is the subset of the Evilginx phishlet format converted to a Muraena configuration
+type phishlet struct {
+	Name   string `yaml:"name"`
+	Author string `yaml:"author"`
+
+	ProxyHosts []struct {
+		PhishSub  string `yaml:"phish_sub"`
+		OrigSub   string `yaml:"orig_sub"`
+		Domain    string `yaml:"domain"`
+		IsLanding bool   `yaml:"is_landing"`
+	} `yaml:"proxy_hosts"`
+
+	SubFilters []struct {
+		TriggersOn string   `yaml:"triggers_on"`
+		OrigSub    string   `yaml:"orig_sub"`
+		Domain     string   `yaml:"domain"`
+		Search     string   `yaml:"search"`
+		Replace    string   `yaml:"replace"`
+		Mimes      []string `yaml:"mimes"`
+	} `yaml:"sub_filters"`
+
+	AuthTokens []struct {
+		Domain string   `yaml:"domain"`
+		Keys   []string `yaml:"keys"`
+		Type   string   `yaml:"type"`
+	} `yaml:"auth_tokens"`
+
+	Credentials struct {
+		Username phishletCredential   `yaml:"username"`
+		Password phishletCredential   `yaml:"password"`
+		Custom   []phishletCredential `yaml:"custom"`
+	} `yaml:"credentials"`
+
+	AuthURLs []string `yaml:"auth_urls"`
+
+	Login struct {
+		Domain string `yaml:"domain"`
+		Path   string `yaml:"path"`
+	} `yaml:"login"`
+}
+
+// phishletCredential is a credential extracted from the POST requests
+type phishletCredential struct {
+	Key    string `yaml:"key"`
+	Search string `yaml:"search"`
+	Type   string `yaml:"type"`
+}
+
+// phishletPlaceholder matches the placeholders of the sub_filters
+var phishletPlaceholder = regexp.MustCompile(`\{(hostname|subdomain|domain|basedomain)(_regexp)?\}`)
+
+// importedPhishlet is the Muraena configuration converted from a phishlet
+type importedPhishlet struct {
+	Name     string
+	Author   string
+	Phishing string
+	Target   string
+	Landing  string
+
+	Origins      []string
+	SubdomainMap [][]string
+	Replacements [][]string
+	Sessions     []importedSession
+	Patterns     []importedPattern
+	AuthPaths    []string
+
+	// Notes are the parts of the phishlet not converted, to be reviewed
+	Notes []string
+}
+
+type importedSession struct {
+	Domain  string
+	Cookies []string
+}
+
+type importedPattern struct {
+	Label string
+	Start string
+	End   string
+}
+
+// phishletHost returns the hostname of a subdomain, the domain itself if the subdomain is empty
+func phishletHost(sub, domain string) string {
+	if sub == "" {
+		return strings.ToLower(domain)
+	}
+	return strings.ToLower(sub + "." + domain)
+}
+
+// importPhishlet converts the phishlet to a configuration for the phishing domain
+func importPhishlet(content []byte, phishing string) (*importedPhishlet, error) {
+	p := phishlet{}
+	if err := yaml.Unmarshal(content, &p); err != nil {
+		return nil, err
+	}
+	if len(p.ProxyHosts) == 0 {
+		return nil, fmt.Errorf("the phishlet has no proxy_hosts")
+	}
+
+	imported := &importedPhishlet{Name: p.Name, Author: p.Author, Phishing: phishing}
+
+	// The target is the registrable domain of the landing host, the first one if none is flagged as landing
+	landing := p.ProxyHosts[0]
+	for _, h := range p.ProxyHosts {
+		if h.IsLanding {
+			landing = h
+			break
+		}
+	}
+	imported.Target = strings.ToLower(landing.Domain)
+	if net.ParseIP(imported.Target) == nil {
+		if domain, err := publicsuffix.EffectiveTLDPlusOne(imported.Target); err == nil {
+			imported.Target = domain
+		}
+	}
+	imported.Landing = phishletHost(landing.OrigSub, landing.Domain)
+	if p.Login.Domain != "" {
+		imported.Landing = strings.ToLower(p.Login.Domain) + p.Login.Path
+	}
+
+	// The subdomains of the target keep their name, unless mapped, while the other hosts are external origins
+	phishHosts := make(map[string]string)
+	for _, h := range p.ProxyHosts {
+		origin := phishletHost(h.OrigSub, h.Domain)
+		sub := strings.TrimSuffix(origin, "."+imported.Target)
+		if origin == imported.Target {
+			sub = ""
+		} else if sub == origin {
+			imported.Origins = append(imported.Origins, origin)
+			continue
+		}
+
+		if h.PhishSub != sub {
+			imported.SubdomainMap = append(imported.SubdomainMap, []string{h.PhishSub, sub})
+		}
+		phishHosts[origin] = phishletHost(h.PhishSub, phishing)
+	}
+
+	for _, f := range p.SubFilters {
+		origin := phishletHost(f.OrigSub, f.Domain)
+		replacement, note := convertSubFilter(f.Search, f.Replace, origin, f.OrigSub, f.Domain, phishHosts[origin], phishing)
+		if note != "" {
+			imported.Notes = append(imported.Notes, fmt.Sprintf("sub_filter %s on %s: %s", strconv.Quote(f.Search),
+				f.TriggersOn, note))
+			continue
+		}
+		if replacement != nil {
+			imported.Replacements = append(imported.Replacements, replacement)
+		}
+	}
+
+	for _, t := range p.AuthTokens {
+		if t.Type != "" && t.Type != "cookie" {
+			imported.Notes = append(imported.Notes, fmt.Sprintf("auth_tokens of type %s on %s", t.Type, t.Domain))
+			continue
+		}
+
+		session := importedSession{Domain: strings.TrimPrefix(strings.ToLower(t.Domain), ".")}
+		for _, key := range t.Keys {
+			options := strings.Split(key, ",")
+			name := options[0]
+			optional := false
+			for _, option := range options[1:] {
+				switch strings.TrimSpace(option) {
+				case "opt":
+					optional = true
+				case "regexp":
+					name = "^" + strings.TrimSuffix(strings.TrimPrefix(name, "^"), "$") + "$"
+				}
+			}
+
+			// The session is complete once all its cookies are captured, so the optional ones are left out
+			if optional {
+				imported.Notes = append(imported.Notes, fmt.Sprintf("optional auth_token %s on %s", name, t.Domain))
+				continue
+			}
+			session.Cookies = append(session.Cookies, name)
+		}
+
+		if len(session.Cookies) > 0 {
+			imported.Sessions = append(imported.Sessions, session)
+		}
+	}
+
+	labels := []string{"Username", "Password"}
+	credentials := []phishletCredential{p.Credentials.Username, p.Credentials.Password}
+	for _, c := range p.Credentials.Custom {
+		labels = append(labels, c.Key)
+		credentials = append(credentials, c)
+	}
+	for i, c := range credentials {
+		if c.Key == "" {
+			continue
+		}
+
+		switch c.Type {
+		case "json":
+			imported.Patterns = append(imported.Patterns, importedPattern{labels[i], `"` + c.Key + `":"`, `"`})
+		case "", "post":
+			imported.Patterns = append(imported.Patterns, importedPattern{labels[i], c.Key + "=", "&"})
+		default:
+			imported.Notes = append(imported.Notes, fmt.Sprintf("credential %s of type %s", c.Key, c.Type))
+		}
+	}
+
+	for _, u := range p.AuthURLs {
+		if path, ok := literalRegexp(u); ok {
+			imported.AuthPaths = append(imported.AuthPaths, path)
+			continue
+		}
+		imported.Notes = append(imported.Notes, fmt.Sprintf("auth_url %s: regular expression", strconv.Quote(u)))
+	}
+
+	sort.Strings(imported.Origins)
+	return imported, nil
+}
+
+// convertSubFilter converts a sub_filter to a custom content replacement.
+// The filter is skipped, without any note, if the replacement of the hostnames already covers it.
+func convertSubFilter(search, replace, origin, sub, domain, phishHost, phishing string) ([]string, string) {
+	needsPhishHost := false
+
+	// As Evilginx, the placeholders of the search are quoted, twice for the _regexp ones,
+	// while the ones of the replacement are only quoted as _regexp
+	expand := func(s string, phishSide bool) string {
+		return phishletPlaceholder.ReplaceAllStringFunc(s, func(match string) string {
+			m := phishletPlaceholder.FindStringSubmatch(match)
+			var value string
+			switch {
+			case m[1] == "basedomain":
+				value = phishing
+			case m[1] == "hostname" && phishSide:
+				needsPhishHost = true
+				value = phishHost
+			case m[1] == "hostname":
+				value = origin
+			case m[1] == "subdomain" && phishSide:
+				needsPhishHost = true
+				value = strings.TrimSuffix(strings.TrimSuffix(phishHost, phishing), ".")
+			case m[1] == "subdomain":
+				value = sub
+			case phishSide:
+				value = phishing
+			default:
+				value = domain
+			}
+
+			if !phishSide {
+				value = regexp.QuoteMeta(value)
+			}
+			if m[2] != "" {
+				value = regexp.QuoteMeta(value)
+			}
+			return value
+		})
+	}
+
+	from, ok := literalRegexp(expand(search, false))
+	if !ok {
+		return nil, "regular expression"
+	}
+	if strings.Contains(replace, "$") {
+		return nil, "regular expression groups"
+	}
+
+	to := expand(replace, true)
+	if needsPhishHost && phishHost == "" {
+		return nil, "hostname of the external origin " + origin
+	}
+	if from == to || strings.ReplaceAll(from, origin, phishHost) == to {
+		return nil, ""
+	}
+
+	return []string{from, to}, ""
+}
+
+// literalRegexp returns the string matched by the regular expression, if it only matches a literal.
+// An unescaped dot is taken as itself, as usually meant in the phishlets, i.e. app.js.
+func literalRegexp(expr string) (string, bool) {
+	re, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		return "", false
+	}
+
+	var b strings.Builder
+	var literal func(re *syntax.Regexp) bool
+	literal = func(re *syntax.Regexp) bool {
+		switch re.Op {
+		case syntax.OpLiteral:
+			if re.Flags&syntax.FoldCase != 0 {
+				return false
+			}
+			b.WriteString(string(re.Rune))
+		case syntax.OpAnyCharNotNL:
+			b.WriteByte('.')
+		case syntax.OpEmptyMatch:
+		case syntax.OpConcat:
+			for _, sub := range re.Sub {
+				if !literal(sub) {
+					return false
+				}
+			}
+		default:
+			return false
+		}
+		return true
+	}
+
+	if !literal(re.Simplify()) {
+		return "", false
+	}
+	return b.String(), true
+}
+
+// importedConfig is the template of the configuration converted from a phishlet
+var importedConfig = template.Must(template.New("phishlet").Funcs(template.FuncMap{"quote": strconv.Quote}).Parse(`#
+# Configuration imported by {{.Version}} from the {{.Phishlet.Name}} phishlet{{with .Phishlet.Author}} by {{.}}{{end}}
+# Landing page: https://{{.Phishlet.Landing}}
+{{- with .Phishlet.Notes}}
+#
+# Not converted, to be reviewed:
+{{- range .}}
+#   - {{.}}
+{{- end}}
+{{- end}}
+#
+
+[proxy]
+    phishing = {{quote .Phishlet.Phishing}}
+    destination = {{quote .Phishlet.Target}}
+
+
+[origins]
+    externalOriginPrefix = "cdn-"
+    externalOrigins = [{{range .Phishlet.Origins}}
+        {{quote .}},{{end}}
+    ]
+    subdomainMap = [{{range .Phishlet.SubdomainMap}}
+        [ {{quote (index . 0)}}, {{quote (index . 1)}} ],{{end}}
+    ]
+
+
+[transform.response]
+    customContent = [{{range .Phishlet.Replacements}}
+        [ {{quote (index . 0)}}, {{quote (index . 1)}} ],{{end}}
+    ]
+
+
+[tracking]
+    enable = true
+    trackRequestCookies = true
+
+    [tracking.secrets]
+        # Evilginx searches the credentials in any POST request
+        paths = [ "^/.*$" ]
+{{range .Phishlet.Patterns}}
+        [[tracking.secrets.patterns]]
+        label = {{quote .Label}}
+        matching = {{quote .Start}}
+        start = {{quote .Start}}
+        end = {{quote .End}}
+{{end}}
+{{- range .Phishlet.Sessions}}
+    [[tracking.sessions]]
+        name = {{quote $.Phishlet.Name}}
+        cookies = [{{range .Cookies}} {{quote .}},{{end}} ]
+        domains = [ {{quote .Domain}} ]
+{{end}}
+{{- with .Phishlet.AuthPaths}}
+[necrobrowser.urls]
+    authSessionResponse = [{{range .}} {{quote .}},{{end}} ]
+{{end -}}
+`))
+
+// Config returns the configuration converted from the phishlet
+func (p *importedPhishlet) Config() (string, error) {
+	var b strings.Builder
+	err := importedConfig.Execute(&b, map[string]interface{}{
+		"Version":  fmt.Sprintf("%s v%s", core.Name, core.Version),
+		"Phishlet": p,
+	})
+	return b.String(), err
+}
+
+// ImportPhishlet runs the import-phishlet subcommand, returning the exit code:
+// an Evilginx phishlet is converted to the proxy, origins, transform and tracking sections.
+func ImportPhishlet(args []string) int {
+	flags := flag.NewFlagSet("import-phishlet", flag.ExitOnError)
+	phishing := flags.String("phishing", "", "Phishing domain.")
+	output := flags.String("output", "", "Path of the generated config file, standard output if empty.")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: muraena import-phishlet -phishing <domain> [-output <file>] <phishlet.yaml>\n")
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args)
+
+	if *phishing == "" || flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	content, err := ioutil.ReadFile(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	imported, err := importPhishlet(content, core.DomainToASCII(*phishing))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error importing the phishlet %s: %s\n", flags.Arg(0), err)
+		return 1
+	}
+
+	config, err := imported.Config()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if *output == "" {
+		fmt.Print(config)
+	} else if err := ioutil.WriteFile(*output, []byte(config), 0600); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	for _, note := range imported.Notes {
+		fmt.Fprintf(os.Stderr, "Not converted: %s\n", note)
+	}
+	return 0
+}
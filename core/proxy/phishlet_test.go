@@ -0,0 +1,101 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pelletier/go-toml"
+
+	"github.com/muraenateam/muraena/session"
+)
+
+func TestImportPhishlet(t *testing.T) {
+	content, err := ioutil.ReadFile("testdata/phishlet.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	imported, err := importPhishlet(content, "phishing.tld")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := imported.Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := session.Configuration{}
+	if err := toml.NewDecoder(strings.NewReader(config)).Strict(true).Decode(&c); err != nil {
+		t.Fatalf("Invalid configuration: %s\n%s", err, config)
+	}
+
+	if c.Proxy.Phishing != "phishing.tld" || c.Proxy.Target != "example.com" {
+		t.Errorf("Unexpected proxy %s %s", c.Proxy.Phishing, c.Proxy.Target)
+	}
+	if !reflect.DeepEqual(c.Origins.ExternalOrigins, []string{"static.example-cdn.net"}) {
+		t.Errorf("Unexpected external origins %v", c.Origins.ExternalOrigins)
+	}
+	if !reflect.DeepEqual(c.Origins.SubdomainMap, [][]string{{"sso", "accounts"}}) {
+		t.Errorf("Unexpected subdomain map %v", c.Origins.SubdomainMap)
+	}
+
+	expected := [][]string{
+		{`integrity="sha384-`, `data-integrity="sha384-`},
+		{`"authority":"https://login.example.com/`, `"authority":"https://login.phishing.tld/phishing.tld/`},
+		{`https://login\.example\.com`, `https:\/\/login\.phishing\.tld`},
+	}
+	if !reflect.DeepEqual(c.Transform.Response.CustomContent, expected) {
+		t.Errorf("Expected the custom content %q, got %q", expected, c.Transform.Response.CustomContent)
+	}
+
+	if len(c.Tracking.Sessions) != 1 ||
+		!reflect.DeepEqual(c.Tracking.Sessions[0].Cookies, []string{"SID", "HSID", "^session_.*$"}) ||
+		!reflect.DeepEqual(c.Tracking.Sessions[0].Domains, []string{"example.com"}) {
+		t.Errorf("Unexpected sessions %+v", c.Tracking.Sessions)
+	}
+
+	patterns := c.Tracking.Secrets.Patterns
+	if len(patterns) != 3 || patterns[0].Start != "email=" || patterns[1].Start != `"password":"` ||
+		patterns[2].Label != "otp" {
+		t.Errorf("Unexpected patterns %+v", patterns)
+	}
+
+	if !reflect.DeepEqual(c.Necrobrowser.SensitiveLocations.AuthSessionResponse, []string{"/app/home"}) {
+		t.Errorf("Unexpected auth session paths %v", c.Necrobrowser.SensitiveLocations.AuthSessionResponse)
+	}
+
+	// The regular expressions, the header token, the optional cookie and the external origin filter are left out
+	if len(imported.Notes) != 5 {
+		t.Errorf("Expected 5 notes, got %q", imported.Notes)
+	}
+	if !strings.Contains(imported.Notes[1], "hostname of the external origin static.example-cdn.net") {
+		t.Errorf("Expected the external origin filter not converted, got %q", imported.Notes[1])
+	}
+	for _, note := range imported.Notes {
+		if !strings.Contains(config, note) {
+			t.Errorf("Expected the note %q in the configuration", note)
+		}
+	}
+}
+
+func TestLiteralRegexp(t *testing.T) {
+	for expr, expected := range map[string]string{
+		`/app/home`:            "/app/home",
+		`login\.example\.com`:  "login.example.com",
+		`"authority":"https:/`: `"authority":"https:/`,
+		`/static/app.js`:       "/static/app.js",
+	} {
+		if literal, ok := literalRegexp(expr); !ok || literal != expected {
+			t.Errorf("Expected %q from %q, got %q", expected, expr, literal)
+		}
+	}
+
+	for _, expr := range []string{`/app/.*`, `nonce="[^"]*"`, `(?i)login`} {
+		if _, ok := literalRegexp(expr); ok {
+			t.Errorf("Expected %q not to be literal", expr)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"github.com/muraenateam/muraena/session"
+)
+
+// protection guarantees that binaries and signed payloads never pass through the string replacement,
+// which would corrupt them
+type protection struct {
+	contentTypes []string
+	paths        []*regexp.Regexp
+	sniff        bool
+}
+
+// protected is the protection shared by all the proxies
+var protected *protection
+
+// newProtection compiles the protection rules defined in the configuration
+func newProtection(sess *session.Session) (*protection, error) {
+	config := sess.Config.Transform.Protect
+	p := &protection{contentTypes: config.ContentTypes, sniff: config.Sniff}
+
+	for _, path := range config.Paths {
+		re, err := regexp.Compile(path)
+		if err != nil {
+			return nil, fmt.Errorf("invalid protected path %s: %w", path, err)
+		}
+		p.paths = append(p.paths, re)
+	}
+
+	return p, nil
+}
+
+// Protects checks if the content exchanged on the path with the given Content-Type must be left untouched
+func (p *protection) Protects(path, contentType string) bool {
+	if p == nil {
+		return false
+	}
+
+	for _, re := range p.paths {
+		if re.MatchString(path) {
+			return true
+		}
+	}
+
+	return contentType != "" && matchContentType(contentType, p.contentTypes)
+}
+
+// ProtectsBody checks if the magic bytes of the body reveal protected content, whatever its declared Content-Type
+func (p *protection) ProtectsBody(body []byte) bool {
+	if p == nil || !p.sniff || len(body) == 0 {
+		return false
+	}
+
+	return matchContentType(http.DetectContentType(body), p.contentTypes)
+}
@@ -0,0 +1,58 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/muraenateam/muraena/session"
+)
+
+func TestProtection(t *testing.T) {
+	sess := &session.Session{Config: &session.Configuration{}}
+	sess.Config.Transform.Protect.ContentTypes = session.DefaultProtectContentTypes
+	sess.Config.Transform.Protect.Paths = []string{`^/api/v1/signed/`, `\.sig$`}
+	sess.Config.Transform.Protect.Sniff = true
+
+	p, err := newProtection(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		path        string
+		contentType string
+		protected   bool
+	}{
+		{"/logo.png", "image/png", true},
+		{"/font.woff2", "font/woff2", true},
+		{"/app.wasm", "application/wasm", true},
+		{"/api/v1/signed/assertion", "application/json", true},
+		{"/release.sig", "", true},
+		{"/index.html", "text/html; charset=utf-8", false},
+		{"/api/v1/session", "application/json", false},
+	} {
+		if got := p.Protects(tc.path, tc.contentType); got != tc.protected {
+			t.Errorf("%s %s: got %v, want %v", tc.path, tc.contentType, got, tc.protected)
+		}
+	}
+
+	for _, tc := range []struct {
+		name      string
+		body      string
+		protected bool
+	}{
+		{"png", "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR", true},
+		{"wasm", "\x00asm\x01\x00\x00\x00", true},
+		{"pdf", "%PDF-1.7\n", true},
+		{"html", "<html><body>https://www.target.com</body></html>", false},
+		{"json", `{"url":"https://www.target.com"}`, false},
+	} {
+		if got := p.ProtectsBody([]byte(tc.body)); got != tc.protected {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.protected)
+		}
+	}
+
+	sess.Config.Transform.Protect.Paths = []string{`(`}
+	if _, err := newProtection(sess); err == nil {
+		t.Error("invalid path accepted")
+	}
+}
@@ -0,0 +1,246 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/muraenateam/muraena/log"
+)
+
+// proxyProtocolTimeout is the time allowed to the balancer to send the PROXY protocol header
+const proxyProtocolTimeout = 5 * time.Second
+
+// proxyProtocolSignature starts the PROXY protocol v2 header
+var proxyProtocolSignature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+var errProxyProtocol = errors.New("invalid PROXY protocol header")
+
+// proxyProtocolListener accepts the connections of a L4 load balancer sending the PROXY protocol header (v1 or v2),
+// so that the remote address of the connections is the client one.
+// Connections without the header are rejected, as the client address could not be trusted.
+type proxyProtocolListener struct {
+	net.Listener
+}
+
+// Accept implements the net.Listener interface.
+// The header is read by the connection on first use, so that a slow client does not block the listener.
+func (l *proxyProtocolListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	return &proxyProtocolConn{Conn: conn, reader: bufio.NewReader(conn)}, nil
+}
+
+type proxyProtocolConn struct {
+	net.Conn
+	reader *bufio.Reader
+
+	once       sync.Once
+	err        error
+	remoteAddr net.Addr
+}
+
+// init reads the PROXY protocol header
+func (c *proxyProtocolConn) init() {
+	c.once.Do(func() {
+		c.remoteAddr = c.Conn.RemoteAddr()
+
+		_ = c.Conn.SetReadDeadline(time.Now().Add(proxyProtocolTimeout))
+		addr, err := readProxyProtocolHeader(c.reader)
+		_ = c.Conn.SetReadDeadline(time.Time{})
+
+		if err != nil {
+			c.err = err
+			c.Conn.Close()
+			return
+		}
+
+		// LOCAL connections, i.e. health checks of the balancer, keep the balancer address
+		if addr != nil {
+			c.remoteAddr = addr
+		}
+	})
+}
+
+// Read implements the net.Conn interface
+func (c *proxyProtocolConn) Read(b []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+
+	return c.reader.Read(b)
+}
+
+// RemoteAddr implements the net.Conn interface, returning the client address
+func (c *proxyProtocolConn) RemoteAddr() net.Addr {
+	c.init()
+	return c.remoteAddr
+}
+
+// readProxyProtocolHeader reads the PROXY protocol header and returns the source address,
+// nil if the connection is not proxied
+func readProxyProtocolHeader(r *bufio.Reader) (net.Addr, error) {
+	signature, err := r.Peek(len(proxyProtocolSignature))
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.Equal(signature, proxyProtocolSignature) {
+		return readProxyProtocolV2(r)
+	}
+	if bytes.HasPrefix(signature, []byte("PROXY ")) {
+		return readProxyProtocolV1(r)
+	}
+
+	return nil, errProxyProtocol
+}
+
+// readProxyProtocolV1 parses the human-readable header, i.e. PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n
+func readProxyProtocolV1(r *bufio.Reader) (net.Addr, error) {
+	// The header is at most 107 bytes long
+	var line []byte
+	for len(line) < 107 {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errProxyProtocol
+	}
+
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, errProxyProtocol
+	}
+
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.Atoi(fields[4])
+	if ip == nil || err != nil || port < 0 || port > 65535 {
+		return nil, errProxyProtocol
+	}
+
+	return &net.TCPAddr{IP: ip, Port: port}, nil
+}
+
+// readProxyProtocolV2 parses the binary header
+func readProxyProtocolV2(r *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	version, command := header[12]>>4, header[12]&0x0f
+	if version != 2 {
+		return nil, fmt.Errorf("unsupported PROXY protocol version %d", version)
+	}
+
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+
+	// LOCAL
+	if command == 0 {
+		return nil, nil
+	}
+	if command != 1 {
+		return nil, errProxyProtocol
+	}
+
+	// The addresses follow the family, then the TLVs: only the edge tag is reported
+	switch header[13] >> 4 {
+	case 1: // IPv4
+		if len(payload) < 12 {
+			return nil, errProxyProtocol
+		}
+		addr := &net.TCPAddr{IP: net.IP(payload[0:4]), Port: int(binary.BigEndian.Uint16(payload[8:10]))}
+		reportEdgeTag(addr, payload[12:])
+		return addr, nil
+	case 2: // IPv6
+		if len(payload) < 36 {
+			return nil, errProxyProtocol
+		}
+		addr := &net.TCPAddr{IP: net.IP(payload[0:16]), Port: int(binary.BigEndian.Uint16(payload[32:34]))}
+		reportEdgeTag(addr, payload[36:])
+		return addr, nil
+	}
+
+	// UNSPEC and UNIX sockets
+	return nil, nil
+}
+
+// proxyProtocolEdgeTag is the type of the TLV tagging the connections forwarded by an edge, in the custom range
+const proxyProtocolEdgeTag = 0xE0
+
+// reportEdgeTag logs the edge the connection has been forwarded by, if tagged
+func reportEdgeTag(addr net.Addr, tlvs []byte) {
+	for len(tlvs) >= 3 {
+		kind, length := tlvs[0], int(binary.BigEndian.Uint16(tlvs[1:3]))
+		if len(tlvs) < 3+length {
+			return
+		}
+		if kind == proxyProtocolEdgeTag {
+			log.Debug("[%s] Forwarded by the edge %s", addr, tlvs[3:3+length])
+			return
+		}
+		tlvs = tlvs[3+length:]
+	}
+}
+
+// proxyProtocolHeader returns the PROXY protocol header (v1 or v2) of the connection from src to dst.
+// The v2 header carries the tag, if any, in the edge TLV.
+func proxyProtocolHeader(version int, src, dst *net.TCPAddr, tag string) []byte {
+	ipv4 := src.IP.To4() != nil && dst.IP.To4() != nil
+
+	if version == 1 {
+		if ipv4 {
+			return []byte(fmt.Sprintf("PROXY TCP4 %s %s %d %d\r\n", src.IP.To4(), dst.IP.To4(), src.Port, dst.Port))
+		}
+		return []byte(fmt.Sprintf("PROXY TCP6 %s %s %d %d\r\n", src.IP.To16(), dst.IP.To16(), src.Port, dst.Port))
+	}
+
+	var payload []byte
+	family := byte(0x11) // TCP over IPv4
+	if ipv4 {
+		payload = append(payload, src.IP.To4()...)
+		payload = append(payload, dst.IP.To4()...)
+	} else {
+		family = 0x21 // TCP over IPv6
+		payload = append(payload, src.IP.To16()...)
+		payload = append(payload, dst.IP.To16()...)
+	}
+	payload = binary.BigEndian.AppendUint16(payload, uint16(src.Port))
+	payload = binary.BigEndian.AppendUint16(payload, uint16(dst.Port))
+
+	if tag != "" {
+		payload = append(payload, proxyProtocolEdgeTag)
+		payload = binary.BigEndian.AppendUint16(payload, uint16(len(tag)))
+		payload = append(payload, tag...)
+	}
+
+	header := append([]byte{}, proxyProtocolSignature...)
+	header = append(header, 0x21, family)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(payload)))
+	return append(header, payload...)
+}
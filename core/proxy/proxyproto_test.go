@@ -0,0 +1,111 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func proxyProtocolV2(command byte, family byte, addresses []byte) []byte {
+	header := append([]byte{}, proxyProtocolSignature...)
+	header = append(header, 0x20|command, family, 0, 0)
+	binary.BigEndian.PutUint16(header[14:16], uint16(len(addresses)))
+	return append(header, addresses...)
+}
+
+func TestReadProxyProtocolHeader(t *testing.T) {
+	ipv4 := []byte{192, 0, 2, 1, 198, 51, 100, 1, 0xdc, 0x04, 0x01, 0xbb}
+
+	for _, tc := range []struct {
+		name   string
+		header string
+		addr   string
+		err    bool
+	}{
+		{"v1 tcp4", "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n", "192.0.2.1:56324", false},
+		{"v1 tcp6", "PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n", "[2001:db8::1]:56324", false},
+		{"v1 unknown", "PROXY UNKNOWN\r\n", "", false},
+		{"v1 invalid", "PROXY TCP4 nope 198.51.100.1 56324 443\r\n", "", true},
+		{"v2 proxy", string(proxyProtocolV2(1, 0x11, ipv4)), "192.0.2.1:56324", false},
+		{"v2 local", string(proxyProtocolV2(0, 0x00, nil)), "", false},
+		{"missing", "GET / HTTP/1.1\r\nHost: phishing.tld\r\n\r\n", "", true},
+	} {
+		r := bufio.NewReader(strings.NewReader(tc.header + "GET / HTTP/1.1\r\n"))
+		addr, err := readProxyProtocolHeader(r)
+		if (err != nil) != tc.err {
+			t.Errorf("%s: unexpected error %v", tc.name, err)
+			continue
+		}
+		if tc.err {
+			continue
+		}
+
+		got := ""
+		if addr != nil {
+			got = addr.String()
+		}
+		if got != tc.addr {
+			t.Errorf("%s: got address %q, want %q", tc.name, got, tc.addr)
+		}
+
+		// The request follows the header
+		if rest, _ := r.ReadString('\n'); rest != "GET / HTTP/1.1\r\n" {
+			t.Errorf("%s: got %q after the header", tc.name, rest)
+		}
+	}
+}
+
+func TestProxyProtocolListener(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.RemoteAddr))
+	})}
+	go server.Serve(&proxyProtocolListener{Listener: ln})
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	_, _ = conn.Write([]byte("PROXY TCP4 203.0.113.7 198.51.100.1 40000 443\r\nGET / HTTP/1.1\r\nHost: phishing.tld\r\nConnection: close\r\n\r\n"))
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	if string(body) != "203.0.113.7:40000" {
+		t.Errorf("got remote address %q, want the client one", body)
+	}
+}
+
+func TestHSTS(t *testing.T) {
+	handler := withHSTS(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/upstream" {
+			w.Header().Set("Strict-Transport-Security", "max-age=1")
+		}
+		_, _ = w.Write([]byte("ok"))
+	}), 31536000)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if got := rec.Header().Get("Strict-Transport-Security"); got != "max-age=31536000; includeSubDomains" {
+		t.Errorf("got HSTS %q", got)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/upstream", nil))
+	if got := rec.Header().Get("Strict-Transport-Security"); got != "max-age=1" {
+		t.Errorf("the upstream HSTS must be kept, got %q", got)
+	}
+}
@@ -0,0 +1,221 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/muraenateam/muraena/session"
+)
+
+var errUpstreamQueueTimeout = errors.New("timeout waiting for an upstream slot")
+
+// upstreamQueue limits the concurrent upstream requests of each victim, and of all of them,
+// so that a single victim opening many tabs, or a sandbox replaying the traffic, cannot exhaust the upstream pool.
+// A request over the limits waits in the queue of its victim, and the free slots are granted to the queues
+// in turn, so that the victims with few requests are not starved by the busy ones.
+type upstreamQueue struct {
+	perVictim int
+	max       int
+	timeout   time.Duration
+
+	mu      sync.Mutex
+	total   int
+	active  map[string]int
+	waiting map[string][]chan struct{}
+	// turns are the victims with waiting requests, in the order they are granted the free slots
+	turns []string
+}
+
+// queue is the upstream queue of the victims, nil if unlimited
+var queue *upstreamQueue
+
+// newUpstreamQueue returns the upstream queue defined in the configuration, nil if unlimited
+func newUpstreamQueue(sess *session.Session) *upstreamQueue {
+	limits := sess.Config.Proxy.Limits
+	if limits.MaxUpstreamPerVictim <= 0 && limits.MaxUpstream <= 0 {
+		return nil
+	}
+
+	return &upstreamQueue{
+		perVictim: limits.MaxUpstreamPerVictim,
+		max:       limits.MaxUpstream,
+		timeout:   time.Duration(limits.UpstreamQueueTimeout) * time.Second,
+		active:    make(map[string]int),
+		waiting:   make(map[string][]chan struct{}),
+	}
+}
+
+// available checks if the victim can be granted a slot, with the lock held
+func (q *upstreamQueue) available(victim string) bool {
+	return (q.max <= 0 || q.total < q.max) && (q.perVictim <= 0 || q.active[victim] < q.perVictim)
+}
+
+// grant assigns a slot to the victim, with the lock held
+func (q *upstreamQueue) grant(victim string) {
+	q.total++
+	q.active[victim]++
+}
+
+// Acquire waits for an upstream slot of the victim, until the context is done or the queue timeout expires
+func (q *upstreamQueue) Acquire(ctx context.Context, victim string) error {
+	q.mu.Lock()
+	if len(q.waiting[victim]) == 0 && q.available(victim) {
+		q.grant(victim)
+		q.mu.Unlock()
+		return nil
+	}
+
+	ready := make(chan struct{})
+	if len(q.waiting[victim]) == 0 {
+		q.turns = append(q.turns, victim)
+	}
+	q.waiting[victim] = append(q.waiting[victim], ready)
+	q.mu.Unlock()
+
+	var timeout <-chan time.Time
+	if q.timeout > 0 {
+		timer := time.NewTimer(q.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	err := errUpstreamQueueTimeout
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-timeout:
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	select {
+	case <-ready:
+		// granted in the meantime: give the slot back
+		q.release(victim)
+	default:
+		q.remove(victim, ready)
+	}
+	return err
+}
+
+// Release frees the upstream slot of the victim, granting it to the next queue in turn
+func (q *upstreamQueue) Release(victim string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.release(victim)
+}
+
+// release frees the slot and dispatches the free slots to the waiting requests, with the lock held
+func (q *upstreamQueue) release(victim string) {
+	q.total--
+	if q.active[victim]--; q.active[victim] <= 0 {
+		delete(q.active, victim)
+	}
+
+	for i := 0; i < len(q.turns); {
+		next := q.turns[i]
+		if !q.available(next) {
+			if q.max > 0 && q.total >= q.max {
+				return
+			}
+			i++
+			continue
+		}
+
+		ready := q.waiting[next][0]
+		q.waiting[next] = q.waiting[next][1:]
+		q.grant(next)
+		close(ready)
+
+		// The victim goes at the end of the turns, if it still has waiting requests
+		q.turns = append(q.turns[:i], q.turns[i+1:]...)
+		if len(q.waiting[next]) > 0 {
+			q.turns = append(q.turns, next)
+		} else {
+			delete(q.waiting, next)
+		}
+	}
+}
+
+// remove drops the waiting request of the victim, with the lock held
+func (q *upstreamQueue) remove(victim string, ready chan struct{}) {
+	waiting := q.waiting[victim]
+	for i, w := range waiting {
+		if w == ready {
+			waiting = append(waiting[:i], waiting[i+1:]...)
+			break
+		}
+	}
+	if len(waiting) > 0 {
+		q.waiting[victim] = waiting
+		return
+	}
+
+	delete(q.waiting, victim)
+	for i, t := range q.turns {
+		if t == victim {
+			q.turns = append(q.turns[:i], q.turns[i+1:]...)
+			break
+		}
+	}
+}
+
+// queueTransport holds the upstream slot of the victim until the response body is closed
+type queueTransport struct {
+	queue *upstreamQueue
+	// header carries the victim identifier set by the tracker, the client IP address is used without it
+	header string
+	next   http.RoundTripper
+}
+
+// RoundTrip implements the http.RoundTripper interface
+func (t *queueTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	victim := ""
+	if t.header != "" {
+		victim = req.Header.Get(t.header)
+	}
+	if victim == "" {
+		victim = GetSenderIP(req)
+	}
+
+	if err := t.queue.Acquire(req.Context(), victim); err != nil {
+		return nil, err
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		t.queue.Release(victim)
+		return nil, err
+	}
+
+	// The upgraded connections are long-lived and do not hold a slot
+	if resp.Body == nil || resp.StatusCode == http.StatusSwitchingProtocols {
+		t.queue.Release(victim)
+		return resp, nil
+	}
+
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: func() { t.queue.Release(victim) }}
+	return resp, nil
+}
+
+// releasingBody frees the upstream slot once closed
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+	once    sync.Once
+}
+
+// Close implements the io.Closer interface
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
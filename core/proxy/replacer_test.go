@@ -0,0 +1,517 @@
+package proxy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// The corpora are generated, so that the benchmarks are reproducible without storing captured pages of real targets.
+// Run make bench to record the results and make bench-compare to compare them with the previous ones.
+
+// benchmarkReplacer returns a Replacer of the target with n external origins, one in ten being a wildcard
+func benchmarkReplacer(n int) *Replacer {
+	r := &Replacer{Phishing: "phishing.click", Target: "poor.victim", ExternalOriginPrefix: "ext"}
+	for i := 0; i < n; i++ {
+		if i%10 == 9 {
+			r.ExternalOrigin = append(r.ExternalOrigin, fmt.Sprintf("*.cdn%d.provider.net", i))
+			continue
+		}
+		r.ExternalOrigin = append(r.ExternalOrigin, fmt.Sprintf("static%d.origin%d.net", i, i%13))
+	}
+
+	if err := r.DomainMapping(); err != nil {
+		panic(err)
+	}
+	r.MakeReplacements()
+	return r
+}
+
+// inTempDir runs the test in a temporary directory, where the discovered origins are saved
+func inTempDir(tb testing.TB) {
+	wd, err := os.Getwd()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if err := os.Chdir(tb.TempDir()); err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { _ = os.Chdir(wd) })
+}
+
+// benchmarkOrigin returns the i-th origin of a benchmarkReplacer of n origins
+func benchmarkOrigin(i, n int) string {
+	i %= n
+	switch {
+	case i%10 == 9:
+		return fmt.Sprintf("assets.cdn%d.provider.net", i)
+	case i%3 == 0:
+		return "www.poor.victim"
+	}
+	return fmt.Sprintf("static%d.origin%d.net", i, i%13)
+}
+
+// htmlCorpus returns a large HTML page, referencing the origins in links, scripts, images and inline data
+func htmlCorpus(n int) string {
+	var b strings.Builder
+	b.WriteString("<!DOCTYPE html><html><head><title>Sign in</title>\n")
+	for i := 0; i < 200; i++ {
+		fmt.Fprintf(&b, `<link rel="preload" href="https://%s/css/main.%d.css" as="style">`+"\n", benchmarkOrigin(i, n), i)
+	}
+	b.WriteString("</head><body>\n")
+	for i := 0; i < 3000; i++ {
+		fmt.Fprintf(&b, `<div class="row row-%d"><a href="https://%s/account/%d?ref=nav">Account settings</a>`, i, benchmarkOrigin(i, n), i)
+		fmt.Fprintf(&b, `<img src="//%s/img/%d.png" alt="Lorem ipsum dolor sit amet, consectetur adipiscing elit"></div>`+"\n", benchmarkOrigin(i+1, n), i)
+	}
+	fmt.Fprintf(&b, `<script>window.__CONFIG__ = {"api": "https://%s/api", "cdn": "https://%s"};</script>`,
+		benchmarkOrigin(1, n), benchmarkOrigin(9, n))
+	b.WriteString("</body></html>\n")
+	return b.String()
+}
+
+// webpackCorpus returns a minified webpack bundle, with escaped URLs and origins built at runtime
+func webpackCorpus(n int) string {
+	var b strings.Builder
+	b.WriteString(`(self.webpackChunkapp=self.webpackChunkapp||[]).push([[179],{`)
+	for i := 0; i < 4000; i++ {
+		fmt.Fprintf(&b, `%d:function(e,t,n){"use strict";n.d(t,{Z:function(){return r}});var o=n(%d),i="https:\/\/%s\/v%d",`,
+			i, i+1, benchmarkOrigin(i, n), i%3)
+		fmt.Fprintf(&b, `a="//"+"%s";function r(e){return o.get(i+"/resource/"+e,{headers:{Origin:a}})}},`, benchmarkOrigin(i+7, n))
+	}
+	b.WriteString(`}]);`)
+	return b.String()
+}
+
+// jsonCorpus returns the response of a JSON API, with the origins in the values
+func jsonCorpus(n int) string {
+	var b strings.Builder
+	b.WriteString(`{"data":[`)
+	for i := 0; i < 5000; i++ {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		fmt.Fprintf(&b, `{"id":%d,"name":"Item %d","url":"https://%s/items/%d","thumbnail":"https:\/\/%s\/thumb\/%d.jpg","tags":["alpha","beta"]}`,
+			i, i, benchmarkOrigin(i, n), i, benchmarkOrigin(i+3, n), i)
+	}
+	fmt.Fprintf(&b, `],"next":"https://%s/api/items?page=2"}`, benchmarkOrigin(0, n))
+	return b.String()
+}
+
+var benchmarkCorpora = []struct {
+	name        string
+	contentType string
+	corpus      func(n int) string
+}{
+	{"html", "text/html; charset=utf-8", htmlCorpus},
+	{"webpack", "application/javascript", webpackCorpus},
+	{"json", "application/json", jsonCorpus},
+}
+
+// TestReplacerCorpora verifies that the benchmarks rewrite the corpora, so that they measure an actual transformation
+func TestReplacerCorpora(t *testing.T) {
+	inTempDir(t)
+	r := benchmarkReplacer(50)
+	for _, c := range benchmarkCorpora {
+		body := string(r.TransformContent([]byte(c.corpus(50)), Backward, c.contentType))
+		for _, origin := range []string{"poor.victim", "origin1.net", "provider.net"} {
+			if strings.Contains(body, origin) {
+				t.Errorf("%s: %s not replaced", c.name, origin)
+			}
+		}
+
+		if !strings.Contains(body, "phishing.click") {
+			t.Errorf("%s: phishing domain not found", c.name)
+		}
+	}
+}
+
+func TestReplacerSnapshot(t *testing.T) {
+	inTempDir(t)
+	r := benchmarkReplacer(20)
+
+	s := r.current()
+	if r.current() != s || r.getMatcher(matcherKind{}) != r.getMatcher(matcherKind{}) {
+		t.Error("expected the snapshot and its matchers to be reused until the rules change")
+	}
+
+	generation := r.Generation()
+	r.SetExternalOrigins([]string{"fonts.newcdn.net"})
+	if err := r.DomainMapping(); err != nil {
+		t.Fatal(err)
+	}
+	r.MakeReplacements()
+
+	if r.current() == s || r.Generation() <= generation {
+		t.Error("expected a new snapshot after a change of the rules")
+	}
+	if _, ok := s.origins["fonts.newcdn.net"]; ok {
+		t.Error("expected the previous snapshot not to be modified")
+	}
+	if got := r.Transform("https://fonts.newcdn.net/a.woff", false, Base64{}); !strings.Contains(got, ".phishing.click") {
+		t.Errorf("expected the new origin to be replaced, got %s", got)
+	}
+
+	// The requests read the rules while the origins are being discovered
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				r.Transform(htmlCorpus(20)[:4096], false, Base64{})
+				r.GetOrigins()
+				if i == 0 {
+					r.SetExternalOrigins([]string{fmt.Sprintf("media%d.newcdn.net", j)})
+					r.MakeReplacements()
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestReplacerSnapshot_Published(t *testing.T) {
+	inTempDir(t)
+	r := benchmarkReplacer(20)
+
+	// The wildcards are not appended, they require a full rebuild of the rules
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 50; i++ {
+			r.SetExternalOrigins([]string{fmt.Sprintf("*.media%d.newcdn.net", i)})
+		}
+	}()
+
+	// The requests never see an origin without its mapping and replacements
+	for {
+		s := r.current()
+		for _, origin := range s.externalOrigins {
+			if !strings.HasPrefix(origin, "*.media") {
+				continue
+			}
+			domain := strings.TrimPrefix(origin, "*.")
+			if _, ok := s.wildcards[domain]; !ok {
+				t.Fatalf("expected %s to be mapped in generation %d", origin, s.generation)
+			}
+			found := false
+			for _, rep := range s.backwardWildcard {
+				found = found || strings.Contains(rep, domain)
+			}
+			if !found {
+				t.Fatalf("expected %s to be replaced in generation %d", origin, s.generation)
+			}
+		}
+
+		select {
+		case <-done:
+			return
+		default:
+		}
+	}
+}
+
+func TestIncrementalReplacements(t *testing.T) {
+	inTempDir(t)
+	r := benchmarkReplacer(20)
+	generation := r.Generation()
+
+	r.SetExternalOrigins([]string{"api.newcdn.net", "login.poor.victim", "xn--bcher-kva.example"})
+	if r.appended != 2 {
+		t.Fatalf("expected 2 origins added incrementally, got %d", r.appended)
+	}
+	if r.Generation() == generation {
+		t.Error("expected a new generation after the addition")
+	}
+
+	// The incremental replacements are the ones of a full rebuild
+	full := benchmarkReplacer(20)
+	full.ExternalOrigin = r.GetExternalOrigins()
+	if err := full.DomainMapping(); err != nil {
+		t.Fatal(err)
+	}
+	full.MakeReplacements()
+
+	for name, replacements := range map[string]func(*Replacer) []string{
+		"forward":       (*Replacer).GetForwardReplacements,
+		"backward":      (*Replacer).GetBackwardReplacements,
+		"last backward": (*Replacer).GetLastBackwardReplacements,
+	} {
+		got, want := pairs(replacements(r)), pairs(replacements(full))
+		if strings.Join(got, " ") != strings.Join(want, " ") {
+			t.Errorf("%s: got %v, want %v", name, got, want)
+		}
+	}
+
+	// Compaction: the replacements are rebuilt every compactionInterval additions
+	for i := 0; r.appended > 0; i++ {
+		r.SetExternalOrigins([]string{fmt.Sprintf("static%d.newcdn.net", i)})
+		if i > compactionInterval {
+			t.Fatal("expected the replacements to be compacted")
+		}
+	}
+	if got := r.GetOrigins()["static0.newcdn.net"]; got == "" {
+		t.Error("expected the origins to be kept by the compaction")
+	}
+}
+
+func TestReplacerPersistence(t *testing.T) {
+	inTempDir(t)
+
+	r := benchmarkReplacer(5)
+	if err := r.Save(); err != nil {
+		t.Fatal(err)
+	}
+	r.SetExternalOrigins([]string{"api.newcdn.net"})
+	if err := r.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	filename := r.GetSessionFileName()
+	if _, err := os.Stat(filename + ".1"); err != nil {
+		t.Fatalf("expected the previous version to be kept as a backup: %s", err)
+	}
+	if matches, _ := filepath.Glob("." + filename + ".tmp*"); len(matches) > 0 {
+		t.Errorf("unexpected temporary files %v", matches)
+	}
+
+	loaded := &Replacer{Phishing: "phishing.click", Target: "poor.victim"}
+	if err := loaded.Load(); err != nil || !contains(loaded.GetExternalOrigins(), "api.newcdn.net") {
+		t.Fatalf("expected the last version to be loaded, got %v", err)
+	}
+
+	// A corrupted file is replaced by the last good backup
+	if err := ioutil.WriteFile(filename, []byte(`{"Phishing": "phishing.click", "Target": "poor.vic`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	loaded = &Replacer{Phishing: "phishing.click", Target: "poor.victim"}
+	if err := loaded.Load(); err != nil || len(loaded.GetExternalOrigins()) != 5 {
+		t.Errorf("expected the backup to be loaded, got %v with %v", err, loaded.GetExternalOrigins())
+	}
+
+	// The rules of another target are not loaded
+	loaded = &Replacer{Phishing: "phishing.click", Target: "other.victim"}
+	if err := os.Rename(filename, loaded.GetSessionFileName()); err != nil {
+		t.Fatal(err)
+	}
+	if err := loaded.Load(); err == nil {
+		t.Error("expected the rules of another target to be rejected")
+	}
+
+	for i := 0; i < sessionBackups+2; i++ {
+		if err := r.Save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := os.Stat(fmt.Sprintf("%s.%d", filename, sessionBackups+1)); !os.IsNotExist(err) {
+		t.Errorf("expected no more than %d backups", sessionBackups)
+	}
+}
+
+func TestReplacerStateDirectory(t *testing.T) {
+	inTempDir(t)
+
+	// The session of a previous release is loaded from the working directory, then saved in the state directory
+	legacy := benchmarkReplacer(3)
+	if err := saveToJSON(legacy.legacySessionFileName(), legacy); err != nil {
+		t.Fatal(err)
+	}
+
+	r := &Replacer{Phishing: "phishing.click", Target: "poor.victim", stateDir: filepath.Join("state", "campaign")}
+	if err := r.Load(); err != nil || len(r.GetExternalOrigins()) != 3 {
+		t.Fatalf("expected the legacy session to be loaded, got %v", err)
+	}
+	if err := r.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	filename := filepath.Join("state", "campaign", "phishing.click_poor.victim.session.json")
+	if r.GetSessionFileName() != filename {
+		t.Errorf("unexpected session file name %s", r.GetSessionFileName())
+	}
+	if _, err := os.Stat(filename); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another campaign of the same target does not load, nor clobber, the state of the first one
+	other := &Replacer{Phishing: "other.click", Target: "poor.victim", stateDir: r.stateDir}
+	if err := other.Load(); err == nil {
+		t.Error("expected the session of another phishing domain to be rejected")
+	}
+	if other.GetSessionFileName() == filename {
+		t.Errorf("expected the campaigns to be saved in distinct files")
+	}
+}
+
+// pairs returns the sorted replacement pairs
+func pairs(replacements []string) []string {
+	var p []string
+	for i := 0; i+1 < len(replacements); i += 2 {
+		p = append(p, replacements[i]+">"+replacements[i+1])
+	}
+	sort.Strings(p)
+	return p
+}
+
+func BenchmarkMakeReplacements(b *testing.B) {
+	inTempDir(b)
+	for _, n := range []int{10, 100, 500} {
+		b.Run(fmt.Sprintf("origins=%d", n), func(b *testing.B) {
+			r := benchmarkReplacer(n)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				r.MakeReplacements()
+			}
+		})
+	}
+}
+
+func BenchmarkSetExternalOrigins(b *testing.B) {
+	r := benchmarkReplacer(500)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// Start over every 256 origins, so that the results do not depend on b.N
+		if i%256 == 255 {
+			b.StopTimer()
+			r = benchmarkReplacer(500)
+			b.StartTimer()
+		}
+		r.SetExternalOrigins([]string{fmt.Sprintf("static%d.discovered.net", i)})
+	}
+}
+
+func BenchmarkTransformContent(b *testing.B) {
+	inTempDir(b)
+	for _, n := range []int{10, 100, 500} {
+		r := benchmarkReplacer(n)
+		for _, c := range benchmarkCorpora {
+			body := []byte(c.corpus(n))
+			b.Run(fmt.Sprintf("%s/origins=%d", c.name, n), func(b *testing.B) {
+				// The first pass discovers the subdomains of the wildcards, adding them as origins
+				r.TransformContent(body, Backward, c.contentType)
+
+				b.SetBytes(int64(len(body)))
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					r.TransformContent(body, Backward, c.contentType)
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkTransformRequest(b *testing.B) {
+	inTempDir(b)
+	r := benchmarkReplacer(100)
+	form := []byte(strings.Repeat("redirect_uri=https%3A%2F%2Fext1.phishing.click%2Fcallback&login=victim%40poor.victim&", 200))
+
+	b.SetBytes(int64(len(form)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.TransformContent(form, Forward, "application/x-www-form-urlencoded")
+	}
+}
+
+func BenchmarkTransformParallel(b *testing.B) {
+	inTempDir(b)
+	r := benchmarkReplacer(100)
+	body := []byte(jsonCorpus(100))
+	r.TransformContent(body, Backward, "application/json")
+
+	b.SetBytes(int64(len(body)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			r.TransformContent(body, Backward, "application/json")
+		}
+	})
+}
+
+func TestReplacerConfigure(t *testing.T) {
+	for _, c := range []struct {
+		name      string
+		prefix    string
+		origins   []string
+		mapped    map[string]string
+		wildcards map[string]string
+		// transform is the backward transformation of a value, and its expected result
+		transform [2]string
+	}{
+		{
+			name:      "external origins",
+			origins:   []string{"cdn.net", "static.other.org"},
+			mapped:    map[string]string{"cdn.net": "ext1", "static.other.org": "ext2"},
+			transform: [2]string{"https://static.other.org/app.js", "https://ext2.phishing.click/app.js"},
+		},
+		{
+			name:      "subdomains of the target",
+			origins:   []string{"www.poor.victim", "a.b.poor.victim"},
+			mapped:    map[string]string{"a.b.poor.victim": "ext1"},
+			transform: [2]string{"https://www.poor.victim/", "https://www.phishing.click/"},
+		},
+		{
+			name:      "wildcards numbered apart",
+			origins:   []string{"*.a.net", "b.net", "*.c.net"},
+			mapped:    map[string]string{"b.net": "ext1"},
+			wildcards: map[string]string{"a.net": "extwld1", "c.net": "extwld2"},
+		},
+		{
+			name:    "duplicated origins",
+			origins: []string{"CDN.net", "https://cdn.net/lib.js", ".cdn.net"},
+			mapped:  map[string]string{"cdn.net": "ext1"},
+		},
+		{
+			name:      "internationalized origins",
+			origins:   []string{"bücher.example"},
+			mapped:    map[string]string{"xn--bcher-kva.example": "ext1"},
+			transform: [2]string{"//bücher.example/", "//ext1.phishing.click/"},
+		},
+		{
+			name:      "custom prefix",
+			prefix:    "res-",
+			origins:   []string{"cdn.net", "*.img.net"},
+			mapped:    map[string]string{"cdn.net": "res-1"},
+			wildcards: map[string]string{"img.net": "res-wld1"},
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			prefix := c.prefix
+			if prefix == "" {
+				prefix = "ext"
+			}
+
+			r := &Replacer{}
+			err := r.configure(ReplacerConfig{
+				Phishing:             "phishing.click",
+				Target:               "poor.victim",
+				ExternalOriginPrefix: prefix,
+				ExternalOrigins:      c.origins,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if mapped := r.GetOrigins(); fmt.Sprint(mapped) != fmt.Sprint(c.mapped) {
+				t.Errorf("expected the origins %v, got %v", c.mapped, mapped)
+			}
+			if wildcards := r.GetWildcardMapping(); fmt.Sprint(wildcards) != fmt.Sprint(c.wildcards) {
+				t.Errorf("expected the wildcards %v, got %v", c.wildcards, wildcards)
+			}
+			if c.transform[0] != "" {
+				if got := r.Transform(c.transform[0], false, r.Base64); got != c.transform[1] {
+					t.Errorf("expected %s to be transformed to %s, got %s", c.transform[0], c.transform[1], got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,240 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"github.com/muraenateam/muraena/log"
+	"github.com/muraenateam/muraena/session"
+)
+
+// Resolver resolves upstream hostnames to IP addresses.
+// net.Resolver satisfies this interface.
+type Resolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// staticResolver resolves hostnames using a static resolution table,
+// falling back to the next Resolver for unknown hosts.
+type staticResolver struct {
+	hosts    map[string][]net.IPAddr
+	fallback Resolver
+}
+
+// LookupIPAddr returns the pinned addresses of host, if any, otherwise it queries the fallback Resolver.
+func (s *staticResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	if addrs, ok := s.hosts[strings.ToLower(strings.TrimSuffix(host, "."))]; ok {
+		return addrs, nil
+	}
+
+	return s.fallback.LookupIPAddr(ctx, host)
+}
+
+// dohResolver resolves hostnames using DNS-over-HTTPS (RFC 8484).
+type dohResolver struct {
+	endpoint string
+	client   *http.Client
+}
+
+// LookupIPAddr queries the DoH endpoint for both A and AAAA records of host.
+func (d *dohResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	var addrs []net.IPAddr
+	var lastErr error
+
+	for _, qtype := range []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA} {
+		res, err := d.query(ctx, host, qtype)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		addrs = append(addrs, res...)
+	}
+
+	if len(addrs) == 0 {
+		if lastErr == nil {
+			lastErr = fmt.Errorf("no such host")
+		}
+		return nil, &net.DNSError{Err: lastErr.Error(), Name: host, Server: d.endpoint}
+	}
+
+	return addrs, nil
+}
+
+func (d *dohResolver) query(ctx context.Context, host string, qtype dnsmessage.Type) (addrs []net.IPAddr, err error) {
+	name, err := dnsmessage.NewName(dnsFQDN(host))
+	if err != nil {
+		return
+	}
+
+	msg := dnsmessage.Message{
+		Header: dnsmessage.Header{RecursionDesired: true},
+		Questions: []dnsmessage.Question{
+			{Name: name, Type: qtype, Class: dnsmessage.ClassINET},
+		},
+	}
+
+	packed, err := msg.Pack()
+	if err != nil {
+		return
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.endpoint, bytes.NewReader(packed))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("DoH server replied with %s", resp.Status)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return
+	}
+
+	var answer dnsmessage.Message
+	if err = answer.Unpack(body); err != nil {
+		return
+	}
+
+	if answer.RCode != dnsmessage.RCodeSuccess {
+		return nil, fmt.Errorf("DoH query failed: %s", answer.RCode)
+	}
+
+	for _, rr := range answer.Answers {
+		switch res := rr.Body.(type) {
+		case *dnsmessage.AResource:
+			addrs = append(addrs, net.IPAddr{IP: net.IP(res.A[:])})
+		case *dnsmessage.AAAAResource:
+			addrs = append(addrs, net.IPAddr{IP: net.IP(res.AAAA[:])})
+		}
+	}
+
+	return
+}
+
+// dnsFQDN returns host as a fully qualified domain name.
+func dnsFQDN(host string) string {
+	if strings.HasSuffix(host, ".") {
+		return host
+	}
+	return host + "."
+}
+
+// NewResolver returns the Resolver defined in the configuration.
+// If a static resolution table is configured, it takes precedence over the selected resolver.
+func NewResolver(sess *session.Session) Resolver {
+	config := sess.Config.Resolver
+	timeout := time.Duration(config.Timeout) * time.Second
+
+	var resolver Resolver
+	switch config.Type {
+	case "doh":
+		resolver = &dohResolver{
+			endpoint: config.Server,
+			client: &http.Client{
+				Timeout: timeout,
+				// The DoH endpoint itself must not be resolved through the DoH resolver
+				Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, ForceAttemptHTTP2: true},
+			},
+		}
+
+	case "dot":
+		server := config.Server
+		serverName, _, err := net.SplitHostPort(server)
+		if err != nil {
+			serverName = server
+		}
+
+		// When the connection is not a net.PacketConn the Go resolver uses TCP framing,
+		// which is the wire format expected by DNS-over-TLS (RFC 7858).
+		resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+				dialer := &tls.Dialer{
+					NetDialer: &net.Dialer{Timeout: timeout},
+					Config:    &tls.Config{ServerName: serverName},
+				}
+				return dialer.DialContext(ctx, "tcp", server)
+			},
+		}
+
+	default:
+		resolver = net.DefaultResolver
+	}
+
+	if len(config.Hosts) == 0 {
+		return resolver
+	}
+
+	static := &staticResolver{hosts: make(map[string][]net.IPAddr), fallback: resolver}
+	for host, value := range config.Hosts {
+		host = strings.ToLower(strings.TrimSuffix(host, "."))
+		for _, ip := range strings.Split(value, ",") {
+			addr := net.ParseIP(strings.TrimSpace(ip))
+			if addr == nil {
+				log.Warning("Invalid IP address %s for host %s in resolver hosts table", ip, host)
+				continue
+			}
+			static.hosts[host] = append(static.hosts[host], net.IPAddr{IP: addr})
+		}
+	}
+
+	return static
+}
+
+// resolvingDialer dials upstream connections resolving hostnames via a Resolver.
+type resolvingDialer struct {
+	Resolver Resolver
+	Dialer   *net.Dialer
+}
+
+// DialContext connects to the address on the named network, resolving the host via the Resolver.
+// Each resolved address is tried in order until one succeeds.
+func (d *resolvingDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		return d.Dialer.DialContext(ctx, network, address)
+	}
+
+	addrs, err := d.Resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	var lastErr error
+	for _, addr := range addrs {
+		conn, err := d.Dialer.DialContext(ctx, network, net.JoinHostPort(addr.IP.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+	}
+
+	if lastErr == nil {
+		lastErr = &net.DNSError{Err: "no such host", Name: host}
+	}
+
+	return nil, lastErr
+}
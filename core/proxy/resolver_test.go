@@ -0,0 +1,213 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"github.com/muraenateam/muraena/session"
+)
+
+// fakeResolver answers the lookups from a table, counting them
+type fakeResolver struct {
+	hosts   map[string][]net.IPAddr
+	lookups int
+}
+
+func (f *fakeResolver) LookupIPAddr(_ context.Context, host string) ([]net.IPAddr, error) {
+	f.lookups++
+	if addrs, ok := f.hosts[host]; ok {
+		return addrs, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: host}
+}
+
+// dohServer answers the DoH queries of poor.victim with an A and an AAAA record, and NXDOMAIN otherwise
+func dohServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/dns-message" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		body, _ := ioutil.ReadAll(r.Body)
+		var query dnsmessage.Message
+		if err := query.Unpack(body); err != nil || len(query.Questions) != 1 {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		q := query.Questions[0]
+		answer := dnsmessage.Message{
+			Header:    dnsmessage.Header{ID: query.ID, Response: true, RCode: dnsmessage.RCodeSuccess},
+			Questions: query.Questions,
+		}
+		header := dnsmessage.ResourceHeader{Name: q.Name, Type: q.Type, Class: dnsmessage.ClassINET, TTL: 60}
+
+		switch {
+		case q.Name.String() != "poor.victim.":
+			answer.RCode = dnsmessage.RCodeNameError
+		case q.Type == dnsmessage.TypeA:
+			answer.Answers = append(answer.Answers, dnsmessage.Resource{Header: header,
+				Body: &dnsmessage.AResource{A: [4]byte{192, 0, 2, 10}}})
+		case q.Type == dnsmessage.TypeAAAA:
+			answer.Answers = append(answer.Answers, dnsmessage.Resource{Header: header,
+				Body: &dnsmessage.AAAAResource{AAAA: [16]byte{0x20, 0x01, 0x0d, 0xb8, 15: 0x10}}})
+		}
+
+		packed, err := answer.Pack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/dns-message")
+		w.Write(packed)
+	}))
+}
+
+func TestDoHResolver(t *testing.T) {
+	server := dohServer(t)
+	defer server.Close()
+
+	d := &dohResolver{endpoint: server.URL, client: server.Client()}
+
+	addrs, err := d.LookupIPAddr(context.Background(), "poor.victim")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addrs) != 2 || addrs[0].IP.String() != "192.0.2.10" || addrs[1].IP.String() != "2001:db8::10" {
+		t.Errorf("unexpected addresses %v", addrs)
+	}
+
+	_, err = d.LookupIPAddr(context.Background(), "unknown.victim")
+	var dnsErr *net.DNSError
+	if !errors.As(err, &dnsErr) || dnsErr.Name != "unknown.victim" {
+		t.Errorf("expected a DNS error, got %v", err)
+	}
+}
+
+func TestDoHResolver_ServerError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	d := &dohResolver{endpoint: server.URL, client: server.Client()}
+	if _, err := d.LookupIPAddr(context.Background(), "poor.victim"); err == nil {
+		t.Error("expected an error when the DoH server fails")
+	}
+}
+
+func TestNewResolver_Hosts(t *testing.T) {
+	sess := &session.Session{Config: &session.Configuration{}}
+	sess.Config.Resolver.Hosts = map[string]string{
+		"Login.Poor.Victim.": "192.0.2.1, 2001:db8::1",
+		"api.poor.victim":    "not-an-ip,192.0.2.2",
+	}
+
+	static, ok := NewResolver(sess).(*staticResolver)
+	if !ok {
+		t.Fatal("expected the static resolver")
+	}
+	fallback := &fakeResolver{hosts: map[string][]net.IPAddr{"cdn.poor.victim": {{IP: net.ParseIP("192.0.2.3")}}}}
+	static.fallback = fallback
+
+	for _, c := range []struct {
+		host     string
+		expected []string
+	}{
+		{"login.poor.victim", []string{"192.0.2.1", "2001:db8::1"}},
+		{"LOGIN.poor.victim.", []string{"192.0.2.1", "2001:db8::1"}},
+		{"api.poor.victim", []string{"192.0.2.2"}},
+		{"cdn.poor.victim", []string{"192.0.2.3"}},
+	} {
+		addrs, err := static.LookupIPAddr(context.Background(), c.host)
+		if err != nil {
+			t.Errorf("%s: %s", c.host, err)
+			continue
+		}
+		if len(addrs) != len(c.expected) {
+			t.Errorf("%s: expected %v, got %v", c.host, c.expected, addrs)
+			continue
+		}
+		for i := range addrs {
+			if addrs[i].IP.String() != c.expected[i] {
+				t.Errorf("%s: expected %v, got %v", c.host, c.expected, addrs)
+			}
+		}
+	}
+
+	if fallback.lookups != 1 {
+		t.Errorf("expected only the unknown host to reach the fallback, got %d lookups", fallback.lookups)
+	}
+
+	sess.Config.Resolver.Hosts = nil
+	if NewResolver(sess) != net.DefaultResolver {
+		t.Error("expected the system resolver without a hosts table")
+	}
+}
+
+func TestResolvingDialer(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	// A closed port, so that the first address fails and the dialer falls back to the next one
+	closed, err := net.Listen("tcp", "127.0.0.2:0")
+	if err != nil {
+		t.Skip("127.0.0.2 is not available")
+	}
+	closed.Close()
+
+	resolver := &fakeResolver{hosts: map[string][]net.IPAddr{
+		"poor.victim":   {{IP: net.ParseIP("127.0.0.2")}, {IP: net.ParseIP("127.0.0.1")}},
+		"closed.victim": {{IP: net.ParseIP("127.0.0.2")}},
+	}}
+	d := &resolvingDialer{Resolver: resolver, Dialer: &net.Dialer{Timeout: time.Second}}
+
+	conn, err := d.DialContext(context.Background(), "tcp", net.JoinHostPort("poor.victim", port))
+	if err != nil {
+		t.Fatalf("expected the dialer to fall back to the second address: %s", err)
+	}
+	if conn.RemoteAddr().String() != listener.Addr().String() {
+		t.Errorf("unexpected remote address %s", conn.RemoteAddr())
+	}
+	conn.Close()
+
+	// The IP addresses are dialed without a lookup
+	lookups := resolver.lookups
+	conn, err = d.DialContext(context.Background(), "tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if resolver.lookups != lookups {
+		t.Error("expected no lookup for an IP address")
+	}
+
+	if _, err = d.DialContext(context.Background(), "tcp", net.JoinHostPort("closed.victim", port)); err == nil {
+		t.Error("expected an error when no address accepts the connection")
+	}
+	if _, err = d.DialContext(context.Background(), "tcp", net.JoinHostPort("unknown.victim", port)); err == nil {
+		t.Error("expected an error for an unknown host")
+	}
+}
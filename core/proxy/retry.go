@@ -0,0 +1,157 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/muraenateam/muraena/log"
+	"github.com/muraenateam/muraena/session"
+)
+
+// maxRetryTokens bounds the retries of a burst of failures, whatever the budget accumulated before it
+const maxRetryTokens = 10
+
+// upstreamRetries retries the idempotent requests failing on transient upstream errors, such as flaky CDN edges:
+// the connection resets and the 502 and 503 responses. The retries are spaced by an exponential backoff
+// with full jitter, and limited by a budget: each request earns a fraction of a retry, each retry spends one.
+type upstreamRetries struct {
+	attempts int
+	backoff  time.Duration
+	ratio    float64
+
+	mu     sync.Mutex
+	tokens float64
+}
+
+// retries is the retry policy of the upstream requests, nil if disabled
+var retries *upstreamRetries
+
+// newUpstreamRetries returns the retry policy defined in the configuration, nil if disabled
+func newUpstreamRetries(sess *session.Session) *upstreamRetries {
+	config := sess.Config.Proxy.Retry
+	if !config.Enabled || config.Attempts <= 0 {
+		return nil
+	}
+
+	return &upstreamRetries{
+		attempts: config.Attempts,
+		backoff:  time.Duration(config.Backoff) * time.Millisecond,
+		ratio:    float64(config.Budget) / 100,
+		tokens:   maxRetryTokens,
+	}
+}
+
+// earn credits the budget with the fraction of a retry earned by a request
+func (r *upstreamRetries) earn() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.tokens += r.ratio; r.tokens > maxRetryTokens {
+		r.tokens = maxRetryTokens
+	}
+}
+
+// spend withdraws a retry from the budget, returning false if it is exhausted
+func (r *upstreamRetries) spend() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.tokens < 1 {
+		return false
+	}
+	r.tokens--
+	return true
+}
+
+// delay returns the jittered backoff before the retry
+func (r *upstreamRetries) delay(attempt int) time.Duration {
+	ceiling := r.backoff << uint(attempt)
+	if ceiling <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(ceiling)))
+}
+
+// retryable checks if the request can be sent again: the safe methods, or any request
+// with an Idempotency-Key, as long as there is no body to replay
+func retryable(req *http.Request) bool {
+	if req.Body != nil && req.Body != http.NoBody && req.ContentLength != 0 {
+		return false
+	}
+
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return req.Header.Get("Idempotency-Key") != ""
+}
+
+// transient checks if the upstream failure is worth a retry
+func transient(resp *http.Response, err error) bool {
+	if err != nil {
+		return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) ||
+			errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+	}
+	return resp.StatusCode == http.StatusBadGateway || resp.StatusCode == http.StatusServiceUnavailable
+}
+
+// retryTransport sends again the idempotent requests failing on transient upstream errors
+type retryTransport struct {
+	retries *upstreamRetries
+	next    http.RoundTripper
+}
+
+// RoundTrip implements the http.RoundTripper interface
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.retries.earn()
+
+	resp, err := t.next.RoundTrip(req)
+	if !retryable(req) {
+		return resp, err
+	}
+
+	for attempt := 0; attempt < t.retries.attempts && transient(resp, err); attempt++ {
+		if !t.retries.spend() {
+			log.Debug("Retry budget exhausted, not retrying %s %s", req.Method, req.URL)
+			break
+		}
+
+		reason := ""
+		if err != nil {
+			reason = err.Error()
+		} else {
+			reason = resp.Status
+		}
+
+		if !sleepContext(req.Context(), t.retries.delay(attempt)) {
+			break
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+
+		log.Debug("Retrying %s %s (%d/%d): %s", req.Method, req.URL, attempt+1, t.retries.attempts, reason)
+		resp, err = t.next.RoundTrip(req)
+	}
+
+	return resp, err
+}
+
+// sleepContext waits for the duration, returning false if the context is done in the meantime
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
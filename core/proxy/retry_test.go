@@ -0,0 +1,55 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestRetryTransport(t *testing.T) {
+	calls := 0
+	upstream := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		calls++
+		switch calls {
+		case 1:
+			return nil, syscall.ECONNRESET
+		case 2:
+			return syntheticResponse(req, http.StatusBadGateway, nil), nil
+		}
+		return syntheticResponse(req, http.StatusOK, nil), nil
+	})
+
+	r := &upstreamRetries{attempts: 2, ratio: 0.2, tokens: maxRetryTokens}
+	transport := &retryTransport{retries: r, next: upstream}
+
+	resp, err := transport.RoundTrip(httptest.NewRequest(http.MethodGet, "https://cdn.target.tld/app.js", nil))
+	if err != nil || resp.StatusCode != http.StatusOK || calls != 3 {
+		t.Errorf("expected a success after 2 retries, got %v %v after %d calls", resp, err, calls)
+	}
+
+	calls = 0
+	post := httptest.NewRequest(http.MethodPost, "https://target.tld/login", strings.NewReader("user=victim"))
+	if _, err := transport.RoundTrip(post); err == nil || calls != 1 {
+		t.Errorf("expected a POST not to be retried, got %v after %d calls", err, calls)
+	}
+
+	calls = 0
+	r.tokens = 0.5
+	if _, err := transport.RoundTrip(httptest.NewRequest(http.MethodGet, "https://target.tld/", nil)); err == nil || calls != 1 {
+		t.Errorf("expected the exhausted budget to prevent retries, got %v after %d calls", err, calls)
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	del := httptest.NewRequest(http.MethodDelete, "https://target.tld/item/1", nil)
+	if retryable(del) {
+		t.Error("expected a DELETE without Idempotency-Key not to be retried")
+	}
+
+	del.Header.Set("Idempotency-Key", "8e03978e")
+	if !retryable(del) {
+		t.Error("expected a DELETE with Idempotency-Key to be retried")
+	}
+}
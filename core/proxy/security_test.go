@@ -0,0 +1,48 @@
+package proxy
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/muraenateam/muraena/session"
+)
+
+func TestApplySecurityHeaders(t *testing.T) {
+	const hsts = "max-age=63072000; includeSubDomains; preload"
+
+	for _, tc := range []struct {
+		name        string
+		mode        string
+		keepPinning bool
+		hsts        string
+		want        string
+	}{
+		{"keep", "", false, hsts, hsts},
+		{"remove", "remove", false, hsts, ""},
+		{"rewrite", "Rewrite", false, hsts, "max-age=63072000"},
+		{"rewrite without max-age", "rewrite", false, "includeSubDomains", ""},
+		{"keep pinning", "", true, hsts, hsts},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			sess := &session.Session{Config: &session.Configuration{}}
+			sess.Config.Transform.Response.Security.HSTS = tc.mode
+			sess.Config.Transform.Response.Security.KeepPinning = tc.keepPinning
+
+			response := &http.Response{Header: http.Header{}}
+			response.Header.Set("Strict-Transport-Security", tc.hsts)
+			response.Header.Set("Expect-CT", `max-age=86400, enforce, report-uri="https://poor.victim/ct"`)
+			response.Header.Set("Alt-Svc", `h3="alt.poor.victim:443"`)
+
+			applySecurityHeaders(sess, response)
+
+			if got := response.Header.Get("Strict-Transport-Security"); got != tc.want {
+				t.Errorf("Strict-Transport-Security: got %q, want %q", got, tc.want)
+			}
+			for _, header := range []string{"Expect-CT", "Alt-Svc"} {
+				if kept := response.Header.Get(header) != ""; kept != tc.keepPinning {
+					t.Errorf("%s kept %v, want %v", header, kept, tc.keepPinning)
+				}
+			}
+		})
+	}
+}
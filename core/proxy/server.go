@@ -1,12 +1,17 @@
 package proxy
 
 import (
+	"bufio"
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/evilsocket/islazy/tui"
 
@@ -23,6 +28,9 @@ type tlsServer struct {
 	Key      string
 	CertPool string
 
+	// Additional certificates selected via SNI
+	SNICertificates []session.ListenerCertificate
+
 	Config *tls.Config
 }
 
@@ -31,7 +39,11 @@ type muraenaServer struct {
 	NetListener net.Listener
 }
 
-func (server *tlsServer) serveTLS(sslkeylog string) (err error) {
+// proxyMux routes the requests of the listeners. It is not the default one,
+// where net/http/pprof and expvar register their handlers.
+var proxyMux = http.NewServeMux()
+
+func (server *tlsServer) serveTLS(keyLog io.Writer) (err error) {
 
 	// Panic recovery
 	defer func() {
@@ -45,6 +57,25 @@ func (server *tlsServer) serveTLS(sslkeylog string) (err error) {
 		return err
 	}
 
+	if len(server.SNICertificates) > 0 {
+		certificates := make(map[string]*tls.Certificate)
+		for _, c := range server.SNICertificates {
+			cert, err := tls.X509KeyPair([]byte(c.CertificateContent), []byte(c.KeyContent))
+			if err != nil {
+				return err
+			}
+
+			for _, hostname := range c.Hostnames {
+				certificates[strings.ToLower(hostname)] = &cert
+			}
+		}
+
+		defaultCertificate := &server.Config.Certificates[0]
+		server.Config.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return selectCertificate(certificates, hello.ServerName, defaultCertificate), nil
+		}
+	}
+
 	if server.CertPool != "" { // needed only for custom CAs
 		certpool := x509.NewCertPool()
 		if !certpool.AppendCertsFromPEM([]byte(server.CertPool)) {
@@ -53,24 +84,51 @@ func (server *tlsServer) serveTLS(sslkeylog string) (err error) {
 		server.Config.ClientCAs = certpool
 	}
 
-	if sslkeylog != "" {
-		// Open the file in append mode, create it if it doesn't exist, with write-only permissions
-		f, err := os.OpenFile(sslkeylog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-		// defer f.Close() // Ensure file is closed when function exits
-
-		if err != nil {
-			log.Error(err.Error())
-		} else {
-			_, _ = fmt.Fprintf(f, "# SSL/TLS secrets log file, generated by Muraena\n")
-			server.Config.KeyLogWriter = f
-		}
+	if keyLog != nil {
+		server.Config.KeyLogWriter = keyLog
 	}
 
 	tlsListener := tls.NewListener(server.NetListener, server.Config)
 	return server.Serve(tlsListener)
 }
 
+// openKeyLog opens the SSL/TLS secrets log file, in append mode, nil if none or if it cannot be opened.
+// It is opened before the sandbox, which would prevent its creation.
+func openKeyLog(sslkeylog string) io.Writer {
+	if sslkeylog == "" {
+		return nil
+	}
+
+	f, err := os.OpenFile(sslkeylog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		log.Error(err.Error())
+		return nil
+	}
+
+	_, _ = fmt.Fprintf(f, "# SSL/TLS secrets log file, generated by Muraena\n")
+	return f
+}
+
+// selectCertificate returns the certificate matching the SNI server name.
+// Exact hostnames take precedence over wildcards, falling back to the default certificate.
+func selectCertificate(certificates map[string]*tls.Certificate, serverName string, fallback *tls.Certificate) *tls.Certificate {
+	serverName = strings.ToLower(strings.TrimSuffix(serverName, "."))
+	if cert, ok := certificates[serverName]; ok {
+		return cert
+	}
+
+	if i := strings.Index(serverName, "."); i > 0 {
+		if cert, ok := certificates["*"+serverName[i:]]; ok {
+			return cert
+		}
+	}
+
+	return fallback
+}
+
 var replacer *Replacer
+var upstreamDialer *resolvingDialer
+var listenerOffenders *offenders
 
 func Run(sess *session.Session) {
 
@@ -80,10 +138,171 @@ func Run(sess *session.Session) {
 		log.Fatal(err.Error())
 	}
 
+	session.RegisterStats("replacer", replacer.Stats)
+	if replacer.shared != nil {
+		go replacer.followCluster(time.Duration(sess.Config.Cluster.Interval) * time.Second)
+	}
+
+	// Proxies fronting the listener, carrying the address of the victims
+	trustedProxies = sess.TrustedProxies()
+	anonymizer = sess.IPAnonymizer()
+
+	// Tracing of the proxy pipeline
+	tracing = newTracer(sess)
+	if tracing != nil {
+		go tracing.export()
+	}
+
+	// JSON transformation rules
+	rules, err := newJSONRules(sess)
+	if err != nil {
+		log.Fatal("%s", err)
+	}
+	jsonRules = rules
+
+	// Binaries and signed payloads left untouched
+	if protected, err = newProtection(sess); err != nil {
+		log.Fatal("%s", err)
+	}
+
+	// CORS policy of the permissive paths
+	if corsPolicy, err = newCrossOrigin(sess); err != nil {
+		log.Fatal("%s", err)
+	}
+
+	// gRPC-web methods
+	if grpcMethods, err = newGRPCMethods(sess); err != nil {
+		log.Fatal("%s", err)
+	}
+
+	// Rewrite cache of static assets
+	rewrites = newRewriteCache(sess)
+
+	// Worker pool of the large body transformations
+	transformers = newTransformPool(sess)
+
+	// Base64 blobs embedded in the responses
+	embedded = newEmbeddedBase64(sess)
+
+	// URL literals of the runtime loaded modules, workers and WebAssembly binaries
+	modules = newModuleURLs(sess)
+
+	// Dry run of the operator browsing the target
+	dryRunner = newDryRun(sess)
+	if dryRunner != nil {
+		replacer.observe = dryRunner.Replaced
+		log.Important("Dry run: only %v are served, the report is written to %s",
+			sess.Config.DryRun.Allow, tui.Bold(sess.Config.DryRun.Report))
+	}
+
+	// Requests for the hosts outside of the phishing domain
+	unknownHosts = newUnknownHost(sess)
+
+	// A/B variants of the responses
+	variants = newResponseVariants(sess)
+
+	// Handling of the client classes
+	if clients, err = newClientRules(sess); err != nil {
+		log.Fatal("%s", err)
+	}
+
+	// CSRF tokens of the victims altered by the rewrite of the responses
+	csrf = newCSRFTokens(sess)
+	if csrf != nil {
+		session.RegisterStats("csrf", csrf.Stats)
+	}
+
+	// Origins the enrichment webhook routes the victims to
+	routingProfiles = newRoutingProfiles(sess)
+
+	// Awareness training answering the credential submissions
+	if trainer, err = newTraining(sess); err != nil {
+		log.Fatal("%s", err)
+	}
+
+	// Operator session comparing the rewritten pages with the original ones
+	if operators, err = newOperatorSession(sess); err != nil {
+		log.Fatal("%s", err)
+	}
+
+	// Upstream cache of static assets
+	assets = newUpstreamCache(sess)
+	if assets != nil {
+		serveCacheAdmin(sess)
+	}
+
+	// Retries of the transient upstream failures
+	retries = newUpstreamRetries(sess)
+
+	// Circuit breaker of the target
+	if breaker, err = newCircuitBreaker(sess); err != nil {
+		log.Fatal("%s", err)
+	}
+
+	// Upstream queue of the victims
+	queue = newUpstreamQueue(sess)
+
+	// Brand assets served from the local cache
+	brand = newBrandAssets(sess)
+
+	// Relay of the victim submissions
+	if relays, err = newRelay(sess); err != nil {
+		log.Fatal("%s", err)
+	}
+	if relays != nil {
+		serveRelay(sess)
+	}
+
+	// Tap mirroring the traffic to an external analyzer
+	if taps, err = newTap(sess); err != nil {
+		log.Fatal("%s", err)
+	}
+	if taps != nil {
+		go taps.run()
+		session.RegisterStats("tap", taps.Stats)
+	}
+
+	// Panic endpoint wiping the captured data
+	servePanic(sess)
+
+	// Kill switch published by the operator
+	kill = newKillSwitch(sess)
+	if kill != nil {
+		go kill.watch(sess)
+	}
+
+	// Health check and readiness endpoints
+	serveHealth(sess)
+
+	// Runtime diagnostics
+	serveDiagnostics(sess)
+
+	// Load the upstream resolver
+	upstreamDialer = &resolvingDialer{
+		Resolver: NewResolver(sess),
+		Dialer:   newDialer(sess),
+	}
+	if sess.Config.Resolver.Type != "system" || len(sess.Config.Resolver.Hosts) > 0 {
+		log.Info("Upstream resolver: %s %s (%d pinned hosts)", tui.Green(sess.Config.Resolver.Type),
+			sess.Config.Resolver.Server, len(sess.Config.Resolver.Hosts))
+	}
+
+	// Watch of the target pages, once the upstream resolver is loaded
+	watcher, err := newTargetWatch(sess)
+	if err != nil {
+		log.Fatal("%s", err)
+	}
+	if watcher != nil {
+		go watcher.watch()
+	}
+
+	limits := NewRequestLimits(sess)
+	listenerOffenders = newOffenders(sess)
+
 	//
 	// start the reverse proxy
 	//
-	http.HandleFunc("/", func(response http.ResponseWriter, request *http.Request) {
+	proxyMux.HandleFunc("/", func(response http.ResponseWriter, request *http.Request) {
 
 		// Defer the recovery function in case of panic
 		defer func() {
@@ -92,8 +311,61 @@ func Run(sess *session.Session) {
 			}
 		}()
 
+		ctx, span := tracing.Start(request.Context(), "receive")
+		defer span.End()
+		if span != nil {
+			request = request.WithContext(ctx)
+			span.Set("http.method", request.Method)
+			span.Set("server.address", request.Host)
+			span.Set("url.path", request.URL.Path)
+			span.Set("client.address", loggedIP(request))
+		}
+
+		if sess.Config.Proxy.RequestValidation.Enabled {
+			if err := ValidateRequest(request, limits); err != nil {
+				log.Warning("[%s] Rejected invalid request %s %s: %s", loggedIP(request), request.Method, request.URL.Path, err)
+				http.Error(response, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+				return
+			}
+		}
+
+		if !limitBody(response, request, sess.Config.Proxy.Limits.MaxBodyBytes, listenerOffenders) {
+			return
+		}
+
+		if request = unknownHosts.Serve(response, request); request == nil {
+			return
+		}
+
+		if kill.Killed() || !sess.Scheduled(time.Now()) {
+			serveDecoy(response, request, sess.Config.Schedule.Decoy)
+			return
+		}
+
+		if dryRunner != nil && !dryRunner.Allow(request) {
+			http.Error(response, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+
+		// The operator requests are neither tracked nor filtered by the watchdog
+		mode := operators.Mode(request)
+		if mode != "" {
+			request = request.WithContext(withOperatorMode(request.Context(), mode))
+		}
+		if operators.Traced(request) {
+			request = request.WithContext(withRewriteTracer(request.Context()))
+		}
+		if trainer != nil && mode == "" {
+			request = request.WithContext(withTrainingSubmission(request.Context()))
+		}
+		if mode == "" {
+			if request = serveClients(response, request, sess.Config.Schedule.Decoy); request == nil {
+				return
+			}
+		}
+
 		// TODO: Configure properly middlewares.
-		if sess.Config.Watchdog.Enabled {
+		if sess.Config.Watchdog.Enabled && mode == "" {
 			m, err := sess.Module("watchdog")
 			if err != nil {
 				log.Error("%s", err)
@@ -101,27 +373,27 @@ func Run(sess *session.Session) {
 
 			wd, ok := m.(*watchdog.Watchdog)
 			if ok {
-				if !wd.Allow(request) {
+				_, hook := tracing.Start(request.Context(), "module.watchdog")
+				allowed := wd.Allow(request)
+				hook.Set("allowed", allowed)
+				hook.End()
+
+				if !allowed {
 					wd.CustomResponse(response, request)
 					return
 				}
 			}
 		}
 
+		if corsPolicy.Preflight(response, request) {
+			return
+		}
+
 		s := &SessionType{Session: sess, Replacer: replacer}
-		s.HandleFood(response, request)
+		taps.Serve(response, request, s.HandleFood)
 	})
 
-	listeningAddress := fmt.Sprintf(`%s:%d`, sess.Config.Proxy.IP, sess.Config.Proxy.Port)
-	netListener, err := net.Listen(sess.Config.Proxy.Listener, listeningAddress)
-	if core.IsError(err) {
-		log.Fatal("%s", err)
-	}
-
-	muraena := &muraenaServer{NetListener: netListener}
-
-	lline := fmt.Sprintf("Muraena is alive on %s \n[ %s ] ==> [ %s ]", tui.Green(listeningAddress), tui.Yellow(sess.Config.Proxy.Phishing), tui.Green(sess.Config.Proxy.Target))
-	log.Info(lline)
+	go handleSignals(sess)
 
 	if *sess.Options.Proxy {
 		// If HTTP_PROXY or HTTPS_PROXY env variables are defined
@@ -140,28 +412,74 @@ func Run(sess *session.Session) {
 		}
 	}
 
-	if sess.Config.TLS.Enabled == false {
-		// HTTP only
-		if err := muraena.Serve(muraena.NetListener); core.IsError(err) {
-			log.Fatal("Error binding Muraena on HTTP: %s", err)
-		}
+	keyLog := openKeyLog(sess.Config.TLS.SSLKeyLog)
+	for _, l := range sess.Config.Proxy.Listeners {
+		serveListener(sess, l, keyLog)
+	}
+
+	// All the sockets are bound, root is not needed anymore
+	if err := sandbox(sess); err != nil {
+		log.Fatal("%s", err)
+	}
+
+	// Wait for the in-flight connections to be drained
+	<-shutdownComplete
+}
+
+// serveDecoy answers the requests received outside of the campaign schedule or once killed,
+// redirecting them to the decoy URL, if any, without reaching the target
+func serveDecoy(w http.ResponseWriter, r *http.Request, decoy string) {
+	log.Debug("[%s] Serving the decoy: %s %s", loggedIP(r), r.Method, r.URL.Path)
+	if decoy == "" {
+		http.NotFound(w, r)
+		return
+	}
+	http.Redirect(w, r, decoy, http.StatusFound)
+}
+
+// serveListener starts serving the victims on the listener
+func serveListener(sess *session.Session, l session.Listener, keyLog io.Writer) {
+	netListener, err := listen(sess, l.Address)
+	if core.IsError(err) {
+		log.Fatal("%s", err)
+	}
+
+	if l.ProxyProtocol {
+		netListener = &proxyProtocolListener{Listener: netListener}
+	}
+
+	limits := sess.Config.Proxy.Limits
+	if limits.MaxConnectionsPerIP > 0 {
+		netListener = newConnectionLimitListener(netListener, limits.MaxConnectionsPerIP, listenerOffenders)
+	}
 
+	muraena := &muraenaServer{NetListener: netListener}
+	registerServer(&muraena.Server)
+	muraena.ReadHeaderTimeout = time.Duration(limits.ReadHeaderTimeout) * time.Second
+	muraena.IdleTimeout = time.Duration(limits.IdleTimeout) * time.Second
+	if sess.Config.Proxy.RequestValidation.Enabled {
+		muraena.MaxHeaderBytes = sess.Config.Proxy.RequestValidation.MaxHeaderBytes
+	}
+
+	var handler http.Handler = proxyMux
+	if l.Mode == "redirect" {
+		handler = RedirectToHTTPS(httpsPort(sess))
+	}
+	muraena.Handler = withHSTS(handler, l.HSTS)
+
+	if l.Mode == "redirect" {
+		log.Info("Redirecting %s to HTTPS", tui.Green(l.Address))
 	} else {
-		// HTTPS
-		if sess.Config.Proxy.HTTPtoHTTPS.Enabled {
-			// redirect HTTP > HTTPS
-			newNetListener, err := net.Listen(sess.Config.Proxy.Listener, fmt.Sprintf("%s:%d", sess.Config.Proxy.IP, sess.Config.Proxy.HTTPtoHTTPS.HTTPport))
-			if core.IsError(err) {
-				log.Fatal("%s", err)
-			}
+		lline := fmt.Sprintf("Muraena is alive on %s \n[ %s ] ==> [ %s ]", tui.Green(l.Address), tui.Yellow(sess.Config.Proxy.Phishing), tui.Green(sess.Config.Proxy.Target))
+		log.Info(lline)
+	}
 
-			server := &http.Server{Handler: RedirectToHTTPS(sess.Config.Proxy.Port)}
-			go func() {
-				err := server.Serve(newNetListener)
-				if err != nil {
-					log.Fatal("Error binding Muraena on HTTP: %s", err)
-				}
-			}()
+	go func() {
+		if !l.TLS {
+			if err := muraena.Serve(muraena.NetListener); core.IsError(err) && err != http.ErrServerClosed {
+				log.Fatal("Error binding Muraena on HTTP: %s", err)
+			}
+			return
 		}
 
 		// Attach TLS configurations to muraena server
@@ -172,11 +490,86 @@ func Run(sess *session.Session) {
 			Key:           cTLS.KeyContent,
 			CertPool:      cTLS.RootContent,
 
-			Config: sess.GetTLSClientConfig(),
+			SNICertificates: cTLS.Listener.Certificates,
+
+			Config: sess.GetTLSServerConfig(),
 		}
 
-		if err := tlsServer.serveTLS(cTLS.SSLKeyLog); core.IsError(err) {
+		if err := tlsServer.serveTLS(keyLog); core.IsError(err) && err != http.ErrServerClosed {
 			log.Fatal("Error binding Muraena on HTTPS: %s", err)
 		}
+	}()
+}
+
+// httpsPort returns the port of the first TLS proxy listener, the redirects target
+func httpsPort(sess *session.Session) int {
+	for _, l := range sess.Config.Proxy.Listeners {
+		if l.TLS && l.Mode == "proxy" {
+			if _, port, err := net.SplitHostPort(l.Address); err == nil {
+				if p, err := strconv.Atoi(port); err == nil {
+					return p
+				}
+			}
+		}
+	}
+
+	return sess.Config.Proxy.Port
+}
+
+// withHSTS adds the Strict-Transport-Security header to the responses, unless already set.
+// Browsers honor it over HTTPS only.
+func withHSTS(next http.Handler, maxAge int) http.Handler {
+	if maxAge <= 0 {
+		return next
+	}
+
+	value := fmt.Sprintf("max-age=%d; includeSubDomains", maxAge)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(&hstsWriter{ResponseWriter: w, value: value}, r)
+	})
+}
+
+// hstsWriter sets the HSTS header when the response headers are written,
+// after the upstream ones have been copied
+type hstsWriter struct {
+	http.ResponseWriter
+	value       string
+	wroteHeader bool
+}
+
+func (w *hstsWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		if w.Header().Get("Strict-Transport-Security") == "" {
+			w.Header().Set("Strict-Transport-Security", w.value)
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *hstsWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap allows http.ResponseController to flush and hijack the connection, i.e. for streaming and WebSockets
+func (w *hstsWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Flush implements http.Flusher
+func (w *hstsWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker
+func (w *hstsWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
 	}
+	return nil, nil, http.ErrNotSupported
 }
@@ -0,0 +1,67 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSelectCertificate(t *testing.T) {
+	fallback := &tls.Certificate{}
+	exact, wildcard, other := &tls.Certificate{}, &tls.Certificate{}, &tls.Certificate{}
+	certificates := map[string]*tls.Certificate{
+		"login.phishing.click": exact,
+		"*.phishing.click":     wildcard,
+		"other.click":          other,
+	}
+
+	for _, c := range []struct {
+		serverName string
+		expected   *tls.Certificate
+	}{
+		{"login.phishing.click", exact},
+		{"LOGIN.Phishing.Click.", exact},
+		{"cdn.phishing.click", wildcard},
+		// The wildcards match a single label
+		{"a.cdn.phishing.click", fallback},
+		{"phishing.click", fallback},
+		{"other.click", other},
+		{"www.other.click", fallback},
+		// Clients not sending SNI get the default certificate
+		{"", fallback},
+	} {
+		if cert := selectCertificate(certificates, c.serverName, fallback); cert != c.expected {
+			t.Errorf("%q: unexpected certificate", c.serverName)
+		}
+	}
+}
+
+func TestOpenKeyLog(t *testing.T) {
+	if openKeyLog("") != nil {
+		t.Error("expected no key log")
+	}
+	if openKeyLog(filepath.Join(t.TempDir(), "missing", "keys.log")) != nil {
+		t.Error("expected no key log when the file cannot be created")
+	}
+
+	path := filepath.Join(t.TempDir(), "keys.log")
+	w := openKeyLog(path)
+	if w == nil {
+		t.Fatal("expected the key log")
+	}
+	defer w.(*os.File).Close()
+
+	if _, err := w.Write([]byte("CLIENT_RANDOM 00 00\n")); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), "# SSL/TLS secrets log file") || !strings.HasSuffix(string(data), "CLIENT_RANDOM 00 00\n") {
+		t.Errorf("unexpected key log %q", data)
+	}
+}
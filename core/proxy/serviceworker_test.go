@@ -0,0 +1,64 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServiceWorkerRegistration(t *testing.T) {
+	for _, tc := range []struct {
+		body   string
+		script string
+		found  bool
+	}{
+		{`navigator.serviceWorker.register("/sw.js", {scope: "/"})`, "/sw.js", true},
+		{"navigator.serviceWorker.register(`/app/worker.js`)", "/app/worker.js", true},
+		{`navigator . serviceWorker . register ( swURL )`, "", true},
+		{`navigator.serviceWorker.getRegistrations()`, "", false},
+	} {
+		match := serviceWorkerRegistration.FindStringSubmatch(tc.body)
+		if (match != nil) != tc.found {
+			t.Errorf("%s: found %v, want %v", tc.body, match != nil, tc.found)
+			continue
+		}
+		if match != nil && match[1] != tc.script {
+			t.Errorf("%s: script %q, want %q", tc.body, match[1], tc.script)
+		}
+	}
+}
+
+func TestServeServiceWorkerShim(t *testing.T) {
+	request := httptest.NewRequest(http.MethodGet, "https://www.poor.victim/sw.js", nil)
+	if isServiceWorkerScript(request) {
+		t.Error("service worker script without the Service-Worker header")
+	}
+	request.Header.Set("Service-Worker", "script")
+	if !isServiceWorkerScript(request) {
+		t.Error("service worker script not detected")
+	}
+
+	response := &http.Response{
+		StatusCode: http.StatusNotModified,
+		Header:     http.Header{},
+		Body:       ioutil.NopCloser(strings.NewReader("")),
+		Request:    request,
+	}
+	response.Header.Set("Content-Encoding", "gzip")
+	response.Header.Set("ETag", `"abc"`)
+
+	serveServiceWorkerShim(response)
+
+	body, _ := ioutil.ReadAll(response.Body)
+	if response.StatusCode != http.StatusOK || string(body) != serviceWorkerShim {
+		t.Errorf("unexpected shim response %d: %s", response.StatusCode, body)
+	}
+	if response.Header.Get("Content-Encoding") != "" || response.Header.Get("ETag") != "" {
+		t.Errorf("stale headers kept: %v", response.Header)
+	}
+	if !strings.Contains(string(body), "registration.unregister()") {
+		t.Error("shim does not unregister the worker")
+	}
+}
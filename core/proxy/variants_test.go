@@ -0,0 +1,33 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/muraenateam/muraena/session"
+)
+
+func TestResponseVariants(t *testing.T) {
+	sess := &session.Session{Config: &session.Configuration{}}
+	sess.Config.Variants = []session.ResponseVariant{
+		{Name: "overlay", Weight: 10, Inject: `<div id="overlay"></div>`, Replace: [][]string{{"Sign in", "Verify your account"}}},
+	}
+	v := newResponseVariants(sess)
+
+	for _, c := range []struct {
+		variant, contentType, body, expected string
+	}{
+		{"overlay", "text/html", "<h1>Sign in</h1></BODY></html>", `<h1>Verify your account</h1><div id="overlay"></div></BODY></html>`},
+		{"overlay", "text/html", "<h1>Sign in</h1>", `<h1>Verify your account</h1><div id="overlay"></div>`},
+		{"overlay", "application/javascript", `title="Sign in"`, `title="Verify your account"`},
+		{session.DefaultVariant, "text/html", "<h1>Sign in</h1></body>", "<h1>Sign in</h1></body>"},
+	} {
+		if body := v[c.variant].Apply(c.contentType, c.body); body != c.expected {
+			t.Errorf("%s %s: expected %q, got %q", c.variant, c.contentType, c.expected, body)
+		}
+	}
+
+	sess.Config.Variants = nil
+	if newResponseVariants(sess) != nil {
+		t.Error("expected no variants")
+	}
+}
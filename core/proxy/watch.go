@@ -0,0 +1,260 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/evilsocket/islazy/tui"
+	"golang.org/x/net/html"
+
+	"github.com/muraenateam/muraena/log"
+	"github.com/muraenateam/muraena/session"
+)
+
+// targetWatch periodically fetches the key pages of the target and alerts when their structure changes:
+// the final status and location, the script URLs, the forms and their fields.
+// Such changes, i.e. a new login flow, are likely to break the configured transformations.
+type targetWatch struct {
+	base      *url.URL
+	paths     []string
+	interval  time.Duration
+	state     string
+	userAgent string
+	client    *http.Client
+
+	mu    sync.Mutex
+	known map[string][]string
+}
+
+// newTargetWatch returns the watch of the target pages defined in the configuration, nil if disabled
+func newTargetWatch(sess *session.Session) (*targetWatch, error) {
+	config := sess.Config.Watch
+	if !config.Enabled {
+		return nil, nil
+	}
+
+	base, err := url.Parse(sess.Config.Proxy.Protocol + sess.Config.Proxy.Target)
+	if err != nil {
+		return nil, fmt.Errorf("invalid watch target: %w", err)
+	}
+
+	w := &targetWatch{
+		base:      base,
+		paths:     config.Paths,
+		interval:  time.Duration(config.Interval) * time.Minute,
+		state:     config.State,
+		userAgent: sess.Config.Transform.Request.UserAgent,
+		known:     make(map[string][]string),
+		client: &http.Client{
+			Timeout: 30 * time.Second,
+			Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				return upstreamTransports.Get(sess, req.URL.Host).RoundTrip(req)
+			}),
+		},
+	}
+
+	if w.state != "" {
+		content, err := ioutil.ReadFile(w.state)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("invalid watch state: %w", err)
+		}
+		if err == nil {
+			if err = json.Unmarshal(content, &w.known); err != nil {
+				return nil, fmt.Errorf("invalid watch state %s: %w", w.state, err)
+			}
+		}
+	}
+
+	return w, nil
+}
+
+// watch checks the pages every interval
+func (w *targetWatch) watch() {
+	for {
+		for _, path := range w.paths {
+			if err := w.check(path); err != nil {
+				log.Warning("Error watching the target page %s: %s", path, err)
+			}
+		}
+
+		time.Sleep(w.interval)
+	}
+}
+
+// check fetches the page and compares its structure with the known one, alerting on changes
+func (w *targetWatch) check(path string) error {
+	ref, err := w.base.Parse(path)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodGet, ref.String(), nil)
+	if err != nil {
+		return err
+	}
+	if w.userAgent != "" {
+		req.Header.Set("User-Agent", w.userAgent)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	page, err := (&Response{Response: resp}).Unpack()
+	if err != nil {
+		return err
+	}
+
+	final := resp.Request.URL
+	structure := append([]string{"status " + resp.Status, "location " + final.Host + final.Path},
+		pageStructure(page, final)...)
+
+	w.mu.Lock()
+	known, seen := w.known[path]
+	w.known[path] = structure
+	w.mu.Unlock()
+
+	switch {
+	case !seen:
+		log.Info("Watching the target page %s: %d elements, %s", path, len(structure), structureHash(structure))
+	case structureHash(known) != structureHash(structure):
+		added, removed := diffStructure(known, structure)
+		log.Important("Target page %s %s: %s", tui.Bold(path), tui.Bold(tui.Red("changed")),
+			strings.Join(append(prefixAll("+", added), prefixAll("-", removed)...), ", "))
+
+		session.Publish(session.Event{
+			Type: session.EventTargetChange,
+			Data: map[string]string{
+				"path":    path,
+				"hash":    structureHash(structure),
+				"added":   strings.Join(added, "\n"),
+				"removed": strings.Join(removed, "\n"),
+			},
+		})
+	default:
+		return nil
+	}
+
+	return w.save()
+}
+
+// save writes the known structures to the state file, if any
+func (w *targetWatch) save() error {
+	if w.state == "" {
+		return nil
+	}
+
+	w.mu.Lock()
+	content, err := json.MarshalIndent(w.known, "", "  ")
+	w.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(w.state, content, 0600)
+}
+
+// pageStructure returns the elements of the page the transformations depend on, sorted:
+// the script URLs, without their query, the forms and their named fields
+func pageStructure(page []byte, base *url.URL) []string {
+	elements := make(map[string]bool)
+
+	z := html.NewTokenizer(bytes.NewReader(page))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			if z.Err() != io.EOF {
+				log.Debug("Error parsing the watched page %s: %s", base, z.Err())
+			}
+			break
+		}
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken {
+			continue
+		}
+
+		name, _ := z.TagName()
+		attrs := make(map[string]string)
+		for {
+			key, value, more := z.TagAttr()
+			attrs[string(key)] = string(value)
+			if !more {
+				break
+			}
+		}
+
+		switch string(name) {
+		case "script":
+			if ref, err := base.Parse(attrs["src"]); attrs["src"] != "" && err == nil {
+				elements["script "+ref.Host+ref.Path] = true
+			}
+		case "form":
+			method := strings.ToUpper(attrs["method"])
+			if method == "" {
+				method = http.MethodGet
+			}
+			action := base.Host + base.Path
+			if ref, err := base.Parse(attrs["action"]); attrs["action"] != "" && err == nil {
+				action = ref.Host + ref.Path
+			}
+			elements["form "+method+" "+action] = true
+		case "input", "select", "textarea", "button":
+			if attrs["name"] != "" {
+				kind := strings.ToLower(attrs["type"])
+				if kind == "" {
+					kind = string(name)
+				}
+				elements["field "+attrs["name"]+" "+kind] = true
+			}
+		}
+	}
+
+	return sortedKeys(elements)
+}
+
+// structureHash returns the hash of the page structure
+func structureHash(structure []string) string {
+	sum := sha256.Sum256([]byte(strings.Join(structure, "\n")))
+	return hex.EncodeToString(sum[:8])
+}
+
+// diffStructure returns the elements added to and removed from the previous structure
+func diffStructure(previous, current []string) (added, removed []string) {
+	before := make(map[string]bool, len(previous))
+	for _, e := range previous {
+		before[e] = true
+	}
+	after := make(map[string]bool, len(current))
+	for _, e := range current {
+		after[e] = true
+		if !before[e] {
+			added = append(added, e)
+		}
+	}
+	for _, e := range previous {
+		if !after[e] {
+			removed = append(removed, e)
+		}
+	}
+	return
+}
+
+func prefixAll(prefix string, values []string) []string {
+	prefixed := make([]string, len(values))
+	for i, v := range values {
+		prefixed[i] = prefix + v
+	}
+	return prefixed
+}
@@ -0,0 +1,63 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPageStructure(t *testing.T) {
+	base, _ := url.Parse("https://login.target.tld/signin")
+	page := `<html><head><script src="/static/app.js?v=3"></script><script>inline()</script></head>
+<body><form method="post" action="/session"><input name="username"><input type="password" name="password">
+<button name="submit" type="submit">Sign in</button></form><form></form></body></html>`
+
+	expected := []string{
+		"field password password",
+		"field submit submit",
+		"field username input",
+		"form GET login.target.tld/signin",
+		"form POST login.target.tld/session",
+		"script login.target.tld/static/app.js",
+	}
+	if got := pageStructure([]byte(page), base); !reflect.DeepEqual(got, expected) {
+		t.Errorf("pageStructure() = %v, want %v", got, expected)
+	}
+}
+
+func TestTargetWatch(t *testing.T) {
+	page := `<form method="post" action="/login"><input name="username"><input name="password" type="password"></form>`
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(page))
+	}))
+	defer upstream.Close()
+
+	base, _ := url.Parse(upstream.URL)
+	state := filepath.Join(t.TempDir(), "watch.json")
+	w := &targetWatch{base: base, state: state, client: upstream.Client(), known: make(map[string][]string)}
+
+	if err := w.check("/"); err != nil {
+		t.Fatal(err)
+	}
+	baseline := w.known["/"]
+
+	page = `<form method="post" action="/login"><input name="email"></form><script src="/challenge.js"></script>`
+	if err := w.check("/"); err != nil {
+		t.Fatal(err)
+	}
+
+	added, removed := diffStructure(baseline, w.known["/"])
+	host := base.Host
+	if !reflect.DeepEqual(added, []string{"field email input", "script " + host + "/challenge.js"}) ||
+		!reflect.DeepEqual(removed, []string{"field password password", "field username input"}) {
+		t.Errorf("unexpected changes: added %v, removed %v", added, removed)
+	}
+
+	if content, err := ioutil.ReadFile(state); err != nil || len(content) == 0 {
+		t.Errorf("expected the state to be saved, got %v", err)
+	}
+}
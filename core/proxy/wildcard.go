@@ -0,0 +1,115 @@
+package proxy
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// DeepWildcardSeparator separates the encoded subdomains of a wildcard origin from its mapping,
+// i.e. a-1b----extwld1.phishing.tld for a.b.cdn.tld when *.cdn.tld is mapped to extwld1.
+// Mapping the whole subdomain to a single label keeps it covered by the *.phishing.tld certificate.
+const DeepWildcardSeparator = CustomWildcardSeparator + "-"
+
+// maxLabelLength is the maximum length of a DNS label
+const maxLabelLength = 63
+
+// subdomainEncoder escapes the hyphens and the dots of a subdomain, so that the encoded label never contains
+// two consecutive hyphens nor ends with one, and the separator can always be told apart
+var subdomainEncoder = strings.NewReplacer("-", "-0", ".", "-1")
+
+// encodeSubdomain encodes a subdomain of any depth as a single label, i.e. a.b-c to a-1b-0c
+func encodeSubdomain(subdomain string) string {
+	return subdomainEncoder.Replace(strings.ToLower(subdomain))
+}
+
+// decodeSubdomain decodes a label encoded by encodeSubdomain
+func decodeSubdomain(label string) (string, bool) {
+	var b strings.Builder
+	for i := 0; i < len(label); i++ {
+		if label[i] != '-' {
+			b.WriteByte(label[i])
+			continue
+		}
+
+		if i+1 == len(label) {
+			return "", false
+		}
+
+		i++
+		switch label[i] {
+		case '0':
+			b.WriteByte('-')
+		case '1':
+			b.WriteByte('.')
+		default:
+			return "", false
+		}
+	}
+
+	subdomain := b.String()
+	for _, l := range strings.Split(subdomain, ".") {
+		if l == "" {
+			return "", false
+		}
+	}
+
+	return subdomain, true
+}
+
+// deepWildcardSeparator returns <DeepWildcardSeparator><ExternalOriginPrefix><WildcardLabel>
+func (r *Replacer) deepWildcardSeparator() string {
+	return fmt.Sprintf("%s%s%s", DeepWildcardSeparator, r.ExternalOriginPrefix, WildcardLabel)
+}
+
+// encodeDeepWildcard maps a nested subdomain of a wildcard origin, as transformed by the backward replacements
+// (a.b.extwld1.phishing.tld), to a single label (a-1b----extwld1.phishing.tld).
+// It returns false for the first level subdomains, which keep the CustomWildcardSeparator mapping,
+// and for the subdomains whose encoding would not fit in a DNS label.
+func (r *Replacer) encodeDeepWildcard(host string) (string, bool) {
+	i := strings.Index(host, "."+r.WildcardPrefix())
+	if i <= 0 {
+		return "", false
+	}
+
+	subdomain, mapping := host[:i], host[i+1:]
+	if !strings.Contains(subdomain, ".") {
+		return "", false
+	}
+
+	label := encodeSubdomain(subdomain) + DeepWildcardSeparator
+	if len(label)+len(strings.Split(mapping, ".")[0]) > maxLabelLength {
+		return "", false
+	}
+
+	return label + mapping, true
+}
+
+// patchDeepWildcards replaces the encoded nested subdomains of the wildcard origins with the original hostnames,
+// i.e. a-1b----extwld1.phishing.tld with a.b.cdn.tld. No origin is added, as the encoding is reversible.
+func (r *Replacer) patchDeepWildcards(input string) string {
+	if !strings.Contains(input, r.deepWildcardSeparator()) {
+		return input
+	}
+
+	re := regexp.MustCompile(fmt.Sprintf(`([a-zA-Z0-9-]+?)%s(%s\d+)\.%s`,
+		regexp.QuoteMeta(DeepWildcardSeparator), regexp.QuoteMeta(r.WildcardPrefix()), regexp.QuoteMeta(r.Phishing)))
+
+	mapping := r.GetWildcardMapping()
+	return re.ReplaceAllStringFunc(input, func(match string) string {
+		groups := re.FindStringSubmatch(match)
+
+		subdomain, ok := decodeSubdomain(groups[1])
+		if !ok {
+			return match
+		}
+
+		for domain, wld := range mapping {
+			if wld == groups[2] {
+				return fmt.Sprintf("%s.%s", subdomain, domain)
+			}
+		}
+
+		return match
+	})
+}
@@ -0,0 +1,94 @@
+package proxy
+
+import (
+	"testing"
+)
+
+func newWildcardReplacer() *Replacer {
+	r := &Replacer{Phishing: "phishing.tld", Target: "target.tld", ExternalOriginPrefix: "ext"}
+	r.ExternalOrigin = []string{"*.cdn.net"}
+	if err := r.DomainMapping(); err != nil {
+		panic(err)
+	}
+	r.MakeReplacements()
+	return r
+}
+
+func TestEncodeSubdomain(t *testing.T) {
+	for _, subdomain := range []string{"a", "a.b", "a.b.c.d", "eu-west-1.s3", "xn--80ak6aa92e.b", "a-.b"} {
+		encoded := encodeSubdomain(subdomain)
+		if got, ok := decodeSubdomain(encoded); !ok || got != subdomain {
+			t.Errorf("%s: encoded %s, decoded %q (%v)", subdomain, encoded, got, ok)
+		}
+	}
+
+	for _, label := range []string{"a-", "a-2b", "-1a", "a-1-1b"} {
+		if got, ok := decodeSubdomain(label); ok {
+			t.Errorf("%s: decoded %q, expected an error", label, got)
+		}
+	}
+}
+
+func TestDeepWildcard(t *testing.T) {
+	r := newWildcardReplacer()
+
+	for _, tc := range []struct {
+		upstream string
+		phishing string
+	}{
+		{"https://a.b.cdn.net/app.js", "https://a-1b----extwld1.phishing.tld/app.js"},
+		{"https://eu-west-1.assets.cdn.net/x", "https://eu-0west-01-1assets----extwld1.phishing.tld/x"},
+		{"//x.y.z.cdn.net/a.css", "//x-1y-1z----extwld1.phishing.tld/a.css"},
+	} {
+		if got := r.Transform(tc.upstream, false, Base64{}); got != tc.phishing {
+			t.Errorf("backward %s: got %s, want %s", tc.upstream, got, tc.phishing)
+		}
+
+		if got := r.Transform(tc.phishing, true, Base64{}); got != tc.upstream {
+			t.Errorf("forward %s: got %s, want %s", tc.phishing, got, tc.upstream)
+		}
+	}
+
+	// The hostname of the request is decoded as well
+	if got := r.PatchComposedWildcardURL("a-1b----extwld1.phishing.tld"); got != "a.b.cdn.net" {
+		t.Errorf("got host %s", got)
+	}
+
+	// Deep subdomains do not grow the origins
+	if origins := r.GetOrigins(); len(origins) != 0 {
+		t.Errorf("unexpected origins %v", origins)
+	}
+}
+
+func TestDomainMapping_Concurrent(t *testing.T) {
+	r := &Replacer{Phishing: "phishing.tld", Target: "target.tld", ExternalOriginPrefix: "ext"}
+	r.ExternalOrigin = []string{"*.cdn.net", "*.static.org", "*.assets.io", "api.other.com"}
+	if err := r.DomainMapping(); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 5000; i++ {
+			if err := r.DomainMapping(); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	// The transformations never see a mapping in the middle of its rebuild
+	for {
+		s := r.current()
+		if len(s.wildcards) != 3 || len(s.origins) != 1 {
+			t.Fatalf("expected 3 wildcards and 1 origin, got %v and %v", s.wildcards, s.origins)
+		}
+
+		select {
+		case <-done:
+			return
+		default:
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package proxy
+
+import (
+	"context"
+
+	"github.com/muraenateam/muraena/session"
+)
+
+// transformPool is a bounded pool of workers transforming the large bodies.
+// A burst of victims fetching huge bundles is processed by a fixed number of goroutines, the others waiting in the
+// queue, and the transformations of the victims gone in the meantime are skipped.
+type transformPool struct {
+	jobs        chan *transformJob
+	minBodySize int
+}
+
+type transformJob struct {
+	ctx  context.Context
+	fn   func()
+	done chan struct{}
+}
+
+// transformers is the pool of the response transformations, nil if disabled
+var transformers *transformPool
+
+// newTransformPool returns the pool defined in the configuration, with its workers started, nil if disabled
+func newTransformPool(sess *session.Session) *transformPool {
+	config := sess.Config.Transform.Response.Workers
+	if !config.Enabled {
+		return nil
+	}
+
+	p := &transformPool{
+		jobs:        make(chan *transformJob, config.Queue),
+		minBodySize: config.MinBodySize,
+	}
+	for i := 0; i < config.Size; i++ {
+		go p.work()
+	}
+
+	return p
+}
+
+// work runs the queued transformations whose request is still alive
+func (p *transformPool) work() {
+	for job := range p.jobs {
+		if job.ctx.Err() == nil {
+			job.fn()
+		}
+		close(job.done)
+	}
+}
+
+// Run runs fn on a worker if the body is at least minBodySize bytes, inline otherwise, and waits for it.
+// It returns the error of the context if it is done first: fn may still be running,
+// so its results must not be used.
+func (p *transformPool) Run(ctx context.Context, size int, fn func()) error {
+	if p == nil || size < p.minBodySize {
+		fn()
+		return nil
+	}
+
+	job := &transformJob{ctx: ctx, fn: fn, done: make(chan struct{})}
+	select {
+	case p.jobs <- job:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case <-job.done:
+		return ctx.Err()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
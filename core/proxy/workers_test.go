@@ -0,0 +1,82 @@
+package proxy
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/muraenateam/muraena/session"
+)
+
+func TestTransformPool(t *testing.T) {
+	sess := &session.Session{Config: &session.Configuration{}}
+	w := &sess.Config.Transform.Response.Workers
+	w.Enabled, w.Size, w.Queue, w.MinBodySize = true, 2, 1, 1024
+
+	p := newTransformPool(sess)
+
+	// Small bodies are transformed inline
+	ran := false
+	if err := p.Run(context.Background(), 10, func() { ran = true }); err != nil || !ran {
+		t.Errorf("expected an inline run, got %v", err)
+	}
+
+	// No more than size transformations run at the same time
+	var running, peak int32
+	release := make(chan struct{})
+	errs := make(chan error, 3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			errs <- p.Run(context.Background(), 2048, func() {
+				n := atomic.AddInt32(&running, 1)
+				for {
+					max := atomic.LoadInt32(&peak)
+					if n <= max || atomic.CompareAndSwapInt32(&peak, max, n) {
+						break
+					}
+				}
+				<-release
+				atomic.AddInt32(&running, -1)
+			})
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	for i := 0; i < 3; i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("unexpected error %s", err)
+		}
+	}
+	if peak != 2 {
+		t.Errorf("expected 2 concurrent transformations, got %d", peak)
+	}
+
+	// The transformations of the requests gone are skipped
+	block := make(chan struct{})
+	for i := 0; i < 2; i++ {
+		go p.Run(context.Background(), 2048, func() { <-block })
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ran = false
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+	if err := p.Run(ctx, 2048, func() { ran = true }); err != context.Canceled {
+		t.Errorf("expected the run to be canceled, got %v", err)
+	}
+	close(block)
+	time.Sleep(20 * time.Millisecond)
+	if ran {
+		t.Error("expected the canceled transformation to be skipped")
+	}
+
+	// Without a pool, the transformations are inline
+	var none *transformPool
+	if err := none.Run(ctx, 1<<20, func() {}); err != nil {
+		t.Errorf("unexpected error %s", err)
+	}
+}
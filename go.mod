@@ -11,14 +11,19 @@ require (
 	github.com/gomodule/redigo v1.9.2
 	github.com/icza/abcsort v0.0.0-20230330133725-d6ace6446f81
 	github.com/kellydunn/golang-geo v0.7.0
+	github.com/lib/pq v1.10.9
 	github.com/logrusorgru/aurora v2.0.3+incompatible
 	github.com/lucasjones/reggen v0.0.0-20200904144131-37ba4fa293bb
 	github.com/manifoldco/promptui v0.9.0
 	github.com/oschwald/geoip2-golang v1.9.0
 	github.com/pelletier/go-toml v1.9.5
 	github.com/pkg/errors v0.9.1
+	golang.org/x/crypto v0.21.0
 	golang.org/x/net v0.22.0
+	golang.org/x/sys v0.18.0
+	google.golang.org/protobuf v1.33.0
 	gopkg.in/resty.v1 v1.12.0
+	gopkg.in/yaml.v3 v3.0.1
 	mvdan.cc/xurls/v2 v2.5.0
 )
 
@@ -35,14 +40,11 @@ require (
 	github.com/golang/protobuf v1.5.4 // indirect
 	github.com/kennygrant/sanitize v1.2.4 // indirect
 	github.com/kylelemons/go-gypsy v1.0.0 // indirect
-	github.com/lib/pq v1.10.9 // indirect
 	github.com/oschwald/maxminddb-golang v1.12.0 // indirect
 	github.com/saintfish/chardet v0.0.0-20230101081208-5e3ef4b5456d // indirect
 	github.com/temoto/robotstxt v1.1.2 // indirect
 	github.com/ziutek/mymysql v1.5.4 // indirect
-	golang.org/x/sys v0.18.0 // indirect
 	golang.org/x/text v0.14.0 // indirect
 	golang.org/x/xerrors v0.0.0-20200804184101-5ec99f83aff1 // indirect
 	google.golang.org/appengine v1.6.8 // indirect
-	google.golang.org/protobuf v1.33.0 // indirect
 )
@@ -6,6 +6,7 @@ import (
 
 	"github.com/evilsocket/islazy/tui"
 
+	"github.com/muraenateam/muraena/core/db"
 	"github.com/muraenateam/muraena/core/proxy"
 	"github.com/muraenateam/muraena/log"
 	"github.com/muraenateam/muraena/module"
@@ -13,6 +14,29 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "init":
+			// Starter configuration generated from the target landing page
+			os.Exit(proxy.InitConfig(os.Args[2:]))
+		case "test-transform":
+			// Offline validation of the transformation rules against saved messages
+			os.Exit(proxy.TestTransform(os.Args[2:]))
+		case "import-phishlet":
+			// Evilginx phishlet converted to a configuration
+			os.Exit(proxy.ImportPhishlet(os.Args[2:]))
+		case "export":
+			// Encrypted bundle of the campaign state, to move or archive it
+			os.Exit(proxy.ExportCampaign(os.Args[2:]))
+		case "import":
+			// Campaign state restored from a bundle
+			os.Exit(proxy.ImportCampaign(os.Args[2:]))
+		case "edge":
+			// Redirector forwarding the connections to the Muraena node
+			os.Exit(proxy.RunEdge(os.Args[2:]))
+		}
+	}
+
 	sess, err := session.New()
 	if err != nil {
 		fmt.Println(err)
@@ -30,6 +54,13 @@ func main() {
 	// Init Log
 	log.Init(sess.Options, sess.Config.Log.Enabled, sess.Config.Log.FilePath)
 
+	// Open the storage of the tracking data
+	if sess.Config.Tracking.Enabled {
+		if err := db.Init(sess); err != nil {
+			log.Fatal("Error opening the storage: %s", err)
+		}
+	}
+
 	// Load all modules
 	module.LoadModules(sess)
 
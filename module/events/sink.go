@@ -0,0 +1,151 @@
+package events
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+
+	"github.com/muraenateam/muraena/session"
+)
+
+// Sink is a destination of the session events
+type Sink interface {
+	Write(e session.Event) error
+}
+
+// newSink returns the sink defined in the configuration
+func newSink(config session.EventSink) (Sink, error) {
+	switch strings.ToLower(config.Type) {
+	case "file":
+		return newFileSink(config.Path)
+	case "redis":
+		return newRedisSink(config.Stream, config.MaxLen)
+	case "kafka":
+		return newKafkaSink(config.Endpoint, config.Topic)
+	case "syslog":
+		return newSyslogSink(config)
+	}
+
+	return nil, fmt.Errorf("unknown event sink type %s", config.Type)
+}
+
+// fileSink appends the events to a file, one JSON object per line (NDJSON)
+type fileSink struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+func newFileSink(path string) (*fileSink, error) {
+	if path == "" {
+		return nil, errors.New("file event sink requires a path")
+	}
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return &fileSink{file: file}, nil
+}
+
+// Write implements the Sink interface
+func (s *fileSink) Write(e session.Event) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err = s.file.Write(append(line, '\n'))
+	return err
+}
+
+// redisSink adds the events to a Redis Stream, using the session Redis connection
+type redisSink struct {
+	pool   *redis.Pool
+	stream string
+	maxLen int
+}
+
+func newRedisSink(stream string, maxLen int) (*redisSink, error) {
+	if session.RedisPool == nil {
+		return nil, errors.New("redis event sink requires the Redis connection, enabled with tracking")
+	}
+
+	if stream == "" {
+		stream = "muraena:events"
+	}
+
+	return &redisSink{pool: session.RedisPool, stream: stream, maxLen: maxLen}, nil
+}
+
+// Write implements the Sink interface
+func (s *redisSink) Write(e session.Event) error {
+	data, err := json.Marshal(e.Data)
+	if err != nil {
+		return err
+	}
+
+	rc := s.pool.Get()
+	defer rc.Close()
+
+	args := redis.Args{}.Add(s.stream)
+	if s.maxLen > 0 {
+		args = args.Add("MAXLEN", "~", s.maxLen)
+	}
+	args = args.Add("*", "type", e.Type, "victim", e.Victim, "time", e.Time.Format(time.RFC3339Nano), "data", string(data))
+
+	_, err = rc.Do("XADD", args...)
+	return err
+}
+
+// kafkaSink produces the events to a Kafka topic through a Kafka REST proxy (v2 API)
+type kafkaSink struct {
+	url    string
+	client *http.Client
+}
+
+func newKafkaSink(endpoint, topic string) (*kafkaSink, error) {
+	if endpoint == "" || topic == "" {
+		return nil, errors.New("kafka event sink requires the REST proxy endpoint and the topic")
+	}
+
+	return &kafkaSink{
+		url:    strings.TrimRight(endpoint, "/") + "/topics/" + topic,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// Write implements the Sink interface
+func (s *kafkaSink) Write(e session.Event) error {
+	type record struct {
+		Key   string        `json:"key,omitempty"`
+		Value session.Event `json:"value"`
+	}
+
+	body, err := json.Marshal(map[string][]record{"records": {{Key: e.Victim, Value: e}}})
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.client.Post(s.url, "application/vnd.kafka.json.v2+json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("kafka REST proxy returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,240 @@
+package events
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/muraenateam/muraena/core"
+	"github.com/muraenateam/muraena/session"
+)
+
+const (
+	// syslogFacility is local0
+	syslogFacility = 16
+	// syslogTimeout bounds the connection and the writes to the collector
+	syslogTimeout = 10 * time.Second
+)
+
+// eventNames are the names of the events in the SIEM, the type otherwise
+var eventNames = map[string]string{
+	session.EventVictim:          "New victim",
+	session.EventRequest:         "Victim request",
+	session.EventCredentials:     "Credentials captured",
+	session.EventCookie:          "Session cookie captured",
+	session.EventSessionComplete: "Session captured",
+	session.EventWatchdog:        "Watchdog action",
+	session.EventSubmission:      "Submission relayed",
+	session.EventPhished:         "Training submission",
+	session.EventUpstream:        "Upstream state change",
+	session.EventTargetChange:    "Target page change",
+}
+
+// eventSeverities are the CEF severities (0-10) of the captures, 1 for the other events
+var eventSeverities = map[string]int{
+	session.EventVictim:          3,
+	session.EventPhished:         5,
+	session.EventCookie:          7,
+	session.EventCredentials:     8,
+	session.EventSubmission:      8,
+	session.EventSessionComplete: 9,
+}
+
+// cefKeys are the CEF keys of the known data fields, the other fields are sent as custom strings
+var cefKeys = map[string]string{
+	"ip":     "src",
+	"ua":     "requestClientApplication",
+	"path":   "request",
+	"url":    "request",
+	"method": "requestMethod",
+	"host":   "dhost",
+}
+
+// leefKeys are the LEEF keys of the known data fields, the other fields are sent as they are
+var leefKeys = map[string]string{
+	"ip":     "src",
+	"ua":     "userAgent",
+	"path":   "url",
+	"url":    "url",
+	"method": "method",
+	"host":   "dst",
+}
+
+// syslogSink sends the events to a syslog collector, as RFC 5424 messages framed by octet counting (RFC 6587) over
+// TCP or TLS, carrying the events formatted in CEF or LEEF
+type syslogSink struct {
+	address  string
+	tls      *tls.Config
+	leef     bool
+	hostname string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func newSyslogSink(config session.EventSink) (*syslogSink, error) {
+	if config.Address == "" {
+		return nil, errors.New("syslog event sink requires the address of the collector")
+	}
+
+	host, _, err := net.SplitHostPort(config.Address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid syslog collector address %s: %w", config.Address, err)
+	}
+
+	s := &syslogSink{address: config.Address, leef: strings.ToLower(config.Format) == "leef"}
+	if strings.ToLower(config.Transport) == "tls" {
+		s.tls = &tls.Config{ServerName: host, InsecureSkipVerify: config.Insecure}
+	}
+	if s.hostname, err = os.Hostname(); err != nil || s.hostname == "" {
+		s.hostname = "-"
+	}
+
+	return s, nil
+}
+
+// Write implements the Sink interface, reconnecting once if the connection was lost
+func (s *syslogSink) Write(e session.Event) error {
+	message := s.message(e)
+	frame := []byte(strconv.Itoa(len(message)) + " " + message)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if s.conn == nil {
+			if s.conn, err = s.dial(); err != nil {
+				return err
+			}
+		}
+
+		_ = s.conn.SetWriteDeadline(time.Now().Add(syslogTimeout))
+		if _, err = s.conn.Write(frame); err == nil {
+			return nil
+		}
+		s.conn.Close()
+		s.conn = nil
+	}
+
+	return err
+}
+
+func (s *syslogSink) dial() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: syslogTimeout}
+	if s.tls != nil {
+		return tls.DialWithDialer(dialer, "tcp", s.address, s.tls)
+	}
+	return dialer.Dial("tcp", s.address)
+}
+
+// message returns the RFC 5424 message of the event
+func (s *syslogSink) message(e session.Event) string {
+	// The captures are notices, the other events informational
+	severity := 6
+	if eventSeverities[e.Type] >= 5 {
+		severity = 5
+	}
+
+	content := cefEvent(e)
+	if s.leef {
+		content = leefEvent(e)
+	}
+
+	return fmt.Sprintf("<%d>1 %s %s muraena %d %s - %s", syslogFacility*8+severity,
+		e.Time.UTC().Format(time.RFC3339Nano), s.hostname, os.Getpid(), e.Type, content)
+}
+
+// eventFields returns the data fields of the event sent to the SIEM, sorted by name.
+// As for the notifications, the values of the credentials are never sent.
+func eventFields(e session.Event) []string {
+	keys := make([]string, 0, len(e.Data))
+	for k := range e.Data {
+		if e.Type == session.EventCredentials && k == "value" {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// eventName returns the name of the event in the SIEM
+func eventName(e session.Event) string {
+	if name, ok := eventNames[e.Type]; ok {
+		return name
+	}
+	return e.Type
+}
+
+// cefEvent returns the event in the ArcSight Common Event Format
+func cefEvent(e session.Event) string {
+	header := strings.NewReplacer(`\`, `\\`, `|`, `\|`)
+	value := strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\r", `\r`, "\n", `\n`)
+
+	severity, ok := eventSeverities[e.Type]
+	if !ok {
+		severity = 1
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "CEF:0|Muraena|Muraena|%s|%s|%s|%d|", header.Replace(core.Version), header.Replace(e.Type),
+		header.Replace(eventName(e)), severity)
+	fmt.Fprintf(&b, "rt=%d", e.Time.UnixNano()/int64(time.Millisecond))
+	if e.Victim != "" {
+		fmt.Fprintf(&b, " suser=%s", value.Replace(e.Victim))
+	}
+
+	custom := 0
+	for _, k := range eventFields(e) {
+		if key, ok := cefKeys[k]; ok {
+			fmt.Fprintf(&b, " %s=%s", key, value.Replace(e.Data[k]))
+			continue
+		}
+
+		// CEF defines 6 custom strings
+		if custom++; custom <= 6 {
+			fmt.Fprintf(&b, " cs%dLabel=%s cs%d=%s", custom, value.Replace(k), custom, value.Replace(e.Data[k]))
+		}
+	}
+
+	return b.String()
+}
+
+// leefEvent returns the event in the IBM QRadar Log Event Extended Format (LEEF 1.0), tab separated
+func leefEvent(e session.Event) string {
+	header := strings.NewReplacer("|", " ")
+	value := strings.NewReplacer("\t", " ", "\r", " ", "\n", " ")
+
+	severity, ok := eventSeverities[e.Type]
+	if !ok {
+		severity = 1
+	}
+
+	attributes := []string{
+		// epoch milliseconds, without devTimeFormat
+		"devTime=" + strconv.FormatInt(e.Time.UnixNano()/int64(time.Millisecond), 10),
+		"cat=" + e.Type,
+		"sev=" + strconv.Itoa(severity),
+	}
+	if e.Victim != "" {
+		attributes = append(attributes, "usrName="+value.Replace(e.Victim))
+	}
+	for _, k := range eventFields(e) {
+		key := k
+		if known, ok := leefKeys[k]; ok {
+			key = known
+		}
+		attributes = append(attributes, key+"="+value.Replace(e.Data[k]))
+	}
+
+	return fmt.Sprintf("LEEF:1.0|Muraena|Muraena|%s|%s|%s", header.Replace(core.Version), header.Replace(e.Type),
+		strings.Join(attributes, "\t"))
+}
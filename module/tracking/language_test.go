@@ -0,0 +1,30 @@
+package tracking
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/muraenateam/muraena/core/db"
+)
+
+func TestPinLanguage(t *testing.T) {
+	for _, c := range []struct {
+		pinned, sent, expected string
+	}{
+		{"it-IT,it;q=0.9,en;q=0.8", "en-US,en;q=0.5", "it-IT,it;q=0.9,en;q=0.8"},
+		{"it-IT,it;q=0.9,en;q=0.8", "", "it-IT,it;q=0.9,en;q=0.8"},
+		{"", "en-US,en;q=0.5", "en-US,en;q=0.5"},
+		{"", "", ""},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "https://phishing.com/", nil)
+		if c.sent != "" {
+			r.Header.Set("Accept-Language", c.sent)
+		}
+
+		pinLanguage(r, &db.Victim{ID: "victim", Language: c.pinned})
+		if got := r.Header.Get("Accept-Language"); got != c.expected {
+			t.Errorf("pinned %q, sent %q: expected %q, got %q", c.pinned, c.sent, c.expected, got)
+		}
+	}
+}
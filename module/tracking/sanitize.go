@@ -0,0 +1,54 @@
+package tracking
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/muraenateam/muraena/session"
+)
+
+// CredentialSanitizer turns a captured credential into the value stored, shown and notified. The simulation
+// engagements record only the fact that the credentials were submitted, never their values.
+type CredentialSanitizer interface {
+	Sanitize(key, value string) string
+}
+
+// NewCredentialSanitizer returns the sanitizer of the tracking.secrets.capture setting, nil to keep the values
+func NewCredentialSanitizer(s *session.Session) CredentialSanitizer {
+	secrets := s.Config.Tracking.Secrets
+	switch secrets.Capture {
+	case "fact":
+		return factSanitizer{}
+	case "hash":
+		key := secrets.Salt
+		if key == "" {
+			key = s.Config.Proxy.Phishing + "|" + s.Config.Proxy.Target
+		}
+		return &hashSanitizer{key: []byte(key)}
+	}
+	return nil
+}
+
+// factSanitizer keeps the length of the credentials
+type factSanitizer struct{}
+
+// Sanitize implements the CredentialSanitizer interface
+func (factSanitizer) Sanitize(key, value string) string {
+	return fmt.Sprintf("[submitted, %d characters]", utf8.RuneCountInString(value))
+}
+
+// hashSanitizer keeps the length and a keyed hash of the credentials, telling whether two submissions are the same,
+// i.e. a password reused across the targets, without storing them
+type hashSanitizer struct {
+	key []byte
+}
+
+// Sanitize implements the CredentialSanitizer interface
+func (h *hashSanitizer) Sanitize(key, value string) string {
+	mac := hmac.New(sha256.New, h.key)
+	mac.Write([]byte(value))
+	return fmt.Sprintf("[submitted, %d characters, hmac %s]", utf8.RuneCountInString(value), hex.EncodeToString(mac.Sum(nil)[:8]))
+}
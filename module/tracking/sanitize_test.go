@@ -0,0 +1,39 @@
+package tracking
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/muraenateam/muraena/session"
+)
+
+func TestCredentialSanitizer(t *testing.T) {
+	s := &session.Session{Config: &session.Configuration{}}
+	s.Config.Proxy.Phishing = "phishing.click"
+	s.Config.Proxy.Target = "poor.victim"
+
+	s.Config.Tracking.Secrets.Capture = "value"
+	if NewCredentialSanitizer(s) != nil {
+		t.Error("expected the values to be kept")
+	}
+
+	s.Config.Tracking.Secrets.Capture = "fact"
+	if v := NewCredentialSanitizer(s).Sanitize("password", "hunter2€"); v != "[submitted, 8 characters]" {
+		t.Errorf("unexpected fact %q", v)
+	}
+
+	s.Config.Tracking.Secrets.Capture = "hash"
+	h := NewCredentialSanitizer(s)
+	v := h.Sanitize("password", "hunter2")
+	if strings.Contains(v, "hunter2") || !strings.HasPrefix(v, "[submitted, 7 characters, hmac ") {
+		t.Errorf("unexpected hash %q", v)
+	}
+	if h.Sanitize("password", "hunter2") != v || h.Sanitize("password", "hunter3") == v {
+		t.Error("expected the same hash for the same value only")
+	}
+
+	s.Config.Tracking.Secrets.Salt = "campaign"
+	if NewCredentialSanitizer(s).Sanitize("password", "hunter2") == v {
+		t.Error("expected the salt to change the hash")
+	}
+}
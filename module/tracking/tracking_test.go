@@ -16,7 +16,9 @@ var m *Tracker
 
 // init test
 func init() {
-	log.Init(core.Options{Debug: &[]bool{true}[0]}, false, "")
+	opts := core.GetDefaultOptions()
+	*opts.Debug = true
+	log.Init(opts, false, "")
 
 	s := &session.Session{}
 	s.Config = &session.Configuration{}
@@ -59,3 +61,33 @@ func TestPushVictim(t *testing.T) {
 
 	m.PushVictim(v)
 }
+
+func TestIsSessionComplete(t *testing.T) {
+	profile := session.SessionProfile{
+		Name:    "target",
+		Cookies: []string{"SID", "^__Secure-[0-9]PSID$"},
+		Domains: []string{"target.tld"},
+	}
+
+	jar := []db.VictimCookie{
+		{Name: "SID", Value: "a", Domain: ".target.tld"},
+		{Name: "NID", Value: "b", Domain: "accounts.target.tld"},
+	}
+	if isSessionComplete(profile, jar) {
+		t.Fatal("session complete without all the cookies")
+	}
+
+	jar = append(jar, db.VictimCookie{Name: "__Secure-1PSID", Value: "c", Domain: "other.tld"})
+	if isSessionComplete(profile, jar) {
+		t.Fatal("session complete with a cookie of another domain")
+	}
+
+	jar = append(jar, db.VictimCookie{Name: "__Secure-3PSID", Value: "d", Domain: "accounts.target.tld"})
+	if !isSessionComplete(profile, jar) {
+		t.Fatal("session not complete")
+	}
+
+	if !isSessionComplete(session.SessionProfile{Cookies: []string{"NID"}}, jar) {
+		t.Fatal("session not complete without domains")
+	}
+}
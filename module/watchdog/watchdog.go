@@ -498,7 +498,7 @@ func ParseRules(rules string) Blacklist {
 // func (module *Watchdog) Allow(ip net.IP) bool {
 func (module *Watchdog) Allow(r *http.Request) bool {
 
-	ip := GetRealAddr(r)
+	ip := net.ParseIP(module.Session.ClientIP(r))
 	ua := GetUserAgent(r)
 
 	// TODO: Hardcoded default ALLOW policy, consider to make it customizable.
@@ -631,12 +631,29 @@ func (module *Watchdog) Allow(r *http.Request) bool {
 	}
 
 	if !allow {
-		module.Important("Blocked %s (ua: %s)", tui.Red(ip.String()), tui.Red(ua))
+		logged := module.Session.AnonymizeIP(ip.String())
+		module.Important("Blocked %s (ua: %s)", tui.Red(logged), tui.Red(ua))
+		session.Publish(session.Event{
+			Type: session.EventWatchdog,
+			Data: map[string]string{"action": "blocked", "ip": logged, "ua": ua},
+		})
 	}
 
 	return allow
 }
 
+// Block appends a rule denying the IP address, i.e. of a client exhausting the resources of the proxy.
+// The rule is kept in memory only, unless the rules are saved.
+func (module *Watchdog) Block(ip net.IP, reason string) {
+	if ip == nil {
+		return
+	}
+
+	if module.Rules.AppendRaw(ip.String()) {
+		module.Important("Blocking %s: %s", tui.Red(module.Session.AnonymizeIP(ip.String())), reason)
+	}
+}
+
 // MonitorRules starts a watcher to monitor changes to file containing blacklist rules.
 func (module *Watchdog) MonitorRules() {
 
@@ -675,23 +692,6 @@ func (module *Watchdog) MonitorRules() {
 	select {}
 }
 
-// GetRealAddr returns the IP address from an http.Request
-func GetRealAddr(r *http.Request) net.IP {
-	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
-		if parts := strings.Split(forwarded, ","); len(parts) > 0 {
-			// Intermediate nodes append, so first is the original client
-			return net.ParseIP(strings.TrimSpace(parts[0]))
-		}
-	}
-
-	addr, _, err := net.SplitHostPort(r.RemoteAddr)
-	if err == nil {
-		return net.ParseIP(addr)
-	}
-	return net.ParseIP(r.RemoteAddr)
-	// return net.ParseIP(proxy.GetSenderIP(r))
-}
-
 // GetUserAgent returns the User-Agent string from an http.Request
 func GetUserAgent(r *http.Request) string {
 	return r.UserAgent()
@@ -0,0 +1,267 @@
+package session
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/muraenateam/muraena/log"
+)
+
+const (
+	// RoleViewer can only read the admin endpoints
+	RoleViewer = "viewer"
+	// RoleOperator can also perform administrative actions
+	RoleOperator = "operator"
+)
+
+// AdminToken grants a role on the admin endpoints
+type AdminToken struct {
+	Name  string `toml:"name"`
+	Token string `toml:"token"`
+	Role  string `toml:"role"`
+}
+
+// AdminIdentity is the operator authenticated on an admin endpoint
+type AdminIdentity struct {
+	Name string
+	Role string
+}
+
+type adminIdentityKey struct{}
+
+// AdminIdentityFrom returns the operator authenticated on the request, if any
+func AdminIdentityFrom(ctx context.Context) (AdminIdentity, bool) {
+	id, ok := ctx.Value(adminIdentityKey{}).(AdminIdentity)
+	return id, ok
+}
+
+// AuditRecord is an entry of the audit log
+type AuditRecord struct {
+	Time     time.Time `json:"time"`
+	Endpoint string    `json:"endpoint"`
+	Name     string    `json:"name,omitempty"`
+	Role     string    `json:"role,omitempty"`
+	IP       string    `json:"ip"`
+	Method   string    `json:"method"`
+	Path     string    `json:"path"`
+	Status   int       `json:"status"`
+	Action   string    `json:"action,omitempty"`
+}
+
+var audit struct {
+	once sync.Once
+	mu   sync.Mutex
+	file *os.File
+}
+
+// Audit appends the record to the audit log, if enabled
+func (s *Session) Audit(record AuditRecord) {
+	path := s.Config.Admin.AuditLog
+	if path == "" {
+		return
+	}
+
+	audit.once.Do(func() {
+		var err error
+		if audit.file, err = os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600); err != nil {
+			log.Error("Error opening the audit log %s: %s", path, err)
+		}
+	})
+	if audit.file == nil {
+		return
+	}
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		return
+	}
+
+	audit.mu.Lock()
+	defer audit.mu.Unlock()
+	if _, err := audit.file.Write(append(line, '\n')); err != nil {
+		log.Error("Error writing the audit log: %s", err)
+	}
+}
+
+// AuditAction records an administrative action performed on the request, i.e. a data export
+func (s *Session) AuditAction(endpoint string, r *http.Request, action string) {
+	id, _ := AdminIdentityFrom(r.Context())
+	s.Audit(AuditRecord{
+		Time:     time.Now().UTC(),
+		Endpoint: endpoint,
+		Name:     id.Name,
+		Role:     id.Role,
+		IP:       remoteIP(r),
+		Method:   r.Method,
+		Path:     r.URL.Path,
+		Status:   http.StatusOK,
+		Action:   action,
+	})
+}
+
+// BearerToken returns the Bearer token of the request
+func BearerToken(r *http.Request) string {
+	return strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+}
+
+// AdminHandler guards an admin endpoint: the client IP must be allowed and the token must grant a role.
+// The endpoint token, if any, grants the operator role, as the tokens of the admin configuration with their roles.
+// Viewers can only send GET and HEAD requests, while all the other requests are administrative actions
+// and, as the rejected ones, are recorded in the audit log.
+// tokenOf extracts the token from the request, the Bearer token if nil.
+func (s *Session) AdminHandler(endpoint, token string, tokenOf func(*http.Request) string, next http.Handler) http.Handler {
+	config := s.Config.Admin
+	if tokenOf == nil {
+		tokenOf = BearerToken
+	}
+
+	var allowed []*net.IPNet
+	for _, a := range config.Allow {
+		if network := ParseNetwork(a); network != nil {
+			allowed = append(allowed, network)
+		}
+	}
+
+	tokens := config.Tokens
+	if token != "" {
+		tokens = append([]AdminToken{{Name: endpoint, Token: token, Role: RoleOperator}}, tokens...)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		record := AuditRecord{
+			Time:     time.Now().UTC(),
+			Endpoint: endpoint,
+			IP:       remoteIP(r),
+			Method:   r.Method,
+			Path:     r.URL.Path,
+		}
+
+		status := http.StatusForbidden
+		if len(allowed) > 0 && !ContainsIP(allowed, record.IP) && !unixSocket(r) {
+			http.Error(w, http.StatusText(status), status)
+			record.Status = status
+			s.Audit(record)
+			return
+		}
+
+		// Without any token the endpoint is open, as it was explicitly configured
+		id := AdminIdentity{Role: RoleOperator}
+		if len(tokens) > 0 {
+			var ok bool
+			if id, ok = authenticate(tokens, tokenOf(r)); !ok {
+				status = http.StatusUnauthorized
+				http.Error(w, http.StatusText(status), status)
+				record.Status = status
+				s.Audit(record)
+				return
+			}
+		}
+		record.Name, record.Role = id.Name, id.Role
+
+		readOnly := r.Method == http.MethodGet || r.Method == http.MethodHead
+		if !readOnly && id.Role != RoleOperator {
+			http.Error(w, http.StatusText(status), status)
+			record.Status = status
+			s.Audit(record)
+			return
+		}
+
+		r = r.WithContext(context.WithValue(r.Context(), adminIdentityKey{}, id))
+		if readOnly {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		record.Status = rec.status
+		s.Audit(record)
+	})
+}
+
+// authenticate returns the identity of the token
+func authenticate(tokens []AdminToken, token string) (AdminIdentity, bool) {
+	if token == "" {
+		return AdminIdentity{}, false
+	}
+
+	for _, t := range tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(t.Token)) == 1 {
+			return AdminIdentity{Name: t.Name, Role: strings.ToLower(t.Role)}, true
+		}
+	}
+
+	return AdminIdentity{}, false
+}
+
+// ParseNetwork parses an IP address or a CIDR, nil if invalid
+func ParseNetwork(s string) *net.IPNet {
+	if _, network, err := net.ParseCIDR(s); err == nil {
+		return network
+	}
+
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil
+	}
+
+	bits := 128
+	if ip.To4() != nil {
+		ip, bits = ip.To4(), 32
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+}
+
+// ContainsIP checks if the IP address belongs to any of the networks
+func ContainsIP(networks []*net.IPNet, s string) bool {
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// unixSocket tells if the request has been received on a Unix socket, whose access is granted by the file permissions
+func unixSocket(r *http.Request) bool {
+	addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	return ok && addr.Network() == "unix"
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// statusRecorder keeps the status code of the response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Flush implements http.Flusher, used by the streaming admin endpoints
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,79 @@
+package session
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAdminHandler(t *testing.T) {
+	s := &Session{Config: &Configuration{}}
+	s.Config.Admin.Tokens = []AdminToken{
+		{Name: "alice", Token: "viewer-token", Role: RoleViewer},
+		{Name: "bob", Token: "operator-token", Role: RoleOperator},
+	}
+	s.Config.Admin.Allow = []string{"10.0.0.0/8", "192.168.1.1"}
+	s.Config.Admin.AuditLog = filepath.Join(t.TempDir(), "audit.log")
+
+	handler := s.AdminHandler("test", "endpoint-token", nil, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id, ok := AdminIdentityFrom(r.Context()); !ok || id.Name == "" {
+			t.Errorf("missing identity in %s %s", r.Method, r.URL.Path)
+		}
+	}))
+
+	for _, tc := range []struct {
+		name   string
+		method string
+		ip     string
+		token  string
+		status int
+	}{
+		{"not allowed ip", http.MethodGet, "172.16.0.1", "operator-token", http.StatusForbidden},
+		{"no token", http.MethodGet, "10.1.2.3", "", http.StatusUnauthorized},
+		{"wrong token", http.MethodGet, "10.1.2.3", "nope", http.StatusUnauthorized},
+		{"viewer read", http.MethodGet, "10.1.2.3", "viewer-token", http.StatusOK},
+		{"viewer action", http.MethodPost, "10.1.2.3", "viewer-token", http.StatusForbidden},
+		{"operator action", http.MethodPost, "192.168.1.1", "operator-token", http.StatusOK},
+		{"endpoint token", http.MethodDelete, "10.1.2.3", "endpoint-token", http.StatusOK},
+	} {
+		req := httptest.NewRequest(tc.method, "/purge", nil)
+		req.RemoteAddr = tc.ip + ":1234"
+		if tc.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tc.token)
+		}
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tc.status {
+			t.Errorf("%s: got status %d, want %d", tc.name, rec.Code, tc.status)
+		}
+	}
+
+	f, err := os.Open(s.Config.Admin.AuditLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var records []AuditRecord
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var r AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, r)
+	}
+
+	// The rejected requests and the actions are audited, the reads are not
+	if len(records) != 6 {
+		t.Fatalf("got %d audit records, want 6", len(records))
+	}
+	if r := records[4]; r.Name != "bob" || r.Role != RoleOperator || r.Method != http.MethodPost || r.Status != http.StatusOK {
+		t.Errorf("unexpected audit record %+v", r)
+	}
+}
@@ -0,0 +1,95 @@
+package session
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// TrustedProxies are the CDNs and load balancers fronting the proxy, i.e. Cloudflare:
+// the headers carrying the address of the client are honored only on their connections.
+type TrustedProxies struct {
+	networks []*net.IPNet
+	headers  []string
+}
+
+// CheckTrustedProxies checks the networks of the trusted proxies, as IP addresses or CIDRs
+func (s *Session) CheckTrustedProxies() (err error) {
+	c := s.Config.Proxy.TrustedProxies
+	if len(c.Networks) == 0 {
+		s.trustedProxies = nil
+		return
+	}
+
+	t := &TrustedProxies{}
+	for _, n := range c.Networks {
+		network := ParseNetwork(strings.TrimSpace(n))
+		if network == nil {
+			return fmt.Errorf("Invalid trusted proxy %s: it must be an IP address or a CIDR", n)
+		}
+		t.networks = append(t.networks, network)
+	}
+
+	for _, h := range c.Headers {
+		if h = strings.TrimSpace(h); h != "" {
+			t.headers = append(t.headers, http.CanonicalHeaderKey(h))
+		}
+	}
+	if len(t.headers) == 0 {
+		return fmt.Errorf("Missing trusted proxy headers")
+	}
+
+	s.trustedProxies = t
+	return
+}
+
+// TrustedProxies returns the trusted proxies, nil if none is configured
+func (s *Session) TrustedProxies() *TrustedProxies {
+	if s == nil {
+		return nil
+	}
+	return s.trustedProxies
+}
+
+// ClientIP returns the address of the client sending the request, see TrustedProxies.ClientIP
+func (s *Session) ClientIP(r *http.Request) string {
+	return s.TrustedProxies().ClientIP(r)
+}
+
+// ClientIP returns the address of the client sending the request. The headers are honored, in order, only if
+// the connection comes from a trusted proxy: the client is the rightmost address of the list not belonging
+// to a trusted proxy, so that the addresses prepended by the client itself are ignored.
+// Otherwise, or without trusted proxies, it is the remote address of the connection.
+func (t *TrustedProxies) ClientIP(r *http.Request) string {
+	remote := remoteIP(r)
+	if t == nil || !ContainsIP(t.networks, remote) {
+		return remote
+	}
+
+	for _, header := range t.headers {
+		values := r.Header.Values(header)
+		if len(values) == 0 {
+			continue
+		}
+
+		hops := strings.Split(strings.Join(values, ","), ",")
+		client := ""
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if net.ParseIP(hop) == nil {
+				break
+			}
+			client = hop
+			if !ContainsIP(t.networks, hop) {
+				break
+			}
+		}
+
+		if client != "" {
+			return client
+		}
+	}
+
+	return remote
+}
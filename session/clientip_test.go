@@ -0,0 +1,53 @@
+package session
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSession_ClientIP(t *testing.T) {
+	s := &Session{Config: &Configuration{}}
+
+	request := httptest.NewRequest("GET", "/", nil)
+	request.RemoteAddr = "198.51.100.7:51000"
+	request.Header.Set("CF-Connecting-IP", "192.0.2.10")
+	if ip := s.ClientIP(request); ip != "198.51.100.7" {
+		t.Errorf("Expected the remote address without trusted proxies, got %s", ip)
+	}
+
+	s.Config.Proxy.TrustedProxies.Networks = []string{"173.245.48.0/20", "10.0.0.1"}
+	s.Config.Proxy.TrustedProxies.Headers = DefaultTrustedProxyHeaders
+	if err := s.CheckTrustedProxies(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name    string
+		remote  string
+		headers map[string]string
+		ip      string
+	}{
+		{"untrusted connection", "198.51.100.7:51000", map[string]string{"CF-Connecting-IP": "192.0.2.10"}, "198.51.100.7"},
+		{"cdn header", "173.245.48.12:443", map[string]string{"CF-Connecting-IP": "192.0.2.10"}, "192.0.2.10"},
+		{"header order", "173.245.48.12:443", map[string]string{"X-Forwarded-For": "192.0.2.20", "CF-Connecting-IP": "192.0.2.10"}, "192.0.2.10"},
+		{"spoofed hop", "10.0.0.1:443", map[string]string{"X-Forwarded-For": "203.0.113.1, 192.0.2.10"}, "192.0.2.10"},
+		{"trusted hops", "10.0.0.1:443", map[string]string{"X-Forwarded-For": "192.0.2.10, 173.245.48.12"}, "192.0.2.10"},
+		{"invalid header", "10.0.0.1:443", map[string]string{"X-Forwarded-For": "unknown"}, "10.0.0.1"},
+		{"ipv6 header", "10.0.0.1:443", map[string]string{"CF-Connecting-IP": "2001:db8::1"}, "2001:db8::1"},
+	} {
+		request := httptest.NewRequest("GET", "/", nil)
+		request.RemoteAddr = tc.remote
+		for k, v := range tc.headers {
+			request.Header.Set(k, v)
+		}
+
+		if ip := s.ClientIP(request); ip != tc.ip {
+			t.Errorf("%s: expected %s, got %s", tc.name, tc.ip, ip)
+		}
+	}
+
+	s.Config.Proxy.TrustedProxies.Networks = []string{"cloudflare"}
+	if err := s.CheckTrustedProxies(); err == nil {
+		t.Error("Expected an error with an invalid network")
+	}
+}
@@ -0,0 +1,85 @@
+package session
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/evilsocket/islazy/tui"
+	"github.com/gomodule/redigo/redis"
+
+	"github.com/muraenateam/muraena/log"
+)
+
+// leader is set while this node is the cluster leader, a standalone instance always is
+var leader int32 = 1
+
+// acquireLeadership renews the leadership of the node, or acquires it if no node holds it
+var acquireLeadership = redis.NewScript(1, `
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+if redis.call('SET', KEYS[1], ARGV[1], 'NX', 'PX', ARGV[2]) then
+	return 1
+end
+return 0`)
+
+// releaseLeadership drops the leadership of the node, if held
+var releaseLeadership = redis.NewScript(1, `
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0`)
+
+// IsLeader tells if this node runs the campaign-wide periodic tasks, such as the retention purge
+func IsLeader() bool {
+	return atomic.LoadInt32(&leader) == 1
+}
+
+// ClusterKey returns the Redis key of a state shared by the nodes of the cluster of the target
+func (s *Session) ClusterKey(name string) string {
+	return fmt.Sprintf("muraena:cluster:%s:%s", s.Config.Proxy.Target, name)
+}
+
+// elect keeps competing for the cluster leadership.
+// The leadership expires after LeaderTTL seconds, so that another node takes over if the leader dies.
+func (s *Session) elect() {
+	config := s.Config.Cluster
+	atomic.StoreInt32(&leader, 0)
+
+	ttl := time.Duration(config.LeaderTTL) * time.Second
+	for {
+		rc := RedisPool.Get()
+		elected, err := redis.Int(acquireLeadership.Do(rc, s.ClusterKey("leader"), config.Node, ttl.Milliseconds()))
+		rc.Close()
+		if err != nil {
+			log.Warning("Cluster leader election failed: %s", err)
+			elected = 0
+		}
+
+		if was := atomic.SwapInt32(&leader, int32(elected)); was != int32(elected) {
+			if elected == 1 {
+				log.Important("Node %s is now the cluster leader", tui.Bold(config.Node))
+			} else {
+				log.Important("Node %s is no longer the cluster leader", tui.Bold(config.Node))
+			}
+		}
+
+		time.Sleep(ttl / 3)
+	}
+}
+
+// LeaveCluster releases the leadership, if held, so that another node takes over immediately
+func (s *Session) LeaveCluster() error {
+	if !s.Config.Cluster.Enabled || RedisPool == nil {
+		return nil
+	}
+
+	atomic.StoreInt32(&leader, 0)
+
+	rc := RedisPool.Get()
+	defer rc.Close()
+
+	_, err := releaseLeadership.Do(rc, s.ClusterKey("leader"), s.Config.Cluster.Node)
+	return err
+}
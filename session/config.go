@@ -3,8 +3,14 @@ package session
 import (
 	"fmt"
 	"io/ioutil"
+	"net"
+	"net/url"
 	"os"
+	"path/filepath"
+	"reflect"
 	"regexp"
+	"runtime"
+	"strconv"
 	"strings"
 
 	"github.com/pelletier/go-toml"
@@ -20,6 +26,115 @@ var (
 	DefaultHTTPSPort       = 443
 	DefaultBase64Padding   = []string{"=", "."}
 	DefaultSkipContentType = []string{"font/*", "image/*"}
+
+	DefaultEmbeddedBase64MinLength = 24
+
+	DefaultDryRunReport = "dryrun.toml"
+
+	DefaultOperatorMode = "original"
+
+	DefaultSecretsCapture = "value"
+
+	DefaultVariant = "control"
+
+	// ClientOS are the operating systems detected from the User-Agent of the clients
+	ClientOS = []string{"android", "ios", "windows", "macos", "linux", "chromeos"}
+
+	DefaultEnrichmentTimeout = 3000
+	DefaultEnrichmentFailure = "allow"
+
+	// DefaultCSRFCookies and DefaultCSRFFields are the names of the CSRF tokens of the common frameworks
+	DefaultCSRFCookies = []string{"csrftoken", "XSRF-TOKEN", "_csrf", "csrf_token"}
+	DefaultCSRFFields  = []string{"csrfmiddlewaretoken", "X-CSRFToken", "X-XSRF-TOKEN", "X-CSRF-Token", "_csrf",
+		"csrf_token", "csrf-token", "authenticity_token", "__RequestVerificationToken"}
+
+	DefaultEmailTLS      = "starttls"
+	DefaultEmailInterval = 60
+	DefaultEmailBatch    = 50
+
+	DefaultProfilesDirectory = "profiles"
+
+	DefaultProtectContentTypes = []string{"font/*", "image/*", "audio/*", "video/*", "application/wasm",
+		"application/octet-stream", "application/pdf", "application/zip", "application/x-gzip"}
+
+	DefaultUploadsPath = "uploads"
+
+	DefaultStreamContentTypes = []string{"text/event-stream", "application/x-ndjson"}
+	DefaultStreamBufferSize   = 64 << 10
+
+	DefaultRewriteCacheSize         = 512
+	DefaultRewriteCacheMaxBodySize  = 4 << 20
+	DefaultRewriteCacheContentTypes = []string{"application/javascript", "application/x-javascript", "text/javascript", "text/css"}
+	DefaultBypassSize               = int64(32 << 20)
+	DefaultResolverTimeout          = 5
+	DefaultShutdownTimeout          = 30
+	DefaultUpgradeDelay             = 3
+
+	DefaultWorkersQueue       = 64
+	DefaultWorkersMinBodySize = 256 << 10
+
+	DefaultUpstreamCacheSize        = 1024
+	DefaultUpstreamCacheMaxBodySize = 8 << 20
+
+	DefaultBrandPaths = []string{"/favicon.ico", "/apple-touch-icon.png", "/apple-touch-icon-precomposed.png",
+		"/manifest.json", "/manifest.webmanifest", "/site.webmanifest"}
+
+	DefaultTrustedProxyHeaders = []string{"CF-Connecting-IP", "True-Client-IP", "X-Forwarded-For"}
+
+	DefaultRelayTimeout = 120
+	DefaultRelayHistory = 100
+
+	DefaultTapNetwork     = "tcp"
+	DefaultTapFormat      = "har"
+	DefaultTapStage       = "upstream"
+	DefaultTapMaxBodySize = 1 << 20
+	DefaultTapQueue       = 1024
+
+	DefaultRetentionInterval  = 60
+	DefaultKillSwitchInterval = 5
+
+	DefaultHealthTimeout = 5
+
+	DefaultDiagnosticsReport  = 60
+	DefaultDiagnosticsDumpDir = "dumps"
+
+	DefaultTracingEndpoint = "http://127.0.0.1:4318/v1/traces"
+	DefaultTracingService  = "muraena"
+	DefaultTracingSampling = 100
+
+	DefaultWatchPaths    = []string{"/"}
+	DefaultWatchInterval = 60
+
+	DefaultClusterInterval  = 5
+	DefaultClusterLeaderTTL = 15
+
+	DefaultDashboardListen  = "127.0.0.1:8888"
+	DefaultDashboardHistory = 500
+
+	DefaultMaxIdleConns        = 512
+	DefaultMaxIdleConnsPerHost = 64
+	DefaultIdleConnTimeout     = 90
+	DefaultTCPKeepAlive        = 30
+	DefaultDialTimeout         = 30
+	DefaultTLSHandshakeTimeout = 10
+
+	DefaultMaxHeaderBytes       = 64 << 10
+	DefaultMaxHeaders           = 100
+	DefaultMaxHeaderNameLength  = 256
+	DefaultMaxHeaderValueLength = 16 << 10
+
+	DefaultRetryAttempts = 2
+	DefaultRetryBackoff  = 100
+	DefaultRetryBudget   = 20
+
+	DefaultCircuitBreakerFailures = 10
+	DefaultCircuitBreakerCooldown = 60
+
+	DefaultUnknownHostAction = "proxy"
+
+	DefaultReadHeaderTimeout    = 10
+	DefaultIdleTimeout          = 120
+	DefaultUpstreamQueueTimeout = 30
 )
 
 type Redirect struct {
@@ -30,6 +145,148 @@ type Redirect struct {
 	HTTPStatusCode int    `toml:"httpStatusCode"`
 }
 
+// JSONRule restricts the transformation of the JSON bodies exchanged on a path to the values
+// matching the selectors, leaving the rest of the document untouched.
+type JSONRule struct {
+	// Path is matched exactly, or as a regular expression if enclosed in ^ and $
+	Path string `toml:"path"`
+	// Direction is request, response or both (default)
+	Direction string   `toml:"direction"`
+	Selectors []string `toml:"selectors"`
+}
+
+// GRPCMethod binds a gRPC method to the message types it exchanges.
+type GRPCMethod struct {
+	// Path is the request path of the method, i.e. /package.Service/Method
+	Path     string `toml:"path"`
+	Request  string `toml:"request"`
+	Response string `toml:"response"`
+}
+
+// SessionProfile lists the cookies constituting an authenticated session of a target.
+// A victim session is complete once all of them have been captured.
+type SessionProfile struct {
+	Name string `toml:"name" json:"name" yaml:"name"`
+	// Cookies are matched by name, exactly or as a regular expression if enclosed in ^ and $
+	Cookies []string `toml:"cookies" json:"cookies" yaml:"cookies"`
+	// Domains restrict the cookies to those set for one of the given domains or their subdomains, any if empty
+	Domains []string `toml:"domains" json:"domains" yaml:"domains"`
+}
+
+// SecretPattern extracts a secret, i.e. a credential, from the requests sent to the secrets paths
+type SecretPattern struct {
+	Label    string `toml:"label" json:"label" yaml:"label"`
+	Matching string `toml:"matching" json:"matching" yaml:"matching"`
+	Start    string `toml:"start" json:"start" yaml:"start"`
+	End      string `toml:"end" json:"end" yaml:"end"`
+}
+
+// GraphQLSecret extracts a secret from the variables of the GraphQL operations sent to the secrets paths
+type GraphQLSecret struct {
+	Label string `toml:"label" json:"label" yaml:"label"`
+	// Operation is the operation name, exactly or as a regular expression if enclosed in ^ and $, any if empty
+	Operation string `toml:"operation" json:"operation" yaml:"operation"`
+	// Variable is the path of the secret in the variables, i.e. input.credentials.password
+	Variable string `toml:"variable" json:"variable" yaml:"variable"`
+}
+
+// ResponseVariant is a variant of the transformation of the responses, i.e. a new overlay injected in the pages,
+// tested on a share of the victims. The victims of no variant are the control group.
+type ResponseVariant struct {
+	Name string `toml:"name"`
+	// Weight is the percentage of the victims assigned to the variant
+	Weight int `toml:"weight"`
+	// Tags assign the victims tagged by the enrichment webhook, regardless of the weights
+	Tags []string `toml:"tags"`
+	// Replace are the replacements applied to the transformed responses, as pairs of strings
+	Replace [][]string `toml:"replace"`
+	// Inject is the HTML injected at the end of the body of the pages
+	Inject string `toml:"inject"`
+}
+
+// ClientRule handles the clients of a class, detected from their User-Agent and client hints.
+// The first rule matching a request applies.
+type ClientRule struct {
+	Name string `toml:"name"`
+	// Class is mobile, tablet or desktop, any if empty
+	Class string `toml:"class"`
+	// OS lists the operating systems matched: android, ios, windows, macos, linux or chromeos, any if empty
+	OS []string `toml:"os"`
+	// Paths restrict the rule, matched exactly, or as regular expressions if enclosed in ^ and $, any if empty
+	Paths []string `toml:"paths"`
+
+	// Action is rewrite (default), redirect or decoy
+	Action string `toml:"action"`
+	// Redirect is the URL the clients are redirected to (redirect)
+	Redirect string `toml:"redirect"`
+	// Replace and Inject transform the responses, as the ones of the variants (rewrite)
+	Replace [][]string `toml:"replace"`
+	Inject  string     `toml:"inject"`
+}
+
+// RoutingProfile is an origin serving the requests of the victims routed to it by the enrichment webhook,
+// instead of the target, i.e. a decoy or a dedicated instance of the target
+type RoutingProfile struct {
+	Name   string `toml:"name"`
+	Origin string `toml:"origin"`
+}
+
+// EventSink is a destination of the session events
+type EventSink struct {
+	// Type is file, redis, kafka or syslog
+	Type string `toml:"type"`
+	// Types restricts the events sent to the sink, all if empty
+	Types []string `toml:"types"`
+
+	// Path of the NDJSON file (file)
+	Path string `toml:"path"`
+	// Stream name and approximate maximum length (redis)
+	Stream string `toml:"stream"`
+	MaxLen int    `toml:"maxLen"`
+	// Endpoint of the Kafka REST proxy and topic (kafka)
+	Endpoint string `toml:"endpoint"`
+	Topic    string `toml:"topic"`
+	// Address (host:port) of the syslog collector, reached over tcp or tls, and format of the events, cef or leef (syslog)
+	Address   string `toml:"address"`
+	Transport string `toml:"transport"`
+	Format    string `toml:"format"`
+	Insecure  bool   `toml:"insecure"`
+}
+
+// ClientCertificate is a client certificate presented to upstream origins requiring mutual TLS.
+// The certificate can be provided as a PEM certificate/key pair or as a PKCS#12 bundle.
+type ClientCertificate struct {
+	Hostname    string `toml:"hostname"`
+	Certificate string `toml:"certificate"`
+	Key         string `toml:"key"`
+	PKCS12      string `toml:"pkcs12"`
+	Password    string `toml:"password"`
+}
+
+// ListenerCertificate is an additional certificate served by the TLS listener
+// to clients requesting one of the given hostnames via SNI.
+// Hostnames can be exact (login.phishing.click) or wildcards (*.phishing.click).
+type ListenerCertificate struct {
+	Hostnames   []string `toml:"hostnames"`
+	Certificate string   `toml:"certificate"`
+	Key         string   `toml:"key"`
+
+	CertificateContent string `toml:"-"`
+	KeyContent         string `toml:"-"`
+}
+
+// Listener is a socket accepting the victim connections
+type Listener struct {
+	Address string `toml:"address"`
+	// Mode is proxy (default), or redirect to redirect to the HTTPS proxy
+	Mode string `toml:"mode"`
+	TLS  bool   `toml:"tls"`
+	// HSTS is the max-age of the Strict-Transport-Security header added to the responses, 0 disables it
+	HSTS int `toml:"hsts"`
+	// ProxyProtocol requires the PROXY protocol (v1 or v2) header sent by a L4 load balancer
+	ProxyProtocol bool `toml:"proxyProtocol"`
+}
+
 type StaticHTTPConfig struct {
 	Enabled       bool   `toml:"enable"`
 	LocalPath     string `toml:"localPath"`
@@ -40,6 +297,22 @@ type StaticHTTPConfig struct {
 
 // Configuration struct
 type Configuration struct {
+	// Include lists the configuration files this one is layered on, relative to its directory
+	Include []string `toml:"include"`
+
+	// Target profiles applied to the configuration, see TargetProfile
+	Profiles struct {
+		// Directory of the profiles, relative to the configuration file
+		Directory string   `toml:"directory"`
+		Use       []string `toml:"use"`
+	} `toml:"profiles"`
+
+	// Persistent state of the campaign, i.e. the origins discovered saved in session.json
+	State struct {
+		// Directory of the state files, relative to the configuration file, the working directory if empty
+		Directory string `toml:"directory"`
+	} `toml:"state"`
+
 	//
 	// Proxy rules
 	//
@@ -55,6 +328,112 @@ type Configuration struct {
 			HTTPport int  `toml:"port"`
 		} `toml:"HTTPtoHTTPS"`
 
+		// Listeners replace the IP, port and HTTPtoHTTPS binds, if defined
+		Listeners []Listener `toml:"listeners"`
+
+		// Graceful shutdown and binary upgrade
+		ReusePort       bool `toml:"reusePort"`
+		ShutdownTimeout int  `toml:"shutdownTimeout"`
+		UpgradeDelay    int  `toml:"upgradeDelay"`
+
+		// Shared cache of the upstream static assets
+		Cache struct {
+			Enabled     bool `toml:"enable"`
+			Size        int  `toml:"size"`
+			MaxBodySize int  `toml:"maxBodySize"`
+
+			// Administration endpoint used to purge the cache
+			Admin struct {
+				Listen string `toml:"listen"`
+				Token  string `toml:"token"`
+			} `toml:"admin"`
+		} `toml:"cache"`
+
+		// Brand assets of the target, i.e. favicon and manifest, served from a local cache
+		Brand struct {
+			Enabled bool     `toml:"enable"`
+			Paths   []string `toml:"paths"`
+			// Directory persisting the cached assets, where they can also be provided
+			Directory string `toml:"directory"`
+		} `toml:"brand"`
+
+		// CDNs and load balancers fronting the listener, whose headers carry the address of the client
+		TrustedProxies struct {
+			Networks []string `toml:"networks"`
+			Headers  []string `toml:"headers"`
+		} `toml:"trustedProxies"`
+
+		// Upstream connection pooling and timeouts (seconds)
+		Transport struct {
+			MaxIdleConns          int `toml:"maxIdleConns"`
+			MaxIdleConnsPerHost   int `toml:"maxIdleConnsPerHost"`
+			MaxConnsPerHost       int `toml:"maxConnsPerHost"`
+			IdleConnTimeout       int `toml:"idleConnTimeout"`
+			TCPKeepAlive          int `toml:"tcpKeepAlive"`
+			DialTimeout           int `toml:"dialTimeout"`
+			TLSHandshakeTimeout   int `toml:"tlsHandshakeTimeout"`
+			ResponseHeaderTimeout int `toml:"responseHeaderTimeout"`
+		} `toml:"transport"`
+
+		// Limits of the victim-facing listener, against resource exhaustion
+		Limits struct {
+			MaxBodyBytes        int64 `toml:"maxBodyBytes"`
+			ReadHeaderTimeout   int   `toml:"readHeaderTimeout"`
+			IdleTimeout         int   `toml:"idleTimeout"`
+			MaxConnectionsPerIP int   `toml:"maxConnectionsPerIP"`
+			BlockOffenders      bool  `toml:"blockOffenders"`
+			// Concurrent upstream requests of each victim and of all of them, the others wait in turn
+			MaxUpstreamPerVictim int `toml:"maxUpstreamPerVictim"`
+			MaxUpstream          int `toml:"maxUpstream"`
+			// Seconds a request waits for an upstream slot
+			UpstreamQueueTimeout int `toml:"upstreamQueueTimeout"`
+		} `toml:"limits"`
+
+		// Strict validation of the requests received by the victim-facing listener
+		RequestValidation struct {
+			Enabled              bool `toml:"enable"`
+			MaxHeaderBytes       int  `toml:"maxHeaderBytes"`
+			MaxHeaders           int  `toml:"maxHeaders"`
+			MaxHeaderNameLength  int  `toml:"maxHeaderNameLength"`
+			MaxHeaderValueLength int  `toml:"maxHeaderValueLength"`
+		} `toml:"requestValidation"`
+
+		// Retries of the idempotent requests failing on transient upstream errors
+		Retry struct {
+			Enabled  bool `toml:"enable"`
+			Attempts int  `toml:"attempts"`
+			// Base of the exponential backoff, in milliseconds
+			Backoff int `toml:"backoff"`
+			// Budget is the percentage of the requests that can be retried
+			Budget int `toml:"budget"`
+		} `toml:"retry"`
+
+		// Circuit breaker of the target, switching to the fallback on outages or bans
+		CircuitBreaker struct {
+			Enabled bool `toml:"enable"`
+			// Consecutive failures (5xx, 429 or connection errors) opening the circuit
+			Failures int `toml:"failures"`
+			// Seconds before a request probes the target again
+			Cooldown int `toml:"cooldown"`
+			// Fallback of the open circuit: maintenance (default), decoy or target
+			Fallback string `toml:"fallback"`
+			// Target is the secondary host, Decoy the URL the victims are redirected to
+			// and Page the HTML file of the maintenance page
+			Target string `toml:"target"`
+			Decoy  string `toml:"decoy"`
+			Page   string `toml:"page"`
+		} `toml:"circuitBreaker"`
+
+		// Requests for a host outside of the phishing domain, i.e. sent to the IP address by the scanners
+		UnknownHost struct {
+			// Action is proxy (default), the host rewritten as the target one, reject, redirect or origin
+			Action string `toml:"action"`
+			// Redirect is the canonical URL the clients are redirected to
+			Redirect string `toml:"redirect"`
+			// Origin is the default origin the requests are proxied to, i.e. https://www.example.com
+			Origin string `toml:"origin"`
+		} `toml:"unknownHost"`
+
 		Protocol string `toml:"-"`
 	} `toml:"proxy"`
 
@@ -76,6 +455,12 @@ type Configuration struct {
 		Base64 struct {
 			Enabled bool     `toml:"enable"`
 			Padding []string `toml:"padding"`
+
+			// Base64 blobs embedded in the responses, i.e. SAML messages and JWT headers
+			Embedded struct {
+				Enabled   bool `toml:"enable"`
+				MinLength int  `toml:"minLength"`
+			} `toml:"embedded"`
 		} `toml:"base64"`
 
 		Request struct {
@@ -95,6 +480,12 @@ type Configuration struct {
 					Value string `toml:"value"`
 				} `toml:"headers"`
 			} `toml:"add"`
+
+			// Files uploaded by the victims through multipart forms
+			Uploads struct {
+				Enabled bool   `toml:"enable"`
+				Path    string `toml:"path"`
+			} `toml:"uploads"`
 		} `toml:"request"`
 
 		Response struct {
@@ -107,8 +498,44 @@ type Configuration struct {
 
 			Cookie struct {
 				SameSite string `toml:"sameSite"`
+				// Secure and Partitioned attributes handling: add, remove or keep (empty)
+				Secure      string `toml:"secure"`
+				Partitioned string `toml:"partitioned"`
 			} `toml:"cookie"`
 
+			// Responses rewritten incrementally, e.g. Server-Sent Events and long-poll endpoints
+			Stream struct {
+				ContentTypes []string `toml:"contentTypes"`
+				Paths        []string `toml:"paths"`
+				BufferSize   int      `toml:"bufferSize"`
+			} `toml:"stream"`
+
+			// Responses larger than Size bytes are passed through without being rewritten, i.e. binary downloads,
+			// unless their Content-Type is listed
+			Bypass struct {
+				Size         int64    `toml:"size"`
+				ContentTypes []string `toml:"contentTypes"`
+			} `toml:"bypass"`
+
+			// Cache of the rewritten static assets
+			Cache struct {
+				Enabled      bool     `toml:"enable"`
+				Size         int      `toml:"size"`
+				MaxBodySize  int      `toml:"maxBodySize"`
+				ContentTypes []string `toml:"contentTypes"`
+			} `toml:"cache"`
+
+			// Pool of workers transforming the large bodies, so that a burst of them cannot exhaust the CPU
+			Workers struct {
+				Enabled bool `toml:"enable"`
+				// Size is the number of workers, the number of CPUs if not set
+				Size int `toml:"size"`
+				// Queue is the number of bodies waiting for a worker, beyond which the responses wait to be queued
+				Queue int `toml:"queue"`
+				// MinBodySize is the size in bytes from which the bodies are transformed by the workers
+				MinBodySize int `toml:"minBodySize"`
+			} `toml:"workers"`
+
 			Remove struct {
 				Headers []string `toml:"headers"`
 			} `toml:"remove"`
@@ -119,11 +546,115 @@ type Configuration struct {
 					Value string `toml:"value"`
 				} `toml:"headers"`
 			} `toml:"add"`
+
+			// Security headers of the target responses
+			Security struct {
+				// HSTS handling of the Strict-Transport-Security header: keep (empty), remove or rewrite
+				HSTS string `toml:"hsts"`
+				// KeepPinning keeps the Expect-CT, Public-Key-Pins and Alt-Svc headers, removed otherwise
+				KeepPinning bool `toml:"keepPinning"`
+			} `toml:"security"`
 		} `toml:"response"`
+
+		// Content never passed through the string replacement, in both directions, i.e. binaries and signed payloads
+		Protect struct {
+			ContentTypes []string `toml:"contentTypes"`
+			// Paths are regular expressions matched against the request path
+			Paths []string `toml:"paths"`
+			// Sniff detects the content type from the magic bytes of the body, whatever the declared one
+			Sniff bool `toml:"sniff"`
+		} `toml:"protect"`
+
+		// Service workers of the target: rewrite (empty) their scripts or replace them with the unregister shim
+		ServiceWorker struct {
+			Mode string `toml:"mode"`
+		} `toml:"serviceWorker"`
+
+		// Escaped URL literals of the dynamic imports, workers and WebAssembly streaming fetches
+		Modules struct {
+			Enabled bool `toml:"enable"`
+		} `toml:"modules"`
+
+		// Cross-origin requests between the proxied origins
+		CORS struct {
+			// Permissive paths (regular expressions matched against the request path) whose preflights are answered
+			// by the proxy, allowing any proxied origin with credentials
+			Permissive []string `toml:"permissive"`
+			// MaxAge of the answered preflights, in seconds
+			MaxAge int `toml:"maxAge"`
+		} `toml:"cors"`
+
+		// JSON-aware transformation rules
+		JSON []JSONRule `toml:"json"`
+
+		// gRPC-web messages rewriting, gRPC content is otherwise passed through untouched
+		GRPCWeb struct {
+			// Descriptors are FileDescriptorSet files, e.g. generated by protoc --include_imports --descriptor_set_out
+			Descriptors []string     `toml:"descriptors"`
+			Methods     []GRPCMethod `toml:"methods"`
+		} `toml:"grpcWeb"`
 	} `toml:"transform"`
 
 	Redirects []Redirect `toml:"redirect"`
 
+	//
+	// Storage of the tracking data
+	//
+	Storage struct {
+		// Type is redis (default), postgres or sqlite
+		Type string `toml:"type"`
+		// DSN is the data source name of the SQL database
+		DSN string `toml:"dsn"`
+		// PublicKey encrypts the captured credentials and cookies at rest (see -keygen)
+		PublicKey string `toml:"publicKey"`
+	} `toml:"storage"`
+
+	//
+	// Retention of the captured data
+	//
+	Retention struct {
+		// Days after which the victims are purged, 0 keeps them forever
+		Days int `toml:"days"`
+		// Interval between purges, in minutes
+		Interval int `toml:"interval"`
+		// Maximum number of credentials and cookies stored per victim, 0 is unlimited
+		MaxCredentials int `toml:"maxCredentials"`
+		MaxCookies     int `toml:"maxCookies"`
+
+		// Panic wipes all the captured data and the session state, then stops Muraena
+		Panic struct {
+			// Signal enables the wipe on SIGUSR1, where available
+			Signal bool   `toml:"signal"`
+			Listen string `toml:"listen"`
+			Token  string `toml:"token"`
+		} `toml:"panic"`
+
+		// KillSwitch stops the proxying once the kill value is published by the operator,
+		// in a DNS TXT record or at an HTTPS URL
+		KillSwitch struct {
+			DNS   string `toml:"dns"`
+			URL   string `toml:"url"`
+			Value string `toml:"value"`
+			// Interval between checks, in minutes
+			Interval int `toml:"interval"`
+			// Purge wipes the captured data once killed
+			Purge bool `toml:"purge"`
+		} `toml:"killSwitch"`
+	} `toml:"retention"`
+
+	//
+	// Resolver
+	//
+	Resolver struct {
+		// Type of resolver used for upstream lookups: system (default), doh, dot
+		Type    string `toml:"type"`
+		Server  string `toml:"server"`
+		Timeout int    `toml:"timeout"`
+
+		// Static resolution table: hostname = "IP[,IP...]"
+		Hosts map[string]string `toml:"hosts"`
+	} `toml:"resolver"`
+
 	//
 	// Logging
 	//
@@ -132,6 +663,29 @@ type Configuration struct {
 		FilePath string `toml:"filePath"`
 	} `toml:"log"`
 
+	//
+	// Privacy of the victims, for the engagements prohibiting to store their IP addresses
+	//
+	Privacy struct {
+		// IP is raw (default), truncate to mask the host part of the addresses, or hash to pseudonymize them
+		IP string `toml:"ip"`
+		// Salt is the key of the hashed addresses, the phishing and target domains if empty
+		Salt string `toml:"salt"`
+	} `toml:"privacy"`
+
+	//
+	// Tracing of the proxy pipeline, exported to an OpenTelemetry collector
+	//
+	Tracing struct {
+		Enabled bool `toml:"enable"`
+		// Endpoint is the OTLP/HTTP traces endpoint of the collector
+		Endpoint string            `toml:"endpoint"`
+		Headers  map[string]string `toml:"headers"`
+		Service  string            `toml:"service"`
+		// Sampling is the percentage of the requests traced
+		Sampling int `toml:"sampling"`
+	} `toml:"tracing"`
+
 	//
 	// DB (Redis)
 	//
@@ -163,6 +717,21 @@ type Configuration struct {
 		SessionTicketsDisabled   bool   `toml:"SessionTicketsDisabled"`
 		InsecureSkipVerify       bool   `toml:"insecureSkipVerify"`
 		RenegotiationSupport     string `toml:"renegotiationSupport"`
+
+		// Listener settings, applied to the victim-facing TLS listener only
+		Listener struct {
+			MinVersion   string                `toml:"minVersion"`
+			MaxVersion   string                `toml:"maxVersion"`
+			CipherSuites []string              `toml:"cipherSuites"`
+			ALPN         []string              `toml:"alpn"`
+			Certificates []ListenerCertificate `toml:"certificates"`
+		} `toml:"listener"`
+
+		// Client certificates presented to origins requiring mutual TLS
+		Client struct {
+			ClientCertificate
+			Origins []ClientCertificate `toml:"origins"`
+		} `toml:"client"`
 	} `toml:"tls"`
 
 	//
@@ -171,6 +740,8 @@ type Configuration struct {
 	Tracking struct {
 		Enabled             bool `toml:"enable"`
 		TrackRequestCookies bool `toml:"trackRequestCookies"`
+		// PinLanguage sends the Accept-Language of the first request of a victim with all its requests
+		PinLanguage bool `toml:"pinLanguage"`
 
 		Trace struct {
 			Identifier     string `toml:"identifier"`
@@ -188,13 +759,40 @@ type Configuration struct {
 		Secrets struct {
 			Paths []string `toml:"paths"`
 
-			Patterns []struct {
-				Label    string `toml:"label"`
-				Matching string `toml:"matching"`
-				Start    string `toml:"start"`
-				End      string `toml:"end"`
-			} `toml:"patterns"`
+			Patterns []SecretPattern `toml:"patterns"`
+			GraphQL  []GraphQLSecret `toml:"graphql"`
+
+			// Capture is what is stored of the credentials: value, fact (the submission and the length) or hash
+			Capture string `toml:"capture"`
+			// Salt is the key of the hashed credentials, the phishing and target domains if empty
+			Salt string `toml:"salt"`
 		} `toml:"secrets"`
+
+		// Sessions are the profiles of the authenticated sessions of the targets
+		Sessions []SessionProfile `toml:"sessions"`
+
+		// Enrichment of the new victims by an external webhook, tagging, blocking or routing them
+		Enrichment struct {
+			Enabled bool   `toml:"enable"`
+			URL     string `toml:"url"`
+			// Token is sent as a bearer token, if set
+			Token string `toml:"token"`
+			// Timeout of the webhook, in milliseconds
+			Timeout int `toml:"timeout"`
+			// Failure is the decision when the webhook fails or times out: allow or block
+			Failure string `toml:"failure"`
+			// Profiles are the origins the webhook can route the victims to
+			Profiles []RoutingProfile `toml:"profiles"`
+		} `toml:"enrichment"`
+
+		// CSRF tokens of the victims altered by the rewrite of the responses, restored in their requests
+		CSRF struct {
+			Enabled bool `toml:"enable"`
+			// Cookies are the names of the cookies carrying the tokens
+			Cookies []string `toml:"cookies"`
+			// Fields are the names of the form fields, JSON keys, meta tags and headers carrying the tokens
+			Fields []string `toml:"fields"`
+		} `toml:"csrf"`
 	} `toml:"tracking"`
 
 	// Crawler
@@ -202,6 +800,8 @@ type Configuration struct {
 		Enabled bool `toml:"enable"`
 		Depth   int  `toml:"depth"`
 		UpTo    int  `toml:"upto"`
+		// Patterns of the hosts found in the script bundles, the subdomains of the target if empty
+		Patterns []string `toml:"patterns"`
 	} // `toml:"crawler"`  TODO: Temporarily disabled
 
 	//
@@ -240,6 +840,230 @@ type Configuration struct {
 		GeoDB   string `toml:"geoDB"`
 	} `toml:"watchdog"`
 
+	//
+	// Relay of the victim submissions (i.e. OTPs) to the operator
+	//
+	Relay struct {
+		Enabled bool   `toml:"enable"`
+		Listen  string `toml:"listen"`
+		Token   string `toml:"token"`
+
+		// Paths are matched exactly, or as regular expressions if enclosed in ^ and $
+		Paths []string `toml:"paths"`
+		// Hold the submissions until resumed by the operator, or for Timeout seconds
+		Hold    bool `toml:"hold"`
+		Timeout int  `toml:"timeout"`
+		// History is the number of submissions kept
+		History int `toml:"history"`
+	} `toml:"relay"`
+
+	//
+	// Awareness training: the credential submissions are answered with an education page instead of reaching the
+	// target, and the credentials are stored without their values
+	//
+	Training struct {
+		Enabled bool `toml:"enable"`
+		// Paths of the submissions, in addition to the ones where the tracker finds credentials.
+		// They are matched exactly, or as regular expressions if enclosed in ^ and $
+		Paths []string `toml:"paths"`
+		// Page is the HTML file of the education page, a built-in one if empty
+		Page string `toml:"page"`
+		// Redirect is the URL the users are redirected to, i.e. a training platform, instead of the page
+		Redirect string `toml:"redirect"`
+	} `toml:"training"`
+
+	//
+	// A/B variants of the responses, each victim being assigned to one of them by tag or by percentage
+	//
+	Variants []ResponseVariant `toml:"variants"`
+
+	//
+	// Handling of the client classes, i.e. pushing the mobile victims to a different flow
+	//
+	Clients []ClientRule `toml:"clients"`
+
+	//
+	// Tap mirroring the proxied traffic to an external analyzer, i.e. Burp or a custom tool, without being inline
+	//
+	Tap struct {
+		Enabled bool `toml:"enable"`
+		// Network is tcp or unix
+		Network string `toml:"network"`
+		Address string `toml:"address"`
+		// Format is har, one HAR entry per line, or frame, each HAR entry prefixed by its 4 bytes length
+		Format string `toml:"format"`
+		// Stage is upstream, the traffic exchanged with the target, i.e. after the rewrite of the requests,
+		// or victim, the traffic exchanged with the victims, i.e. after the rewrite of the responses
+		Stage string `toml:"stage"`
+		// Paths are matched exactly, or as regular expressions if enclosed in ^ and $, all if empty
+		Paths []string `toml:"paths"`
+		// MaxBodySize is the number of bytes of the bodies mirrored, the rest is truncated
+		MaxBodySize int `toml:"maxBodySize"`
+		// Queue is the number of exchanges waiting to be sent, the others are dropped
+		Queue int `toml:"queue"`
+	} `toml:"tap"`
+
+	//
+	// Events
+	//
+	Events struct {
+		Enabled bool        `toml:"enable"`
+		Sinks   []EventSink `toml:"sinks"`
+	} `toml:"events"`
+
+	//
+	// GoPhish campaign delivering the lures, where the clicks and the submissions of the recipients are reported
+	//
+	GoPhish struct {
+		Enabled bool `toml:"enable"`
+		// URL of the GoPhish phishing server, the listener of the landing pages
+		URL string `toml:"url"`
+		// Recipients is a CSV file of lure tokens and GoPhish recipient IDs, the tokens being the recipient IDs if empty
+		Recipients string `toml:"recipients"`
+		// Insecure skips the verification of the certificate of the phishing server
+		Insecure bool `toml:"insecure"`
+	} `toml:"gophish"`
+
+	//
+	// Cluster of instances sharing the state through Redis
+	//
+	Cluster struct {
+		Enabled bool `toml:"enable"`
+		// Node identifies this instance, the hostname and process ID by default
+		Node string `toml:"node"`
+		// Interval between the synchronizations of the shared state, in seconds
+		Interval int `toml:"interval"`
+		// LeaderTTL is the time after which the leadership of an unresponsive node expires, in seconds
+		LeaderTTL int `toml:"leaderTTL"`
+	} `toml:"cluster"`
+
+	//
+	// Sandbox applied once the listeners are bound
+	//
+	Sandbox struct {
+		// User and Group to switch to, dropping the root privileges
+		User  string `toml:"user"`
+		Group string `toml:"group"`
+		// Chroot confines the process to the directory, which becomes the working directory
+		Chroot string `toml:"chroot"`
+
+		// Landlock restricts the file system access to the listed paths (Linux 5.13+)
+		Landlock struct {
+			Enabled   bool     `toml:"enable"`
+			ReadOnly  []string `toml:"readOnly"`
+			ReadWrite []string `toml:"readWrite"`
+		} `toml:"landlock"`
+
+		// Seccomp denies the syscalls not needed by a running proxy, i.e. execve and ptrace
+		Seccomp bool `toml:"seccomp"`
+	} `toml:"sandbox"`
+
+	//
+	// Schedule: outside of the campaign windows the clients get the decoy and nothing is captured
+	//
+	Schedule struct {
+		Enabled bool `toml:"enable"`
+		// Start and End bound the campaign, as RFC 3339 timestamps or dates
+		Start    string `toml:"start"`
+		End      string `toml:"end"`
+		Timezone string `toml:"timezone"`
+		// Windows are the recurring windows the campaign is active in, always if empty
+		Windows []ScheduleWindow `toml:"windows"`
+		// Decoy is the URL the clients are redirected to outside of the schedule, a 404 page if empty
+		Decoy string `toml:"decoy"`
+	} `toml:"schedule"`
+
+	//
+	// Dry run: the operator browses the target through the proxy to discover the origins to configure
+	//
+	DryRun struct {
+		Enabled bool `toml:"enable"`
+		// Allow restricts the proxy to the listed IP addresses and CIDRs, the operator ones
+		Allow []string `toml:"allow"`
+		// Report is the file where the suggested configuration is written
+		Report string `toml:"report"`
+	} `toml:"dryRun"`
+
+	//
+	// Operator session, browsing the target through the proxy to compare the rewritten pages with the original ones
+	//
+	Operator struct {
+		Enabled bool `toml:"enable"`
+		// Allow lists the IP addresses and CIDRs of the operator
+		Allow []string `toml:"allow"`
+		// Mode is original, the responses passed untouched, annotate, the transformed responses listing the
+		// replacements applied, or markers, the replaced substrings wrapped in marker comments
+		Mode string `toml:"mode"`
+		// Offsets is the file where the replacements found in the annotated responses are logged, with their
+		// byte ranges in the original body
+		Offsets string `toml:"offsets"`
+
+		// Trace logs how the requests of the operator, or carrying the secret, and their responses are rewritten
+		Trace struct {
+			Enabled bool `toml:"enable"`
+			// Secret turns on the trace of the requests carrying it in the X-Muraena-Trace header, from any address
+			Secret string `toml:"secret"`
+		} `toml:"trace"`
+	} `toml:"operator"`
+
+	//
+	// Health check and readiness endpoints
+	//
+	Health struct {
+		Listen string `toml:"listen"`
+		// MinCertificateValidity is the number of days the certificate must still be valid to be ready
+		MinCertificateValidity int `toml:"minCertificateValidity"`
+		// Timeout of the upstream reachability check, in seconds
+		Timeout int `toml:"timeout"`
+	} `toml:"health"`
+
+	//
+	// Runtime diagnostics: pprof, expvar and memory reports
+	//
+	Diagnostics struct {
+		Listen string `toml:"listen"`
+		Token  string `toml:"token"`
+		// Report is the interval between the memory reports, in minutes
+		Report int `toml:"report"`
+		// DumpDir is the directory where the goroutine and heap dumps are written
+		DumpDir string `toml:"dumpDir"`
+	} `toml:"diagnostics"`
+
+	//
+	// Watch of the target pages, alerting when their structure changes
+	//
+	Watch struct {
+		Enabled bool     `toml:"enable"`
+		Paths   []string `toml:"paths"`
+		// Interval between fetches, in minutes
+		Interval int `toml:"interval"`
+		// State is the file keeping the known structures across restarts, memory only if empty
+		State string `toml:"state"`
+	} `toml:"watch"`
+
+	//
+	// Administration endpoints
+	//
+	Admin struct {
+		// Tokens granting the viewer or operator role on all the admin endpoints
+		Tokens []AdminToken `toml:"tokens"`
+		// Allow restricts the admin endpoints to the listed IP addresses and CIDRs
+		Allow []string `toml:"allow"`
+		// AuditLog is the file where the administrative actions are appended
+		AuditLog string `toml:"auditLog"`
+	} `toml:"admin"`
+
+	//
+	// Dashboard
+	//
+	Dashboard struct {
+		Enabled bool   `toml:"enable"`
+		Listen  string `toml:"listen"`
+		Token   string `toml:"token"`
+		// History is the number of events kept
+		History int `toml:"history"`
+	} `toml:"dashboard"`
+
 	//
 	// Telegram
 	//
@@ -248,27 +1072,60 @@ type Configuration struct {
 		BotToken string   `toml:"botToken"`
 		ChatIDs  []string `toml:"chatIDs"`
 	} `toml:"telegram"`
+
+	//
+	// Email digests of the campaign events, for the engagement networks where Telegram cannot be reached
+	//
+	Email struct {
+		Enabled bool `toml:"enable"`
+		// Server is the host:port of the SMTP server
+		Server string `toml:"server"`
+		// TLS is starttls, tls (implicit TLS, i.e. on port 465) or none
+		TLS      string `toml:"tls"`
+		Insecure bool   `toml:"insecure"`
+		Username string `toml:"username"`
+		Password string `toml:"password"`
+
+		From string   `toml:"from"`
+		To   []string `toml:"to"`
+
+		// Types restricts the events notified, all if empty
+		Types []string `toml:"types"`
+		// Interval between the digests, in seconds
+		Interval int `toml:"interval"`
+		// Batch is the number of events sending a digest before the interval
+		Batch int `toml:"batch"`
+	} `toml:"email"`
 }
 
 // GetConfiguration returns the configuration object
 func (s *Session) GetConfiguration() (err error) {
 
-	cb, err := ioutil.ReadFile(*s.Options.ConfigFilePath)
-	if err != nil {
-		return errors.New(fmt.Sprintf("Error reading configuration file %s: %s", *s.Options.ConfigFilePath, err))
-	}
 	c := Configuration{}
-	if err := toml.Unmarshal(cb, &c); err != nil {
+	if err := loadConfiguration(*s.Options.ConfigFilePath, &c); err != nil {
 		return errors.New(fmt.Sprintf("Error unmarshalling TOML configuration file %s: %s", *s.Options.ConfigFilePath,
 			err))
 	}
 
+	s.references = nil
+	if err := interpolate(reflect.ValueOf(&c), "", &s.references); err != nil {
+		return err
+	}
+
 	s.Config = &c
 
+	if err := s.ApplyProfiles(); err != nil {
+		return err
+	}
+
 	if s.Config.Proxy.Phishing == "" || s.Config.Proxy.Target == "" {
 		return errors.New(fmt.Sprintf("Missing phishing/destination from configuration!"))
 	}
 
+	// Internationalized domains are handled in their punycode form, the one sent by the browsers
+	s.Config.Proxy.Phishing = core.DomainToASCII(s.Config.Proxy.Phishing)
+	s.Config.Proxy.Target = core.DomainToASCII(s.Config.Proxy.Target)
+
 	// Listening
 	if s.Config.Proxy.IP == "" {
 		s.Config.Proxy.IP = DefaultIP
@@ -278,7 +1135,7 @@ func (s *Session) GetConfiguration() (err error) {
 	if s.Config.Proxy.Listener == "" {
 		s.Config.Proxy.Listener = DefaultListener
 	} else if !core.StringContains(strings.ToLower(s.Config.Proxy.Listener), []string{"tcp", "tcp4", "tcp6"}) {
-		s.Config.Proxy.Listener = DefaultListener
+		return errors.New(fmt.Sprintf("Invalid proxy.listener %s: it must be one of tcp, tcp4, tcp6", s.Config.Proxy.Listener))
 	}
 
 	if s.Config.Proxy.Port == 0 {
@@ -288,6 +1145,213 @@ func (s *Session) GetConfiguration() (err error) {
 		}
 	}
 
+	// Graceful shutdown
+	if s.Config.Proxy.ShutdownTimeout <= 0 {
+		s.Config.Proxy.ShutdownTimeout = DefaultShutdownTimeout
+	}
+
+	if s.Config.Proxy.UpgradeDelay <= 0 {
+		s.Config.Proxy.UpgradeDelay = DefaultUpgradeDelay
+	}
+
+	// Upstream cache
+	if s.Config.Proxy.Cache.Enabled {
+		c := &s.Config.Proxy.Cache
+		if c.Size <= 0 {
+			c.Size = DefaultUpstreamCacheSize
+		}
+		if c.MaxBodySize <= 0 {
+			c.MaxBodySize = DefaultUpstreamCacheMaxBodySize
+		}
+	}
+
+	if s.Config.Proxy.Brand.Enabled && len(s.Config.Proxy.Brand.Paths) == 0 {
+		s.Config.Proxy.Brand.Paths = DefaultBrandPaths
+	}
+
+	if len(s.Config.Proxy.TrustedProxies.Networks) > 0 && len(s.Config.Proxy.TrustedProxies.Headers) == 0 {
+		s.Config.Proxy.TrustedProxies.Headers = DefaultTrustedProxyHeaders
+	}
+
+	// Relay
+	if s.Config.Relay.Enabled {
+		r := &s.Config.Relay
+		if r.Timeout <= 0 {
+			r.Timeout = DefaultRelayTimeout
+		}
+		if r.History <= 0 {
+			r.History = DefaultRelayHistory
+		}
+	}
+
+	// Tap
+	if s.Config.Tap.Enabled {
+		t := &s.Config.Tap
+		if t.Network == "" {
+			t.Network = DefaultTapNetwork
+		}
+		if t.Format == "" {
+			t.Format = DefaultTapFormat
+		}
+		if t.Stage == "" {
+			t.Stage = DefaultTapStage
+		}
+		if t.MaxBodySize <= 0 {
+			t.MaxBodySize = DefaultTapMaxBodySize
+		}
+		if t.Queue <= 0 {
+			t.Queue = DefaultTapQueue
+		}
+	}
+
+	// Retention
+	if s.Config.Retention.Interval <= 0 {
+		s.Config.Retention.Interval = DefaultRetentionInterval
+	}
+	if s.Config.Retention.KillSwitch.Interval <= 0 {
+		s.Config.Retention.KillSwitch.Interval = DefaultKillSwitchInterval
+	}
+
+	// Health
+	if s.Config.Health.Timeout <= 0 {
+		s.Config.Health.Timeout = DefaultHealthTimeout
+	}
+
+	// Diagnostics
+	if s.Config.Diagnostics.Report <= 0 {
+		s.Config.Diagnostics.Report = DefaultDiagnosticsReport
+	}
+	if s.Config.Diagnostics.DumpDir == "" {
+		s.Config.Diagnostics.DumpDir = DefaultDiagnosticsDumpDir
+	}
+
+	// Tracing
+	if s.Config.Tracing.Enabled {
+		t := &s.Config.Tracing
+		if t.Endpoint == "" {
+			t.Endpoint = DefaultTracingEndpoint
+		}
+		if t.Service == "" {
+			t.Service = DefaultTracingService
+		}
+		if t.Sampling <= 0 {
+			t.Sampling = DefaultTracingSampling
+		}
+	}
+
+	// Watch
+	if s.Config.Watch.Enabled {
+		if len(s.Config.Watch.Paths) == 0 {
+			s.Config.Watch.Paths = DefaultWatchPaths
+		}
+		if s.Config.Watch.Interval <= 0 {
+			s.Config.Watch.Interval = DefaultWatchInterval
+		}
+	}
+
+	// Cluster
+	if s.Config.Cluster.Enabled {
+		c := &s.Config.Cluster
+		if c.Node == "" {
+			hostname, _ := os.Hostname()
+			c.Node = fmt.Sprintf("%s-%d", hostname, os.Getpid())
+		}
+		if c.Interval <= 0 {
+			c.Interval = DefaultClusterInterval
+		}
+		if c.LeaderTTL <= 0 {
+			c.LeaderTTL = DefaultClusterLeaderTTL
+		}
+	}
+
+	// Dashboard
+	if s.Config.Dashboard.Enabled {
+		d := &s.Config.Dashboard
+		if d.Listen == "" {
+			d.Listen = DefaultDashboardListen
+		}
+		if d.History <= 0 {
+			d.History = DefaultDashboardHistory
+		}
+	}
+
+	// Upstream transport
+	t := &s.Config.Proxy.Transport
+	if t.MaxIdleConns == 0 {
+		t.MaxIdleConns = DefaultMaxIdleConns
+	}
+	if t.MaxIdleConnsPerHost == 0 {
+		t.MaxIdleConnsPerHost = DefaultMaxIdleConnsPerHost
+	}
+	if t.IdleConnTimeout == 0 {
+		t.IdleConnTimeout = DefaultIdleConnTimeout
+	}
+	if t.TCPKeepAlive == 0 {
+		t.TCPKeepAlive = DefaultTCPKeepAlive
+	}
+	if t.DialTimeout == 0 {
+		t.DialTimeout = DefaultDialTimeout
+	}
+	if t.TLSHandshakeTimeout == 0 {
+		t.TLSHandshakeTimeout = DefaultTLSHandshakeTimeout
+	}
+
+	// Request validation
+	if s.Config.Proxy.RequestValidation.Enabled {
+		v := &s.Config.Proxy.RequestValidation
+		if v.MaxHeaderBytes == 0 {
+			v.MaxHeaderBytes = DefaultMaxHeaderBytes
+		}
+		if v.MaxHeaders == 0 {
+			v.MaxHeaders = DefaultMaxHeaders
+		}
+		if v.MaxHeaderNameLength == 0 {
+			v.MaxHeaderNameLength = DefaultMaxHeaderNameLength
+		}
+		if v.MaxHeaderValueLength == 0 {
+			v.MaxHeaderValueLength = DefaultMaxHeaderValueLength
+		}
+	}
+
+	// Listener limits
+	if s.Config.Proxy.Limits.ReadHeaderTimeout == 0 {
+		s.Config.Proxy.Limits.ReadHeaderTimeout = DefaultReadHeaderTimeout
+	}
+	if s.Config.Proxy.Limits.IdleTimeout == 0 {
+		s.Config.Proxy.Limits.IdleTimeout = DefaultIdleTimeout
+	}
+	if s.Config.Proxy.Limits.UpstreamQueueTimeout == 0 {
+		s.Config.Proxy.Limits.UpstreamQueueTimeout = DefaultUpstreamQueueTimeout
+	}
+
+	// Upstream retries
+	if r := &s.Config.Proxy.Retry; r.Enabled {
+		if r.Attempts <= 0 {
+			r.Attempts = DefaultRetryAttempts
+		}
+		if r.Backoff <= 0 {
+			r.Backoff = DefaultRetryBackoff
+		}
+		if r.Budget <= 0 {
+			r.Budget = DefaultRetryBudget
+		}
+	}
+
+	// Circuit breaker
+	if b := &s.Config.Proxy.CircuitBreaker; b.Enabled {
+		if b.Failures <= 0 {
+			b.Failures = DefaultCircuitBreakerFailures
+		}
+		if b.Cooldown <= 0 {
+			b.Cooldown = DefaultCircuitBreakerCooldown
+		}
+	}
+
+	// Unknown hosts
+	if s.Config.Proxy.UnknownHost.Action == "" {
+		s.Config.Proxy.UnknownHost.Action = DefaultUnknownHostAction
+	}
+
 	// HTTPtoHTTPS
 	if s.Config.Proxy.HTTPtoHTTPS.Enabled {
 		if s.Config.Proxy.HTTPtoHTTPS.HTTPport == 0 {
@@ -368,22 +1432,36 @@ func (s *Session) GetConfiguration() (err error) {
 
 		s.Config.Proxy.Protocol = "https://"
 
+		versions := []string{"SSL3.0", "TLS1.0", "TLS1.1", "TLS1.2", "TLS1.3"}
 		s.Config.TLS.MinVersion = strings.ToUpper(s.Config.TLS.MinVersion)
-		if !core.StringContains(s.Config.TLS.MinVersion, []string{"SSL3.0", "TLS1.0", "TLS1.1", "TLS1.2", "TLS1.3"}) {
+		if s.Config.TLS.MinVersion == "" {
 			// Fallback to TLS1
 			s.Config.TLS.MinVersion = "TLS1.0"
+		} else if !core.StringContains(s.Config.TLS.MinVersion, versions) {
+			return errors.New(fmt.Sprintf("Invalid tls.minVersion %s: it must be one of %s",
+				s.Config.TLS.MinVersion, strings.Join(versions, ", ")))
 		}
 
 		s.Config.TLS.MaxVersion = strings.ToUpper(s.Config.TLS.MaxVersion)
-		if !core.StringContains(s.Config.TLS.MaxVersion, []string{"SSL3.0", "TLS1.0", "TLS1.1", "TLS1.2", "TLS1.3"}) {
+		if s.Config.TLS.MaxVersion == "" {
 			// Fallback to TLS1.3
 			s.Config.TLS.MaxVersion = "TLS1.3"
+		} else if !core.StringContains(s.Config.TLS.MaxVersion, versions) {
+			return errors.New(fmt.Sprintf("Invalid tls.maxVersion %s: it must be one of %s",
+				s.Config.TLS.MaxVersion, strings.Join(versions, ", ")))
 		}
 
 		s.Config.TLS.RenegotiationSupport = strings.ToUpper(s.Config.TLS.RenegotiationSupport)
-		if !core.StringContains(s.Config.TLS.RenegotiationSupport, []string{"NEVER", "ONCE", "FREELY"}) {
+		if s.Config.TLS.RenegotiationSupport == "" {
 			// Fallback to NEVER
 			s.Config.TLS.RenegotiationSupport = "NEVER"
+		} else if !core.StringContains(s.Config.TLS.RenegotiationSupport, []string{"NEVER", "ONCE", "FREELY"}) {
+			return errors.New(fmt.Sprintf("Invalid tls.renegotiationSupport %s: it must be one of Never, Once, Freely",
+				s.Config.TLS.RenegotiationSupport))
+		}
+
+		if err = s.CheckTLSListener(); err != nil {
+			return err
 		}
 
 	}
@@ -394,20 +1472,74 @@ func (s *Session) GetConfiguration() (err error) {
 	if s.Config.Transform.Base64.Padding == nil {
 		s.Config.Transform.Base64.Padding = DefaultBase64Padding
 	}
+	if s.Config.Transform.Base64.Embedded.MinLength <= 0 {
+		s.Config.Transform.Base64.Embedded.MinLength = DefaultEmbeddedBase64MinLength
+	}
+
+	for _, v := range []string{s.Config.Transform.Response.Cookie.Secure, s.Config.Transform.Response.Cookie.Partitioned} {
+		if !core.StringContains(strings.ToLower(v), []string{"", "add", "remove"}) {
+			return fmt.Errorf("invalid cookie attribute handling %s: must be add or remove", v)
+		}
+	}
+
+	if s.Config.Transform.Request.Uploads.Enabled && s.Config.Transform.Request.Uploads.Path == "" {
+		s.Config.Transform.Request.Uploads.Path = DefaultUploadsPath
+	}
 
 	if s.Config.Transform.Response.SkipContentType == nil {
 		s.Config.Transform.Response.SkipContentType = DefaultSkipContentType
 	}
 
-	s.Config.Transform.Request.SkipExtensions = []string{
-		"ttf", "otf", "woff", "woff2", "eot", // fonts and images
-		"ase", "art", "bmp", "blp", "cd5", "cit", "cpt", "cr2", "cut", "dds", "dib", "djvu", "egt", "exif", "gif",
-		"gpl", "grf", "icns", "ico", "iff", "jng", "jpeg", "jpg", "jfif", "jp2", "jps", "lbm", "max", "miff", "mng",
-		"msp", "nitf", "ota", "pbm", "pc1", "pc2", "pc3", "pcf", "pcx", "pdn", "pgm", "PI1", "PI2", "PI3", "pict",
-		"pct", "pnm", "pns", "ppm", "psb", "psd", "pdd", "psp", "px", "pxm", "pxr", "qfx", "raw", "rle", "sct", "sgi",
-		"rgb", "int", "bw", "tga", "tiff", "tif", "vtf", "xbm", "xcf", "xpm", "3dv", "amf", "ai", "awg", "cgm", "cdr",
-		"cmx", "dxf", "e2d", "egt", "eps", "fs", "gbr", "odg", "svg", "stl", "vrml", "x3d", "sxd", "v2d", "vnd", "wmf",
-		"emf", "art", "xar", "png", "webp", "jxr", "hdp", "wdp", "cur", "ecw", "iff", "lbm", "liff", "nrrd", "pam",
+	if s.Config.Transform.Protect.ContentTypes == nil {
+		s.Config.Transform.Protect.ContentTypes = DefaultProtectContentTypes
+	}
+
+	if s.Config.Transform.Response.Bypass.Size == 0 {
+		s.Config.Transform.Response.Bypass.Size = DefaultBypassSize
+	}
+
+	if s.Config.Transform.Response.Stream.ContentTypes == nil {
+		s.Config.Transform.Response.Stream.ContentTypes = DefaultStreamContentTypes
+	}
+	if s.Config.Transform.Response.Stream.BufferSize <= 0 {
+		s.Config.Transform.Response.Stream.BufferSize = DefaultStreamBufferSize
+	}
+
+	if s.Config.Transform.Response.Cache.Enabled {
+		c := &s.Config.Transform.Response.Cache
+		if c.Size <= 0 {
+			c.Size = DefaultRewriteCacheSize
+		}
+		if c.MaxBodySize <= 0 {
+			c.MaxBodySize = DefaultRewriteCacheMaxBodySize
+		}
+		if c.ContentTypes == nil {
+			c.ContentTypes = DefaultRewriteCacheContentTypes
+		}
+	}
+
+	if s.Config.Transform.Response.Workers.Enabled {
+		w := &s.Config.Transform.Response.Workers
+		if w.Size <= 0 {
+			w.Size = runtime.NumCPU()
+		}
+		if w.Queue <= 0 {
+			w.Queue = DefaultWorkersQueue
+		}
+		if w.MinBodySize <= 0 {
+			w.MinBodySize = DefaultWorkersMinBodySize
+		}
+	}
+
+	s.Config.Transform.Request.SkipExtensions = []string{
+		"ttf", "otf", "woff", "woff2", "eot", // fonts and images
+		"ase", "art", "bmp", "blp", "cd5", "cit", "cpt", "cr2", "cut", "dds", "dib", "djvu", "egt", "exif", "gif",
+		"gpl", "grf", "icns", "ico", "iff", "jng", "jpeg", "jpg", "jfif", "jp2", "jps", "lbm", "max", "miff", "mng",
+		"msp", "nitf", "ota", "pbm", "pc1", "pc2", "pc3", "pcf", "pcx", "pdn", "pgm", "PI1", "PI2", "PI3", "pict",
+		"pct", "pnm", "pns", "ppm", "psb", "psd", "pdd", "psp", "px", "pxm", "pxr", "qfx", "raw", "rle", "sct", "sgi",
+		"rgb", "int", "bw", "tga", "tiff", "tif", "vtf", "xbm", "xcf", "xpm", "3dv", "amf", "ai", "awg", "cgm", "cdr",
+		"cmx", "dxf", "e2d", "egt", "eps", "fs", "gbr", "odg", "svg", "stl", "vrml", "x3d", "sxd", "v2d", "vnd", "wmf",
+		"emf", "art", "xar", "png", "webp", "jxr", "hdp", "wdp", "cur", "ecw", "iff", "lbm", "liff", "nrrd", "pam",
 		"pcx", "pgf", "sgi", "rgb", "rgba", "bw", "int", "inta", "sid", "ras", "sun", "tga"}
 
 	// Fix Craft config
@@ -431,6 +1563,16 @@ func (s *Session) GetConfiguration() (err error) {
 	return s.DoChecks()
 }
 
+// StateDirectory returns the directory of the state files, resolved relative to the configuration file
+func (s *Session) StateDirectory() string {
+	directory := s.Config.State.Directory
+	if directory != "" && !filepath.IsAbs(directory) && s.Options.ConfigFilePath != nil {
+		directory = filepath.Join(filepath.Dir(*s.Options.ConfigFilePath), directory)
+	}
+
+	return directory
+}
+
 func (s *Session) UpdateConfiguration(domains *[]string) (err error) {
 	config := s.Config
 
@@ -461,24 +1603,156 @@ func (s *Session) DoChecks() (err error) {
 	// Check Redirect
 	s.CheckRedirect()
 
+	// Check the settings with a fixed set of values
+	err = s.CheckValues()
+	if err != nil {
+		return
+	}
+
+	// Check Resolver
+	err = s.CheckResolver()
+	if err != nil {
+		return
+	}
+
+	// Check Listeners
+	err = s.CheckListeners()
+	if err != nil {
+		return
+	}
+
+	// Check Trusted Proxies
+	err = s.CheckTrustedProxies()
+	if err != nil {
+		return
+	}
+
+	// Check Privacy
+	err = s.CheckPrivacy()
+	if err != nil {
+		return
+	}
+
+	// Check TLS client certificates
+	err = s.CheckClientCertificates()
+	if err != nil {
+		return
+	}
+
 	// Check Log
 	err = s.CheckLog()
 	if err != nil {
 		return
 	}
 
+	// Check Tracing
+	err = s.CheckTracing()
+	if err != nil {
+		return
+	}
+
+	// Check Tap
+	err = s.CheckTap()
+	if err != nil {
+		return
+	}
+
+	// Check Relay
+	err = s.CheckRelay()
+	if err != nil {
+		return
+	}
+
 	// Check Tracking
 	err = s.CheckTracking()
 	if err != nil {
 		return
 	}
 
+	// Check Training
+	err = s.CheckTraining()
+	if err != nil {
+		return
+	}
+
+	// Check Variants
+	err = s.CheckVariants()
+	if err != nil {
+		return
+	}
+
+	// Check Clients
+	err = s.CheckClients()
+	if err != nil {
+		return
+	}
+
+	// Check Email
+	err = s.CheckEmail()
+	if err != nil {
+		return
+	}
+
 	// Check Static Server
 	err = s.CheckStaticServer()
 	if err != nil {
 		return
 	}
 
+	// Check Cluster
+	err = s.CheckCluster()
+	if err != nil {
+		return
+	}
+
+	// Check Admin
+	err = s.CheckAdmin()
+	if err != nil {
+		return
+	}
+
+	// Check Diagnostics
+	err = s.CheckDiagnostics()
+	if err != nil {
+		return
+	}
+
+	// Check Retention
+	err = s.CheckRetention()
+	if err != nil {
+		return
+	}
+
+	// Check Sandbox
+	err = s.CheckSandbox()
+	if err != nil {
+		return
+	}
+
+	// Check Dry Run
+	err = s.CheckDryRun()
+	if err != nil {
+		return
+	}
+
+	// Check Storage
+	err = s.CheckStorage()
+	if err != nil {
+		return
+	}
+
+	// Check Operator
+	err = s.CheckOperator()
+	if err != nil {
+		return
+	}
+
+	// Check Schedule
+	err = s.CheckSchedule()
+	if err != nil {
+		return
+	}
+
 	return
 }
 
@@ -505,6 +1779,109 @@ func (s *Session) CheckRedirect() {
 	s.Config.Redirects = redirects
 }
 
+// CheckListeners checks the listeners and, if none is defined, creates them from the IP, port and HTTPtoHTTPS binds.
+func (s *Session) CheckListeners() (err error) {
+	p := &s.Config.Proxy
+	if len(p.Listeners) == 0 {
+		p.Listeners = []Listener{{Address: net.JoinHostPort(p.IP, strconv.Itoa(p.Port)), TLS: s.Config.TLS.Enabled}}
+		if s.Config.TLS.Enabled && p.HTTPtoHTTPS.Enabled {
+			p.Listeners = append(p.Listeners, Listener{
+				Address: net.JoinHostPort(p.IP, strconv.Itoa(p.HTTPtoHTTPS.HTTPport)),
+				Mode:    "redirect",
+			})
+		}
+	}
+
+	proxies := 0
+	for i := range p.Listeners {
+		l := &p.Listeners[i]
+		l.Mode = strings.ToLower(l.Mode)
+		if l.Mode == "" {
+			l.Mode = "proxy"
+		}
+
+		if _, _, err = net.SplitHostPort(l.Address); err != nil && !IsSocketAddress(l.Address) {
+			return fmt.Errorf("Invalid listener address %s: %w", l.Address, err)
+		}
+		err = nil
+
+		switch l.Mode {
+		case "proxy":
+			proxies++
+		case "redirect":
+		default:
+			return fmt.Errorf("Invalid listener %s mode %s: it must be proxy or redirect", l.Address, l.Mode)
+		}
+
+		if l.TLS && !s.Config.TLS.Enabled {
+			return fmt.Errorf("Invalid listener %s: TLS requires the tls section to be enabled", l.Address)
+		}
+	}
+
+	if proxies == 0 {
+		return errors.New("Missing proxy listener")
+	}
+
+	limits := p.Limits
+	if limits.MaxBodyBytes < 0 || limits.ReadHeaderTimeout < 0 || limits.IdleTimeout < 0 || limits.MaxConnectionsPerIP < 0 ||
+		limits.MaxUpstreamPerVictim < 0 || limits.MaxUpstream < 0 || limits.UpstreamQueueTimeout < 0 {
+		return errors.New("Invalid proxy limits: they must not be negative")
+	}
+
+	if b := p.CircuitBreaker; b.Enabled {
+		switch {
+		case strings.EqualFold(b.Fallback, "target") && b.Target == "":
+			return errors.New("Missing proxy circuitBreaker target: it is required by the target fallback")
+		case strings.EqualFold(b.Fallback, "decoy") && b.Decoy == "":
+			return errors.New("Missing proxy circuitBreaker decoy: it is required by the decoy fallback")
+		}
+	}
+
+	switch u := p.UnknownHost; strings.ToLower(u.Action) {
+	case "redirect":
+		if u.Redirect == "" {
+			return errors.New("Missing proxy unknownHost redirect: it is required by the redirect action")
+		}
+	case "origin":
+		if origin, err := url.Parse(u.Origin); err != nil || (origin.Scheme != "http" && origin.Scheme != "https") || origin.Host == "" {
+			return fmt.Errorf("Invalid proxy unknownHost origin %s: it must be an http or https URL", u.Origin)
+		}
+	}
+
+	return
+}
+
+// CheckResolver checks the resolver configuration and falls back to the system resolver if the type is unknown.
+func (s *Session) CheckResolver() (err error) {
+	s.Config.Resolver.Type = strings.ToLower(s.Config.Resolver.Type)
+	if !core.StringContains(s.Config.Resolver.Type, []string{"system", "doh", "dot"}) {
+		s.Config.Resolver.Type = "system"
+	}
+
+	if s.Config.Resolver.Timeout <= 0 {
+		s.Config.Resolver.Timeout = DefaultResolverTimeout
+	}
+
+	switch s.Config.Resolver.Type {
+	case "doh":
+		if !strings.HasPrefix(s.Config.Resolver.Server, "https://") {
+			return errors.New(fmt.Sprintf("Invalid DoH resolver server %s: it must be an https:// URL", s.Config.Resolver.Server))
+		}
+
+	case "dot":
+		if s.Config.Resolver.Server == "" {
+			return errors.New("Missing DoT resolver server")
+		}
+
+		// Default DNS-over-TLS port
+		if _, _, err := net.SplitHostPort(s.Config.Resolver.Server); err != nil {
+			s.Config.Resolver.Server = net.JoinHostPort(s.Config.Resolver.Server, "853")
+		}
+	}
+
+	return
+}
+
 // CheckLog checks the log configuration and disables it if the file is not accessible.
 func (s *Session) CheckLog() (err error) {
 	if !s.Config.Log.Enabled {
@@ -526,12 +1903,443 @@ func (s *Session) CheckLog() (err error) {
 	return
 }
 
+// CheckTracing checks the collector endpoint and the sampling of the tracing
+func (s *Session) CheckTracing() (err error) {
+	t := s.Config.Tracing
+	if !t.Enabled {
+		return
+	}
+
+	u, err := url.Parse(t.Endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("Invalid tracing endpoint %s: it must be an http(s) URL", t.Endpoint)
+	}
+
+	if t.Sampling > 100 {
+		return fmt.Errorf("Invalid tracing sampling %d: it must be a percentage", t.Sampling)
+	}
+
+	return nil
+}
+
+// CheckTap checks the listener of the traffic tap
+func (s *Session) CheckTap() (err error) {
+	t := s.Config.Tap
+	if !t.Enabled {
+		return
+	}
+
+	if t.Address == "" {
+		return errors.New("Missing tap address")
+	}
+
+	return nil
+}
+
+// CheckRelay checks the relay API.
+// It lists and replays the victim submissions, i.e. passwords and OTPs, so it is never exposed without a token.
+func (s *Session) CheckRelay() (err error) {
+	r := s.Config.Relay
+	if !r.Enabled || r.Listen == "" || r.Token != "" {
+		return
+	}
+
+	for _, t := range s.Config.Admin.Tokens {
+		if t.Token != "" && strings.ToLower(t.Role) == RoleOperator {
+			return
+		}
+	}
+
+	return errors.New("Missing relay token: it is required to expose the relay API")
+}
+
+// CheckTraining checks that the submissions of the awareness training can be detected
+func (s *Session) CheckTraining() (err error) {
+	t := s.Config.Training
+	if !t.Enabled {
+		return
+	}
+
+	if len(t.Paths) == 0 && !s.Config.Tracking.Enabled {
+		return errors.New("Missing training paths: the submissions are detected on them or by the tracker")
+	}
+
+	if t.Page != "" && t.Redirect != "" {
+		return errors.New("Invalid training: the page and the redirect are exclusive")
+	}
+
+	// The training never stores the values of the credentials, nor sends the submissions anywhere
+	if s.Config.Tracking.Secrets.Capture == DefaultSecretsCapture {
+		s.Config.Tracking.Secrets.Capture = "fact"
+	}
+	s.Config.Relay.Enabled = false
+	s.Config.Tap.Enabled = false
+	s.Config.Operator.Trace.Enabled, s.Config.Operator.Trace.Secret = false, ""
+
+	return nil
+}
+
 // CheckTracking checks the tracking configuration and disables it if the file is not accessible.
 func (s *Session) CheckTracking() (err error) {
 	if !s.Config.Tracking.Enabled {
 		return
 	}
 
+	secrets := &s.Config.Tracking.Secrets
+	secrets.Capture = strings.ToLower(secrets.Capture)
+	if secrets.Capture == "" {
+		secrets.Capture = DefaultSecretsCapture
+	}
+
+	// The relay, the tap and the rewrite trace record the raw bodies, where the values of the secrets are still in clear
+	if secrets.Capture != DefaultSecretsCapture {
+		switch {
+		case s.Config.Relay.Enabled:
+			return fmt.Errorf("Invalid tracking secrets capture %s: the relay exposes the values of the secrets", secrets.Capture)
+		case s.Config.Tap.Enabled:
+			return fmt.Errorf("Invalid tracking secrets capture %s: the tap exports the values of the secrets", secrets.Capture)
+		case s.Config.Operator.Trace.Enabled || s.Config.Operator.Trace.Secret != "":
+			return fmt.Errorf("Invalid tracking secrets capture %s: the rewrite trace logs the values of the secrets", secrets.Capture)
+		}
+	}
+
+	if err = s.CheckEnrichment(); err != nil {
+		return
+	}
+
+	if csrf := &s.Config.Tracking.CSRF; csrf.Enabled && len(csrf.Cookies) == 0 && len(csrf.Fields) == 0 {
+		csrf.Cookies, csrf.Fields = DefaultCSRFCookies, DefaultCSRFFields
+	}
+
+	for _, profile := range s.Config.Tracking.Sessions {
+		if len(profile.Cookies) == 0 {
+			return fmt.Errorf("session profile %s does not define any cookie", profile.Name)
+		}
+
+		for _, c := range profile.Cookies {
+			if strings.HasPrefix(c, "^") && strings.HasSuffix(c, "$") {
+				if _, err = regexp.Compile(c); err != nil {
+					return fmt.Errorf("invalid cookie %s in session profile %s: %w", c, profile.Name, err)
+				}
+			}
+		}
+	}
+
+	return
+}
+
+// CheckEnrichment checks the webhook enriching the new victims and the origins of its routing profiles
+func (s *Session) CheckEnrichment() (err error) {
+	e := &s.Config.Tracking.Enrichment
+	if !e.Enabled {
+		return
+	}
+
+	if u, err := url.Parse(e.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return fmt.Errorf("Invalid enrichment webhook %s: it must be an http(s) URL", e.URL)
+	}
+
+	if e.Timeout <= 0 {
+		e.Timeout = DefaultEnrichmentTimeout
+	}
+	e.Failure = strings.ToLower(e.Failure)
+	if e.Failure == "" {
+		e.Failure = DefaultEnrichmentFailure
+	}
+
+	names := make(map[string]bool)
+	for _, p := range e.Profiles {
+		if p.Name == "" || names[p.Name] {
+			return fmt.Errorf("Invalid enrichment profile %q: the names must be unique", p.Name)
+		}
+		names[p.Name] = true
+
+		if u, err := url.Parse(p.Origin); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("Invalid origin %s of enrichment profile %s: it must be an http(s) URL", p.Origin, p.Name)
+		}
+	}
+
+	return nil
+}
+
+// CheckCluster checks that the tracking data is kept in a storage shared by the cluster nodes.
+func (s *Session) CheckCluster() (err error) {
+	if !s.Config.Cluster.Enabled || !s.Config.Tracking.Enabled {
+		return
+	}
+
+	if strings.ToLower(s.Config.Storage.Type) == "sqlite" {
+		return errors.New("Invalid cluster storage: the nodes must share the tracking data in Redis or Postgres")
+	}
+
+	return
+}
+
+// CheckAdmin checks the admin tokens and the allowed networks.
+func (s *Session) CheckAdmin() (err error) {
+	for _, t := range s.Config.Admin.Tokens {
+		if t.Token == "" {
+			return fmt.Errorf("Missing token of admin %s", t.Name)
+		}
+
+		if !core.StringContains(strings.ToLower(t.Role), []string{RoleViewer, RoleOperator}) {
+			return fmt.Errorf("Invalid role %s of admin %s: it must be %s or %s", t.Role, t.Name, RoleViewer, RoleOperator)
+		}
+	}
+
+	for _, a := range s.Config.Admin.Allow {
+		if ParseNetwork(a) == nil {
+			return fmt.Errorf("Invalid admin allowed address %s", a)
+		}
+	}
+
+	return
+}
+
+// CheckVariants checks that the victims can be assigned to the response variants
+func (s *Session) CheckVariants() (err error) {
+	if len(s.Config.Variants) == 0 {
+		return
+	}
+
+	if !s.Config.Tracking.Enabled {
+		return errors.New("Invalid variants: the victims are assigned to them by the tracker")
+	}
+
+	names := map[string]bool{DefaultVariant: true}
+	total := 0
+	for _, v := range s.Config.Variants {
+		if v.Name == "" || names[v.Name] {
+			return fmt.Errorf("Invalid variant %q: the names must be unique, and not %s", v.Name, DefaultVariant)
+		}
+		names[v.Name] = true
+
+		if v.Weight < 0 {
+			return fmt.Errorf("Invalid weight of variant %s: it must not be negative", v.Name)
+		}
+		total += v.Weight
+
+		for _, r := range v.Replace {
+			if len(r) != 2 || r[0] == "" {
+				return fmt.Errorf("Invalid replacement %v of variant %s: it must be a pair of strings", r, v.Name)
+			}
+		}
+	}
+
+	if total > 100 {
+		return fmt.Errorf("Invalid variants: their weights add up to %d%%", total)
+	}
+
+	return nil
+}
+
+// CheckClients checks the rules of the client classes
+func (s *Session) CheckClients() (err error) {
+	for i := range s.Config.Clients {
+		c := &s.Config.Clients[i]
+		c.Action = strings.ToLower(c.Action)
+		c.Class = strings.ToLower(c.Class)
+		for j := range c.OS {
+			c.OS[j] = strings.ToLower(c.OS[j])
+			if !containsFold(ClientOS, c.OS[j]) {
+				return fmt.Errorf("Invalid OS %s of client rule %s: it must be one of %s", c.OS[j], c.Name,
+					strings.Join(ClientOS, ", "))
+			}
+		}
+
+		if c.Action == "redirect" && c.Redirect == "" {
+			return fmt.Errorf("Missing redirect URL of client rule %s", c.Name)
+		}
+
+		for _, r := range c.Replace {
+			if len(r) != 2 || r[0] == "" {
+				return fmt.Errorf("Invalid replacement %v of client rule %s: it must be a pair of strings", r, c.Name)
+			}
+		}
+
+		for _, p := range c.Paths {
+			if strings.HasPrefix(p, "^") && strings.HasSuffix(p, "$") {
+				if _, err = regexp.Compile(p); err != nil {
+					return fmt.Errorf("Invalid path %s of client rule %s: %w", p, c.Name, err)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// CheckEmail checks the SMTP server and the recipients of the email digests
+func (s *Session) CheckEmail() (err error) {
+	e := &s.Config.Email
+	if !e.Enabled {
+		return
+	}
+
+	if _, _, err = net.SplitHostPort(e.Server); err != nil {
+		return fmt.Errorf("Invalid email server %s: it must be host:port", e.Server)
+	}
+
+	if e.From == "" || len(e.To) == 0 {
+		return errors.New("Missing email sender or recipients")
+	}
+
+	if e.TLS == "" {
+		e.TLS = DefaultEmailTLS
+	}
+	e.TLS = strings.ToLower(e.TLS)
+
+	if e.Interval <= 0 {
+		e.Interval = DefaultEmailInterval
+	}
+	if e.Batch <= 0 {
+		e.Batch = DefaultEmailBatch
+	}
+
+	return nil
+}
+
+// CheckDiagnostics checks the diagnostics endpoint.
+// The profiles and the dumps reveal the internals of the instance, so it is never exposed without a token.
+func (s *Session) CheckDiagnostics() (err error) {
+	if s.Config.Diagnostics.Listen == "" || s.Config.Diagnostics.Token != "" {
+		return
+	}
+
+	for _, t := range s.Config.Admin.Tokens {
+		if t.Token != "" {
+			return
+		}
+	}
+
+	return errors.New("Missing diagnostics token: it is required to expose the diagnostics endpoint")
+}
+
+// CheckRetention checks the retention configuration.
+// The panic endpoint wipes all the captured data, so it is never exposed without a token.
+// The kill switch is fetched over HTTPS only, so that nobody on the path can trigger or mask it.
+func (s *Session) CheckRetention() (err error) {
+	r := s.Config.Retention
+	if r.Days < 0 || r.MaxCredentials < 0 || r.MaxCookies < 0 {
+		return errors.New("Invalid retention: days and limits must not be negative")
+	}
+
+	operator := false
+	for _, t := range s.Config.Admin.Tokens {
+		operator = operator || strings.ToLower(t.Role) == RoleOperator
+	}
+
+	if r.Panic.Listen != "" && r.Panic.Token == "" && !operator {
+		return errors.New("Missing retention panic token: it is required to expose the panic endpoint")
+	}
+
+	k := r.KillSwitch
+	if (k.DNS != "" || k.URL != "") && k.Value == "" {
+		return errors.New("Missing retention kill switch value: it is required to check the kill switch")
+	}
+	if k.URL != "" && !strings.HasPrefix(strings.ToLower(k.URL), "https://") {
+		return fmt.Errorf("Invalid retention kill switch URL %s: it must be an HTTPS URL", k.URL)
+	}
+
+	return
+}
+
+// CheckSandbox checks the sandbox configuration.
+// Once chrooted, the relative paths are resolved against it, so Muraena is expected to be started from it.
+func (s *Session) CheckSandbox() (err error) {
+	sandbox := s.Config.Sandbox
+	sandboxed := sandbox.User != "" || sandbox.Group != "" || sandbox.Chroot != "" || sandbox.Landlock.Enabled ||
+		sandbox.Seccomp
+
+	// The binary upgrade executes the binary again and binds the listeners, which the sandboxed process cannot do
+	if sandboxed && s.Config.Proxy.ReusePort {
+		return errors.New("Invalid sandbox: the binary upgrade (proxy.reusePort) cannot be used in the sandbox")
+	}
+
+	dir := sandbox.Chroot
+	if dir == "" {
+		return
+	}
+
+	info, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("Invalid sandbox chroot: %w", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("Invalid sandbox chroot: %s is not a directory", dir)
+	}
+
+	return
+}
+
+// CheckStorage checks the storage configuration.
+// With a public key the captured values are sealed, so the features needing them in clear cannot be enabled.
+func (s *Session) CheckStorage() (err error) {
+	if s.Config.Storage.PublicKey == "" {
+		return
+	}
+
+	switch {
+	case s.Config.Necrobrowser.Enabled:
+		return errors.New("Invalid storage public key: Necrobrowser cannot use the sealed cookies and credentials")
+	case s.Config.Relay.Enabled:
+		return errors.New("Invalid storage public key: the relay exposes the submissions in clear")
+	case s.Config.Tap.Enabled:
+		return errors.New("Invalid storage public key: the tap exports the submissions in clear")
+	case s.Config.Operator.Trace.Enabled || s.Config.Operator.Trace.Secret != "":
+		return errors.New("Invalid storage public key: the rewrite trace logs the submissions in clear")
+	}
+
+	return
+}
+
+// CheckDryRun checks the dry run configuration.
+// No victim is served in a dry run, so the tracking and Necrobrowser are disabled.
+func (s *Session) CheckDryRun() (err error) {
+	d := &s.Config.DryRun
+	if !d.Enabled {
+		return
+	}
+
+	if len(d.Allow) == 0 {
+		return errors.New("Missing dry run allowed addresses: the proxy must be restricted to the operator")
+	}
+
+	for _, a := range d.Allow {
+		if ParseNetwork(a) == nil {
+			return fmt.Errorf("Invalid dry run allowed address %s", a)
+		}
+	}
+
+	if d.Report == "" {
+		d.Report = DefaultDryRunReport
+	}
+
+	s.Config.Tracking.Enabled = false
+	s.Config.Necrobrowser.Enabled = false
+	return
+}
+
+// CheckOperator checks the addresses of the operator session
+func (s *Session) CheckOperator() (err error) {
+	o := &s.Config.Operator
+	if !o.Enabled {
+		return
+	}
+
+	if len(o.Allow) == 0 {
+		return errors.New("Missing operator allowed addresses")
+	}
+
+	for _, a := range o.Allow {
+		if ParseNetwork(a) == nil {
+			return fmt.Errorf("Invalid operator allowed address %s", a)
+		}
+	}
+
+	if o.Mode == "" {
+		o.Mode = DefaultOperatorMode
+	}
 	return
 }
 
@@ -57,3 +57,353 @@ func TestSession_CheckRedirect(t *testing.T) {
 		t.Errorf("Expected %d, got %d", 302, s.Config.Redirects[1].HTTPStatusCode)
 	}
 }
+
+func TestSession_CheckCluster(t *testing.T) {
+	s := &Session{}
+	s.Config = &Configuration{}
+	s.Config.Cluster.Enabled = true
+	s.Config.Tracking.Enabled = true
+
+	for storage, valid := range map[string]bool{"": true, "redis": true, "postgres": true, "sqlite": false} {
+		s.Config.Storage.Type = storage
+		if err := s.CheckCluster(); (err == nil) != valid {
+			t.Errorf("storage %q: expected valid %v, got %v", storage, valid, err)
+		}
+	}
+}
+
+func TestSession_StateDirectory(t *testing.T) {
+	config := "/etc/muraena/config.toml"
+	s := &Session{}
+	s.Config = &Configuration{}
+	s.Options.ConfigFilePath = &config
+
+	for directory, expected := range map[string]string{"": "", "state": "/etc/muraena/state", "/var/lib/muraena": "/var/lib/muraena"} {
+		s.Config.State.Directory = directory
+		if got := s.StateDirectory(); got != expected {
+			t.Errorf("directory %q: expected %q, got %q", directory, expected, got)
+		}
+	}
+}
+
+func TestSession_CheckListeners(t *testing.T) {
+	s := &Session{}
+	s.Config = &Configuration{}
+	s.Config.Proxy.IP = "0.0.0.0"
+	s.Config.Proxy.Port = 443
+	s.Config.Proxy.HTTPtoHTTPS.Enabled = true
+	s.Config.Proxy.HTTPtoHTTPS.HTTPport = 80
+	s.Config.TLS.Enabled = true
+
+	if err := s.CheckListeners(); err != nil {
+		t.Fatal(err)
+	}
+
+	l := s.Config.Proxy.Listeners
+	if len(l) != 2 || l[0].Address != "0.0.0.0:443" || !l[0].TLS || l[0].Mode != "proxy" ||
+		l[1].Address != "0.0.0.0:80" || l[1].Mode != "redirect" {
+		t.Errorf("unexpected listeners %+v", l)
+	}
+
+	s.Config.Proxy.Listeners = []Listener{{Address: ":80", Mode: "redirect"}}
+	if err := s.CheckListeners(); err == nil {
+		t.Error("expected an error without proxy listeners")
+	}
+
+	s.Config.TLS.Enabled = false
+	s.Config.Proxy.Listeners = []Listener{{Address: ":443", TLS: true}}
+	if err := s.CheckListeners(); err == nil {
+		t.Error("expected an error with a TLS listener and TLS disabled")
+	}
+
+	s.Config.Proxy.Listeners = []Listener{{Address: ":80"}}
+	for _, c := range []struct {
+		action, redirect, origin string
+		valid                    bool
+	}{
+		{"reject", "", "", true},
+		{"redirect", "", "", false},
+		{"redirect", "https://www.example.com/", "", true},
+		{"origin", "", "www.example.com", false},
+		{"origin", "", "https://www.example.com", true},
+	} {
+		s.Config.Proxy.UnknownHost.Action = c.action
+		s.Config.Proxy.UnknownHost.Redirect = c.redirect
+		s.Config.Proxy.UnknownHost.Origin = c.origin
+		if err := s.CheckListeners(); (err == nil) != c.valid {
+			t.Errorf("unknown host %s %q %q: expected valid %v, got %v", c.action, c.redirect, c.origin, c.valid, err)
+		}
+	}
+}
+
+func TestSession_CheckTraining(t *testing.T) {
+	s := &Session{}
+	s.Config = &Configuration{}
+	s.Config.Training.Enabled = true
+
+	if err := s.CheckTraining(); err == nil {
+		t.Error("expected an error without paths and tracking")
+	}
+
+	s.Config.Tracking.Enabled = true
+	s.Config.Tracking.Secrets.Capture = DefaultSecretsCapture
+	if err := s.CheckTraining(); err != nil {
+		t.Errorf("expected the submissions to be detected by the tracker, got %v", err)
+	}
+	if s.Config.Tracking.Secrets.Capture != "fact" {
+		t.Errorf("expected the training to capture the fact of the credentials, got %q", s.Config.Tracking.Secrets.Capture)
+	}
+
+	s.Config.Relay.Enabled, s.Config.Tap.Enabled, s.Config.Operator.Trace.Secret = true, true, "trace"
+	if err := s.CheckTraining(); err != nil {
+		t.Fatal(err)
+	}
+	if s.Config.Relay.Enabled || s.Config.Tap.Enabled || s.Config.Operator.Trace.Secret != "" {
+		t.Error("expected the training to disable the relay, the tap and the rewrite trace")
+	}
+
+	s.Config.Training.Page = "training.html"
+	s.Config.Training.Redirect = "https://training.example.com/"
+	if err := s.CheckTraining(); err == nil {
+		t.Error("expected an error with both the page and the redirect")
+	}
+}
+
+func TestSession_CheckRelay(t *testing.T) {
+	s := &Session{}
+	s.Config = &Configuration{}
+	s.Config.Relay.Enabled = true
+
+	if err := s.CheckRelay(); err != nil {
+		t.Errorf("expected no error without the API, got %v", err)
+	}
+
+	s.Config.Relay.Listen = "127.0.0.1:8090"
+	if err := s.CheckRelay(); err == nil {
+		t.Error("expected an error without a token")
+	}
+
+	s.Config.Admin.Tokens = []AdminToken{{Name: "audit", Token: "viewer", Role: RoleViewer}}
+	if err := s.CheckRelay(); err == nil {
+		t.Error("expected an error with a viewer token only")
+	}
+
+	s.Config.Admin.Tokens = append(s.Config.Admin.Tokens, AdminToken{Name: "ops", Token: "operator", Role: RoleOperator})
+	if err := s.CheckRelay(); err != nil {
+		t.Errorf("expected the operator token to protect the API, got %v", err)
+	}
+
+	s.Config.Admin.Tokens = nil
+	s.Config.Relay.Token = "s3cr3t"
+	if err := s.CheckRelay(); err != nil {
+		t.Errorf("expected the relay token to protect the API, got %v", err)
+	}
+}
+
+func TestSession_CheckEmail(t *testing.T) {
+	s := &Session{}
+	s.Config = &Configuration{}
+	s.Config.Email.Enabled = true
+	s.Config.Email.Server = "smtp.example.com"
+
+	if err := s.CheckEmail(); err == nil {
+		t.Error("expected an error without the port of the server")
+	}
+
+	s.Config.Email.Server = "smtp.example.com:587"
+	if err := s.CheckEmail(); err == nil {
+		t.Error("expected an error without recipients")
+	}
+
+	s.Config.Email.From = "muraena@example.com"
+	s.Config.Email.To = []string{"operator@example.com"}
+	if err := s.CheckEmail(); err != nil {
+		t.Fatal(err)
+	}
+	if e := s.Config.Email; e.TLS != DefaultEmailTLS || e.Interval != DefaultEmailInterval || e.Batch != DefaultEmailBatch {
+		t.Errorf("expected the defaults, got %s %d %d", e.TLS, e.Interval, e.Batch)
+	}
+}
+
+func TestSession_CheckEnrichment(t *testing.T) {
+	s := &Session{}
+	s.Config = &Configuration{}
+	e := &s.Config.Tracking.Enrichment
+	e.Enabled = true
+
+	e.URL = "webhook.example.com"
+	if err := s.CheckEnrichment(); err == nil {
+		t.Error("expected an error without an http(s) webhook")
+	}
+
+	e.URL = "https://webhook.example.com/victims"
+	e.Profiles = []RoutingProfile{{Name: "decoy", Origin: "https://decoy.example.com"}, {Name: "decoy", Origin: "https://other.example.com"}}
+	if err := s.CheckEnrichment(); err == nil {
+		t.Error("expected an error with duplicated profiles")
+	}
+
+	e.Profiles = e.Profiles[:1]
+	if err := s.CheckEnrichment(); err != nil {
+		t.Fatal(err)
+	}
+	if e.Timeout != DefaultEnrichmentTimeout || e.Failure != DefaultEnrichmentFailure {
+		t.Errorf("expected the defaults, got %d %s", e.Timeout, e.Failure)
+	}
+}
+
+func TestSession_CheckVariants(t *testing.T) {
+	s := &Session{}
+	s.Config = &Configuration{}
+	s.Config.Variants = []ResponseVariant{{Name: "overlay", Weight: 60}}
+
+	if err := s.CheckVariants(); err == nil {
+		t.Error("expected an error without tracking")
+	}
+
+	s.Config.Tracking.Enabled = true
+	for _, c := range []struct {
+		variants []ResponseVariant
+		valid    bool
+	}{
+		{[]ResponseVariant{{Name: "overlay", Weight: 60}, {Name: "banner", Weight: 40}}, true},
+		{[]ResponseVariant{{Name: "overlay", Weight: 60}, {Name: "banner", Weight: 41}}, false},
+		{[]ResponseVariant{{Name: "overlay"}, {Name: "overlay"}}, false},
+		{[]ResponseVariant{{Name: DefaultVariant}}, false},
+		{[]ResponseVariant{{Name: "overlay", Replace: [][]string{{"Sign in"}}}}, false},
+	} {
+		s.Config.Variants = c.variants
+		if err := s.CheckVariants(); (err == nil) != c.valid {
+			t.Errorf("%+v: expected valid %v, got %v", c.variants, c.valid, err)
+		}
+	}
+}
+
+func TestSession_CheckClients(t *testing.T) {
+	s := &Session{}
+	s.Config = &Configuration{}
+
+	for _, c := range []struct {
+		rule  ClientRule
+		valid bool
+	}{
+		{ClientRule{Name: "ios", Class: "mobile", OS: []string{"iOS"}, Action: "Redirect", Redirect: "https://lure.com"}, true},
+		{ClientRule{Name: "mobile", Class: "mobile", Replace: [][]string{{"Sign in", "Continue"}}}, true},
+		{ClientRule{Name: "symbian", OS: []string{"symbian"}}, false},
+		{ClientRule{Name: "ios", Action: "redirect"}, false},
+		{ClientRule{Name: "mobile", Replace: [][]string{{"Sign in"}}}, false},
+		{ClientRule{Name: "mobile", Paths: []string{"^/(login$"}}, false},
+	} {
+		s.Config.Clients = []ClientRule{c.rule}
+		if err := s.CheckClients(); (err == nil) != c.valid {
+			t.Errorf("%+v: expected valid %v, got %v", c.rule, c.valid, err)
+		}
+	}
+
+	s.Config.Clients = []ClientRule{{Name: "ios", OS: []string{"iOS"}, Action: "Decoy"}}
+	if err := s.CheckClients(); err != nil || s.Config.Clients[0].OS[0] != "ios" || s.Config.Clients[0].Action != "decoy" {
+		t.Errorf("expected the OS and the action to be lowercased, got %+v", s.Config.Clients[0])
+	}
+}
+
+func TestSession_CheckTracking_CSRF(t *testing.T) {
+	s := &Session{}
+	s.Config = &Configuration{}
+	s.Config.Tracking.Enabled = true
+	s.Config.Tracking.CSRF.Enabled = true
+
+	if err := s.CheckTracking(); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Config.Tracking.CSRF.Cookies) == 0 || len(s.Config.Tracking.CSRF.Fields) == 0 {
+		t.Error("expected the default CSRF cookies and fields")
+	}
+
+	s.Config.Tracking.CSRF.Cookies = []string{"XSRF-TOKEN"}
+	s.Config.Tracking.CSRF.Fields = nil
+	if err := s.CheckTracking(); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Config.Tracking.CSRF.Fields) != 0 {
+		t.Error("expected the configured CSRF cookies only")
+	}
+}
+
+func TestSession_CheckTracking_Capture(t *testing.T) {
+	for _, c := range []struct {
+		name    string
+		capture string
+		enable  func(*Configuration)
+		valid   bool
+	}{
+		{"relay with values", "value", func(c *Configuration) { c.Relay.Enabled = true }, true},
+		{"relay with facts", "fact", func(c *Configuration) { c.Relay.Enabled = true }, false},
+		{"tap with hashes", "HASH", func(c *Configuration) { c.Tap.Enabled = true }, false},
+		{"trace with facts", "fact", func(c *Configuration) { c.Operator.Trace.Enabled = true }, false},
+		{"trace secret with facts", "fact", func(c *Configuration) { c.Operator.Trace.Secret = "trace" }, false},
+		{"facts only", "fact", func(c *Configuration) {}, true},
+	} {
+		s := &Session{}
+		s.Config = &Configuration{}
+		s.Config.Tracking.Enabled = true
+		s.Config.Tracking.Secrets.Capture = c.capture
+		c.enable(s.Config)
+
+		if err := s.CheckTracking(); (err == nil) != c.valid {
+			t.Errorf("%s: expected valid %v, got %v", c.name, c.valid, err)
+		}
+	}
+}
+
+func TestSession_CheckSandbox(t *testing.T) {
+	s := &Session{}
+	s.Config = &Configuration{}
+	s.Config.Proxy.ReusePort = true
+
+	if err := s.CheckSandbox(); err != nil {
+		t.Errorf("expected the binary upgrade to be allowed without the sandbox, got %v", err)
+	}
+
+	for name, sandbox := range map[string]func(*Configuration){
+		"user":     func(c *Configuration) { c.Sandbox.User = "nobody" },
+		"chroot":   func(c *Configuration) { c.Sandbox.Chroot = t.TempDir() },
+		"landlock": func(c *Configuration) { c.Sandbox.Landlock.Enabled = true },
+		"seccomp":  func(c *Configuration) { c.Sandbox.Seccomp = true },
+	} {
+		s.Config = &Configuration{}
+		sandbox(s.Config)
+		if err := s.CheckSandbox(); err != nil {
+			t.Errorf("%s: expected a valid sandbox, got %v", name, err)
+		}
+
+		s.Config.Proxy.ReusePort = true
+		if err := s.CheckSandbox(); err == nil {
+			t.Errorf("%s: expected an error with the binary upgrade", name)
+		}
+	}
+}
+
+func TestSession_CheckStorage(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		enable func(*Configuration)
+		valid  bool
+	}{
+		{"sealed values", func(c *Configuration) {}, true},
+		{"necrobrowser", func(c *Configuration) { c.Necrobrowser.Enabled = true }, false},
+		{"relay", func(c *Configuration) { c.Relay.Enabled = true }, false},
+		{"tap", func(c *Configuration) { c.Tap.Enabled = true }, false},
+		{"trace", func(c *Configuration) { c.Operator.Trace.Secret = "trace" }, false},
+	} {
+		s := &Session{}
+		s.Config = &Configuration{}
+		c.enable(s.Config)
+		if err := s.CheckStorage(); err != nil {
+			t.Errorf("%s: expected no error without the public key, got %v", c.name, err)
+		}
+
+		s.Config.Storage.PublicKey = "QUJDREVGR0hJSktMTU5PUFFSU1RVVldYWVphYmNkZWY="
+		if err := s.CheckStorage(); (err == nil) != c.valid {
+			t.Errorf("%s: expected valid %v, got %v", c.name, c.valid, err)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package session
+
+import (
+	"sync"
+	"time"
+)
+
+// Event types
+const (
+	EventVictim          = "victim"
+	EventRequest         = "request"
+	EventCredentials     = "credentials"
+	EventCookie          = "cookie"
+	EventSessionComplete = "session"
+	EventWatchdog        = "watchdog"
+	EventSubmission      = "submission"
+	EventPhished         = "phished"
+	EventUpstream        = "upstream"
+	EventTargetChange    = "change"
+)
+
+// Event is an occurrence of interest for the operator, such as a new victim or captured credentials
+type Event struct {
+	Type   string            `json:"type"`
+	Victim string            `json:"victim,omitempty"`
+	Time   time.Time         `json:"time"`
+	Data   map[string]string `json:"data,omitempty"`
+}
+
+var (
+	eventsMu    sync.RWMutex
+	subscribers = make(map[chan Event]struct{})
+
+	statsMu sync.RWMutex
+	stats   = make(map[string]func() map[string]interface{})
+)
+
+// Publish sends the event to all the subscribers. Slow subscribers miss the event rather than blocking the proxy.
+func Publish(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+
+	eventsMu.RLock()
+	defer eventsMu.RUnlock()
+
+	for ch := range subscribers {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// Subscribe returns a channel receiving the published events, until the returned function is called
+func Subscribe(buffer int) (<-chan Event, func()) {
+	ch := make(chan Event, buffer)
+
+	eventsMu.Lock()
+	subscribers[ch] = struct{}{}
+	eventsMu.Unlock()
+
+	return ch, func() {
+		eventsMu.Lock()
+		delete(subscribers, ch)
+		eventsMu.Unlock()
+	}
+}
+
+// RegisterStats adds a named provider of runtime statistics, replacing any previous one with the same name
+func RegisterStats(name string, provider func() map[string]interface{}) {
+	statsMu.Lock()
+	defer statsMu.Unlock()
+
+	stats[name] = provider
+}
+
+// Stats collects the statistics of all the registered providers
+func Stats() map[string]map[string]interface{} {
+	statsMu.RLock()
+	defer statsMu.RUnlock()
+
+	all := make(map[string]map[string]interface{}, len(stats))
+	for name, provider := range stats {
+		all[name] = provider()
+	}
+	return all
+}
@@ -0,0 +1,91 @@
+package session
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/pelletier/go-toml"
+)
+
+// loadConfiguration loads the configuration file, layered on the files it includes.
+// Each file is validated on its own, so that the errors refer to its lines,
+// then the files are merged in order: the tables are merged key by key,
+// while any other value, arrays included, replaces the one of the previous layers.
+func loadConfiguration(path string, c *Configuration) error {
+	tree, err := loadLayers(path, nil)
+	if err != nil {
+		return err
+	}
+
+	return tree.Unmarshal(c)
+}
+
+// loadLayers returns the tree of the file merged over the ones of its includes.
+// parents are the files including it, to detect the cycles.
+func loadLayers(path string, parents []string) (*toml.Tree, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, parent := range parents {
+		if parent == abs {
+			return nil, fmt.Errorf("Circular include of %s", path)
+		}
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	layer := Configuration{}
+	if err := decodeConfiguration(content, &layer); err != nil {
+		return nil, err
+	}
+
+	tree, err := toml.LoadBytes(content)
+	if err != nil {
+		return nil, err
+	}
+
+	var merged *toml.Tree
+	for _, include := range layer.Include {
+		if !filepath.IsAbs(include) {
+			include = filepath.Join(filepath.Dir(path), include)
+		}
+
+		t, err := loadLayers(include, append(parents, abs))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", include, err)
+		}
+
+		if merged == nil {
+			merged = t
+		} else {
+			mergeTree(merged, t)
+		}
+	}
+
+	if merged == nil {
+		return tree, nil
+	}
+
+	mergeTree(merged, tree)
+	return merged, nil
+}
+
+// mergeTree merges the layer over the base
+func mergeTree(base, layer *toml.Tree) {
+	for _, key := range layer.Keys() {
+		value := layer.GetPath([]string{key})
+		if table, ok := value.(*toml.Tree); ok {
+			if baseTable, ok := base.GetPath([]string{key}).(*toml.Tree); ok {
+				mergeTree(baseTable, table)
+				continue
+			}
+		}
+
+		base.SetPath([]string{key}, value)
+	}
+}
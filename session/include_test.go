@@ -0,0 +1,99 @@
+package session
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeLayers(t *testing.T, layers map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range layers {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestLoadConfiguration_Layers(t *testing.T) {
+	dir := writeLayers(t, map[string]string{
+		"base.toml": `
+[proxy]
+    phishing = "base.tld"
+    port = 443
+
+[transform.response]
+    headers = ["Location", "Set-Cookie"]
+
+[log]
+    enable = true
+    filePath = "muraena.log"
+`,
+		"profiles/target.toml": `
+[proxy]
+    destination = "target.com"
+
+[origins]
+    externalOrigins = ["*.cdn-target.com"]
+
+[[tracking.sessions]]
+    name = "target"
+    cookies = ["SID"]
+`,
+		"engagement/config.toml": `
+include = ["../base.toml", "../profiles/target.toml"]
+
+[proxy]
+    phishing = "phishing.tld"
+
+[transform.response]
+    headers = ["Location"]
+`,
+	})
+
+	c := Configuration{}
+	if err := loadConfiguration(filepath.Join(dir, "engagement/config.toml"), &c); err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Proxy.Phishing != "phishing.tld" || c.Proxy.Target != "target.com" || c.Proxy.Port != 443 {
+		t.Errorf("Unexpected proxy %s %s %d", c.Proxy.Phishing, c.Proxy.Target, c.Proxy.Port)
+	}
+	if len(c.Transform.Response.Headers) != 1 || c.Transform.Response.Headers[0] != "Location" {
+		t.Errorf("Expected the headers replaced, got %v", c.Transform.Response.Headers)
+	}
+	if !c.Log.Enabled || c.Log.FilePath != "muraena.log" {
+		t.Errorf("Expected the log of the base, got %+v", c.Log)
+	}
+	if len(c.Origins.ExternalOrigins) != 1 || len(c.Tracking.Sessions) != 1 || c.Tracking.Sessions[0].Name != "target" {
+		t.Errorf("Expected the origins and sessions of the profile, got %v %+v", c.Origins.ExternalOrigins,
+			c.Tracking.Sessions)
+	}
+}
+
+func TestLoadConfiguration_Errors(t *testing.T) {
+	dir := writeLayers(t, map[string]string{
+		"a.toml":       "include = [\"b.toml\"]\n",
+		"b.toml":       "include = [\"a.toml\"]\n",
+		"typo.toml":    "include = [\"profile.toml\"]\n",
+		"profile.toml": "[origins]\n    externalOrigin = [\"cdn.target.com\"]\n",
+	})
+
+	c := Configuration{}
+	err := loadConfiguration(filepath.Join(dir, "a.toml"), &c)
+	if err == nil || !strings.Contains(err.Error(), "Circular include") {
+		t.Errorf("Expected a circular include, got %v", err)
+	}
+
+	err = loadConfiguration(filepath.Join(dir, "typo.toml"), &c)
+	if err == nil || !strings.Contains(err.Error(), "profile.toml: (2, 5): unknown key origins.externalOrigin") {
+		t.Errorf("Expected the unknown key of the included file, got %v", err)
+	}
+}
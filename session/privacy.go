@@ -0,0 +1,86 @@
+package session
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"strings"
+)
+
+// Prefixes kept by the truncated addresses
+const (
+	anonymizedIPv4Bits = 24
+	anonymizedIPv6Bits = 48
+)
+
+// IPAnonymizer masks the addresses of the clients in the logs, the tracking and the notifications. The addresses are
+// truncated to their network, or replaced by a keyed hash: within a campaign, an address always has the same
+// pseudonym, so that the requests of a victim can still be told apart.
+type IPAnonymizer struct {
+	hash bool
+	key  []byte
+}
+
+// CheckPrivacy checks the anonymization of the addresses of the clients
+func (s *Session) CheckPrivacy() (err error) {
+	p := &s.Config.Privacy
+	p.IP = strings.ToLower(p.IP)
+
+	switch p.IP {
+	case "truncate":
+		s.anonymizer = &IPAnonymizer{}
+	case "hash":
+		key := p.Salt
+		if key == "" {
+			key = s.Config.Proxy.Phishing + "|" + s.Config.Proxy.Target
+		}
+		s.anonymizer = &IPAnonymizer{hash: true, key: []byte(key)}
+	default:
+		s.anonymizer = nil
+	}
+	return
+}
+
+// IPAnonymizer returns the anonymizer of the addresses, nil if they are kept as they are
+func (s *Session) IPAnonymizer() *IPAnonymizer {
+	if s == nil {
+		return nil
+	}
+	return s.anonymizer
+}
+
+// AnonymizeIP returns the address of a client as it can be logged or stored, see IPAnonymizer.Anonymize
+func (s *Session) AnonymizeIP(ip string) string {
+	return s.IPAnonymizer().Anonymize(ip)
+}
+
+// Anonymize returns the truncated or hashed address, or the address as it is if the anonymization is disabled.
+// The truncated addresses keep their /24 (IPv4) or /48 (IPv6) network, the hashed ones are ip- followed by
+// 16 hexadecimal digits.
+func (a *IPAnonymizer) Anonymize(ip string) string {
+	if a == nil || ip == "" {
+		return ip
+	}
+
+	parsed := net.ParseIP(ip)
+	if a.hash {
+		value := ip
+		if parsed != nil {
+			value = parsed.String()
+		}
+
+		mac := hmac.New(sha256.New, a.key)
+		mac.Write([]byte(value))
+		return "ip-" + hex.EncodeToString(mac.Sum(nil)[:8])
+	}
+
+	if parsed == nil {
+		// Not an address, i.e. the client of a unix socket
+		return ip
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(anonymizedIPv4Bits, 32)).String()
+	}
+	return parsed.Mask(net.CIDRMask(anonymizedIPv6Bits, 128)).String()
+}
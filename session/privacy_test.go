@@ -0,0 +1,60 @@
+package session
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSession_AnonymizeIP(t *testing.T) {
+	s := &Session{Config: &Configuration{}}
+	s.Config.Proxy.Phishing = "phishing.click"
+	s.Config.Proxy.Target = "poor.victim"
+	if err := s.CheckPrivacy(); err != nil {
+		t.Fatal(err)
+	}
+	if ip := s.AnonymizeIP("203.0.113.10"); ip != "203.0.113.10" {
+		t.Errorf("expected the raw address, got %s", ip)
+	}
+
+	s.Config.Privacy.IP = "Truncate"
+	if err := s.CheckPrivacy(); err != nil {
+		t.Fatal(err)
+	}
+	for ip, want := range map[string]string{
+		"203.0.113.10":          "203.0.113.0",
+		"::ffff:203.0.113.10":   "203.0.113.0",
+		"2001:db8:1234:5678::1": "2001:db8:1234::",
+		"@":                     "@",
+		"":                      "",
+	} {
+		if got := s.AnonymizeIP(ip); got != want {
+			t.Errorf("%q: expected %q, got %q", ip, want, got)
+		}
+	}
+
+	s.Config.Privacy.IP = "hash"
+	if err := s.CheckPrivacy(); err != nil {
+		t.Fatal(err)
+	}
+	hashed := s.AnonymizeIP("203.0.113.10")
+	if !strings.HasPrefix(hashed, "ip-") || len(hashed) != 19 || strings.Contains(hashed, "203") {
+		t.Errorf("unexpected pseudonym %s", hashed)
+	}
+	if s.AnonymizeIP("::ffff:203.0.113.10") != hashed || s.AnonymizeIP("203.0.113.11") == hashed {
+		t.Error("expected the pseudonym to identify the address")
+	}
+
+	// The pseudonyms depend on the salt of the campaign
+	s.Config.Privacy.Salt = "s3cr3t"
+	if err := s.CheckPrivacy(); err != nil {
+		t.Fatal(err)
+	}
+	if s.AnonymizeIP("203.0.113.10") == hashed {
+		t.Error("expected the pseudonym to change with the salt")
+	}
+
+	var none *Session
+	if none.AnonymizeIP("203.0.113.10") != "203.0.113.10" {
+		t.Error("expected the raw address without session")
+	}
+}
@@ -0,0 +1,153 @@
+package session
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// profileExtensions are the supported formats of the target profiles, in lookup order
+var profileExtensions = []string{".yaml", ".yml", ".json"}
+
+// TargetProfile is the portable description of a target, i.e. O365 or Okta, shared between the operators.
+// Profiles are YAML or JSON files named after the profile, dropped in the profiles directory
+// and selected by name in the profiles section of the configuration.
+type TargetProfile struct {
+	Name        string `json:"name" yaml:"name"`
+	Description string `json:"description" yaml:"description"`
+
+	// Destination is the target domain, used if the configuration does not set one
+	Destination string `json:"destination" yaml:"destination"`
+
+	Origins struct {
+		// External are the origins proxied besides the destination and its subdomains
+		External []string `json:"external" yaml:"external"`
+		// SubdomainMap maps the phishing subdomains to the target ones, as [phishing, target] pairs
+		SubdomainMap [][]string `json:"subdomainMap" yaml:"subdomainMap"`
+	} `json:"origins" yaml:"origins"`
+
+	// CustomContent are the replacements of the response content, as the transform.response.customContent pairs
+	CustomContent [][]string `json:"customContent" yaml:"customContent"`
+
+	Sessions []SessionProfile `json:"sessions" yaml:"sessions"`
+
+	Login struct {
+		// Paths the credentials are submitted to
+		Paths       []string        `json:"paths" yaml:"paths"`
+		Credentials []SecretPattern `json:"credentials" yaml:"credentials"`
+		GraphQL     []GraphQLSecret `json:"graphql" yaml:"graphql"`
+		// Authenticated are the paths whose response marks an authenticated session
+		Authenticated []string `json:"authenticated" yaml:"authenticated"`
+	} `json:"login" yaml:"login"`
+}
+
+// LoadProfile loads the profile by name from the directory, rejecting the unknown fields
+func LoadProfile(directory, name string) (*TargetProfile, error) {
+	if name == "" || strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".") {
+		return nil, fmt.Errorf("Invalid profile name %s", name)
+	}
+
+	for _, ext := range profileExtensions {
+		path := filepath.Join(directory, name+ext)
+		content, err := ioutil.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		p := &TargetProfile{}
+		if ext == ".json" {
+			decoder := json.NewDecoder(bytes.NewReader(content))
+			decoder.DisallowUnknownFields()
+			err = decoder.Decode(p)
+		} else {
+			decoder := yaml.NewDecoder(bytes.NewReader(content))
+			decoder.KnownFields(true)
+			err = decoder.Decode(p)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Error loading profile %s: %s", path, err)
+		}
+
+		if p.Name == "" {
+			p.Name = name
+		}
+		return p, nil
+	}
+
+	return nil, fmt.Errorf("Profile %s not found in %s", name, directory)
+}
+
+// ListProfiles returns the names of the profiles in the directory
+func ListProfiles(directory string) ([]string, error) {
+	entries, err := ioutil.ReadDir(directory)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, entry := range entries {
+		ext := filepath.Ext(entry.Name())
+		for _, e := range profileExtensions {
+			if !entry.IsDir() && ext == e {
+				names = append(names, strings.TrimSuffix(entry.Name(), ext))
+			}
+		}
+	}
+	return names, nil
+}
+
+// Apply adds the profile to the configuration: its entries are appended to the configured ones,
+// and the destination is only set if missing.
+func (p *TargetProfile) Apply(c *Configuration) {
+	if c.Proxy.Target == "" {
+		c.Proxy.Target = p.Destination
+	}
+
+	c.Origins.ExternalOrigins = append(c.Origins.ExternalOrigins, p.Origins.External...)
+	c.Origins.SubdomainMap = append(c.Origins.SubdomainMap, p.Origins.SubdomainMap...)
+
+	c.Transform.Response.CustomContent = append(c.Transform.Response.CustomContent, p.CustomContent...)
+
+	c.Tracking.Sessions = append(c.Tracking.Sessions, p.Sessions...)
+	c.Tracking.Secrets.Paths = append(c.Tracking.Secrets.Paths, p.Login.Paths...)
+	c.Tracking.Secrets.Patterns = append(c.Tracking.Secrets.Patterns, p.Login.Credentials...)
+	c.Tracking.Secrets.GraphQL = append(c.Tracking.Secrets.GraphQL, p.Login.GraphQL...)
+	c.Necrobrowser.SensitiveLocations.AuthSessionResponse = append(
+		c.Necrobrowser.SensitiveLocations.AuthSessionResponse, p.Login.Authenticated...)
+}
+
+// ApplyProfiles applies the profiles selected by the configuration, in order
+func (s *Session) ApplyProfiles() error {
+	config := &s.Config.Profiles
+	if len(config.Use) == 0 {
+		return nil
+	}
+
+	if config.Directory == "" {
+		config.Directory = DefaultProfilesDirectory
+	}
+
+	directory := config.Directory
+	if !filepath.IsAbs(directory) && s.Options.ConfigFilePath != nil {
+		directory = filepath.Join(filepath.Dir(*s.Options.ConfigFilePath), directory)
+	}
+
+	for _, name := range config.Use {
+		p, err := LoadProfile(directory, name)
+		if err != nil {
+			return err
+		}
+		p.Apply(s.Config)
+	}
+
+	return nil
+}
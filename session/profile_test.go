@@ -0,0 +1,82 @@
+package session
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadProfile(t *testing.T) {
+	p, err := LoadProfile("testdata/profiles", "example")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p.Destination != "example.com" || len(p.Origins.External) != 2 || len(p.Login.Credentials) != 2 {
+		t.Errorf("Unexpected profile %+v", p)
+	}
+	if p.Sessions[0].Cookies[1] != "^session_[a-z]+$" {
+		t.Errorf("Unexpected session cookies %v", p.Sessions[0].Cookies)
+	}
+
+	p, err = LoadProfile("testdata/profiles", "portal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Name != "portal" || p.Origins.External[0] != "portal.example.net" {
+		t.Errorf("Unexpected profile %+v", p)
+	}
+
+	for _, name := range []string{"missing", "../profiles/example", ""} {
+		if _, err := LoadProfile("testdata/profiles", name); err == nil {
+			t.Errorf("Expected an error loading the profile %q", name)
+		}
+	}
+
+	names, err := ListProfiles("testdata/profiles")
+	if err != nil || !reflect.DeepEqual(names, []string{"example", "portal"}) {
+		t.Errorf("Unexpected profiles %v: %v", names, err)
+	}
+}
+
+func TestLoadProfile_UnknownField(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "typo.yaml"), []byte("origin:\n  external: [a.com]\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := LoadProfile(dir, "typo")
+	if err == nil || !strings.Contains(err.Error(), "origin") {
+		t.Errorf("Expected an unknown field error, got %v", err)
+	}
+}
+
+func TestSession_ApplyProfiles(t *testing.T) {
+	path := "testdata/config.toml"
+	s := &Session{Config: &Configuration{}}
+	s.Options.ConfigFilePath = &path
+	s.Config.Origins.ExternalOrigins = []string{"static.example.com"}
+	s.Config.Profiles.Use = []string{"example", "portal"}
+
+	if err := s.ApplyProfiles(); err != nil {
+		t.Fatal(err)
+	}
+
+	c := s.Config
+	if c.Proxy.Target != "example.com" {
+		t.Errorf("Expected the profile destination, got %s", c.Proxy.Target)
+	}
+	expected := []string{"static.example.com", "*.example-cdn.net", "sso.example.org", "portal.example.net"}
+	if !reflect.DeepEqual(c.Origins.ExternalOrigins, expected) {
+		t.Errorf("Expected %v, got %v", expected, c.Origins.ExternalOrigins)
+	}
+	if !reflect.DeepEqual(c.Tracking.Secrets.Paths, []string{"/api/v1/authn", "/portal/login"}) {
+		t.Errorf("Unexpected secrets paths %v", c.Tracking.Secrets.Paths)
+	}
+	if len(c.Tracking.Sessions) != 1 || len(c.Tracking.Secrets.Patterns) != 2 ||
+		len(c.Necrobrowser.SensitiveLocations.AuthSessionResponse) != 1 || len(c.Transform.Response.CustomContent) != 1 {
+		t.Errorf("Unexpected configuration %+v", c)
+	}
+}
@@ -0,0 +1,161 @@
+package session
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ScheduleWindow is a recurring window of the campaign, i.e. the business hours of the engagement.
+// A window ending before its start spans midnight.
+type ScheduleWindow struct {
+	// Days are the weekdays the window starts on (mon, tue, ...), every day if empty
+	Days []string `toml:"days"`
+	// From and To are the times of the day, as hh:mm
+	From string `toml:"from"`
+	To   string `toml:"to"`
+}
+
+// schedule is the parsed campaign schedule
+type schedule struct {
+	location   *time.Location
+	start, end time.Time
+	windows    []window
+}
+
+type window struct {
+	days     map[time.Weekday]bool
+	from, to int
+}
+
+// parseWeekday parses the English name of a weekday, full or abbreviated (mon, tue, ...)
+func parseWeekday(name string) (time.Weekday, bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		full := strings.ToLower(day.String())
+		if name == full || name == full[:3] {
+			return day, true
+		}
+	}
+	return 0, false
+}
+
+// CheckSchedule checks the campaign schedule: its start and end, as RFC 3339 timestamps or dates,
+// the time zone and the recurring windows
+func (s *Session) CheckSchedule() (err error) {
+	c := s.Config.Schedule
+	if !c.Enabled {
+		return
+	}
+
+	sc := &schedule{location: time.Local}
+	if c.Timezone != "" {
+		if sc.location, err = time.LoadLocation(c.Timezone); err != nil {
+			return fmt.Errorf("Invalid schedule timezone %s: %w", c.Timezone, err)
+		}
+	}
+
+	if c.Start != "" {
+		if sc.start, err = parseScheduleTime(c.Start, sc.location, false); err != nil {
+			return fmt.Errorf("Invalid schedule start %s: %w", c.Start, err)
+		}
+	}
+	if c.End != "" {
+		if sc.end, err = parseScheduleTime(c.End, sc.location, true); err != nil {
+			return fmt.Errorf("Invalid schedule end %s: %w", c.End, err)
+		}
+	}
+	if !sc.start.IsZero() && !sc.end.IsZero() && !sc.end.After(sc.start) {
+		return fmt.Errorf("Invalid schedule: the end %s is not after the start %s", c.End, c.Start)
+	}
+
+	for _, w := range c.Windows {
+		parsed := window{days: make(map[time.Weekday]bool)}
+		for _, d := range w.Days {
+			day, ok := parseWeekday(d)
+			if !ok {
+				return fmt.Errorf("Invalid schedule window day %s", d)
+			}
+			parsed.days[day] = true
+		}
+
+		if parsed.from, err = parseTimeOfDay(w.From); err != nil {
+			return fmt.Errorf("Invalid schedule window start %s: %w", w.From, err)
+		}
+		if parsed.to, err = parseTimeOfDay(w.To); err != nil {
+			return fmt.Errorf("Invalid schedule window end %s: %w", w.To, err)
+		}
+		if parsed.from == parsed.to {
+			return fmt.Errorf("Invalid schedule window %s-%s: it is empty", w.From, w.To)
+		}
+
+		sc.windows = append(sc.windows, parsed)
+	}
+
+	s.schedule = sc
+	return
+}
+
+// parseScheduleTime parses an RFC 3339 timestamp or a date in the location, the end of the day if end is set
+func parseScheduleTime(value string, location *time.Location, end bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+
+	t, err := time.ParseInLocation("2006-01-02", value, location)
+	if err != nil {
+		return t, fmt.Errorf("it must be an RFC 3339 timestamp or a date (YYYY-MM-DD)")
+	}
+	if end {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// parseTimeOfDay returns the minutes of the time of the day, as hh:mm
+func parseTimeOfDay(value string) (int, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("it must be hh:mm")
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Scheduled checks if the campaign is active at the time, always if there is no schedule
+func (s *Session) Scheduled(now time.Time) bool {
+	sc := s.schedule
+	if sc == nil {
+		return true
+	}
+
+	if !sc.start.IsZero() && now.Before(sc.start) || !sc.end.IsZero() && !now.Before(sc.end) {
+		return false
+	}
+	if len(sc.windows) == 0 {
+		return true
+	}
+
+	now = now.In(sc.location)
+	minutes := now.Hour()*60 + now.Minute()
+	today, yesterday := now.Weekday(), now.AddDate(0, 0, -1).Weekday()
+
+	for _, w := range sc.windows {
+		if w.from < w.to {
+			if w.on(today) && minutes >= w.from && minutes < w.to {
+				return true
+			}
+			continue
+		}
+
+		// The window spans midnight: its end belongs to the day it started on
+		if w.on(today) && minutes >= w.from || w.on(yesterday) && minutes < w.to {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (w window) on(day time.Weekday) bool {
+	return len(w.days) == 0 || w.days[day]
+}
@@ -0,0 +1,70 @@
+package session
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSession_Scheduled(t *testing.T) {
+	s := &Session{Config: &Configuration{}}
+	if !s.Scheduled(time.Now()) {
+		t.Error("Expected an active campaign without schedule")
+	}
+
+	c := &s.Config.Schedule
+	c.Enabled = true
+	c.Timezone = "UTC"
+	c.Start = "2026-11-02"
+	c.End = "2026-11-13T18:00:00Z"
+	c.Windows = []ScheduleWindow{
+		{Days: []string{"mon", "Tuesday", "wed", "thu", "fri"}, From: "08:00", To: "18:00"},
+		{Days: []string{"fri"}, From: "22:00", To: "02:00"},
+	}
+	if err := s.CheckSchedule(); err != nil {
+		t.Fatal(err)
+	}
+
+	for at, expected := range map[string]bool{
+		"2026-11-01T10:00:00Z": false, // before the start
+		"2026-11-02T07:59:00Z": false,
+		"2026-11-02T08:00:00Z": true,
+		"2026-11-02T17:59:00Z": true,
+		"2026-11-02T18:00:00Z": false,
+		"2026-11-06T23:00:00Z": true, // friday night
+		"2026-11-07T01:30:00Z": true, // on saturday, as the window started on friday
+		"2026-11-07T10:00:00Z": false,
+		"2026-11-13T17:00:00Z": true,
+		"2026-11-16T10:00:00Z": false, // after the end
+	} {
+		now, _ := time.Parse(time.RFC3339, at)
+		if got := s.Scheduled(now); got != expected {
+			t.Errorf("Expected %v at %s, got %v", expected, at, got)
+		}
+	}
+}
+
+func TestSession_CheckSchedule(t *testing.T) {
+	for expected, schedule := range map[string]func(*Session){
+		"schedule timezone": func(s *Session) { s.Config.Schedule.Timezone = "Mars/Olympus" },
+		"schedule start":    func(s *Session) { s.Config.Schedule.Start = "next monday" },
+		"is not after":      func(s *Session) { s.Config.Schedule.Start, s.Config.Schedule.End = "2026-11-02", "2026-11-01" },
+		"window day": func(s *Session) {
+			s.Config.Schedule.Windows = []ScheduleWindow{{Days: []string{"monkey"}, From: "08:00", To: "18:00"}}
+		},
+		"window end": func(s *Session) {
+			s.Config.Schedule.Windows = []ScheduleWindow{{From: "08:00", To: "6pm"}}
+		},
+		"is empty": func(s *Session) {
+			s.Config.Schedule.Windows = []ScheduleWindow{{From: "08:00", To: "08:00"}}
+		},
+	} {
+		s := &Session{Config: &Configuration{}}
+		s.Config.Schedule.Enabled = true
+		schedule(s)
+
+		if err := s.CheckSchedule(); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected an error with %q, got %v", expected, err)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package session
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pelletier/go-toml"
+
+	"github.com/muraenateam/muraena/core"
+)
+
+// undecodedKey extracts the keys from the strict decoding error
+var undecodedKey = regexp.MustCompile(`"((?:[^"\\]|\\.)*)"`)
+
+// decodeConfiguration decodes the TOML configuration, rejecting the keys not matching any setting:
+// typos would otherwise silently leave the setting to its default.
+// Syntax errors, type errors and unknown keys are reported with their line and column.
+func decodeConfiguration(content []byte, c *Configuration) error {
+	tree, err := toml.LoadBytes(content)
+	if err != nil {
+		return err
+	}
+
+	err = toml.NewDecoder(bytes.NewReader(content)).Strict(true).Decode(c)
+	if err == nil || !strings.HasPrefix(err.Error(), "undecoded keys: ") {
+		return err
+	}
+
+	var unknown []string
+	for _, match := range undecodedKey.FindAllStringSubmatch(err.Error(), -1) {
+		key, uerr := strconv.Unquote(`"` + match[1] + `"`)
+		if uerr != nil {
+			key = match[1]
+		}
+		unknown = append(unknown, fmt.Sprintf("%s: unknown key %s", keyPosition(tree, strings.Split(key, ".")), key))
+	}
+
+	return fmt.Errorf("%s", strings.Join(unknown, "\n"))
+}
+
+// keyPosition returns the position of the key, whose path includes the indexes of the arrays of tables
+func keyPosition(tree *toml.Tree, path []string) toml.Position {
+	for len(path) > 1 {
+		switch node := tree.GetPath(path[:1]).(type) {
+		case *toml.Tree:
+			tree, path = node, path[1:]
+		case []*toml.Tree:
+			i, err := strconv.Atoi(path[1])
+			if err != nil || i >= len(node) || len(path) < 3 {
+				return tree.GetPositionPath(path[:1])
+			}
+			tree, path = node[i], path[2:]
+		default:
+			return tree.GetPositionPath(path[:1])
+		}
+	}
+
+	return tree.GetPositionPath(path)
+}
+
+// CheckValues checks the settings accepting a fixed set of values, which would otherwise fall back to their default
+func (s *Session) CheckValues() (err error) {
+	c := s.Config
+
+	type setting struct {
+		name    string
+		value   string
+		allowed []string
+	}
+
+	values := []setting{
+		{"tracking.trace.landing.type", c.Tracking.Trace.Landing.Type, []string{"path", "query"}},
+		{"tracking.secrets.capture", c.Tracking.Secrets.Capture, []string{"value", "fact", "hash"}},
+		{"tracking.enrichment.failure", c.Tracking.Enrichment.Failure, []string{"allow", "block"}},
+		{"necrobrowser.trigger.type", c.Necrobrowser.Trigger.Type, []string{"cookies", "path"}},
+		{"storage.type", c.Storage.Type, []string{"redis", "postgres", "sqlite"}},
+		{"transform.response.cookie.sameSite", c.Transform.Response.Cookie.SameSite, []string{"strict", "lax", "none"}},
+		{"transform.response.security.hsts", c.Transform.Response.Security.HSTS, []string{"keep", "remove", "rewrite"}},
+		{"transform.serviceWorker.mode", c.Transform.ServiceWorker.Mode, []string{"rewrite", "unregister"}},
+		{"proxy.circuitBreaker.fallback", c.Proxy.CircuitBreaker.Fallback, []string{"maintenance", "decoy", "target"}},
+		{"privacy.ip", c.Privacy.IP, []string{"raw", "truncate", "hash"}},
+		{"proxy.unknownHost.action", c.Proxy.UnknownHost.Action, []string{"proxy", "reject", "redirect", "origin"}},
+		{"operator.mode", c.Operator.Mode, []string{"original", "annotate", "markers"}},
+		{"tap.network", c.Tap.Network, []string{"tcp", "unix"}},
+		{"tap.format", c.Tap.Format, []string{"har", "frame"}},
+		{"tap.stage", c.Tap.Stage, []string{"upstream", "victim"}},
+		{"email.tls", c.Email.TLS, []string{"starttls", "tls", "none"}},
+	}
+	for _, rule := range c.Clients {
+		values = append(values, setting{"clients.class", rule.Class, []string{"mobile", "tablet", "desktop"}},
+			setting{"clients.action", rule.Action, []string{"rewrite", "redirect", "decoy"}})
+	}
+	for _, sink := range c.Events.Sinks {
+		values = append(values, setting{"events.sinks.type", sink.Type, []string{"file", "redis", "kafka", "syslog"}},
+			setting{"events.sinks.transport", sink.Transport, []string{"tcp", "tls"}},
+			setting{"events.sinks.format", sink.Format, []string{"cef", "leef"}})
+	}
+
+	for _, v := range values {
+		if v.value != "" && !containsFold(v.allowed, v.value) {
+			return fmt.Errorf("Invalid %s %s: it must be one of %s", v.name, v.value, strings.Join(v.allowed, ", "))
+		}
+	}
+
+	return
+}
+
+func containsFold(values []string, s string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// PrintConfiguration writes the effective configuration, with the defaults applied.
+// The settings resolved from the environment or from a file are written as referenced, not to disclose the secrets.
+func (s *Session) PrintConfiguration(w io.Writer) error {
+	for _, ref := range s.references {
+		resolved := ref.value.String()
+		ref.value.SetString(ref.reference)
+		defer ref.value.SetString(resolved)
+	}
+
+	content, err := toml.Marshal(s.Config)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "# Effective configuration of %s v%s\n%s", core.Name, core.Version, content)
+	return err
+}
@@ -0,0 +1,108 @@
+package session
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestDecodeConfiguration_Sample(t *testing.T) {
+	for _, path := range []string{"../config/config.toml", "../core/proxy/testdata/golden/config.toml"} {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c := Configuration{}
+		if err := decodeConfiguration(content, &c); err != nil {
+			t.Errorf("%s: %s", path, err)
+		}
+	}
+}
+
+func TestDecodeConfiguration_UnknownKeys(t *testing.T) {
+	content := `
+[proxy]
+    phishing = "phishing.tld"
+    destination = "target.com"
+
+[tracking]
+    enable = true
+    trackRequestCookie = true
+
+    [[tracking.secrets.patterns]]
+    label = "Username"
+    start = "user="
+
+    [[tracking.secrets.patterns]]
+    label = "Password"
+    begin = "pass="
+`
+
+	c := Configuration{}
+	err := decodeConfiguration([]byte(content), &c)
+	if err == nil {
+		t.Fatal("Expected an error for the unknown keys")
+	}
+
+	for _, expected := range []string{
+		"(8, 5): unknown key tracking.trackRequestCookie",
+		"(16, 5): unknown key tracking.secrets.patterns.1.begin",
+	} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected %q in %q", expected, err)
+		}
+	}
+}
+
+func TestDecodeConfiguration_Errors(t *testing.T) {
+	for content, expected := range map[string]string{
+		"[proxy]\n    port = \"443\"\n": "(2, 5)",
+		"[proxy]\n    phishing = \n":    "(3, 1)",
+	} {
+		c := Configuration{}
+		err := decodeConfiguration([]byte(content), &c)
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected an error at %s, got %v", expected, err)
+		}
+	}
+}
+
+func TestSession_CheckValues(t *testing.T) {
+	s := &Session{Config: &Configuration{}}
+	s.Config.Tracking.Trace.Landing.Type = "Query"
+	s.Config.Transform.Response.Cookie.SameSite = "Lax"
+	if err := s.CheckValues(); err != nil {
+		t.Errorf("Unexpected error %s", err)
+	}
+
+	s.Config.Storage.Type = "mysql"
+	if err := s.CheckValues(); err == nil || !strings.Contains(err.Error(), "storage.type") {
+		t.Errorf("Expected an invalid storage.type, got %v", err)
+	}
+
+	s.Config.Storage.Type = ""
+	s.Config.Events.Sinks = []EventSink{{Type: "file"}, {Type: "syslog"}, {Type: "snmp"}}
+	if err := s.CheckValues(); err == nil || !strings.Contains(err.Error(), "events.sinks.type") {
+		t.Errorf("Expected an invalid events.sinks.type, got %v", err)
+	}
+}
+
+func TestSession_PrintConfiguration(t *testing.T) {
+	s := &Session{Config: &Configuration{}}
+	s.Config.Proxy.Phishing = "phishing.tld"
+
+	var b bytes.Buffer
+	if err := s.PrintConfiguration(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	c := Configuration{}
+	if err := decodeConfiguration(b.Bytes(), &c); err != nil {
+		t.Fatalf("The printed configuration does not decode: %s", err)
+	}
+	if c.Proxy.Phishing != "phishing.tld" {
+		t.Errorf("Expected phishing.tld, got %s", c.Proxy.Phishing)
+	}
+}
@@ -0,0 +1,110 @@
+package session
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// envReference matches the environment variables referenced by the configuration values, $${ being a literal ${
+var envReference = regexp.MustCompile(`\$\$\{|\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// fileReference prefixes the values read from a file, i.e. file:///run/secrets/redis
+const fileReference = "file://"
+
+// secretReference is a configuration value resolved from the environment or from a file
+type secretReference struct {
+	value     reflect.Value
+	reference string
+}
+
+// interpolate resolves the references to the environment variables and the files in the string settings,
+// so that the secrets do not have to be stored in the configuration file.
+// The transform section is left as is, its rules matching the content, i.e. JavaScript template literals.
+func interpolate(v reflect.Value, path string, refs *[]secretReference) error {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return interpolate(v.Elem(), path, refs)
+
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name := strings.Split(field.Tag.Get("toml"), ",")[0]
+			if field.PkgPath != "" || name == "-" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			if path != "" {
+				name = path + "." + name
+			}
+			if name == "transform" {
+				continue
+			}
+
+			if err := interpolate(v.Field(i), name, refs); err != nil {
+				return err
+			}
+		}
+
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := interpolate(v.Index(i), path+"."+strconv.Itoa(i), refs); err != nil {
+				return err
+			}
+		}
+
+	case reflect.String:
+		resolved, err := resolveReference(v.String(), path)
+		if err != nil {
+			return err
+		}
+		if resolved != v.String() && v.CanSet() {
+			*refs = append(*refs, secretReference{value: v, reference: v.String()})
+			v.SetString(resolved)
+		}
+	}
+
+	return nil
+}
+
+// resolveReference returns the content of the file:// value, or the value with its ${VAR} replaced
+func resolveReference(value, setting string) (string, error) {
+	if strings.HasPrefix(value, fileReference) {
+		path := strings.TrimPrefix(value, fileReference)
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("Error reading %s of %s: %s", path, setting, err)
+		}
+		return strings.TrimRight(string(content), "\r\n"), nil
+	}
+
+	if !strings.Contains(value, "${") {
+		return value, nil
+	}
+
+	var err error
+	resolved := envReference.ReplaceAllStringFunc(value, func(match string) string {
+		if match == "$${" {
+			return "${"
+		}
+
+		name := envReference.FindStringSubmatch(match)[1]
+		env, ok := os.LookupEnv(name)
+		if !ok && err == nil {
+			err = fmt.Errorf("Missing environment variable %s of %s", name, setting)
+		}
+		return env
+	})
+
+	return resolved, err
+}
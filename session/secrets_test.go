@@ -0,0 +1,74 @@
+package session
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestInterpolate(t *testing.T) {
+	secret := filepath.Join(t.TempDir(), "redis")
+	if err := ioutil.WriteFile(secret, []byte("s3cr3t\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("MURAENA_BOT_TOKEN", "123:abc")
+
+	c := Configuration{}
+	c.Redis.Password = "file://" + secret
+	c.Telegram.BotToken = "${MURAENA_BOT_TOKEN}"
+	c.Telegram.ChatIDs = []string{"chat-${MURAENA_BOT_TOKEN}", "$${literal}"}
+	c.Transform.Response.CustomContent = [][]string{{"${js}", "${js}"}}
+
+	var refs []secretReference
+	if err := interpolate(reflect.ValueOf(&c), "", &refs); err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Redis.Password != "s3cr3t" {
+		t.Errorf("Expected the file content, got %q", c.Redis.Password)
+	}
+	if c.Telegram.BotToken != "123:abc" {
+		t.Errorf("Expected the environment variable, got %q", c.Telegram.BotToken)
+	}
+	if c.Telegram.ChatIDs[0] != "chat-123:abc" || c.Telegram.ChatIDs[1] != "${literal}" {
+		t.Errorf("Unexpected chat IDs %q", c.Telegram.ChatIDs)
+	}
+	if c.Transform.Response.CustomContent[0][0] != "${js}" {
+		t.Errorf("Expected the transform rules unchanged, got %q", c.Transform.Response.CustomContent)
+	}
+	if len(refs) != 4 {
+		t.Errorf("Expected 4 references, got %d", len(refs))
+	}
+
+	// The secrets are printed as referenced
+	s := &Session{Config: &c, references: refs}
+	var b bytes.Buffer
+	if err := s.PrintConfiguration(&b); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(b.String(), "s3cr3t") || !strings.Contains(b.String(), "${MURAENA_BOT_TOKEN}") {
+		t.Errorf("Expected the references in the printed configuration:\n%s", b.String())
+	}
+	if c.Redis.Password != "s3cr3t" {
+		t.Errorf("Expected the resolved value restored, got %q", c.Redis.Password)
+	}
+}
+
+func TestInterpolate_Missing(t *testing.T) {
+	c := Configuration{}
+	c.Redis.Password = "${MURAENA_UNDEFINED_VARIABLE}"
+
+	var refs []secretReference
+	err := interpolate(reflect.ValueOf(&c), "", &refs)
+	if err == nil || !strings.Contains(err.Error(), "redis.password") {
+		t.Errorf("Expected a missing variable of redis.password, got %v", err)
+	}
+
+	c.Redis.Password = "file:///nonexistent/secret"
+	if err := interpolate(reflect.ValueOf(&c), "", &refs); err == nil {
+		t.Error("Expected an error reading the missing file")
+	}
+}
@@ -2,6 +2,7 @@ package session
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
 	"runtime"
 	"strings"
@@ -19,6 +20,17 @@ type Session struct {
 	Options core.Options
 	Config  *Configuration
 	Modules moduleList
+
+	// references are the settings resolved from the environment or from a file
+	references []secretReference
+
+	// schedule is the campaign schedule, nil if the campaign is always active
+	schedule *schedule
+
+	// trustedProxies are the proxies fronting the listener, nil if the clients connect directly
+	trustedProxies *TrustedProxies
+	// anonymizer masks the addresses of the clients, nil if they are kept as they are
+	anonymizer *IPAnonymizer
 }
 
 // New session
@@ -45,6 +57,40 @@ func New() (*Session, error) {
 		os.Exit(0)
 	}
 
+	if *s.Options.Keygen {
+		public, private, err := core.GenerateKeyPair()
+		if err != nil {
+			return nil, err
+		}
+		fmt.Printf("publicKey = \"%s\"\nprivateKey = \"%s\"\n", public, private)
+		os.Exit(0)
+	}
+
+	if *s.Options.Decrypt != "" {
+		key, err := ioutil.ReadFile(*s.Options.Decrypt)
+		if err != nil {
+			return nil, err
+		}
+		private, err := core.ParseKey(strings.TrimSpace(string(key)))
+		if err != nil {
+			return nil, err
+		}
+		if err := core.DecryptStream(os.Stdin, os.Stdout, private); err != nil {
+			return nil, err
+		}
+		os.Exit(0)
+	}
+
+	if *s.Options.PrintConfig {
+		if err := s.GetConfiguration(); err != nil {
+			return nil, err
+		}
+		if err := s.PrintConfiguration(os.Stdout); err != nil {
+			return nil, err
+		}
+		os.Exit(0)
+	}
+
 	log.Level = log.INFO
 	log.Format = "{datetime} {level:color}{level:name}{reset}: {message}"
 	if *s.Options.Debug == true {
@@ -62,14 +108,20 @@ func New() (*Session, error) {
 		return nil, err
 	}
 
-	// Load Redis only if tracking is enabled
-	if s.Config.Tracking.Enabled {
+	// Load Redis only if clustered, or if tracking is enabled and the data is stored in Redis
+	storage := strings.ToLower(s.Config.Storage.Type)
+	if s.Config.Cluster.Enabled || s.Config.Tracking.Enabled && (storage == "" || storage == "redis") {
 		if err = s.InitRedis(); err != nil {
 			log.Error("%s", err)
 			return nil, err
 		}
 	}
 
+	// Join the cluster
+	if s.Config.Cluster.Enabled {
+		go s.elect()
+	}
+
 	log.Info("Connected to Redis")
 
 	// Load prompt
@@ -0,0 +1,131 @@
+package session
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// UnixPrefix marks the addresses of Unix sockets, i.e. unix:/run/muraena/proxy.sock
+	UnixPrefix = "unix:"
+	// SystemdPrefix marks the sockets inherited from systemd socket activation, by name or index, i.e. systemd:https
+	SystemdPrefix = "systemd:"
+
+	// systemdFirstFD is the first file descriptor passed by systemd
+	systemdFirstFD = 3
+)
+
+// UnixSockets are the paths of the Unix sockets created, handed over to the user the privileges are dropped to
+var UnixSockets []string
+
+var activation struct {
+	once      sync.Once
+	listeners []net.Listener
+	names     []string
+	err       error
+}
+
+// IsSocketAddress tells if the address is a Unix or systemd socket, rather than a network one
+func IsSocketAddress(address string) bool {
+	return strings.HasPrefix(address, UnixPrefix) || strings.HasPrefix(address, SystemdPrefix)
+}
+
+// ListenSocket returns the listener of a Unix or systemd socket address, ok is false for the network addresses.
+// A stale Unix socket is replaced, and the new one is accessible by the group, i.e. the local front web server.
+func ListenSocket(address string) (ln net.Listener, ok bool, err error) {
+	switch {
+	case strings.HasPrefix(address, UnixPrefix):
+		path := strings.TrimPrefix(address, UnixPrefix)
+		if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+			_ = os.Remove(path)
+		}
+
+		if ln, err = net.Listen("unix", path); err != nil {
+			return nil, true, err
+		}
+		if err = os.Chmod(path, 0660); err != nil {
+			ln.Close()
+			return nil, true, err
+		}
+		UnixSockets = append(UnixSockets, path)
+		return ln, true, nil
+
+	case strings.HasPrefix(address, SystemdPrefix):
+		ln, err = systemdListener(strings.TrimPrefix(address, SystemdPrefix))
+		return ln, true, err
+	}
+
+	return nil, false, nil
+}
+
+// systemdListener returns the inherited socket with the name (FileDescriptorName) or the index
+func systemdListener(name string) (net.Listener, error) {
+	activation.once.Do(inheritSockets)
+	if activation.err != nil {
+		return nil, activation.err
+	}
+
+	index, err := strconv.Atoi(name)
+	if err != nil {
+		index = -1
+		for i, n := range activation.names {
+			if n == name {
+				index = i
+				break
+			}
+		}
+	}
+
+	if index < 0 || index >= len(activation.listeners) || activation.listeners[index] == nil {
+		return nil, fmt.Errorf("systemd socket %s not found among the %d inherited", name, len(activation.listeners))
+	}
+
+	// A socket is served by one listener only
+	ln := activation.listeners[index]
+	activation.listeners[index] = nil
+	return ln, nil
+}
+
+// inheritSockets collects the sockets passed by systemd, as described in sd_listen_fds(3)
+func inheritSockets() {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		activation.err = fmt.Errorf("no socket inherited from systemd")
+		return
+	}
+
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count <= 0 {
+		activation.err = fmt.Errorf("no socket inherited from systemd")
+		return
+	}
+
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	// The sockets must not be inherited by the processes spawned, i.e. during a binary upgrade
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	for i := 0; i < count; i++ {
+		name := ""
+		if i < len(names) {
+			name = names[i]
+		}
+
+		f := os.NewFile(uintptr(systemdFirstFD+i), name)
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			activation.err = fmt.Errorf("systemd socket %d: %w", i, err)
+			return
+		}
+
+		activation.listeners = append(activation.listeners, ln)
+		activation.names = append(activation.names, name)
+	}
+}
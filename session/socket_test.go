@@ -0,0 +1,37 @@
+package session
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+func TestListenSocket(t *testing.T) {
+	if _, ok, _ := ListenSocket("127.0.0.1:0"); ok {
+		t.Error("network addresses are not sockets")
+	}
+
+	path := filepath.Join(t.TempDir(), "muraena.sock")
+	ln, ok, err := ListenSocket(UnixPrefix + path)
+	if !ok || err != nil {
+		t.Fatalf("unexpected result %v %v", ok, err)
+	}
+
+	go func() {
+		if conn, err := ln.Accept(); err == nil {
+			conn.Close()
+		}
+	}()
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	ln.Close()
+
+	// Without systemd, no socket is inherited
+	if _, ok, err := ListenSocket(SystemdPrefix + "https"); !ok || err == nil {
+		t.Errorf("expected an error without inherited sockets, got %v", err)
+	}
+}
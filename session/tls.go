@@ -2,6 +2,16 @@ package session
 
 import (
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/pkcs12"
+
+	"github.com/muraenateam/muraena/core"
 )
 
 var tlsVersionToConst = map[string]uint16{
@@ -18,6 +28,10 @@ var tlsRenegotiationToConst = map[string]tls.RenegotiationSupport{
 	"FREELY": tls.RenegotiateFreelyAsClient,
 }
 
+// clientCertificates holds the loaded client certificates:
+// the default one (empty key) and the ones bound to specific origins.
+var clientCertificates = map[string]tls.Certificate{}
+
 func (s *Session) GetTLSClientConfig() *tls.Config {
 	cTLS := s.Config.TLS
 
@@ -32,3 +46,185 @@ func (s *Session) GetTLSClientConfig() *tls.Config {
 		Renegotiation:            tlsRenegotiationToConst[cTLS.RenegotiationSupport],
 	}
 }
+
+// GetUpstreamTLSConfig returns the TLS configuration used to connect to the given upstream host.
+// If a client certificate is configured for the host, or a default one is set, it is presented
+// when the origin requests mutual TLS.
+func (s *Session) GetUpstreamTLSConfig(host string) *tls.Config {
+	config := s.GetTLSClientConfig()
+	config.Certificates = nil
+
+	host = strings.ToLower(strings.Split(host, ":")[0])
+	if cert, ok := clientCertificates[host]; ok {
+		config.Certificates = []tls.Certificate{cert}
+	} else if cert, ok := clientCertificates[""]; ok {
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config
+}
+
+// CheckClientCertificates loads the client certificates used towards origins requiring mutual TLS.
+func (s *Session) CheckClientCertificates() (err error) {
+	client := s.Config.TLS.Client
+
+	clientCertificates = map[string]tls.Certificate{}
+	if client.Certificate != "" || client.PKCS12 != "" {
+		cert, err := loadClientCertificate(client.ClientCertificate)
+		if err != nil {
+			return err
+		}
+		clientCertificates[""] = cert
+	}
+
+	for _, origin := range client.Origins {
+		if origin.Hostname == "" {
+			return errors.New("Missing hostname for TLS client certificate")
+		}
+
+		cert, err := loadClientCertificate(origin)
+		if err != nil {
+			return err
+		}
+		clientCertificates[strings.ToLower(origin.Hostname)] = cert
+	}
+
+	return
+}
+
+// loadClientCertificate loads a client certificate from PEM files (or content) or a PKCS#12 bundle.
+func loadClientCertificate(c ClientCertificate) (cert tls.Certificate, err error) {
+	if c.PKCS12 != "" {
+		data, err := ioutil.ReadFile(c.PKCS12)
+		if err != nil {
+			return cert, errors.New(fmt.Sprintf("Error reading TLS client PKCS#12 %s: %s", c.PKCS12, err))
+		}
+
+		blocks, err := pkcs12.ToPEM(data, c.Password)
+		if err != nil {
+			return cert, errors.New(fmt.Sprintf("Error decoding TLS client PKCS#12 %s: %s", c.PKCS12, err))
+		}
+
+		var certPEM, keyPEM []byte
+		for _, b := range blocks {
+			if strings.Contains(b.Type, "PRIVATE KEY") {
+				keyPEM = append(keyPEM, pemEncode(b.Type, b.Bytes)...)
+			} else {
+				certPEM = append(certPEM, pemEncode(b.Type, b.Bytes)...)
+			}
+		}
+
+		return tls.X509KeyPair(certPEM, keyPEM)
+	}
+
+	certPEM, err := readPEM(c.Certificate)
+	if err != nil {
+		return cert, errors.New(fmt.Sprintf("Error reading TLS client certificate %s: %s", c.Certificate, err))
+	}
+
+	keyPEM, err := readPEM(c.Key)
+	if err != nil {
+		return cert, errors.New(fmt.Sprintf("Error reading TLS client key %s: %s", c.Key, err))
+	}
+
+	cert, err = tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return cert, errors.New(fmt.Sprintf("Error loading TLS client certificate %s: %s", c.Certificate, err))
+	}
+
+	if cert.Leaf == nil && len(cert.Certificate) > 0 {
+		cert.Leaf, _ = x509.ParseCertificate(cert.Certificate[0])
+	}
+
+	return
+}
+
+// readPEM returns the PEM content, either inline or read from the given file path.
+func readPEM(value string) ([]byte, error) {
+	if strings.HasPrefix(value, "-----BEGIN") {
+		return []byte(value), nil
+	}
+
+	return ioutil.ReadFile(value)
+}
+
+// pemEncode encodes a DER block to PEM
+func pemEncode(blockType string, data []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: data})
+}
+
+// GetTLSServerConfig returns the TLS configuration of the victim-facing listener.
+// Versions fall back to the global TLS settings when not defined in the listener section.
+func (s *Session) GetTLSServerConfig() *tls.Config {
+	cTLS := s.Config.TLS
+	listener := cTLS.Listener
+
+	config := s.GetTLSClientConfig()
+	config.MinVersion = tlsVersionToConst[listener.MinVersion]
+	config.MaxVersion = tlsVersionToConst[listener.MaxVersion]
+
+	for _, name := range listener.CipherSuites {
+		config.CipherSuites = append(config.CipherSuites, tlsCipherSuiteToConst(name))
+	}
+
+	if len(listener.ALPN) > 0 {
+		config.NextProtos = listener.ALPN
+	}
+
+	return config
+}
+
+// CheckTLSListener validates the TLS listener settings and loads the additional SNI certificates.
+func (s *Session) CheckTLSListener() (err error) {
+	listener := &s.Config.TLS.Listener
+
+	versions := []string{"SSL3.0", "TLS1.0", "TLS1.1", "TLS1.2", "TLS1.3"}
+	listener.MinVersion = strings.ToUpper(listener.MinVersion)
+	if !core.StringContains(listener.MinVersion, versions) {
+		listener.MinVersion = s.Config.TLS.MinVersion
+	}
+
+	listener.MaxVersion = strings.ToUpper(listener.MaxVersion)
+	if !core.StringContains(listener.MaxVersion, versions) {
+		listener.MaxVersion = s.Config.TLS.MaxVersion
+	}
+
+	for _, name := range listener.CipherSuites {
+		if tlsCipherSuiteToConst(name) == 0 {
+			return errors.New(fmt.Sprintf("Unknown TLS cipher suite %s", name))
+		}
+	}
+
+	for i, c := range listener.Certificates {
+		if len(c.Hostnames) == 0 {
+			return errors.New(fmt.Sprintf("Missing hostnames for TLS listener certificate %s", c.Certificate))
+		}
+
+		crt, err := readPEM(c.Certificate)
+		if err != nil {
+			return errors.New(fmt.Sprintf("Error reading TLS listener certificate %s: %s", c.Certificate, err))
+		}
+
+		k, err := readPEM(c.Key)
+		if err != nil {
+			return errors.New(fmt.Sprintf("Error reading TLS listener key %s: %s", c.Key, err))
+		}
+
+		listener.Certificates[i].CertificateContent = string(crt)
+		listener.Certificates[i].KeyContent = string(k)
+	}
+
+	return
+}
+
+// tlsCipherSuiteToConst returns the ID of the named cipher suite, or 0 if unknown.
+func tlsCipherSuiteToConst(name string) uint16 {
+	name = strings.ToUpper(strings.TrimSpace(name))
+	for _, c := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		if c.Name == name {
+			return c.ID
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,189 @@
+package session
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// The PKCS#12 fixtures of testdata/tls were generated with the password muraena, using the legacy encryption
+// supported by x/crypto/pkcs12:
+//   openssl pkcs12 -export -legacy -in cert.pem -inkey key.pem -out client.p12
+//   openssl pkcs12 -export -legacy -in cert.pem -nokeys -out nokey.p12
+
+// generateClientPEM returns a self-signed client certificate and its key, PEM encoded
+func generateClientPEM(t *testing.T) (certPEM, keyPEM []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "muraena client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+}
+
+func TestLoadClientCertificate_PEM(t *testing.T) {
+	certPEM, keyPEM := generateClientPEM(t)
+	otherCertPEM, _ := generateClientPEM(t)
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key")
+	if err := ioutil.WriteFile(certFile, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		name  string
+		cert  ClientCertificate
+		valid bool
+	}{
+		{"files", ClientCertificate{Certificate: certFile, Key: keyFile}, true},
+		{"content", ClientCertificate{Certificate: string(certPEM), Key: string(keyPEM)}, true},
+		{"missing key", ClientCertificate{Certificate: certFile, Key: filepath.Join(dir, "missing.key")}, false},
+		{"empty key", ClientCertificate{Certificate: certFile}, false},
+		{"mismatched key", ClientCertificate{Certificate: string(otherCertPEM), Key: keyFile}, false},
+		{"missing certificate", ClientCertificate{Certificate: filepath.Join(dir, "missing.crt"), Key: keyFile}, false},
+	} {
+		cert, err := loadClientCertificate(c.cert)
+		if (err == nil) != c.valid {
+			t.Errorf("%s: expected valid %v, got %v", c.name, c.valid, err)
+			continue
+		}
+		if c.valid && (cert.Leaf == nil || cert.Leaf.Subject.CommonName != "muraena client") {
+			t.Errorf("%s: expected the parsed leaf certificate", c.name)
+		}
+	}
+}
+
+func TestLoadClientCertificate_PKCS12(t *testing.T) {
+	for _, c := range []struct {
+		name  string
+		cert  ClientCertificate
+		valid bool
+	}{
+		{"valid", ClientCertificate{PKCS12: "testdata/tls/client.p12", Password: "muraena"}, true},
+		{"wrong password", ClientCertificate{PKCS12: "testdata/tls/client.p12", Password: "wrong"}, false},
+		{"missing key", ClientCertificate{PKCS12: "testdata/tls/nokey.p12", Password: "muraena"}, false},
+		{"missing file", ClientCertificate{PKCS12: "testdata/tls/missing.p12", Password: "muraena"}, false},
+	} {
+		cert, err := loadClientCertificate(c.cert)
+		if (err == nil) != c.valid {
+			t.Errorf("%s: expected valid %v, got %v", c.name, c.valid, err)
+			continue
+		}
+		if c.valid && len(cert.Certificate) != 1 {
+			t.Errorf("%s: expected one certificate, got %d", c.name, len(cert.Certificate))
+		}
+	}
+}
+
+func TestGetUpstreamTLSConfig(t *testing.T) {
+	certPEM, keyPEM := generateClientPEM(t)
+
+	s := &Session{Config: &Configuration{}}
+	s.Config.TLS.Client.Origins = []ClientCertificate{
+		{Hostname: "API.poor.victim", Certificate: string(certPEM), Key: string(keyPEM)},
+	}
+	if err := s.CheckClientCertificates(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { clientCertificates = map[string]tls.Certificate{} }()
+
+	if c := s.GetUpstreamTLSConfig("api.poor.victim:443"); len(c.Certificates) != 1 {
+		t.Error("expected the certificate of the origin")
+	}
+	if c := s.GetUpstreamTLSConfig("www.poor.victim"); len(c.Certificates) != 0 {
+		t.Error("expected no certificate without a default one")
+	}
+
+	s.Config.TLS.Client.Origins = []ClientCertificate{{Certificate: string(certPEM), Key: string(keyPEM)}}
+	if err := s.CheckClientCertificates(); err == nil {
+		t.Error("expected an error without the hostname of the origin")
+	}
+}
+
+func TestTLSCipherSuiteToConst(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		expected uint16
+	}{
+		{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+		{" tls_ecdhe_ecdsa_with_chacha20_poly1305_sha256 ", tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256},
+		{"TLS_AES_256_GCM_SHA384", tls.TLS_AES_256_GCM_SHA384},
+		// The insecure suites can be selected, i.e. to serve legacy clients
+		{"TLS_RSA_WITH_RC4_128_SHA", tls.TLS_RSA_WITH_RC4_128_SHA},
+		{"TLS_UNKNOWN", 0},
+		{"", 0},
+	} {
+		if id := tlsCipherSuiteToConst(c.name); id != c.expected {
+			t.Errorf("%q: expected %#04x, got %#04x", c.name, c.expected, id)
+		}
+	}
+}
+
+func TestCheckTLSListener(t *testing.T) {
+	for _, c := range []struct {
+		name               string
+		min, max           string
+		ciphers            []string
+		valid              bool
+		minConst, maxConst uint16
+	}{
+		{"listener versions", "tls1.2", "TLS1.3", nil, true, tls.VersionTLS12, tls.VersionTLS13},
+		{"global fallback", "", "TLS9.9", nil, true, tls.VersionTLS10, tls.VersionTLS12},
+		{"cipher suites", "TLS1.2", "", []string{"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384"}, true, tls.VersionTLS12, tls.VersionTLS12},
+		{"unknown cipher suite", "", "", []string{"TLS_UNKNOWN"}, false, 0, 0},
+	} {
+		s := &Session{Config: &Configuration{}}
+		s.Config.TLS.MinVersion, s.Config.TLS.MaxVersion = "TLS1.0", "TLS1.2"
+		s.Config.TLS.Listener.MinVersion = c.min
+		s.Config.TLS.Listener.MaxVersion = c.max
+		s.Config.TLS.Listener.CipherSuites = c.ciphers
+
+		err := s.CheckTLSListener()
+		if (err == nil) != c.valid {
+			t.Errorf("%s: expected valid %v, got %v", c.name, c.valid, err)
+			continue
+		}
+		if !c.valid {
+			continue
+		}
+
+		config := s.GetTLSServerConfig()
+		if config.MinVersion != c.minConst || config.MaxVersion != c.maxConst {
+			t.Errorf("%s: expected versions %#04x-%#04x, got %#04x-%#04x", c.name, c.minConst, c.maxConst,
+				config.MinVersion, config.MaxVersion)
+		}
+		if len(config.CipherSuites) != len(c.ciphers) {
+			t.Errorf("%s: expected %d cipher suites, got %v", c.name, len(c.ciphers), config.CipherSuites)
+		}
+	}
+}